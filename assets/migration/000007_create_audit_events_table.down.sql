@@ -0,0 +1,3 @@
+DROP TABLE IF EXISTS audit_events;
+
+DELETE FROM permissions WHERE code = 'audit:read';
\ No newline at end of file
@@ -0,0 +1,22 @@
+CREATE TABLE
+  IF NOT EXISTS audit_events (
+    id bigserial PRIMARY KEY,
+    created_at timestamp(0)
+    with
+      time zone NOT NULL DEFAULT NOW (),
+      category text NOT NULL,
+      action text NOT NULL,
+      user_id bigint,
+      target text NOT NULL DEFAULT '',
+      properties jsonb NOT NULL DEFAULT '{}'
+  );
+
+CREATE INDEX IF NOT EXISTS audit_events_category_created_at_idx ON audit_events (category, created_at);
+
+CREATE INDEX IF NOT EXISTS audit_events_created_at_idx ON audit_events (created_at);
+
+-- Add the permission required to export the audit log
+INSERT INTO
+  permissions (code)
+VALUES
+  ('audit:read');
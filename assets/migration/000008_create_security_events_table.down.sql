@@ -0,0 +1,3 @@
+DROP TABLE IF EXISTS security_events;
+
+DELETE FROM permissions WHERE code = 'security:read';
\ No newline at end of file
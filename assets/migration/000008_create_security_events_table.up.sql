@@ -0,0 +1,21 @@
+CREATE TABLE
+  IF NOT EXISTS security_events (
+    id bigserial PRIMARY KEY,
+    created_at timestamp(0)
+    with
+      time zone NOT NULL DEFAULT NOW (),
+      type text NOT NULL,
+      user_id bigint,
+      ip text NOT NULL DEFAULT '',
+      details jsonb NOT NULL DEFAULT '{}'
+  );
+
+CREATE INDEX IF NOT EXISTS security_events_type_idx ON security_events (type);
+
+CREATE INDEX IF NOT EXISTS security_events_user_id_idx ON security_events (user_id);
+
+-- Add the permission required to query the security events
+INSERT INTO
+  permissions (code)
+VALUES
+  ('security:read');
@@ -0,0 +1,26 @@
+CREATE TABLE
+  IF NOT EXISTS passkeys (
+    id bigserial PRIMARY KEY,
+    created_at timestamp(0)
+    with
+      time zone NOT NULL DEFAULT NOW (),
+      user_id bigint NOT NULL REFERENCES users ON DELETE CASCADE,
+      name text NOT NULL DEFAULT '',
+      credential_id bytea UNIQUE NOT NULL,
+      credential jsonb NOT NULL,
+      last_used_at timestamp(0)
+    with
+      time zone
+  );
+
+CREATE INDEX IF NOT EXISTS passkeys_user_id_idx ON passkeys (user_id);
+
+CREATE TABLE
+  IF NOT EXISTS webauthn_sessions (
+    hash bytea PRIMARY KEY,
+    user_id bigint REFERENCES users ON DELETE CASCADE,
+    data jsonb NOT NULL,
+    expiry timestamp(0)
+    with
+      time zone NOT NULL
+  );
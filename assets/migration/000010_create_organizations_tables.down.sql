@@ -0,0 +1,7 @@
+DROP TABLE IF EXISTS sso_states;
+
+DROP TABLE IF EXISTS organization_sso;
+
+DROP TABLE IF EXISTS organization_members;
+
+DROP TABLE IF EXISTS organizations;
@@ -0,0 +1,39 @@
+CREATE TABLE
+  IF NOT EXISTS organizations (
+    id bigserial PRIMARY KEY,
+    created_at timestamp(0)
+    with
+      time zone NOT NULL DEFAULT NOW (),
+      name text NOT NULL,
+      slug citext UNIQUE NOT NULL,
+      version integer NOT NULL DEFAULT 1
+  );
+
+CREATE TABLE
+  IF NOT EXISTS organization_members (
+    organization_id bigint NOT NULL REFERENCES organizations ON DELETE CASCADE,
+    user_id bigint NOT NULL REFERENCES users ON DELETE CASCADE,
+    role text NOT NULL,
+    PRIMARY KEY (organization_id, user_id)
+  );
+
+CREATE TABLE
+  IF NOT EXISTS organization_sso (
+    organization_id bigint PRIMARY KEY REFERENCES organizations ON DELETE CASCADE,
+    issuer text NOT NULL,
+    client_id text NOT NULL,
+    client_secret text NOT NULL,
+    role_claim text NOT NULL DEFAULT '',
+    role_mapping jsonb NOT NULL DEFAULT '{}',
+    default_role text NOT NULL
+  );
+
+CREATE TABLE
+  IF NOT EXISTS sso_states (
+    hash bytea PRIMARY KEY,
+    organization_id bigint NOT NULL REFERENCES organizations ON DELETE CASCADE,
+    nonce text NOT NULL,
+    expiry timestamp(0)
+    with
+      time zone NOT NULL
+  );
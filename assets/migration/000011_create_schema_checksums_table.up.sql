@@ -0,0 +1,8 @@
+CREATE TABLE
+  IF NOT EXISTS schema_checksums (
+    version bigint PRIMARY KEY,
+    checksum text NOT NULL,
+    recorded_at timestamp(0)
+    with
+      time zone NOT NULL DEFAULT NOW ()
+  );
@@ -0,0 +1,16 @@
+CREATE TABLE
+  IF NOT EXISTS reviews (
+    id bigserial PRIMARY KEY,
+    created_at timestamp(0)
+    with
+      time zone NOT NULL DEFAULT NOW (),
+      movie_id bigint NOT NULL REFERENCES movies ON DELETE CASCADE,
+      user_id bigint NOT NULL REFERENCES users ON DELETE CASCADE,
+      rating smallint NOT NULL CHECK (rating BETWEEN 1 AND 5),
+      body text NOT NULL DEFAULT '',
+      version integer NOT NULL DEFAULT 1,
+      -- each user can only review a movie once, they edit their review instead of posting another
+      CONSTRAINT reviews_movie_id_user_id_key UNIQUE (movie_id, user_id)
+  );
+
+CREATE INDEX IF NOT EXISTS reviews_user_id_idx ON reviews (user_id);
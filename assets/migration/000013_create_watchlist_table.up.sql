@@ -0,0 +1,9 @@
+CREATE TABLE
+  IF NOT EXISTS watchlist (
+    user_id bigint NOT NULL REFERENCES users ON DELETE CASCADE,
+    movie_id bigint NOT NULL REFERENCES movies ON DELETE CASCADE,
+    added_at timestamp(0)
+    with
+      time zone NOT NULL DEFAULT NOW (),
+      PRIMARY KEY (user_id, movie_id)
+  );
@@ -0,0 +1,27 @@
+CREATE TABLE
+  IF NOT EXISTS people (
+    id bigserial PRIMARY KEY,
+    created_at timestamp(0)
+    with
+      time zone NOT NULL DEFAULT NOW (),
+      name text NOT NULL,
+      biography text NOT NULL DEFAULT '',
+      birth_date date,
+      version integer NOT NULL DEFAULT 1
+  );
+
+CREATE INDEX IF NOT EXISTS people_name_idx ON people USING GIN (to_tsvector('simple', name));
+
+CREATE TABLE
+  IF NOT EXISTS movie_credits (
+    id bigserial PRIMARY KEY,
+    movie_id bigint NOT NULL REFERENCES movies ON DELETE CASCADE,
+    person_id bigint NOT NULL REFERENCES people ON DELETE CASCADE,
+    role text NOT NULL,
+    character text NOT NULL DEFAULT '',
+    billing_order integer NOT NULL DEFAULT 0,
+    -- a person can be credited more than once on a movie (e.g. director and writer), but not twice for the same character
+    CONSTRAINT movie_credits_movie_id_person_id_role_character_key UNIQUE (movie_id, person_id, role, character)
+  );
+
+CREATE INDEX IF NOT EXISTS movie_credits_person_id_idx ON movie_credits (person_id);
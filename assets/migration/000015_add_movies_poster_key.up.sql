@@ -0,0 +1,2 @@
+ALTER TABLE movies
+ADD COLUMN IF NOT EXISTS poster_key text NOT NULL DEFAULT '';
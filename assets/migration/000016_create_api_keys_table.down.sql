@@ -0,0 +1,3 @@
+DROP TABLE IF EXISTS api_keys;
+
+DELETE FROM permissions WHERE code = 'api_keys:admin';
\ No newline at end of file
@@ -0,0 +1,27 @@
+CREATE TABLE
+  IF NOT EXISTS api_keys (
+    id bigserial PRIMARY KEY,
+    created_at timestamp(0)
+    with
+      time zone NOT NULL DEFAULT NOW (),
+      user_id bigint NOT NULL REFERENCES users ON DELETE CASCADE,
+      name text NOT NULL,
+      -- the first characters of the key, so the owner can tell their keys apart without the key being stored
+      prefix text NOT NULL,
+      hash bytea NOT NULL UNIQUE,
+      permissions text[] NOT NULL,
+      expires_at timestamp(0)
+    with
+      time zone,
+      last_used_at timestamp(0)
+    with
+      time zone
+  );
+
+CREATE INDEX IF NOT EXISTS api_keys_user_id_idx ON api_keys (user_id);
+
+-- Add the permission required to mint and revoke API keys
+INSERT INTO
+  permissions (code)
+VALUES
+  ('api_keys:admin');
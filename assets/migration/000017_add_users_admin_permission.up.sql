@@ -0,0 +1,5 @@
+-- Add the permission required to manage the user accounts
+INSERT INTO
+  permissions (code)
+VALUES
+  ('users:admin');
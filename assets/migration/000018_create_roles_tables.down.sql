@@ -0,0 +1,3 @@
+DROP TABLE IF EXISTS roles_permissions;
+
+DROP TABLE IF EXISTS roles;
\ No newline at end of file
@@ -0,0 +1,16 @@
+CREATE TABLE
+  IF NOT EXISTS roles (
+    id bigserial PRIMARY KEY,
+    created_at timestamp(0)
+    with
+      time zone NOT NULL DEFAULT NOW (),
+      name text NOT NULL UNIQUE,
+      description text NOT NULL DEFAULT ''
+  );
+
+CREATE TABLE
+  IF NOT EXISTS roles_permissions (
+    role_id bigint NOT NULL REFERENCES roles ON DELETE CASCADE,
+    permission_id bigint NOT NULL REFERENCES permissions ON DELETE CASCADE,
+    PRIMARY KEY (role_id, permission_id)
+  );
@@ -0,0 +1 @@
+DROP INDEX IF EXISTS movies_created_at_id_idx;
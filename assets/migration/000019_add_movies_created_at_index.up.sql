@@ -0,0 +1 @@
+CREATE INDEX IF NOT EXISTS movies_created_at_id_idx ON movies (created_at, id);
@@ -0,0 +1,46 @@
+CREATE TABLE
+  IF NOT EXISTS webhooks (
+    id bigserial PRIMARY KEY,
+    created_at timestamp(0)
+    with
+      time zone NOT NULL DEFAULT NOW (),
+      url text NOT NULL,
+      events text[] NOT NULL,
+      -- the secret the payloads are signed with, it has to be kept in plaintext so the signatures can be computed
+      secret text NOT NULL
+  );
+
+CREATE TABLE
+  IF NOT EXISTS webhook_deliveries (
+    id bigserial PRIMARY KEY,
+    created_at timestamp(0)
+    with
+      time zone NOT NULL DEFAULT NOW (),
+      webhook_id bigint NOT NULL REFERENCES webhooks ON DELETE CASCADE,
+      event text NOT NULL,
+      payload jsonb NOT NULL,
+      -- pending until the delivery succeeds (delivered) or runs out of attempts (failed)
+      status text NOT NULL DEFAULT 'pending',
+      attempts integer NOT NULL DEFAULT 0,
+      next_attempt_at timestamp(0)
+    with
+      time zone NOT NULL DEFAULT NOW (),
+      response_status integer,
+      last_error text NOT NULL DEFAULT '',
+      delivered_at timestamp(0)
+    with
+      time zone
+  );
+
+CREATE INDEX IF NOT EXISTS webhook_deliveries_webhook_id_idx ON webhook_deliveries (webhook_id);
+
+-- the dispatcher only ever looks for the pending deliveries which are due
+CREATE INDEX IF NOT EXISTS webhook_deliveries_pending_idx ON webhook_deliveries (next_attempt_at)
+WHERE
+  status = 'pending';
+
+-- Add the permission required to manage the webhooks
+INSERT INTO
+  permissions (code)
+VALUES
+  ('webhooks:admin');
@@ -0,0 +1,5 @@
+-- Add the permission required to profile the running server through the pprof endpoints
+INSERT INTO
+  permissions (code)
+VALUES
+  ('debug:admin');
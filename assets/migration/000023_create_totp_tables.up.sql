@@ -0,0 +1,25 @@
+CREATE TABLE
+  IF NOT EXISTS user_totp (
+    user_id bigint PRIMARY KEY REFERENCES users ON DELETE CASCADE,
+    created_at timestamp(0)
+    with
+      time zone NOT NULL DEFAULT NOW (),
+      -- the shared secret the codes are generated from, it has to be kept in plaintext so the codes can be checked
+      secret text NOT NULL,
+      -- false until the user has proved their authenticator app works by entering a code
+      enabled boolean NOT NULL DEFAULT false,
+      -- the time step of the last accepted code, so a code can't be used twice
+      last_used_step bigint NOT NULL DEFAULT 0
+  );
+
+CREATE TABLE
+  IF NOT EXISTS totp_recovery_codes (
+    id bigserial PRIMARY KEY,
+    user_id bigint NOT NULL REFERENCES user_totp ON DELETE CASCADE,
+    hash bytea NOT NULL,
+    used_at timestamp(0)
+    with
+      time zone
+  );
+
+CREATE INDEX IF NOT EXISTS totp_recovery_codes_user_id_idx ON totp_recovery_codes (user_id);
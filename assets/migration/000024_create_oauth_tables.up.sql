@@ -0,0 +1,24 @@
+CREATE TABLE
+  IF NOT EXISTS oauth_identities (
+    provider text NOT NULL,
+    -- the provider's ID of the account, which unlike the email address never changes
+    subject text NOT NULL,
+    user_id bigint NOT NULL REFERENCES users ON DELETE CASCADE,
+    created_at timestamp(0)
+    with
+      time zone NOT NULL DEFAULT NOW (),
+      PRIMARY KEY (provider, subject)
+  );
+
+CREATE INDEX IF NOT EXISTS oauth_identities_user_id_idx ON oauth_identities (user_id);
+
+CREATE TABLE
+  IF NOT EXISTS oauth_states (
+    hash bytea PRIMARY KEY,
+    provider text NOT NULL,
+    -- the PKCE code verifier, which the code can only be exchanged with
+    verifier text NOT NULL,
+    expiry timestamp(0)
+    with
+      time zone NOT NULL
+  );
@@ -0,0 +1,8 @@
+ALTER TABLE tokens
+ADD COLUMN IF NOT EXISTS id bigserial UNIQUE,
+ADD COLUMN IF NOT EXISTS created_at timestamp(0) with time zone NOT NULL DEFAULT NOW (),
+ADD COLUMN IF NOT EXISTS ip_address text NOT NULL DEFAULT '',
+ADD COLUMN IF NOT EXISTS user_agent text NOT NULL DEFAULT '',
+ADD COLUMN IF NOT EXISTS last_used_at timestamp(0) with time zone;
+
+CREATE INDEX IF NOT EXISTS tokens_user_id_idx ON tokens (user_id);
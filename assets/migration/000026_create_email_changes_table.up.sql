@@ -0,0 +1,9 @@
+CREATE TABLE
+  IF NOT EXISTS email_changes (
+    user_id bigint PRIMARY KEY REFERENCES users ON DELETE CASCADE,
+    -- the new address, which only replaces the user's email once it has been confirmed with the token sent to it
+    email citext NOT NULL,
+    created_at timestamp(0)
+    with
+      time zone NOT NULL DEFAULT NOW ()
+  );
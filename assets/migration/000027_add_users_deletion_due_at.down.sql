@@ -0,0 +1,11 @@
+DELETE FROM reviews WHERE user_id IS NULL;
+
+ALTER TABLE reviews
+DROP CONSTRAINT IF EXISTS reviews_user_id_fkey,
+ADD CONSTRAINT reviews_user_id_fkey FOREIGN KEY (user_id) REFERENCES users ON DELETE CASCADE,
+ALTER COLUMN user_id SET NOT NULL;
+
+DROP INDEX IF EXISTS users_deletion_due_at_idx;
+
+ALTER TABLE users
+DROP COLUMN IF EXISTS deletion_due_at;
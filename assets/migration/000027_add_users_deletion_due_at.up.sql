@@ -0,0 +1,10 @@
+ALTER TABLE users
+ADD COLUMN IF NOT EXISTS deletion_due_at timestamp(0) with time zone;
+
+CREATE INDEX IF NOT EXISTS users_deletion_due_at_idx ON users (deletion_due_at) WHERE deletion_due_at IS NOT NULL;
+
+-- the reviews of a deleted user are kept, anonymized, rather than being deleted with the user
+ALTER TABLE reviews
+ALTER COLUMN user_id DROP NOT NULL,
+DROP CONSTRAINT IF EXISTS reviews_user_id_fkey,
+ADD CONSTRAINT reviews_user_id_fkey FOREIGN KEY (user_id) REFERENCES users ON DELETE SET NULL;
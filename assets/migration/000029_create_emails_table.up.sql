@@ -0,0 +1,28 @@
+CREATE TABLE
+  IF NOT EXISTS emails (
+    id bigserial PRIMARY KEY,
+    created_at timestamp(0)
+    with
+      time zone NOT NULL DEFAULT NOW (),
+      recipient text NOT NULL,
+      -- the template the email was rendered from, the rendered email is kept so it can be sent after a restart
+      template text NOT NULL,
+      subject text NOT NULL,
+      plain_body text NOT NULL,
+      html_body text NOT NULL,
+      -- pending until the email is sent (sent) or runs out of attempts (failed)
+      status text NOT NULL DEFAULT 'pending',
+      attempts integer NOT NULL DEFAULT 0,
+      next_attempt_at timestamp(0)
+    with
+      time zone NOT NULL DEFAULT NOW (),
+      last_error text NOT NULL DEFAULT '',
+      sent_at timestamp(0)
+    with
+      time zone
+  );
+
+-- the worker only ever looks for the pending emails which are due
+CREATE INDEX IF NOT EXISTS emails_pending_idx ON emails (next_attempt_at)
+WHERE
+  status = 'pending';
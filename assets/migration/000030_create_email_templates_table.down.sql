@@ -0,0 +1,3 @@
+DROP TABLE IF EXISTS email_templates;
+
+DELETE FROM permissions WHERE code = 'emails:admin';
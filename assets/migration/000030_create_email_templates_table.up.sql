@@ -0,0 +1,20 @@
+CREATE TABLE
+  IF NOT EXISTS email_templates (
+    id bigserial PRIMARY KEY,
+    created_at timestamp(0)
+    with
+      time zone NOT NULL DEFAULT NOW (),
+      -- the embedded template the row overrides, every change adds a new version and the latest one is used
+      name text NOT NULL,
+      version integer NOT NULL,
+      subject text NOT NULL,
+      plain_body text NOT NULL,
+      html_body text NOT NULL,
+      CONSTRAINT email_templates_name_version_key UNIQUE (name, version)
+  );
+
+-- Add the permission required to edit the email templates
+INSERT INTO
+  permissions (code)
+VALUES
+  ('emails:admin');
@@ -0,0 +1,3 @@
+-- the files attached to the email, with their content encoded as base64
+ALTER TABLE emails
+ADD COLUMN IF NOT EXISTS attachments jsonb NOT NULL DEFAULT '[]';
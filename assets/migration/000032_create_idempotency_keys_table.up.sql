@@ -0,0 +1,21 @@
+CREATE TABLE
+  IF NOT EXISTS idempotency_keys (
+    -- 0 for the requests made without signing in, such as registering a user
+    user_id bigint NOT NULL,
+    key text NOT NULL,
+    created_at timestamp(0)
+    with
+      time zone NOT NULL DEFAULT NOW (),
+      expires_at timestamp(0)
+    with
+      time zone NOT NULL,
+      -- the hash of the method, path and body of the first request, a retry has to be the same request
+      request_hash bytea NOT NULL,
+      -- 0 while the first request is being handled, then the response which is replayed for the retries
+      status integer NOT NULL DEFAULT 0,
+      headers jsonb NOT NULL DEFAULT '{}',
+      body bytea NOT NULL DEFAULT '',
+      PRIMARY KEY (user_id, key)
+  );
+
+CREATE INDEX IF NOT EXISTS idempotency_keys_expires_at_idx ON idempotency_keys (expires_at);
@@ -0,0 +1,6 @@
+DROP TABLE IF EXISTS api_key_usage;
+
+ALTER TABLE api_keys
+DROP COLUMN IF EXISTS tier;
+
+DROP TABLE IF EXISTS api_quota_tiers;
@@ -0,0 +1,31 @@
+CREATE TABLE
+  IF NOT EXISTS api_quota_tiers (
+    name text PRIMARY KEY,
+    -- NULL for no limit
+    daily_limit bigint,
+    monthly_limit bigint
+  );
+
+INSERT INTO
+  api_quota_tiers (name, daily_limit, monthly_limit)
+VALUES
+  ('free', 1000, 20000),
+  ('standard', 10000, 250000),
+  ('unlimited', NULL, NULL);
+
+ALTER TABLE api_keys
+ADD COLUMN IF NOT EXISTS tier text NOT NULL DEFAULT 'free' REFERENCES api_quota_tiers;
+
+-- the keys minted before the quotas were introduced are used by our own integrations, so they aren't limited
+UPDATE api_keys
+SET
+  tier = 'unlimited';
+
+CREATE TABLE
+  IF NOT EXISTS api_key_usage (
+    api_key_id bigint NOT NULL REFERENCES api_keys ON DELETE CASCADE,
+    -- the UTC day the requests were made on
+    day date NOT NULL,
+    requests bigint NOT NULL DEFAULT 0,
+    PRIMARY KEY (api_key_id, day)
+  );
@@ -0,0 +1,9 @@
+ALTER TABLE movies
+ADD COLUMN IF NOT EXISTS updated_at timestamp(0)
+with
+  time zone NOT NULL DEFAULT NOW ();
+
+-- the movies were last changed no earlier than they were added
+UPDATE movies
+SET
+  updated_at = created_at;
@@ -0,0 +1,12 @@
+CREATE TABLE
+  IF NOT EXISTS movie_views (
+    movie_id bigint NOT NULL REFERENCES movies ON DELETE CASCADE,
+    -- the start of the UTC hour the views were counted in
+    hour timestamp(0)
+    with
+      time zone NOT NULL,
+      views bigint NOT NULL DEFAULT 0,
+      PRIMARY KEY (movie_id, hour)
+  );
+
+CREATE INDEX IF NOT EXISTS movie_views_hour_idx ON movie_views (hour);
@@ -0,0 +1,3 @@
+ALTER TABLE users
+DROP COLUMN IF EXISTS favorites_updated_at;
+DROP TABLE IF EXISTS favorites;
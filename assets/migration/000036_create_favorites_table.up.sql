@@ -0,0 +1,16 @@
+CREATE TABLE
+  IF NOT EXISTS favorites (
+    user_id bigint NOT NULL REFERENCES users ON DELETE CASCADE,
+    movie_id bigint NOT NULL REFERENCES movies ON DELETE CASCADE,
+    favorited_at timestamp(0)
+    with
+      time zone NOT NULL DEFAULT NOW (),
+      PRIMARY KEY (user_id, movie_id)
+  );
+
+-- when the user last favorited or unfavorited a movie, which the Last-Modified time of their movie responses takes
+-- into account as they say whether each movie is a favorite
+ALTER TABLE users
+ADD COLUMN IF NOT EXISTS favorites_updated_at timestamp(0)
+with
+  time zone NOT NULL DEFAULT NOW ();
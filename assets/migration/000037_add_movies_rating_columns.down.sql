@@ -0,0 +1,4 @@
+DROP INDEX IF EXISTS movies_average_rating_idx;
+ALTER TABLE movies
+DROP COLUMN IF EXISTS average_rating,
+DROP COLUMN IF EXISTS review_count;
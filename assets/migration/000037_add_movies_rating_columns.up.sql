@@ -0,0 +1,24 @@
+ALTER TABLE movies
+ADD COLUMN IF NOT EXISTS average_rating double precision NOT NULL DEFAULT 0,
+ADD COLUMN IF NOT EXISTS review_count integer NOT NULL DEFAULT 0;
+
+-- the aggregates were computed from the reviews on every read until now
+UPDATE movies
+SET
+  average_rating = aggregates.average_rating,
+  review_count = aggregates.review_count
+FROM
+  (
+    SELECT
+      movie_id,
+      avg(rating)::double precision AS average_rating,
+      count(*) AS review_count
+    FROM
+      reviews
+    GROUP BY
+      movie_id
+  ) AS aggregates
+WHERE
+  movies.id = aggregates.movie_id;
+
+CREATE INDEX IF NOT EXISTS movies_average_rating_idx ON movies (average_rating, id);
@@ -0,0 +1,5 @@
+DROP TABLE IF EXISTS review_moderation_decisions;
+DROP TABLE IF EXISTS review_reports;
+ALTER TABLE reviews
+DROP COLUMN IF EXISTS hidden_at;
+DELETE FROM permissions WHERE code = 'reviews:moderate';
@@ -0,0 +1,46 @@
+-- a hidden review is left out of the listings and the rating of its movie, but kept for the moderators
+ALTER TABLE reviews
+ADD COLUMN IF NOT EXISTS hidden_at timestamp(0)
+with
+  time zone;
+
+CREATE TABLE
+  IF NOT EXISTS review_reports (
+    id bigserial PRIMARY KEY,
+    created_at timestamp(0)
+    with
+      time zone NOT NULL DEFAULT NOW (),
+      review_id bigint NOT NULL REFERENCES reviews ON DELETE CASCADE,
+      user_id bigint REFERENCES users ON DELETE SET NULL,
+      reason text NOT NULL,
+      -- set when a moderator makes a decision about the review
+      resolved_at timestamp(0)
+    with
+      time zone,
+      CONSTRAINT review_reports_review_id_user_id_key UNIQUE (review_id, user_id)
+  );
+
+-- the moderation queue is made of the reports which haven't been resolved
+CREATE INDEX IF NOT EXISTS review_reports_unresolved_idx ON review_reports (review_id)
+WHERE
+  resolved_at IS NULL;
+
+CREATE TABLE
+  IF NOT EXISTS review_moderation_decisions (
+    id bigserial PRIMARY KEY,
+    created_at timestamp(0)
+    with
+      time zone NOT NULL DEFAULT NOW (),
+      review_id bigint NOT NULL REFERENCES reviews ON DELETE CASCADE,
+      moderator_id bigint REFERENCES users ON DELETE SET NULL,
+      action text NOT NULL,
+      note text NOT NULL DEFAULT ''
+  );
+
+CREATE INDEX IF NOT EXISTS review_moderation_decisions_review_id_idx ON review_moderation_decisions (review_id);
+
+-- Add the permission required to moderate the reported reviews
+INSERT INTO
+  permissions (code)
+VALUES
+  ('reviews:moderate');
@@ -0,0 +1,26 @@
+CREATE TABLE
+  IF NOT EXISTS tags (
+    id bigserial PRIMARY KEY,
+    created_at timestamp(0)
+    with
+      time zone NOT NULL DEFAULT NOW (),
+      -- tags are normalized to lowercase words joined by hyphens, such as cult-classic
+      name text NOT NULL,
+      CONSTRAINT tags_name_key UNIQUE (name)
+  );
+
+CREATE TABLE
+  IF NOT EXISTS movie_tags (
+    movie_id bigint NOT NULL REFERENCES movies ON DELETE CASCADE,
+    tag_id bigint NOT NULL REFERENCES tags ON DELETE CASCADE,
+    -- the user who tagged the movie, who can remove the tag again
+    user_id bigint REFERENCES users ON DELETE SET NULL,
+    added_at timestamp(0)
+    with
+      time zone NOT NULL DEFAULT NOW (),
+      PRIMARY KEY (movie_id, tag_id)
+  );
+
+CREATE INDEX IF NOT EXISTS movie_tags_tag_id_idx ON movie_tags (tag_id);
+
+CREATE INDEX IF NOT EXISTS movie_tags_user_id_idx ON movie_tags (user_id);
@@ -0,0 +1,32 @@
+CREATE TABLE
+  IF NOT EXISTS lists (
+    id bigserial PRIMARY KEY,
+    created_at timestamp(0)
+    with
+      time zone NOT NULL DEFAULT NOW (),
+      updated_at timestamp(0)
+    with
+      time zone NOT NULL DEFAULT NOW (),
+      user_id bigint NOT NULL REFERENCES users ON DELETE CASCADE,
+      name text NOT NULL,
+      description text NOT NULL DEFAULT '',
+      -- a public list can be read by anyone with its URL, a private one only by its owner
+      public boolean NOT NULL DEFAULT false,
+      version integer NOT NULL DEFAULT 1
+  );
+
+CREATE INDEX IF NOT EXISTS lists_user_id_idx ON lists (user_id);
+
+CREATE TABLE
+  IF NOT EXISTS list_movies (
+    list_id bigint NOT NULL REFERENCES lists ON DELETE CASCADE,
+    movie_id bigint NOT NULL REFERENCES movies ON DELETE CASCADE,
+    -- the order the owner has put the movies in, new movies go at the end
+    position integer NOT NULL,
+    added_at timestamp(0)
+    with
+      time zone NOT NULL DEFAULT NOW (),
+      PRIMARY KEY (list_id, movie_id)
+  );
+
+CREATE INDEX IF NOT EXISTS list_movies_movie_id_idx ON list_movies (movie_id);
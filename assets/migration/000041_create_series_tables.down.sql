@@ -0,0 +1,2 @@
+DROP TABLE IF EXISTS series_movies;
+DROP TABLE IF EXISTS series;
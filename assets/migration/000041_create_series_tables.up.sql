@@ -0,0 +1,20 @@
+CREATE TABLE
+  IF NOT EXISTS series (
+    id bigserial PRIMARY KEY,
+    created_at timestamp(0)
+    with
+      time zone NOT NULL DEFAULT NOW (),
+      name text NOT NULL,
+      description text NOT NULL DEFAULT '',
+      version integer NOT NULL DEFAULT 1
+  );
+
+-- a movie is part of one series at most, so the movie ID alone is the key
+CREATE TABLE
+  IF NOT EXISTS series_movies (
+    movie_id bigint PRIMARY KEY REFERENCES movies ON DELETE CASCADE,
+    series_id bigint NOT NULL REFERENCES series ON DELETE CASCADE,
+    position integer NOT NULL
+  );
+
+CREATE INDEX IF NOT EXISTS series_movies_series_id_idx ON series_movies (series_id, position);
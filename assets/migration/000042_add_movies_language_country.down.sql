@@ -0,0 +1,7 @@
+DROP INDEX IF EXISTS movies_country_idx;
+
+DROP INDEX IF EXISTS movies_language_idx;
+
+ALTER TABLE movies
+DROP COLUMN IF EXISTS language,
+DROP COLUMN IF EXISTS country;
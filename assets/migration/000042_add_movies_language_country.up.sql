@@ -0,0 +1,9 @@
+-- the original language is an ISO 639-1 code such as 'ja', and the country an ISO 3166-1 alpha-2 code such as 'JP'.
+-- They are empty for the movies they aren't known for
+ALTER TABLE movies
+ADD COLUMN IF NOT EXISTS language text NOT NULL DEFAULT '',
+ADD COLUMN IF NOT EXISTS country text NOT NULL DEFAULT '';
+
+CREATE INDEX IF NOT EXISTS movies_language_idx ON movies (language);
+
+CREATE INDEX IF NOT EXISTS movies_country_idx ON movies (country);
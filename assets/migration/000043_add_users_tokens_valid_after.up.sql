@@ -0,0 +1,4 @@
+-- the signed authentication tokens issued before this time are refused, which signs the user out of every session
+-- made with one. It is NULL for the users who have never been signed out that way
+ALTER TABLE users
+ADD COLUMN IF NOT EXISTS tokens_valid_after timestamp(0) with time zone;
@@ -0,0 +1,4 @@
+-- a disabled user has been deprovisioned by an admin or their identity provider. Unlike an account which hasn't been
+-- activated, the user can't undo it themselves. It is NULL for the users who are enabled
+ALTER TABLE users
+ADD COLUMN IF NOT EXISTS disabled_at timestamp(0) with time zone;
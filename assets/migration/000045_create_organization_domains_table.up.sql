@@ -0,0 +1,7 @@
+CREATE TABLE
+  IF NOT EXISTS organization_domains (
+    domain citext PRIMARY KEY,
+    organization_id bigint NOT NULL REFERENCES organizations ON DELETE CASCADE
+  );
+
+CREATE INDEX IF NOT EXISTS organization_domains_organization_id_idx ON organization_domains (organization_id);
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/nytro04/greenlight/internal/data"
+)
+
+// deleteAccountHandler schedules the deletion of the authenticated user's account at the end of the grace period and
+// signs them out everywhere. They can sign in again and cancel the deletion until then, after which the account is
+// deleted by the account_deletions job. Their reviews are kept, without their name on them
+func (app *application) deleteAccountHandler(w http.ResponseWriter, r *http.Request) {
+	user := app.contextGetUser(r)
+
+	dueAt := app.clock.Now().Add(app.config.accounts.deletionGracePeriod).Truncate(time.Second)
+
+	err := app.models.Users.ScheduleDeletion(r.Context(), user.ID, dueAt)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	app.recordAudit(r, data.AuditCategoryAuth, "account_deletion_scheduled", user.Email)
+
+	app.notifyByEmail(r, user.Email, "account_deletion.go.tmpl", map[string]interface{}{
+		"dueAt": dueAt.UTC().Format(time.RFC1123),
+	})
+
+	env := envelope{
+		"message":         "your account will be deleted at the end of the grace period, sign in again and cancel the deletion before then to keep it",
+		"deletion_due_at": dueAt,
+	}
+
+	err = app.writeJSON(w, http.StatusAccepted, env, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// cancelAccountDeletionHandler keeps the authenticated user's account which was due to be deleted
+func (app *application) cancelAccountDeletionHandler(w http.ResponseWriter, r *http.Request) {
+	user := app.contextGetUser(r)
+
+	err := app.models.Users.CancelDeletion(r.Context(), user.ID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.errorResponse(w, r, http.StatusConflict, "your account isn't due to be deleted")
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	app.recordAudit(r, data.AuditCategoryAuth, "account_deletion_cancelled", user.Email)
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"message": "the deletion of your account has been cancelled"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// deleteDueAccounts permanently deletes the accounts whose deletion grace period has ended, along with their avatars.
+// This is run periodically by the scheduler
+func (app *application) deleteDueAccounts() error {
+	avatarKeys, deleted, err := app.models.Users.DeleteDue(context.Background())
+	if err != nil {
+		return err
+	}
+
+	// the accounts are already gone, so a failure only leaves an orphaned avatar behind and the other keys are still tried
+	for _, key := range avatarKeys {
+		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		err := app.storage.Delete(ctx, key)
+		cancel()
+		if err != nil {
+			app.logger.PrintError(err, "key", key)
+		}
+	}
+
+	if deleted > 0 {
+		app.logger.PrintInfo("accounts past their deletion grace period deleted", "deleted", deleted)
+	}
+
+	return nil
+}
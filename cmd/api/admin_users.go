@@ -0,0 +1,209 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/nytro04/greenlight/internal/data"
+	"github.com/nytro04/greenlight/internal/validator"
+)
+
+// listUsersHandler returns a page of the user accounts, optionally filtered by part of the email address and by
+// whether the account has been activated
+func (app *application) listUsersHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Email     string
+		Activated *bool
+		data.Filters
+	}
+
+	v := validator.New()
+
+	qs := r.URL.Query()
+
+	input.Email = app.readString(qs, "email", "")
+	input.Activated = app.readBool(qs, "activated", v)
+
+	input.Filters.Page = app.readInt(qs, "page", 1, v)
+	input.Filters.PageSize = app.readInt(qs, "page_size", 20, v)
+	input.Filters.Sort = app.readString(qs, "sort", "id")
+	input.Filters.SortSafeList = []string{"id", "created_at", "name", "email", "-id", "-created_at", "-name", "-email"}
+
+	if data.ValidateFilters(v, input.Filters); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	users, metadata, err := app.models.Users.GetAll(r.Context(), input.Email, input.Activated, input.Filters)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrUnsafeSort):
+			app.invalidSortResponse(w, r, input.Filters)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"users": users, "metadata": metadata}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// readUser reads the user ID from the URL and fetches the user, sending a 404 if they don't exist
+func (app *application) readUser(w http.ResponseWriter, r *http.Request) (*data.User, bool) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return nil, false
+	}
+
+	user, err := app.models.Users.GetByID(r.Context(), id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return nil, false
+	}
+
+	return user, true
+}
+
+func (app *application) showUserHandler(w http.ResponseWriter, r *http.Request) {
+	user, ok := app.readUser(w, r)
+	if !ok {
+		return
+	}
+
+	err := app.writeJSON(w, http.StatusOK, envelope{"user": user}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// updateUserHandler lets an admin change a user's name and email address, activate or deactivate their account, and
+// disable or enable it. A deactivated user can activate their account again themselves, so disabling is how a user is
+// locked out. Passwords can't be set here, they are only ever known to the user
+func (app *application) updateUserHandler(w http.ResponseWriter, r *http.Request) {
+	user, ok := app.readUser(w, r)
+	if !ok {
+		return
+	}
+
+	var input struct {
+		Name      *string `json:"name"`
+		Email     *string `json:"email"`
+		Activated *bool   `json:"activated"`
+		Disabled  *bool   `json:"disabled"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	if input.Name != nil {
+		user.Name = *input.Name
+	}
+	if input.Email != nil {
+		user.Email = *input.Email
+	}
+	if input.Activated != nil {
+		user.Activated = *input.Activated
+	}
+
+	v := validator.New()
+
+	if data.ValidateUser(v, user); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = app.models.Users.Update(r.Context(), user)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrDuplicateEmail):
+			v.AddError("email", "a user with this email address already exists")
+			app.failedValidationResponse(w, r, v.Errors)
+		case errors.Is(err, data.ErrEditConflict):
+			app.editConflictResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	// a deactivated user must not be able to carry on using the tokens they already had
+	if !user.Activated {
+		err = app.models.Tokens.DeleteAllForUser(r.Context(), data.ScopeAuthentication, user.ID)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+	}
+
+	app.recordAudit(r, data.AuditCategoryAuth, "user_updated", user.Email)
+
+	// disabling the user signs them out everywhere, their signed tokens included
+	if input.Disabled != nil && *input.Disabled != user.Disabled() {
+		if *input.Disabled {
+			err = app.models.Users.Disable(r.Context(), user)
+		} else {
+			err = app.models.Users.Enable(r.Context(), user)
+		}
+		if err != nil {
+			switch {
+			case errors.Is(err, data.ErrRecordNotFound):
+				app.notFoundResponse(w, r)
+			default:
+				app.serverErrorResponse(w, r, err)
+			}
+			return
+		}
+
+		if *input.Disabled {
+			app.recordAudit(r, data.AuditCategoryAuth, "user_disabled", user.Email)
+		} else {
+			app.recordAudit(r, data.AuditCategoryAuth, "user_enabled", user.Email)
+		}
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"user": user}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) deleteUserHandler(w http.ResponseWriter, r *http.Request) {
+	user, ok := app.readUser(w, r)
+	if !ok {
+		return
+	}
+
+	err := app.models.Users.Delete(r.Context(), user.ID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	if user.AvatarKey != "" {
+		app.deleteStoredObject(r, user.AvatarKey)
+	}
+
+	app.recordAudit(r, data.AuditCategoryAuth, "user_deleted", user.Email)
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"message": "user successfully deleted"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
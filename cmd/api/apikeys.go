@@ -0,0 +1,157 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/nytro04/greenlight/internal/data"
+	"github.com/nytro04/greenlight/internal/validator"
+)
+
+// createAPIKeyHandler mints an API key for a server-to-server client. The key belongs to a user, the admin
+// themselves unless another user_id is given, and is limited to the listed permissions and the requests its quota
+// tier allows, the free tier unless another is given. A request made with the key
+// needs the permission to be granted to both the key and its user, so revoking a permission from the user also
+// takes it away from their keys. The plaintext key is only included in this response
+func (app *application) createAPIKeyHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Name        string     `json:"name"`
+		UserID      int64      `json:"user_id"`
+		Permissions []string   `json:"permissions"`
+		Tier        string     `json:"tier"`
+		ExpiresAt   *time.Time `json:"expires_at"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	key := &data.APIKey{
+		UserID:      input.UserID,
+		Name:        input.Name,
+		Permissions: input.Permissions,
+		Tier:        input.Tier,
+		ExpiresAt:   input.ExpiresAt,
+	}
+
+	if key.Tier == "" {
+		key.Tier = data.DefaultQuotaTier
+	}
+
+	if key.UserID == 0 {
+		key.UserID = app.contextGetUser(r).ID
+	}
+
+	v := validator.New()
+
+	if data.ValidateAPIKey(v, key, app.clock.Now()); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = app.validatePermissionCodes(r.Context(), v, key.Permissions)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = app.models.APIKeys.Insert(key)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			v.AddError("user_id", "user does not exist")
+			app.failedValidationResponse(w, r, v.Errors)
+		case errors.Is(err, data.ErrUnknownQuotaTier):
+			v.AddError("tier", "quota tier does not exist")
+			app.failedValidationResponse(w, r, v.Errors)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	app.recordAudit(r, data.AuditCategoryAuth, "api_key_created", fmt.Sprintf("api_key:%d", key.ID))
+
+	err = app.writeJSON(w, http.StatusCreated, envelope{"api_key": key}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// listAPIKeysHandler returns the API keys, optionally only those of the user given by the user_id query string parameter
+func (app *application) listAPIKeysHandler(w http.ResponseWriter, r *http.Request) {
+	v := validator.New()
+
+	userID := app.readInt(r.URL.Query(), "user_id", 0, v)
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	keys, err := app.models.APIKeys.GetAll(int64(userID))
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"api_keys": keys}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// deleteAPIKeyHandler revokes an API key
+func (app *application) deleteAPIKeyHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	err = app.models.APIKeys.Delete(id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	app.recordAudit(r, data.AuditCategoryAuth, "api_key_revoked", fmt.Sprintf("api_key:%d", id))
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"message": "API key successfully revoked"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// showUsageHandler shows how much of the daily and monthly quotas the user's API keys have used. A request made with
+// an API key only sees the usage of that key
+func (app *application) showUsageHandler(w http.ResponseWriter, r *http.Request) {
+	var keyID int64
+	if key := app.contextGetAPIKey(r); key != nil {
+		keyID = key.ID
+	}
+
+	usage, err := app.models.Quotas.GetForUser(r.Context(), app.contextGetUser(r).ID, keyID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"usage": usage}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
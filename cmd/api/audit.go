@@ -0,0 +1,109 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/nytro04/greenlight/internal/data"
+	"github.com/nytro04/greenlight/internal/validator"
+	"github.com/tomasen/realip"
+)
+
+// recordAudit writes an entry to the audit log for the current request. The user is taken from the request context,
+// anonymous users are stored without a user ID. Failing to write the audit entry is logged but doesn't fail the request
+func (app *application) recordAudit(r *http.Request, category, action, target string) {
+	event := &data.AuditEvent{
+		Category: category,
+		Action:   action,
+		Target:   target,
+		Properties: map[string]string{
+			"ip":         realip.FromRequest(r),
+			"user_agent": r.UserAgent(),
+		},
+	}
+
+	// only set the user ID if the request is made by an authenticated user
+	user := app.contextGetUser(r)
+	if !user.IsAnonymous() {
+		event.UserID = &user.ID
+	}
+
+	err := app.models.Audit.Insert(event)
+	if err != nil {
+		app.logError(r, err)
+	}
+}
+
+// enforceAuditRetention deletes the audit events which are older than the retention period configured for their category.
+// This is run periodically by the scheduler
+func (app *application) enforceAuditRetention() error {
+	retention := map[string]time.Duration{
+		data.AuditCategoryAuth:    app.config.audit.authRetention,
+		data.AuditCategoryContent: app.config.audit.contentRetention,
+	}
+
+	for category, period := range retention {
+		deleted, err := app.models.Audit.DeleteExpired(category, app.clock.Now().Add(-period))
+		if err != nil {
+			return err
+		}
+
+		if deleted > 0 {
+			app.logger.PrintInfo("expired audit events deleted", "category", category, "deleted", deleted)
+		}
+	}
+
+	return nil
+}
+
+// exportAuditHandler streams all the audit events in the requested date range to the client as NDJSON (one JSON object per line).
+// The events are written as they are read from the database, so the response is never buffered in memory
+func (app *application) exportAuditHandler(w http.ResponseWriter, r *http.Request) {
+	v := validator.New()
+
+	qs := r.URL.Query()
+
+	// the from parameter is required, the to parameter defaults to the current time
+	from := app.readTime(qs, "from", time.Time{}, v)
+	to := app.readTime(qs, "to", app.clock.Now(), v)
+
+	v.Check(!from.IsZero(), "from", "must be provided")
+	v.Check(to.After(from), "to", "must be after from")
+
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	// record the export itself, so there is a trail of who archived the audit log
+	app.recordAudit(r, data.AuditCategoryAuth, "audit_exported", from.Format(time.RFC3339)+"/"+to.Format(time.RFC3339))
+
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	w.Header().Set("Content-Disposition", `attachment; filename="audit.ndjson"`)
+	w.WriteHeader(http.StatusOK)
+
+	enc := json.NewEncoder(w)
+	flusher, _ := w.(http.Flusher)
+
+	// flush the response every 100 events so the client starts receiving data straight away
+	count := 0
+	err := app.models.Audit.Export(r.Context(), from, to, func(event *data.AuditEvent) error {
+		err := enc.Encode(event)
+		if err != nil {
+			return err
+		}
+
+		count++
+		if flusher != nil && count%100 == 0 {
+			flusher.Flush()
+		}
+
+		return nil
+	})
+
+	// the status code has already been sent at this point, so all we can do is log the error
+	if err != nil {
+		app.logError(r, err)
+	}
+}
@@ -0,0 +1,183 @@
+package main
+
+import (
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"image"
+	_ "image/jpeg"
+	_ "image/png"
+	"io"
+	"mime"
+	"net/http"
+	"path"
+
+	"github.com/nytro04/greenlight/internal/data"
+	"github.com/nytro04/greenlight/internal/storage"
+	_ "golang.org/x/image/webp"
+)
+
+// maxAvatarBytes is the largest avatar image which can be uploaded (2MB)
+const maxAvatarBytes = 2 * 1_048_576
+
+// The smallest and largest width and height of an avatar in pixels
+const (
+	minAvatarDimension = 64
+	maxAvatarDimension = 2048
+)
+
+// uploadAvatarHandler stores the image in the "avatar" field of a multipart/form-data request as the authenticated user's
+// avatar, replacing any avatar they already had. The accepted image types are the same as for movie posters, and the
+// dimensions are read from the image header so an image which would be unreasonable to show next to a review is refused
+func (app *application) uploadAvatarHandler(w http.ResponseWriter, r *http.Request) {
+	user, err := app.models.Users.GetByID(r.Context(), app.contextGetUser(r).ID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	// leave some room on top of the image for the multipart boundaries and headers
+	r.Body = http.MaxBytesReader(w, r.Body, maxAvatarBytes+64*1024)
+
+	img, err := readImagePart(r, "avatar", maxAvatarBytes)
+	if err != nil {
+		var maxBytesError *http.MaxBytesError
+		switch {
+		case errors.As(err, &maxBytesError):
+			app.errorResponse(w, r, http.StatusRequestEntityTooLarge, fmt.Sprintf("the avatar must not be larger than %d bytes", maxAvatarBytes))
+		default:
+			app.badRequestResponse(w, r, err)
+		}
+		return
+	}
+
+	if len(img) > maxAvatarBytes {
+		app.errorResponse(w, r, http.StatusRequestEntityTooLarge, fmt.Sprintf("the avatar must not be larger than %d bytes", maxAvatarBytes))
+		return
+	}
+
+	contentType := http.DetectContentType(img)
+
+	extension, ok := posterExtensions[contentType]
+	if !ok {
+		app.failedValidationResponse(w, r, map[string]string{"avatar": "must be a JPEG, PNG or WebP image"})
+		return
+	}
+
+	cfg, _, err := image.DecodeConfig(bytes.NewReader(img))
+	if err != nil {
+		app.failedValidationResponse(w, r, map[string]string{"avatar": "must be a valid image"})
+		return
+	}
+
+	if cfg.Width < minAvatarDimension || cfg.Height < minAvatarDimension || cfg.Width > maxAvatarDimension || cfg.Height > maxAvatarDimension {
+		app.failedValidationResponse(w, r, map[string]string{"avatar": fmt.Sprintf("must be between %d and %d pixels wide and high", minAvatarDimension, maxAvatarDimension)})
+		return
+	}
+
+	// every upload gets a new key, so a cached copy of the old avatar is never served in place of the new one
+	suffix := make([]byte, 8)
+
+	_, err = io.ReadFull(app.random, suffix)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	oldKey := user.AvatarKey
+	user.AvatarKey = fmt.Sprintf("avatars/%d-%s%s", user.ID, hex.EncodeToString(suffix), extension)
+
+	err = app.storage.Put(r.Context(), user.AvatarKey, bytes.NewReader(img), int64(len(img)), contentType)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.models.Users.UpdateAvatar(r.Context(), user)
+	if err != nil {
+		// the user record still points at the old avatar, so the one just uploaded is removed again
+		app.deleteStoredObject(r, user.AvatarKey)
+
+		switch {
+		case errors.Is(err, data.ErrEditConflict):
+			app.editConflictResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	if oldKey != "" {
+		app.deleteStoredObject(r, oldKey)
+	}
+
+	app.recordAudit(r, data.AuditCategoryContent, "avatar_uploaded", user.Email)
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"user": user}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// showUserAvatarHandler sends a user's avatar. It needs the same permission as reading the reviews the avatar is shown
+// next to, and is served in the same way as a movie poster
+func (app *application) showUserAvatarHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	user, err := app.models.Users.GetByID(r.Context(), id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	if user.AvatarKey == "" {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	if signer, ok := app.storage.(storage.URLSigner); ok {
+		url, err := signer.SignedURL(user.AvatarKey, posterURLTTL)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+
+		http.Redirect(w, r, url, http.StatusFound)
+		return
+	}
+
+	avatar, err := app.storage.Open(r.Context(), user.AvatarKey)
+	if err != nil {
+		switch {
+		case errors.Is(err, storage.ErrNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+	defer avatar.Close()
+
+	w.Header().Set("Content-Type", mime.TypeByExtension(path.Ext(user.AvatarKey)))
+	w.Header().Set("Cache-Control", "public, max-age=3600")
+
+	_, err = io.Copy(w, avatar)
+	if err != nil {
+		app.logError(r, err)
+	}
+}
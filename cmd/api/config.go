@@ -0,0 +1,397 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/mail"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/nytro04/greenlight/internal/jsonlog"
+	"github.com/nytro04/greenlight/internal/jwtauth"
+	"github.com/nytro04/greenlight/internal/mailer"
+	pwhash "github.com/nytro04/greenlight/internal/password"
+	"github.com/nytro04/greenlight/internal/validator"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// envInt reads an integer environment variable used as a flag default. A value which isn't an integer is recorded as a
+// configuration problem against the flag it supplies, rather than silently falling back to zero
+func envInt(v *validator.Validator, key, flagName string) int {
+	s := os.Getenv(key)
+	if s == "" {
+		return 0
+	}
+
+	i, err := strconv.Atoi(s)
+	if err != nil {
+		v.AddError(configKey(flagName, key), "must be an integer value")
+		return 0
+	}
+
+	return i
+}
+
+// envString reads a string environment variable used as a flag default, falling back to the given default when it isn't set
+func envString(key, fallback string) string {
+	s := os.Getenv(key)
+	if s == "" {
+		return fallback
+	}
+
+	return s
+}
+
+// parseConfigDate parses a YYYY-MM-DD date flag, leaving the date unset when the value is empty
+func parseConfigDate(value string, dst *time.Time) error {
+	if value == "" {
+		*dst = time.Time{}
+		return nil
+	}
+
+	t, err := time.Parse(time.DateOnly, value)
+	if err != nil {
+		return errors.New("must be a date in the YYYY-MM-DD format")
+	}
+
+	*dst = t
+	return nil
+}
+
+// splitList splits a comma-separated flag or environment variable, trimming the spaces around the values and dropping
+// the empty ones
+func splitList(value string) []string {
+	var list []string
+
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			list = append(list, item)
+		}
+	}
+
+	return list
+}
+
+// configKey names a configuration value in the startup errors by the flag, and the environment variable if there is one,
+// which supplies it, so the operator knows exactly what to change
+func configKey(flagName, envKey string) string {
+	if envKey == "" {
+		return "-" + flagName
+	}
+	return "-" + flagName + " (" + envKey + ")"
+}
+
+// validateConfig checks the whole configuration after the flags have been parsed, adding every problem it finds to the validator
+func validateConfig(v *validator.Validator, cfg config) {
+	v.Check(cfg.port >= 1 && cfg.port <= 65535, configKey("port", "HTTP_PORT"), "must be between 1 and 65535")
+	v.Check(validator.In(cfg.env, "development", "staging", "production"), configKey("env", "environment"), "must be one of development, staging or production")
+
+	_, err := jsonlog.ParseLevel(cfg.logLevel)
+	v.Check(err == nil, configKey("log-level", "LOG_LEVEL"), "must be one of debug, info, warn or error")
+
+	adminPort, ok := listenPort(cfg.admin.addr)
+	if cfg.admin.addr != "" {
+		v.Check(ok, configKey("admin-addr", "ADMIN_ADDR"), "must be a host:port address")
+		v.Check(adminPort != cfg.port, configKey("admin-addr", "ADMIN_ADDR"), "must not use the same port as the API")
+	}
+
+	tlsEnabled := cfg.tls.certFile != "" || cfg.tls.keyFile != "" || len(cfg.tls.autocertDomains) > 0
+	v.Check(cfg.tls.certFile != "" || cfg.tls.keyFile == "", configKey("tls-cert", "TLS_CERT_FILE"), "must be provided when a TLS key is set")
+	v.Check(cfg.tls.keyFile != "" || cfg.tls.certFile == "", configKey("tls-key", "TLS_KEY_FILE"), "must be provided when a TLS certificate is set")
+	v.Check(cfg.tls.certFile == "" || len(cfg.tls.autocertDomains) == 0, configKey("tls-autocert-domains", "TLS_AUTOCERT_DOMAINS"), "must not be set along with a TLS certificate")
+
+	if len(cfg.tls.autocertDomains) > 0 {
+		for _, domain := range cfg.tls.autocertDomains {
+			v.Check(isDomain(domain), configKey("tls-autocert-domains", "TLS_AUTOCERT_DOMAINS"), fmt.Sprintf("%q is not a domain name", domain))
+		}
+		v.Check(cfg.tls.autocertCache != "", configKey("tls-autocert-cache", "TLS_AUTOCERT_CACHE"), "must be provided when autocert is enabled")
+	}
+
+	if cfg.tls.redirectAddr != "" {
+		redirectPort, ok := listenPort(cfg.tls.redirectAddr)
+
+		v.Check(tlsEnabled, configKey("tls-redirect-addr", "TLS_REDIRECT_ADDR"), "must only be set when TLS is enabled")
+		v.Check(ok, configKey("tls-redirect-addr", "TLS_REDIRECT_ADDR"), "must be a host:port address")
+		v.Check(redirectPort != cfg.port && (cfg.admin.addr == "" || redirectPort != adminPort), configKey("tls-redirect-addr", "TLS_REDIRECT_ADDR"), "must not use the same port as the API or the admin listener")
+	}
+
+	dsnKey := configKey("db-dsn", "DATABASE_URL")
+	if cfg.env == "development" {
+		dsnKey = configKey("db-dsn", "DB_USER, DB_PASSWORD, DB_HOST, DB_NAME")
+	}
+
+	switch {
+	case cfg.db.dsn == "":
+		v.AddError(dsnKey, "must be provided")
+	case strings.HasPrefix(cfg.db.dsn, "postgres://") || strings.HasPrefix(cfg.db.dsn, "postgresql://"):
+		_, err := pgxpool.ParseConfig(cfg.db.dsn)
+		v.Check(err == nil, dsnKey, "must be a valid PostgreSQL connection URL")
+	}
+
+	if cfg.db.replicaDSN != "" {
+		_, err := pgxpool.ParseConfig(cfg.db.replicaDSN)
+		v.Check(err == nil, configKey("db-replica-dsn", "DATABASE_REPLICA_URL"), "must be a valid PostgreSQL connection string")
+		v.Check(cfg.db.replicaDSN != cfg.db.dsn, configKey("db-replica-dsn", "DATABASE_REPLICA_URL"), "must not be the same as db-dsn")
+	}
+
+	v.Check(cfg.db.maxOpenConns > 0, configKey("db-max-open-conns", ""), "must be greater than zero")
+	v.Check(cfg.db.minConns >= 0, configKey("db-min-conns", ""), "must not be negative")
+	v.Check(cfg.db.minConns <= cfg.db.maxOpenConns, configKey("db-min-conns", ""), "must not be more than db-max-open-conns")
+	v.Check(isDuration(cfg.db.maxIdleTime), configKey("db-max-idle-time", ""), "must be a valid duration such as 15m")
+	v.Check(cfg.db.healthCheckPeriod > 0, configKey("db-health-check-period", ""), "must be greater than zero")
+	v.Check(cfg.db.readTimeout > 0, configKey("db-read-timeout", ""), "must be greater than zero")
+	v.Check(cfg.db.writeTimeout > 0, configKey("db-write-timeout", ""), "must be greater than zero")
+	v.Check(cfg.db.statementTimeout >= cfg.db.readTimeout && cfg.db.statementTimeout >= cfg.db.writeTimeout,
+		configKey("db-statement-timeout", ""), "must not be less than db-read-timeout or db-write-timeout")
+	v.Check(cfg.db.breakerThreshold >= 0, configKey("db-breaker-threshold", ""), "must not be negative")
+	v.Check(cfg.db.breakerThreshold == 0 || cfg.db.breakerCooldown > 0, configKey("db-breaker-cooldown", ""), "must be greater than zero")
+	v.Check(validator.In(cfg.db.schemaCheck, "strict", "warn"), configKey("db-schema-check", ""), "must be strict or warn")
+
+	if cfg.limiter.enabled {
+		v.Check(cfg.limiter.rps > 0, configKey("limiter-rps", ""), "must be greater than zero")
+		v.Check(cfg.limiter.burst > 0, configKey("limit-burst", ""), "must be greater than zero")
+	}
+
+	switch cfg.mail.Provider {
+	case mailer.ProviderSMTP:
+		if len(cfg.mail.SMTPHosts) > 0 {
+			v.Check(cfg.mail.SMTPPort >= 1 && cfg.mail.SMTPPort <= 65535, configKey("smtp-port", "SMTP_PORT"), "must be between 1 and 65535")
+			v.Check(cfg.mail.Sender != "", configKey("smtp-sender", "SMTP_SENDER"), "must be provided when an SMTP host is set")
+			v.Check(cfg.mail.SMTPFailureThreshold >= 1, configKey("smtp-failure-threshold", ""), "must be at least 1")
+			v.Check(cfg.mail.SMTPHostCooldown > 0, configKey("smtp-host-cooldown", ""), "must be greater than zero")
+
+			for _, host := range cfg.mail.SMTPHosts {
+				if _, port, err := net.SplitHostPort(host); err == nil {
+					p, err := strconv.Atoi(port)
+					v.Check(err == nil && p >= 1 && p <= 65535, configKey("smtp-host", "SMTP_HOST"), "must be a list of hosts with ports between 1 and 65535")
+				}
+			}
+		}
+	case mailer.ProviderSES:
+		v.Check(cfg.mail.SESRegion != "", configKey("ses-region", "SES_REGION"), "must be provided for the ses mail provider")
+		v.Check(cfg.mail.SESAccessKey != "", configKey("ses-access-key", "SES_ACCESS_KEY"), "must be provided for the ses mail provider")
+		v.Check(cfg.mail.SESSecretKey != "", configKey("ses-secret-key", "SES_SECRET_KEY"), "must be provided for the ses mail provider")
+	case mailer.ProviderSendGrid:
+		v.Check(cfg.mail.SendGridAPIKey != "", configKey("sendgrid-api-key", "SENDGRID_API_KEY"), "must be provided for the sendgrid mail provider")
+	case mailer.ProviderMailgun:
+		v.Check(isURL(cfg.mail.MailgunBaseURL, "https"), configKey("mailgun-base-url", "MAILGUN_BASE_URL"), "must be an absolute https URL")
+		v.Check(cfg.mail.MailgunDomain != "", configKey("mailgun-domain", "MAILGUN_DOMAIN"), "must be provided for the mailgun mail provider")
+		v.Check(cfg.mail.MailgunAPIKey != "", configKey("mailgun-api-key", "MAILGUN_API_KEY"), "must be provided for the mailgun mail provider")
+	case mailer.ProviderDev:
+		// the emails are only captured, nobody would receive the activation emails in production
+		v.Check(cfg.env != "production", configKey("mail-provider", "MAIL_PROVIDER"), "must not be dev in production")
+		v.Check(cfg.mail.DevDir != "", configKey("mail-dev-dir", "MAIL_DEV_DIR"), "must be provided for the dev mail provider")
+	default:
+		v.AddError(configKey("mail-provider", "MAIL_PROVIDER"), "must be one of smtp, ses, sendgrid, mailgun or dev")
+	}
+
+	// DKIM signing needs all three settings, and only applies to the emails sent over SMTP
+	if cfg.mail.DKIMDomain != "" || cfg.mail.DKIMSelector != "" || cfg.mail.DKIMKeyFile != "" {
+		v.Check(cfg.mail.Provider == mailer.ProviderSMTP, configKey("dkim-domain", "DKIM_DOMAIN"), "must only be set for the smtp mail provider")
+		v.Check(cfg.mail.DKIMDomain != "", configKey("dkim-domain", "DKIM_DOMAIN"), "must be provided when DKIM signing is configured")
+		v.Check(cfg.mail.DKIMSelector != "", configKey("dkim-selector", "DKIM_SELECTOR"), "must be provided when DKIM signing is configured")
+		v.Check(cfg.mail.DKIMKeyFile != "", configKey("dkim-private-key-file", "DKIM_PRIVATE_KEY_FILE"), "must be provided when DKIM signing is configured")
+	}
+
+	// the HTTP APIs need the address to send from, the SMTP server can do without when no host is set
+	if validator.In(cfg.mail.Provider, mailer.ProviderSES, mailer.ProviderSendGrid, mailer.ProviderMailgun) {
+		_, err := mail.ParseAddress(cfg.mail.Sender)
+		v.Check(err == nil, configKey("smtp-sender", "SMTP_SENDER"), "must be a valid email address such as \"Greenlight <no-reply@example.com>\"")
+	}
+
+	for _, origin := range cfg.cors.trustedOrigins {
+		v.Check(isURL(origin, "http", "https"), configKey("cors-trusted-origins", ""), "must be a list of absolute http or https origins")
+		v.Check(strings.Count(origin, "*") == 0 || strings.Count(origin, "*") == 1 && strings.Contains(origin, "://*."), configKey("cors-trusted-origins", ""), "may only use a wildcard as the first label of the host, such as https://*.example.com")
+	}
+	for _, method := range cfg.cors.allowedMethods {
+		v.Check(validator.In(method, http.MethodGet, http.MethodHead, http.MethodPost, http.MethodPut, http.MethodPatch, http.MethodDelete), configKey("cors-allowed-methods", ""), fmt.Sprintf("%q is not a supported method", method))
+	}
+	v.Check(cfg.cors.maxAge >= 0, configKey("cors-max-age", ""), "must not be negative")
+
+	v.Check(cfg.audit.authRetention > 0, configKey("audit-auth-retention", ""), "must be greater than zero")
+	v.Check(cfg.audit.contentRetention > 0, configKey("audit-content-retention", ""), "must be greater than zero")
+	v.Check(cfg.audit.retentionInterval > 0, configKey("audit-retention-interval", ""), "must be greater than zero")
+
+	v.Check(cfg.accounts.deletionGracePeriod > 0, configKey("account-deletion-grace-period", ""), "must be greater than zero")
+	v.Check(cfg.accounts.deletionInterval > 0, configKey("account-deletion-interval", ""), "must be greater than zero")
+	v.Check(cfg.tokens.cleanupInterval > 0, configKey("token-cleanup-interval", ""), "must be greater than zero")
+	v.Check(cfg.idempotency.ttl > 0, configKey("idempotency-key-ttl", ""), "must be greater than zero")
+	v.Check(cfg.idempotency.cleanupInterval > 0, configKey("idempotency-cleanup-interval", ""), "must be greater than zero")
+	v.Check(cfg.views.flushInterval > 0, configKey("views-flush-interval", ""), "must be greater than zero")
+	v.Check(cfg.views.retention >= time.Hour, configKey("views-retention", ""), "must be at least an hour")
+	v.Check(cfg.views.retention%time.Hour == 0, configKey("views-retention", ""), "must be a whole number of hours")
+	v.Check(cfg.views.cleanupInterval > 0, configKey("views-cleanup-interval", ""), "must be greater than zero")
+
+	if !cfg.versions.v1Sunset.IsZero() {
+		v.Check(!cfg.versions.v1Deprecation.IsZero(), configKey("v1-sunset-date", ""), "must only be set along with -v1-deprecation-date")
+		v.Check(cfg.versions.v1Sunset.After(cfg.versions.v1Deprecation), configKey("v1-sunset-date", ""), "must be after the deprecation date")
+	}
+
+	v.Check(cfg.password.minScore >= 0 && cfg.password.minScore <= 4, configKey("password-min-score", ""), "must be between 0 and 4")
+	v.Check(validator.In(cfg.password.hasher, pwhash.AlgorithmBcrypt, pwhash.AlgorithmArgon2id), configKey("password-hasher", "PASSWORD_HASHER"), "must be bcrypt or argon2id")
+	v.Check(cfg.password.bcryptCost >= bcrypt.MinCost && cfg.password.bcryptCost <= bcrypt.MaxCost, configKey("bcrypt-cost", ""), fmt.Sprintf("must be between %d and %d", bcrypt.MinCost, bcrypt.MaxCost))
+	v.Check(cfg.password.argon2Time >= 1, configKey("argon2-time", ""), "must be at least 1")
+	v.Check(cfg.password.argon2Threads >= 1 && cfg.password.argon2Threads <= 255, configKey("argon2-threads", ""), "must be between 1 and 255")
+	// argon2 needs at least 8KiB for every thread
+	v.Check(cfg.password.argon2Memory >= 8*cfg.password.argon2Threads && cfg.password.argon2Memory <= 4*1024*1024, configKey("argon2-memory", ""), "must be at least 8 KiB per thread and at most 4 GiB")
+
+	switch cfg.siem.forwarder {
+	case "", "none":
+	case "syslog":
+		v.Check(cfg.siem.address != "", configKey("siem-address", "SIEM_ADDRESS"), "must be provided for the syslog forwarder")
+	case "http":
+		v.Check(isURL(cfg.siem.address, "http", "https"), configKey("siem-address", "SIEM_ADDRESS"), "must be an http or https URL for the http forwarder")
+	default:
+		v.AddError(configKey("siem-forwarder", "SIEM_FORWARDER"), "must be one of none, syslog or http")
+	}
+
+	v.Check(validator.In(cfg.auth.tokenStrategy, "database", "jwt"), configKey("auth-token-strategy", "AUTH_TOKEN_STRATEGY"), "must be database or jwt")
+
+	if cfg.auth.tokenStrategy == "jwt" {
+		switch cfg.jwt.algorithm {
+		case jwtauth.AlgorithmHS256:
+			v.Check(len(cfg.jwt.secret) >= jwtauth.MinSecretLength, configKey("jwt-secret", "JWT_SECRET"), fmt.Sprintf("must be at least %d bytes long for HS256", jwtauth.MinSecretLength))
+		case jwtauth.AlgorithmEdDSA:
+			v.Check(cfg.jwt.privateKeyFile != "", configKey("jwt-private-key-file", "JWT_PRIVATE_KEY_FILE"), "must be provided for EdDSA")
+		default:
+			v.AddError(configKey("jwt-algorithm", "JWT_ALGORITHM"), "must be HS256 or EdDSA")
+		}
+		v.Check(cfg.jwt.issuer != "", configKey("jwt-issuer", ""), "must be provided")
+		v.Check(cfg.jwt.ttl > 0, configKey("jwt-ttl", ""), "must be greater than zero")
+	}
+
+	if cfg.webauthn.rpID != "" {
+		v.Check(len(cfg.webauthn.rpOrigins) > 0, configKey("webauthn-rp-origins", "WEBAUTHN_RP_ORIGINS"), "must be provided when a relying party ID is set")
+
+		for _, origin := range cfg.webauthn.rpOrigins {
+			v.Check(isURL(origin, "http", "https"), configKey("webauthn-rp-origins", "WEBAUTHN_RP_ORIGINS"), "must be a list of absolute http or https origins")
+		}
+	}
+
+	v.Check(cfg.oauth.googleClientID == "" || cfg.oauth.googleClientSecret != "", configKey("oauth-google-client-secret", "OAUTH_GOOGLE_CLIENT_SECRET"), "must be provided when a Google client ID is set")
+	v.Check(cfg.oauth.githubClientID == "" || cfg.oauth.githubClientSecret != "", configKey("oauth-github-client-secret", "OAUTH_GITHUB_CLIENT_SECRET"), "must be provided when a GitHub client ID is set")
+
+	switch cfg.storage.Backend {
+	case "local":
+		v.Check(cfg.storage.LocalDir != "", configKey("storage-local-dir", "STORAGE_LOCAL_DIR"), "must be provided for the local storage backend")
+	case "s3":
+		v.Check(isURL(cfg.storage.S3Endpoint, "http", "https"), configKey("storage-s3-endpoint", "STORAGE_S3_ENDPOINT"), "must be an http or https URL for the s3 storage backend")
+		v.Check(cfg.storage.S3Bucket != "", configKey("storage-s3-bucket", "STORAGE_S3_BUCKET"), "must be provided for the s3 storage backend")
+		v.Check(cfg.storage.S3AccessKey != "", configKey("storage-s3-access-key", "STORAGE_S3_ACCESS_KEY"), "must be provided for the s3 storage backend")
+		v.Check(cfg.storage.S3SecretKey != "", configKey("storage-s3-secret-key", "STORAGE_S3_SECRET_KEY"), "must be provided for the s3 storage backend")
+	default:
+		v.AddError(configKey("storage-backend", "STORAGE_BACKEND"), "must be one of local or s3")
+	}
+
+	switch cfg.cache.Backend {
+	case "none":
+	case "memory":
+		v.Check(cfg.cache.MaxEntries > 0, configKey("cache-max-entries", ""), "must be greater than zero for the memory cache backend")
+	case "redis":
+		v.Check(isURL(cfg.cache.RedisURL, "redis", "rediss"), configKey("cache-redis-url", "CACHE_REDIS_URL"), "must be a redis or rediss URL for the redis cache backend")
+	default:
+		v.AddError(configKey("cache-backend", "CACHE_BACKEND"), "must be one of none, memory or redis")
+	}
+
+	if cfg.cache.Backend != "none" {
+		v.Check(cfg.cache.TTL > 0, configKey("cache-ttl", ""), "must be greater than zero")
+	}
+
+	v.Check(isURL(cfg.sso.baseURL, "http", "https"), configKey("sso-base-url", "SSO_BASE_URL"), "must be an absolute http or https URL")
+
+	if cfg.tmdb.apiKey != "" {
+		v.Check(isURL(cfg.tmdb.baseURL, "http", "https"), configKey("tmdb-base-url", "TMDB_BASE_URL"), "must be an absolute http or https URL")
+	}
+
+	v.Check(cfg.mail.Timeout > 0, configKey("email-attempt-timeout", ""), "must be greater than zero")
+	v.Check(cfg.emails.retryBackoff > 0, configKey("email-retry-backoff", ""), "must be greater than zero")
+	v.Check(cfg.emails.retryInterval > 0, configKey("email-retry-interval", ""), "must be greater than zero")
+	v.Check(cfg.emails.maxAttempts >= 1 && cfg.emails.maxAttempts <= 20, configKey("email-max-attempts", ""), "must be between 1 and 20")
+
+	v.Check(cfg.activation.resendCooldown >= 0, configKey("activation-resend-cooldown", ""), "must not be negative")
+	v.Check(cfg.activation.resendDailyLimit >= 1, configKey("activation-resend-daily-limit", ""), "must be at least 1")
+
+	v.Check(cfg.webhooks.timeout > 0, configKey("webhook-timeout", ""), "must be greater than zero")
+	v.Check(cfg.webhooks.retryBackoff > 0, configKey("webhook-retry-backoff", ""), "must be greater than zero")
+	v.Check(cfg.webhooks.retryInterval > 0, configKey("webhook-retry-interval", ""), "must be greater than zero")
+	v.Check(cfg.webhooks.maxAttempts >= 1 && cfg.webhooks.maxAttempts <= 20, configKey("webhook-max-attempts", ""), "must be between 1 and 20")
+
+	if cfg.otel.endpoint != "" {
+		v.Check(isURL(cfg.otel.endpoint, "http", "https"), configKey("otel-endpoint", "OTEL_EXPORTER_OTLP_ENDPOINT"), "must be an absolute http or https URL")
+		v.Check(cfg.otel.serviceName != "", configKey("otel-service-name", "OTEL_SERVICE_NAME"), "must be provided when an OTLP endpoint is set")
+
+		_, err := parseHeaders(cfg.otel.headers)
+		v.Check(err == nil, configKey("otel-headers", "OTEL_EXPORTER_OTLP_HEADERS"), "must be a comma-separated list of key=value pairs")
+	}
+	v.Check(cfg.otel.sampleRatio >= 0 && cfg.otel.sampleRatio <= 1, configKey("otel-sample-ratio", ""), "must be between 0 and 1")
+
+	v.Check(cfg.totp.issuer != "" && !strings.Contains(cfg.totp.issuer, ":"), configKey("totp-issuer", ""), "must be provided and must not contain a colon")
+
+	if cfg.errtrack.dsn != "" {
+		v.Check(isURL(cfg.errtrack.dsn, "http", "https"), configKey("errtrack-dsn", "SENTRY_DSN"), "must be an absolute http or https URL")
+	}
+
+	v.Check(cfg.randomSeed == 0 || cfg.env != "production", configKey("random-seed", ""), "must not be used in production")
+}
+
+// parseHeaders parses a comma-separated list of key=value pairs, the format of OTEL_EXPORTER_OTLP_HEADERS. The values
+// may be URL encoded
+func parseHeaders(value string) (map[string]string, error) {
+	headers := make(map[string]string)
+
+	for _, pair := range strings.Split(value, ",") {
+		if strings.TrimSpace(pair) == "" {
+			continue
+		}
+
+		key, val, ok := strings.Cut(pair, "=")
+		key = strings.TrimSpace(key)
+		if !ok || key == "" {
+			return nil, fmt.Errorf("invalid header %q", pair)
+		}
+
+		val, err := url.QueryUnescape(strings.TrimSpace(val))
+		if err != nil {
+			return nil, err
+		}
+
+		headers[key] = val
+	}
+
+	return headers, nil
+}
+
+// listenPort returns the port of a host:port listen address, reporting whether the address is valid. The host may be
+// empty to listen on every interface
+func listenPort(addr string) (int, bool) {
+	_, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return 0, false
+	}
+
+	p, err := strconv.Atoi(port)
+	return p, err == nil && p >= 1 && p <= 65535
+}
+
+// isDomain reports whether the value looks like a bare domain name, without a scheme, port or path
+func isDomain(value string) bool {
+	return value != "" && !strings.ContainsAny(value, ":/ ") && strings.Contains(value, ".") && net.ParseIP(value) == nil
+}
+
+// isDuration reports whether the value can be parsed by time.ParseDuration
+func isDuration(value string) bool {
+	_, err := time.ParseDuration(value)
+	return err == nil
+}
+
+// isURL reports whether the value is an absolute URL with one of the given schemes
+func isURL(value string, schemes ...string) bool {
+	u, err := url.Parse(value)
+	return err == nil && u.Host != "" && validator.In(u.Scheme, schemes...)
+}
@@ -5,6 +5,7 @@ import (
 	"net/http"
 
 	"github.com/nytro04/greenlight/internal/data"
+	"github.com/nytro04/greenlight/internal/jsonlog"
 )
 
 // contextKey is a custom type that we will use as the key for storing request values in the request context.
@@ -15,9 +16,41 @@ type contextKey string
 // We will use this constant as the key when storing and retrieving the User info from the request context.
 const userContextKey = contextKey("user")
 
+// apiKeyContextKey stores the API key the request was authenticated with, if it wasn't authenticated with a token
+const apiKeyContextKey = contextKey("api_key")
+
+// apiKeyAllowedContextKey marks the requests to routes which API keys can be used on
+const apiKeyAllowedContextKey = contextKey("api_key_allowed")
+
+// graphqlRequestContextKey stores the HTTP request a GraphQL query was made in, in the context handed to the resolvers
+const graphqlRequestContextKey = contextKey("graphql_request")
+
+// metricsRouteContextKey stores the route pattern the request matched, for labelling its metrics
+const metricsRouteContextKey = contextKey("metrics_route")
+
+// accessLogContextKey stores the access log entry of the request, which records the authenticated user
+const accessLogContextKey = contextKey("access_log")
+
+// apiVersionContextKey stores the version of the API the request was routed to
+const apiVersionContextKey = contextKey("api_version")
+
+// requestIDContextKey stores the ID of the request, and loggerContextKey the logger which adds it to every log entry
+const (
+	requestIDContextKey = contextKey("request_id")
+	loggerContextKey    = contextKey("logger")
+)
+
 // Define a new contextSetUser helper. This returns a new copy of the request with the specified User struct added to the context.
 // note that we use our custom contextKey type as the key. This helps to prevent collisions with other data stored in the context.
 func (app *application) contextSetUser(r *http.Request, user *data.User) *http.Request {
+	// the access log middleware runs before the request is authenticated, so it is handed the user through its entry
+	if entry, ok := r.Context().Value(accessLogContextKey).(*accessLogEntry); ok {
+		entry.userID = 0
+		if !user.IsAnonymous() {
+			entry.userID = user.ID
+		}
+	}
+
 	ctx := context.WithValue(r.Context(), userContextKey, user)
 	return r.WithContext(ctx)
 }
@@ -33,3 +66,67 @@ func (app *application) contextGetUser(r *http.Request) *data.User {
 	}
 	return user
 }
+
+// contextSetAPIKey returns a new copy of the request with the API key it was authenticated with added to the context
+func (app *application) contextSetAPIKey(r *http.Request, key *data.APIKey) *http.Request {
+	ctx := context.WithValue(r.Context(), apiKeyContextKey, key)
+	return r.WithContext(ctx)
+}
+
+// contextGetAPIKey returns the API key the request was authenticated with, or nil if no API key was used
+func (app *application) contextGetAPIKey(r *http.Request) *data.APIKey {
+	key, _ := r.Context().Value(apiKeyContextKey).(*data.APIKey)
+	return key
+}
+
+// contextSetAPIKeyAllowed returns a new copy of the request marked as one to a route which API keys can be used on,
+// because its permission is checked by requirePermission or it goes through allowAPIKey
+func (app *application) contextSetAPIKeyAllowed(r *http.Request) *http.Request {
+	ctx := context.WithValue(r.Context(), apiKeyAllowedContextKey, true)
+	return r.WithContext(ctx)
+}
+
+// contextAPIKeyAllowed reports whether the request is to a route which API keys can be used on
+func (app *application) contextAPIKeyAllowed(r *http.Request) bool {
+	allowed, _ := r.Context().Value(apiKeyAllowedContextKey).(bool)
+	return allowed
+}
+
+// contextSetRequestID returns a new copy of the request with its ID, and a logger which includes the ID in every entry, added to the context
+func (app *application) contextSetRequestID(r *http.Request, id string) *http.Request {
+	ctx := context.WithValue(r.Context(), requestIDContextKey, id)
+	ctx = context.WithValue(ctx, loggerContextKey, app.logger.With("request_id", id))
+	return r.WithContext(ctx)
+}
+
+// contextGetRequestID returns the ID of the request, or an empty string if the request hasn't been through the requestID middleware
+func (app *application) contextGetRequestID(r *http.Request) string {
+	id, _ := r.Context().Value(requestIDContextKey).(string)
+	return id
+}
+
+// contextGetLogger returns the logger for the request, which includes the request ID in every entry. The application
+// logger is returned if the request hasn't been through the requestID middleware
+func (app *application) contextGetLogger(r *http.Request) *jsonlog.Logger {
+	logger, ok := r.Context().Value(loggerContextKey).(*jsonlog.Logger)
+	if !ok {
+		return app.logger
+	}
+	return logger
+}
+
+// contextSetAPIVersion returns a new copy of the request with the version of the API it was routed to added to the context
+func (app *application) contextSetAPIVersion(r *http.Request, version apiVersion) *http.Request {
+	ctx := context.WithValue(r.Context(), apiVersionContextKey, version)
+	return r.WithContext(ctx)
+}
+
+// contextGetAPIVersion returns the version of the API the request was routed to. The routes outside the versioned
+// groups, such as /metrics, are treated as v1
+func (app *application) contextGetAPIVersion(r *http.Request) apiVersion {
+	version, ok := r.Context().Value(apiVersionContextKey).(apiVersion)
+	if !ok {
+		return apiV1
+	}
+	return version
+}
@@ -0,0 +1,177 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/nytro04/greenlight/internal/data"
+	"github.com/nytro04/greenlight/internal/validator"
+)
+
+// requestEmailChangeHandler starts changing the user's email address. The new address is kept aside and a token is sent
+// to it, the user's email is only changed once the token has been confirmed, so an account can't be moved to an address
+// its owner doesn't control. The current password is required, so a stolen token isn't enough to take over the account
+func (app *application) requestEmailChangeHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Email    string `json:"email"`
+		Password string `json:"password"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+
+	data.ValidateEmail(v, input.Email)
+	v.Check(input.Password != "", "password", "must be provided")
+
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	// the user in the context doesn't hold the password hash when the request was made with a signed JWT
+	user, err := app.models.Users.GetByID(r.Context(), app.contextGetUser(r).ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	match, err := user.Password.Matches(input.Password)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	if !match {
+		app.recordSecurityEvent(r, data.SecurityEventLoginFailed, map[string]string{"email": user.Email, "reason": "wrong password on email change"})
+		v.AddError("password", "is incorrect")
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	_, err = app.models.Users.GetByEmail(r.Context(), input.Email)
+	switch {
+	case err == nil:
+		v.AddError("email", "a user with this email address already exists")
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	case !errors.Is(err, data.ErrRecordNotFound):
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	// the pending address, its token and the email with the token are saved together, so a token is never left
+	// without an email to deliver it
+	err = app.models.WithTx(r.Context(), func(tx data.Models) error {
+		err := tx.Users.SetPendingEmail(r.Context(), user.ID, input.Email)
+		if err != nil {
+			return err
+		}
+
+		// only the token sent for the latest request can confirm the change
+		err = tx.Tokens.DeleteAllForUser(r.Context(), data.ScopeEmailChange, user.ID)
+		if err != nil {
+			return err
+		}
+
+		token, err := tx.Tokens.New(r.Context(), user.ID, emailChangeTokenTTL, data.ScopeEmailChange)
+		if err != nil {
+			return err
+		}
+
+		return app.queueEmail(r.Context(), tx, input.Email, "email_change.go.tmpl", map[string]interface{}{
+			"emailChangeToken": token.Plaintext,
+		})
+	})
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	app.sendQueuedEmails(r)
+
+	env := envelope{"message": "an email will be sent to the new address containing the confirmation instructions"}
+
+	err = app.writeJSON(w, http.StatusAccepted, env, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// confirmEmailChangeHandler completes an email change with the token which was sent to the new address. The old
+// address is told about the change, so the owner finds out if it wasn't them who made it
+func (app *application) confirmEmailChangeHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		TokenPlaintext string `json:"token"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+
+	if data.ValidateTokenPlaintext(v, input.TokenPlaintext); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	user, err := app.models.Users.GetTokenUser(r.Context(), data.ScopeEmailChange, input.TokenPlaintext)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			v.AddError("token", "invalid or expired email change token")
+			app.failedValidationResponse(w, r, v.Errors)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	email, err := app.models.Users.GetPendingEmail(r.Context(), user.ID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			v.AddError("token", "invalid or expired email change token")
+			app.failedValidationResponse(w, r, v.Errors)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	oldEmail := user.Email
+
+	err = app.models.Users.ConfirmEmail(r.Context(), user, email)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrDuplicateEmail):
+			v.AddError("email", "a user with this email address already exists")
+			app.failedValidationResponse(w, r, v.Errors)
+		case errors.Is(err, data.ErrEditConflict):
+			app.editConflictResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	app.recordAudit(r, data.AuditCategoryAuth, "email_changed", oldEmail+" -> "+email)
+	app.recordSecurityEvent(r, data.SecurityEventEmailChanged, map[string]string{"user_id": strconv.FormatInt(user.ID, 10), "old_email": oldEmail, "new_email": email})
+
+	app.notifyByEmail(r, oldEmail, "email_changed.go.tmpl", map[string]interface{}{
+		"newEmail": email,
+	})
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"user": user}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
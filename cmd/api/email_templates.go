@@ -0,0 +1,299 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"text/template"
+	"time"
+
+	"github.com/julienschmidt/httprouter"
+	"github.com/nytro04/greenlight/internal/data"
+	"github.com/nytro04/greenlight/internal/mailer"
+	"github.com/nytro04/greenlight/internal/validator"
+)
+
+// emailTemplateSummary is an email template in the list, telling whether it has been edited by an admin
+type emailTemplateSummary struct {
+	Name      string     `json:"name"`
+	Source    string     `json:"source"` // database or embedded
+	Version   int32      `json:"version"`
+	UpdatedAt *time.Time `json:"updated_at"`
+}
+
+// readEmailTemplateName returns the name of the template in the URL, sending a 404 if there is no embedded template
+// with that name, as only the embedded templates can be overridden
+func (app *application) readEmailTemplateName(w http.ResponseWriter, r *http.Request) (string, bool) {
+	name := httprouter.ParamsFromContext(r.Context()).ByName("name")
+
+	if !validator.In(name, mailer.TemplateNames()...) {
+		app.notFoundResponse(w, r)
+		return "", false
+	}
+
+	return name, true
+}
+
+// currentEmailTemplate returns the latest version of the template edited by an admin, or the embedded template with
+// version 0 when it hasn't been edited
+func (app *application) currentEmailTemplate(r *http.Request, name string) (*data.EmailTemplate, error) {
+	tmpl, err := app.models.EmailTemplates.GetLatest(r.Context(), name)
+	if !errors.Is(err, data.ErrRecordNotFound) {
+		return tmpl, err
+	}
+
+	embedded, err := mailer.Embedded(name)
+	if err != nil {
+		return nil, err
+	}
+
+	return &data.EmailTemplate{Name: name, Subject: embedded.Subject, PlainBody: embedded.PlainBody, HTMLBody: embedded.HTMLBody}, nil
+}
+
+// listEmailTemplatesHandler lists every email template, with the version the emails are rendered with
+func (app *application) listEmailTemplatesHandler(w http.ResponseWriter, r *http.Request) {
+	overrides, err := app.models.EmailTemplates.GetAllLatest(r.Context())
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	latest := make(map[string]*data.EmailTemplate, len(overrides))
+	for _, tmpl := range overrides {
+		latest[tmpl.Name] = tmpl
+	}
+
+	templates := []emailTemplateSummary{}
+
+	for _, name := range mailer.TemplateNames() {
+		summary := emailTemplateSummary{Name: name, Source: "embedded"}
+
+		if tmpl, ok := latest[name]; ok {
+			summary.Source = "database"
+			summary.Version = tmpl.Version
+			summary.UpdatedAt = &tmpl.CreatedAt
+		}
+
+		templates = append(templates, summary)
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"email_templates": templates}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// showEmailTemplateHandler returns the template the emails are rendered with, along with every version saved by the
+// admins, newest first
+func (app *application) showEmailTemplateHandler(w http.ResponseWriter, r *http.Request) {
+	name, ok := app.readEmailTemplateName(w, r)
+	if !ok {
+		return
+	}
+
+	tmpl, err := app.currentEmailTemplate(r, name)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	versions, err := app.models.EmailTemplates.GetVersions(r.Context(), name)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"email_template": tmpl, "versions": versions}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// updateEmailTemplateHandler saves a new version of a template, which the emails queued from then on are rendered
+// with. The parts are checked to be valid templates, so a typo can't stop the emails from being sent
+func (app *application) updateEmailTemplateHandler(w http.ResponseWriter, r *http.Request) {
+	name, ok := app.readEmailTemplateName(w, r)
+	if !ok {
+		return
+	}
+
+	var input struct {
+		Subject   string `json:"subject"`
+		PlainBody string `json:"plain_body"`
+		HTMLBody  string `json:"html_body"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	tmpl := &data.EmailTemplate{
+		Name:      name,
+		Subject:   input.Subject,
+		PlainBody: input.PlainBody,
+		HTMLBody:  input.HTMLBody,
+	}
+
+	v := validator.New()
+
+	data.ValidateEmailTemplate(v, tmpl)
+
+	for key, text := range map[string]string{"subject": tmpl.Subject, "plain_body": tmpl.PlainBody, "html_body": tmpl.HTMLBody} {
+		if err := mailer.CheckSyntax(text); err != nil {
+			v.AddError(key, fmt.Sprintf("is not a valid template: %s", err))
+		}
+	}
+
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = app.models.EmailTemplates.Insert(r.Context(), tmpl)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrEditConflict):
+			app.editConflictResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	app.recordAudit(r, data.AuditCategoryContent, "email_template_updated", fmt.Sprintf("email_template:%s:%d", name, tmpl.Version))
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"email_template": tmpl}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// deleteEmailTemplateHandler removes every saved version of a template, so the emails are rendered with the embedded
+// template again
+func (app *application) deleteEmailTemplateHandler(w http.ResponseWriter, r *http.Request) {
+	name, ok := app.readEmailTemplateName(w, r)
+	if !ok {
+		return
+	}
+
+	err := app.models.EmailTemplates.Delete(r.Context(), name)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	app.recordAudit(r, data.AuditCategoryContent, "email_template_reset", "email_template:"+name)
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"message": "email template reset to the embedded template"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// emailPreview is an email rendered for an admin to check
+type emailPreview struct {
+	Subject   string `json:"subject"`
+	PlainBody string `json:"plain_body"`
+	HTMLBody  string `json:"html_body"`
+}
+
+// previewEmailHandler renders a template with the given dynamic data, in the same way as the emails which are queued,
+// so an admin can check a change to a template without going through the flow which sends it. When send_to is given
+// the email is also sent to that address straight away, rather than through the queue, so a failure is reported back
+func (app *application) previewEmailHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Template string         `json:"template"`
+		Data     map[string]any `json:"data"`
+		SendTo   string         `json:"send_to"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+
+	v.Check(validator.In(input.Template, mailer.TemplateNames()...), "template", "must be the name of an email template")
+
+	if input.SendTo != "" {
+		v.Check(validator.Matches(input.SendTo, validator.EmailRX), "send_to", "must be a valid email address")
+	}
+
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	message, err := app.renderEmail(r.Context(), app.models, mailer.TemplateFile(input.Template), input.Data)
+	if err != nil {
+		// the template failing while it runs is down to the data it was given
+		var execErr template.ExecError
+		switch {
+		case errors.As(err, &execErr):
+			v.AddError("data", fmt.Sprintf("could not be rendered with the template: %s", execErr.Err))
+			app.failedValidationResponse(w, r, v.Errors)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	env := envelope{"email": emailPreview{Subject: message.Subject, PlainBody: message.PlainBody, HTMLBody: message.HTMLBody}}
+
+	if input.SendTo != "" {
+		err = app.mailer.Deliver(r.Context(), input.SendTo, message)
+		if err != nil {
+			app.logError(r, err)
+			app.errorResponse(w, r, http.StatusBadGateway, fmt.Sprintf("the mail provider did not accept the email: %s", err))
+			return
+		}
+
+		app.recordAudit(r, data.AuditCategoryContent, "email_test_sent", fmt.Sprintf("email_template:%s -> %s", input.Template, input.SendTo))
+		env["sent_to"] = input.SendTo
+	}
+
+	err = app.writeJSON(w, http.StatusOK, env, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// listOutboxHandler lists the emails captured by the dev mail provider, newest first, so the activation and password
+// reset tokens can be picked up without a mail provider. The route is only registered for the dev provider
+func (app *application) listOutboxHandler(w http.ResponseWriter, r *http.Request) {
+	v := validator.New()
+
+	limit := app.readInt(r.URL.Query(), "limit", 20, v)
+
+	v.Check(limit >= 1 && limit <= 100, "limit", "must be between 1 and 100")
+
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	emails, err := app.mailer.Outbox(limit)
+	if err != nil {
+		switch {
+		case errors.Is(err, mailer.ErrNoOutbox):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"emails": emails}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
@@ -0,0 +1,272 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/vmihailenco/msgpack/v5"
+)
+
+// the response formats a client can ask for in the Accept header
+const (
+	formatJSON    = "application/json"
+	formatJSONAPI = "application/vnd.api+json"
+	formatXML     = "application/xml"
+	formatMsgPack = "application/msgpack"
+)
+
+// responseFormats maps the media types accepted in the Accept header to the response format they select, including
+// the older names still sent by some clients. The wildcards select the default format
+var responseFormats = map[string]string{
+	"application/json":         formatJSON,
+	"application/vnd.api+json": formatJSONAPI,
+	"application/*":            "",
+	"*/*":                      "",
+	"application/xml":          formatXML,
+	"text/xml":                 formatXML,
+	"application/msgpack":      formatMsgPack,
+	"application/x-msgpack":    formatMsgPack,
+	"application/vnd.msgpack":  formatMsgPack,
+}
+
+// negotiateFormat picks the response format from the Accept header of the request, preferring the media type with
+// the highest quality and, between equals, the one listed first. The fallback format is used when the header is
+// missing, only has wildcards or lists nothing the API can send, rather than refusing the request, as many clients
+// send a browser's Accept header
+func negotiateFormat(r *http.Request, fallback string) string {
+	format, best := "", 0.0
+
+	for _, candidate := range strings.Split(r.Header.Get("Accept"), ",") {
+		mediaType, params, err := mime.ParseMediaType(strings.TrimSpace(candidate))
+		if err != nil {
+			continue
+		}
+
+		f, ok := responseFormats[mediaType]
+		if !ok {
+			continue
+		}
+
+		q := 1.0
+		if value, ok := params["q"]; ok {
+			q, err = strconv.ParseFloat(value, 64)
+			if err != nil {
+				continue
+			}
+		}
+
+		if q > best {
+			format, best = f, q
+		}
+	}
+
+	if format == "" {
+		return fallback
+	}
+
+	return format
+}
+
+// responseFormat returns the format of the response to the request. JSON:API is used in place of plain JSON when the
+// client doesn't ask for a format if it has been made the default, so JSON:API client libraries work without setting
+// the Accept header, while the clients which ask for application/json still get it
+func (app *application) responseFormat(r *http.Request) string {
+	if app.config.jsonapi.defaultFormat {
+		return negotiateFormat(r, formatJSONAPI)
+	}
+
+	return negotiateFormat(r, formatJSON)
+}
+
+// writeResponse writes the data like writeJSON, in the format the client asked for in its Accept header. The JSON:API,
+// XML and MessagePack encodings are made from the JSON one, so the field names and values are the same in every format
+func (app *application) writeResponse(w http.ResponseWriter, r *http.Request, status int, data interface{}, headers http.Header) error {
+	format := app.responseFormat(r)
+	if format == formatJSON {
+		w.Header().Add("Vary", "Accept")
+		return app.writeJSON(w, status, data, headers)
+	}
+
+	js, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+
+	var body []byte
+
+	switch format {
+	case formatJSONAPI:
+		body, err = jsonAPIDocument(js, status)
+	case formatXML:
+		body, err = jsonToXML(js)
+	case formatMsgPack:
+		body, err = jsonToMsgPack(js)
+	}
+	if err != nil {
+		return err
+	}
+
+	for key, value := range headers {
+		w.Header()[key] = value
+	}
+
+	w.Header().Add("Vary", "Accept")
+	w.Header().Set("Content-Type", format)
+	w.WriteHeader(status)
+	w.Write(body)
+
+	return nil
+}
+
+// jsonToXML converts a JSON document to XML, inside a <response> element. Every object member becomes an element named
+// after its key, or an <entry key="..."> element when the key isn't a valid XML name, and the items of an array become
+// <item> elements. The members are kept in the order of the JSON
+func jsonToXML(js []byte) ([]byte, error) {
+	dec := json.NewDecoder(bytes.NewReader(js))
+	dec.UseNumber()
+
+	var buf bytes.Buffer
+	buf.WriteString(xml.Header)
+
+	enc := xml.NewEncoder(&buf)
+
+	err := writeXMLValue(enc, dec, "response")
+	if err != nil {
+		return nil, err
+	}
+
+	err = enc.Flush()
+	if err != nil {
+		return nil, err
+	}
+
+	buf.WriteByte('\n')
+
+	return buf.Bytes(), nil
+}
+
+// writeXMLValue reads the next JSON value from the decoder and writes it as an XML element with the given name
+func writeXMLValue(enc *xml.Encoder, dec *json.Decoder, name string) error {
+	token, err := dec.Token()
+	if err != nil {
+		return err
+	}
+
+	start := xml.StartElement{Name: xml.Name{Local: name}}
+	if !isXMLName(name) {
+		start = xml.StartElement{Name: xml.Name{Local: "entry"}, Attr: []xml.Attr{{Name: xml.Name{Local: "key"}, Value: name}}}
+	}
+
+	err = enc.EncodeToken(start)
+	if err != nil {
+		return err
+	}
+
+	switch token := token.(type) {
+	case json.Delim:
+		for dec.More() {
+			child := "item"
+
+			if token == '{' {
+				key, err := dec.Token()
+				if err != nil {
+					return err
+				}
+				child = key.(string)
+			}
+
+			err = writeXMLValue(enc, dec, child)
+			if err != nil {
+				return err
+			}
+		}
+
+		// the closing delimiter
+		_, err = dec.Token()
+		if err != nil {
+			return err
+		}
+	case nil:
+		// null is written as an empty element
+	default:
+		err = enc.EncodeToken(xml.CharData(fmt.Sprint(token)))
+		if err != nil {
+			return err
+		}
+	}
+
+	return enc.EncodeToken(start.End())
+}
+
+// isXMLName reports whether the JSON key can be used as an XML element name as it is
+func isXMLName(name string) bool {
+	if name == "" || strings.HasPrefix(strings.ToLower(name), "xml") {
+		return false
+	}
+
+	for i, c := range name {
+		switch {
+		case c == '_', c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z':
+		case i > 0 && (c == '-' || c == '.' || c >= '0' && c <= '9'):
+		default:
+			return false
+		}
+	}
+
+	return true
+}
+
+// jsonToMsgPack converts a JSON document to MessagePack. Whole numbers are encoded as integers and the rest as floats,
+// and the keys of the maps are sorted so the same data always gives the same bytes
+func jsonToMsgPack(js []byte) ([]byte, error) {
+	dec := json.NewDecoder(bytes.NewReader(js))
+	dec.UseNumber()
+
+	var value interface{}
+
+	err := dec.Decode(&value)
+	if err != nil {
+		return nil, err
+	}
+
+	var buf bytes.Buffer
+
+	enc := msgpack.NewEncoder(&buf)
+	enc.SetSortMapKeys(true)
+	enc.UseCompactInts(true)
+
+	err = enc.Encode(msgpackValue(value))
+	if err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
+
+// msgpackValue replaces the JSON numbers in a decoded JSON value with integers or floats
+func msgpackValue(value interface{}) interface{} {
+	switch value := value.(type) {
+	case json.Number:
+		if i, err := value.Int64(); err == nil {
+			return i
+		}
+		f, _ := value.Float64()
+		return f
+	case map[string]interface{}:
+		for key, v := range value {
+			value[key] = msgpackValue(v)
+		}
+	case []interface{}:
+		for i, v := range value {
+			value[i] = msgpackValue(v)
+		}
+	}
+
+	return value
+}
@@ -1,23 +1,34 @@
 package main
 
 import (
+	"errors"
 	"fmt"
+	"math"
 	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/nytro04/greenlight/internal/breaker"
+	"github.com/nytro04/greenlight/internal/data"
+	"github.com/nytro04/greenlight/internal/errtrack"
 )
 
 func (app *application) logError(r *http.Request, err error) {
-	app.logger.PrintError(err, map[string]string{
-		"request_method": r.Method,
-		"request_url":    r.URL.String(),
-	})
+	app.contextGetLogger(r).PrintError(err, "request_method", r.Method, "request_url", r.URL.String())
 }
 
 // errorResponse method sends a JSON response containing the error message to the client. The status code of the response is passed in the status parameter.
 // The message parameter can be a string, or it can be a map with the key "error" containing the error message.
+// The request ID is included, so a client reporting the error can tell us which log entries belong to it.
 func (app *application) errorResponse(w http.ResponseWriter, r *http.Request, status int, message interface{}) {
 	env := envelope{"error": message}
 
-	err := app.writeJSON(w, status, env, nil)
+	if id := app.contextGetRequestID(r); id != "" {
+		env["request_id"] = id
+	}
+
+	err := app.writeResponse(w, r, status, env, nil)
 	if err != nil {
 		app.logError(r, err)
 		w.WriteHeader(500)
@@ -25,13 +36,53 @@ func (app *application) errorResponse(w http.ResponseWriter, r *http.Request, st
 
 }
 
+// reportError sends an unexpected error to the error tracker, along with the request ID, the authenticated user and the
+// route of the request it happened in
+func (app *application) reportError(r *http.Request, err error, panicked bool) {
+	event := errtrack.Event{
+		Err:       err,
+		Panic:     panicked,
+		RequestID: app.contextGetRequestID(r),
+		Method:    r.Method,
+		URL:       r.URL.String(),
+	}
+
+	// the user and route are looked up without the context helpers, as the error may have happened before the
+	// request was authenticated or matched a route
+	if user, ok := r.Context().Value(userContextKey).(*data.User); ok && !user.IsAnonymous() {
+		event.UserID = user.ID
+	}
+	if route, ok := r.Context().Value(metricsRouteContextKey).(*metricsRoute); ok {
+		event.Route = route.pattern
+	}
+
+	app.errtrack.Report(event)
+}
+
 // serverErrorResponse method sends a 500 Internal Server Error response to the client when an unexpected condition is encountered by the server.
+// An error from the database's circuit breaker being open gets a 503 instead, since it's expected while the database is down.
 func (app *application) serverErrorResponse(w http.ResponseWriter, r *http.Request, err error) {
+	if errors.Is(err, breaker.ErrOpen) {
+		app.databaseUnavailableResponse(w, r)
+		return
+	}
+
 	app.logError(r, err)
-	message := "the server encountered a problem and could not process your request"
-	app.errorResponse(w, r, http.StatusInternalServerError, message)
+	app.reportError(r, err, false)
+	app.errorResponse(w, r, http.StatusInternalServerError, serverErrorMessage)
 }
 
+// panicResponse sends the same response as serverErrorResponse for a panic recovered by the recoverPanic middleware,
+// which is reported to the error tracker as a crash rather than an error
+func (app *application) panicResponse(w http.ResponseWriter, r *http.Request, err error) {
+	app.logError(r, err)
+	app.reportError(r, err, true)
+	app.errorResponse(w, r, http.StatusInternalServerError, serverErrorMessage)
+}
+
+// serverErrorMessage is the message of the 500 responses, the details of the error are only logged
+const serverErrorMessage = "the server encountered a problem and could not process your request"
+
 // notFoundResponse method sends a 404 Not Found response to the client when the client sends a request to an endpoint that does not exist.
 func (app *application) notFoundResponse(w http.ResponseWriter, r *http.Request) {
 	message := "the requested resource could not be found"
@@ -55,24 +106,91 @@ func (app *application) failedValidationResponse(w http.ResponseWriter, r *http.
 	app.errorResponse(w, r, http.StatusUnprocessableEntity, errors)
 }
 
+// invalidSortResponse method sends a 422 Unprocessable Entity response listing the allowed sort values when a model rejects the sort
+// value in the filters. The handlers validate the filters before querying the models, so this is a last line of defence.
+func (app *application) invalidSortResponse(w http.ResponseWriter, r *http.Request, filters data.Filters) {
+	app.failedValidationResponse(w, r, map[string]string{"sort": "must be one of: " + strings.Join(filters.SortSafeList, ", ")})
+}
+
 // invalidCredentialsResponse method sends a 401 Unauthorized response to the client when the client provides invalid authentication credentials.
 func (app *application) invalidCredentialsResponse(w http.ResponseWriter, r *http.Request) {
 	message := "invalid authentication credentials"
 	app.errorResponse(w, r, http.StatusUnauthorized, message)
 }
 
+// totpRequiredResponse sends a 401 Unauthorized response when the password was right but the account has
+// two-factor authentication enabled, and no code was sent along with it
+func (app *application) totpRequiredResponse(w http.ResponseWriter, r *http.Request) {
+	message := "a two-factor authentication code is required"
+	app.errorResponse(w, r, http.StatusUnauthorized, message)
+}
+
+// totpAlreadyEnabledResponse sends a 409 Conflict response when the user tries to enroll in two-factor
+// authentication again, it has to be turned off before a new secret can be generated
+func (app *application) totpAlreadyEnabledResponse(w http.ResponseWriter, r *http.Request) {
+	message := "two-factor authentication is already enabled"
+	app.errorResponse(w, r, http.StatusConflict, message)
+}
+
 // editConflictResponse method sends a 409 Conflict response to the client when an edit conflict is detected when trying to update a record in the database that has been modified since it was last fetched.
 func (app *application) editConflictResponse(w http.ResponseWriter, r *http.Request) {
 	message := "unable to update the record due to an edit conflict, please try again"
 	app.errorResponse(w, r, http.StatusConflict, message)
 }
 
-// rateLimitExceededResponse method sends a 429 Too Many Requests response to the client when the rate limit is exceeded for a particular route or IP address
-func (app *application) rateLimitExceededResponse(w http.ResponseWriter, r *http.Request) {
+// preconditionFailedResponse method sends a 412 Precondition Failed response to the client when the If-Match header of an update doesn't match the current version of the record.
+func (app *application) preconditionFailedResponse(w http.ResponseWriter, r *http.Request) {
+	message := "the record has been modified since it was fetched, fetch it again and retry the update"
+	app.errorResponse(w, r, http.StatusPreconditionFailed, message)
+}
+
+// databaseUnavailableResponse method sends a 503 Service Unavailable response to the client when the database's circuit breaker is open.
+// The Retry-After header tells the client when the database will next be tried.
+func (app *application) databaseUnavailableResponse(w http.ResponseWriter, r *http.Request) {
+	retryAfter := 10 * time.Second
+	if app.dbBreaker != nil {
+		retryAfter = app.dbBreaker.RetryAfter()
+	}
+
+	w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(retryAfter.Seconds()))))
+
+	message := "the database is temporarily unavailable, please try again later"
+	app.errorResponse(w, r, http.StatusServiceUnavailable, message)
+}
+
+// rateLimitExceededResponse method sends a 429 Too Many Requests response to the client when the rate limit is exceeded for a particular route or IP address.
+// The Retry-After header tells the client when its next request will be allowed
+func (app *application) rateLimitExceededResponse(w http.ResponseWriter, r *http.Request, retryAfter time.Duration) {
+	w.Header().Set("Retry-After", strconv.Itoa(max(int(math.Ceil(retryAfter.Seconds())), 1)))
+
 	message := "rate limit exceeded"
 	app.errorResponse(w, r, http.StatusTooManyRequests, message)
 }
 
+// activationThrottledResponse method sends a 429 Too Many Requests response to the client when an activation email has
+// been sent to the user too recently or too many times in the last day. The Retry-After header tells the client when
+// another one can be sent
+func (app *application) activationThrottledResponse(w http.ResponseWriter, r *http.Request, retryAfter time.Duration) {
+	w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(retryAfter.Seconds()))))
+
+	message := "an activation email was sent to this account recently, please wait before requesting another"
+	app.errorResponse(w, r, http.StatusTooManyRequests, message)
+}
+
+// quotaExceededResponse method sends a 429 Too Many Requests response to the client when its API key has used up the
+// requests its quota tier allows for the day or the month. The Retry-After header tells the client when the quota resets
+func (app *application) quotaExceededResponse(w http.ResponseWriter, r *http.Request, usage *data.APIKeyUsage) {
+	period, resetsAt := "daily", usage.Daily.ResetsAt
+	if usage.Monthly.Exceeded() {
+		period, resetsAt = "monthly", usage.Monthly.ResetsAt
+	}
+
+	w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(resetsAt.Sub(app.clock.Now()).Seconds()))))
+
+	message := fmt.Sprintf("the %s request quota of this API key's %s tier has been used up", period, usage.Tier)
+	app.errorResponse(w, r, http.StatusTooManyRequests, message)
+}
+
 // invalidAuthenticationTokenResponse method sends a 401 Unauthorized response to the client when the client provides an invalid or missing authentication token.
 func (app *application) invalidAuthenticationTokenResponse(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("WWW-Authenticate", "Bearer")
@@ -93,6 +211,13 @@ func (app *application) inactivateAccountResponse(w http.ResponseWriter, r *http
 	app.errorResponse(w, r, http.StatusForbidden, message)
 }
 
+// accountDisabledResponse sends a 403 Forbidden response when a disabled user tries to sign in or activate their
+// account. Only an admin or their identity provider can enable them again
+func (app *application) accountDisabledResponse(w http.ResponseWriter, r *http.Request) {
+	message := "your account has been disabled, contact your administrator"
+	app.errorResponse(w, r, http.StatusForbidden, message)
+}
+
 // notPermittedResponse method sends a 403 Forbidden response to the client when the client tries to access a protected route using an account that does not have the necessary permissions.
 func (app *application) notPermittedResponse(w http.ResponseWriter, r *http.Request) {
 	message := "your use account does not the necessary permissions to access this resource"
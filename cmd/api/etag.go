@@ -0,0 +1,135 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/nytro04/greenlight/internal/data"
+)
+
+// etagFor returns a strong ETag for the JSON representation of the data. It is a hash of the same encoding writeJSON
+// sends, so it changes whenever anything in the response does, including the parts which don't bump the record
+// version such as the review aggregates and embedded credits of a movie
+func etagFor(data interface{}) (string, error) {
+	js, err := json.Marshal(data)
+	if err != nil {
+		return "", err
+	}
+
+	sum := sha256.Sum256(js)
+
+	return `"` + hex.EncodeToString(sum[:16]) + `"`, nil
+}
+
+// etagMatches reports whether the entity tags listed in an If-None-Match or If-Match header value include the etag.
+// A "*" matches any etag, and weak tags are compared by their value
+func etagMatches(header, etag string) bool {
+	for _, candidate := range strings.Split(header, ",") {
+		candidate = strings.TrimSpace(candidate)
+
+		if candidate == "*" || strings.TrimPrefix(candidate, "W/") == etag {
+			return true
+		}
+	}
+
+	return false
+}
+
+// setLastModified sets the Last-Modified header to the latest updated_at time of the movies, or the time the movies
+// were flagged from, such as the time the user last changed their favorites, if that is later. It is left unset when
+// there are no movies. It isn't used for pages of movies, whose time wouldn't show a movie being deleted from the page
+func setLastModified(w http.ResponseWriter, flaggedAt time.Time, movies ...*data.Movie) {
+	if len(movies) == 0 {
+		return
+	}
+
+	lastModified := flaggedAt
+
+	for _, movie := range movies {
+		if movie.UpdatedAt.After(lastModified) {
+			lastModified = movie.UpdatedAt
+		}
+	}
+
+	if !lastModified.IsZero() {
+		w.Header().Set("Last-Modified", lastModified.UTC().Format(http.TimeFormat))
+	}
+}
+
+// notModifiedSince reports whether the Last-Modified time already set on the response is no later than the
+// If-Modified-Since time of the request. It is false when either is missing or can't be parsed
+func notModifiedSince(w http.ResponseWriter, r *http.Request) bool {
+	lastModified, err := http.ParseTime(w.Header().Get("Last-Modified"))
+	if err != nil {
+		return false
+	}
+
+	ifModifiedSince, err := http.ParseTime(r.Header.Get("If-Modified-Since"))
+	if err != nil {
+		return false
+	}
+
+	return !lastModified.After(ifModifiedSince)
+}
+
+// writeResponseWithETag writes the response like writeResponse, with an ETag header computed from the data. A GET or
+// HEAD request whose If-None-Match header matches the ETag gets an empty 304 Not Modified response instead. The ETag is
+// computed from the JSON whatever the format of the response, so it is weak for the other formats, as their bytes differ.
+// When the handler has set a Last-Modified header, a request without If-None-Match gets the 304 if its If-Modified-Since
+// time is no earlier, as If-Modified-Since is only used when If-None-Match isn't sent
+func (app *application) writeResponseWithETag(w http.ResponseWriter, r *http.Request, status int, data interface{}, headers http.Header) error {
+	etag, err := etagFor(data)
+	if err != nil {
+		return err
+	}
+
+	if app.responseFormat(r) != formatJSON {
+		etag = "W/" + etag
+	}
+
+	w.Header().Set("ETag", etag)
+
+	if r.Method == http.MethodGet || r.Method == http.MethodHead {
+		ifNoneMatch := r.Header.Get("If-None-Match")
+
+		notModified := ifNoneMatch != "" && etagMatches(ifNoneMatch, strings.TrimPrefix(etag, "W/"))
+		if ifNoneMatch == "" {
+			notModified = notModifiedSince(w, r)
+		}
+
+		if notModified {
+			w.Header().Add("Vary", "Accept")
+			w.WriteHeader(http.StatusNotModified)
+			return nil
+		}
+	}
+
+	return app.writeResponse(w, r, status, data, headers)
+}
+
+// checkIfMatch enforces the If-Match header of an update, sending a 412 Precondition Failed response if the client's
+// copy of the resource is out of date. It reports whether the update can go ahead, which it always can when the
+// header isn't set
+func (app *application) checkIfMatch(w http.ResponseWriter, r *http.Request, current interface{}) bool {
+	ifMatch := r.Header.Get("If-Match")
+	if ifMatch == "" {
+		return true
+	}
+
+	etag, err := etagFor(current)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return false
+	}
+
+	if !etagMatches(ifMatch, etag) {
+		app.preconditionFailedResponse(w, r)
+		return false
+	}
+
+	return true
+}
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/nytro04/greenlight/internal/data"
+	"github.com/nytro04/greenlight/internal/validator"
+)
+
+// exportFlushEvery is the number of movies written between flushes of the export, so the client starts receiving data straight away
+const exportFlushEvery = 100
+
+// exportMoviesHandler streams every movie matching the same filters as the movie listing as CSV or NDJSON. The movies are
+// written as they are read from the database, so the whole catalog can be exported without buffering it in memory.
+// The CSV has the columns the import reads, so an export can be imported into another instance
+func (app *application) exportMoviesHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Genres []string
+		Tags   []string
+		Format string
+		data.MovieSearch
+		data.MovieOrigin
+		data.MovieRanges
+	}
+
+	v := validator.New()
+
+	qs := r.URL.Query()
+
+	input.Title = app.readString(qs, "title", "")
+	input.Genres = app.readCSV(qs, "genres", []string{})
+	input.Tags = app.readTags(qs, v)
+	input.MovieOrigin = app.readMovieOrigin(qs, v)
+	if fuzzy := app.readBool(qs, "fuzzy", v); fuzzy != nil {
+		input.Fuzzy = *fuzzy
+	}
+	input.MovieRanges = app.readMovieRanges(qs, v)
+	input.Format = app.readString(qs, "format", "ndjson")
+
+	v.Check(validator.In(input.Format, "csv", "ndjson"), "format", "must be csv or ndjson")
+
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	// write encodes a movie in the chosen format, and flush sends everything buffered by the encoder on to the client
+	var write func(movie *data.Movie) error
+	var flush func() error
+
+	switch input.Format {
+	case "csv":
+		w.Header().Set("Content-Type", "text/csv")
+		w.Header().Set("Content-Disposition", `attachment; filename="movies.csv"`)
+
+		cw := csv.NewWriter(w)
+
+		write = func(movie *data.Movie) error {
+			return cw.Write([]string{
+				strconv.FormatInt(movie.ID, 10),
+				movie.Title,
+				strconv.Itoa(int(movie.Year)),
+				strconv.Itoa(int(movie.Runtime)),
+				strings.Join(movie.Genres, "|"),
+				movie.Language,
+				movie.Country,
+				movie.CreatedAt.Format(time.RFC3339),
+				strconv.Itoa(int(movie.Version)),
+			})
+		}
+		flush = func() error {
+			cw.Flush()
+			return cw.Error()
+		}
+
+		// the header is buffered before the first movie, so an empty result is still a valid CSV file. Writing to
+		// the buffer can't fail, any error surfaces when the buffer is flushed
+		_ = cw.Write([]string{"id", "title", "year", "runtime", "genres", "language", "country", "created_at", "version"})
+	default:
+		w.Header().Set("Content-Type", "application/x-ndjson")
+		w.Header().Set("Content-Disposition", `attachment; filename="movies.ndjson"`)
+
+		enc := json.NewEncoder(w)
+
+		write = func(movie *data.Movie) error {
+			return enc.Encode(movie)
+		}
+		flush = func() error {
+			return nil
+		}
+	}
+
+	w.WriteHeader(http.StatusOK)
+
+	flusher, _ := w.(http.Flusher)
+
+	// flush the response every so many movies so the client starts receiving data straight away
+	count := 0
+	err := app.models.Movies.Export(r.Context(), input.MovieSearch, input.Genres, input.Tags, input.MovieOrigin, input.MovieRanges, func(movie *data.Movie) error {
+		err := write(movie)
+		if err != nil {
+			return err
+		}
+
+		count++
+		if count%exportFlushEvery == 0 {
+			err = flush()
+			if err != nil {
+				return err
+			}
+			if flusher != nil {
+				flusher.Flush()
+			}
+		}
+
+		return nil
+	})
+	if err == nil {
+		err = flush()
+	}
+
+	// the status code has already been sent at this point, so all we can do is log the error
+	if err != nil {
+		app.logError(r, err)
+	}
+}
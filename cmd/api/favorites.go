@@ -0,0 +1,126 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/nytro04/greenlight/internal/data"
+	"github.com/nytro04/greenlight/internal/validator"
+)
+
+// addFavoriteHandler marks a movie as one of the authenticated user's favorites. It is a PUT, so marking a movie which
+// is already a favorite succeeds too
+func (app *application) addFavoriteHandler(w http.ResponseWriter, r *http.Request) {
+	movieID, err := app.readNamedIDParam(r, "movie_id")
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	err = app.models.Favorites.Add(r.Context(), app.contextGetUser(r).ID, movieID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"message": "movie added to favorites"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// removeFavoriteHandler unmarks a movie as one of the authenticated user's favorites
+func (app *application) removeFavoriteHandler(w http.ResponseWriter, r *http.Request) {
+	movieID, err := app.readNamedIDParam(r, "movie_id")
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	err = app.models.Favorites.Remove(r.Context(), app.contextGetUser(r).ID, movieID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"message": "movie removed from favorites"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// listFavoritesHandler returns a page of the authenticated user's favorite movies, most recently favorited first
+// unless the client asks otherwise
+func (app *application) listFavoritesHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		data.Filters
+	}
+
+	v := validator.New()
+
+	qs := r.URL.Query()
+
+	input.Filters.Page = app.readInt(qs, "page", 1, v)
+	input.Filters.PageSize = app.readInt(qs, "page_size", 20, v)
+	input.Filters.Sort = app.readString(qs, "sort", "-favorited_at")
+	input.Filters.SortSafeList = []string{"id", "title", "year", "runtime", "favorited_at", "-id", "-title", "-year", "-runtime", "-favorited_at"}
+
+	if data.ValidateFilters(v, input.Filters); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	movies, metadata, err := app.models.Favorites.GetAll(r.Context(), app.contextGetUser(r).ID, input.Filters)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrUnsafeSort):
+			app.invalidSortResponse(w, r, input.Filters)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"movies": movies, "metadata": metadata}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// markFavorites sets the favorited flag of the movies for the authenticated user, and returns the time the user last
+// changed their favorites, which the Last-Modified time of the response has to take into account. The movies are
+// left unflagged for an anonymous user
+func (app *application) markFavorites(r *http.Request, movies ...*data.Movie) (time.Time, error) {
+	user := app.contextGetUser(r)
+	if user.IsAnonymous() || len(movies) == 0 {
+		return time.Time{}, nil
+	}
+
+	ids := make([]int64, len(movies))
+	for i, movie := range movies {
+		ids[i] = movie.ID
+	}
+
+	favorited, updatedAt, err := app.models.Favorites.GetFavorited(r.Context(), user.ID, ids)
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	for _, movie := range movies {
+		isFavorite := favorited[movie.ID]
+		movie.Favorited = &isFavorite
+	}
+
+	return updatedAt, nil
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/url"
+
+	"github.com/nytro04/greenlight/internal/validator"
+)
+
+// readFields reads the comma-separated fields query string parameter, which names the fields of a resource the client
+// wants in the response, checking each value is one of the supported ones. An empty slice means every field is wanted
+func (app *application) readFields(qs url.Values, supported []string, v *validator.Validator) []string {
+	fields := app.readCSV(qs, "fields", []string{})
+
+	for _, field := range fields {
+		v.Check(validator.In(field, supported...), "fields", fmt.Sprintf("unsupported field %q", field))
+	}
+
+	return fields
+}
+
+// selectFields returns the JSON representation of a resource, or a slice of resources, cut down to the given fields.
+// The value is returned unchanged if no fields were asked for. Working on the encoded JSON, rather than the structs,
+// means the fields always have the names and formats the client sees in a full response
+func selectFields(value interface{}, fields []string) (interface{}, error) {
+	if len(fields) == 0 {
+		return value, nil
+	}
+
+	js, err := json.Marshal(value)
+	if err != nil {
+		return nil, err
+	}
+
+	// a JSON array holds a list of resources, anything else is a single one
+	if len(js) > 0 && js[0] == '[' {
+		var resources []map[string]json.RawMessage
+
+		err = json.Unmarshal(js, &resources)
+		if err != nil {
+			return nil, err
+		}
+
+		for i := range resources {
+			resources[i] = keepFields(resources[i], fields)
+		}
+
+		return resources, nil
+	}
+
+	var resource map[string]json.RawMessage
+
+	err = json.Unmarshal(js, &resource)
+	if err != nil {
+		return nil, err
+	}
+
+	return keepFields(resource, fields), nil
+}
+
+// keepFields returns the fields of the resource which are in the list. A field left out of the JSON, such as an omitempty
+// field with no value, stays left out
+func keepFields(resource map[string]json.RawMessage, fields []string) map[string]json.RawMessage {
+	kept := make(map[string]json.RawMessage, len(fields))
+
+	for _, field := range fields {
+		if value, ok := resource[field]; ok {
+			kept[field] = value
+		}
+	}
+
+	return kept
+}
@@ -0,0 +1,459 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/nytro04/greenlight/internal/data"
+	"github.com/nytro04/greenlight/internal/graphql"
+	"github.com/nytro04/greenlight/internal/validator"
+)
+
+// graphqlRequest carries the HTTP request a query was made in to the resolvers, which are only handed a context
+type graphqlRequest struct {
+	r           *http.Request
+	permissions data.Permissions // the permissions of the user, loaded the first time a resolver needs them
+}
+
+// moviePage and reviewPage are a page of a listing together with its pagination metadata
+type moviePage struct {
+	movies   []*data.Movie
+	metadata data.Metadata
+}
+
+type reviewPage struct {
+	reviews  []*data.Review
+	metadata data.Metadata
+}
+
+// graphqlHandler serves the GraphQL queries, sent either as a JSON body in a POST request or in the query string of a
+// GET request. The response always has a 200 status, any problems with the query are in its errors
+func (app *application) graphqlHandler(schema *graphql.Schema) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var req graphql.Request
+
+		switch r.Method {
+		case http.MethodGet:
+			qs := r.URL.Query()
+
+			req.Query = qs.Get("query")
+			req.OperationName = qs.Get("operationName")
+
+			if variables := qs.Get("variables"); variables != "" {
+				err := json.Unmarshal([]byte(variables), &req.Variables)
+				if err != nil {
+					app.badRequestResponse(w, r, errors.New("variables must be a JSON object"))
+					return
+				}
+			}
+		default:
+			err := app.readJSON(w, r, &req)
+			if err != nil {
+				app.badRequestResponse(w, r, err)
+				return
+			}
+		}
+
+		v := validator.New()
+
+		if v.Check(strings.TrimSpace(req.Query) != "", "query", "must be provided"); !v.Valid() {
+			app.failedValidationResponse(w, r, v.Errors)
+			return
+		}
+
+		ctx := context.WithValue(r.Context(), graphqlRequestContextKey, &graphqlRequest{r: r})
+
+		err := app.writeJSON(w, http.StatusOK, schema.Execute(ctx, req), nil)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+		}
+	}
+}
+
+// graphqlSchema builds the schema of the GraphQL endpoint. The fields which fetch related records, such as the
+// reviews of a movie and the user who wrote each review, are resolved with one query for every object at their
+// level of the response, so a page of movies with their reviews and reviewers takes three queries however long it is
+func (app *application) graphqlSchema() *graphql.Schema {
+	metadata := &graphql.Object{Name: "Metadata", Fields: map[string]*graphql.Field{
+		"currentPage":  {Resolve: graphql.Property(func(m data.Metadata) any { return m.CurrentPage })},
+		"pageSize":     {Resolve: graphql.Property(func(m data.Metadata) any { return m.PageSize })},
+		"firstPage":    {Resolve: graphql.Property(func(m data.Metadata) any { return m.FirstPage })},
+		"lastPage":     {Resolve: graphql.Property(func(m data.Metadata) any { return m.LastPage })},
+		"totalRecords": {Resolve: graphql.Property(func(m data.Metadata) any { return m.TotalRecords })},
+	}}
+
+	user := &graphql.Object{Name: "User", Fields: map[string]*graphql.Field{
+		"id":        {Resolve: graphql.Property(func(u *data.User) any { return u.ID })},
+		"name":      {Resolve: graphql.Property(func(u *data.User) any { return u.Name })},
+		"createdAt": {Resolve: graphql.Property(func(u *data.User) any { return u.CreatedAt })},
+		"avatarUrl": {Resolve: graphql.Property(func(u *data.User) any {
+			if u.AvatarURL == "" {
+				return nil
+			}
+			return u.AvatarURL
+		})},
+		"email":     {Resolve: app.resolveUserPrivate(func(u *data.User) any { return u.Email })},
+		"activated": {Resolve: app.resolveUserPrivate(func(u *data.User) any { return u.Activated })},
+	}}
+
+	credit := &graphql.Object{Name: "Credit", Fields: map[string]*graphql.Field{
+		"id":           {Resolve: graphql.Property(func(c *data.Credit) any { return c.ID })},
+		"personId":     {Resolve: graphql.Property(func(c *data.Credit) any { return c.PersonID })},
+		"personName":   {Resolve: graphql.Property(func(c *data.Credit) any { return c.PersonName })},
+		"role":         {Resolve: graphql.Property(func(c *data.Credit) any { return c.Role })},
+		"character":    {Resolve: graphql.Property(func(c *data.Credit) any { return c.Character })},
+		"billingOrder": {Resolve: graphql.Property(func(c *data.Credit) any { return c.BillingOrder })},
+	}}
+
+	review := &graphql.Object{Name: "Review", Fields: map[string]*graphql.Field{
+		"id":        {Resolve: graphql.Property(func(r *data.Review) any { return r.ID })},
+		"createdAt": {Resolve: graphql.Property(func(r *data.Review) any { return r.CreatedAt })},
+		"movieId":   {Resolve: graphql.Property(func(r *data.Review) any { return r.MovieID })},
+		"rating":    {Resolve: graphql.Property(func(r *data.Review) any { return r.Rating })},
+		"body":      {Resolve: graphql.Property(func(r *data.Review) any { return r.Body })},
+		"version":   {Resolve: graphql.Property(func(r *data.Review) any { return r.Version })},
+		"user":      {Type: user, Resolve: app.resolveReviewUsers},
+	}}
+
+	reviews := &graphql.Object{Name: "ReviewPage", Fields: map[string]*graphql.Field{
+		"reviews":  {Type: review, List: true, Resolve: graphql.Property(func(p *reviewPage) any { return graphqlList(p.reviews) })},
+		"metadata": {Type: metadata, Resolve: graphql.Property(func(p *reviewPage) any { return p.metadata })},
+	}}
+
+	movie := &graphql.Object{Name: "Movie", Fields: map[string]*graphql.Field{
+		"id":            {Resolve: graphql.Property(func(m *data.Movie) any { return m.ID })},
+		"createdAt":     {Resolve: graphql.Property(func(m *data.Movie) any { return m.CreatedAt })},
+		"updatedAt":     {Resolve: graphql.Property(func(m *data.Movie) any { return m.UpdatedAt })},
+		"title":         {Resolve: graphql.Property(func(m *data.Movie) any { return m.Title })},
+		"year":          {Resolve: graphql.Property(func(m *data.Movie) any { return m.Year })},
+		"runtime":       {Resolve: graphql.Property(func(m *data.Movie) any { return int32(m.Runtime) })},
+		"genres":        {Resolve: graphql.Property(func(m *data.Movie) any { return m.Genres })},
+		"language":      {Resolve: graphql.Property(func(m *data.Movie) any { return m.Language })},
+		"country":       {Resolve: graphql.Property(func(m *data.Movie) any { return m.Country })},
+		"version":       {Resolve: graphql.Property(func(m *data.Movie) any { return m.Version })},
+		"averageRating": {Resolve: graphql.Property(func(m *data.Movie) any { return m.AverageRating })},
+		"reviewCount":   {Resolve: graphql.Property(func(m *data.Movie) any { return m.ReviewCount })},
+		"posterUrl": {Resolve: graphql.Property(func(m *data.Movie) any {
+			if m.PosterURL == "" {
+				return nil
+			}
+			return m.PosterURL
+		})},
+		"credits": {Type: credit, List: true, Resolve: app.resolveMovieCredits},
+		"reviews": {Type: reviews, Args: []string{"page", "pageSize", "sort"}, Resolve: app.resolveMovieReviews},
+	}}
+
+	movies := &graphql.Object{Name: "MoviePage", Fields: map[string]*graphql.Field{
+		"movies":   {Type: movie, List: true, Resolve: graphql.Property(func(p *moviePage) any { return graphqlList(p.movies) })},
+		"metadata": {Type: metadata, Resolve: graphql.Property(func(p *moviePage) any { return p.metadata })},
+	}}
+
+	query := &graphql.Object{Name: "Query", Fields: map[string]*graphql.Field{
+		"movie":  {Type: movie, Args: []string{"id"}, Resolve: graphql.Root(app.resolveMovie)},
+		"movies": {Type: movies, Args: []string{"title", "genres", "page", "pageSize", "sort"}, Resolve: graphql.Root(app.resolveMovies)},
+		"me":     {Type: user, Resolve: graphql.Root(app.resolveMe)},
+		"user":   {Type: user, Args: []string{"id"}, Resolve: graphql.Root(app.resolveUser)},
+	}}
+
+	return &graphql.Schema{Query: query}
+}
+
+func (app *application) resolveMovie(ctx context.Context, args graphql.Args) (any, error) {
+	id, err := args.ID("id")
+	if err != nil {
+		return nil, err
+	}
+
+	movie, err := app.models.Movies.Get(ctx, id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			return nil, nil
+		default:
+			return nil, app.graphqlServerError(ctx, err)
+		}
+	}
+
+	return movie, nil
+}
+
+// resolveMovies lists the movies with the same title and genre filters, sorts and pagination as GET /v1/movies
+func (app *application) resolveMovies(ctx context.Context, args graphql.Args) (any, error) {
+	var search data.MovieSearch
+	var filters data.Filters
+
+	var err error
+
+	if search.Title, err = args.String("title", ""); err != nil {
+		return nil, err
+	}
+
+	genres, err := args.Strings("genres")
+	if err != nil {
+		return nil, err
+	}
+
+	if filters.Page, err = args.Int("page", 1); err != nil {
+		return nil, err
+	}
+	if filters.PageSize, err = args.Int("pageSize", 20); err != nil {
+		return nil, err
+	}
+	if filters.Sort, err = args.String("sort", "id"); err != nil {
+		return nil, err
+	}
+	filters.SortSafeList = movieSortSafeList
+
+	v := validator.New()
+
+	if data.ValidateFilters(v, filters); !v.Valid() {
+		return nil, graphqlValidationError(v)
+	}
+
+	movies, metadata, err := app.models.Movies.GetAll(ctx, search, genres, []string{}, data.MovieOrigin{Languages: []string{}, Countries: []string{}}, data.MovieRanges{}, filters)
+	if err != nil {
+		return nil, app.graphqlServerError(ctx, err)
+	}
+
+	return &moviePage{movies: movies, metadata: metadata}, nil
+}
+
+// resolveMovieCredits fetches the credits of all the movies with a single query
+func (app *application) resolveMovieCredits(ctx context.Context, sources []any, args graphql.Args) ([]any, error) {
+	ids := make([]int64, len(sources))
+	for i, source := range sources {
+		ids[i] = source.(*data.Movie).ID
+	}
+
+	credits, err := app.models.People.GetCreditsForMovies(ids...)
+	if err != nil {
+		return nil, app.graphqlServerError(ctx, err)
+	}
+
+	values := make([]any, len(sources))
+	for i, id := range ids {
+		values[i] = graphqlList(credits[id])
+	}
+
+	return values, nil
+}
+
+// resolveMovieReviews fetches the same page of the reviews of all the movies with a single query
+func (app *application) resolveMovieReviews(ctx context.Context, sources []any, args graphql.Args) ([]any, error) {
+	var filters data.Filters
+
+	var err error
+
+	if filters.Page, err = args.Int("page", 1); err != nil {
+		return nil, err
+	}
+	if filters.PageSize, err = args.Int("pageSize", 20); err != nil {
+		return nil, err
+	}
+	if filters.Sort, err = args.String("sort", "-created_at"); err != nil {
+		return nil, err
+	}
+	filters.SortSafeList = reviewSortSafeList
+
+	v := validator.New()
+
+	if data.ValidateFilters(v, filters); !v.Valid() {
+		return nil, graphqlValidationError(v)
+	}
+
+	ids := make([]int64, len(sources))
+	for i, source := range sources {
+		ids[i] = source.(*data.Movie).ID
+	}
+
+	reviews, metadata, err := app.models.Reviews.GetAllForMovies(ids, filters)
+	if err != nil {
+		return nil, app.graphqlServerError(ctx, err)
+	}
+
+	values := make([]any, len(sources))
+	for i, id := range ids {
+		values[i] = &reviewPage{reviews: reviews[id], metadata: metadata[id]}
+	}
+
+	return values, nil
+}
+
+// resolveReviewUsers fetches the users who wrote the reviews with a single query
+func (app *application) resolveReviewUsers(ctx context.Context, sources []any, args graphql.Args) ([]any, error) {
+	ids := make([]int64, len(sources))
+	for i, source := range sources {
+		ids[i] = source.(*data.Review).UserID
+	}
+
+	users, err := app.models.Users.GetByIDs(ctx, ids...)
+	if err != nil {
+		return nil, app.graphqlServerError(ctx, err)
+	}
+
+	// the user is left null for the anonymized reviews of a user who has deleted their account
+	values := make([]any, len(sources))
+	for i, id := range ids {
+		if user, ok := users[id]; ok {
+			values[i] = user
+		}
+	}
+
+	return values, nil
+}
+
+func (app *application) resolveMe(ctx context.Context, args graphql.Args) (any, error) {
+	gr := ctx.Value(graphqlRequestContextKey).(*graphqlRequest)
+
+	return app.contextGetUser(gr.r), nil
+}
+
+// resolveUser looks up any user, which needs the same users:admin permission as GET /v1/users/:id
+func (app *application) resolveUser(ctx context.Context, args graphql.Args) (any, error) {
+	id, err := args.ID("id")
+	if err != nil {
+		return nil, err
+	}
+
+	err = app.graphqlRequirePermission(ctx, "users:admin")
+	if err != nil {
+		return nil, err
+	}
+
+	user, err := app.models.Users.GetByID(ctx, id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			return nil, nil
+		default:
+			return nil, app.graphqlServerError(ctx, err)
+		}
+	}
+
+	return user, nil
+}
+
+// resolveUserPrivate returns the resolver for a field of a user which is only shown to the user themselves and to
+// the admins, it is null for everyone else
+func (app *application) resolveUserPrivate(get func(user *data.User) any) graphql.Resolver {
+	return func(ctx context.Context, sources []any, args graphql.Args) ([]any, error) {
+		gr := ctx.Value(graphqlRequestContextKey).(*graphqlRequest)
+		current := app.contextGetUser(gr.r)
+
+		// the permissions are only loaded when the request asks for someone else's details
+		var admin *bool
+
+		values := make([]any, len(sources))
+
+		for i, source := range sources {
+			user := source.(*data.User)
+
+			if user.ID != current.ID {
+				if admin == nil {
+					allowed, err := app.graphqlHasPermission(ctx, "users:admin")
+					if err != nil {
+						return nil, err
+					}
+					admin = &allowed
+				}
+
+				if !*admin {
+					continue
+				}
+			}
+
+			values[i] = get(user)
+		}
+
+		return values, nil
+	}
+}
+
+// graphqlHasPermission reports whether the user making the request has the permission, and the API key the request
+// was made with if there is one, in the same way as requirePermission
+func (app *application) graphqlHasPermission(ctx context.Context, code string) (bool, error) {
+	gr := ctx.Value(graphqlRequestContextKey).(*graphqlRequest)
+
+	if gr.permissions == nil {
+		permissions, err := app.models.Permissions.GetAllForUser(ctx, app.contextGetUser(gr.r).ID)
+		if err != nil {
+			return false, app.graphqlServerError(ctx, err)
+		}
+		gr.permissions = append(data.Permissions{}, permissions...)
+	}
+
+	key := app.contextGetAPIKey(gr.r)
+
+	return gr.permissions.Include(code) && (key == nil || key.Permissions.Include(code)), nil
+}
+
+// graphqlRequirePermission returns an error for the field when the user doesn't have the permission, recording the
+// refusal as a security event in the same way as requirePermission
+func (app *application) graphqlRequirePermission(ctx context.Context, code string) error {
+	allowed, err := app.graphqlHasPermission(ctx, code)
+	if err != nil {
+		return err
+	}
+
+	if !allowed {
+		gr := ctx.Value(graphqlRequestContextKey).(*graphqlRequest)
+
+		app.recordSecurityEvent(gr.r, data.SecurityEventPermissionDenied, map[string]string{
+			"permission":     code,
+			"request_method": gr.r.Method,
+			"request_url":    gr.r.URL.String(),
+		})
+		return fmt.Errorf("your user account doesn't have the %s permission needed for this field", code)
+	}
+
+	return nil
+}
+
+// graphqlServerError logs an unexpected error in a resolver and returns the error sent to the client in its place,
+// which doesn't give away anything about the internals
+func (app *application) graphqlServerError(ctx context.Context, err error) error {
+	gr := ctx.Value(graphqlRequestContextKey).(*graphqlRequest)
+
+	app.logError(gr.r, err)
+
+	return errors.New("the server encountered a problem and could not process your request")
+}
+
+// graphqlValidationError turns the problems with the arguments found by a validator into a single error message. The
+// validators key the problems by the snake case query string parameters, so they are renamed to the camel case arguments
+func graphqlValidationError(v *validator.Validator) error {
+	keys := make([]string, 0, len(v.Errors))
+	for key := range v.Errors {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	problems := make([]string, len(keys))
+	for i, key := range keys {
+		words := strings.Split(key, "_")
+		for j := 1; j < len(words); j++ {
+			if words[j] != "" {
+				words[j] = strings.ToUpper(words[j][:1]) + words[j][1:]
+			}
+		}
+
+		problems[i] = fmt.Sprintf("%s %s", strings.Join(words, ""), v.Errors[key])
+	}
+
+	return fmt.Errorf("invalid arguments: %s", strings.Join(problems, ", "))
+}
+
+// graphqlList converts a slice of records into the []any a list field resolves to, an empty list rather than null
+// when there are none
+func graphqlList[T any](items []T) []any {
+	list := make([]any, len(items))
+	for i, item := range items {
+		list[i] = item
+	}
+	return list
+}
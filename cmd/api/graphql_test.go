@@ -0,0 +1,70 @@
+package main
+
+import (
+	"context"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+
+	"github.com/nytro04/greenlight/internal/data"
+)
+
+// TestResolveUserPrivate checks the private fields of a user are only shown to the user themselves and to the admins,
+// and that an API key only shows them to an admin when it has been granted users:admin too
+func TestResolveUserPrivate(t *testing.T) {
+	current := &data.User{ID: 1, Email: "alice@example.com"}
+	other := &data.User{ID: 2, Email: "bob@example.com"}
+
+	tests := []struct {
+		name        string
+		permissions data.Permissions // nil to load them from the mock model, which doesn't grant users:admin
+		key         *data.APIKey
+		want        []any
+	}{
+		{
+			name: "not an admin",
+			want: []any{"alice@example.com", nil},
+		},
+		{
+			name:        "admin",
+			permissions: data.Permissions{"users:admin"},
+			want:        []any{"alice@example.com", "bob@example.com"},
+		},
+		{
+			name:        "admin with a key without users:admin",
+			permissions: data.Permissions{"users:admin"},
+			key:         &data.APIKey{Permissions: data.Permissions{"movies:read"}},
+			want:        []any{"alice@example.com", nil},
+		},
+		{
+			name:        "admin with a key with users:admin",
+			permissions: data.Permissions{"users:admin"},
+			key:         &data.APIKey{Permissions: data.Permissions{"users:admin"}},
+			want:        []any{"alice@example.com", "bob@example.com"},
+		},
+	}
+
+	app := &application{models: data.NewMockModels()}
+
+	resolve := app.resolveUserPrivate(func(u *data.User) any { return u.Email })
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := app.contextSetUser(httptest.NewRequest("POST", "/v1/graphql", nil), current)
+			if tt.key != nil {
+				r = app.contextSetAPIKey(r, tt.key)
+			}
+
+			ctx := context.WithValue(r.Context(), graphqlRequestContextKey, &graphqlRequest{r: r, permissions: tt.permissions})
+
+			got, err := resolve(ctx, []any{current, other}, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
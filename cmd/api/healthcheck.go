@@ -1,7 +1,14 @@
 package main
 
 import (
+	"context"
+	"fmt"
 	"net/http"
+	"sync"
+	"time"
+
+	"github.com/nytro04/greenlight/internal/data"
+	"github.com/nytro04/greenlight/internal/mailer"
 )
 
 func (app *application) healthcheckHandler(w http.ResponseWriter, r *http.Request) {
@@ -19,3 +26,145 @@ func (app *application) healthcheckHandler(w http.ResponseWriter, r *http.Reques
 		app.serverErrorResponse(w, r, err)
 	}
 }
+
+// readyCheckTimeout is how long each dependency has to respond to the readiness check
+const readyCheckTimeout = 2 * time.Second
+
+// dependencyCheck is the result of checking one of the dependencies of the application. A dependency which isn't
+// required, such as the mail provider, is reported but doesn't stop the application from being ready
+type dependencyCheck struct {
+	Status   string            `json:"status"` // ok, unavailable or disabled
+	Required bool              `json:"required"`
+	Duration string            `json:"duration,omitempty"`
+	Schema   *data.SchemaCheck `json:"schema,omitempty"`
+}
+
+// healthzHandler is the liveness check. It always succeeds while the process is able to serve requests, and doesn't
+// look at any dependencies, so an orchestrator only restarts the process when it has stopped responding altogether
+func (app *application) healthzHandler(w http.ResponseWriter, r *http.Request) {
+	err := app.writeJSON(w, http.StatusOK, envelope{"status": "alive"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// readyzHandler reports whether the application is ready to serve traffic. The database connection, the migrations
+// and the mail provider are checked at the same time, and the application is ready when every required dependency is
+// ok. A 503 Service Unavailable is sent otherwise, so a load balancer stops routing to an instance which can't reach
+// the database, or to an old binary deployed against a newer schema. The errors are logged rather than included in
+// the response, which is public
+func (app *application) readyzHandler(w http.ResponseWriter, r *http.Request) {
+	checks := map[string]func(ctx context.Context) (*dependencyCheck, error){
+		"database":   app.checkDatabase,
+		"migrations": app.checkMigrations,
+		"mail":       app.checkMail,
+	}
+
+	results := make(map[string]*dependencyCheck, len(checks))
+
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+
+	for name, check := range checks {
+		wg.Add(1)
+
+		go func() {
+			defer wg.Done()
+
+			ctx, cancel := context.WithTimeout(r.Context(), readyCheckTimeout)
+			defer cancel()
+
+			start := time.Now()
+
+			result, err := check(ctx)
+			if err != nil {
+				app.logError(r, fmt.Errorf("readiness check %s: %w", name, err))
+			}
+			if result.Status != "disabled" {
+				result.Duration = time.Since(start).Round(time.Microsecond).String()
+			}
+
+			mu.Lock()
+			results[name] = result
+			mu.Unlock()
+		}()
+	}
+
+	wg.Wait()
+
+	status, ready := http.StatusOK, "ready"
+	for _, result := range results {
+		if result.Required && result.Status != "ok" {
+			status, ready = http.StatusServiceUnavailable, "unavailable"
+		}
+	}
+
+	err := app.writeJSON(w, status, envelope{"status": ready, "checks": results}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// checkDatabase pings the database, which opens a new connection if there isn't an idle one in the pool
+func (app *application) checkDatabase(ctx context.Context) (*dependencyCheck, error) {
+	result := &dependencyCheck{Status: "unavailable", Required: true}
+
+	err := app.db.PingContext(ctx)
+	if err != nil {
+		return result, err
+	}
+
+	result.Status = "ok"
+	return result, nil
+}
+
+// checkMigrations compares the embedded migrations with the database schema again, rather than relying on the check
+// made at startup, so the instance stops being ready when a newer schema is migrated in underneath it
+func (app *application) checkMigrations(ctx context.Context) (*dependencyCheck, error) {
+	result := &dependencyCheck{Status: "unavailable", Required: true}
+
+	check, err := checkSchema(app.db)
+	if err != nil {
+		return result, err
+	}
+
+	result.Schema = check
+	if check.Status == data.SchemaStatusOK {
+		result.Status = "ok"
+	}
+
+	return result, nil
+}
+
+// checkMail connects and authenticates to the mail provider, without sending anything. Emails are queued and retried
+// in the background, so a provider outage doesn't stop the application from serving requests and the check isn't
+// required. It is disabled when the SMTP provider has no host set. With several SMTP hosts it is ok while any one of
+// them can be reached
+func (app *application) checkMail(ctx context.Context) (*dependencyCheck, error) {
+	result := &dependencyCheck{Status: "disabled"}
+
+	if app.config.mail.Provider == mailer.ProviderSMTP && len(app.config.mail.SMTPHosts) == 0 {
+		return result, nil
+	}
+
+	// the SMTP dialer has its own timeout and ignores the context, so the context only stops the handler waiting any
+	// longer than that
+	done := make(chan error, 1)
+	go func() {
+		done <- app.mailer.Ping(ctx)
+	}()
+
+	select {
+	case err := <-done:
+		if err != nil {
+			result.Status = "unavailable"
+			return result, err
+		}
+	case <-ctx.Done():
+		result.Status = "unavailable"
+		return result, ctx.Err()
+	}
+
+	result.Status = "ok"
+	return result, nil
+}
@@ -9,6 +9,7 @@ import (
 	"net/url"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/julienschmidt/httprouter"
 	"github.com/nytro04/greenlight/internal/validator"
@@ -17,12 +18,17 @@ import (
 // helper method to extract the id parameter from the request context and convert it to an integer.
 // If the id parameter cannot be parsed as an integer, or is less than 1, the method returns an error.
 func (app *application) readIDParam(r *http.Request) (int64, error) {
+	return app.readNamedIDParam(r, "id")
+}
+
+// readNamedIDParam works in the same way as readIDParam, for routes with more than one ID parameter such as /v1/movies/:id/reviews/:review_id
+func (app *application) readNamedIDParam(r *http.Request, name string) (int64, error) {
 
 	params := httprouter.ParamsFromContext(r.Context())
 
-	id, err := strconv.ParseInt(params.ByName("id"), 10, 64)
+	id, err := strconv.ParseInt(params.ByName(name), 10, 64)
 	if err != nil || id < 1 {
-		return 0, errors.New("invalid id parameter")
+		return 0, fmt.Errorf("invalid %s parameter", name)
 	}
 
 	return id, nil
@@ -57,15 +63,16 @@ func (app *application) writeJSON(w http.ResponseWriter, status int, data interf
 	return nil
 }
 
+// maxRequestBodyBytes is the largest request body readJSON accepts (1MB)
+const maxRequestBodyBytes = 1_048_576
+
 // readJSON decodes JSON data from a request body into a destination struct. It also validates the request body data. If the request body is empty or
 // contains invalid JSON, or the JSON data does not match the structure of the destination struct, the method returns an error. If the request body
 // contains a JSON array, or a JSON object with multiple keys, the method returns an error. The method also limits the size of the request body to 1MB.
 func (app *application) readJSON(w http.ResponseWriter, r *http.Request, dst interface{}) error {
 
-	// limit the size of the request body to 1MB
-	maxBytes := 1_048_576
 	// use the MaxBytesReader() function to limit the size of the request body to 1MB. If the request body is larger than this, the server will respond with a 413 Payload Too Large response.
-	r.Body = http.MaxBytesReader(w, r.Body, int64(maxBytes))
+	r.Body = http.MaxBytesReader(w, r.Body, maxRequestBodyBytes)
 	// initialize the json decoder, and call DisallowUnknownFields() method on it to and return and error for JSON fields which
 	// cannot be matched to a destination instead of being silently ignored.
 	dec := json.NewDecoder(r.Body)
@@ -104,7 +111,7 @@ func (app *application) readJSON(w http.ResponseWriter, r *http.Request, dst int
 			return fmt.Errorf("body contains unknown key %s", fieldName)
 			// If the request body exceeds the maximum allowed size, Decode() will return an error message in the format 'http: request body too large'.
 		case err.Error() == "http: request body too large":
-			return fmt.Errorf("body must not be larger than %d bytes", maxBytes)
+			return fmt.Errorf("body must not be larger than %d bytes", maxRequestBodyBytes)
 		case errors.As(err, &invalidUnmarshalError):
 			// TODO: Check for a better error message and return it, panic is not a good idea
 			panic(err)
@@ -151,6 +158,19 @@ func (app *application) readCSV(qs url.Values, key string, defaultValue []string
 	return strings.Split(csv, ",")
 }
 
+// readInclude reads the comma-separated include query string parameter, which names the related resources the client
+// wants embedded in the response, checking each value is one of the supported ones
+func (app *application) readInclude(qs url.Values, supported []string, v *validator.Validator) map[string]bool {
+	include := make(map[string]bool)
+
+	for _, value := range app.readCSV(qs, "include", []string{}) {
+		v.Check(validator.In(value, supported...), "include", fmt.Sprintf("unsupported value %q", value))
+		include[value] = true
+	}
+
+	return include
+}
+
 // readInt helper returns an integer value from the query string, or the provided default value if no key is found.
 func (app *application) readInt(qs url.Values, key string, defaultValue int, v *validator.Validator) int {
 	// extract value for a key from the query string, if no key is exist, this will return empty string ""
@@ -172,6 +192,50 @@ func (app *application) readInt(qs url.Values, key string, defaultValue int, v *
 	return i
 }
 
+// readBool helper returns a pointer to the boolean value from the query string, or nil if no key is found, so an
+// optional filter can tell "not given" apart from false. Anything strconv.ParseBool doesn't accept adds an error to the validator
+func (app *application) readBool(qs url.Values, key string, v *validator.Validator) *bool {
+	s := qs.Get(key)
+
+	if s == "" {
+		return nil
+	}
+
+	b, err := strconv.ParseBool(s)
+	if err != nil {
+		v.AddError(key, "must be a boolean value")
+		return nil
+	}
+
+	return &b
+}
+
+// readTime helper returns a time.Time value from the query string, or the provided default value if no key is found.
+// The value can either be a full RFC3339 timestamp (2006-01-02T15:04:05Z) or a plain date (2006-01-02), in which case it is taken as midnight UTC.
+func (app *application) readTime(qs url.Values, key string, defaultValue time.Time, v *validator.Validator) time.Time {
+	// extract value for a key from the query string, if no key is exist, this will return empty string ""
+	s := qs.Get(key)
+
+	// if no key is found, return the default value
+	if s == "" {
+		return defaultValue
+	}
+
+	// try the full timestamp format first, then fall back to the plain date format
+	t, err := time.Parse(time.RFC3339, s)
+	if err == nil {
+		return t
+	}
+
+	t, err = time.Parse(time.DateOnly, s)
+	if err != nil {
+		v.AddError(key, "must be a valid RFC3339 timestamp or YYYY-MM-DD date")
+		return defaultValue
+	}
+
+	return t
+}
+
 // The background helper method is used to start a background goroutine for a given function. This is useful for running background tasks that do not need to block the main application thread.
 // The method uses a deferred function to recover from any runtime panics and log the error using the application logger, instead of terminating the application.
 func (app *application) background(fn func()) {
@@ -188,7 +252,7 @@ func (app *application) background(fn func()) {
 		defer func() {
 			// Recover from any runtime panics and log the error using the application logger
 			if err := recover(); err != nil {
-				app.logger.PrintError(fmt.Errorf("%s", err), nil)
+				app.logger.PrintError(fmt.Errorf("%s", err))
 			}
 		}()
 		// Execute the arbitrary function that was passed in as an argument
@@ -0,0 +1,317 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/julienschmidt/httprouter"
+	"github.com/nytro04/greenlight/internal/data"
+	"github.com/nytro04/greenlight/internal/validator"
+)
+
+// FuzzReadJSON checks that readJSON never panics on an untrusted request body, and only accepts bodies which are
+// a single valid JSON value
+func FuzzReadJSON(f *testing.F) {
+	f.Add(`{"title":"Moana","year":2016,"runtime":"107 mins","genres":["animation","adventure"]}`)
+	f.Add(`{"title":"Moana"}{"title":"Moana"}`)
+	f.Add(`{"title":"Moana", "rating":"PG"}`)
+	f.Add(`{"runtime":107}`)
+	f.Add(`["foo", "bar"]`)
+	f.Add(`{"title":`)
+	f.Add(``)
+
+	app := &application{}
+
+	f.Fuzz(func(t *testing.T, body string) {
+		var input struct {
+			Title   string       `json:"title"`
+			Year    int32        `json:"year"`
+			Runtime data.Runtime `json:"runtime"`
+			Genres  []string     `json:"genres"`
+		}
+
+		r := httptest.NewRequest("POST", "/v1/movies", strings.NewReader(body))
+		w := httptest.NewRecorder()
+
+		err := app.readJSON(w, r, &input)
+		if err == nil && !json.Valid([]byte(strings.TrimSpace(body))) {
+			t.Fatalf("accepted body which isn't a single JSON value: %q", body)
+		}
+	})
+}
+
+// FuzzReadQuery checks that the query string helpers used by the list endpoints never panic on an untrusted query
+// string, and that any page or page size they accept passes the filter validation
+func FuzzReadQuery(f *testing.F) {
+	f.Add("title=moana&genres=animation,adventure&page=1&page_size=5&sort=-year")
+	f.Add("page=abc&page_size=9999999999999999999999&sort=title%3B")
+	f.Add("from=2024-01-01&to=2024-01-02T15:04:05Z")
+	f.Add("from=2024-13-45&genres=,,&page=-1")
+	f.Add("%zz=1&;")
+
+	app := &application{}
+
+	f.Fuzz(func(t *testing.T, rawQuery string) {
+		qs, err := url.ParseQuery(rawQuery)
+		if err != nil {
+			return
+		}
+
+		v := validator.New()
+
+		app.readString(qs, "title", "")
+		app.readCSV(qs, "genres", []string{})
+		app.readTime(qs, "from", time.Time{}, v)
+
+		filters := data.Filters{
+			Page:         app.readInt(qs, "page", 1, v),
+			PageSize:     app.readInt(qs, "page_size", 20, v),
+			Sort:         app.readString(qs, "sort", "id"),
+			SortSafeList: []string{"id", "title", "year", "runtime", "-id", "-title", "-year", "-runtime"},
+		}
+
+		if data.ValidateFilters(v, filters); v.Valid() && (filters.Page < 1 || filters.PageSize < 1) {
+			t.Fatalf("accepted page %d and page size %d", filters.Page, filters.PageSize)
+		}
+	})
+}
+
+// TestReadPatch checks both patch formats are applied to the current document, and that the fields a patch removes
+// come back empty rather than keeping their old values
+func TestReadPatch(t *testing.T) {
+	current := moviePatchDocument{Title: "Moana", Year: 2016, Runtime: 107, Genres: []string{"animation", "adventure"}}
+
+	tests := []struct {
+		name      string
+		mediaType string
+		body      string
+		want      moviePatchDocument
+		wantErr   bool
+	}{
+		{
+			name:      "remove a genre",
+			mediaType: jsonPatchMediaType,
+			body:      `[{"op":"test","path":"/genres/1","value":"adventure"},{"op":"remove","path":"/genres/1"}]`,
+			want:      moviePatchDocument{Title: "Moana", Year: 2016, Runtime: 107, Genres: []string{"animation"}},
+		},
+		{
+			name:      "failed test",
+			mediaType: jsonPatchMediaType,
+			body:      `[{"op":"test","path":"/title","value":"Frozen"},{"op":"replace","path":"/year","value":2013}]`,
+			wantErr:   true,
+		},
+		{
+			name:      "unknown field",
+			mediaType: jsonPatchMediaType,
+			body:      `[{"op":"add","path":"/rating","value":"PG"}]`,
+			wantErr:   true,
+		},
+		{
+			name:      "merge and clear",
+			mediaType: mergePatchMediaType,
+			body:      `{"runtime":"103 mins","genres":null}`,
+			want:      moviePatchDocument{Title: "Moana", Year: 2016, Runtime: 103},
+		},
+	}
+
+	app := &application{}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest("PATCH", "/v1/movies/1", strings.NewReader(tt.body))
+			w := httptest.NewRecorder()
+
+			var got moviePatchDocument
+
+			err := app.readPatch(w, r, tt.mediaType, current, &got)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("got error %v, want error %t", err, tt.wantErr)
+			}
+
+			if !tt.wantErr && !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+// TestNegotiateFormat checks the response format follows the quality values of the Accept header, and falls back to
+// JSON when nothing the API can send is listed
+func TestNegotiateFormat(t *testing.T) {
+	tests := []struct {
+		accept string
+		want   string
+	}{
+		{"", formatJSON},
+		{"text/html", formatJSON},
+		{"application/xml", formatXML},
+		{"application/json;q=0.5, text/xml", formatXML},
+		{"application/msgpack;q=0.9, application/xml;q=0.8", formatMsgPack},
+		{"application/xml;q=0, */*", formatJSON},
+		{"application/vnd.api+json", formatJSONAPI},
+	}
+
+	for _, tt := range tests {
+		r := httptest.NewRequest("GET", "/v1/movies", nil)
+		r.Header.Set("Accept", tt.accept)
+
+		if got := negotiateFormat(r, formatJSON); got != tt.want {
+			t.Errorf("Accept %q: got %s, want %s", tt.accept, got, tt.want)
+		}
+	}
+}
+
+// TestJSONToXML checks the members keep their order, array items become <item> elements and keys which aren't valid
+// XML names are kept in an attribute
+func TestJSONToXML(t *testing.T) {
+	got, err := jsonToXML([]byte(`{"movie":{"title":"Moana & Maui","genres":["animation"],"year":2016},"errors":{"movies[0].title":"must be provided"}}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := `<?xml version="1.0" encoding="UTF-8"?>` + "\n" +
+		`<response><movie><title>Moana &amp; Maui</title><genres><item>animation</item></genres><year>2016</year></movie>` +
+		`<errors><entry key="movies[0].title">must be provided</entry></errors></response>` + "\n"
+
+	if string(got) != want {
+		t.Errorf("got %s, want %s", got, want)
+	}
+}
+
+// TestJSONAPIDocument checks a movie with embedded credits becomes a resource object with a relationship, and a failed
+// validation becomes one error object for each field
+func TestJSONAPIDocument(t *testing.T) {
+	got, err := jsonAPIDocument([]byte(`{"movie":{"id":1,"title":"Moana","credits":[{"id":7,"role":"cast"}]}}`), http.StatusOK)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := `{"data":{"type":"movies","id":"1","attributes":{"title":"Moana"},"relationships":{"credits":{"data":[{"type":"credits","id":"7"}]}}},` +
+		`"included":[{"type":"credits","id":"7","attributes":{"role":"cast"}}],"jsonapi":{"version":"1.1"}}` + "\n"
+
+	if string(got) != want {
+		t.Errorf("got %s, want %s", got, want)
+	}
+
+	got, err = jsonAPIDocument([]byte(`{"error":{"year":"must be provided","title":"must be provided"},"request_id":"abc"}`), http.StatusUnprocessableEntity)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want = `{"errors":[{"status":"422","title":"Unprocessable Entity","detail":"must be provided","source":{"pointer":"/data/attributes/title"}},` +
+		`{"status":"422","title":"Unprocessable Entity","detail":"must be provided","source":{"pointer":"/data/attributes/year"}}],` +
+		`"meta":{"request_id":"abc"},"jsonapi":{"version":"1.1"}}` + "\n"
+
+	if string(got) != want {
+		t.Errorf("got %s, want %s", got, want)
+	}
+}
+
+// TestNotModifiedSince checks If-Modified-Since gets a 304 only when the movies haven't changed after it, and is
+// ignored when If-None-Match is sent
+func TestNotModifiedSince(t *testing.T) {
+	app := &application{}
+	updatedAt := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		ifModifiedSince string
+		ifNoneMatch     string
+		want            int
+	}{
+		{"", "", http.StatusOK},
+		{updatedAt.Format(http.TimeFormat), "", http.StatusNotModified},
+		{updatedAt.Add(-time.Second).Format(http.TimeFormat), "", http.StatusOK},
+		{"not a date", "", http.StatusOK},
+		{updatedAt.Format(http.TimeFormat), `"stale"`, http.StatusOK},
+	}
+
+	for _, tt := range tests {
+		r := httptest.NewRequest("GET", "/v1/movies/1", nil)
+		r.Header.Set("If-Modified-Since", tt.ifModifiedSince)
+		r.Header.Set("If-None-Match", tt.ifNoneMatch)
+		w := httptest.NewRecorder()
+
+		setLastModified(w, time.Time{}, &data.Movie{UpdatedAt: updatedAt.Add(-time.Hour)}, &data.Movie{UpdatedAt: updatedAt})
+
+		err := app.writeResponseWithETag(w, r, http.StatusOK, envelope{"movie": "moana"}, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if w.Code != tt.want {
+			t.Errorf("If-Modified-Since %q, If-None-Match %q: got %d, want %d", tt.ifModifiedSince, tt.ifNoneMatch, w.Code, tt.want)
+		}
+	}
+}
+
+// TestRouteRecorderMethods checks a GET route answers HEAD with its headers but no body, and the OPTIONS and 405
+// responses of a static route list only the methods of its own path
+func TestRouteRecorderMethods(t *testing.T) {
+	app := &application{}
+
+	router := &routeRecorder{Router: httprouter.New()}
+	router.GlobalOPTIONS = http.HandlerFunc(app.optionsResponse)
+
+	router.HandlerFunc(http.MethodGet, "/movies/:id", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"movie":"moana"}`))
+	})
+	router.HandlerFunc(http.MethodDelete, "/movies/:id", func(w http.ResponseWriter, r *http.Request) {})
+	router.StaticHandlerFunc(http.MethodPost, "/movies/batch", func(w http.ResponseWriter, r *http.Request) {})
+
+	tests := []struct {
+		method, path string
+		status       int
+		allow        string
+	}{
+		{http.MethodHead, "/movies/1", http.StatusOK, ""},
+		{http.MethodOptions, "/movies/1", http.StatusNoContent, "DELETE, GET, HEAD, OPTIONS"},
+		{http.MethodPut, "/movies/1", http.StatusMethodNotAllowed, "DELETE, GET, HEAD, OPTIONS"},
+		{http.MethodOptions, "/movies/batch", http.StatusNoContent, "OPTIONS, POST"},
+		{http.MethodGet, "/movies/batch", http.StatusMethodNotAllowed, "OPTIONS, POST"},
+	}
+
+	for _, tt := range tests {
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest(tt.method, tt.path, nil))
+
+		if w.Code != tt.status || w.Header().Get("Allow") != tt.allow {
+			t.Errorf("%s %s: got %d with Allow %q, want %d with Allow %q", tt.method, tt.path, w.Code, w.Header().Get("Allow"), tt.status, tt.allow)
+		}
+	}
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodHead, "/movies/1", nil))
+
+	if w.Body.Len() != 0 || w.Header().Get("Content-Length") != "17" {
+		t.Errorf("HEAD: got a body of %d bytes and Content-Length %q, want no body and 17", w.Body.Len(), w.Header().Get("Content-Length"))
+	}
+}
+
+// TestParseViewWindow checks the trending windows are whole numbers of hours or days
+func TestParseViewWindow(t *testing.T) {
+	tests := []struct {
+		window string
+		want   time.Duration
+		valid  bool
+	}{
+		{"7d", 7 * 24 * time.Hour, true},
+		{"12h", 12 * time.Hour, true},
+		{"0d", 0, false},
+		{"1.5d", 0, false},
+		{"7", 0, false},
+		{"30m", 0, false},
+	}
+
+	for _, tt := range tests {
+		got, err := parseViewWindow(tt.window)
+		if (err == nil) != tt.valid || got != tt.want {
+			t.Errorf("%q: got %s, %v, want %s", tt.window, got, err, tt.want)
+		}
+	}
+}
@@ -0,0 +1,157 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+
+	"github.com/felixge/httpsnoop"
+	"github.com/nytro04/greenlight/internal/data"
+)
+
+// maxIdempotencyKeyLength is the longest Idempotency-Key accepted, long enough for a UUID or any other random key
+const maxIdempotencyKeyLength = 255
+
+// idempotent makes a POST endpoint safe to retry. A request with an Idempotency-Key header is handled once, and the
+// response is kept for the idempotency key TTL and replayed for any retry by the same user with the same key, so a
+// client which didn't get the response because of a flaky network can't create the same movie twice. Responses which
+// a retry could change, the server errors, conflicts and rate limiting, aren't kept, so the retry is handled again.
+// Requests without the header are handled as usual
+func (app *application) idempotent(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		key := r.Header.Get("Idempotency-Key")
+		if key == "" {
+			next(w, r)
+			return
+		}
+
+		if len(key) > maxIdempotencyKeyLength {
+			app.badRequestResponse(w, r, fmt.Errorf("the Idempotency-Key header must not be more than %d bytes long", maxIdempotencyKeyLength))
+			return
+		}
+
+		// the body is read up front to tell a retry from a different request using the same key, then put back for
+		// the handler to read
+		body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxRequestBodyBytes))
+		if err != nil {
+			app.badRequestResponse(w, r, err)
+			return
+		}
+		r.Body = io.NopCloser(bytes.NewReader(body))
+
+		// the format the response is written in is part of the request, so a retry asking for another format through
+		// its Accept header isn't replayed the response in the first one
+		hash := sha256.New()
+		fmt.Fprintf(hash, "%s %s %s\n", r.Method, r.URL.Path, app.responseFormat(r))
+		hash.Write(body)
+
+		// the requests made without signing in, such as registering, share the anonymous user's ID
+		userID := app.contextGetUser(r).ID
+
+		stored, err := app.models.Idempotency.Begin(r.Context(), userID, key, hash.Sum(nil), app.config.idempotency.ttl)
+		if err != nil {
+			switch {
+			case errors.Is(err, data.ErrIdempotencyKeyInUse):
+				app.errorResponse(w, r, http.StatusConflict, "a request with this Idempotency-Key is still being handled, retry it later")
+			case errors.Is(err, data.ErrIdempotencyKeyReused):
+				app.errorResponse(w, r, http.StatusUnprocessableEntity, "the Idempotency-Key has already been used for a different request")
+			default:
+				app.serverErrorResponse(w, r, err)
+			}
+			return
+		}
+
+		if stored != nil {
+			for name, value := range stored.Headers {
+				w.Header().Set(name, value)
+			}
+			w.Header().Set("Idempotent-Replayed", "true")
+			w.WriteHeader(stored.Status)
+			w.Write(stored.Body)
+			return
+		}
+
+		// the response is stored after the request has been handled even if the client has gone away, as that is
+		// when it is most likely to retry
+		ctx := context.WithoutCancel(r.Context())
+
+		response := &data.IdempotentResponse{}
+		var captured bytes.Buffer
+
+		// a handler which panics releases the key, so the retry isn't refused until the key expires
+		completed := false
+		defer func() {
+			if !completed {
+				err := app.models.Idempotency.Release(ctx, userID, key)
+				if err != nil {
+					app.logError(r, err)
+				}
+			}
+		}()
+
+		next(httpsnoop.Wrap(w, httpsnoop.Hooks{
+			WriteHeader: func(writeHeader httpsnoop.WriteHeaderFunc) httpsnoop.WriteHeaderFunc {
+				return func(code int) {
+					if response.Status == 0 {
+						response.Status = code
+					}
+					writeHeader(code)
+				}
+			},
+			Write: func(write httpsnoop.WriteFunc) httpsnoop.WriteFunc {
+				return func(b []byte) (int, error) {
+					if response.Status == 0 {
+						response.Status = http.StatusOK
+					}
+					captured.Write(b)
+					return write(b)
+				}
+			},
+		}), r)
+
+		switch response.Status {
+		case 0, http.StatusConflict, http.StatusTooManyRequests:
+			return
+		}
+
+		if response.Status >= 500 || captured.Len() > maxRequestBodyBytes {
+			return
+		}
+
+		response.Body = captured.Bytes()
+		response.Headers = map[string]string{}
+
+		for _, name := range []string{"Content-Type", "Location"} {
+			if value := w.Header().Get(name); value != "" {
+				response.Headers[name] = value
+			}
+		}
+
+		err = app.models.Idempotency.Complete(ctx, userID, key, response)
+		if err != nil {
+			app.logError(r, err)
+			return
+		}
+
+		completed = true
+	}
+}
+
+// deleteExpiredIdempotencyKeys deletes the idempotency keys which have expired. This is run periodically by the
+// scheduler
+func (app *application) deleteExpiredIdempotencyKeys() error {
+	deleted, err := app.models.Idempotency.DeleteExpired(app.clock.Now())
+	if err != nil {
+		return err
+	}
+
+	if deleted > 0 {
+		app.logger.PrintInfo("expired idempotency keys deleted", "deleted", deleted)
+	}
+
+	return nil
+}
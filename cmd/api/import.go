@@ -0,0 +1,455 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/nytro04/greenlight/internal/data"
+	"github.com/nytro04/greenlight/internal/moviemeta"
+	"github.com/nytro04/greenlight/internal/validator"
+)
+
+// The limits of a bulk import. Larger catalogs can be imported in several requests
+const (
+	maxImportBytes = 10 * 1_048_576
+	maxImportRows  = 10_000
+)
+
+// importMovie is a row of an import, it has the same fields as the body of createMovieHandler
+type importMovie struct {
+	Title    string       `json:"title"`
+	Runtime  data.Runtime `json:"runtime"`
+	Genres   []string     `json:"genres"`
+	Year     int32        `json:"year"`
+	Language string       `json:"language"`
+	Country  string       `json:"country"`
+}
+
+// importRow is a row read from the upload. Rows which couldn't be parsed have errors instead of a movie
+type importRow struct {
+	movie  *data.Movie
+	errors map[string]string
+}
+
+// importResult reports what happened to a row of the import, rows are numbered from 1 in the order they were uploaded
+type importResult struct {
+	Row    int               `json:"row"`
+	Status string            `json:"status"`
+	ID     int64             `json:"id,omitempty"`
+	Errors map[string]string `json:"errors,omitempty"`
+}
+
+// importReport is the response to an import
+type importReport struct {
+	Total    int            `json:"total"`
+	Imported int            `json:"imported"`
+	Failed   int            `json:"failed"`
+	Rows     []importResult `json:"rows"`
+}
+
+// importMoviesHandler creates movies in bulk from a JSON array, NDJSON or CSV upload. The format is taken from the
+// Content-Type of the body, or of the file part of a multipart/form-data upload. Every row is validated, the valid
+// rows are inserted in a single transaction and the response reports, row by row, which were imported and why the others weren't
+func (app *application) importMoviesHandler(w http.ResponseWriter, r *http.Request) {
+	r.Body = http.MaxBytesReader(w, r.Body, maxImportBytes)
+
+	rows, err := readImport(r)
+	if err != nil {
+		var maxBytesError *http.MaxBytesError
+		switch {
+		case errors.As(err, &maxBytesError):
+			app.errorResponse(w, r, http.StatusRequestEntityTooLarge, fmt.Sprintf("the import must not be larger than %d bytes", maxImportBytes))
+		default:
+			app.badRequestResponse(w, r, err)
+		}
+		return
+	}
+
+	v := validator.New()
+
+	v.Check(len(rows) > 0, "movies", "must contain at least 1 movie")
+	v.Check(len(rows) <= maxImportRows, "movies", fmt.Sprintf("must not contain more than %d movies", maxImportRows))
+
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	results := make([]importResult, len(rows))
+	movies := []*data.Movie{}
+
+	for i, row := range rows {
+		results[i].Row = i + 1
+
+		if row.errors == nil {
+			rowValidator := validator.New()
+			data.ValidateMovie(rowValidator, row.movie)
+			row.errors = rowValidator.Errors
+		}
+
+		if len(row.errors) > 0 {
+			results[i].Status = "failed"
+			results[i].Errors = row.errors
+			continue
+		}
+
+		movies = append(movies, row.movie)
+	}
+
+	err = app.models.Movies.InsertMany(r.Context(), movies)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	// the valid movies were inserted in the order of their rows, so they can be matched back up with their results
+	next := 0
+	for i := range results {
+		if results[i].Status == "" {
+			results[i].Status = "imported"
+			results[i].ID = movies[next].ID
+			next++
+		}
+	}
+
+	app.recordAudit(r, data.AuditCategoryContent, "movies_imported", fmt.Sprintf("%d movies", len(movies)))
+
+	report := importReport{
+		Total:    len(rows),
+		Imported: len(movies),
+		Failed:   len(rows) - len(movies),
+		Rows:     results,
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"import": report}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// readImport reads the rows of an import from the body, or the file part of a multipart upload, in the format given by its media type
+func readImport(r *http.Request) ([]importRow, error) {
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+
+	if mediaType != "multipart/form-data" {
+		return parseImport(r.Body, mediaType)
+	}
+
+	reader, err := r.MultipartReader()
+	if err != nil {
+		return nil, err
+	}
+
+	for {
+		part, err := reader.NextPart()
+		if err == io.EOF {
+			return nil, errors.New("body must contain a file field")
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		if part.FormName() != "file" {
+			part.Close()
+			continue
+		}
+		defer part.Close()
+
+		// browsers often send a generic type for uploaded files, in which case the format comes from the file extension
+		mediaType, _, _ = mime.ParseMediaType(part.Header.Get("Content-Type"))
+		if mediaType == "" || mediaType == "application/octet-stream" {
+			mediaType = mime.TypeByExtension(filepath.Ext(part.FileName()))
+		}
+
+		return parseImport(part, mediaType)
+	}
+}
+
+// parseImport reads the rows of an import in the given format
+func parseImport(body io.Reader, mediaType string) ([]importRow, error) {
+	mediaType, _, _ = mime.ParseMediaType(mediaType)
+
+	switch mediaType {
+	case "application/json":
+		return parseJSONImport(body)
+	case "application/x-ndjson", "application/ndjson":
+		return parseNDJSONImport(body)
+	case "text/csv":
+		return parseCSVImport(body)
+	default:
+		return nil, errors.New("import must be application/json, application/x-ndjson or text/csv")
+	}
+}
+
+// parseJSONImport reads the rows of a JSON array of movies. A row with values of the wrong type is reported as a failed row,
+// while malformed JSON fails the whole import, since there is no telling where the next row starts
+func parseJSONImport(body io.Reader) ([]importRow, error) {
+	dec := json.NewDecoder(body)
+	dec.DisallowUnknownFields()
+
+	token, err := dec.Token()
+	if err != nil {
+		return nil, fmt.Errorf("body contains badly-formed JSON: %w", err)
+	}
+	if delim, ok := token.(json.Delim); !ok || delim != '[' {
+		return nil, errors.New("body must contain a JSON array of movies")
+	}
+
+	rows := []importRow{}
+
+	for dec.More() {
+		var input importMovie
+
+		err := dec.Decode(&input)
+		if err != nil {
+			var syntaxError *json.SyntaxError
+			var maxBytesError *http.MaxBytesError
+			switch {
+			case errors.As(err, &syntaxError), errors.Is(err, io.ErrUnexpectedEOF):
+				return nil, errors.New("body contains badly-formed JSON")
+			case errors.As(err, &maxBytesError):
+				return nil, err
+			}
+			rows = append(rows, importRow{errors: importRowErrors(err)})
+			continue
+		}
+
+		rows = append(rows, importRow{movie: input.movie()})
+	}
+
+	_, err = dec.Token()
+	if err != nil {
+		return nil, fmt.Errorf("body contains badly-formed JSON: %w", err)
+	}
+
+	return rows, nil
+}
+
+// importRowErrors describes why a JSON row couldn't be decoded, against the field at fault where there is one
+func importRowErrors(err error) map[string]string {
+	var unmarshalTypeError *json.UnmarshalTypeError
+
+	switch {
+	case errors.As(err, &unmarshalTypeError) && unmarshalTypeError.Field != "":
+		return map[string]string{unmarshalTypeError.Field: "has an incorrect JSON type"}
+	case errors.Is(err, data.ErrInvalidRuntimeFormat):
+		return map[string]string{"runtime": `must be a string such as "102 mins"`}
+	case strings.HasPrefix(err.Error(), "json: unknown field "):
+		return map[string]string{"row": "contains unknown key " + strings.TrimPrefix(err.Error(), "json: unknown field ")}
+	default:
+		return map[string]string{"row": "must be a JSON object describing a movie"}
+	}
+}
+
+// parseNDJSONImport reads the rows of newline delimited JSON, one movie per line. Blank lines are skipped, and a line
+// which isn't a valid movie is reported as a failed row
+func parseNDJSONImport(body io.Reader) ([]importRow, error) {
+	scanner := bufio.NewScanner(body)
+	scanner.Buffer(make([]byte, 64*1024), maxRequestBodyBytes)
+
+	rows := []importRow{}
+
+	for scanner.Scan() {
+		line := bytes.TrimSpace(scanner.Bytes())
+		if len(line) == 0 {
+			continue
+		}
+
+		var input importMovie
+
+		dec := json.NewDecoder(bytes.NewReader(line))
+		dec.DisallowUnknownFields()
+
+		err := dec.Decode(&input)
+		if err != nil {
+			rows = append(rows, importRow{errors: importRowErrors(err)})
+			continue
+		}
+
+		rows = append(rows, importRow{movie: input.movie()})
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	return rows, nil
+}
+
+// parseCSVImport reads the rows of a CSV file. The first line is a header naming the title, year, runtime and genres
+// columns, in any order. The runtime is in minutes, and the genres are separated by | characters
+func parseCSVImport(body io.Reader) ([]importRow, error) {
+	reader := csv.NewReader(body)
+	reader.TrimLeadingSpace = true
+
+	header, err := reader.Read()
+	if err != nil {
+		return nil, errors.New("CSV must start with a header line")
+	}
+
+	columns := make(map[string]int)
+	for i, name := range header {
+		columns[strings.ToLower(strings.TrimSpace(name))] = i
+	}
+
+	for _, name := range []string{"title", "year", "runtime", "genres"} {
+		if _, ok := columns[name]; !ok {
+			return nil, fmt.Errorf("CSV header must contain a %s column", name)
+		}
+	}
+
+	// the rows don't have to have the same number of fields as the header, a short row is reported as a failed row
+	reader.FieldsPerRecord = -1
+
+	rows := []importRow{}
+
+	for {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			var parseError *csv.ParseError
+			if errors.As(err, &parseError) && !errors.Is(parseError.Err, csv.ErrQuote) {
+				rows = append(rows, importRow{errors: map[string]string{"row": err.Error()}})
+				continue
+			}
+			return nil, fmt.Errorf("body contains badly-formed CSV: %w", err)
+		}
+
+		rows = append(rows, csvRow(record, columns))
+	}
+
+	return rows, nil
+}
+
+// csvRow converts a CSV record into an import row, reporting the fields which can't be parsed
+func csvRow(record []string, columns map[string]int) importRow {
+	// the language and country columns are optional, so a missing column reads as an empty field
+	field := func(name string) string {
+		i, ok := columns[name]
+		if !ok || i >= len(record) {
+			return ""
+		}
+		return strings.TrimSpace(record[i])
+	}
+
+	movie := &data.Movie{Title: field("title"), Language: field("language"), Country: field("country")}
+	problems := make(map[string]string)
+
+	if s := field("year"); s != "" {
+		year, err := strconv.ParseInt(s, 10, 32)
+		if err != nil {
+			problems["year"] = "must be an integer value"
+		}
+		movie.Year = int32(year)
+	}
+
+	if s := strings.TrimSuffix(field("runtime"), " mins"); s != "" {
+		runtime, err := strconv.ParseInt(s, 10, 32)
+		if err != nil {
+			problems["runtime"] = "must be an integer number of minutes"
+		}
+		movie.Runtime = data.Runtime(runtime)
+	}
+
+	if s := field("genres"); s != "" {
+		movie.Genres = []string{}
+		for _, genre := range strings.Split(s, "|") {
+			movie.Genres = append(movie.Genres, strings.TrimSpace(genre))
+		}
+	}
+
+	if len(problems) > 0 {
+		return importRow{errors: problems}
+	}
+
+	return importRow{movie: movie}
+}
+
+// movie converts the row into the movie it describes
+func (input importMovie) movie() *data.Movie {
+	return &data.Movie{
+		Title:    input.Title,
+		Runtime:  input.Runtime,
+		Genres:   input.Genres,
+		Year:     input.Year,
+		Language: input.Language,
+		Country:  input.Country,
+	}
+}
+
+// importTMDBMovieHandler creates a movie from its metadata on The Movie Database, so the title, year, runtime, genres,
+// language and country don't have to be typed in by hand. The metadata goes through the same validation as a movie created with createMovieHandler
+func (app *application) importTMDBMovieHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		TMDBID int64 `json:"tmdb_id"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+
+	if v.Check(input.TMDBID > 0, "tmdb_id", "must be a positive integer"); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	meta, err := app.tmdb.Lookup(r.Context(), input.TMDBID)
+	if err != nil {
+		switch {
+		case errors.Is(err, moviemeta.ErrNotFound):
+			v.AddError("tmdb_id", "must be the ID of a movie on TMDB")
+			app.failedValidationResponse(w, r, v.Errors)
+		default:
+			app.logError(r, err)
+			app.errorResponse(w, r, http.StatusBadGateway, "the movie metadata service could not be reached, please try again later")
+		}
+		return
+	}
+
+	movie := &data.Movie{
+		Title:    meta.Title,
+		Year:     meta.Year,
+		Runtime:  data.Runtime(meta.Runtime),
+		Genres:   meta.Genres,
+		Language: meta.Language,
+		Country:  meta.Country,
+	}
+
+	// TMDB has movies which are missing some of the fields we require, such as unreleased movies without a runtime
+	if data.ValidateMovie(v, movie); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = app.models.Movies.Insert(r.Context(), movie)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	app.recordAudit(r, data.AuditCategoryContent, "movie_imported", fmt.Sprintf("movie:%d", movie.ID))
+
+	headers := make(http.Header)
+	headers.Set("Location", fmt.Sprintf("/v1/movies/%d", movie.ID))
+
+	err = app.writeJSON(w, http.StatusCreated, envelope{"movie": movie}, headers)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
@@ -0,0 +1,197 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// jsonAPIResourceTypes maps the keys of the response envelopes which hold resources to the JSON:API type of the
+// resources. The other keys of an envelope, such as the pagination metadata, go in the meta object
+var jsonAPIResourceTypes = map[string]string{
+	"movie":  "movies",
+	"movies": "movies",
+	"user":   "users",
+}
+
+// jsonAPIRelationships maps the fields of a resource type which embed related resources, such as the credits of a
+// movie asked for with include=credits, to the type of the related resources. They are sent as relationships, with
+// the related resources in the included list
+var jsonAPIRelationships = map[string]map[string]string{
+	"movies": {"credits": "credits"},
+}
+
+// jsonAPIDoc is a JSON:API top-level document
+type jsonAPIDoc struct {
+	Data     any                        `json:"data,omitempty"`
+	Errors   []jsonAPIError             `json:"errors,omitempty"`
+	Included []*jsonAPIResource         `json:"included,omitempty"`
+	Meta     map[string]json.RawMessage `json:"meta,omitempty"`
+	JSONAPI  map[string]string          `json:"jsonapi"`
+}
+
+// jsonAPIResource is a resource object, or a resource identifier when only the type and ID are set
+type jsonAPIResource struct {
+	Type          string                         `json:"type"`
+	ID            string                         `json:"id,omitempty"`
+	Attributes    map[string]json.RawMessage     `json:"attributes,omitempty"`
+	Relationships map[string]jsonAPIRelationship `json:"relationships,omitempty"`
+}
+
+type jsonAPIRelationship struct {
+	Data []*jsonAPIResource `json:"data"`
+}
+
+// jsonAPIError is an error object. Source points at the attribute of the request document an error is about
+type jsonAPIError struct {
+	Status string            `json:"status"`
+	Title  string            `json:"title"`
+	Detail string            `json:"detail,omitempty"`
+	Source map[string]string `json:"source,omitempty"`
+}
+
+// jsonAPIDocument converts the JSON encoding of a response envelope to a JSON:API document. The resources in the
+// envelope become resource objects, with their ID taken out of the attributes, an error becomes an errors list with
+// one entry for each field of a failed validation, and every other key of the envelope goes in the meta object
+func jsonAPIDocument(js []byte, status int) ([]byte, error) {
+	var env map[string]json.RawMessage
+
+	err := json.Unmarshal(js, &env)
+	if err != nil {
+		return nil, err
+	}
+
+	doc := jsonAPIDoc{JSONAPI: map[string]string{"version": "1.1"}}
+	included := map[string]bool{}
+
+	for key, raw := range env {
+		resourceType, isResource := jsonAPIResourceTypes[key]
+
+		switch {
+		case key == "error":
+			doc.Errors = jsonAPIErrors(raw, status)
+		case isResource && strings.HasPrefix(string(raw), "["):
+			var objects []map[string]json.RawMessage
+
+			err = json.Unmarshal(raw, &objects)
+			if err != nil {
+				return nil, err
+			}
+
+			resources := make([]*jsonAPIResource, len(objects))
+			for i, object := range objects {
+				resources[i] = doc.resource(resourceType, object, included)
+			}
+			doc.Data = resources
+		case isResource:
+			var object map[string]json.RawMessage
+
+			err = json.Unmarshal(raw, &object)
+			if err != nil {
+				return nil, err
+			}
+
+			doc.Data = doc.resource(resourceType, object, included)
+		default:
+			if doc.Meta == nil {
+				doc.Meta = make(map[string]json.RawMessage)
+			}
+			doc.Meta[key] = raw
+		}
+	}
+
+	body, err := json.Marshal(doc)
+	if err != nil {
+		return nil, err
+	}
+
+	return append(body, '\n'), nil
+}
+
+// resource converts the JSON object of a resource to a resource object, adding the related resources it embeds to the
+// included list of the document. included records the related resources already in the list, so each appears once
+func (doc *jsonAPIDoc) resource(resourceType string, object map[string]json.RawMessage, included map[string]bool) *jsonAPIResource {
+	resource := &jsonAPIResource{Type: resourceType, ID: jsonAPIID(object), Attributes: object}
+	delete(object, "id")
+
+	for field, relatedType := range jsonAPIRelationships[resourceType] {
+		raw, ok := object[field]
+		if !ok {
+			continue
+		}
+		delete(object, field)
+
+		var related []map[string]json.RawMessage
+
+		if json.Unmarshal(raw, &related) != nil {
+			continue
+		}
+
+		relationship := jsonAPIRelationship{Data: []*jsonAPIResource{}}
+
+		for _, object := range related {
+			id := jsonAPIID(object)
+			relationship.Data = append(relationship.Data, &jsonAPIResource{Type: relatedType, ID: id})
+
+			if !included[relatedType+"/"+id] {
+				included[relatedType+"/"+id] = true
+
+				delete(object, "id")
+				doc.Included = append(doc.Included, &jsonAPIResource{Type: relatedType, ID: id, Attributes: object})
+			}
+		}
+
+		if resource.Relationships == nil {
+			resource.Relationships = make(map[string]jsonAPIRelationship)
+		}
+		resource.Relationships[field] = relationship
+	}
+
+	return resource
+}
+
+// jsonAPIID returns the ID of a resource as the string JSON:API requires, or an empty string when the resource has
+// no ID, such as a movie cut down with fields=title
+func jsonAPIID(object map[string]json.RawMessage) string {
+	var id json.Number
+
+	if json.Unmarshal(object["id"], &id) != nil {
+		return ""
+	}
+
+	return id.String()
+}
+
+// jsonAPIErrors converts the error of an error response, which is either a message or the messages of a failed
+// validation keyed by field, to error objects
+func jsonAPIErrors(raw json.RawMessage, status int) []jsonAPIError {
+	title := http.StatusText(status)
+	code := strconv.Itoa(status)
+
+	var message string
+	if json.Unmarshal(raw, &message) == nil {
+		return []jsonAPIError{{Status: code, Title: title, Detail: message}}
+	}
+
+	// any other kind of error is sent as it is in the detail
+	var fields map[string]string
+	if json.Unmarshal(raw, &fields) != nil {
+		return []jsonAPIError{{Status: code, Title: title, Detail: string(raw)}}
+	}
+
+	errs := make([]jsonAPIError, 0, len(fields))
+
+	for field, message := range fields {
+		pointer := "/data/attributes/" + strings.NewReplacer("~", "~0", "/", "~1").Replace(field)
+		errs = append(errs, jsonAPIError{Status: code, Title: title, Detail: message, Source: map[string]string{"pointer": pointer}})
+	}
+
+	// the fields come out of the map in a random order
+	sort.Slice(errs, func(i, j int) bool {
+		return errs[i].Source["pointer"] < errs[j].Source["pointer"]
+	})
+
+	return errs
+}
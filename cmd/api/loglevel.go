@@ -0,0 +1,55 @@
+package main
+
+import (
+	"net/http"
+
+	"github.com/nytro04/greenlight/internal/jsonlog"
+	"github.com/nytro04/greenlight/internal/validator"
+)
+
+// showLogLevelHandler returns the minimum level of the entries the application currently logs
+func (app *application) showLogLevelHandler(w http.ResponseWriter, r *http.Request) {
+	err := app.writeJSON(w, http.StatusOK, envelope{"level": jsonlog.LevelName(app.logLevel.Level())}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// updateLogLevelHandler changes the minimum log level without restarting the server. The change only lasts until
+// the next restart, when the level from the configuration is used again
+func (app *application) updateLogLevelHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Level string `json:"level"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+
+	level, err := jsonlog.ParseLevel(input.Level)
+	if v.Check(err == nil, "level", "must be one of debug, info, warn or error"); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	previous := app.logLevel.Level()
+
+	// the change is logged while the lower of the two levels is in effect, so the entry is written whenever info
+	// entries were being logged before or after it
+	app.logLevel.Set(min(previous, level))
+	app.contextGetLogger(r).PrintInfo("log level changed",
+		"from", jsonlog.LevelName(previous),
+		"to", jsonlog.LevelName(level),
+		"user_id", app.contextGetUser(r).ID,
+	)
+	app.logLevel.Set(level)
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"level": jsonlog.LevelName(level)}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
@@ -0,0 +1,156 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"math/rand/v2"
+	"net/http"
+	"time"
+
+	"github.com/nytro04/greenlight/internal/data"
+	"github.com/nytro04/greenlight/internal/mailer"
+)
+
+// emailClaimSize is the number of due emails the worker claims at a time
+const emailClaimSize = 20
+
+// renderEmail renders the template with the dynamic data. The latest version of the template edited by an admin is
+// used if there is one, otherwise the embedded template
+func (app *application) renderEmail(ctx context.Context, models data.Models, templateFile string, templateData any) (*mailer.Message, error) {
+	tmpl, err := models.EmailTemplates.GetLatest(ctx, mailer.TemplateName(templateFile))
+	switch {
+	case err == nil:
+		return app.mailer.RenderTemplate(mailer.Template{Subject: tmpl.Subject, PlainBody: tmpl.PlainBody, HTMLBody: tmpl.HTMLBody}, templateData)
+	case errors.Is(err, data.ErrRecordNotFound):
+		return app.mailer.Render(templateFile, templateData)
+	default:
+		return nil, err
+	}
+}
+
+// queueEmail renders the template with the dynamic data and queues the email to be sent by the email worker. Queued
+// with the models of a transaction, the email is only sent if the transaction commits, so it can't go missing if the
+// request fails after the change it is about. The caller should call sendQueuedEmails once the email is committed.
+// The attachments are checked before the email is queued, as an invalid attachment would fail every attempt
+func (app *application) queueEmail(ctx context.Context, models data.Models, recipient, templateFile string, templateData any, attachments ...mailer.Attachment) error {
+	err := mailer.ValidateAttachments(attachments)
+	if err != nil {
+		return err
+	}
+
+	message, err := app.renderEmail(ctx, models, templateFile, templateData)
+	if err != nil {
+		return err
+	}
+
+	email := &data.Email{
+		Recipient: recipient,
+		Template:  templateFile,
+		Subject:   message.Subject,
+		PlainBody: message.PlainBody,
+		HTMLBody:  message.HTMLBody,
+	}
+
+	for _, a := range attachments {
+		email.Attachments = append(email.Attachments, data.EmailAttachment{Filename: a.Filename, ContentType: a.ContentType, Data: a.Data, Inline: a.Inline})
+	}
+
+	return models.Emails.Insert(ctx, email)
+}
+
+// sendQueuedEmails triggers the email_deliveries job, so the queued emails go out straight away rather than on its
+// next tick
+func (app *application) sendQueuedEmails(r *http.Request) {
+	err := app.jobs.Trigger("email_deliveries")
+	if err != nil {
+		app.logError(r, err)
+	}
+}
+
+// sendEmails sends every pending email which is due, until there are none left. Each failed attempt is retried with
+// a jittered exponential backoff until the email runs out of attempts. This is run after an email is queued and periodically
+// by the scheduler, which picks up the retries and anything left behind by a restart
+func (app *application) sendEmails() error {
+	// a claimed email isn't picked up again until the lease runs out, which covers the time it takes to send the
+	// whole batch one after another
+	lease := emailClaimSize * app.config.mail.Timeout
+
+	for {
+		emails, err := app.models.Emails.ClaimDue(emailClaimSize, lease)
+		if err != nil {
+			return err
+		}
+
+		if len(emails) == 0 {
+			return nil
+		}
+
+		for _, email := range emails {
+			err := app.sendEmail(email)
+			if err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// sendEmail makes a single attempt at sending a queued email, bounded by the attempt timeout, and records the outcome.
+// The returned error is only for failing to record the outcome, an email the mail provider doesn't accept is recorded
+// against the email
+func (app *application) sendEmail(email *data.Email) error {
+	ctx, cancel := context.WithTimeout(context.Background(), app.config.mail.Timeout)
+	defer cancel()
+
+	name := mailer.TemplateName(email.Template)
+
+	message := &mailer.Message{
+		Subject:   email.Subject,
+		PlainBody: email.PlainBody,
+		HTMLBody:  email.HTMLBody,
+	}
+
+	for _, a := range email.Attachments {
+		message.Attachments = append(message.Attachments, mailer.Attachment{Filename: a.Filename, ContentType: a.ContentType, Data: a.Data, Inline: a.Inline})
+	}
+
+	err := app.mailer.Deliver(ctx, email.Recipient, message)
+	if err == nil {
+		app.instruments.emails.WithLabelValues(name, "sent").Inc()
+		return app.models.Emails.MarkSent(email.ID)
+	}
+
+	// give up after the last attempt
+	if email.Attempts >= app.config.emails.maxAttempts {
+		app.instruments.emails.WithLabelValues(name, "failed").Inc()
+		app.logger.PrintError(err, "message", "giving up on email", "email_id", email.ID, "template", name, "attempt", email.Attempts)
+		return app.models.Emails.MarkFailed(email.ID, err.Error(), nil)
+	}
+
+	next := app.clock.Now().Add(emailRetryWait(app.config.emails.retryBackoff, email.Attempts))
+
+	app.instruments.emails.WithLabelValues(name, "retry").Inc()
+	app.logger.PrintError(err, "message", "email attempt failed", "email_id", email.ID, "template", name, "attempt", email.Attempts, "next_attempt_at", next)
+
+	return app.models.Emails.MarkFailed(email.ID, err.Error(), &next)
+}
+
+// emailRetryWait returns the wait before retrying an email which has failed the given number of attempts. The wait
+// doubles with each attempt, 30s, 1m, 2m, 4m, ... for the default backoff, and a random part of up to half of it is
+// taken off, so the emails which failed together during an outage aren't all retried at the same moment
+func emailRetryWait(backoff time.Duration, attempts int) time.Duration {
+	wait := backoff << (attempts - 1)
+
+	return wait - rand.N(wait/2+1)
+}
+
+// notifyByEmail queues an email which only lets the user know about a change which has already been made, and starts
+// sending it. Failing to queue the email is logged but doesn't fail the request, the change has happened either way
+func (app *application) notifyByEmail(r *http.Request, recipient, templateFile string, templateData any) {
+	err := app.queueEmail(r.Context(), app.models, recipient, templateFile, templateData)
+	if err != nil {
+		app.logError(r, err)
+		return
+	}
+
+	app.sendQueuedEmails(r)
+}
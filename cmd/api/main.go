@@ -3,10 +3,13 @@ package main
 import (
 	"context"
 	"database/sql"
+	"database/sql/driver"
 	"errors"
 	"expvar"
 	"flag"
 	"fmt"
+	"io"
+	"log/slog"
 	"os"
 	"runtime"
 	"strconv"
@@ -14,15 +17,34 @@ import (
 	"sync"
 	"time"
 
+	"github.com/go-webauthn/webauthn/webauthn"
 	"github.com/golang-migrate/migrate/v4"
-	_ "github.com/golang-migrate/migrate/v4/database/postgres"
+	_ "github.com/golang-migrate/migrate/v4/database/pgx/v5"
 	"github.com/golang-migrate/migrate/v4/source/iofs"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/jackc/pgx/v5/stdlib"
 	"github.com/joho/godotenv"
-	_ "github.com/lib/pq"
 	"github.com/nytro04/greenlight/assets"
+	"github.com/nytro04/greenlight/internal/breaker"
+	"github.com/nytro04/greenlight/internal/cache"
+	"github.com/nytro04/greenlight/internal/clock"
 	"github.com/nytro04/greenlight/internal/data"
+	"github.com/nytro04/greenlight/internal/errtrack"
+	"github.com/nytro04/greenlight/internal/jobs"
 	"github.com/nytro04/greenlight/internal/jsonlog"
+	"github.com/nytro04/greenlight/internal/jwtauth"
 	"github.com/nytro04/greenlight/internal/mailer"
+	"github.com/nytro04/greenlight/internal/moviemeta"
+	"github.com/nytro04/greenlight/internal/oauth"
+	pwhash "github.com/nytro04/greenlight/internal/password"
+	"github.com/nytro04/greenlight/internal/random"
+	"github.com/nytro04/greenlight/internal/siem"
+	"github.com/nytro04/greenlight/internal/storage"
+	"github.com/nytro04/greenlight/internal/tracing"
+	"github.com/nytro04/greenlight/internal/validator"
+	"github.com/nytro04/greenlight/internal/webhook"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/propagation"
 )
 
 // buildTime is a string containing the date and time at which the binary was built.
@@ -55,44 +77,224 @@ var (
 )
 
 type config struct {
-	port int
-	env  string
-	db   struct {
-		dsn          string // data source name
-		maxOpenConns int
-		maxIdleConns int
-		maxIdleTime  string
+	port     int
+	env      string
+	logLevel string // minimum level of the log entries (debug|info|warn|error)
+
+	admin struct {
+		addr string // host:port of the internal listener for the operational endpoints, they are served on the public port when empty
+	}
+
+	accessLog struct {
+		enabled bool     // write a log entry for every request
+		exclude []string // paths which aren't logged, such as the health checks
+	}
+
+	tls struct {
+		certFile        string   // PEM certificate chain, the API serves HTTPS with it when it is set along with the key
+		keyFile         string   // PEM private key of the certificate
+		autocertDomains []string // domains certificates are requested from Let's Encrypt for, autocert is off when empty
+		autocertCache   string   // directory the issued certificates and the ACME account key are kept in
+		autocertEmail   string   // contact address given to the certificate authority, optional
+		redirectAddr    string   // plain HTTP listen address which redirects to HTTPS, and answers the ACME HTTP-01 challenges
+	}
+
+	db struct {
+		dsn               string // data source name
+		replicaDSN        string // data source name of the read replica, the reads go to the primary when it is empty
+		maxOpenConns      int
+		minConns          int
+		maxIdleTime       string
+		healthCheckPeriod time.Duration
+		readTimeout       time.Duration // how long a single read of the models may take
+		writeTimeout      time.Duration // how long a single write of the models may take
+		statementTimeout  time.Duration // the statement_timeout of every connection, the server side safety net
+		breakerThreshold  int           // consecutive connection failures which open the circuit breaker, 0 turns it off
+		breakerCooldown   time.Duration // how long the circuit breaker stays open before probing the database
+		schemaCheck       string        // what to do when the embedded migrations don't match the database schema (strict|warn)
 	}
 	limiter struct {
 		rps     float64 // requests per second
 		burst   int     // burst
 		enabled bool
 	}
-	smtp struct {
-		host     string // SMTP server address
-		port     int    // SMTP port
-		username string // SMTP username
-		password string // SMTP password
-		sender   string // email address to send from
+	mail mailer.Config // the provider the emails are sent through and the address they are from
+
+	emails struct {
+		retryBackoff  time.Duration // the wait before the first retry of a failed email, doubled for each retry after it
+		retryInterval time.Duration // how often the queued emails which are due are sent
+		maxAttempts   int           // the number of attempts made at an email before it is given up on
+	}
+
+	activation struct {
+		resendCooldown   time.Duration // the wait between two activation emails sent to the same user
+		resendDailyLimit int           // the number of activation emails a user can be sent in a day
 	}
 
 	cors struct {
-		trustedOrigins []string
+		trustedOrigins   []string      // origins allowed to make cross-origin requests, the host may start with a *. wildcard
+		allowedMethods   []string      // methods allowed in preflighted requests
+		allowedHeaders   []string      // request headers allowed in preflighted requests
+		exposedHeaders   []string      // response headers the browser scripts may read
+		maxAge           time.Duration // how long the browsers may cache a preflight response, zero leaves it to the browser
+		allowCredentials bool          // allow requests with cookies or HTTP authentication
+	}
+
+	audit struct {
+		authRetention     time.Duration // how long authentication events are kept for
+		contentRetention  time.Duration // how long content edit events are kept for
+		retentionInterval time.Duration // how often the expired events are removed
+	}
+
+	accounts struct {
+		deletionGracePeriod time.Duration // how long a user has to change their mind after deleting their account
+		deletionInterval    time.Duration // how often the accounts whose grace period has ended are deleted
+	}
+
+	tokens struct {
+		cleanupInterval time.Duration // how often the expired tokens are deleted
+	}
+
+	views struct {
+		flushInterval   time.Duration // how often the buffered views of the movie details are written to the database
+		retention       time.Duration // how long the view counts are kept, which is the longest trending window
+		cleanupInterval time.Duration // how often the view counts past the retention are deleted
+	}
+
+	idempotency struct {
+		ttl             time.Duration // how long the response to a request with an Idempotency-Key is replayed for
+		cleanupInterval time.Duration // how often the expired idempotency keys are deleted
+	}
+
+	password struct {
+		minScore      int    // the lowest zxcvbn strength score a new password must have
+		hasher        string // the algorithm new password hashes are made with (bcrypt|argon2id)
+		bcryptCost    int    // the bcrypt cost
+		argon2Time    int    // the number of argon2id passes over the memory
+		argon2Memory  int    // the argon2id memory in KiB
+		argon2Threads int    // the argon2id degree of parallelism
+	}
+
+	errtrack struct {
+		dsn string // DSN of the Sentry-compatible error tracker, errors are only logged when it is empty
+	}
+
+	siem struct {
+		forwarder string // none, syslog or http
+		address   string // syslog host:port or HTTP URL the security events are forwarded to
+	}
+
+	auth struct {
+		tokenStrategy string // how authentication tokens are issued and checked (database|jwt)
 	}
+
+	jwt struct {
+		algorithm      string        // HS256 or EdDSA
+		secret         string        // HS256 signing secret
+		privateKeyFile string        // PEM encoded Ed25519 private key for EdDSA
+		issuer         string        // the iss claim of the tokens, tokens from another issuer are refused
+		ttl            time.Duration // how long a signed token is valid for, it can't be revoked before then
+	}
+
+	webauthn struct {
+		rpID          string   // relying party ID, the domain the passkeys are bound to
+		rpDisplayName string   // relying party name shown by the authenticator
+		rpOrigins     []string // fully qualified origins the WebAuthn ceremonies may come from
+	}
+
+	totp struct {
+		issuer string // the name the accounts are listed under in the authenticator apps
+	}
+
+	oauth struct {
+		googleClientID     string // Google OAuth client, Google login is only enabled when it is set
+		googleClientSecret string
+		githubClientID     string // GitHub OAuth app, GitHub login is only enabled when it is set
+		githubClientSecret string
+	}
+
+	scim struct {
+		token string // bearer token identity providers use to call the SCIM provisioning endpoints
+	}
+
+	sso struct {
+		baseURL string // public base URL of the API, used to build the OIDC redirect URLs
+	}
+
+	openapi struct {
+		ui bool // serve the Swagger UI page for the OpenAPI specification at /v1/docs
+	}
+
+	jsonapi struct {
+		defaultFormat bool // render the movies and errors as JSON:API for the clients which don't ask for a format
+	}
+
+	versions struct {
+		v1Deprecation time.Time // when v1 was deprecated, its responses carry the Deprecation header once it is set
+		v1Sunset      time.Time // when v1 will be turned off, sent in the Sunset header of its responses
+	}
+
+	storage storage.Config // where uploaded files such as movie posters are kept
+
+	cache cache.Config // where the movies looked up by ID are cached
+
+	tmdb struct {
+		apiKey  string // API key for The Movie Database, the TMDB import is only enabled when it is set
+		baseURL string // address of the TMDB API
+	}
+
+	webhooks struct {
+		timeout       time.Duration // how long a receiver has to respond to a delivery
+		retryBackoff  time.Duration // the wait before the first retry of a failed delivery, doubled for each retry after it
+		retryInterval time.Duration // how often the deliveries which are due a retry are sent
+		maxAttempts   int           // the number of attempts made at a delivery before it is given up on
+	}
+
+	otel struct {
+		endpoint    string  // base URL of the OTLP/HTTP collector the spans are exported to, tracing is off when it is empty
+		headers     string  // comma-separated key=value headers sent with every export
+		serviceName string  // the service.name the spans are reported under
+		sampleRatio float64 // the fraction of new traces which are recorded
+	}
+
+	randomSeed int64 // seed for the deterministic random source used in test environments, zero means crypto/rand
 }
 
 type application struct {
-	config config
-	logger *jsonlog.Logger
-	models data.Models
-	mailer mailer.Mailer
-	wg     sync.WaitGroup
+	config      config
+	logger      *jsonlog.Logger
+	logLevel    *slog.LevelVar // the minimum level of the logger, which can be changed while the server is running
+	models      data.Models
+	db          *sql.DB // the connection pool, only used directly by the readiness check
+	mailer      mailer.Mailer
+	wg          sync.WaitGroup
+	jobs        *jobs.Scheduler // runs the periodic background jobs, stopped in the graceful shutdown
+	siem        siem.Forwarder
+	errtrack    errtrack.Reporter // reports unexpected errors and panics to the error tracker
+	webauthn    *webauthn.WebAuthn
+	oauth       map[string]*oauth.Provider // the social login providers which have been configured, keyed by name
+	jwt         *jwtauth.Signer            // signs and verifies the authentication tokens, nil unless the jwt token strategy is used
+	clock       clock.Clock                // the source of the current time, swapped for a mock clock in tests
+	random      io.Reader                  // the source of random bytes, swapped for a seeded source in test environments
+	storage     storage.Storage
+	tmdb        *moviemeta.TMDB  // client for The Movie Database, nil unless an API key is configured
+	webhooks    *webhook.Sender  // sends the signed webhook deliveries
+	instruments *appMetrics      // the metrics served in the Prometheus format on /metrics
+	dbBreaker   *breaker.Breaker // the circuit breaker of the primary database, nil when it is turned off
+	views       *viewCounter     // buffers the views of the movie details until they are written
 }
 
 func main() {
 	var cfg config
 
-	logger := jsonlog.New(os.Stdout, jsonlog.LevelInfo)
+	// the minimum level is held in a LevelVar so it can be changed without restarting the server. It stays at info
+	// until the configuration has been read
+	logLevel := new(slog.LevelVar)
+	logger := jsonlog.New(os.Stdout, logLevel)
+
+	// send the entries of libraries which log with slog, or with the standard library's log package, through the same
+	// handler, so every line the application writes is a JSON log entry
+	slog.SetDefault(logger.Slog())
 
 	var (
 		dbHost = os.Getenv("DB_HOST")
@@ -110,7 +312,7 @@ func main() {
 	if env == "development" {
 		err := godotenv.Load()
 		if err != nil {
-			logger.PrintFatal(err, map[string]string{"message": "Error loading .env file"})
+			logger.PrintFatal(err, "message", "Error loading .env file")
 		}
 	}
 
@@ -137,13 +339,49 @@ func main() {
 	// use the environment variables for local development
 	// dsn := fmt.Sprintf("postgres://%s:%s@%s/%s?sslmode=disable", dbUser, dbPassword, dbHost, dbName)
 
-	httpPort := os.Getenv("HTTP_PORT")
-	intHttpPort, _ := strconv.Atoi(httpPort)
+	// collect every problem with the configuration, so they can all be reported together at startup
+	configValidator := validator.New()
+
+	intHttpPort := envInt(configValidator, "HTTP_PORT", "port")
 	flag.IntVar(&cfg.port, "port", intHttpPort, "API server port")
 	flag.StringVar(&cfg.env, "env", env, "Environment (development|staging|production)")
 
 	flag.StringVar(&cfg.db.dsn, "db-dsn", dsn, "PostgreSQL DSN")
 
+	// Read the DSN of the read replica into the config struct. When it is set the catalog reads, the movies, people
+	// and reviews, are sent to the replica while the writes go to the primary
+	flag.StringVar(&cfg.db.replicaDSN, "db-replica-dsn", os.Getenv("DATABASE_REPLICA_URL"), "PostgreSQL read replica DSN")
+
+	// Read the minimum log level into the config struct. It can be changed at runtime with PUT /v1/admin/log-level
+	flag.StringVar(&cfg.logLevel, "log-level", envString("LOG_LEVEL", "info"), "Minimum log level (debug|info|warn|error)")
+
+	// Read the admin listener address into the config struct. When it is set the metrics, pprof and health check
+	// endpoints are only served on this address, which should only be reachable from the internal network
+	flag.StringVar(&cfg.admin.addr, "admin-addr", os.Getenv("ADMIN_ADDR"), "Internal listen address for the operational endpoints, such as 127.0.0.1:4001")
+
+	// Read the access log settings into the config struct. Every request is logged apart from those to the excluded
+	// paths, which are a space-separated list in the same way as the CORS trusted origins
+	cfg.accessLog.exclude = []string{"/v1/healthcheck", "/v1/healthz", "/v1/readyz", "/v1/metrics", "/metrics"}
+	flag.BoolVar(&cfg.accessLog.enabled, "access-log", true, "Write a log entry for every request")
+	flag.Func("access-log-exclude", "Paths left out of the access log (space-separated)", func(val string) error {
+		cfg.accessLog.exclude = strings.Fields(val)
+		return nil
+	})
+
+	// Read the TLS settings into the config struct. The API serves HTTPS directly with either a certificate and key from
+	// disk, or certificates obtained automatically from Let's Encrypt for the listed domains. Plain HTTP requests to the
+	// redirect address are sent on to HTTPS
+	cfg.tls.autocertDomains = strings.Fields(os.Getenv("TLS_AUTOCERT_DOMAINS"))
+	flag.StringVar(&cfg.tls.certFile, "tls-cert", os.Getenv("TLS_CERT_FILE"), "TLS certificate file (PEM)")
+	flag.StringVar(&cfg.tls.keyFile, "tls-key", os.Getenv("TLS_KEY_FILE"), "TLS private key file (PEM)")
+	flag.Func("tls-autocert-domains", "Domains to obtain Let's Encrypt certificates for (space-separated)", func(val string) error {
+		cfg.tls.autocertDomains = strings.Fields(val)
+		return nil
+	})
+	flag.StringVar(&cfg.tls.autocertCache, "tls-autocert-cache", envString("TLS_AUTOCERT_CACHE", "./certs"), "Directory the Let's Encrypt certificates are cached in")
+	flag.StringVar(&cfg.tls.autocertEmail, "tls-autocert-email", os.Getenv("TLS_AUTOCERT_EMAIL"), "Contact email for the Let's Encrypt account")
+	flag.StringVar(&cfg.tls.redirectAddr, "tls-redirect-addr", os.Getenv("TLS_REDIRECT_ADDR"), "Listen address for the HTTP to HTTPS redirect, such as :80")
+
 	// fmt.Printf("intPort:\t%d\n", intHttpPort)
 	// fmt.Printf("cfg port:\t%d\n", cfg.port)
 
@@ -152,12 +390,30 @@ func main() {
 
 	// Read the connection pool settings from command-line flags into the config struct.
 	// The connection pool settings are used to configure the connection pool that the application will use to connect to the PostgreSQL database.
-	// The maxOpenConns setting is used to set the maximum number of open connections in the pool. and the maxIdleConns setting is used to set the maximum number of idle connections in the pool.
+	// The maxOpenConns setting is used to set the maximum number of open connections in the pool. and the minConns setting is used to set the number of connections the pool keeps open even when they are idle.
 	// The maxIdleTime setting is used to set the maximum amount of time that a connection can remain idle in the pool before it is closed and removed from the pool.
+	// The healthCheckPeriod setting is how often the pool checks its idle connections, closing the ones which are broken or have been idle too long.
 
 	flag.IntVar(&cfg.db.maxOpenConns, "db-max-open-conns", 25, "PostgreSQL max open connections")
-	flag.IntVar(&cfg.db.maxIdleConns, "db-max-idle-conns", 25, "PostgreSQL max idle connections")
+	flag.IntVar(&cfg.db.minConns, "db-min-conns", 0, "PostgreSQL min connections kept open")
 	flag.StringVar(&cfg.db.maxIdleTime, "db-max-idle-time", "15m", "PostgreSQL max connection idle time")
+	flag.DurationVar(&cfg.db.healthCheckPeriod, "db-health-check-period", time.Minute, "PostgreSQL idle connection health check interval")
+
+	// Read the query timeouts into the config struct. The read and write timeouts cancel a query from the application,
+	// the statement timeout is set on every connection so the server also gives up on a statement which runs too long,
+	// for instance when the application's cancellation doesn't reach it. It must be at least the longest query timeout
+	flag.DurationVar(&cfg.db.readTimeout, "db-read-timeout", data.ReadTimeout, "PostgreSQL read query timeout")
+	flag.DurationVar(&cfg.db.writeTimeout, "db-write-timeout", data.WriteTimeout, "PostgreSQL write query timeout")
+	flag.DurationVar(&cfg.db.statementTimeout, "db-statement-timeout", 30*time.Second, "PostgreSQL statement_timeout of every connection")
+
+	// Read the circuit breaker settings into the config struct. After the threshold of consecutive failures to get a
+	// connection the requests which need the database get a 503 without trying, until a probe after the cooldown succeeds
+	flag.IntVar(&cfg.db.breakerThreshold, "db-breaker-threshold", 5, "Consecutive PostgreSQL connection failures which open the circuit breaker (0 disables)")
+	flag.DurationVar(&cfg.db.breakerCooldown, "db-breaker-cooldown", 10*time.Second, "How long the PostgreSQL circuit breaker stays open before probing")
+
+	// Read the schema check mode into the config struct. In strict mode the server refuses to start when the embedded
+	// migrations don't match the database schema, in warn mode it logs the problems and reports them on /v1/readyz
+	flag.StringVar(&cfg.db.schemaCheck, "db-schema-check", "warn", "Action on embedded migration/schema mismatch (strict|warn)")
 
 	// The rate limiter middleware is used to limit the number of requests that a client can make to the API within a given time window.
 	// The rate limiter settings are used to configure the rate limiter middleware. settings from command-line flags into the config struct.
@@ -165,14 +421,49 @@ func main() {
 	flag.IntVar(&cfg.limiter.burst, "limit-burst", 4, "Rte limiter maximum burst")
 	flag.BoolVar(&cfg.limiter.enabled, "limiter-enabled", true, "Enable rate limiter")
 
-	smtpPort, _ := strconv.Atoi(os.Getenv("SMTP_PORT"))
-	// Read the SMTP server settings from command-line flags into the config struct.
-	// The SMTP server settings are used to configure the SMTP server that the application will use to send emails.
-	flag.StringVar(&cfg.smtp.host, "smtp-host", os.Getenv("SMTP_HOST"), "SMTP host")
-	flag.IntVar(&cfg.smtp.port, "smtp-port", smtpPort, "SMTP port")
-	flag.StringVar(&cfg.smtp.username, "smtp-username", os.Getenv("SMTP_USERNAME"), "SMTP username")
-	flag.StringVar(&cfg.smtp.password, "smtp-password", os.Getenv("SMTP_PASSWORD"), "SMTP password")
-	flag.StringVar(&cfg.smtp.sender, "smtp-sender", os.Getenv("SMTP_SENDER"), "SMTP sender")
+	smtpPort := envInt(configValidator, "SMTP_PORT", "smtp-port")
+	// Read the mail settings from command-line flags into the config struct. The emails are sent through the SMTP
+	// servers by default, failing over from one host to the next in the order they are listed, or through the HTTP API of SES, SendGrid or Mailgun where outbound SMTP is blocked. Only the
+	// settings of the selected provider are used, the sender address is used by all of them. The emails sent over SMTP are
+	// DKIM signed when a DKIM domain is set, the API providers sign the emails themselves. The dev provider doesn't send
+	// anything, it writes the emails to a directory and logs them so the signup flow can be tried locally
+	flag.StringVar(&cfg.mail.Provider, "mail-provider", envString("MAIL_PROVIDER", mailer.ProviderSMTP), "Mail provider (smtp|ses|sendgrid|mailgun|dev)")
+	cfg.mail.SMTPHosts = splitList(os.Getenv("SMTP_HOST"))
+	flag.Func("smtp-host", "SMTP hosts in priority order, each may include a port (comma-separated)", func(val string) error {
+		cfg.mail.SMTPHosts = splitList(val)
+		return nil
+	})
+	flag.IntVar(&cfg.mail.SMTPPort, "smtp-port", smtpPort, "SMTP port of the hosts which don't include one")
+	flag.StringVar(&cfg.mail.SMTPUsername, "smtp-username", os.Getenv("SMTP_USERNAME"), "SMTP username")
+	flag.StringVar(&cfg.mail.SMTPPassword, "smtp-password", os.Getenv("SMTP_PASSWORD"), "SMTP password")
+	flag.IntVar(&cfg.mail.SMTPFailureThreshold, "smtp-failure-threshold", 3, "Consecutive connection failures after which an SMTP host is skipped")
+	flag.DurationVar(&cfg.mail.SMTPHostCooldown, "smtp-host-cooldown", time.Minute, "How long a failing SMTP host is skipped for before it is tried again")
+	flag.StringVar(&cfg.mail.Sender, "smtp-sender", os.Getenv("SMTP_SENDER"), "Email sender, used by every mail provider")
+	flag.StringVar(&cfg.mail.DKIMDomain, "dkim-domain", os.Getenv("DKIM_DOMAIN"), "Domain the emails sent over SMTP are DKIM signed for")
+	flag.StringVar(&cfg.mail.DKIMSelector, "dkim-selector", os.Getenv("DKIM_SELECTOR"), "DKIM selector of the DNS record holding the public key")
+	flag.StringVar(&cfg.mail.DKIMKeyFile, "dkim-private-key-file", os.Getenv("DKIM_PRIVATE_KEY_FILE"), "DKIM RSA or Ed25519 private key file (PEM)")
+	flag.StringVar(&cfg.mail.SESRegion, "ses-region", envString("SES_REGION", "us-east-1"), "Amazon SES region")
+	flag.StringVar(&cfg.mail.SESAccessKey, "ses-access-key", os.Getenv("SES_ACCESS_KEY"), "Amazon SES access key ID")
+	flag.StringVar(&cfg.mail.SESSecretKey, "ses-secret-key", os.Getenv("SES_SECRET_KEY"), "Amazon SES secret access key")
+	flag.StringVar(&cfg.mail.SendGridAPIKey, "sendgrid-api-key", os.Getenv("SENDGRID_API_KEY"), "SendGrid API key")
+	flag.StringVar(&cfg.mail.MailgunBaseURL, "mailgun-base-url", envString("MAILGUN_BASE_URL", mailer.DefaultMailgunBaseURL), "Mailgun API base URL")
+	flag.StringVar(&cfg.mail.MailgunDomain, "mailgun-domain", os.Getenv("MAILGUN_DOMAIN"), "Mailgun sending domain")
+	flag.StringVar(&cfg.mail.MailgunAPIKey, "mailgun-api-key", os.Getenv("MAILGUN_API_KEY"), "Mailgun API key")
+	flag.StringVar(&cfg.mail.DevDir, "mail-dev-dir", envString("MAIL_DEV_DIR", mailer.DefaultDevDir), "Directory the dev mail provider writes the emails to")
+
+	// Read the email queue settings into the config struct. The emails are queued in the database and sent by a
+	// worker, a failed email is retried with a jittered exponential backoff by a scheduled job which runs every retry
+	// interval. The attempt timeout bounds each attempt at sending an email through the mail provider
+	flag.DurationVar(&cfg.mail.Timeout, "email-attempt-timeout", 10*time.Second, "Timeout of each attempt at sending an email")
+	flag.DurationVar(&cfg.emails.retryBackoff, "email-retry-backoff", 30*time.Second, "Wait before the first retry of a failed email")
+	flag.DurationVar(&cfg.emails.retryInterval, "email-retry-interval", 30*time.Second, "How often queued emails which are due are sent")
+	flag.IntVar(&cfg.emails.maxAttempts, "email-max-attempts", 8, "Attempts made at sending an email before it is given up on")
+
+	// Read the activation email throttling settings into the config struct. Anyone can ask for an activation email to
+	// be sent to an account which isn't activated, so the emails are limited per user to stop a victim's inbox being
+	// flooded with them
+	flag.DurationVar(&cfg.activation.resendCooldown, "activation-resend-cooldown", 5*time.Minute, "Minimum wait between activation emails sent to a user")
+	flag.IntVar(&cfg.activation.resendDailyLimit, "activation-resend-daily-limit", 5, "Maximum activation emails sent to a user in a day")
 
 	// use teh flag.Func to process the cors-trusted-origins flag. use strings fields to split the space-separated list of origins into a slice of strings and assign it to the config struct.
 	// if the flag is not provided, i.e empty string, white space, the trustedOrigins field will be an empty slice.
@@ -182,6 +473,168 @@ func main() {
 		return nil
 	})
 
+	// the other CORS settings are space-separated lists too. The defaults allow the methods and headers the API uses,
+	// and let the browser scripts read the ETag, the Location of a created resource, whether a response was replayed for
+	// an Idempotency-Key and the request ID
+	cfg.cors.allowedMethods = []string{"GET", "POST", "PUT", "PATCH", "DELETE"}
+	cfg.cors.allowedHeaders = []string{"Authorization", "Content-Type", "Idempotency-Key", "If-Match", "If-None-Match", "X-Request-ID"}
+	cfg.cors.exposedHeaders = []string{"Deprecation", "ETag", "Idempotent-Replayed", "Link", "Location", "Retry-After", "Sunset", "X-RateLimit-Limit", "X-RateLimit-Remaining", "X-RateLimit-Reset", "X-Request-ID"}
+	flag.Func("cors-allowed-methods", "Methods allowed in CORS requests (space-separated)", func(val string) error {
+		cfg.cors.allowedMethods = strings.Fields(val)
+		return nil
+	})
+	flag.Func("cors-allowed-headers", "Request headers allowed in CORS requests (space-separated)", func(val string) error {
+		cfg.cors.allowedHeaders = strings.Fields(val)
+		return nil
+	})
+	flag.Func("cors-exposed-headers", "Response headers exposed to CORS requests (space-separated)", func(val string) error {
+		cfg.cors.exposedHeaders = strings.Fields(val)
+		return nil
+	})
+	flag.DurationVar(&cfg.cors.maxAge, "cors-max-age", 0, "How long browsers may cache CORS preflight responses")
+	flag.BoolVar(&cfg.cors.allowCredentials, "cors-allow-credentials", false, "Allow CORS requests with credentials")
+
+	// Read the audit log retention settings from command-line flags into the config struct.
+	// Audit events older than the retention period for their category are deleted by a scheduled job which runs every retention interval.
+	flag.DurationVar(&cfg.audit.authRetention, "audit-auth-retention", data.DefaultAuditRetention[data.AuditCategoryAuth], "Audit log retention for authentication events")
+	flag.DurationVar(&cfg.audit.contentRetention, "audit-content-retention", data.DefaultAuditRetention[data.AuditCategoryContent], "Audit log retention for content edit events")
+	flag.DurationVar(&cfg.audit.retentionInterval, "audit-retention-interval", time.Hour, "How often expired audit events are deleted")
+
+	// Read the account deletion settings from command-line flags into the config struct.
+	// An account deleted by its user is kept for the grace period, during which the deletion can be cancelled, and then
+	// permanently deleted by a scheduled job which runs every deletion interval.
+	flag.DurationVar(&cfg.accounts.deletionGracePeriod, "account-deletion-grace-period", 30*24*time.Hour, "How long a deleted account can be restored for")
+	flag.DurationVar(&cfg.accounts.deletionInterval, "account-deletion-interval", time.Hour, "How often accounts past their deletion grace period are deleted")
+
+	// Read the token cleanup settings into the config struct. Expired tokens can't be used any more, a scheduled job
+	// which runs every cleanup interval deletes them so they don't pile up in the tokens table
+	flag.DurationVar(&cfg.tokens.cleanupInterval, "token-cleanup-interval", time.Hour, "How often expired tokens are deleted")
+
+	// Read the movie view settings into the config struct. The views of the movie details are buffered in memory and
+	// written every flush interval, and the counts older than the retention, which is the longest window the trending
+	// movies can be ranked over, are deleted by a scheduled job
+	flag.DurationVar(&cfg.views.flushInterval, "views-flush-interval", 10*time.Second, "How often the buffered movie views are written")
+	flag.DurationVar(&cfg.views.retention, "views-retention", 30*24*time.Hour, "How long movie view counts are kept")
+	flag.DurationVar(&cfg.views.cleanupInterval, "views-cleanup-interval", time.Hour, "How often old movie view counts are deleted")
+
+	// Read the idempotency key settings into the config struct. The response to a request with an Idempotency-Key
+	// header is replayed for the retries of the request for the TTL, then deleted by a scheduled job
+	flag.DurationVar(&cfg.idempotency.ttl, "idempotency-key-ttl", 24*time.Hour, "How long the response to a request with an Idempotency-Key is replayed for")
+	flag.DurationVar(&cfg.idempotency.cleanupInterval, "idempotency-cleanup-interval", time.Hour, "How often expired idempotency keys are deleted")
+
+	// Read the password policy from command-line flags into the config struct.
+	// The strength of new passwords is estimated with zxcvbn, which scores them from 0 (too guessable) to 4 (very unguessable).
+	flag.IntVar(&cfg.password.minScore, "password-min-score", data.MinPasswordScore, "Minimum zxcvbn strength score of new passwords (0-4)")
+
+	// Read the password hashing settings from command-line flags into the config struct.
+	// Passwords hashed with another algorithm or other parameters are still accepted, and hashed again when their users log in.
+	flag.StringVar(&cfg.password.hasher, "password-hasher", envString("PASSWORD_HASHER", pwhash.AlgorithmBcrypt), "Password hashing algorithm (bcrypt|argon2id)")
+	flag.IntVar(&cfg.password.bcryptCost, "bcrypt-cost", pwhash.DefaultBcryptCost, "bcrypt cost")
+	flag.IntVar(&cfg.password.argon2Time, "argon2-time", pwhash.DefaultArgon2Time, "argon2id number of passes")
+	flag.IntVar(&cfg.password.argon2Memory, "argon2-memory", pwhash.DefaultArgon2Memory, "argon2id memory in KiB")
+	flag.IntVar(&cfg.password.argon2Threads, "argon2-threads", pwhash.DefaultArgon2Threads, "argon2id degree of parallelism")
+
+	// Read the error tracker DSN into the config struct. Unexpected errors and panics are reported to it with the
+	// request ID, user and route of the request they happened in
+	flag.StringVar(&cfg.errtrack.dsn, "errtrack-dsn", os.Getenv("SENTRY_DSN"), "Sentry-compatible error tracker DSN")
+
+	// Read the SIEM forwarder settings from command-line flags into the config struct.
+	// Security events are always stored in the database, the forwarder optionally ships a copy of each event to a syslog server or HTTP collector.
+	flag.StringVar(&cfg.siem.forwarder, "siem-forwarder", os.Getenv("SIEM_FORWARDER"), "Security event forwarder (none|syslog|http)")
+	flag.StringVar(&cfg.siem.address, "siem-address", os.Getenv("SIEM_ADDRESS"), "Security event forwarder address (syslog host:port or HTTP URL)")
+
+	// Read the authentication token strategy into the config struct. By default the tokens are random strings whose hash
+	// is stored in the database and looked up on every request. With the jwt strategy they are signed JWTs which are
+	// checked without a query, at the cost of not being revocable before they expire, so they are given a shorter TTL
+	flag.StringVar(&cfg.auth.tokenStrategy, "auth-token-strategy", envString("AUTH_TOKEN_STRATEGY", "database"), "Authentication token strategy (database|jwt)")
+	flag.StringVar(&cfg.jwt.algorithm, "jwt-algorithm", envString("JWT_ALGORITHM", "HS256"), "JWT signing algorithm (HS256|EdDSA)")
+	flag.StringVar(&cfg.jwt.secret, "jwt-secret", os.Getenv("JWT_SECRET"), "JWT HS256 signing secret")
+	flag.StringVar(&cfg.jwt.privateKeyFile, "jwt-private-key-file", os.Getenv("JWT_PRIVATE_KEY_FILE"), "JWT EdDSA private key file (PEM)")
+	flag.StringVar(&cfg.jwt.issuer, "jwt-issuer", "greenlight", "JWT issuer claim")
+	flag.DurationVar(&cfg.jwt.ttl, "jwt-ttl", time.Hour, "How long a JWT authentication token is valid for")
+
+	// Read the WebAuthn relying party settings from command-line flags into the config struct. These are used for passkey registration and login.
+	// The origins are a space-separated list, in the same way as the CORS trusted origins
+	cfg.webauthn.rpOrigins = strings.Fields(os.Getenv("WEBAUTHN_RP_ORIGINS"))
+	flag.StringVar(&cfg.webauthn.rpID, "webauthn-rp-id", os.Getenv("WEBAUTHN_RP_ID"), "WebAuthn relying party ID (domain)")
+	flag.StringVar(&cfg.webauthn.rpDisplayName, "webauthn-rp-display-name", "Greenlight", "WebAuthn relying party display name")
+	flag.Func("webauthn-rp-origins", "WebAuthn relying party origins (space-separated)", func(val string) error {
+		cfg.webauthn.rpOrigins = strings.Fields(val)
+		return nil
+	})
+
+	// Read the TOTP issuer into the config struct. It is shown next to the user's email in their authenticator app
+	flag.StringVar(&cfg.totp.issuer, "totp-issuer", "Greenlight", "Issuer name shown in TOTP authenticator apps")
+
+	// Read the social login clients into the config struct. Each provider is only enabled when its client ID is set, the
+	// callback URL to register with the provider is <sso-base-url>/v1/oauth/<provider>/callback
+	flag.StringVar(&cfg.oauth.googleClientID, "oauth-google-client-id", os.Getenv("OAUTH_GOOGLE_CLIENT_ID"), "Google OAuth client ID")
+	flag.StringVar(&cfg.oauth.googleClientSecret, "oauth-google-client-secret", os.Getenv("OAUTH_GOOGLE_CLIENT_SECRET"), "Google OAuth client secret")
+	flag.StringVar(&cfg.oauth.githubClientID, "oauth-github-client-id", os.Getenv("OAUTH_GITHUB_CLIENT_ID"), "GitHub OAuth client ID")
+	flag.StringVar(&cfg.oauth.githubClientSecret, "oauth-github-client-secret", os.Getenv("OAUTH_GITHUB_CLIENT_SECRET"), "GitHub OAuth client secret")
+
+	// Read the SCIM bearer token into the config struct. The SCIM provisioning endpoints are disabled when no token is set
+	flag.StringVar(&cfg.scim.token, "scim-token", os.Getenv("SCIM_TOKEN"), "SCIM provisioning bearer token")
+
+	// Read the public base URL of the API into the config struct. The identity providers redirect users back to
+	// <base-url>/v1/sso/<slug>/callback after a single sign-on login
+	flag.StringVar(&cfg.sso.baseURL, "sso-base-url", os.Getenv("SSO_BASE_URL"), "Public base URL used for SSO redirects")
+
+	// Read the OpenAPI settings into the config struct. The specification is always served, the Swagger UI page is optional
+	flag.BoolVar(&cfg.openapi.ui, "openapi-ui", false, "Serve the Swagger UI for the OpenAPI specification at /v1/docs")
+
+	// Read the JSON:API setting into the config struct. The clients can always ask for JSON:API with the Accept header,
+	// this makes it the format of the responses to the clients which don't ask for one
+	flag.BoolVar(&cfg.jsonapi.defaultFormat, "jsonapi-default", false, "Render responses as JSON:API unless the client asks for another format")
+
+	// Read the deprecation schedule of v1 into the config struct. Once the deprecation date is set the v1 responses tell
+	// the clients to move to v2 with the Deprecation header, and the Sunset header once the date it goes away is set
+	flag.Func("v1-deprecation-date", "Date v1 of the API was deprecated (YYYY-MM-DD)", func(val string) error {
+		return parseConfigDate(val, &cfg.versions.v1Deprecation)
+	})
+	flag.Func("v1-sunset-date", "Date v1 of the API will be turned off (YYYY-MM-DD)", func(val string) error {
+		return parseConfigDate(val, &cfg.versions.v1Sunset)
+	})
+
+	// Read the file storage settings into the config struct. Uploaded posters are written to a local directory by default,
+	// or to a bucket of any S3-compatible object store
+	flag.StringVar(&cfg.storage.Backend, "storage-backend", envString("STORAGE_BACKEND", "local"), "File storage backend (local|s3)")
+	flag.StringVar(&cfg.storage.LocalDir, "storage-local-dir", envString("STORAGE_LOCAL_DIR", "./uploads"), "Directory for the local file storage backend")
+	flag.StringVar(&cfg.storage.S3Endpoint, "storage-s3-endpoint", os.Getenv("STORAGE_S3_ENDPOINT"), "S3-compatible storage endpoint URL")
+	flag.StringVar(&cfg.storage.S3Region, "storage-s3-region", envString("STORAGE_S3_REGION", "us-east-1"), "S3 storage region")
+	flag.StringVar(&cfg.storage.S3Bucket, "storage-s3-bucket", os.Getenv("STORAGE_S3_BUCKET"), "S3 storage bucket")
+	flag.StringVar(&cfg.storage.S3AccessKey, "storage-s3-access-key", os.Getenv("STORAGE_S3_ACCESS_KEY"), "S3 storage access key ID")
+	flag.StringVar(&cfg.storage.S3SecretKey, "storage-s3-secret-key", os.Getenv("STORAGE_S3_SECRET_KEY"), "S3 storage secret access key")
+
+	// Read the cache settings into the config struct. The movies looked up by ID are kept in memory by default, which
+	// each instance of the API does on its own, or in a Redis server shared by all of them
+	flag.StringVar(&cfg.cache.Backend, "cache-backend", envString("CACHE_BACKEND", "memory"), "Movie cache backend (none|memory|redis)")
+	flag.DurationVar(&cfg.cache.TTL, "cache-ttl", time.Minute, "How long a movie is cached for")
+	flag.IntVar(&cfg.cache.MaxEntries, "cache-max-entries", 10000, "Most movies kept by the memory cache backend")
+	flag.StringVar(&cfg.cache.RedisURL, "cache-redis-url", os.Getenv("CACHE_REDIS_URL"), "Redis URL for the redis cache backend")
+
+	// Read the TMDB settings into the config struct. Movies can only be imported from TMDB when an API key is set
+	flag.StringVar(&cfg.tmdb.apiKey, "tmdb-api-key", os.Getenv("TMDB_API_KEY"), "The Movie Database API key")
+	flag.StringVar(&cfg.tmdb.baseURL, "tmdb-base-url", envString("TMDB_BASE_URL", moviemeta.DefaultTMDBBaseURL), "The Movie Database API base URL")
+
+	// Read the webhook settings into the config struct. A failed delivery is retried with an exponential backoff, the
+	// retries are sent by a scheduled job which runs every retry interval
+	flag.DurationVar(&cfg.webhooks.timeout, "webhook-timeout", 10*time.Second, "Webhook delivery timeout")
+	flag.DurationVar(&cfg.webhooks.retryBackoff, "webhook-retry-backoff", 30*time.Second, "Wait before the first retry of a failed webhook delivery")
+	flag.DurationVar(&cfg.webhooks.retryInterval, "webhook-retry-interval", 30*time.Second, "How often webhook deliveries which are due a retry are sent")
+	flag.IntVar(&cfg.webhooks.maxAttempts, "webhook-max-attempts", 8, "Attempts made at a webhook delivery before it is given up on")
+
+	// Read the OpenTelemetry settings into the config struct. The environment variables are the standard ones read by
+	// the OpenTelemetry SDKs, so the API can be configured the same way as the other services in a trace
+	flag.StringVar(&cfg.otel.endpoint, "otel-endpoint", os.Getenv("OTEL_EXPORTER_OTLP_ENDPOINT"), "OTLP/HTTP collector base URL, tracing is disabled when empty")
+	flag.StringVar(&cfg.otel.headers, "otel-headers", os.Getenv("OTEL_EXPORTER_OTLP_HEADERS"), "Headers sent to the OTLP collector (comma-separated key=value pairs)")
+	flag.StringVar(&cfg.otel.serviceName, "otel-service-name", envString("OTEL_SERVICE_NAME", "greenlight"), "Service name the traces are reported under")
+	flag.Float64Var(&cfg.otel.sampleRatio, "otel-sample-ratio", 1, "Fraction of new traces which are recorded (0-1)")
+
+	// Read the random seed into the config struct. Setting a seed makes every generated token reproducible, so it is only
+	// meant for test and sandbox environments and is refused in production
+	flag.Int64Var(&cfg.randomSeed, "random-seed", 0, "Seed for deterministic token generation (test environments only)")
+
 	// create a new version boolean flag with a default value of false
 	displayVersion := flag.Bool("version", false, "Display version and exit")
 
@@ -207,21 +660,41 @@ func main() {
 
 	var err error
 
+	// fall back to the local server address for the SSO redirects
+	if cfg.sso.baseURL == "" {
+		cfg.sso.baseURL = fmt.Sprintf("http://localhost:%d", cfg.port)
+	}
+
 	// assign cgf.db.dsn to the dsn variable
 	cfg.db.dsn = dsn
 
+	// validate the whole configuration and report all of the problems at once, each keyed by the flag and environment
+	// variable which supplies the value
+	if validateConfig(configValidator, cfg); !configValidator.Valid() {
+		logger.PrintFatal(errors.New("invalid configuration"), "errors", configValidator.Errors)
+	}
+
+	// the level has already been validated
+	level, _ := jsonlog.ParseLevel(cfg.logLevel)
+	logLevel.Set(level)
+
+	data.ReadTimeout = cfg.db.readTimeout
+	data.WriteTimeout = cfg.db.writeTimeout
+	data.MinPasswordScore = cfg.password.minScore
+	data.PasswordHasher = newPasswordHasher(cfg)
+
 	// cfg.port, err = strconv.Atoi(httpPort)
 	// if err != nil {
-	// 	logger.PrintFatal(err, map[string]string{"message": "Invalid value for HTTP_PORT"})
+	// 	logger.PrintFatal(err, "message", "Invalid value for HTTP_PORT")
 	// }
 	// cfg.db.maxIdleTime = dbMaxIdleTime
 	// cfg.db.maxIdleConns, err = strconv.Atoi(dbMaxIdleConns)
 	// if err != nil {
-	// 	logger.PrintFatal(err, map[string]string{"message": "Invalid value for DB_MAX_IDLE_CONNS"})
+	// 	logger.PrintFatal(err, "message", "Invalid value for DB_MAX_IDLE_CONNS")
 	// }
 	// cfg.db.maxOpenConns, err = strconv.Atoi(dbMaxOpenConns)
 	// if err != nil {
-	// 	logger.PrintFatal(err, map[string]string{"message": "Invalid value for DB_MAX_OPEN_CONNS"})
+	// 	logger.PrintFatal(err, "message", "Invalid value for DB_MAX_OPEN_CONNS")
 	// }
 
 	// assign the trusted origins to the config struct
@@ -233,25 +706,113 @@ func main() {
 	// add rate limiter settings from environment variables
 	// cfg.limiter.rps, err = strconv.ParseFloat(limiterRPS, 64)
 	// if err != nil {
-	// 	logger.PrintFatal(err, map[string]string{"message": "Invalid value for LIMITER_RPS"})
+	// 	logger.PrintFatal(err, "message", "Invalid value for LIMITER_RPS")
 	// }
 	// cfg.limiter.burst, err = strconv.Atoi(limiterBurst)
 	// if err != nil {
-	// 	logger.PrintFatal(err, map[string]string{"message": "Invalid value for LIMITER_BURST"})
+	// 	logger.PrintFatal(err, "message", "Invalid value for LIMITER_BURST")
 	// }
 	// cfg.limiter.enabled, err = strconv.ParseBool(limiterEnabled)
 	// if err != nil {
-	// 	logger.PrintFatal(err, map[string]string{"message": "Invalid value for LIMITER_ENABLED"})
+	// 	logger.PrintFatal(err, "message", "Invalid value for LIMITER_ENABLED")
 	// }
 
+	// everything which depends on the current time reads it from the clock, so tests can move time forward deterministically
+	clk := clock.New()
+
+	// the random bytes in generated tokens come from crypto/rand, unless a seed has been set for a reproducible test run
+	rnd := random.New()
+	if cfg.randomSeed != 0 {
+		rnd = random.NewSeeded(cfg.randomSeed)
+		logger.PrintInfo("using deterministic random source", "seed", cfg.randomSeed)
+	}
+
+	// record the spans of the HTTP requests, database queries and emails. The incoming traceparent headers are always
+	// passed on, the spans are only recorded and exported when an OTLP endpoint is configured
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{}))
+
+	if cfg.otel.endpoint != "" {
+		headers, _ := parseHeaders(cfg.otel.headers)
+
+		tp, err := tracing.New(tracing.Config{
+			Endpoint:       cfg.otel.endpoint,
+			Headers:        headers,
+			ServiceName:    cfg.otel.serviceName,
+			ServiceVersion: version,
+			SampleRatio:    cfg.otel.sampleRatio,
+			Timeout:        10 * time.Second,
+			BatchInterval:  5 * time.Second,
+		})
+		if err != nil {
+			logger.PrintFatal(err, "message", "Error creating the trace exporter")
+		}
+
+		// the SDK reports the batches it fails to export through the global error handler
+		otel.SetErrorHandler(otel.ErrorHandlerFunc(func(err error) {
+			logger.PrintError(err, "message", "Error exporting traces")
+		}))
+		otel.SetTracerProvider(tp)
+
+		// export the spans of the requests which were still running when the server shut down
+		defer func() {
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+
+			err := tp.Shutdown(ctx)
+			if err != nil {
+				logger.PrintError(err, "message", "Error exporting traces")
+			}
+		}()
+
+		logger.PrintInfo("tracing enabled", "endpoint", cfg.otel.endpoint)
+	}
+
+	// the circuit breaker stops the requests from waiting on the database once it has been failing for a while, they
+	// are answered with a 503 straight away until it recovers. A threshold of zero turns it off
+	var dbBreaker *breaker.Breaker
+	if cfg.db.breakerThreshold > 0 {
+		dbBreaker = breaker.New(cfg.db.breakerThreshold, cfg.db.breakerCooldown, clk)
+	}
+
 	// open a connection to the database and defer the close
-	db, err := openDB(cfg, automigrateBool)
+	db, err := openDB(cfg, automigrateBool, dbBreaker)
 	if err != nil {
-		logger.PrintFatal(err, map[string]string{"message": "Error opening database connection"})
+		logger.PrintFatal(err, "message", "Error opening database connection")
 	}
 
 	defer db.Close()
-	logger.PrintInfo("database connection pool established", nil)
+	logger.PrintInfo("database connection pool established")
+
+	// open the read replica's pool if one is configured. replica stays nil otherwise, and every query goes to the primary
+	var replica *sql.DB
+	if cfg.db.replicaDSN != "" {
+		replica, err = openReplica(cfg)
+		if err != nil {
+			logger.PrintFatal(err, "message", "Error opening read replica connection")
+		}
+
+		defer replica.Close()
+		logger.PrintInfo("read replica connection pool established")
+	}
+
+	// compare the embedded migrations with the schema in the database, so an old binary isn't served against a newer schema
+	schemaCheck, err := checkSchema(db)
+	if err != nil {
+		logger.PrintFatal(err, "message", "Error checking database schema")
+	}
+
+	if schemaCheck.Status != data.SchemaStatusOK {
+		properties := []any{
+			"database_version", schemaCheck.DatabaseVersion,
+			"embedded_version", schemaCheck.EmbeddedVersion,
+		}
+
+		if cfg.db.schemaCheck == "strict" {
+			logger.PrintFatal(errors.New(strings.Join(schemaCheck.Problems, "; ")), properties...)
+		}
+
+		logger.PrintError(errors.New(strings.Join(schemaCheck.Problems, "; ")), properties...)
+	}
 
 	// add a version variable to the expvar package to expose the application version
 	expvar.NewString("version").Set(version)
@@ -271,45 +832,148 @@ func main() {
 		return time.Now().Unix()
 	}))
 
+	// create the forwarder used to ship security events to the SIEM
+	forwarder, err := siem.New(cfg.siem.forwarder, cfg.siem.address)
+	if err != nil {
+		logger.PrintFatal(err, "message", "Error creating SIEM forwarder")
+	}
+
+	// create the reporter unexpected errors are sent to, and send the ones which are still queued before exiting
+	reporter, err := errtrack.New(errtrack.Config{
+		DSN:         cfg.errtrack.dsn,
+		Environment: cfg.env,
+		Release:     version,
+	})
+	if err != nil {
+		logger.PrintFatal(err, "message", "Error creating error tracker")
+	}
+	defer reporter.Flush(5 * time.Second)
+
+	// create the WebAuthn relying party used for passkey registration and login. Passkeys are only enabled when a relying party ID is configured
+	var wa *webauthn.WebAuthn
+	if cfg.webauthn.rpID != "" {
+		wa, err = webauthn.New(&webauthn.Config{
+			RPID:          cfg.webauthn.rpID,
+			RPDisplayName: cfg.webauthn.rpDisplayName,
+			RPOrigins:     cfg.webauthn.rpOrigins,
+		})
+		if err != nil {
+			logger.PrintFatal(err, "message", "Error creating WebAuthn relying party")
+		}
+	}
+
+	// create the signer of the authentication tokens when they are JWTs
+	var signer *jwtauth.Signer
+	if cfg.auth.tokenStrategy == "jwt" {
+		signer, err = jwtauth.New(jwtauth.Config{
+			Algorithm:      cfg.jwt.algorithm,
+			Secret:         cfg.jwt.secret,
+			PrivateKeyFile: cfg.jwt.privateKeyFile,
+			Issuer:         cfg.jwt.issuer,
+		}, clk)
+		if err != nil {
+			logger.PrintFatal(err, "message", "Error creating JWT signer")
+		}
+	}
+
+	// create the social login providers which have a client configured
+	oauthProviders, err := newOAuthProviders(cfg)
+	if err != nil {
+		logger.PrintFatal(err, "message", "Error creating OAuth providers")
+	}
+
+	// create the mailer for the configured provider
+	mail, err := mailer.New(cfg.mail, clk)
+	if err != nil {
+		logger.PrintFatal(err, "message", "Error creating mailer")
+	}
+
+	// create the storage backend uploaded posters are kept in
+	store, err := storage.New(cfg.storage)
+	if err != nil {
+		logger.PrintFatal(err, "message", "Error creating file storage")
+	}
+
+	// create the cache the movies are read through, nil when caching is turned off
+	movieCache, err := cache.New(cfg.cache, clk)
+	if err != nil {
+		logger.PrintFatal(err, "message", "Error creating cache")
+	}
+
+	models := data.NewModels(db, replica, clk, rnd)
+	if movieCache != nil {
+		models.CacheMovies(movieCache, cfg.cache.TTL)
+	}
+
+	// create the client for The Movie Database, which movies can be imported from when an API key is configured
+	var tmdb *moviemeta.TMDB
+	if cfg.tmdb.apiKey != "" {
+		tmdb = moviemeta.NewTMDB(cfg.tmdb.baseURL, cfg.tmdb.apiKey)
+	}
+
 	// create a new application struct and pass all the dependencies
 	app := &application{
-		config: cfg,
-		logger: logger,
-		models: data.NewModels(db),
-		mailer: mailer.New(cfg.smtp.host, cfg.smtp.port, cfg.smtp.username, cfg.smtp.password, cfg.smtp.sender), // use this when using command line flags
-		// mailer: mailer.New(cfg.smtp.host, cfg.smtp.port, cfg.smtp.username, cfg.smtp.password, cfg.smtp.sender), // use this when using environment variables
+		config:      cfg,
+		logger:      logger,
+		logLevel:    logLevel,
+		models:      models,
+		db:          db,
+		mailer:      mail,
+		siem:        forwarder,
+		errtrack:    reporter,
+		webauthn:    wa,
+		oauth:       oauthProviders,
+		jwt:         signer,
+		clock:       clk,
+		random:      rnd,
+		storage:     store,
+		tmdb:        tmdb,
+		webhooks:    webhook.NewSender(cfg.webhooks.timeout),
+		instruments: newAppMetrics(db, dbBreaker),
+		dbBreaker:   dbBreaker,
+		views:       newViewCounter(),
 	}
 
+	// register the periodic background jobs and start running them on their schedules
+	app.jobs = jobs.New(clk, logger, app.instruments.registry)
+	app.jobs.Register("audit_retention", cfg.audit.retentionInterval, app.enforceAuditRetention)
+	app.jobs.Register("webhook_deliveries", cfg.webhooks.retryInterval, app.deliverWebhooks)
+	app.jobs.Register("email_deliveries", cfg.emails.retryInterval, app.sendEmails)
+	app.jobs.Register("account_deletions", cfg.accounts.deletionInterval, app.deleteDueAccounts)
+	app.jobs.Register("token_cleanup", cfg.tokens.cleanupInterval, app.deleteExpiredTokens)
+	app.jobs.Register("idempotency_key_cleanup", cfg.idempotency.cleanupInterval, app.deleteExpiredIdempotencyKeys)
+	app.jobs.Register("movie_view_flush", cfg.views.flushInterval, app.flushMovieViews)
+	app.jobs.Register("movie_view_cleanup", cfg.views.cleanupInterval, app.deleteOldMovieViews)
+	app.jobs.Start()
+
+	// queue the webhook deliveries for every change made to the movies
+	app.models.OnMovieChanged(app.emitWebhookEvent)
+
 	// call the serve method on the application struct
 	err = app.serve()
 	if err != nil {
-		logger.PrintFatal(err, map[string]string{"message": "server shutdown with error"})
+		logger.PrintFatal(err, "message", "server shutdown with error")
 	}
 
 }
 
-// openDB opens a new database connection using the provided DSN. It returns a sql.DB connection pool.
-func openDB(cfg config, autoMigrate bool) (*sql.DB, error) {
-	// Open a sql.DB connection pool
-	db, err := sql.Open("postgres", cfg.db.dsn)
+// checkSchema compares the checksums of the migrations embedded in the binary with the migrations applied to the database
+func checkSchema(db *sql.DB) (*data.SchemaCheck, error) {
+	checksums, err := data.MigrationChecksums(assets.EmbeddedFiles, "migration")
 	if err != nil {
 		return nil, err
 	}
 
-	// Set the maximum number of open (in-use + idle) connections in the pool.
-	db.SetMaxOpenConns(cfg.db.maxOpenConns)
-
-	// Set the maximum number of idle connections in the pool.
-	db.SetMaxIdleConns(cfg.db.maxIdleConns)
+	return data.CheckSchema(db, checksums)
+}
 
-	duration, err := time.ParseDuration(cfg.db.maxIdleTime)
+// openDB opens a new database connection using the provided DSN. It returns a sql.DB connection pool.
+func openDB(cfg config, autoMigrate bool, dbBreaker *breaker.Breaker) (*sql.DB, error) {
+	db, err := openPool(cfg, cfg.db.dsn, false, dbBreaker)
 	if err != nil {
 		return nil, err
 	}
 
-	// set the maximum idle timeout
-	db.SetConnMaxIdleTime(duration)
-
 	// run automigrate if the autoMigrate flag is true
 	if autoMigrate {
 		iofsDriver, err := iofs.New(assets.EmbeddedFiles, "migration")
@@ -317,7 +981,8 @@ func openDB(cfg config, autoMigrate bool) (*sql.DB, error) {
 			return nil, err
 		}
 
-		migrator, err := migrate.NewWithSourceInstance("iofs", iofsDriver, cfg.db.dsn)
+		// the migrations are run with migrate's pgx driver, which is chosen by the pgx5 scheme
+		migrator, err := migrate.NewWithSourceInstance("iofs", iofsDriver, migrationURL(cfg.db.dsn))
 		if err != nil {
 			return nil, err
 		}
@@ -345,3 +1010,83 @@ func openDB(cfg config, autoMigrate bool) (*sql.DB, error) {
 	// return the sql.DB connection pool
 	return db, nil
 }
+
+// openReplica opens the connection pool of the read replica. The replica isn't pinged, since the reads fall back to
+// the primary while it can't be reached, so it being down shouldn't stop the application from starting
+func openReplica(cfg config) (*sql.DB, error) {
+	return openPool(cfg, cfg.db.replicaDSN, true, nil)
+}
+
+// openPool opens a connection pool with the pool settings from the config. The connections of a read only pool refuse
+// to write, so a write which is sent to the replica by mistake fails rather than going unnoticed. If a circuit breaker
+// is given, the pool stops handing out connections while it is open
+func openPool(cfg config, dsn string, readOnly bool, dbBreaker *breaker.Breaker) (*sql.DB, error) {
+	// the connections are pooled by pgxpool, which checks the idle ones in the background, and database/sql borrows them
+	// from it so the models keep running their queries through a sql.DB
+	poolConfig, err := pgxpool.ParseConfig(dsn)
+	if err != nil {
+		return nil, err
+	}
+
+	duration, err := time.ParseDuration(cfg.db.maxIdleTime)
+	if err != nil {
+		return nil, err
+	}
+
+	// Set the maximum number of open (in-use + idle) connections in the pool, and the number kept open when idle.
+	poolConfig.MaxConns = int32(cfg.db.maxOpenConns)
+	poolConfig.MinConns = int32(cfg.db.minConns)
+
+	// set the maximum idle timeout and how often the idle connections are checked
+	poolConfig.MaxConnIdleTime = duration
+	poolConfig.HealthCheckPeriod = cfg.db.healthCheckPeriod
+
+	// the server cancels any statement which runs longer than the statement timeout, the value is in milliseconds
+	poolConfig.ConnConfig.RuntimeParams["statement_timeout"] = strconv.FormatInt(cfg.db.statementTimeout.Milliseconds(), 10)
+
+	if readOnly {
+		poolConfig.ConnConfig.RuntimeParams["default_transaction_read_only"] = "on"
+	}
+
+	pool, err := pgxpool.NewWithConfig(context.Background(), poolConfig)
+	if err != nil {
+		return nil, err
+	}
+
+	var connector driver.Connector = poolConnector{Connector: stdlib.GetPoolConnector(pool), pool: pool}
+	if dbBreaker != nil {
+		connector = breaker.WrapConnector(connector, dbBreaker)
+	}
+
+	// Open a sql.DB connection pool on top of pgxpool
+	// the connector records a span for every query, see the internal/tracing package
+	db := sql.OpenDB(tracing.WrapConnector(connector))
+
+	// the idle connections are kept by pgxpool, so database/sql must not hold on to any of them itself
+	db.SetMaxOpenConns(cfg.db.maxOpenConns)
+	db.SetMaxIdleConns(0)
+
+	return db, nil
+}
+
+// poolConnector closes the pgxpool when the sql.DB on top of it is closed
+type poolConnector struct {
+	driver.Connector
+	pool *pgxpool.Pool
+}
+
+func (c poolConnector) Close() error {
+	c.pool.Close()
+	return nil
+}
+
+// migrationURL returns the postgres:// connection URL with the pgx5 scheme, which selects migrate's pgx driver
+func migrationURL(dsn string) string {
+	for _, scheme := range []string{"postgres://", "postgresql://"} {
+		if strings.HasPrefix(dsn, scheme) {
+			return "pgx5://" + strings.TrimPrefix(dsn, scheme)
+		}
+	}
+
+	return dsn
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/nytro04/greenlight/internal/data"
+	"github.com/nytro04/greenlight/internal/validator"
+)
+
+// showMeHandler returns the authenticated user's own record. It is read from the database rather than the request
+// context, since the user held in the context of a request made with a signed JWT is only what the token carries
+func (app *application) showMeHandler(w http.ResponseWriter, r *http.Request) {
+	user, err := app.models.Users.GetByID(r.Context(), app.contextGetUser(r).ID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeResponseWithETag(w, r, http.StatusOK, envelope{"user": user}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// updateMeHandler partially updates the authenticated user's name. The email address can't be changed here, as a new
+// address must be confirmed first; that goes through PUT /v1/me/email. A client which sends If-Match with the ETag
+// from GET /v1/me gets a 412 if the record has changed since
+func (app *application) updateMeHandler(w http.ResponseWriter, r *http.Request) {
+	user, err := app.models.Users.GetByID(r.Context(), app.contextGetUser(r).ID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	if !app.checkIfMatch(w, r, envelope{"user": user}) {
+		return
+	}
+
+	var input struct {
+		Name  *string `json:"name"`
+		Email *string `json:"email"`
+	}
+
+	err = app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+
+	v.Check(input.Email == nil, "email", "can't be changed here, use PUT /v1/me/email to confirm the new address")
+
+	if input.Name != nil {
+		user.Name = *input.Name
+	}
+
+	if data.ValidateUser(v, user); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = app.models.Users.Update(r.Context(), user)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrEditConflict):
+			app.editConflictResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	app.recordAudit(r, data.AuditCategoryAuth, "profile_updated", user.Email)
+
+	err = app.writeResponseWithETag(w, r, http.StatusOK, envelope{"user": user}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/nytro04/greenlight/internal/data"
+)
+
+// metaLimitsHandler returns the operational limits clients must respect. The values are read from the running
+// configuration, the same constants the handlers enforce and the quota tiers in the database, so they can't drift from
+// the real behaviour. Durations are given in seconds
+func (app *application) metaLimitsHandler(w http.ResponseWriter, r *http.Request) {
+	seconds := func(d time.Duration) int64 {
+		return int64(d / time.Second)
+	}
+
+	tokenTTLs := map[string]int64{
+		data.ScopeAuthentication: seconds(authenticationTokenTTL),
+		data.ScopeActivation:     seconds(activationTokenTTL),
+		data.ScopeEmailChange:    seconds(emailChangeTokenTTL),
+		"sso_state":              seconds(ssoStateTTL),
+	}
+
+	if app.jwt != nil {
+		tokenTTLs[data.ScopeAuthentication] = seconds(app.config.jwt.ttl)
+	}
+
+	if app.webauthn != nil {
+		tokenTTLs["webauthn_session"] = seconds(webauthnSessionTTL)
+	}
+
+	tiers, err := app.models.Quotas.GetTiers(r.Context())
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	limits := envelope{
+		"max_request_body_bytes": maxRequestBodyBytes,
+		// applied to each client IP address across all endpoints
+		"rate_limits": envelope{
+			"enabled": app.config.limiter.enabled,
+			"tiers": envelope{
+				"ip": envelope{
+					"requests_per_second": app.config.limiter.rps,
+					"burst":               app.config.limiter.burst,
+				},
+			},
+		},
+		// the daily and monthly number of requests an API key can make, depending on its tier
+		"api_key_quotas": envelope{
+			"default_tier": data.DefaultQuotaTier,
+			"tiers":        tiers,
+		},
+		"pagination": envelope{
+			"max_page":      data.MaxPage,
+			"max_page_size": data.MaxPageSize,
+		},
+		"movies": envelope{
+			"max_genres":      data.MaxMovieGenres,
+			"max_batch_size":  maxBatchMovies,
+			"max_import_rows": maxImportRows,
+		},
+		"token_ttl_seconds": tokenTTLs,
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"limits": limits}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"net/http"
+	"strconv"
+)
+
+// headHandler serves a HEAD request with the handler of the GET route, so the response has the same status and
+// headers, including the Content-Length of the body the GET request would have been sent, but no body
+func headHandler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hw := &headResponseWriter{ResponseWriter: w}
+
+		next.ServeHTTP(hw, r)
+
+		hw.finish()
+	})
+}
+
+// headResponseWriter counts and discards the body written by a GET handler, and holds the status back until the
+// handler returns so the Content-Length header can still be set
+type headResponseWriter struct {
+	http.ResponseWriter
+	status int
+	length int
+}
+
+func (hw *headResponseWriter) WriteHeader(status int) {
+	// informational responses such as 103 Early Hints aren't the final status
+	if hw.status == 0 && status >= 200 {
+		hw.status = status
+	}
+}
+
+func (hw *headResponseWriter) Write(b []byte) (int, error) {
+	if hw.status == 0 {
+		hw.status = http.StatusOK
+	}
+
+	hw.length += len(b)
+
+	return len(b), nil
+}
+
+// FlushError is found by http.ResponseController before it unwraps the writer, so a streaming handler doesn't send the
+// headers before the length of the body is known
+func (hw *headResponseWriter) FlushError() error {
+	return nil
+}
+
+func (hw *headResponseWriter) Unwrap() http.ResponseWriter {
+	return hw.ResponseWriter
+}
+
+func (hw *headResponseWriter) finish() {
+	if hw.status == 0 {
+		hw.status = http.StatusOK
+	}
+
+	bodyAllowed := hw.status != http.StatusNoContent && hw.status != http.StatusNotModified
+	if bodyAllowed && hw.ResponseWriter.Header().Get("Content-Length") == "" {
+		hw.ResponseWriter.Header().Set("Content-Length", strconv.Itoa(hw.length))
+	}
+
+	hw.ResponseWriter.WriteHeader(hw.status)
+}
+
+// optionsResponse answers an OPTIONS request which isn't a CORS preflight with a 204 No Content response. The router
+// has already set the Allow header to the methods of the path
+func (app *application) optionsResponse(w http.ResponseWriter, r *http.Request) {
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"net/http"
+
+	"github.com/nytro04/greenlight/internal/breaker"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// unmatchedRoute is the route label of the requests which don't match any route, so scanners probing random paths
+// don't create a new series for every path they try
+const unmatchedRoute = "unmatched"
+
+// appMetrics holds the Prometheus metrics served on /metrics. The requests are labelled with the route pattern they
+// matched rather than their path, which keeps the number of series bounded
+type appMetrics struct {
+	registry *prometheus.Registry
+
+	requests    *prometheus.CounterVec
+	durations   *prometheus.HistogramVec
+	inFlight    prometheus.Gauge
+	rateLimited prometheus.Counter
+	emails      *prometheus.CounterVec
+	tokens      *prometheus.CounterVec
+}
+
+// newAppMetrics creates the metrics, along with the Go runtime and process metrics. The connection pool statistics
+// are only exported when a database is given, and the state of its circuit breaker when it has one
+func newAppMetrics(db *sql.DB, dbBreaker *breaker.Breaker) *appMetrics {
+	m := &appMetrics{
+		registry: prometheus.NewRegistry(),
+		requests: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "http_requests_total",
+			Help: "Number of HTTP requests handled, by method, route and response status.",
+		}, []string{"method", "route", "status"}),
+		durations: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "http_request_duration_seconds",
+			Help:    "Time taken to handle HTTP requests, by method and route.",
+			Buckets: prometheus.DefBuckets,
+		}, []string{"method", "route"}),
+		inFlight: prometheus.NewGauge(prometheus.GaugeOpts{
+			Name: "http_requests_in_flight",
+			Help: "Number of HTTP requests currently being handled.",
+		}),
+		rateLimited: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "http_rate_limited_requests_total",
+			Help: "Number of HTTP requests rejected by the rate limiter.",
+		}),
+		emails: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "email_send_attempts_total",
+			Help: "Number of attempts at sending a queued email, by template and outcome (sent, retry or failed).",
+		}, []string{"template", "outcome"}),
+		tokens: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "expired_tokens_deleted_total",
+			Help: "Number of expired tokens deleted by the token cleanup job, by scope.",
+		}, []string{"scope"}),
+	}
+
+	m.registry.MustRegister(
+		m.requests,
+		m.durations,
+		m.inFlight,
+		m.rateLimited,
+		m.emails,
+		m.tokens,
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+	)
+
+	if db != nil {
+		m.registry.MustRegister(collectors.NewDBStatsCollector(db, "greenlight"))
+	}
+
+	if dbBreaker != nil {
+		m.registry.MustRegister(
+			prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+				Name: "db_circuit_breaker_state",
+				Help: "State of the database circuit breaker: 0 closed, 1 half open, 2 open.",
+			}, func() float64 { return float64(dbBreaker.State()) }),
+			prometheus.NewCounterFunc(prometheus.CounterOpts{
+				Name: "db_circuit_breaker_trips_total",
+				Help: "Number of times the database circuit breaker has opened.",
+			}, func() float64 { return float64(dbBreaker.Trips()) }),
+			prometheus.NewCounterFunc(prometheus.CounterOpts{
+				Name: "db_circuit_breaker_rejections_total",
+				Help: "Number of database connections refused while the circuit breaker was open.",
+			}, func() float64 { return float64(dbBreaker.Rejections()) }),
+		)
+	}
+
+	return m
+}
+
+// metricsRoute is stored in the request context by the metrics middleware, and filled in with the route pattern by the
+// router once the request has been matched
+type metricsRoute struct {
+	pattern string
+}
+
+func contextSetMetricsRoute(r *http.Request, route *metricsRoute) *http.Request {
+	ctx := context.WithValue(r.Context(), metricsRouteContextKey, route)
+	return r.WithContext(ctx)
+}
+
+// instrumentRoute wraps the handler of a route so the requests it handles are labelled with its pattern, in their
+// metrics and their trace span
+func instrumentRoute(pattern string, handler http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if route, ok := r.Context().Value(metricsRouteContextKey).(*metricsRoute); ok {
+			route.pattern = pattern
+		}
+
+		span := trace.SpanFromContext(r.Context())
+		span.SetName(r.Method + " " + pattern)
+		span.SetAttributes(attribute.String("http.route", pattern))
+
+		handler.ServeHTTP(w, r)
+	})
+}
+
+// prometheusHandler serves the metrics in the Prometheus exposition format
+func (app *application) prometheusHandler(w http.ResponseWriter, r *http.Request) {
+	promhttp.HandlerFor(app.instruments.registry, promhttp.HandlerOpts{}).ServeHTTP(w, r)
+}
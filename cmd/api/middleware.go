@@ -1,9 +1,13 @@
 package main
 
 import (
+	"context"
+	"encoding/hex"
 	"errors"
 	"expvar"
 	"fmt"
+	"io"
+	"math"
 	"net/http"
 	"strconv"
 	"strings"
@@ -14,9 +18,54 @@ import (
 	"github.com/nytro04/greenlight/internal/data"
 	"github.com/nytro04/greenlight/internal/validator"
 	"github.com/tomasen/realip"
+	"go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp"
 	"golang.org/x/time/rate"
 )
 
+// maxRequestIDLength is the longest X-Request-ID header we propagate, longer values are replaced with a generated ID
+const maxRequestIDLength = 128
+
+// requestID gives every request an ID, which is added to the request context, echoed in the X-Request-ID response header
+// and included in the log entries and error responses for the request. An ID sent by the client (or a proxy in front of us)
+// in the X-Request-ID header is propagated, so a request can be followed across services
+func (app *application) requestID(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get("X-Request-ID")
+
+		if !validRequestID(id) {
+			b := make([]byte, 16)
+
+			_, err := io.ReadFull(app.random, b)
+			if err != nil {
+				app.serverErrorResponse(w, r, err)
+				return
+			}
+
+			id = hex.EncodeToString(b)
+		}
+
+		w.Header().Set("X-Request-ID", id)
+
+		next.ServeHTTP(w, app.contextSetRequestID(r, id))
+	})
+}
+
+// validRequestID reports whether a client supplied request ID is safe to propagate. Only printable ASCII without spaces
+// is allowed, so the ID can't be used to inject anything into the response headers or the logs
+func validRequestID(id string) bool {
+	if id == "" || len(id) > maxRequestIDLength {
+		return false
+	}
+
+	for i := 0; i < len(id); i++ {
+		if id[i] <= ' ' || id[i] > '~' {
+			return false
+		}
+	}
+
+	return true
+}
+
 // recoverPanic is a middleware function that recovers from panics in the application and returns a 500 Internal Server Error response to the client.
 func (app *application) recoverPanic(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
@@ -29,8 +78,9 @@ func (app *application) recoverPanic(next http.Handler) http.Handler {
 				// this acts as a trigger to force Go's HTTP server to close the current connection after a response has been sent
 				w.Header().Set("Connection", "close")
 
-				// call the serverErrorResponse method to send a 500 Internal Server Error response to the client
-				app.serverErrorResponse(w, r, fmt.Errorf("%s", err))
+				// call the panicResponse method to send a 500 Internal Server Error response to the client, and report the
+				// panic to the error tracker
+				app.panicResponse(w, r, fmt.Errorf("%s", err))
 			}
 		}()
 		next.ServeHTTP(w, r)
@@ -53,14 +103,16 @@ func (app *application) rateLimit(next http.Handler) http.Handler {
 
 	// Launch a background goroutine that removes old entries from the clients map once every minute
 	go func() {
-		for {
-			time.Sleep(time.Minute)
+		ticker := app.clock.NewTicker(time.Minute)
+		defer ticker.Stop()
+
+		for range ticker.C() {
 			// Lock the mutex to prevent any other goroutines from accessing the map while we're deleting the old entries
 			mu.Lock()
 
 			// Loop through all clients. If they haven't been seen within the last 3 minutes, delete the corresponding entry from the map
 			for ip, client := range clients {
-				if time.Since(client.lastSeen) > 3*time.Minute {
+				if app.clock.Since(client.lastSeen) > 3*time.Minute {
 					delete(clients, ip)
 				}
 			}
@@ -86,24 +138,52 @@ func (app *application) rateLimit(next http.Handler) http.Handler {
 			}
 
 			// Update the last seen time for the client
-			clients[ip].lastSeen = time.Now()
+			now := app.clock.Now()
+			clients[ip].lastSeen = now
 
-			// call the .Allow() method on the current rate limiter. if the request isn't allowed, unlock the mutex and
-			// call the rateLimitExceededResponse method to send a 429 Too Many Requests response to the client
-			if !clients[ip].limiter.Allow() {
-				mu.Unlock()
-				app.rateLimitExceededResponse(w, r)
-				return
-			}
+			// call the .AllowN() method on the current rate limiter with the time from the application clock. if the request isn't allowed,
+			// unlock the mutex and call the rateLimitExceededResponse method to send a 429 Too Many Requests response to the client
+			limiter := clients[ip].limiter
+			allowed := limiter.AllowN(now, 1)
+			tokens := limiter.TokensAt(now)
 
-			// unlock the mutex and call the next handler in the chain
+			// unlock the mutex, the rest of the work doesn't touch the map
 			mu.Unlock()
+
+			setRateLimitHeaders(w, limiter, tokens, now)
+
+			if !allowed {
+				app.instruments.rateLimited.Inc()
+				app.rateLimitExceededResponse(w, r, tokenWait(limiter, tokens, 1))
+				return
+			}
 		}
 
 		next.ServeHTTP(w, r)
 	})
 }
 
+// setRateLimitHeaders tells the client how much of its rate limit is left, so it can slow down before it is refused.
+// X-RateLimit-Limit is the size of the bucket, the most requests which can be made at once, X-RateLimit-Remaining
+// the requests which can be made straight away, and X-RateLimit-Reset the Unix time the bucket will be full again
+func setRateLimitHeaders(w http.ResponseWriter, limiter *rate.Limiter, tokens float64, now time.Time) {
+	remaining := max(int(math.Floor(tokens)), 0)
+	reset := now.Add(tokenWait(limiter, tokens, float64(limiter.Burst())))
+
+	w.Header().Set("X-RateLimit-Limit", strconv.Itoa(limiter.Burst()))
+	w.Header().Set("X-RateLimit-Remaining", strconv.Itoa(remaining))
+	w.Header().Set("X-RateLimit-Reset", strconv.FormatInt(int64(math.Ceil(float64(reset.UnixMilli())/1000)), 10))
+}
+
+// tokenWait returns how long the limiter takes to refill from the given number of tokens to the wanted number
+func tokenWait(limiter *rate.Limiter, tokens, wanted float64) time.Duration {
+	if tokens >= wanted || limiter.Limit() <= 0 {
+		return 0
+	}
+
+	return time.Duration((wanted - tokens) / float64(limiter.Limit()) * float64(time.Second))
+}
+
 // authenticate is a middleware function that checks whether a request is authorized by looking for a valid authentication token in the Authorization header.
 // If the request is authorized, the user details are added to the request context. If the request is not authorized, a 401 Unauthorized response is sent to the client.
 func (app *application) authenticate(next http.Handler) http.Handler {
@@ -127,14 +207,27 @@ func (app *application) authenticate(next http.Handler) http.Handler {
 		// if the header is not in the expected format, we return a 401 Unauthorized response.
 
 		headerParts := strings.Split(authorizationHeader, " ")
-		if len(headerParts) != 2 || headerParts[0] != "Bearer" {
+		if len(headerParts) != 2 || (headerParts[0] != "Bearer" && headerParts[0] != "ApiKey") {
 			app.invalidAuthenticationTokenResponse(w, r)
 			return
 		}
 
+		// server-to-server clients authenticate with "ApiKey <key>" instead of a token
+		if headerParts[0] == "ApiKey" {
+			app.authenticateAPIKey(w, r, next, headerParts[1])
+			return
+		}
+
 		// extract the actual token from the header parts
 		token := headerParts[1]
 
+		// with the jwt strategy, a signed token is checked without going to the database. The tokens are told apart by
+		// their format, so database tokens issued before the strategy was switched keep working until they expire
+		if app.jwt != nil && strings.Count(token, ".") == 2 {
+			app.authenticateJWT(w, r, next, token)
+			return
+		}
+
 		// validate the token to make sure it is in a sensible format
 		// if the token is invalid, return a 401 Unauthorized response
 		v := validator.New()
@@ -144,7 +237,7 @@ func (app *application) authenticate(next http.Handler) http.Handler {
 		}
 
 		// retrieve the details of the user associated with the authentication token, and handle any errors
-		user, err := app.models.Users.GetTokenUser(data.ScopeAuthentication, token)
+		user, err := app.models.Users.GetTokenUser(r.Context(), data.ScopeAuthentication, token)
 		if err != nil {
 			switch {
 			case errors.Is(err, data.ErrRecordNotFound):
@@ -155,6 +248,13 @@ func (app *application) authenticate(next http.Handler) http.Handler {
 			return
 		}
 
+		// record when the session was last used, so the user can tell which of their sessions are still in use. This is
+		// only a best effort, so a failed write is logged rather than failing the request
+		err = app.models.Tokens.Touch(r.Context(), token)
+		if err != nil {
+			app.logError(r, err)
+		}
+
 		// call the contextSetUser() method to add the user information to the request context
 		r = app.contextSetUser(r, user)
 
@@ -164,6 +264,93 @@ func (app *application) authenticate(next http.Handler) http.Handler {
 	})
 }
 
+// authenticateAPIKey authenticates the request as the user an API key belongs to, and adds the key to the request
+// context so requirePermission can limit the request to the permissions the key was minted with
+func (app *application) authenticateAPIKey(w http.ResponseWriter, r *http.Request, next http.Handler, plaintext string) {
+	v := validator.New()
+	if data.ValidateAPIKeyPlaintext(v, plaintext); !v.Valid() {
+		app.invalidAuthenticationTokenResponse(w, r)
+		return
+	}
+
+	user, key, err := app.models.APIKeys.GetUserForKey(plaintext)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.invalidAuthenticationTokenResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	// every request made with the key counts against the quota of its tier, whether it succeeds or not
+	usage, err := app.models.Quotas.Consume(r.Context(), key)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrQuotaExceeded):
+			app.quotaExceededResponse(w, r, usage)
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.invalidAuthenticationTokenResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	r = app.contextSetUser(r, user)
+	r = app.contextSetAPIKey(r, key)
+
+	next.ServeHTTP(w, r)
+}
+
+// bearerToken returns the token the request was authenticated with. The authenticate middleware has already checked the
+// Authorization header holds a valid bearer token, so it must only be called on the routes which require a signed in user
+func bearerToken(r *http.Request) string {
+	return strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+}
+
+// authenticateJWT authenticates the request with a signed JWT. The user is built from the token's claims rather than
+// read from the database, so it only holds the ID, name, email and activation status the token was issued with. Only the
+// time the user's tokens were last revoked is looked up, to refuse the tokens issued before it
+func (app *application) authenticateJWT(w http.ResponseWriter, r *http.Request, next http.Handler, token string) {
+	claims, err := app.jwt.Verify(token)
+	if err != nil {
+		app.invalidAuthenticationTokenResponse(w, r)
+		return
+	}
+
+	// Verify has already checked the subject is a user ID
+	id, _ := claims.UserID()
+
+	// a signed token can't be deleted, so the ones issued before the user's password was changed, or their account was
+	// scheduled for deletion, are refused here, as are all the tokens of a disabled user
+	validAfter, err := app.models.Users.GetTokensValidAfter(r.Context(), id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.invalidAuthenticationTokenResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	if !validAfter.IsZero() && (claims.IssuedAt == nil || claims.IssuedAt.Time.Before(validAfter)) {
+		app.invalidAuthenticationTokenResponse(w, r)
+		return
+	}
+
+	user := &data.User{
+		ID:        id,
+		Name:      claims.Name,
+		Email:     claims.Email,
+		Activated: claims.Activated,
+	}
+
+	next.ServeHTTP(w, app.contextSetUser(r, user))
+}
+
 // requireAuthenticatedUser is a middleware function that checks if the user is not anonymous
 func (app *application) requireAuthenticatedUser(next http.HandlerFunc) http.HandlerFunc {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
@@ -176,6 +363,13 @@ func (app *application) requireAuthenticatedUser(next http.HandlerFunc) http.Han
 			return
 		}
 
+		// API keys are scoped to permissions, so they can't be used on the routes which only need a signed in user
+		// (managing passkeys, writing reviews...), those are reserved for the user's own sessions
+		if app.contextGetAPIKey(r) != nil && !app.contextAPIKeyAllowed(r) {
+			app.notPermittedResponse(w, r)
+			return
+		}
+
 		// call the next handler in the chain
 		next.ServeHTTP(w, r)
 
@@ -209,18 +403,19 @@ func (app *application) requireActivatedUser(next http.HandlerFunc) http.Handler
 //	before checking the permissions of the user
 func (app *application) requirePermission(code string, next http.HandlerFunc) http.HandlerFunc {
 	fn := func(w http.ResponseWriter, r *http.Request) {
-		// extract the user from the request context
-		user := app.contextGetUser(r)
-
-		// get the slice of permissions for the user
-		permissions, err := app.models.Permissions.GetAllForUser(user.ID)
+		// check if the user has the required permission
+		permitted, err := app.hasPermission(r, code)
 		if err != nil {
 			app.serverErrorResponse(w, r, err)
 			return
 		}
 
-		// check if the user has the required permission
-		if !permissions.Include(code) {
+		if !permitted {
+			app.recordSecurityEvent(r, data.SecurityEventPermissionDenied, map[string]string{
+				"permission":     code,
+				"request_method": r.Method,
+				"request_url":    r.URL.String(),
+			})
 			app.notPermittedResponse(w, r)
 			return
 		}
@@ -229,11 +424,45 @@ func (app *application) requirePermission(code string, next http.HandlerFunc) ht
 		next.ServeHTTP(w, r)
 	}
 
-	// wrap the handler function in the requireActivatedUser middleware and return it
-	return app.requireActivatedUser(fn)
+	// wrap the handler function in the requireActivatedUser middleware, marking the request as one which will have its
+	// permission checked so requireAuthenticatedUser lets API keys through
+	checked := app.requireActivatedUser(fn)
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		checked(w, app.contextSetAPIKeyAllowed(r))
+	}
+}
+
+// allowAPIKey requires an authenticated user like requireAuthenticatedUser, but lets requests made with an API key
+// through too. It is for the routes which need no permission but which an API key holder has a use for, such as
+// checking how much of their quota is left
+func (app *application) allowAPIKey(next http.HandlerFunc) http.HandlerFunc {
+	authenticated := app.requireAuthenticatedUser(next)
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		authenticated(w, app.contextSetAPIKeyAllowed(r))
+	}
+}
+
+// hasPermission reports whether the authenticated user has the permission. A request made with an API key also needs
+// the permission to have been granted to the key
+func (app *application) hasPermission(r *http.Request, code string) (bool, error) {
+	// get the slice of permissions for the user
+	permissions, err := app.models.Permissions.GetAllForUser(r.Context(), app.contextGetUser(r).ID)
+	if err != nil {
+		return false, err
+	}
+
+	key := app.contextGetAPIKey(r)
+
+	return permissions.Include(code) && (key == nil || key.Permissions.Include(code)), nil
 }
 
 func (app *application) enableCORS(next http.Handler) http.Handler {
+	allowedMethods := strings.Join(app.config.cors.allowedMethods, ", ")
+	allowedHeaders := strings.Join(app.config.cors.allowedHeaders, ", ")
+	exposedHeaders := strings.Join(app.config.cors.exposedHeaders, ", ")
+
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		// w.Header().Set("Access-Control-Allow-Origin", "*")
 
@@ -245,28 +474,39 @@ func (app *application) enableCORS(next http.Handler) http.Handler {
 		// get the value of the Origin header from the request. This will return an empty string if the header is not present
 		origin := r.Header.Get("Origin")
 
-		// if the Origin header is not present, the request is same-origin and we don't need to do anything
-		if origin != "" {
-			// loop through the list of trusted origins and check if the request Origin header matches any of them
-			// if there is a match, set the Access-Control-Allow-Origin header on the response with the value of the Origin header
-			// this indicates that the client is allowed to make requests from that origin
-			for i := range app.config.cors.trustedOrigins {
-				if origin == app.config.cors.trustedOrigins[i] {
-					w.Header().Set("Access-Control-Allow-Origin", origin)
-
-					// if the request method is OPTIONS and has an Access-Control-Request-Method header, then we know this is a preflight request
-					// in this case, we set the Access-Control-Allow-Methods and Access-Control-Allow-Headers headers on the response
-					// and return a 200 OK status code to indicate that the client is allowed to make the request
-					if r.Method == http.MethodOptions && r.Header.Get("Access-Control-Request-Method") != "" {
-						// set the Access-Control-Allow-Methods and Access-Control-Allow-Headers headers on the response
-						w.Header().Set("Access-Control-Allow-Methods", "OPTIONS, PUT, PATCH, DELETE")
-						w.Header().Set("Access-Control-Allow-Headers", "Authorization, Content-Type")
-
-						w.WriteHeader(http.StatusOK)
-						return
-					}
-					break
+		// if the Origin header is not present, the request is same-origin and we don't need to do anything. otherwise
+		// if the origin is one of the trusted origins, set the Access-Control-Allow-Origin header on the response with
+		// the value of the Origin header. this indicates that the client is allowed to make requests from that origin
+		if origin != "" && corsOriginTrusted(origin, app.config.cors.trustedOrigins) {
+			w.Header().Set("Access-Control-Allow-Origin", origin)
+
+			if app.config.cors.allowCredentials {
+				w.Header().Set("Access-Control-Allow-Credentials", "true")
+			}
+
+			// let the browser scripts read the response headers they need, such as the ETag, so they can send it back
+			// in If-None-Match and If-Match, and the request ID
+			if exposedHeaders != "" {
+				w.Header().Set("Access-Control-Expose-Headers", exposedHeaders)
+			}
+
+			// if the request method is OPTIONS and has an Access-Control-Request-Method header, then we know this is a preflight request
+			// in this case, we set the Access-Control-Allow-Methods and Access-Control-Allow-Headers headers on the response
+			// and return a 200 OK status code to indicate that the client is allowed to make the request
+			if r.Method == http.MethodOptions && r.Header.Get("Access-Control-Request-Method") != "" {
+				w.Header().Add("Vary", "Access-Control-Request-Method")
+				w.Header().Add("Vary", "Access-Control-Request-Headers")
+
+				// set the Access-Control-Allow-Methods and Access-Control-Allow-Headers headers on the response
+				w.Header().Set("Access-Control-Allow-Methods", allowedMethods)
+				w.Header().Set("Access-Control-Allow-Headers", allowedHeaders)
+
+				if app.config.cors.maxAge > 0 {
+					w.Header().Set("Access-Control-Max-Age", strconv.Itoa(int(app.config.cors.maxAge.Seconds())))
 				}
+
+				w.WriteHeader(http.StatusOK)
+				return
 			}
 		}
 		// call the next handler in the chain
@@ -274,6 +514,34 @@ func (app *application) enableCORS(next http.Handler) http.Handler {
 	})
 }
 
+// corsOriginTrusted reports whether the origin matches one of the trusted origins. A trusted origin whose host starts
+// with a *. wildcard, such as https://*.example.com, matches the origins of its subdomains at any depth with the same
+// scheme and port, but not the origin of the domain itself
+func corsOriginTrusted(origin string, trusted []string) bool {
+	for _, t := range trusted {
+		if origin == t {
+			return true
+		}
+
+		scheme, domain, ok := strings.Cut(t, "://*.")
+		if !ok {
+			continue
+		}
+
+		prefix, suffix := scheme+"://", "."+domain
+		if len(origin) <= len(prefix)+len(suffix) || !strings.HasPrefix(origin, prefix) || !strings.HasSuffix(origin, suffix) {
+			continue
+		}
+
+		subdomain := origin[len(prefix) : len(origin)-len(suffix)]
+		if !strings.ContainsAny(subdomain, "/:@") {
+			return true
+		}
+	}
+
+	return false
+}
+
 func (app *application) metrics(next http.Handler) http.Handler {
 	// declare and initialize the expvar variables when new middleware is created
 	totalRequestsReceived := expvar.NewInt("total_requests_received")
@@ -286,9 +554,19 @@ func (app *application) metrics(next http.Handler) http.Handler {
 		// use the add method to increment the totalRequestsReceived received by 1
 		totalRequestsReceived.Add(1)
 
+		app.instruments.inFlight.Inc()
+		defer app.instruments.inFlight.Dec()
+
+		// the router fills in the pattern of the route the request matched
+		route := &metricsRoute{pattern: unmatchedRoute}
+		r = contextSetMetricsRoute(r, route)
+
 		// returns the metrics for the request
 		metrics := httpsnoop.CaptureMetrics(next, w, r)
 
+		app.instruments.requests.WithLabelValues(r.Method, route.pattern, strconv.Itoa(metrics.Code)).Inc()
+		app.instruments.durations.WithLabelValues(r.Method, route.pattern).Observe(metrics.Duration.Seconds())
+
 		// on the way back up the middleware chain, increment the number of responses sent by 1
 		totalResponsesSent.Add(1)
 
@@ -299,3 +577,63 @@ func (app *application) metrics(next http.Handler) http.Handler {
 		totalResponsesSentByStatus.Add(strconv.Itoa(metrics.Code), 1)
 	})
 }
+
+// accessLogEntry is stored in the request context by the accessLog middleware, and filled in with the user once the
+// request has been authenticated
+type accessLogEntry struct {
+	userID int64
+}
+
+// accessLog writes one log entry for every request once it has been handled, unless its path is one of the excluded
+// ones such as the health checks. The path is logged without the query string, as it can hold tokens
+func (app *application) accessLog(next http.Handler) http.Handler {
+	excluded := make(map[string]bool, len(app.config.accessLog.exclude))
+	for _, path := range app.config.accessLog.exclude {
+		excluded[path] = true
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !app.config.accessLog.enabled || excluded[r.URL.Path] {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		entry := &accessLogEntry{}
+		r = r.WithContext(context.WithValue(r.Context(), accessLogContextKey, entry))
+
+		metrics := httpsnoop.CaptureMetrics(next, w, r)
+
+		attrs := []any{
+			"method", r.Method,
+			"path", r.URL.Path,
+			"status", metrics.Code,
+			"bytes", metrics.Written,
+			"duration", metrics.Duration,
+			"client_ip", realip.FromRequest(r),
+		}
+		if entry.userID != 0 {
+			attrs = append(attrs, "user_id", entry.userID)
+		}
+
+		// the request's logger adds the request ID
+		app.contextGetLogger(r).PrintInfo("request", attrs...)
+	})
+}
+
+// traceRequests starts a server span for every request, continuing the caller's trace when the request has a traceparent
+// header. The span is renamed after the route once the router has matched it. The scrapes of the metrics and the
+// health checks aren't traced, they would drown out the requests made by clients
+func (app *application) traceRequests(next http.Handler) http.Handler {
+	return otelhttp.NewHandler(next, "HTTP request",
+		otelhttp.WithSpanNameFormatter(func(operation string, r *http.Request) string {
+			return r.Method
+		}),
+		otelhttp.WithFilter(func(r *http.Request) bool {
+			switch r.URL.Path {
+			case "/metrics", "/v1/metrics", "/v1/healthz", "/v1/readyz", "/v1/healthcheck":
+				return false
+			}
+			return true
+		}),
+	)
+}
@@ -0,0 +1,179 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/nytro04/greenlight/internal/data"
+	"github.com/nytro04/greenlight/internal/validator"
+)
+
+// reportReviewHandler flags a review for the moderators, with the reason the authenticated user gives. Each user can
+// report a review once
+func (app *application) reportReviewHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	var input struct {
+		Reason string `json:"reason"`
+	}
+
+	err = app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	report := &data.ReviewReport{
+		ReviewID: id,
+		UserID:   app.contextGetUser(r).ID,
+		Reason:   input.Reason,
+	}
+
+	v := validator.New()
+
+	if data.ValidateReviewReport(v, report); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = app.models.Moderation.Report(r.Context(), report)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		case errors.Is(err, data.ErrDuplicateReport):
+			v.AddError("review", "you have already reported this review")
+			app.failedValidationResponse(w, r, v.Errors)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusCreated, envelope{"report": report}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// listReportedReviewsHandler returns a page of the moderation queue, the reviews with reports no moderator has made a
+// decision about yet, the most reported first unless the client asks otherwise
+func (app *application) listReportedReviewsHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		data.Filters
+	}
+
+	v := validator.New()
+
+	qs := r.URL.Query()
+
+	input.Filters.Page = app.readInt(qs, "page", 1, v)
+	input.Filters.PageSize = app.readInt(qs, "page_size", 20, v)
+	input.Filters.Sort = app.readString(qs, "sort", "-report_count")
+	input.Filters.SortSafeList = []string{"id", "created_at", "report_count", "last_reported_at", "-id", "-created_at", "-report_count", "-last_reported_at"}
+
+	if data.ValidateFilters(v, input.Filters); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	reviews, metadata, err := app.models.Moderation.GetReported(r.Context(), input.Filters)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrUnsafeSort):
+			app.invalidSortResponse(w, r, input.Filters)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"reviews": reviews, "metadata": metadata}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// showModeratedReviewHandler returns a review, even a hidden one, with its reports and the moderation decisions made
+// about it
+func (app *application) showModeratedReviewHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	review, reports, decisions, err := app.models.Moderation.GetReview(r.Context(), id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"review": review, "reports": reports, "decisions": decisions}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// createModerationDecisionHandler records the authenticated moderator's decision about a review, hiding or restoring
+// it or dismissing its reports, and resolves the review's open reports
+func (app *application) createModerationDecisionHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	var input struct {
+		Action string `json:"action"`
+		Note   string `json:"note"`
+	}
+
+	err = app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	decision := &data.ModerationDecision{
+		ReviewID:    id,
+		ModeratorID: app.contextGetUser(r).ID,
+		Action:      input.Action,
+		Note:        input.Note,
+	}
+
+	v := validator.New()
+
+	if data.ValidateModerationDecision(v, decision); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = app.models.Moderation.Decide(r.Context(), decision)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	app.recordAudit(r, data.AuditCategoryContent, "review_moderated_"+decision.Action, fmt.Sprintf("review:%d", decision.ReviewID))
+
+	err = app.writeJSON(w, http.StatusCreated, envelope{"decision": decision}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
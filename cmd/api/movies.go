@@ -4,6 +4,9 @@ import (
 	"errors"
 	"fmt"
 	"net/http"
+	"net/url"
+	"strings"
+	"time"
 
 	"github.com/nytro04/greenlight/internal/data"
 	"github.com/nytro04/greenlight/internal/validator"
@@ -11,10 +14,12 @@ import (
 
 func (app *application) createMovieHandler(w http.ResponseWriter, r *http.Request) {
 	var input struct {
-		Title   string       `json:"title"`
-		Runtime data.Runtime `json:"runtime"`
-		Genres  []string     `json:"genres"`
-		Year    int32        `json:"year"`
+		Title    string       `json:"title"`
+		Runtime  data.Runtime `json:"runtime"`
+		Genres   []string     `json:"genres"`
+		Year     int32        `json:"year"`
+		Language string       `json:"language"`
+		Country  string       `json:"country"`
 	}
 
 	err := app.readJSON(w, r, &input)
@@ -24,10 +29,12 @@ func (app *application) createMovieHandler(w http.ResponseWriter, r *http.Reques
 	}
 
 	movie := &data.Movie{
-		Title:   input.Title,
-		Runtime: input.Runtime,
-		Genres:  input.Genres,
-		Year:    input.Year,
+		Title:    input.Title,
+		Runtime:  input.Runtime,
+		Genres:   input.Genres,
+		Year:     input.Year,
+		Language: input.Language,
+		Country:  input.Country,
 	}
 
 	// Initialize a new Validator instance.
@@ -39,24 +46,98 @@ func (app *application) createMovieHandler(w http.ResponseWriter, r *http.Reques
 	}
 
 	// call insert method on the movie model to insert the movie into the database
-	err = app.models.Movies.Insert(movie)
+	err = app.models.Movies.Insert(r.Context(), movie)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 		return
 	}
 
+	app.recordAudit(r, data.AuditCategoryContent, "movie_created", fmt.Sprintf("movie:%d", movie.ID))
+
 	// include location header with interpolated id to
 	headers := make(http.Header)
-	headers.Set("Location", fmt.Sprintf("/v1/movies/%d", movie.ID))
+	headers.Set("Location", fmt.Sprintf("/%s/movies/%d", app.contextGetAPIVersion(r), movie.ID))
 
 	// json response with 201 status code
-	err = app.writeJSON(w, http.StatusCreated, envelope{"movie": movie}, headers)
+	err = app.writeResponse(w, r, http.StatusCreated, envelope{"movie": app.presentMovie(r, movie)}, headers)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+
+}
+
+// maxBatchMovies is the number of movies which can be created in one batch, larger sets go through the import
+const maxBatchMovies = 100
+
+// createMoviesBatchHandler creates several movies in one request. The batch is all or nothing: every movie is
+// validated before any is inserted, and if one is invalid the errors of each invalid movie are returned, keyed by its
+// position in the batch, and none are created. The movies are inserted with a multi-row INSERT in one transaction
+func (app *application) createMoviesBatchHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Movies []importMovie `json:"movies"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+
+	v.Check(len(input.Movies) > 0, "movies", "must contain at least 1 movie")
+	v.Check(len(input.Movies) <= maxBatchMovies, "movies", fmt.Sprintf("must not contain more than %d movies", maxBatchMovies))
+
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	movies := make([]*data.Movie, len(input.Movies))
+
+	for i, in := range input.Movies {
+		movies[i] = in.movie()
+
+		movieValidator := validator.New()
+		data.ValidateMovie(movieValidator, movies[i])
+
+		for key, message := range movieValidator.Errors {
+			v.AddError(fmt.Sprintf("movies[%d].%s", i, key), message)
+		}
+	}
+
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = app.models.Movies.InsertMany(r.Context(), movies)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	ids := make([]int64, len(movies))
+	for i, movie := range movies {
+		ids[i] = movie.ID
 	}
 
+	app.recordAudit(r, data.AuditCategoryContent, "movies_created", fmt.Sprintf("%d movies", len(movies)))
+
+	err = app.writeResponse(w, r, http.StatusCreated, envelope{"ids": ids, "movies": app.presentMovies(r, movies)}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
 }
 
+// movieSortSafeList holds the supported sort values of the movie listing. the "-" prefix indicates that the field should be
+// sorted in descending order. relevance puts the best matches for the title search first, so it has no descending form.
+// rating sorts by the average rating of the reviews, so sort=-rating puts the best rated movies first
+var movieSortSafeList = []string{"id", "title", "year", "runtime", "created_at", "relevance", "rating", "-id", "-title", "-year", "-runtime", "-created_at", "-rating"}
+
+// movieFieldSafeList holds the movie fields a v1 client can ask for with the fields query string parameter, e.g. fields=id,title,year
+var movieFieldSafeList = []string{"id", "createdAt", "updatedAt", "title", "year", "runtime", "genres", "version", "average_rating", "review_count", "credits", "headline", "poster_url", "favorited", "tags", "series", "language", "country"}
+
 func (app *application) showMovieHandler(w http.ResponseWriter, r *http.Request) {
 	id, err := app.readIDParam(r)
 	if err != nil {
@@ -64,7 +145,17 @@ func (app *application) showMovieHandler(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
-	movie, err := app.models.Movies.Get(id)
+	// the related resources can be embedded in the movie with the include query string parameter, e.g. include=credits
+	v := validator.New()
+
+	include := app.readInclude(r.URL.Query(), []string{"credits", "tags", "series"}, v)
+	fields := app.readFields(r.URL.Query(), app.movieFields(r), v)
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	movie, err := app.models.Movies.Get(r.Context(), id)
 	if err != nil {
 		switch {
 		case errors.Is(err, data.ErrRecordNotFound):
@@ -75,8 +166,55 @@ func (app *application) showMovieHandler(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
+	if include["credits"] {
+		err = app.embedCredits(movie)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+	}
+
+	if include["tags"] {
+		err = app.embedTags(r, movie)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+	}
+
+	if include["series"] {
+		err = app.embedSeries(r, movie)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+	}
+
+	favoritesUpdatedAt, err := app.markFavorites(r, movie)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	// cut the movie down to the fields the client asked for, if it asked for any
+	selected, err := selectFields(app.presentMovie(r, movie), fields)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	app.recordMovieView(r, movie.ID)
+
+	// the credits, tags and series don't move the updated_at time of the movie on, so a movie with them embedded has no
+	// Last-Modified
+	if !include["credits"] && !include["tags"] && !include["series"] {
+		setLastModified(w, favoritesUpdatedAt, movie)
+	}
+
 	// err = app.writeJSON(w, http.StatusOK, envelope{"movie": movie} , nil) //using envelope type
-	err = app.writeJSON(w, http.StatusOK, envelope{"movie": movie}, nil)
+	// the ETag lets clients revalidate their cached copy with If-None-Match and get a 304 if it hasn't changed, and
+	// the Last-Modified time lets them do the same with If-Modified-Since
+	err = app.writeResponseWithETag(w, r, http.StatusOK, envelope{"movie": selected}, nil)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 	}
@@ -85,8 +223,11 @@ func (app *application) showMovieHandler(w http.ResponseWriter, r *http.Request)
 func (app *application) listMoviesHandler(w http.ResponseWriter, r *http.Request) {
 	// create a new struct to hold the expected query string parameters
 	var input struct {
-		Title  string
 		Genres []string
+		Tags   []string
+		data.MovieSearch
+		data.MovieOrigin
+		data.MovieRanges
 		data.Filters
 	}
 
@@ -98,6 +239,16 @@ func (app *application) listMoviesHandler(w http.ResponseWriter, r *http.Request
 	// use the readString() and readCSV helper to extract the parameters
 	input.Title = app.readString(qs, "title", "")
 	input.Genres = app.readCSV(qs, "genres", []string{})
+	input.Tags = app.readTags(qs, v)
+	input.MovieOrigin = app.readMovieOrigin(qs, v)
+	include := app.readInclude(qs, []string{"credits", "headline", "tags", "series"}, v)
+	input.Headline = include["headline"]
+	if fuzzy := app.readBool(qs, "fuzzy", v); fuzzy != nil {
+		input.Fuzzy = *fuzzy
+	}
+	fields := app.readFields(qs, app.movieFields(r), v)
+
+	input.MovieRanges = app.readMovieRanges(qs, v)
 
 	// extract the page and page_size query string values, falling back to default values if they are not provided
 	input.Filters.Page = app.readInt(qs, "page", 1, v)
@@ -105,8 +256,18 @@ func (app *application) listMoviesHandler(w http.ResponseWriter, r *http.Request
 
 	// extract the sort query string value, falling back to "id" it is not provided, which will imply sorting by ascending ID
 	input.Filters.Sort = app.readString(qs, "sort", "id")
-	// add the supported sort values to the safe list. the "-" prefix indicates that the field should be sorted in descending order
-	input.Filters.SortSafeList = []string{"id", "title", "year", "runtime", "-id", "-title", "-year", "-runtime"}
+	input.Filters.SortSafeList = movieSortSafeList
+
+	// a cursor parameter switches to keyset pagination, which stays fast however deep the client pages. It is empty
+	// for the first page, and the next_cursor from the metadata of the previous page after that
+	input.Filters.CursorMode = qs.Has("cursor")
+	input.Filters.Cursor = app.readString(qs, "cursor", "")
+
+	// exact_count=false estimates the total rather than counting every match, which is much cheaper on a big catalog.
+	// The metadata is flagged as approximate when it is
+	if exact := app.readBool(qs, "exact_count", v); exact != nil {
+		input.Filters.EstimateCount = !*exact
+	}
 
 	// validate the filters using the ValidateFilters() helper
 	if data.ValidateFilters(v, input.Filters); !v.Valid() {
@@ -115,19 +276,196 @@ func (app *application) listMoviesHandler(w http.ResponseWriter, r *http.Request
 	}
 
 	// call the GetAll() method on the movies model to retrieve the movies, passing in the various filter parameters
-	movies, metadata, err := app.models.Movies.GetAll(input.Title, input.Genres, input.Filters)
+	movies, metadata, err := app.models.Movies.GetAll(r.Context(), input.MovieSearch, input.Genres, input.Tags, input.MovieOrigin, input.MovieRanges, input.Filters)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrUnsafeSort):
+			app.invalidSortResponse(w, r, input.Filters)
+		case errors.Is(err, data.ErrInvalidCursor):
+			app.failedValidationResponse(w, r, map[string]string{"cursor": "must be a next_cursor value returned for the same sort"})
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	if include["credits"] {
+		err = app.embedCredits(movies...)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+	}
+
+	if include["tags"] {
+		err = app.embedTags(r, movies...)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+	}
+
+	if include["series"] {
+		err = app.embedSeries(r, movies...)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+	}
+
+	_, err = app.markFavorites(r, movies...)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 		return
 	}
 
+	selected, err := selectFields(app.presentMovies(r, movies), fields)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	// a page has no Last-Modified, as a deleted movie or one moving onto the page wouldn't move it on; the ETag
+	// covers those
+
 	// send a JSON response containing the movie data
-	err = app.writeJSON(w, http.StatusOK, envelope{"movies": movies, "metadata": metadata}, nil)
+	err = app.writeResponseWithETag(w, r, http.StatusOK, envelope{"movies": selected, "metadata": metadata}, nil)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 	}
 }
 
+// showRandomMovieHandler returns a movie picked at random from the ones matching the same title, genre, tag, origin
+// and range filters as the listing, for the "surprise me" features. The response isn't cacheable, as each request gets a new pick
+func (app *application) showRandomMovieHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Genres []string
+		Tags   []string
+		data.MovieSearch
+		data.MovieOrigin
+		data.MovieRanges
+	}
+
+	v := validator.New()
+
+	qs := r.URL.Query()
+
+	input.Title = app.readString(qs, "title", "")
+	input.Genres = app.readCSV(qs, "genres", []string{})
+	input.Tags = app.readTags(qs, v)
+	input.MovieOrigin = app.readMovieOrigin(qs, v)
+	if fuzzy := app.readBool(qs, "fuzzy", v); fuzzy != nil {
+		input.Fuzzy = *fuzzy
+	}
+	fields := app.readFields(qs, app.movieFields(r), v)
+
+	input.MovieRanges = app.readMovieRanges(qs, v)
+
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	movie, err := app.models.Movies.GetRandom(r.Context(), input.MovieSearch, input.Genres, input.Tags, input.MovieOrigin, input.MovieRanges)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.errorResponse(w, r, http.StatusNotFound, "no movies match the filters")
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	_, err = app.markFavorites(r, movie)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	selected, err := selectFields(app.presentMovie(r, movie), fields)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	headers := make(http.Header)
+	headers.Set("Cache-Control", "no-store")
+
+	err = app.writeResponse(w, r, http.StatusOK, envelope{"movie": selected}, headers)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// showMovieStatsHandler returns statistics about the whole catalog, such as the number of movies of each genre and
+// decade, so dashboards don't have to page through every movie to work them out
+func (app *application) showMovieStatsHandler(w http.ResponseWriter, r *http.Request) {
+	stats, err := app.models.Movies.Stats(r.Context())
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeResponseWithETag(w, r, http.StatusOK, envelope{"stats": stats}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// readMovieRanges reads and validates the range filters of the movie listing and export. The ranges are inclusive,
+// and each one is left open when its parameter isn't given
+func (app *application) readMovieRanges(qs url.Values, v *validator.Validator) data.MovieRanges {
+	ranges := data.MovieRanges{
+		YearMin:    app.readInt(qs, "year_min", 0, v),
+		YearMax:    app.readInt(qs, "year_max", 0, v),
+		RuntimeMin: app.readInt(qs, "runtime_min", 0, v),
+		RuntimeMax: app.readInt(qs, "runtime_max", 0, v),
+	}
+
+	if createdAfter := app.readTime(qs, "created_after", time.Time{}, v); !createdAfter.IsZero() {
+		ranges.CreatedAfter = &createdAfter
+	}
+	if createdBefore := app.readTime(qs, "created_before", time.Time{}, v); !createdBefore.IsZero() {
+		ranges.CreatedBefore = &createdBefore
+	}
+
+	data.ValidateMovieRanges(v, ranges)
+
+	return ranges
+}
+
+// readMovieOrigin reads and validates the comma separated language and country filters of the movie listing, e.g.
+// language=ja,ko. The codes are case-insensitive in the query string, so country=jp matches the movies made in JP
+func (app *application) readMovieOrigin(qs url.Values, v *validator.Validator) data.MovieOrigin {
+	origin := data.MovieOrigin{
+		Languages: app.readCSV(qs, "language", []string{}),
+		Countries: app.readCSV(qs, "country", []string{}),
+	}
+
+	for i := range origin.Languages {
+		origin.Languages[i] = strings.ToLower(origin.Languages[i])
+	}
+	for i := range origin.Countries {
+		origin.Countries[i] = strings.ToUpper(origin.Countries[i])
+	}
+
+	data.ValidateMovieOrigin(v, origin)
+
+	return origin
+}
+
+// moviePatchDocument is the document a JSON Patch or JSON Merge Patch of a movie is applied to, holding only the fields
+// a client may change. The paths of the patch are the field names, e.g. /genres/1 for the second genre
+type moviePatchDocument struct {
+	Title    string       `json:"title"`
+	Year     int32        `json:"year"`
+	Runtime  data.Runtime `json:"runtime"`
+	Genres   []string     `json:"genres"`
+	Language string       `json:"language"`
+	Country  string       `json:"country"`
+}
+
 func (app *application) updateMovieHandler(w http.ResponseWriter, r *http.Request) {
 	// read the id parameter from the URL
 	id, err := app.readIDParam(r)
@@ -136,8 +474,8 @@ func (app *application) updateMovieHandler(w http.ResponseWriter, r *http.Reques
 		return
 	}
 
-	// fetch the existing movie record from the database
-	movie, err := app.models.Movies.Get(id)
+	// fetch the existing movie record from the primary, as the update is made on top of it
+	movie, err := app.models.Movies.GetForWrite(r.Context(), id)
 	if err != nil {
 		switch {
 		case errors.Is(err, data.ErrRecordNotFound):
@@ -147,35 +485,91 @@ func (app *application) updateMovieHandler(w http.ResponseWriter, r *http.Reques
 		}
 		return
 	}
-	// To support partial updates, we change the type to pointers and use the zero value to determine if the field was provided.
-	// by checking if the field is nil or not
-	var input struct {
-		Title   *string       `json:"title"`   // this will be nil if the field is not provided
-		Year    *int32        `json:"year"`    // same as above
-		Runtime *data.Runtime `json:"runtime"` // same as above
-		Genres  []string      `json:"genres"`  // no pointer here because the zero value of a slice is nil
-	}
+	// a client which sends If-Match with the ETag of the movie it fetched gets a 412 if the movie has changed since,
+	// rather than overwriting somebody else's changes. The ETag is the one GET /v1/movies/:id sends without include,
+	// so it is computed with the favorited flag, on a copy, as the movie itself goes on to the change hooks
+	fetched := *movie
 
-	// read the JSON request body data into the input struct
-	err = app.readJSON(w, r, &input)
+	_, err = app.markFavorites(r, &fetched)
 	if err != nil {
-		app.badRequestResponse(w, r, err)
+		app.serverErrorResponse(w, r, err)
 		return
 	}
 
-	// update the movie record in the database with the updated details
-	// if the field is not provided, we use the existing value
-	if input.Title != nil {
-		movie.Title = *input.Title // dereference the pointer (*) to get the value
-	}
-	if input.Year != nil {
-		movie.Year = *input.Year
-	}
-	if input.Runtime != nil {
-		movie.Runtime = *input.Runtime
+	if !app.checkIfMatch(w, r, envelope{"movie": app.presentMovie(r, &fetched)}) {
+		return
 	}
-	if input.Genres != nil {
-		movie.Genres = input.Genres // no need to dereference the pointer here
+
+	// a JSON Patch or JSON Merge Patch is applied to the editable fields of the movie, which lets a client remove a
+	// genre or clear a field, something the plain partial update below can't express
+	if mediaType := patchMediaType(r); mediaType != "" {
+		current := moviePatchDocument{
+			Title:    movie.Title,
+			Year:     movie.Year,
+			Runtime:  movie.Runtime,
+			Genres:   movie.Genres,
+			Language: movie.Language,
+			Country:  movie.Country,
+		}
+
+		var patched moviePatchDocument
+
+		err = app.readPatch(w, r, mediaType, current, &patched)
+		if err != nil {
+			switch {
+			case errors.Is(err, errPatchTestFailed):
+				app.errorResponse(w, r, http.StatusConflict, "a test operation in the patch failed, the movie doesn't match it")
+			default:
+				app.badRequestResponse(w, r, err)
+			}
+			return
+		}
+
+		movie.Title = patched.Title
+		movie.Year = patched.Year
+		movie.Runtime = patched.Runtime
+		movie.Genres = patched.Genres
+		movie.Language = patched.Language
+		movie.Country = patched.Country
+	} else {
+		// To support partial updates, we change the type to pointers and use the zero value to determine if the field was provided.
+		// by checking if the field is nil or not
+		var input struct {
+			Title    *string       `json:"title"`   // this will be nil if the field is not provided
+			Year     *int32        `json:"year"`    // same as above
+			Runtime  *data.Runtime `json:"runtime"` // same as above
+			Genres   []string      `json:"genres"`  // no pointer here because the zero value of a slice is nil
+			Language *string       `json:"language"`
+			Country  *string       `json:"country"`
+		}
+
+		// read the JSON request body data into the input struct
+		err = app.readJSON(w, r, &input)
+		if err != nil {
+			app.badRequestResponse(w, r, err)
+			return
+		}
+
+		// update the movie record in the database with the updated details
+		// if the field is not provided, we use the existing value
+		if input.Title != nil {
+			movie.Title = *input.Title // dereference the pointer (*) to get the value
+		}
+		if input.Year != nil {
+			movie.Year = *input.Year
+		}
+		if input.Runtime != nil {
+			movie.Runtime = *input.Runtime
+		}
+		if input.Genres != nil {
+			movie.Genres = input.Genres // no need to dereference the pointer here
+		}
+		if input.Language != nil {
+			movie.Language = *input.Language
+		}
+		if input.Country != nil {
+			movie.Country = *input.Country
+		}
 	}
 
 	// validate the updated movie record
@@ -188,7 +582,7 @@ func (app *application) updateMovieHandler(w http.ResponseWriter, r *http.Reques
 
 	// pass the updated movie record to the Update() method
 	// intercept any edit conflict errors and return a 409 status code
-	err = app.models.Movies.Update(movie)
+	err = app.models.Movies.Update(r.Context(), movie)
 	if err != nil {
 		switch {
 		case errors.Is(err, data.ErrEditConflict):
@@ -199,8 +593,12 @@ func (app *application) updateMovieHandler(w http.ResponseWriter, r *http.Reques
 		return
 	}
 
-	// write the updated movie record in the JSON response
-	err = app.writeJSON(w, http.StatusOK, envelope{"movie": movie}, nil)
+	app.recordAudit(r, data.AuditCategoryContent, "movie_updated", fmt.Sprintf("movie:%d", movie.ID))
+
+	movie.Favorited = fetched.Favorited
+
+	// write the updated movie record in the JSON response, with its new ETag for the next conditional update
+	err = app.writeResponseWithETag(w, r, http.StatusOK, envelope{"movie": app.presentMovie(r, movie)}, nil)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 	}
@@ -214,8 +612,20 @@ func (app *application) deleteMovieHandler(w http.ResponseWriter, r *http.Reques
 		return
 	}
 
+	// fetch the movie first, so its poster can be removed from storage once the record is gone
+	movie, err := app.models.Movies.GetForWrite(r.Context(), id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
 	// delete the movie record from the database, sending a 404 not found response if the record does not exist
-	err = app.models.Movies.Delete(id)
+	err = app.models.Movies.Delete(r.Context(), id)
 	if err != nil {
 		switch {
 		case errors.Is(err, data.ErrRecordNotFound):
@@ -226,8 +636,14 @@ func (app *application) deleteMovieHandler(w http.ResponseWriter, r *http.Reques
 		return
 	}
 
+	if movie.PosterKey != "" {
+		app.deleteStoredObject(r, movie.PosterKey)
+	}
+
+	app.recordAudit(r, data.AuditCategoryContent, "movie_deleted", fmt.Sprintf("movie:%d", id))
+
 	// send a 200 OK response if the record was deleted successfully
-	err = app.writeJSON(w, http.StatusOK, envelope{"message": "movie successfully deleted"}, nil)
+	err = app.writeResponse(w, r, http.StatusOK, envelope{"message": "movie successfully deleted"}, nil)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 	}
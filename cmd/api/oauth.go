@@ -0,0 +1,195 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/julienschmidt/httprouter"
+	"github.com/nytro04/greenlight/internal/data"
+	"github.com/nytro04/greenlight/internal/oauth"
+)
+
+// oauthStateTTL is how long a user has to complete the login at the provider
+const oauthStateTTL = 10 * time.Minute
+
+// newOAuthProviders creates the social login providers which have a client ID configured, keyed by their name
+func newOAuthProviders(cfg config) (map[string]*oauth.Provider, error) {
+	clients := map[string]struct{ id, secret string }{
+		oauth.Google: {cfg.oauth.googleClientID, cfg.oauth.googleClientSecret},
+		oauth.GitHub: {cfg.oauth.githubClientID, cfg.oauth.githubClientSecret},
+	}
+
+	providers := make(map[string]*oauth.Provider)
+
+	for name, client := range clients {
+		if client.id == "" {
+			continue
+		}
+
+		redirectURL := fmt.Sprintf("%s/v1/oauth/%s/callback", cfg.sso.baseURL, name)
+
+		provider, err := oauth.New(name, client.id, client.secret, redirectURL)
+		if err != nil {
+			return nil, err
+		}
+
+		providers[name] = provider
+	}
+
+	return providers, nil
+}
+
+// readOAuthProvider returns the provider named in the URL, sending a 404 if it isn't one which has been configured
+func (app *application) readOAuthProvider(w http.ResponseWriter, r *http.Request) (*oauth.Provider, bool) {
+	provider, ok := app.oauth[httprouter.ParamsFromContext(r.Context()).ByName("provider")]
+	if !ok {
+		app.notFoundResponse(w, r)
+		return nil, false
+	}
+
+	return provider, true
+}
+
+// oauthLoginHandler starts a social login by redirecting the user to the provider's consent page
+func (app *application) oauthLoginHandler(w http.ResponseWriter, r *http.Request) {
+	provider, ok := app.readOAuthProvider(w, r)
+	if !ok {
+		return
+	}
+
+	state, verifier, err := app.models.OAuth.NewState(provider.Name(), oauthStateTTL)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	http.Redirect(w, r, provider.AuthCodeURL(state, verifier), http.StatusFound)
+}
+
+// oauthCallbackHandler completes a social login. The code is exchanged for the user's profile at the provider, and the
+// user is found by the account they linked before, by their verified email address (linking the account to it) or
+// created just in time. They are then issued an authentication token in the same way as a password login, unless they
+// have turned on two-factor authentication
+func (app *application) oauthCallbackHandler(w http.ResponseWriter, r *http.Request) {
+	provider, ok := app.readOAuthProvider(w, r)
+	if !ok {
+		return
+	}
+
+	qs := r.URL.Query()
+
+	// the provider redirects back with an error parameter if the user didn't give their consent
+	if qs.Get("error") != "" {
+		app.badRequestResponse(w, r, fmt.Errorf("provider returned an error: %s", qs.Get("error")))
+		return
+	}
+
+	verifier, err := app.models.OAuth.ConsumeState(provider.Name(), qs.Get("state"))
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.badRequestResponse(w, r, errors.New("invalid or expired state parameter"))
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	profile, err := provider.Exchange(r.Context(), qs.Get("code"), verifier)
+	if err != nil {
+		app.logError(r, err)
+		app.recordSecurityEvent(r, data.SecurityEventLoginFailed, map[string]string{"provider": provider.Name(), "reason": "oauth code exchange failed"})
+		app.invalidCredentialsResponse(w, r)
+		return
+	}
+
+	user, err := app.models.OAuth.GetUser(provider.Name(), profile.Subject)
+	linked := err == nil
+	switch {
+	case err == nil:
+	case errors.Is(err, data.ErrRecordNotFound):
+		// accounts are only linked by email address if the provider has verified it, otherwise anybody who can
+		// add an address to their account at the provider could take over an existing account
+		if profile.Email == "" || !profile.EmailVerified {
+			app.recordSecurityEvent(r, data.SecurityEventLoginFailed, map[string]string{"provider": provider.Name(), "reason": "oauth email not verified"})
+			app.errorResponse(w, r, http.StatusForbidden, "your account at the provider must have a verified email address")
+			return
+		}
+
+		user, err = app.findOrProvisionOAuthUser(r.Context(), profile)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+	default:
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	if user.Disabled() {
+		app.recordSecurityEvent(r, data.SecurityEventLoginFailed, map[string]string{"provider": provider.Name(), "email": user.Email, "reason": "account disabled"})
+		app.accountDisabledResponse(w, r)
+		return
+	}
+
+	// the login at the provider doesn't stand in for the user's second factor, and the callback can't be sent again
+	// with a code, so the users who have turned on two-factor authentication sign in with their password and code.
+	// The account isn't linked to them either
+	t, err := app.getEnabledTOTP(user.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	if t != nil {
+		app.recordSecurityEvent(r, data.SecurityEventLoginFailed, map[string]string{"provider": provider.Name(), "email": user.Email, "reason": "oauth login with two-factor enabled"})
+		app.errorResponse(w, r, http.StatusForbidden, "your account has two-factor authentication turned on, sign in with your password and code instead")
+		return
+	}
+
+	if !linked {
+		err = app.models.OAuth.Link(user.ID, provider.Name(), profile.Subject)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+	}
+
+	authToken, err := app.newAuthenticationToken(r, user)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	app.recordAudit(r, data.AuditCategoryAuth, "login_oauth", user.Email)
+
+	err = app.writeJSON(w, http.StatusCreated, envelope{"authentication_token": authToken}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// findOrProvisionOAuthUser returns the user with the verified email address of the profile, activating them if they
+// hadn't activated their account yet (unless they are disabled), or creates an activated account for them
+func (app *application) findOrProvisionOAuthUser(ctx context.Context, profile *oauth.Profile) (*data.User, error) {
+	user, err := app.models.Users.GetByEmail(ctx, profile.Email)
+	switch {
+	case err == nil:
+		// the email address has been verified by the provider, so there is no need for the activation email
+		if !user.Activated && !user.Disabled() {
+			user.Activated = true
+			err = app.models.Users.Update(ctx, user)
+			if err != nil {
+				return nil, err
+			}
+		}
+		return user, nil
+	case errors.Is(err, data.ErrRecordNotFound):
+		return app.provisionSSOUser(ctx, ssoClaims{Email: profile.Email, EmailVerified: profile.EmailVerified, Name: profile.Name})
+	default:
+		return nil, err
+	}
+}
@@ -0,0 +1,961 @@
+package main
+
+import (
+	"encoding/json"
+	"html/template"
+	"net/http"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/julienschmidt/httprouter"
+	"github.com/nytro04/greenlight/internal/data"
+	"github.com/nytro04/greenlight/internal/graphql"
+	"github.com/nytro04/greenlight/internal/mailer"
+)
+
+// routeRecorder wraps the router and records every route registered on it, so the OpenAPI specification is generated
+// from the routes which are actually served and a new route can't be left out of it. Every GET route is served for
+// HEAD as well, and the routes registered with StaticHandlerFunc get the same OPTIONS and 405 handling as the others
+type routeRecorder struct {
+	*httprouter.Router
+	routes []recordedRoute
+	static map[string]http.Handler
+}
+
+type recordedRoute struct {
+	method string
+	path   string
+}
+
+func (rr *routeRecorder) HandlerFunc(method, path string, handler http.HandlerFunc) {
+	rr.Handler(method, path, handler)
+}
+
+func (rr *routeRecorder) Handler(method, path string, handler http.Handler) {
+	rr.routes = append(rr.routes, recordedRoute{method: method, path: path})
+	rr.Router.Handler(method, path, instrumentRoute(path, handler))
+
+	if method == http.MethodGet {
+		rr.Router.Handler(http.MethodHead, path, instrumentRoute(path, headHandler(handler)))
+	}
+}
+
+// StaticHandlerFunc registers a route with a fixed path which httprouter refuses because a wildcard uses the same path
+// segment, such as POST /v1/movies/import next to POST /v1/movies/:id/poster. These routes are matched exactly
+// before the request reaches httprouter, and the other methods on their paths aren't passed on to the wildcard routes
+func (rr *routeRecorder) StaticHandlerFunc(method, path string, handler http.HandlerFunc) {
+	if rr.static == nil {
+		rr.static = make(map[string]http.Handler)
+	}
+
+	rr.routes = append(rr.routes, recordedRoute{method: method, path: path})
+	rr.static[method+" "+path] = instrumentRoute(path, handler)
+
+	if method == http.MethodGet {
+		rr.static[http.MethodHead+" "+path] = instrumentRoute(path, headHandler(handler))
+	}
+}
+
+func (rr *routeRecorder) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if handler, ok := rr.static[r.Method+" "+r.URL.Path]; ok {
+		handler.ServeHTTP(w, r)
+		return
+	}
+
+	if allow := rr.staticAllowed(r.URL.Path); allow != "" {
+		w.Header().Set("Allow", allow)
+
+		switch {
+		case r.Method == http.MethodOptions && rr.GlobalOPTIONS != nil:
+			rr.GlobalOPTIONS.ServeHTTP(w, r)
+		case r.Method == http.MethodOptions:
+		case rr.MethodNotAllowed != nil:
+			rr.MethodNotAllowed.ServeHTTP(w, r)
+		default:
+			http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		}
+		return
+	}
+
+	rr.Router.ServeHTTP(w, r)
+}
+
+// staticAllowed returns the value of the Allow header for a path registered with StaticHandlerFunc, in the same form
+// httprouter uses for the other routes, or an empty string if no static route has the path
+func (rr *routeRecorder) staticAllowed(path string) string {
+	var allowed []string
+
+	for key := range rr.static {
+		method, staticPath, _ := strings.Cut(key, " ")
+		if staticPath == path {
+			allowed = append(allowed, method)
+		}
+	}
+
+	if len(allowed) == 0 {
+		return ""
+	}
+
+	allowed = append(allowed, http.MethodOptions)
+	sort.Strings(allowed)
+
+	return strings.Join(allowed, ", ")
+}
+
+// apiOperation annotates a route with what the specification can't work out from the route itself. Request is a value
+// of the request body type and Response maps the keys of the response envelope to values of their types, the schemas
+// are generated from the types by reflection
+type apiOperation struct {
+	Summary    string
+	Tag        string
+	Permission string // the permission required, or "authenticated" for routes which only need a signed in user
+	Query      []string
+	Request    any
+	Response   map[string]any
+	Status     int  // the success status code, 200 if not set
+	Patch      bool // the request body can also be a JSON Patch or a JSON Merge Patch
+}
+
+// page is the query string accepted by the paginated list endpoints
+var page = []string{"page", "page_size", "sort"}
+
+// apiOperations holds the annotations of the documented routes, keyed by method and path
+var apiOperations = map[string]apiOperation{
+	"GET /v1/healthcheck":  {Summary: "Show the application status", Tag: "meta"},
+	"GET /v1/healthz":      {Summary: "Check the process is alive", Tag: "meta", Response: map[string]any{"status": ""}},
+	"GET /v1/readyz":       {Summary: "Check the database, migrations and mail provider", Tag: "meta", Response: map[string]any{"status": "", "checks": map[string]dependencyCheck{}}},
+	"GET /v1/meta/limits":  {Summary: "Show the limits the API enforces", Tag: "meta"},
+	"GET /v1/openapi.json": {Summary: "Show this OpenAPI specification", Tag: "meta"},
+
+	"GET /v1/movies": {
+		Summary: "List movies", Tag: "movies", Permission: "movies:read",
+		Query:    append([]string{"title", "genres", "tags", "language", "country", "include", "fields", "fuzzy", "cursor", "exact_count", "year_min", "year_max", "runtime_min", "runtime_max", "created_after", "created_before"}, page...),
+		Response: map[string]any{"movies": []data.Movie{}, "metadata": data.Metadata{}},
+	},
+	"POST /v1/movies": {
+		Summary: "Create a movie", Tag: "movies", Permission: "movies:write", Status: http.StatusCreated,
+		Request: struct {
+			Title    string       `json:"title"`
+			Runtime  data.Runtime `json:"runtime"`
+			Genres   []string     `json:"genres"`
+			Year     int32        `json:"year"`
+			Language string       `json:"language"`
+			Country  string       `json:"country"`
+		}{},
+		Response: map[string]any{"movie": data.Movie{}},
+	},
+	"GET /v1/movies/:id": {
+		Summary: "Show a movie", Tag: "movies", Permission: "movies:read", Query: []string{"include", "fields"},
+		Response: map[string]any{"movie": data.Movie{}},
+	},
+	"PATCH /v1/movies/:id": {
+		Summary: "Partially update a movie", Tag: "movies", Permission: "movies:write",
+		Request: struct {
+			Title    *string       `json:"title"`
+			Year     *int32        `json:"year"`
+			Runtime  *data.Runtime `json:"runtime"`
+			Genres   []string      `json:"genres"`
+			Language *string       `json:"language"`
+			Country  *string       `json:"country"`
+		}{},
+		Response: map[string]any{"movie": data.Movie{}},
+		Patch:    true,
+	},
+	"POST /v1/movies/batch": {
+		Summary: "Create up to 100 movies, either all of them or none", Tag: "movies", Permission: "movies:write", Status: http.StatusCreated,
+		Request: struct {
+			Movies []importMovie `json:"movies"`
+		}{},
+		Response: map[string]any{"ids": []int64{}, "movies": []data.Movie{}},
+	},
+	"POST /v1/movies/import": {
+		Summary: "Import movies in bulk from a JSON array, NDJSON or CSV upload", Tag: "movies", Permission: "movies:write",
+		Request:  []importMovie{},
+		Response: map[string]any{"import": importReport{}},
+	},
+	"POST /v1/movies/import/tmdb": {
+		Summary: "Create a movie from its metadata on The Movie Database", Tag: "movies", Permission: "movies:write", Status: http.StatusCreated,
+		Request: struct {
+			TMDBID int64 `json:"tmdb_id"`
+		}{},
+		Response: map[string]any{"movie": data.Movie{}},
+	},
+	"GET /v1/movies/stats": {
+		Summary: "Show statistics about the catalog", Tag: "movies", Permission: "movies:read",
+		Response: map[string]any{"stats": data.MovieStats{}},
+	},
+	"GET /v1/movies/trending": {
+		Summary: "List the movies viewed the most recently", Tag: "movies", Permission: "movies:read",
+		Query:    []string{"window", "page", "page_size"},
+		Response: map[string]any{"movies": []data.TrendingMovie{}, "metadata": data.Metadata{}, "window": ""},
+	},
+	"GET /v1/movies/random": {
+		Summary: "Show a movie picked at random from the ones matching the filters", Tag: "movies", Permission: "movies:read",
+		Query:    []string{"title", "genres", "tags", "language", "country", "fuzzy", "fields", "year_min", "year_max", "runtime_min", "runtime_max", "created_after", "created_before"},
+		Response: map[string]any{"movie": data.Movie{}},
+	},
+	"GET /v1/movies/export": {
+		Summary: "Export the movies as CSV or NDJSON", Tag: "movies", Permission: "movies:read",
+		Query: []string{"format", "title", "genres", "tags", "language", "country", "fuzzy", "year_min", "year_max", "runtime_min", "runtime_max", "created_after", "created_before"},
+	},
+	"DELETE /v1/movies/:id": {Summary: "Delete a movie", Tag: "movies", Permission: "movies:write", Response: map[string]any{"message": ""}},
+
+	"GET /v2/movies": {
+		Summary: "List movies", Tag: "movies", Permission: "movies:read",
+		Query:    append([]string{"title", "genres", "language", "country", "include", "fields", "fuzzy", "cursor", "exact_count", "year_min", "year_max", "runtime_min", "runtime_max", "created_after", "created_before"}, page...),
+		Response: map[string]any{"movies": []movieV2{}, "metadata": data.Metadata{}},
+	},
+	"POST /v2/movies": {
+		Summary: "Create a movie", Tag: "movies", Permission: "movies:write", Status: http.StatusCreated,
+		Request: struct {
+			Title    string     `json:"title"`
+			Runtime  isoRuntime `json:"runtime"`
+			Genres   []string   `json:"genres"`
+			Year     int32      `json:"year"`
+			Language string     `json:"language"`
+			Country  string     `json:"country"`
+		}{},
+		Response: map[string]any{"movie": movieV2{}},
+	},
+	"GET /v2/movies/:id": {
+		Summary: "Show a movie", Tag: "movies", Permission: "movies:read", Query: []string{"include", "fields"},
+		Response: map[string]any{"movie": movieV2{}},
+	},
+	"PATCH /v2/movies/:id": {
+		Summary: "Partially update a movie", Tag: "movies", Permission: "movies:write",
+		Request: struct {
+			Title    *string     `json:"title"`
+			Year     *int32      `json:"year"`
+			Runtime  *isoRuntime `json:"runtime"`
+			Genres   []string    `json:"genres"`
+			Language *string     `json:"language"`
+			Country  *string     `json:"country"`
+		}{},
+		Response: map[string]any{"movie": movieV2{}},
+		Patch:    true,
+	},
+	"POST /v2/movies/batch": {
+		Summary: "Create up to 100 movies, either all of them or none", Tag: "movies", Permission: "movies:write", Status: http.StatusCreated,
+		Request: struct {
+			Movies []importMovie `json:"movies"`
+		}{},
+		Response: map[string]any{"ids": []int64{}, "movies": []movieV2{}},
+	},
+	"DELETE /v2/movies/:id": {Summary: "Delete a movie", Tag: "movies", Permission: "movies:write", Response: map[string]any{"message": ""}},
+
+	"GET /v1/movies/:id/poster":                {Summary: "Download the poster of a movie, or redirect to it", Tag: "movies", Permission: "movies:read"},
+	"POST /v1/movies/:id/poster":               {Summary: "Upload the poster of a movie as multipart/form-data", Tag: "movies", Permission: "movies:write", Response: map[string]any{"movie": data.Movie{}}},
+	"GET /v1/movies/:id/credits":               {Summary: "List the cast and crew of a movie", Tag: "people", Permission: "movies:read", Response: map[string]any{"credits": []data.Credit{}}},
+	"POST /v1/movies/:id/credits":              {Summary: "Credit a person on a movie", Tag: "people", Permission: "movies:write", Status: http.StatusCreated, Request: data.Credit{}, Response: map[string]any{"credit": data.Credit{}}},
+	"DELETE /v1/movies/:id/credits/:credit_id": {Summary: "Remove a credit from a movie", Tag: "people", Permission: "movies:write", Response: map[string]any{"message": ""}},
+
+	"GET /v1/movies/:id/reviews": {
+		Summary: "List the reviews of a movie", Tag: "reviews", Permission: "movies:read", Query: page,
+		Response: map[string]any{"reviews": []data.Review{}, "metadata": data.Metadata{}},
+	},
+	"POST /v1/movies/:id/reviews": {
+		Summary: "Review a movie", Tag: "reviews", Permission: "authenticated", Status: http.StatusCreated,
+		Request: struct {
+			Rating int8   `json:"rating"`
+			Body   string `json:"body"`
+		}{},
+		Response: map[string]any{"review": data.Review{}},
+	},
+	"PATCH /v1/movies/:id/reviews/:review_id": {
+		Summary: "Partially update your review", Tag: "reviews", Permission: "authenticated",
+		Request: struct {
+			Rating *int8   `json:"rating"`
+			Body   *string `json:"body"`
+		}{},
+		Response: map[string]any{"review": data.Review{}},
+	},
+	"DELETE /v1/movies/:id/reviews/:review_id": {Summary: "Delete your review", Tag: "reviews", Permission: "authenticated", Response: map[string]any{"message": ""}},
+	"POST /v1/reviews/:id/report": {
+		Summary: "Report a review to the moderators", Tag: "reviews", Permission: "authenticated", Status: http.StatusCreated,
+		Request: struct {
+			Reason string `json:"reason"`
+		}{},
+		Response: map[string]any{"report": data.ReviewReport{}},
+	},
+
+	"GET /v1/movies/:id/tags": {Summary: "List the tags of a movie", Tag: "tags", Permission: "movies:read", Response: map[string]any{"tags": []data.MovieTag{}}},
+	"POST /v1/movies/:id/tags": {
+		Summary: "Tag a movie, leaving the tags it already has as they are", Tag: "tags", Permission: "authenticated",
+		Request: struct {
+			Tags []string `json:"tags"`
+		}{},
+		Response: map[string]any{"tags": []data.MovieTag{}},
+	},
+	"DELETE /v1/movies/:id/tags/:tag": {Summary: "Remove a tag you added from a movie, or any tag with movies:write", Tag: "tags", Permission: "authenticated", Response: map[string]any{"message": ""}},
+	"GET /v1/me/tag-suggestions": {
+		Summary: "Suggest tags starting with a prefix, the ones you use the most first", Tag: "tags", Permission: "authenticated",
+		Query:    []string{"prefix", "limit"},
+		Response: map[string]any{"suggestions": []data.TagSuggestion{}},
+	},
+
+	"GET /v1/graphql": {
+		Summary: "Run a GraphQL query given in the query string", Tag: "graphql", Permission: "movies:read",
+		Query:    []string{"query", "operationName", "variables"},
+		Response: graphqlResponse,
+	},
+	"POST /v1/graphql": {
+		Summary: "Run a GraphQL query", Tag: "graphql", Permission: "movies:read",
+		Request:  graphql.Request{},
+		Response: graphqlResponse,
+	},
+
+	"GET /v1/people": {
+		Summary: "List people", Tag: "people", Permission: "movies:read", Query: append([]string{"name"}, page...),
+		Response: map[string]any{"people": []data.Person{}, "metadata": data.Metadata{}},
+	},
+	"POST /v1/people":           {Summary: "Create a person", Tag: "people", Permission: "movies:write", Status: http.StatusCreated, Request: data.Person{}, Response: map[string]any{"person": data.Person{}}},
+	"GET /v1/people/:id":        {Summary: "Show a person", Tag: "people", Permission: "movies:read", Response: map[string]any{"person": data.Person{}}},
+	"PATCH /v1/people/:id":      {Summary: "Partially update a person", Tag: "people", Permission: "movies:write", Request: data.Person{}, Response: map[string]any{"person": data.Person{}}},
+	"DELETE /v1/people/:id":     {Summary: "Delete a person", Tag: "people", Permission: "movies:write", Response: map[string]any{"message": ""}},
+	"GET /v1/people/:id/movies": {Summary: "List the movies a person is credited on", Tag: "people", Permission: "movies:read", Response: map[string]any{"credits": []data.Credit{}}},
+
+	"GET /v1/series": {
+		Summary: "List series", Tag: "series", Permission: "movies:read", Query: append([]string{"name"}, page...),
+		Response: map[string]any{"series": []data.Series{}, "metadata": data.Metadata{}},
+	},
+	"POST /v1/series": {
+		Summary: "Create a series", Tag: "series", Permission: "movies:write", Status: http.StatusCreated,
+		Request: struct {
+			Name        string `json:"name"`
+			Description string `json:"description"`
+		}{},
+		Response: map[string]any{"series": data.Series{}},
+	},
+	"GET /v1/series/:id": {Summary: "Show a series with its movies in order", Tag: "series", Permission: "movies:read", Response: map[string]any{"series": data.Series{}, "movies": []data.Movie{}}},
+	"PATCH /v1/series/:id": {
+		Summary: "Partially update a series", Tag: "series", Permission: "movies:write",
+		Request: struct {
+			Name        *string `json:"name"`
+			Description *string `json:"description"`
+		}{},
+		Response: map[string]any{"series": data.Series{}},
+	},
+	"DELETE /v1/series/:id": {Summary: "Delete a series, keeping its movies", Tag: "series", Permission: "movies:write", Response: map[string]any{"message": ""}},
+	"PUT /v1/series/:id/movies": {
+		Summary: "Replace the movies of a series, in order", Tag: "series", Permission: "movies:write",
+		Request: struct {
+			MovieIDs []int64 `json:"movie_ids"`
+		}{},
+		Response: map[string]any{"series": data.Series{}, "movies": []data.Movie{}},
+	},
+
+	"POST /v1/users": {
+		Summary: "Register a user", Tag: "users", Status: http.StatusAccepted,
+		Request: struct {
+			Name     string `json:"name"`
+			Email    string `json:"email"`
+			Password string `json:"password"`
+		}{},
+		Response: map[string]any{"user": data.User{}},
+	},
+	"PUT /v1/users/activated": {
+		Summary: "Activate a user with their activation token", Tag: "users",
+		Request: struct {
+			Token string `json:"token"`
+		}{},
+		Response: map[string]any{"user": data.User{}},
+	},
+	"PUT /v1/users/email": {
+		Summary: "Confirm an email change with the token sent to the new address", Tag: "users",
+		Request: struct {
+			Token string `json:"token"`
+		}{},
+		Response: map[string]any{"user": data.User{}},
+	},
+	"GET /v1/users": {
+		Summary: "List users", Tag: "admin", Permission: "users:admin", Query: append([]string{"email", "activated"}, page...),
+		Response: map[string]any{"users": []data.User{}, "metadata": data.Metadata{}},
+	},
+	"GET /v1/users/:id": {Summary: "Show a user", Tag: "admin", Permission: "users:admin", Response: map[string]any{"user": data.User{}}},
+	"PATCH /v1/users/:id": {
+		Summary: "Partially update a user, disabling them locks them out until they are enabled again", Tag: "admin", Permission: "users:admin",
+		Request: struct {
+			Name      *string `json:"name"`
+			Email     *string `json:"email"`
+			Activated *bool   `json:"activated"`
+			Disabled  *bool   `json:"disabled"`
+		}{},
+		Response: map[string]any{"user": data.User{}},
+	},
+	"DELETE /v1/users/:id":          {Summary: "Delete a user", Tag: "admin", Permission: "users:admin", Response: map[string]any{"message": ""}},
+	"GET /v1/users/:id/avatar":      {Summary: "Download the avatar of a user, or redirect to it", Tag: "users", Permission: "movies:read"},
+	"GET /v1/users/:id/permissions": {Summary: "List the permissions of a user", Tag: "admin", Permission: "users:admin", Response: map[string]any{"permissions": data.Permissions{}}},
+	"POST /v1/users/:id/permissions": {
+		Summary: "Grant permissions or roles to a user", Tag: "admin", Permission: "users:admin",
+		Request:  permissionChange{},
+		Response: map[string]any{"permissions": data.Permissions{}},
+	},
+	"DELETE /v1/users/:id/permissions": {
+		Summary: "Revoke permissions or roles from a user", Tag: "admin", Permission: "users:admin",
+		Request:  permissionChange{},
+		Response: map[string]any{"permissions": data.Permissions{}},
+	},
+	"GET /v1/permissions":  {Summary: "List the permissions which can be granted", Tag: "admin", Permission: "users:admin", Response: map[string]any{"permissions": data.Permissions{}}},
+	"GET /v1/roles":        {Summary: "List roles", Tag: "admin", Permission: "users:admin", Response: map[string]any{"roles": []data.Role{}}},
+	"POST /v1/roles":       {Summary: "Create a role", Tag: "admin", Permission: "users:admin", Status: http.StatusCreated, Request: data.Role{}, Response: map[string]any{"role": data.Role{}}},
+	"DELETE /v1/roles/:id": {Summary: "Delete a role", Tag: "admin", Permission: "users:admin", Response: map[string]any{"message": ""}},
+
+	"POST /v1/tokens/authentication": {
+		Summary: "Create an authentication token", Tag: "tokens", Status: http.StatusCreated,
+		Request: struct {
+			Email        string `json:"email"`
+			Password     string `json:"password"`
+			TOTPCode     string `json:"totp_code"`
+			RecoveryCode string `json:"recovery_code"`
+		}{},
+		Response: map[string]any{"authentication_token": data.Token{}},
+	},
+	"DELETE /v1/tokens/authentication": {
+		Summary: "Log out by revoking your authentication token, or all of them", Tag: "tokens", Permission: "authenticated",
+		Query: []string{"all"}, Response: map[string]any{"message": ""},
+	},
+	"POST /v1/tokens/activation": {
+		Summary: "Send a new activation token", Tag: "tokens", Status: http.StatusAccepted,
+		Request: struct {
+			Email string `json:"email"`
+		}{},
+		Response: map[string]any{"message": ""},
+	},
+
+	"GET /v1/me/watchlist": {
+		Summary: "List the movies on your watchlist", Tag: "watchlist", Permission: "movies:read", Query: page,
+		Response: map[string]any{"movies": []data.Movie{}, "metadata": data.Metadata{}},
+	},
+	"POST /v1/me/watchlist/:movie_id":   {Summary: "Add a movie to your watchlist", Tag: "watchlist", Permission: "movies:read"},
+	"DELETE /v1/me/watchlist/:movie_id": {Summary: "Remove a movie from your watchlist", Tag: "watchlist", Permission: "movies:read"},
+
+	"GET /v1/me/favorites": {
+		Summary: "List your favorite movies", Tag: "favorites", Permission: "movies:read", Query: page,
+		Response: map[string]any{"movies": []data.Movie{}, "metadata": data.Metadata{}},
+	},
+	"PUT /v1/me/favorites/:movie_id":    {Summary: "Mark a movie as a favorite", Tag: "favorites", Permission: "movies:read", Response: map[string]any{"message": ""}},
+	"DELETE /v1/me/favorites/:movie_id": {Summary: "Unmark a movie as a favorite", Tag: "favorites", Permission: "movies:read", Response: map[string]any{"message": ""}},
+
+	"GET /v1/me/lists": {
+		Summary: "List your lists, public and private", Tag: "lists", Permission: "authenticated", Query: page,
+		Response: map[string]any{"lists": []data.MovieList{}, "metadata": data.Metadata{}},
+	},
+	"POST /v1/lists": {
+		Summary: "Create a list of movies, private unless public is set", Tag: "lists", Permission: "authenticated", Status: http.StatusCreated,
+		Request: struct {
+			Name        string `json:"name"`
+			Description string `json:"description"`
+			Public      bool   `json:"public"`
+		}{},
+		Response: map[string]any{"list": data.MovieList{}},
+	},
+	"GET /v1/lists/:id": {
+		Summary: "Show a list with a page of its movies, public lists need no authentication", Tag: "lists", Query: page,
+		Response: map[string]any{"list": data.MovieList{}, "movies": []data.Movie{}, "metadata": data.Metadata{}},
+	},
+	"PATCH /v1/lists/:id": {
+		Summary: "Partially update your list", Tag: "lists", Permission: "authenticated",
+		Request: struct {
+			Name        *string `json:"name"`
+			Description *string `json:"description"`
+			Public      *bool   `json:"public"`
+		}{},
+		Response: map[string]any{"list": data.MovieList{}},
+	},
+	"DELETE /v1/lists/:id": {Summary: "Delete your list", Tag: "lists", Permission: "authenticated", Response: map[string]any{"message": ""}},
+	"PUT /v1/lists/:id/movies": {
+		Summary: "Reorder the movies of your list, giving the ID of every movie on it in the new order", Tag: "lists", Permission: "authenticated",
+		Request: struct {
+			MovieIDs []int64 `json:"movie_ids"`
+		}{},
+		Response: map[string]any{"message": ""},
+	},
+	"PUT /v1/lists/:id/movies/:movie_id":    {Summary: "Add a movie to the end of your list", Tag: "lists", Permission: "authenticated", Response: map[string]any{"message": ""}},
+	"DELETE /v1/lists/:id/movies/:movie_id": {Summary: "Remove a movie from your list", Tag: "lists", Permission: "authenticated", Response: map[string]any{"message": ""}},
+
+	"POST /v1/tokens/webauthn/options": {Summary: "Start a passkey login", Tag: "tokens"},
+	"POST /v1/tokens/webauthn":         {Summary: "Create an authentication token with a passkey", Tag: "tokens", Status: http.StatusCreated, Response: map[string]any{"authentication_token": data.Token{}}},
+	"GET /v1/me/passkeys":              {Summary: "List your passkeys", Tag: "passkeys", Permission: "authenticated", Response: map[string]any{"passkeys": []data.Passkey{}}},
+	"POST /v1/me/passkeys/options":     {Summary: "Start registering a passkey", Tag: "passkeys", Permission: "authenticated"},
+	"POST /v1/me/passkeys":             {Summary: "Finish registering a passkey", Tag: "passkeys", Permission: "authenticated", Status: http.StatusCreated},
+	"DELETE /v1/me/passkeys/:id":       {Summary: "Delete one of your passkeys", Tag: "passkeys", Permission: "authenticated", Response: map[string]any{"message": ""}},
+
+	"GET /v1/me": {Summary: "Show your own user record", Tag: "users", Permission: "authenticated", Response: map[string]any{"user": data.User{}}},
+	"GET /v1/me/usage": {
+		Summary: "Show the daily and monthly quota usage of your API keys", Tag: "users", Permission: "authenticated",
+		Response: map[string]any{"usage": []data.APIKeyUsage{}},
+	},
+	"PATCH /v1/me": {
+		Summary: "Update your name, the email address is changed with PUT /v1/me/email", Tag: "users", Permission: "authenticated",
+		Request: struct {
+			Name *string `json:"name"`
+		}{},
+		Response: map[string]any{"user": data.User{}},
+	},
+	"DELETE /v1/me": {
+		Summary: "Delete your account at the end of the grace period, signing out all of your sessions", Tag: "users", Permission: "authenticated", Status: http.StatusAccepted,
+		Response: map[string]any{"message": "", "deletion_due_at": time.Time{}},
+	},
+	"DELETE /v1/me/deletion": {Summary: "Cancel the deletion of your account during the grace period", Tag: "users", Permission: "authenticated", Response: map[string]any{"message": ""}},
+	"PUT /v1/me/avatar":      {Summary: "Upload your avatar as multipart/form-data", Tag: "users", Permission: "authenticated", Response: map[string]any{"user": data.User{}}},
+	"PUT /v1/me/password": {
+		Summary: "Change your password, signing out all of your sessions", Tag: "users", Permission: "authenticated",
+		Request: struct {
+			CurrentPassword string `json:"current_password"`
+			Password        string `json:"password"`
+		}{},
+		Response: map[string]any{"message": ""},
+	},
+	"PUT /v1/me/email": {
+		Summary: "Change your email address, which is confirmed with a token sent to the new address", Tag: "users", Permission: "authenticated", Status: http.StatusAccepted,
+		Request: struct {
+			Email    string `json:"email"`
+			Password string `json:"password"`
+		}{},
+		Response: map[string]any{"message": ""},
+	},
+
+	"GET /v1/me/sessions":        {Summary: "List the sessions you are signed in with", Tag: "sessions", Permission: "authenticated", Response: map[string]any{"sessions": []data.Session{}}},
+	"DELETE /v1/me/sessions/:id": {Summary: "Sign out of one of your sessions", Tag: "sessions", Permission: "authenticated", Response: map[string]any{"message": ""}},
+
+	"POST /v1/me/totp": {Summary: "Start enrolling in two-factor authentication", Tag: "totp", Permission: "authenticated", Status: http.StatusCreated, Response: map[string]any{"totp": data.TOTPEnrollment{}}},
+	"POST /v1/me/totp/verify": {
+		Summary: "Confirm a code and enable two-factor authentication", Tag: "totp", Permission: "authenticated",
+		Request: struct {
+			Code string `json:"code"`
+		}{},
+		Response: map[string]any{"recovery_codes": []string{}},
+	},
+	"POST /v1/me/totp/recovery-codes": {
+		Summary: "Replace your two-factor recovery codes", Tag: "totp", Permission: "authenticated",
+		Request: struct {
+			Code string `json:"code"`
+		}{},
+		Response: map[string]any{"recovery_codes": []string{}},
+	},
+	"DELETE /v1/me/totp": {
+		Summary: "Disable two-factor authentication", Tag: "totp", Permission: "authenticated",
+		Request: struct {
+			Code         string `json:"code"`
+			RecoveryCode string `json:"recovery_code"`
+		}{},
+		Response: map[string]any{"message": ""},
+	},
+
+	"POST /v1/organizations":           {Summary: "Create an organization", Tag: "organizations", Permission: "authenticated", Status: http.StatusCreated, Response: map[string]any{"organization": data.Organization{}}},
+	"GET /v1/organizations/:id":        {Summary: "Show an organization you are a member of", Tag: "organizations", Permission: "authenticated", Response: map[string]any{"organization": data.Organization{}}},
+	"PUT /v1/organizations/:id/sso":    {Summary: "Configure single sign-on and the verified domains for an organization", Tag: "organizations", Permission: "users:admin"},
+	"GET /v1/sso/:slug/login":          {Summary: "Start a single sign-on login", Tag: "organizations"},
+	"GET /v1/sso/:slug/callback":       {Summary: "Finish a single sign-on login", Tag: "organizations", Status: http.StatusCreated, Response: map[string]any{"authentication_token": data.Token{}}},
+	"GET /v1/oauth/:provider/login":    {Summary: "Start a login with Google or GitHub", Tag: "tokens"},
+	"GET /v1/oauth/:provider/callback": {Summary: "Finish a login with Google or GitHub", Tag: "tokens", Status: http.StatusCreated, Response: map[string]any{"authentication_token": data.Token{}}},
+	"GET /v1/metrics":                  {Summary: "Show the application metrics", Tag: "meta"},
+	"GET /metrics":                     {Summary: "Show the application metrics in the Prometheus text format", Tag: "meta"},
+
+	"GET /v1/admin/debug/pprof/":          {Summary: "List the runtime profiles", Tag: "admin", Permission: "debug:admin"},
+	"GET /v1/admin/debug/pprof/:profile":  {Summary: "Download a runtime profile in the pprof format", Tag: "admin", Permission: "debug:admin", Query: []string{"seconds", "debug", "gc"}},
+	"POST /v1/admin/debug/pprof/:profile": {Summary: "Look up program counters, only for the symbol profile", Tag: "admin", Permission: "debug:admin"},
+
+	"GET /v1/admin/log-level": {Summary: "Show the minimum log level", Tag: "admin", Permission: "debug:admin", Response: map[string]any{"level": ""}},
+	"PUT /v1/admin/log-level": {
+		Summary: "Change the minimum log level until the next restart", Tag: "admin", Permission: "debug:admin",
+		Request: struct {
+			Level string `json:"level"`
+		}{},
+		Response: map[string]any{"level": ""},
+	},
+
+	"GET /v1/admin/audit/export": {Summary: "Export the audit log as NDJSON", Tag: "admin", Permission: "audit:read", Query: []string{"from", "to"}},
+	"GET /v1/admin/security-events": {
+		Summary: "List security events", Tag: "admin", Permission: "security:read", Query: append([]string{"type", "user_id"}, page...),
+		Response: map[string]any{"security_events": []data.SecurityEvent{}, "metadata": data.Metadata{}},
+	},
+	"GET /v1/admin/api-keys": {Summary: "List API keys", Tag: "admin", Permission: "api_keys:admin", Query: []string{"user_id"}, Response: map[string]any{"api_keys": []data.APIKey{}}},
+	"POST /v1/admin/api-keys": {
+		Summary: "Mint an API key", Tag: "admin", Permission: "api_keys:admin", Status: http.StatusCreated,
+		Request: struct {
+			Name        string     `json:"name"`
+			UserID      int64      `json:"user_id"`
+			Permissions []string   `json:"permissions"`
+			Tier        string     `json:"tier"`
+			ExpiresAt   *time.Time `json:"expires_at"`
+		}{},
+		Response: map[string]any{"api_key": data.APIKey{}},
+	},
+	"DELETE /v1/admin/api-keys/:id": {Summary: "Revoke an API key", Tag: "admin", Permission: "api_keys:admin", Response: map[string]any{"message": ""}},
+
+	"GET /v1/admin/webhooks": {Summary: "List webhooks", Tag: "admin", Permission: "webhooks:admin", Response: map[string]any{"webhooks": []data.Webhook{}}},
+	"POST /v1/admin/webhooks": {
+		Summary: "Register a webhook", Tag: "admin", Permission: "webhooks:admin", Status: http.StatusCreated,
+		Request: struct {
+			URL    string   `json:"url"`
+			Events []string `json:"events"`
+		}{},
+		Response: map[string]any{"webhook": data.Webhook{}},
+	},
+	"DELETE /v1/admin/webhooks/:id": {Summary: "Delete a webhook", Tag: "admin", Permission: "webhooks:admin", Response: map[string]any{"message": ""}},
+
+	"GET /v1/admin/reviews/reported": {
+		Summary: "List the reviews with open reports", Tag: "admin", Permission: "reviews:moderate", Query: page,
+		Response: map[string]any{"reviews": []data.ReportedReview{}, "metadata": data.Metadata{}},
+	},
+	"GET /v1/admin/reviews/:id": {
+		Summary: "Show a review with its reports and moderation decisions", Tag: "admin", Permission: "reviews:moderate",
+		Response: map[string]any{"review": data.Review{}, "reports": []data.ReviewReport{}, "decisions": []data.ModerationDecision{}},
+	},
+	"POST /v1/admin/reviews/:id/decisions": {
+		Summary: "Hide or restore a review, or dismiss its reports", Tag: "admin", Permission: "reviews:moderate", Status: http.StatusCreated,
+		Request: struct {
+			Action string `json:"action"`
+			Note   string `json:"note"`
+		}{},
+		Response: map[string]any{"decision": data.ModerationDecision{}},
+	},
+	"GET /v1/admin/webhooks/:id/deliveries": {
+		Summary: "List the deliveries of a webhook", Tag: "admin", Permission: "webhooks:admin",
+		Query:    []string{"status", "page", "page_size", "sort"},
+		Response: map[string]any{"deliveries": []data.WebhookDelivery{}, "metadata": data.Metadata{}},
+	},
+
+	"GET /v1/admin/email-templates": {Summary: "List the email templates", Tag: "admin", Permission: "emails:admin", Response: map[string]any{"email_templates": []emailTemplateSummary{}}},
+	"GET /v1/admin/email-templates/:name": {
+		Summary: "Show an email template and its saved versions", Tag: "admin", Permission: "emails:admin",
+		Response: map[string]any{"email_template": data.EmailTemplate{}, "versions": []data.EmailTemplate{}},
+	},
+	"PUT /v1/admin/email-templates/:name": {
+		Summary: "Save a new version of an email template", Tag: "admin", Permission: "emails:admin",
+		Request: struct {
+			Subject   string `json:"subject"`
+			PlainBody string `json:"plain_body"`
+			HTMLBody  string `json:"html_body"`
+		}{},
+		Response: map[string]any{"email_template": data.EmailTemplate{}},
+	},
+	"DELETE /v1/admin/email-templates/:name": {Summary: "Reset an email template to the embedded template", Tag: "admin", Permission: "emails:admin", Response: map[string]any{"message": ""}},
+	"POST /v1/admin/emails/preview": {
+		Summary: "Render an email template, optionally sending it to an address", Tag: "admin", Permission: "emails:admin",
+		Request: struct {
+			Template string         `json:"template"`
+			Data     map[string]any `json:"data"`
+			SendTo   string         `json:"send_to"`
+		}{},
+		Response: map[string]any{"email": emailPreview{}, "sent_to": ""},
+	},
+	"GET /v1/admin/emails/outbox": {
+		Summary: "List the emails captured by the dev mail provider", Tag: "admin", Permission: "emails:admin",
+		Query: []string{"limit"}, Response: map[string]any{"emails": []mailer.CapturedEmail{}},
+	},
+}
+
+// graphqlResponse is the body of the GraphQL responses. The data takes the shape of the query, so it has no fixed schema
+var graphqlResponse = map[string]any{
+	"data": json.RawMessage{},
+	"errors": []struct {
+		Message string   `json:"message"`
+		Path    []string `json:"path"`
+	}{},
+}
+
+// permissionChange is the body of the permission grant and revoke requests
+type permissionChange struct {
+	Permissions []string `json:"permissions"`
+	Roles       []string `json:"roles"`
+}
+
+// openAPIHandler serves the OpenAPI specification of the routes on the router. It is generated on the first request,
+// once every route has been registered
+func (app *application) openAPIHandler(router *routeRecorder) http.HandlerFunc {
+	var once sync.Once
+	var spec []byte
+	var specErr error
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		once.Do(func() {
+			spec, specErr = json.Marshal(buildOpenAPISpec(router.routes))
+		})
+
+		if specErr != nil {
+			app.serverErrorResponse(w, r, specErr)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(spec)
+	}
+}
+
+// buildOpenAPISpec generates the OpenAPI 3 document for the routes. Routes which haven't been annotated in apiOperations
+// are still included, with their path parameters but without a summary or schemas
+func buildOpenAPISpec(routes []recordedRoute) map[string]any {
+	schemas := &openAPISchemas{components: map[string]any{}}
+	paths := map[string]map[string]any{}
+
+	for _, route := range routes {
+		path, params := openAPIPath(route.path)
+
+		op := apiOperations[route.method+" "+route.path]
+
+		operation := map[string]any{
+			"operationId": strings.ToLower(route.method) + strings.NewReplacer("/", "_", ":", "", "-", "_").Replace(route.path),
+		}
+
+		if op.Summary != "" {
+			operation["summary"] = op.Summary
+		}
+		if op.Tag != "" {
+			operation["tags"] = []string{op.Tag}
+		}
+
+		var parameters []map[string]any
+		for _, param := range params {
+			parameters = append(parameters, map[string]any{"name": param, "in": "path", "required": true, "schema": map[string]any{"type": "string"}})
+		}
+		for _, param := range op.Query {
+			parameters = append(parameters, map[string]any{"name": param, "in": "query", "schema": map[string]any{"type": "string"}})
+		}
+		if parameters != nil {
+			operation["parameters"] = parameters
+		}
+
+		// API keys can only be used on the routes guarded by a permission
+		switch op.Permission {
+		case "":
+		case "authenticated":
+			operation["security"] = []map[string][]string{{"bearerAuth": {}}}
+		default:
+			operation["security"] = []map[string][]string{{"bearerAuth": {}}, {"apiKey": {}}}
+			operation["description"] = "Requires the " + op.Permission + " permission."
+		}
+
+		if op.Request != nil {
+			schema := schemas.schemaFor(reflect.TypeOf(op.Request))
+			content := map[string]any{"application/json": map[string]any{"schema": schema}}
+
+			if op.Patch {
+				content[mergePatchMediaType] = map[string]any{"schema": schema}
+				content[jsonPatchMediaType] = map[string]any{"schema": map[string]any{
+					"type": "array",
+					"items": map[string]any{
+						"type":     "object",
+						"required": []string{"op", "path"},
+						"properties": map[string]any{
+							"op":    map[string]any{"type": "string", "enum": []string{"add", "remove", "replace", "move", "copy", "test"}},
+							"path":  map[string]any{"type": "string"},
+							"from":  map[string]any{"type": "string"},
+							"value": map[string]any{},
+						},
+					},
+				}}
+			}
+
+			operation["requestBody"] = map[string]any{"required": true, "content": content}
+		}
+
+		status := op.Status
+		if status == 0 {
+			status = http.StatusOK
+		}
+
+		response := map[string]any{"description": http.StatusText(status)}
+
+		if op.Response != nil {
+			properties := map[string]any{}
+			for key, value := range op.Response {
+				properties[key] = schemas.schemaFor(reflect.TypeOf(value))
+			}
+			response["content"] = map[string]any{"application/json": map[string]any{"schema": map[string]any{"type": "object", "properties": properties}}}
+		}
+
+		operation["responses"] = map[string]any{
+			strconv.Itoa(status): response,
+			"default":            map[string]any{"$ref": "#/components/responses/Error"},
+		}
+
+		if paths[path] == nil {
+			paths[path] = map[string]any{}
+		}
+		paths[path][strings.ToLower(route.method)] = operation
+	}
+
+	return map[string]any{
+		"openapi": "3.0.3",
+		"info": map[string]any{
+			"title":   "Greenlight API",
+			"version": version,
+		},
+		"paths": paths,
+		"components": map[string]any{
+			"schemas": schemas.components,
+			"responses": map[string]any{
+				"Error": map[string]any{
+					"description": "Error",
+					"content": map[string]any{"application/json": map[string]any{"schema": map[string]any{
+						"type":       "object",
+						"properties": map[string]any{"error": map[string]any{}, "request_id": map[string]any{"type": "string"}},
+					}}},
+				},
+			},
+			"securitySchemes": map[string]any{
+				"bearerAuth": map[string]any{"type": "http", "scheme": "bearer"},
+				"apiKey":     map[string]any{"type": "apiKey", "in": "header", "name": "Authorization", "description": "ApiKey <key>"},
+			},
+		},
+	}
+}
+
+// openAPIPath converts an httprouter path such as /v1/movies/:id into the OpenAPI form /v1/movies/{id}, returning the
+// names of the path parameters
+func openAPIPath(path string) (string, []string) {
+	var params []string
+
+	segments := strings.Split(path, "/")
+	for i, segment := range segments {
+		if strings.HasPrefix(segment, ":") || strings.HasPrefix(segment, "*") {
+			params = append(params, segment[1:])
+			segments[i] = "{" + segment[1:] + "}"
+		}
+	}
+
+	return strings.Join(segments, "/"), params
+}
+
+// openAPISchemas generates the JSON schemas of Go types, the named structs are added to the components once and referenced
+type openAPISchemas struct {
+	components map[string]any
+}
+
+var (
+	timeType       = reflect.TypeOf(time.Time{})
+	runtimeType    = reflect.TypeOf(data.Runtime(0))
+	isoRuntimeType = reflect.TypeOf(isoRuntime(0))
+	dateType       = reflect.TypeOf(data.Date{})
+	rawType        = reflect.TypeOf(json.RawMessage{})
+)
+
+func (s *openAPISchemas) schemaFor(t reflect.Type) map[string]any {
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+
+	// the types with their own JSON encoding
+	switch t {
+	case timeType:
+		return map[string]any{"type": "string", "format": "date-time"}
+	case runtimeType:
+		return map[string]any{"type": "string", "example": "102 mins"}
+	case isoRuntimeType:
+		return map[string]any{"type": "string", "format": "duration", "example": "PT102M"}
+	case dateType:
+		return map[string]any{"type": "string", "format": "date"}
+	case rawType:
+		return map[string]any{}
+	}
+
+	switch t.Kind() {
+	case reflect.Bool:
+		return map[string]any{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64, reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return map[string]any{"type": "integer"}
+	case reflect.Float32, reflect.Float64:
+		return map[string]any{"type": "number"}
+	case reflect.String:
+		return map[string]any{"type": "string"}
+	case reflect.Slice, reflect.Array:
+		if t.Elem().Kind() == reflect.Uint8 {
+			return map[string]any{"type": "string", "format": "byte"}
+		}
+		return map[string]any{"type": "array", "items": s.schemaFor(t.Elem())}
+	case reflect.Map:
+		return map[string]any{"type": "object", "additionalProperties": s.schemaFor(t.Elem())}
+	case reflect.Struct:
+		if t.Name() == "" {
+			return s.structSchema(t)
+		}
+
+		// the component names are capitalised, so the unexported request types in this package read the same as the data types
+		name := strings.ToUpper(t.Name()[:1]) + t.Name()[1:]
+
+		if _, ok := s.components[name]; !ok {
+			// reserve the name first, so a type which refers to itself doesn't recurse forever
+			s.components[name] = map[string]any{}
+			s.components[name] = s.structSchema(t)
+		}
+		return map[string]any{"$ref": "#/components/schemas/" + name}
+	default:
+		return map[string]any{}
+	}
+}
+
+// structSchema generates the object schema of a struct from its exported fields and their json tags
+func (s *openAPISchemas) structSchema(t reflect.Type) map[string]any {
+	properties := map[string]any{}
+
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if !field.IsExported() {
+			continue
+		}
+
+		tag := field.Tag.Get("json")
+		if tag == "-" {
+			continue
+		}
+
+		name, _, _ := strings.Cut(tag, ",")
+
+		// the fields of an embedded struct, or pointer to one, without a json name are promoted into the parent, the same
+		// as encoding/json does
+		embeddedType := field.Type
+		if embeddedType.Kind() == reflect.Pointer {
+			embeddedType = embeddedType.Elem()
+		}
+
+		if field.Anonymous && name == "" && embeddedType.Kind() == reflect.Struct {
+			embedded := s.structSchema(embeddedType)
+			for key, value := range embedded["properties"].(map[string]any) {
+				properties[key] = value
+			}
+			continue
+		}
+
+		if name == "" {
+			name = field.Name
+		}
+
+		properties[name] = s.schemaFor(field.Type)
+	}
+
+	return map[string]any{"type": "object", "properties": properties}
+}
+
+// swaggerUITemplate is the page which renders the specification with Swagger UI, loaded from a CDN
+var swaggerUITemplate = template.Must(template.New("swagger").Parse(`<!DOCTYPE html>
+<html>
+<head>
+  <meta charset="utf-8">
+  <title>Greenlight API</title>
+  <link rel="stylesheet" href="https://unpkg.com/swagger-ui-dist@5/swagger-ui.css">
+</head>
+<body>
+  <div id="swagger-ui"></div>
+  <script src="https://unpkg.com/swagger-ui-dist@5/swagger-ui-bundle.js"></script>
+  <script>
+    window.ui = SwaggerUIBundle({ url: {{.}}, dom_id: "#swagger-ui" });
+  </script>
+</body>
+</html>
+`))
+
+// swaggerUIHandler serves the Swagger UI page for the specification, it is only registered when the -openapi-ui flag is set
+func (app *application) swaggerUIHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+
+	err := swaggerUITemplate.Execute(w, "/v1/openapi.json")
+	if err != nil {
+		app.logError(r, err)
+	}
+}
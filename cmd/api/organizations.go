@@ -0,0 +1,192 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/nytro04/greenlight/internal/data"
+	"github.com/nytro04/greenlight/internal/validator"
+)
+
+// createOrganizationHandler creates a new organization, the user who creates it becomes its owner
+func (app *application) createOrganizationHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Name string `json:"name"`
+		Slug string `json:"slug"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	org := &data.Organization{
+		Name: input.Name,
+		Slug: input.Slug,
+	}
+
+	v := validator.New()
+
+	if data.ValidateOrganization(v, org); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	user := app.contextGetUser(r)
+
+	err = app.models.Organizations.Insert(org, user.ID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrDuplicateSlug):
+			v.AddError("slug", "an organization with this slug already exists")
+			app.failedValidationResponse(w, r, v.Errors)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	headers := make(http.Header)
+	headers.Set("Location", fmt.Sprintf("/v1/organizations/%d", org.ID))
+
+	err = app.writeJSON(w, http.StatusCreated, envelope{"organization": org}, headers)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// readOrganization reads the organization ID from the URL and fetches the organization along with the role the authenticated
+// user has in it. A 404 is sent if the organization doesn't exist or the user isn't a member, so non-members can't find out
+// which organizations exist
+func (app *application) readOrganization(w http.ResponseWriter, r *http.Request) (*data.Organization, string, bool) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return nil, "", false
+	}
+
+	org, err := app.models.Organizations.Get(id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return nil, "", false
+	}
+
+	role, err := app.models.Organizations.GetMemberRole(org.ID, app.contextGetUser(r).ID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return nil, "", false
+	}
+
+	return org, role, true
+}
+
+// showOrganizationHandler returns an organization the authenticated user is a member of, along with their role in it
+func (app *application) showOrganizationHandler(w http.ResponseWriter, r *http.Request) {
+	org, role, ok := app.readOrganization(w, r)
+	if !ok {
+		return
+	}
+
+	err := app.writeJSON(w, http.StatusOK, envelope{"organization": org, "role": role}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// updateOrganizationSSOHandler configures the OIDC identity provider for an organization and the email domains it has
+// been verified to own. The identity provider can vouch for any email address, so this needs the users:admin
+// permission rather than ownership of the organization, which any activated user can get by creating one
+func (app *application) updateOrganizationSSOHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	org, err := app.models.Organizations.Get(id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	var input struct {
+		Issuer       string            `json:"issuer"`
+		ClientID     string            `json:"client_id"`
+		ClientSecret string            `json:"client_secret"`
+		RoleClaim    string            `json:"role_claim"`
+		RoleMapping  map[string]string `json:"role_mapping"`
+		DefaultRole  string            `json:"default_role"`
+		Domains      []string          `json:"domains"`
+	}
+
+	err = app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	sso := &data.OrganizationSSO{
+		OrganizationID: org.ID,
+		Issuer:         input.Issuer,
+		ClientID:       input.ClientID,
+		ClientSecret:   input.ClientSecret,
+		RoleClaim:      input.RoleClaim,
+		RoleMapping:    input.RoleMapping,
+		DefaultRole:    input.DefaultRole,
+		Domains:        input.Domains,
+	}
+
+	// new members get the member role unless the administrator says otherwise
+	if sso.DefaultRole == "" {
+		sso.DefaultRole = data.OrganizationRoleMember
+	}
+
+	v := validator.New()
+
+	if data.ValidateOrganizationSSO(v, sso); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = app.models.Organizations.SetSSO(sso)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrDuplicateDomain):
+			v.AddError("domains", "a domain belongs to another organization")
+			app.failedValidationResponse(w, r, v.Errors)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	app.recordAudit(r, data.AuditCategoryAuth, "organization_sso_updated", fmt.Sprintf("organization:%d", org.ID))
+
+	// include the login URL so the organization knows where to point its members
+	env := envelope{
+		"sso":       sso,
+		"login_url": fmt.Sprintf("%s/v1/sso/%s/login", app.config.sso.baseURL, org.Slug),
+	}
+
+	err = app.writeJSON(w, http.StatusOK, env, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
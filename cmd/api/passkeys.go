@@ -0,0 +1,327 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/go-webauthn/webauthn/protocol"
+	"github.com/go-webauthn/webauthn/webauthn"
+	"github.com/nytro04/greenlight/internal/data"
+	"github.com/nytro04/greenlight/internal/validator"
+)
+
+// webauthnSessionTTL is how long a client has to complete a registration or login ceremony after requesting the options
+const webauthnSessionTTL = 5 * time.Minute
+
+// loadWebAuthnUser wraps the user together with all of their registered passkeys, as required by the webauthn library
+func (app *application) loadWebAuthnUser(user *data.User) (*data.WebAuthnUser, error) {
+	passkeys, err := app.models.Passkeys.GetAllForUser(user.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	waUser := &data.WebAuthnUser{User: user}
+	for _, passkey := range passkeys {
+		waUser.Credentials = append(waUser.Credentials, passkey.Credential)
+	}
+
+	return waUser, nil
+}
+
+// webauthnErrorMessage returns a client friendly message for an error returned by the webauthn library. The library errors
+// carry a short description of what was wrong with the credential in their Details field
+func webauthnErrorMessage(err error) string {
+	var protocolError *protocol.Error
+	if errors.As(err, &protocolError) && protocolError.Details != "" {
+		return protocolError.Details
+	}
+	return "invalid credential"
+}
+
+// beginPasskeyRegistrationHandler starts the registration ceremony for a new passkey. It returns the credential creation options
+// which the client passes to navigator.credentials.create(), along with a session token which must be sent back to finish the ceremony
+func (app *application) beginPasskeyRegistrationHandler(w http.ResponseWriter, r *http.Request) {
+	user := app.contextGetUser(r)
+
+	waUser, err := app.loadWebAuthnUser(user)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	// require a discoverable (resident) credential so it can be used for passwordless login, and exclude the credentials
+	// the user has already registered so the same authenticator isn't registered twice
+	options, session, err := app.webauthn.BeginRegistration(
+		waUser,
+		webauthn.WithResidentKeyRequirement(protocol.ResidentKeyRequirementRequired),
+		webauthn.WithExclusions(webauthn.Credentials(waUser.Credentials).CredentialDescriptors()),
+	)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	sessionToken, err := app.models.Passkeys.NewSession(user.ID, session, webauthnSessionTTL)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"session": sessionToken, "options": options}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// finishPasskeyRegistrationHandler verifies the attestation returned by the authenticator and stores the new passkey for the user
+func (app *application) finishPasskeyRegistrationHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Session    string          `json:"session"`
+		Name       string          `json:"name"`
+		Credential json.RawMessage `json:"credential"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+
+	v.Check(input.Session != "", "session", "must be provided")
+	v.Check(len(input.Credential) > 0, "credential", "must be provided")
+	data.ValidatePasskeyName(v, input.Name)
+
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	user := app.contextGetUser(r)
+
+	// the session can only be used by the user who started the ceremony, and only once
+	session, err := app.models.Passkeys.ConsumeSession(user.ID, input.Session)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			v.AddError("session", "invalid or expired session")
+			app.failedValidationResponse(w, r, v.Errors)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	parsed, err := protocol.ParseCredentialCreationResponseBytes(input.Credential)
+	if err != nil {
+		v.AddError("credential", webauthnErrorMessage(err))
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	waUser, err := app.loadWebAuthnUser(user)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	credential, err := app.webauthn.CreateCredential(waUser, *session, parsed)
+	if err != nil {
+		v.AddError("credential", webauthnErrorMessage(err))
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	passkey := &data.Passkey{
+		UserID:     user.ID,
+		Name:       input.Name,
+		Credential: *credential,
+	}
+
+	err = app.models.Passkeys.Insert(passkey)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrDuplicatePasskey):
+			v.AddError("credential", "this passkey has already been registered")
+			app.failedValidationResponse(w, r, v.Errors)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	app.recordAudit(r, data.AuditCategoryAuth, "passkey_registered", user.Email)
+
+	err = app.writeJSON(w, http.StatusCreated, envelope{"passkey": passkey}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// listPasskeysHandler returns the passkeys registered by the authenticated user
+func (app *application) listPasskeysHandler(w http.ResponseWriter, r *http.Request) {
+	user := app.contextGetUser(r)
+
+	passkeys, err := app.models.Passkeys.GetAllForUser(user.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"passkeys": passkeys}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// deletePasskeyHandler removes one of the authenticated user's passkeys
+func (app *application) deletePasskeyHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	user := app.contextGetUser(r)
+
+	err = app.models.Passkeys.Delete(id, user.ID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	app.recordAudit(r, data.AuditCategoryAuth, "passkey_deleted", user.Email)
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"message": "passkey successfully deleted"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// beginPasskeyLoginHandler starts a discoverable login ceremony. The client doesn't need to say who it is, the authenticator
+// picks the passkey and returns the user handle along with the assertion
+func (app *application) beginPasskeyLoginHandler(w http.ResponseWriter, r *http.Request) {
+	options, session, err := app.webauthn.BeginDiscoverableLogin()
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	sessionToken, err := app.models.Passkeys.NewSession(0, session, webauthnSessionTTL)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"session": sessionToken, "options": options}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// createWebAuthnTokenHandler verifies a passkey assertion and, if it is valid, issues a regular authentication token for the user
+// the passkey belongs to. From this point on the client uses the token exactly as if it had logged in with a password
+func (app *application) createWebAuthnTokenHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Session    string          `json:"session"`
+		Credential json.RawMessage `json:"credential"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+
+	v.Check(input.Session != "", "session", "must be provided")
+	v.Check(len(input.Credential) > 0, "credential", "must be provided")
+
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	session, err := app.models.Passkeys.ConsumeSession(0, input.Session)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			v.AddError("session", "invalid or expired session")
+			app.failedValidationResponse(w, r, v.Errors)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	parsed, err := protocol.ParseCredentialRequestResponseBytes(input.Credential)
+	if err != nil {
+		v.AddError("credential", webauthnErrorMessage(err))
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	// the library calls this function with the user handle from the assertion to look up the user and their credentials
+	var user *data.User
+	handler := func(rawID, userHandle []byte) (webauthn.User, error) {
+		userID, err := data.UserIDFromWebAuthnHandle(userHandle)
+		if err != nil {
+			return nil, err
+		}
+
+		user, err = app.models.Users.GetByID(r.Context(), userID)
+		if err != nil {
+			return nil, err
+		}
+
+		return app.loadWebAuthnUser(user)
+	}
+
+	_, credential, err := app.webauthn.ValidatePasskeyLogin(handler, *session, parsed)
+	if err != nil {
+		app.recordSecurityEvent(r, data.SecurityEventLoginFailed, map[string]string{"reason": "invalid passkey assertion"})
+		app.invalidCredentialsResponse(w, r)
+		return
+	}
+
+	// a clone warning means the authenticator's signature counter went backwards, which suggests the credential has been copied
+	if credential.Authenticator.CloneWarning {
+		app.recordSecurityEvent(r, data.SecurityEventLoginFailed, map[string]string{"email": user.Email, "reason": "passkey clone warning"})
+		app.invalidCredentialsResponse(w, r)
+		return
+	}
+
+	if user.Disabled() {
+		app.recordSecurityEvent(r, data.SecurityEventLoginFailed, map[string]string{"email": user.Email, "reason": "account disabled"})
+		app.accountDisabledResponse(w, r)
+		return
+	}
+
+	err = app.models.Passkeys.UpdateCredential(user.ID, credential)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	token, err := app.newAuthenticationToken(r, user)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	app.recordAudit(r, data.AuditCategoryAuth, "login_passkey", user.Email)
+
+	err = app.writeJSON(w, http.StatusCreated, envelope{"authentication_token": token}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/nytro04/greenlight/internal/data"
+	pwhash "github.com/nytro04/greenlight/internal/password"
+	"github.com/nytro04/greenlight/internal/validator"
+)
+
+// updatePasswordHandler changes the user's password after checking their current one. All of their authentication
+// tokens are deleted along with the change, so they have to sign in again everywhere, and an email is sent to let them
+// know in case it wasn't them who changed it
+func (app *application) updatePasswordHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		CurrentPassword string `json:"current_password"`
+		Password        string `json:"password"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+
+	v.Check(input.CurrentPassword != "", "current_password", "must be provided")
+	data.ValidatePasswordPlaintext(v, input.Password)
+
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	// the user in the context doesn't hold the password hash when the request was made with a signed JWT
+	user, err := app.models.Users.GetByID(r.Context(), app.contextGetUser(r).ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	match, err := user.Password.Matches(input.CurrentPassword)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	if !match {
+		app.recordSecurityEvent(r, data.SecurityEventLoginFailed, map[string]string{"email": user.Email, "reason": "wrong password on password change"})
+		v.AddError("current_password", "is incorrect")
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = user.Password.HashPassword(input.Password)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.models.Users.UpdatePassword(r.Context(), user)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrEditConflict):
+			app.editConflictResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	app.recordAudit(r, data.AuditCategoryAuth, "password_changed", user.Email)
+	app.recordSecurityEvent(r, data.SecurityEventPasswordChanged, nil)
+
+	app.sendPasswordChangedEmail(r, user)
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"message": "your password has been changed, please sign in again"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// sendPasswordChangedEmail lets the user know their password has been changed and their sessions signed out. The email
+// is queued and sent in the background, after the response
+func (app *application) sendPasswordChangedEmail(r *http.Request, user *data.User) {
+	app.notifyByEmail(r, user.Email, "password_changed.go.tmpl", map[string]interface{}{
+		"changedAt": app.clock.Now().UTC().Format(time.RFC1123),
+	})
+}
+
+// newPasswordHasher returns the hasher new password hashes are made with. The configuration has already been validated
+func newPasswordHasher(cfg config) pwhash.Hasher {
+	if cfg.password.hasher == pwhash.AlgorithmArgon2id {
+		return pwhash.Argon2id{Time: uint32(cfg.password.argon2Time), Memory: uint32(cfg.password.argon2Memory), Threads: uint8(cfg.password.argon2Threads)}
+	}
+
+	return pwhash.Bcrypt{Cost: cfg.password.bcryptCost}
+}
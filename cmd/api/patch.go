@@ -0,0 +1,89 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+
+	jsonpatch "github.com/evanphx/json-patch/v5"
+)
+
+const (
+	// jsonPatchMediaType is the media type of a JSON Patch (RFC 6902), a list of operations on the resource
+	jsonPatchMediaType = "application/json-patch+json"
+
+	// mergePatchMediaType is the media type of a JSON Merge Patch (RFC 7396), a partial document where null removes a field
+	mergePatchMediaType = "application/merge-patch+json"
+)
+
+// errPatchTestFailed is returned by readPatch when a test operation of a JSON Patch doesn't match the resource
+var errPatchTestFailed = errors.New("patch test operation failed")
+
+// patchMediaType returns the media type of the request when it is one of the patch formats, or an empty string for
+// the plain JSON partial updates
+func patchMediaType(r *http.Request) string {
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+
+	switch mediaType {
+	case jsonPatchMediaType, mergePatchMediaType:
+		return mediaType
+	default:
+		return ""
+	}
+}
+
+// readPatch applies the patch in the request body, in the given patch format, to the JSON of current and decodes the
+// patched document into dst. dst is a fresh value rather than current, so that a field the patch removes ends up
+// empty and is caught by the validation. The patched document is decoded by readJSON, so a patch which adds an
+// unknown field or gives a field the wrong type is refused in the same way as a plain update
+func (app *application) readPatch(w http.ResponseWriter, r *http.Request, mediaType string, current, dst interface{}) error {
+	doc, err := json.Marshal(current)
+	if err != nil {
+		return err
+	}
+
+	patch, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxRequestBodyBytes))
+	if err != nil {
+		var maxBytesError *http.MaxBytesError
+		if errors.As(err, &maxBytesError) {
+			return fmt.Errorf("body must not be larger than %d bytes", maxRequestBodyBytes)
+		}
+		return err
+	}
+
+	if len(bytes.TrimSpace(patch)) == 0 {
+		return errors.New("body must not be empty")
+	}
+
+	var patched []byte
+
+	switch mediaType {
+	case jsonPatchMediaType:
+		operations, err := jsonpatch.DecodePatch(patch)
+		if err != nil {
+			return fmt.Errorf("body contains an invalid JSON Patch: %w", err)
+		}
+
+		patched, err = operations.Apply(doc)
+		if err != nil {
+			if errors.Is(err, jsonpatch.ErrTestFailed) {
+				return errPatchTestFailed
+			}
+			return fmt.Errorf("the JSON Patch could not be applied: %w", err)
+		}
+	case mergePatchMediaType:
+		patched, err = jsonpatch.MergePatch(doc, patch)
+		if err != nil {
+			return fmt.Errorf("body contains an invalid JSON Merge Patch: %w", err)
+		}
+	default:
+		return fmt.Errorf("unsupported patch media type %q", mediaType)
+	}
+
+	r.Body = io.NopCloser(bytes.NewReader(patched))
+	return app.readJSON(w, r, dst)
+}
@@ -0,0 +1,265 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/nytro04/greenlight/internal/data"
+	"github.com/nytro04/greenlight/internal/validator"
+)
+
+// listPermissionsHandler returns the codes of every permission which can be granted
+func (app *application) listPermissionsHandler(w http.ResponseWriter, r *http.Request) {
+	permissions, err := app.models.Permissions.GetAll(r.Context())
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"permissions": permissions}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// validatePermissionCodes checks every code is a permission which exists, adding an error to the validator for each which doesn't
+func (app *application) validatePermissionCodes(ctx context.Context, v *validator.Validator, codes []string) error {
+	known, err := app.models.Permissions.GetAll(ctx)
+	if err != nil {
+		return err
+	}
+
+	for _, code := range codes {
+		v.Check(known.Include(code), "permissions", fmt.Sprintf("%q is not a known permission", code))
+	}
+
+	return nil
+}
+
+func (app *application) listUserPermissionsHandler(w http.ResponseWriter, r *http.Request) {
+	user, ok := app.readUser(w, r)
+	if !ok {
+		return
+	}
+
+	permissions, err := app.models.Permissions.GetAllForUser(r.Context(), user.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	// make sure an empty list is sent rather than null when the user has no permissions
+	if permissions == nil {
+		permissions = data.Permissions{}
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"permissions": permissions}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// readPermissionChange reads the user from the URL and the permissions to grant or revoke from the body. The body lists
+// permission codes, role names or both, the roles are expanded into the permissions they bundle
+func (app *application) readPermissionChange(w http.ResponseWriter, r *http.Request) (*data.User, data.Permissions, bool) {
+	user, ok := app.readUser(w, r)
+	if !ok {
+		return nil, nil, false
+	}
+
+	var input struct {
+		Permissions []string `json:"permissions"`
+		Roles       []string `json:"roles"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return nil, nil, false
+	}
+
+	v := validator.New()
+
+	v.Check(len(input.Permissions) > 0 || len(input.Roles) > 0, "permissions", "must contain at least 1 permission or role")
+
+	err = app.validatePermissionCodes(r.Context(), v, input.Permissions)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return nil, nil, false
+	}
+
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return nil, nil, false
+	}
+
+	codes := data.Permissions(input.Permissions)
+
+	if len(input.Roles) > 0 {
+		rolePermissions, err := app.models.Roles.GetPermissions(input.Roles...)
+		if err != nil {
+			switch {
+			case errors.Is(err, data.ErrRecordNotFound):
+				v.AddError("roles", "must only contain roles which exist")
+				app.failedValidationResponse(w, r, v.Errors)
+			default:
+				app.serverErrorResponse(w, r, err)
+			}
+			return nil, nil, false
+		}
+
+		codes = append(codes, rolePermissions...)
+	}
+
+	return user, codes, true
+}
+
+// grantUserPermissionsHandler grants permissions to a user, either directly or through roles, and returns the permissions they now have
+func (app *application) grantUserPermissionsHandler(w http.ResponseWriter, r *http.Request) {
+	user, codes, ok := app.readPermissionChange(w, r)
+	if !ok {
+		return
+	}
+
+	err := app.models.Permissions.AddForUser(r.Context(), user.ID, codes...)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	app.recordAudit(r, data.AuditCategoryAuth, "permissions_granted", user.Email)
+
+	app.writeUserPermissions(w, r, user)
+}
+
+// revokeUserPermissionsHandler revokes permissions from a user, either directly or through roles, and returns the permissions they have left
+func (app *application) revokeUserPermissionsHandler(w http.ResponseWriter, r *http.Request) {
+	user, codes, ok := app.readPermissionChange(w, r)
+	if !ok {
+		return
+	}
+
+	err := app.models.Permissions.RemoveForUser(r.Context(), user.ID, codes...)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	app.recordAudit(r, data.AuditCategoryAuth, "permissions_revoked", user.Email)
+
+	app.writeUserPermissions(w, r, user)
+}
+
+// writeUserPermissions sends the permissions the user has after a change
+func (app *application) writeUserPermissions(w http.ResponseWriter, r *http.Request, user *data.User) {
+	permissions, err := app.models.Permissions.GetAllForUser(r.Context(), user.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	if permissions == nil {
+		permissions = data.Permissions{}
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"permissions": permissions}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) listRolesHandler(w http.ResponseWriter, r *http.Request) {
+	roles, err := app.models.Roles.GetAll()
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"roles": roles}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) createRoleHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Name        string   `json:"name"`
+		Description string   `json:"description"`
+		Permissions []string `json:"permissions"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	role := &data.Role{
+		Name:        input.Name,
+		Description: input.Description,
+		Permissions: input.Permissions,
+	}
+
+	v := validator.New()
+
+	data.ValidateRole(v, role)
+
+	err = app.validatePermissionCodes(r.Context(), v, role.Permissions)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = app.models.Roles.Insert(role)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrDuplicateRole):
+			v.AddError("name", "a role with this name already exists")
+			app.failedValidationResponse(w, r, v.Errors)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	app.recordAudit(r, data.AuditCategoryAuth, "role_created", role.Name)
+
+	err = app.writeJSON(w, http.StatusCreated, envelope{"role": role}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// deleteRoleHandler deletes a role. The users it was granted to keep its permissions, since they were granted individually
+func (app *application) deleteRoleHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	err = app.models.Roles.Delete(id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	app.recordAudit(r, data.AuditCategoryAuth, "role_deleted", fmt.Sprintf("role:%d", id))
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"message": "role successfully deleted"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
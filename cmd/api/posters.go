@@ -0,0 +1,231 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"path"
+	"time"
+
+	"github.com/nytro04/greenlight/internal/data"
+	"github.com/nytro04/greenlight/internal/storage"
+)
+
+// maxPosterBytes is the largest poster image which can be uploaded (5MB)
+const maxPosterBytes = 5 * 1_048_576
+
+// posterURLTTL is how long the signed URLs the poster endpoint redirects to are valid for
+const posterURLTTL = 15 * time.Minute
+
+// posterExtensions are the image types accepted as posters, keyed by the content type sniffed from the upload
+var posterExtensions = map[string]string{
+	"image/jpeg": ".jpg",
+	"image/png":  ".png",
+	"image/webp": ".webp",
+}
+
+// uploadMoviePosterHandler stores the image in the "poster" field of a multipart/form-data request as the movie's poster,
+// replacing any poster it already had. The content type is sniffed from the image itself rather than trusted from the client
+func (app *application) uploadMoviePosterHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	movie, err := app.models.Movies.GetForWrite(r.Context(), id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	// leave some room on top of the image for the multipart boundaries and headers
+	r.Body = http.MaxBytesReader(w, r.Body, maxPosterBytes+64*1024)
+
+	image, err := readImagePart(r, "poster", maxPosterBytes)
+	if err != nil {
+		var maxBytesError *http.MaxBytesError
+		switch {
+		case errors.As(err, &maxBytesError):
+			app.errorResponse(w, r, http.StatusRequestEntityTooLarge, fmt.Sprintf("the poster must not be larger than %d bytes", maxPosterBytes))
+		default:
+			app.badRequestResponse(w, r, err)
+		}
+		return
+	}
+
+	if len(image) > maxPosterBytes {
+		app.errorResponse(w, r, http.StatusRequestEntityTooLarge, fmt.Sprintf("the poster must not be larger than %d bytes", maxPosterBytes))
+		return
+	}
+
+	contentType := http.DetectContentType(image)
+
+	extension, ok := posterExtensions[contentType]
+	if !ok {
+		app.failedValidationResponse(w, r, map[string]string{"poster": "must be a JPEG, PNG or WebP image"})
+		return
+	}
+
+	// every upload gets a new key, so a cached copy of the old poster is never served in place of the new one
+	suffix := make([]byte, 8)
+
+	_, err = io.ReadFull(app.random, suffix)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	oldKey := movie.PosterKey
+	movie.PosterKey = fmt.Sprintf("posters/%d-%s%s", movie.ID, hex.EncodeToString(suffix), extension)
+
+	err = app.storage.Put(r.Context(), movie.PosterKey, bytes.NewReader(image), int64(len(image)), contentType)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.models.Movies.UpdatePoster(r.Context(), movie)
+	if err != nil {
+		// the movie record still points at the old poster, so the one just uploaded is removed again
+		app.deleteStoredObject(r, movie.PosterKey)
+
+		switch {
+		case errors.Is(err, data.ErrEditConflict):
+			app.editConflictResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	if oldKey != "" {
+		app.deleteStoredObject(r, oldKey)
+	}
+
+	app.recordAudit(r, data.AuditCategoryContent, "poster_uploaded", fmt.Sprintf("movie:%d", movie.ID))
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"movie": movie}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// readImagePart reads the contents of the named part of a multipart/form-data request, skipping any other parts. Up to
+// one byte more than maxBytes is read, so the caller can tell an oversized image apart from one of exactly the maximum size
+func readImagePart(r *http.Request, field string, maxBytes int64) ([]byte, error) {
+	reader, err := r.MultipartReader()
+	if err != nil {
+		return nil, fmt.Errorf("body must be multipart/form-data with a %s field", field)
+	}
+
+	for {
+		part, err := reader.NextPart()
+		if err == io.EOF {
+			return nil, fmt.Errorf("body must contain a %s field", field)
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		if part.FormName() != field {
+			part.Close()
+			continue
+		}
+
+		image, err := io.ReadAll(io.LimitReader(part, maxBytes+1))
+		part.Close()
+		if err != nil {
+			return nil, err
+		}
+
+		if len(image) == 0 {
+			return nil, fmt.Errorf("%s must not be empty", field)
+		}
+
+		return image, nil
+	}
+}
+
+// showMoviePosterHandler sends the movie's poster. Backends which can sign URLs are redirected to, so the image is
+// downloaded straight from the storage service, otherwise the API streams the image itself
+func (app *application) showMoviePosterHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	movie, err := app.models.Movies.Get(r.Context(), id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	if movie.PosterKey == "" {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	if signer, ok := app.storage.(storage.URLSigner); ok {
+		url, err := signer.SignedURL(movie.PosterKey, posterURLTTL)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+
+		http.Redirect(w, r, url, http.StatusFound)
+		return
+	}
+
+	poster, err := app.storage.Open(r.Context(), movie.PosterKey)
+	if err != nil {
+		switch {
+		case errors.Is(err, storage.ErrNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+	defer poster.Close()
+
+	w.Header().Set("Content-Type", mime.TypeByExtension(path.Ext(movie.PosterKey)))
+	w.Header().Set("Cache-Control", "public, max-age=3600")
+
+	_, err = io.Copy(w, poster)
+	if err != nil {
+		app.logError(r, err)
+	}
+}
+
+// deleteStoredObject removes a poster or avatar which is no longer used in the background. A failure only leaves an
+// orphaned object behind, so it is logged rather than reported to the client
+func (app *application) deleteStoredObject(r *http.Request, key string) {
+	logger := app.contextGetLogger(r)
+
+	app.background(func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		defer cancel()
+
+		err := app.storage.Delete(ctx, key)
+		if err != nil {
+			logger.PrintError(err, "key", key)
+		}
+	})
+}
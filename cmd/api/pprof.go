@@ -0,0 +1,41 @@
+package main
+
+import (
+	"net/http"
+	"net/http/pprof"
+	runtimepprof "runtime/pprof"
+
+	"github.com/julienschmidt/httprouter"
+)
+
+// pprofIndexHandler lists the runtime profiles. The net/http/pprof handlers aren't registered on the default mux, so
+// the profiles are only served on the admin listener, or on the public routes guarded by the debug:admin permission
+// when there is no admin listener
+func (app *application) pprofIndexHandler(w http.ResponseWriter, r *http.Request) {
+	pprof.Index(w, r)
+}
+
+// pprofProfileHandler serves a single profile. The CPU profile and the execution trace run for the number of seconds
+// in the seconds query parameter, which has to be less than the server's write timeout
+func (app *application) pprofProfileHandler(w http.ResponseWriter, r *http.Request) {
+	name := httprouter.ParamsFromContext(r.Context()).ByName("profile")
+
+	switch name {
+	case "cmdline":
+		pprof.Cmdline(w, r)
+	case "profile":
+		pprof.Profile(w, r)
+	case "symbol":
+		pprof.Symbol(w, r)
+	case "trace":
+		pprof.Trace(w, r)
+	default:
+		// the heap, goroutine, allocs, block, mutex and threadcreate profiles
+		if runtimepprof.Lookup(name) == nil {
+			app.notFoundResponse(w, r)
+			return
+		}
+
+		pprof.Handler(name).ServeHTTP(w, r)
+	}
+}
@@ -5,31 +5,268 @@ import (
 	"net/http"
 
 	"github.com/julienschmidt/httprouter"
+	"github.com/nytro04/greenlight/internal/mailer"
 )
 
 func (app *application) routes() http.Handler {
 
-	router := httprouter.New()
+	// the routes are recorded as they are registered, so the OpenAPI specification can be generated from them
+	router := &routeRecorder{Router: httprouter.New()}
 
 	router.NotFound = http.HandlerFunc(app.notFoundResponse)
 
 	router.MethodNotAllowed = http.HandlerFunc(app.methodNotAllowedResponse)
 
-	router.HandlerFunc(http.MethodGet, "/v1/healthcheck", app.healthcheckHandler)
+	// the OPTIONS requests which aren't CORS preflights are answered with the Allow header of the path
+	router.GlobalOPTIONS = http.HandlerFunc(app.optionsResponse)
 
-	router.HandlerFunc(http.MethodGet, "/v1/movies", app.requirePermission("movies:read", app.listMoviesHandler))
-	router.HandlerFunc(http.MethodGet, "/v1/movies/:id", app.requirePermission("movies:read", app.showMovieHandler))
-	router.HandlerFunc(http.MethodPost, "/v1/movies", app.requirePermission("movies:write", app.createMovieHandler))
-	router.HandlerFunc(http.MethodPatch, "/v1/movies/:id", app.requirePermission("movies:write", app.updateMovieHandler))
-	router.HandlerFunc(http.MethodDelete, "/v1/movies/:id", app.requirePermission("movies:write", app.deleteMovieHandler))
+	// the routes of each version of the API are registered on its own group, which tells the handlers the version so
+	// they can shape their responses for it. v1 is the whole API, v2 so far only has the movies
+	v1 := app.versionGroup(router, apiV1)
+	v2 := app.versionGroup(router, apiV2)
 
-	router.HandlerFunc(http.MethodPost, "/v1/users", app.registerUserHandler)
-	router.HandlerFunc(http.MethodPut, "/v1/users/activated", app.activateUserHandler)
+	v1.HandlerFunc(http.MethodGet, "/healthcheck", app.healthcheckHandler)
+	v1.HandlerFunc(http.MethodGet, "/meta/limits", app.metaLimitsHandler)
+	v1.HandlerFunc(http.MethodGet, "/openapi.json", app.openAPIHandler(router))
 
-	router.HandlerFunc(http.MethodPost, "/v1/tokens/authentication", app.createAuthenticationTokenHandler)
-	router.HandlerFunc(http.MethodPost, "/v1/tokens/activation", app.createActivationTokenHandler)
+	if app.config.openapi.ui {
+		v1.HandlerFunc(http.MethodGet, "/docs", app.swaggerUIHandler)
+	}
 
-	router.Handler(http.MethodGet, "/v1/metrics", expvar.Handler())
+	v1.HandlerFunc(http.MethodGet, "/movies", app.requirePermission("movies:read", app.listMoviesHandler))
+	v1.HandlerFunc(http.MethodGet, "/movies/:id", app.requirePermission("movies:read", app.showMovieHandler))
+	v1.StaticHandlerFunc(http.MethodGet, "/movies/export", app.requirePermission("movies:read", app.exportMoviesHandler))
+	v1.StaticHandlerFunc(http.MethodGet, "/movies/stats", app.requirePermission("movies:read", app.showMovieStatsHandler))
+	v1.StaticHandlerFunc(http.MethodGet, "/movies/trending", app.requirePermission("movies:read", app.listTrendingMoviesHandler))
+	v1.StaticHandlerFunc(http.MethodGet, "/movies/random", app.requirePermission("movies:read", app.showRandomMovieHandler))
+	v1.HandlerFunc(http.MethodPost, "/movies", app.requirePermission("movies:write", app.idempotent(app.createMovieHandler)))
+	v1.StaticHandlerFunc(http.MethodPost, "/movies/batch", app.requirePermission("movies:write", app.idempotent(app.createMoviesBatchHandler)))
+	v1.StaticHandlerFunc(http.MethodPost, "/movies/import", app.requirePermission("movies:write", app.importMoviesHandler))
 
-	return app.metrics(app.recoverPanic(app.enableCORS(app.rateLimit(app.authenticate(router)))))
+	if app.tmdb != nil {
+		v1.StaticHandlerFunc(http.MethodPost, "/movies/import/tmdb", app.requirePermission("movies:write", app.importTMDBMovieHandler))
+	}
+
+	v1.HandlerFunc(http.MethodPatch, "/movies/:id", app.requirePermission("movies:write", app.updateMovieHandler))
+	v1.HandlerFunc(http.MethodDelete, "/movies/:id", app.requirePermission("movies:write", app.deleteMovieHandler))
+
+	// v2 has snake_case keys throughout and ISO 8601 runtimes, otherwise the movies behave as they do in v1
+	v2.HandlerFunc(http.MethodGet, "/movies", app.requirePermission("movies:read", app.listMoviesHandler))
+	v2.HandlerFunc(http.MethodGet, "/movies/:id", app.requirePermission("movies:read", app.showMovieHandler))
+	v2.HandlerFunc(http.MethodPost, "/movies", app.requirePermission("movies:write", app.idempotent(app.createMovieHandler)))
+	v2.StaticHandlerFunc(http.MethodPost, "/movies/batch", app.requirePermission("movies:write", app.idempotent(app.createMoviesBatchHandler)))
+	v2.HandlerFunc(http.MethodPatch, "/movies/:id", app.requirePermission("movies:write", app.updateMovieHandler))
+	v2.HandlerFunc(http.MethodDelete, "/movies/:id", app.requirePermission("movies:write", app.deleteMovieHandler))
+
+	v1.HandlerFunc(http.MethodGet, "/movies/:id/poster", app.requirePermission("movies:read", app.showMoviePosterHandler))
+	v1.HandlerFunc(http.MethodPost, "/movies/:id/poster", app.requirePermission("movies:write", app.uploadMoviePosterHandler))
+
+	v1.HandlerFunc(http.MethodGet, "/movies/:id/credits", app.requirePermission("movies:read", app.listMovieCreditsHandler))
+	v1.HandlerFunc(http.MethodPost, "/movies/:id/credits", app.requirePermission("movies:write", app.createMovieCreditHandler))
+	v1.HandlerFunc(http.MethodDelete, "/movies/:id/credits/:credit_id", app.requirePermission("movies:write", app.deleteMovieCreditHandler))
+
+	// the GraphQL endpoint reads the same records as the REST routes, resolved against the same models
+	schema := app.graphqlSchema()
+	v1.HandlerFunc(http.MethodGet, "/graphql", app.requirePermission("movies:read", app.graphqlHandler(schema)))
+	v1.HandlerFunc(http.MethodPost, "/graphql", app.requirePermission("movies:read", app.graphqlHandler(schema)))
+
+	v1.HandlerFunc(http.MethodGet, "/people", app.requirePermission("movies:read", app.listPeopleHandler))
+	v1.HandlerFunc(http.MethodPost, "/people", app.requirePermission("movies:write", app.createPersonHandler))
+	v1.HandlerFunc(http.MethodGet, "/people/:id", app.requirePermission("movies:read", app.showPersonHandler))
+	v1.HandlerFunc(http.MethodPatch, "/people/:id", app.requirePermission("movies:write", app.updatePersonHandler))
+	v1.HandlerFunc(http.MethodDelete, "/people/:id", app.requirePermission("movies:write", app.deletePersonHandler))
+	v1.HandlerFunc(http.MethodGet, "/people/:id/movies", app.requirePermission("movies:read", app.listPersonMoviesHandler))
+
+	v1.HandlerFunc(http.MethodGet, "/series", app.requirePermission("movies:read", app.listSeriesHandler))
+	v1.HandlerFunc(http.MethodPost, "/series", app.requirePermission("movies:write", app.createSeriesHandler))
+	v1.HandlerFunc(http.MethodGet, "/series/:id", app.requirePermission("movies:read", app.showSeriesHandler))
+	v1.HandlerFunc(http.MethodPatch, "/series/:id", app.requirePermission("movies:write", app.updateSeriesHandler))
+	v1.HandlerFunc(http.MethodDelete, "/series/:id", app.requirePermission("movies:write", app.deleteSeriesHandler))
+	v1.HandlerFunc(http.MethodPut, "/series/:id/movies", app.requirePermission("movies:write", app.setSeriesMoviesHandler))
+
+	v1.HandlerFunc(http.MethodGet, "/movies/:id/reviews", app.requirePermission("movies:read", app.listReviewsHandler))
+	v1.HandlerFunc(http.MethodPost, "/movies/:id/reviews", app.requireActivatedUser(app.createReviewHandler))
+	v1.HandlerFunc(http.MethodPatch, "/movies/:id/reviews/:review_id", app.requireActivatedUser(app.updateReviewHandler))
+	v1.HandlerFunc(http.MethodDelete, "/movies/:id/reviews/:review_id", app.requireActivatedUser(app.deleteReviewHandler))
+	v1.HandlerFunc(http.MethodPost, "/reviews/:id/report", app.requireActivatedUser(app.reportReviewHandler))
+
+	v1.HandlerFunc(http.MethodGet, "/movies/:id/tags", app.requirePermission("movies:read", app.listMovieTagsHandler))
+	v1.HandlerFunc(http.MethodPost, "/movies/:id/tags", app.requireActivatedUser(app.addMovieTagsHandler))
+	v1.HandlerFunc(http.MethodDelete, "/movies/:id/tags/:tag", app.requireActivatedUser(app.removeMovieTagHandler))
+
+	v1.HandlerFunc(http.MethodPost, "/users", app.idempotent(app.registerUserHandler))
+	v1.HandlerFunc(http.MethodPut, "/users/activated", app.activateUserHandler)
+	v1.HandlerFunc(http.MethodPut, "/users/email", app.confirmEmailChangeHandler)
+
+	v1.HandlerFunc(http.MethodGet, "/users", app.requirePermission("users:admin", app.listUsersHandler))
+	v1.HandlerFunc(http.MethodGet, "/users/:id", app.requirePermission("users:admin", app.showUserHandler))
+	v1.HandlerFunc(http.MethodPatch, "/users/:id", app.requirePermission("users:admin", app.updateUserHandler))
+	v1.HandlerFunc(http.MethodDelete, "/users/:id", app.requirePermission("users:admin", app.deleteUserHandler))
+
+	v1.HandlerFunc(http.MethodGet, "/users/:id/avatar", app.requirePermission("movies:read", app.showUserAvatarHandler))
+
+	v1.HandlerFunc(http.MethodGet, "/users/:id/permissions", app.requirePermission("users:admin", app.listUserPermissionsHandler))
+	v1.HandlerFunc(http.MethodPost, "/users/:id/permissions", app.requirePermission("users:admin", app.grantUserPermissionsHandler))
+	v1.HandlerFunc(http.MethodDelete, "/users/:id/permissions", app.requirePermission("users:admin", app.revokeUserPermissionsHandler))
+
+	v1.HandlerFunc(http.MethodGet, "/permissions", app.requirePermission("users:admin", app.listPermissionsHandler))
+	v1.HandlerFunc(http.MethodGet, "/roles", app.requirePermission("users:admin", app.listRolesHandler))
+	v1.HandlerFunc(http.MethodPost, "/roles", app.requirePermission("users:admin", app.createRoleHandler))
+	v1.HandlerFunc(http.MethodDelete, "/roles/:id", app.requirePermission("users:admin", app.deleteRoleHandler))
+
+	v1.HandlerFunc(http.MethodPost, "/tokens/authentication", app.createAuthenticationTokenHandler)
+	v1.HandlerFunc(http.MethodDelete, "/tokens/authentication", app.requireAuthenticatedUser(app.deleteAuthenticationTokenHandler))
+	v1.HandlerFunc(http.MethodPost, "/tokens/activation", app.createActivationTokenHandler)
+
+	// the passkey routes are only registered when a WebAuthn relying party has been configured
+	if app.webauthn != nil {
+		v1.HandlerFunc(http.MethodPost, "/tokens/webauthn/options", app.beginPasskeyLoginHandler)
+		v1.HandlerFunc(http.MethodPost, "/tokens/webauthn", app.createWebAuthnTokenHandler)
+
+		v1.HandlerFunc(http.MethodGet, "/me/passkeys", app.requireActivatedUser(app.listPasskeysHandler))
+		v1.HandlerFunc(http.MethodPost, "/me/passkeys/options", app.requireActivatedUser(app.beginPasskeyRegistrationHandler))
+		v1.HandlerFunc(http.MethodPost, "/me/passkeys", app.requireActivatedUser(app.finishPasskeyRegistrationHandler))
+		v1.HandlerFunc(http.MethodDelete, "/me/passkeys/:id", app.requireActivatedUser(app.deletePasskeyHandler))
+	}
+
+	v1.HandlerFunc(http.MethodGet, "/me", app.requireAuthenticatedUser(app.showMeHandler))
+	v1.HandlerFunc(http.MethodPatch, "/me", app.requireActivatedUser(app.updateMeHandler))
+	v1.HandlerFunc(http.MethodDelete, "/me", app.requireAuthenticatedUser(app.deleteAccountHandler))
+	v1.HandlerFunc(http.MethodDelete, "/me/deletion", app.requireAuthenticatedUser(app.cancelAccountDeletionHandler))
+
+	v1.HandlerFunc(http.MethodPut, "/me/avatar", app.requireActivatedUser(app.uploadAvatarHandler))
+	v1.HandlerFunc(http.MethodPut, "/me/password", app.requireActivatedUser(app.updatePasswordHandler))
+	v1.HandlerFunc(http.MethodPut, "/me/email", app.requireActivatedUser(app.requestEmailChangeHandler))
+
+	v1.HandlerFunc(http.MethodGet, "/me/usage", app.allowAPIKey(app.showUsageHandler))
+
+	v1.HandlerFunc(http.MethodGet, "/me/sessions", app.requireActivatedUser(app.listSessionsHandler))
+	v1.HandlerFunc(http.MethodDelete, "/me/sessions/:id", app.requireActivatedUser(app.deleteSessionHandler))
+
+	v1.HandlerFunc(http.MethodPost, "/me/totp", app.requireActivatedUser(app.enrollTOTPHandler))
+	v1.HandlerFunc(http.MethodPost, "/me/totp/verify", app.requireActivatedUser(app.confirmTOTPHandler))
+	v1.HandlerFunc(http.MethodPost, "/me/totp/recovery-codes", app.requireActivatedUser(app.regenerateRecoveryCodesHandler))
+	v1.HandlerFunc(http.MethodDelete, "/me/totp", app.requireActivatedUser(app.deleteTOTPHandler))
+
+	v1.HandlerFunc(http.MethodGet, "/me/watchlist", app.requirePermission("movies:read", app.listWatchlistHandler))
+	v1.HandlerFunc(http.MethodPost, "/me/watchlist/:movie_id", app.requirePermission("movies:read", app.addToWatchlistHandler))
+	v1.HandlerFunc(http.MethodDelete, "/me/watchlist/:movie_id", app.requirePermission("movies:read", app.removeFromWatchlistHandler))
+
+	v1.HandlerFunc(http.MethodGet, "/me/favorites", app.requirePermission("movies:read", app.listFavoritesHandler))
+	v1.HandlerFunc(http.MethodPut, "/me/favorites/:movie_id", app.requirePermission("movies:read", app.addFavoriteHandler))
+	v1.HandlerFunc(http.MethodDelete, "/me/favorites/:movie_id", app.requirePermission("movies:read", app.removeFavoriteHandler))
+
+	v1.HandlerFunc(http.MethodGet, "/me/tag-suggestions", app.requireActivatedUser(app.listTagSuggestionsHandler))
+
+	// a public list is shared by its URL, so it can be read without an account
+	v1.HandlerFunc(http.MethodGet, "/me/lists", app.requireActivatedUser(app.listMyListsHandler))
+	v1.HandlerFunc(http.MethodPost, "/lists", app.requireActivatedUser(app.createListHandler))
+	v1.HandlerFunc(http.MethodGet, "/lists/:id", app.showListHandler)
+	v1.HandlerFunc(http.MethodPatch, "/lists/:id", app.requireActivatedUser(app.updateListHandler))
+	v1.HandlerFunc(http.MethodDelete, "/lists/:id", app.requireActivatedUser(app.deleteListHandler))
+	v1.HandlerFunc(http.MethodPut, "/lists/:id/movies", app.requireActivatedUser(app.reorderListHandler))
+	v1.HandlerFunc(http.MethodPut, "/lists/:id/movies/:movie_id", app.requireActivatedUser(app.addListMovieHandler))
+	v1.HandlerFunc(http.MethodDelete, "/lists/:id/movies/:movie_id", app.requireActivatedUser(app.removeListMovieHandler))
+
+	v1.HandlerFunc(http.MethodPost, "/organizations", app.requireActivatedUser(app.createOrganizationHandler))
+	v1.HandlerFunc(http.MethodGet, "/organizations/:id", app.requireActivatedUser(app.showOrganizationHandler))
+	v1.HandlerFunc(http.MethodPut, "/organizations/:id/sso", app.requirePermission("users:admin", app.updateOrganizationSSOHandler))
+
+	v1.HandlerFunc(http.MethodGet, "/sso/:slug/login", app.ssoLoginHandler)
+	v1.HandlerFunc(http.MethodGet, "/sso/:slug/callback", app.ssoCallbackHandler)
+
+	// the social login routes answer 404 for a provider which hasn't been configured
+	if len(app.oauth) > 0 {
+		v1.HandlerFunc(http.MethodGet, "/oauth/:provider/login", app.oauthLoginHandler)
+		v1.HandlerFunc(http.MethodGet, "/oauth/:provider/callback", app.oauthCallbackHandler)
+	}
+
+	v1.HandlerFunc(http.MethodGet, "/admin/audit/export", app.requirePermission("audit:read", app.exportAuditHandler))
+	v1.HandlerFunc(http.MethodGet, "/admin/security-events", app.requirePermission("security:read", app.listSecurityEventsHandler))
+
+	v1.HandlerFunc(http.MethodGet, "/admin/api-keys", app.requirePermission("api_keys:admin", app.listAPIKeysHandler))
+	v1.HandlerFunc(http.MethodPost, "/admin/api-keys", app.requirePermission("api_keys:admin", app.createAPIKeyHandler))
+	v1.HandlerFunc(http.MethodDelete, "/admin/api-keys/:id", app.requirePermission("api_keys:admin", app.deleteAPIKeyHandler))
+
+	v1.HandlerFunc(http.MethodGet, "/admin/webhooks", app.requirePermission("webhooks:admin", app.listWebhooksHandler))
+	v1.HandlerFunc(http.MethodPost, "/admin/webhooks", app.requirePermission("webhooks:admin", app.createWebhookHandler))
+	v1.HandlerFunc(http.MethodDelete, "/admin/webhooks/:id", app.requirePermission("webhooks:admin", app.deleteWebhookHandler))
+	v1.HandlerFunc(http.MethodGet, "/admin/webhooks/:id/deliveries", app.requirePermission("webhooks:admin", app.listWebhookDeliveriesHandler))
+
+	v1.StaticHandlerFunc(http.MethodGet, "/admin/reviews/reported", app.requirePermission("reviews:moderate", app.listReportedReviewsHandler))
+	v1.HandlerFunc(http.MethodGet, "/admin/reviews/:id", app.requirePermission("reviews:moderate", app.showModeratedReviewHandler))
+	v1.HandlerFunc(http.MethodPost, "/admin/reviews/:id/decisions", app.requirePermission("reviews:moderate", app.createModerationDecisionHandler))
+
+	v1.HandlerFunc(http.MethodGet, "/admin/email-templates", app.requirePermission("emails:admin", app.listEmailTemplatesHandler))
+	v1.HandlerFunc(http.MethodGet, "/admin/email-templates/:name", app.requirePermission("emails:admin", app.showEmailTemplateHandler))
+	v1.HandlerFunc(http.MethodPut, "/admin/email-templates/:name", app.requirePermission("emails:admin", app.updateEmailTemplateHandler))
+	v1.HandlerFunc(http.MethodDelete, "/admin/email-templates/:name", app.requirePermission("emails:admin", app.deleteEmailTemplateHandler))
+	v1.HandlerFunc(http.MethodPost, "/admin/emails/preview", app.requirePermission("emails:admin", app.previewEmailHandler))
+
+	if app.config.mail.Provider == mailer.ProviderDev {
+		v1.HandlerFunc(http.MethodGet, "/admin/emails/outbox", app.requirePermission("emails:admin", app.listOutboxHandler))
+	}
+
+	v1.HandlerFunc(http.MethodGet, "/admin/log-level", app.requirePermission("debug:admin", app.showLogLevelHandler))
+	v1.HandlerFunc(http.MethodPut, "/admin/log-level", app.requirePermission("debug:admin", app.updateLogLevelHandler))
+
+	// the operational endpoints move to the admin listener when it is configured, so they aren't exposed publicly
+	if app.config.admin.addr == "" {
+		v1.HandlerFunc(http.MethodGet, "/healthz", app.healthzHandler)
+		v1.HandlerFunc(http.MethodGet, "/readyz", app.readyzHandler)
+
+		v1.HandlerFunc(http.MethodGet, "/admin/debug/pprof/", app.requirePermission("debug:admin", app.pprofIndexHandler))
+		v1.HandlerFunc(http.MethodGet, "/admin/debug/pprof/:profile", app.requirePermission("debug:admin", app.pprofProfileHandler))
+		v1.HandlerFunc(http.MethodPost, "/admin/debug/pprof/:profile", app.requirePermission("debug:admin", app.pprofProfileHandler))
+
+		v1.Handler(http.MethodGet, "/metrics", expvar.Handler())
+		router.HandlerFunc(http.MethodGet, "/metrics", app.prometheusHandler)
+	}
+
+	// the SCIM endpoints are used by identity providers with their own bearer token, so they are served
+	// by a separate router which isn't wrapped in the authenticate middleware
+	mux := http.NewServeMux()
+	mux.Handle("/", app.authenticate(router))
+
+	if app.config.scim.token != "" {
+		// a routeRecorder is only used so the requests are labelled with their route in the metrics, the SCIM
+		// endpoints aren't part of the OpenAPI specification
+		scim := &routeRecorder{Router: httprouter.New()}
+		scim.GlobalOPTIONS = http.HandlerFunc(app.optionsResponse)
+
+		scim.HandlerFunc(http.MethodGet, "/scim/v2/Users", app.scimListUsersHandler)
+		scim.HandlerFunc(http.MethodPost, "/scim/v2/Users", app.scimCreateUserHandler)
+		scim.HandlerFunc(http.MethodGet, "/scim/v2/Users/:id", app.scimShowUserHandler)
+		scim.HandlerFunc(http.MethodPatch, "/scim/v2/Users/:id", app.scimPatchUserHandler)
+		scim.HandlerFunc(http.MethodDelete, "/scim/v2/Users/:id", app.scimDeleteUserHandler)
+
+		mux.Handle("/scim/", app.requireSCIMToken(scim))
+	}
+
+	return app.traceRequests(app.requestID(app.accessLog(app.metrics(app.recoverPanic(app.enableCORS(app.rateLimit(mux)))))))
+}
+
+// adminRoutes returns the handler of the admin listener. It serves the health checks, the expvar and Prometheus
+// metrics and the pprof profiles without authentication, so it must only be reachable from the internal network.
+// Its requests aren't counted in the metrics or traced, the routeRecorder is only used so its GET routes answer HEAD
+func (app *application) adminRoutes() http.Handler {
+	router := &routeRecorder{Router: httprouter.New()}
+
+	router.NotFound = http.HandlerFunc(app.notFoundResponse)
+
+	router.MethodNotAllowed = http.HandlerFunc(app.methodNotAllowedResponse)
+
+	router.GlobalOPTIONS = http.HandlerFunc(app.optionsResponse)
+
+	router.HandlerFunc(http.MethodGet, "/healthz", app.healthzHandler)
+	router.HandlerFunc(http.MethodGet, "/readyz", app.readyzHandler)
+
+	router.Handler(http.MethodGet, "/debug/vars", expvar.Handler())
+	router.HandlerFunc(http.MethodGet, "/metrics", app.prometheusHandler)
+
+	router.HandlerFunc(http.MethodGet, "/debug/pprof/", app.pprofIndexHandler)
+	router.HandlerFunc(http.MethodGet, "/debug/pprof/:profile", app.pprofProfileHandler)
+	router.HandlerFunc(http.MethodPost, "/debug/pprof/:profile", app.pprofProfileHandler)
+
+	return app.recoverPanic(router)
 }
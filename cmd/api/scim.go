@@ -0,0 +1,513 @@
+package main
+
+import (
+	"crypto/subtle"
+	"encoding/base32"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/nytro04/greenlight/internal/data"
+	"github.com/nytro04/greenlight/internal/validator"
+)
+
+// Define constants for the SCIM schema URNs used in the requests and responses
+const (
+	scimUserSchema     = "urn:ietf:params:scim:schemas:core:2.0:User"
+	scimListSchema     = "urn:ietf:params:scim:api:messages:2.0:ListResponse"
+	scimPatchOpSchema  = "urn:ietf:params:scim:api:messages:2.0:PatchOp"
+	scimErrorSchema    = "urn:ietf:params:scim:api:messages:2.0:Error"
+	scimContentType    = "application/scim+json"
+	scimMaxRequestSize = 1_048_576
+)
+
+// scimName holds the name attribute of a SCIM user. We only store a single name, so formatted takes
+// precedence and the given and family names are joined together if it isn't provided
+type scimName struct {
+	Formatted  string `json:"formatted,omitempty"`
+	GivenName  string `json:"givenName,omitempty"`
+	FamilyName string `json:"familyName,omitempty"`
+}
+
+type scimEmail struct {
+	Value   string `json:"value"`
+	Primary bool   `json:"primary,omitempty"`
+}
+
+type scimMeta struct {
+	ResourceType string    `json:"resourceType"`
+	Created      time.Time `json:"created"`
+	Location     string    `json:"location"`
+	Version      string    `json:"version"`
+}
+
+// scimUser is the SCIM representation of a User. The SCIM userName is mapped onto the user email address
+type scimUser struct {
+	Schemas     []string    `json:"schemas"`
+	ID          string      `json:"id,omitempty"`
+	ExternalID  string      `json:"externalId,omitempty"`
+	UserName    string      `json:"userName"`
+	Name        scimName    `json:"name"`
+	DisplayName string      `json:"displayName,omitempty"`
+	Emails      []scimEmail `json:"emails,omitempty"`
+	Active      *bool       `json:"active,omitempty"`
+	Password    string      `json:"password,omitempty"`
+	Meta        *scimMeta   `json:"meta,omitempty"`
+}
+
+// newSCIMUser converts a User into its SCIM representation. A user is only active once they are activated and while
+// they aren't disabled
+func newSCIMUser(user *data.User) scimUser {
+	active := user.Activated && !user.Disabled()
+
+	return scimUser{
+		Schemas:     []string{scimUserSchema},
+		ID:          strconv.FormatInt(user.ID, 10),
+		UserName:    user.Email,
+		Name:        scimName{Formatted: user.Name},
+		DisplayName: user.Name,
+		Emails:      []scimEmail{{Value: user.Email, Primary: true}},
+		Active:      &active,
+		Meta: &scimMeta{
+			ResourceType: "User",
+			Created:      user.CreatedAt,
+			Location:     fmt.Sprintf("/scim/v2/Users/%d", user.ID),
+			Version:      fmt.Sprintf(`W/"%d"`, user.Version),
+		},
+	}
+}
+
+// displayName works out the single name we store for a user from the SCIM name attributes
+func (u scimUser) displayName() string {
+	switch {
+	case u.Name.Formatted != "":
+		return u.Name.Formatted
+	case u.DisplayName != "":
+		return u.DisplayName
+	case u.Name.GivenName != "" || u.Name.FamilyName != "":
+		return strings.TrimSpace(u.Name.GivenName + " " + u.Name.FamilyName)
+	default:
+		return u.UserName
+	}
+}
+
+// email returns the primary email address of the SCIM user, falling back to the userName
+func (u scimUser) email() string {
+	for _, email := range u.Emails {
+		if email.Primary {
+			return email.Value
+		}
+	}
+	if len(u.Emails) > 0 {
+		return u.Emails[0].Value
+	}
+	return u.UserName
+}
+
+// requireSCIMToken is a middleware function which checks that the request carries the configured SCIM bearer token.
+// The SCIM endpoints are used by identity providers rather than users, so they sit outside the authenticate middleware
+func (app *application) requireSCIMToken(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Add("Vary", "Authorization")
+
+		token, found := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+
+		// use a constant time comparison so the token can't be guessed from the response timings
+		if !found || subtle.ConstantTimeCompare([]byte(token), []byte(app.config.scim.token)) != 1 {
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			app.scimErrorResponse(w, r, http.StatusUnauthorized, "invalid or missing SCIM token")
+			return
+		}
+
+		// there is no user behind the SCIM token, so the request is made as the AnonymousUser
+		r = app.contextSetUser(r, data.AnonymousUser)
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// scimErrorResponse sends an error in the format described by RFC 7644 section 3.12
+func (app *application) scimErrorResponse(w http.ResponseWriter, r *http.Request, status int, detail string) {
+	env := envelope{
+		"schemas": []string{scimErrorSchema},
+		"status":  strconv.Itoa(status),
+		"detail":  detail,
+	}
+
+	err := app.writeSCIM(w, status, env)
+	if err != nil {
+		app.logError(r, err)
+		w.WriteHeader(http.StatusInternalServerError)
+	}
+}
+
+// writeSCIM writes a SCIM JSON response with the application/scim+json content type
+func (app *application) writeSCIM(w http.ResponseWriter, status int, data interface{}) error {
+	js, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+
+	w.Header().Set("Content-Type", scimContentType)
+	w.WriteHeader(status)
+	w.Write(append(js, '\n'))
+
+	return nil
+}
+
+// readSCIM decodes a SCIM request body. Unlike readJSON, unknown fields are ignored because identity providers
+// send plenty of attributes (locale, timezone, groups...) which we have no use for
+func (app *application) readSCIM(w http.ResponseWriter, r *http.Request, dst interface{}) error {
+	r.Body = http.MaxBytesReader(w, r.Body, scimMaxRequestSize)
+
+	err := json.NewDecoder(r.Body).Decode(dst)
+	if err != nil {
+		return errors.New("body contains badly-formed JSON")
+	}
+
+	return nil
+}
+
+// readSCIMUser reads the user ID from the URL and fetches the user, sending the SCIM error response if it can't
+func (app *application) readSCIMUser(w http.ResponseWriter, r *http.Request) (*data.User, bool) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.scimErrorResponse(w, r, http.StatusNotFound, "user not found")
+		return nil, false
+	}
+
+	user, err := app.models.Users.GetByID(r.Context(), id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.scimErrorResponse(w, r, http.StatusNotFound, "user not found")
+		default:
+			app.logError(r, err)
+			app.scimErrorResponse(w, r, http.StatusInternalServerError, "internal server error")
+		}
+		return nil, false
+	}
+
+	return user, true
+}
+
+// saveSCIMUser validates and stores the changes made to a provisioned user, disabling or enabling them to match active,
+// sending the SCIM error response if it fails
+func (app *application) saveSCIMUser(w http.ResponseWriter, r *http.Request, user *data.User, active bool) bool {
+	v := validator.New()
+
+	if data.ValidateUser(v, user); !v.Valid() {
+		app.scimErrorResponse(w, r, http.StatusBadRequest, scimValidationDetail(v))
+		return false
+	}
+
+	err := app.models.Users.Update(r.Context(), user)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrDuplicateEmail):
+			app.scimErrorResponse(w, r, http.StatusConflict, "a user with this userName already exists")
+		case errors.Is(err, data.ErrEditConflict):
+			app.scimErrorResponse(w, r, http.StatusConflict, "unable to update the user due to an edit conflict, please try again")
+		default:
+			app.logError(r, err)
+			app.scimErrorResponse(w, r, http.StatusInternalServerError, "internal server error")
+		}
+		return false
+	}
+
+	// an inactive user is disabled rather than just deactivated, which they could undo by activating their account
+	// again. Disabling them signs them out everywhere too
+	switch {
+	case !active && !user.Disabled():
+		err = app.models.Users.Disable(r.Context(), user)
+	case active && user.Disabled():
+		err = app.models.Users.Enable(r.Context(), user)
+	}
+	if err != nil {
+		app.logError(r, err)
+		app.scimErrorResponse(w, r, http.StatusInternalServerError, "internal server error")
+		return false
+	}
+
+	return true
+}
+
+// scimValidationDetail flattens the validator errors into the single detail string a SCIM error carries
+func scimValidationDetail(v *validator.Validator) string {
+	var details []string
+	for key, message := range v.Errors {
+		details = append(details, key+" "+message)
+	}
+	return strings.Join(details, ", ")
+}
+
+// randomPassword generates a random password for provisioned users. They authenticate through their identity provider,
+// so the password is never shared with anybody, but the users table requires a password hash
+func (app *application) randomPassword() (string, error) {
+	b := make([]byte, 32)
+
+	_, err := io.ReadFull(app.random, b)
+	if err != nil {
+		return "", err
+	}
+
+	return base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString(b)[:64], nil
+}
+
+// scimCreateUserHandler provisions a new user. Provisioned users are activated straight away, since their email address
+// has already been verified by the organization, and are disabled if the identity provider says they are inactive
+func (app *application) scimCreateUserHandler(w http.ResponseWriter, r *http.Request) {
+	var input scimUser
+
+	err := app.readSCIM(w, r, &input)
+	if err != nil {
+		app.scimErrorResponse(w, r, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	user := &data.User{
+		Name:      input.displayName(),
+		Email:     input.email(),
+		Activated: true,
+	}
+
+	password := input.Password
+	if password == "" {
+		password, err = app.randomPassword()
+		if err != nil {
+			app.logError(r, err)
+			app.scimErrorResponse(w, r, http.StatusInternalServerError, "internal server error")
+			return
+		}
+	}
+
+	err = user.Password.HashPassword(password)
+	if err != nil {
+		app.logError(r, err)
+		app.scimErrorResponse(w, r, http.StatusInternalServerError, "internal server error")
+		return
+	}
+
+	v := validator.New()
+
+	if data.ValidateUser(v, user); !v.Valid() {
+		app.scimErrorResponse(w, r, http.StatusBadRequest, scimValidationDetail(v))
+		return
+	}
+
+	err = app.models.Users.Insert(r.Context(), user)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrDuplicateEmail):
+			app.scimErrorResponse(w, r, http.StatusConflict, "a user with this userName already exists")
+		default:
+			app.logError(r, err)
+			app.scimErrorResponse(w, r, http.StatusInternalServerError, "internal server error")
+		}
+		return
+	}
+
+	err = app.models.Permissions.AddForUser(r.Context(), user.ID, "movies:read")
+	if err != nil {
+		app.logError(r, err)
+		app.scimErrorResponse(w, r, http.StatusInternalServerError, "internal server error")
+		return
+	}
+
+	if input.Active != nil && !*input.Active {
+		err = app.models.Users.Disable(r.Context(), user)
+		if err != nil {
+			app.logError(r, err)
+			app.scimErrorResponse(w, r, http.StatusInternalServerError, "internal server error")
+			return
+		}
+	}
+
+	app.recordAudit(r, data.AuditCategoryAuth, "scim_user_created", user.Email)
+
+	w.Header().Set("Location", fmt.Sprintf("/scim/v2/Users/%d", user.ID))
+
+	err = app.writeSCIM(w, http.StatusCreated, newSCIMUser(user))
+	if err != nil {
+		app.logError(r, err)
+	}
+}
+
+// scimShowUserHandler returns a single provisioned user
+func (app *application) scimShowUserHandler(w http.ResponseWriter, r *http.Request) {
+	user, ok := app.readSCIMUser(w, r)
+	if !ok {
+		return
+	}
+
+	err := app.writeSCIM(w, http.StatusOK, newSCIMUser(user))
+	if err != nil {
+		app.logError(r, err)
+	}
+}
+
+// scimListUsersHandler supports the `filter=userName eq "..."` lookup identity providers use to check whether a user already
+// exists before provisioning it. Listing all users isn't supported
+func (app *application) scimListUsersHandler(w http.ResponseWriter, r *http.Request) {
+	filter := r.URL.Query().Get("filter")
+
+	attribute, value, found := strings.Cut(filter, " eq ")
+	if !found || !strings.EqualFold(strings.TrimSpace(attribute), "userName") {
+		app.scimErrorResponse(w, r, http.StatusBadRequest, `only the filter 'userName eq "..."' is supported`)
+		return
+	}
+
+	resources := []scimUser{}
+
+	user, err := app.models.Users.GetByEmail(r.Context(), strings.Trim(strings.TrimSpace(value), `"`))
+	switch {
+	case err == nil:
+		resources = append(resources, newSCIMUser(user))
+	case !errors.Is(err, data.ErrRecordNotFound):
+		app.logError(r, err)
+		app.scimErrorResponse(w, r, http.StatusInternalServerError, "internal server error")
+		return
+	}
+
+	env := envelope{
+		"schemas":      []string{scimListSchema},
+		"totalResults": len(resources),
+		"startIndex":   1,
+		"itemsPerPage": len(resources),
+		"Resources":    resources,
+	}
+
+	err = app.writeSCIM(w, http.StatusOK, env)
+	if err != nil {
+		app.logError(r, err)
+	}
+}
+
+// scimPatchUserHandler applies a SCIM PatchOp to a provisioned user. The supported attributes are active, userName,
+// displayName and name.formatted, which covers what identity providers send when a user is renamed or deactivated
+func (app *application) scimPatchUserHandler(w http.ResponseWriter, r *http.Request) {
+	user, ok := app.readSCIMUser(w, r)
+	if !ok {
+		return
+	}
+
+	var input struct {
+		Schemas    []string `json:"schemas"`
+		Operations []struct {
+			Op    string          `json:"op"`
+			Path  string          `json:"path"`
+			Value json.RawMessage `json:"value"`
+		} `json:"Operations"`
+	}
+
+	err := app.readSCIM(w, r, &input)
+	if err != nil {
+		app.scimErrorResponse(w, r, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	if !validator.In(scimPatchOpSchema, input.Schemas...) {
+		app.scimErrorResponse(w, r, http.StatusBadRequest, "request must use the PatchOp schema")
+		return
+	}
+
+	active := !user.Disabled()
+
+	for _, operation := range input.Operations {
+		if !strings.EqualFold(operation.Op, "replace") && !strings.EqualFold(operation.Op, "add") {
+			app.scimErrorResponse(w, r, http.StatusBadRequest, fmt.Sprintf("unsupported patch operation %q", operation.Op))
+			return
+		}
+
+		// an operation without a path carries an object with the attributes to replace
+		values := map[string]json.RawMessage{}
+		if operation.Path == "" {
+			err = json.Unmarshal(operation.Value, &values)
+			if err != nil {
+				app.scimErrorResponse(w, r, http.StatusBadRequest, "patch value must be an object when no path is given")
+				return
+			}
+		} else {
+			values[operation.Path] = operation.Value
+		}
+
+		for path, value := range values {
+			err = applySCIMPatch(user, &active, path, value)
+			if err != nil {
+				app.scimErrorResponse(w, r, http.StatusBadRequest, err.Error())
+				return
+			}
+		}
+	}
+
+	if !app.saveSCIMUser(w, r, user, active) {
+		return
+	}
+
+	app.recordAudit(r, data.AuditCategoryAuth, "scim_user_updated", user.Email)
+
+	err = app.writeSCIM(w, http.StatusOK, newSCIMUser(user))
+	if err != nil {
+		app.logError(r, err)
+	}
+}
+
+// applySCIMPatch sets a single attribute on the user from a SCIM patch operation. The active attribute is set on active
+// rather than the user, as it is saved separately
+func applySCIMPatch(user *data.User, active *bool, path string, value json.RawMessage) error {
+	switch strings.ToLower(path) {
+	case "active":
+		// some identity providers send the boolean as a string
+		err := json.Unmarshal(value, active)
+		if err != nil {
+			var s string
+			if json.Unmarshal(value, &s) != nil {
+				return errors.New("active must be a boolean")
+			}
+			*active, err = strconv.ParseBool(s)
+			if err != nil {
+				return errors.New("active must be a boolean")
+			}
+		}
+		// an active user has had their email address verified by the organization
+		if *active {
+			user.Activated = true
+		}
+	case "username":
+		err := json.Unmarshal(value, &user.Email)
+		if err != nil {
+			return errors.New("userName must be a string")
+		}
+	case "displayname", "name.formatted":
+		err := json.Unmarshal(value, &user.Name)
+		if err != nil {
+			return fmt.Errorf("%s must be a string", path)
+		}
+	default:
+		return fmt.Errorf("unsupported patch path %q", path)
+	}
+
+	return nil
+}
+
+// scimDeleteUserHandler deprovisions a user. The user record is kept (so their content and audit trail stay intact),
+// but the account is disabled, which revokes all of its tokens and stops them signing in or activating it again
+func (app *application) scimDeleteUserHandler(w http.ResponseWriter, r *http.Request) {
+	user, ok := app.readSCIMUser(w, r)
+	if !ok {
+		return
+	}
+
+	if !app.saveSCIMUser(w, r, user, false) {
+		return
+	}
+
+	app.recordAudit(r, data.AuditCategoryAuth, "scim_user_deactivated", user.Email)
+
+	w.WriteHeader(http.StatusNoContent)
+}
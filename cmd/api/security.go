@@ -0,0 +1,98 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"github.com/nytro04/greenlight/internal/data"
+	"github.com/nytro04/greenlight/internal/validator"
+	"github.com/tomasen/realip"
+)
+
+// recordSecurityEvent stores a security event for the current request and forwards it to the configured SIEM in the background.
+// The user is taken from the request context, anonymous users are stored without a user ID. Failing to store or forward the event
+// is logged but doesn't fail the request
+func (app *application) recordSecurityEvent(r *http.Request, eventType string, details map[string]string) {
+	event := &data.SecurityEvent{
+		Type:    eventType,
+		IP:      realip.FromRequest(r),
+		Details: details,
+	}
+
+	user := app.contextGetUser(r)
+	if !user.IsAnonymous() {
+		event.UserID = &user.ID
+	}
+
+	err := app.models.SecurityEvents.Insert(event)
+	if err != nil {
+		app.logError(r, err)
+		return
+	}
+
+	// forward the event in the background, so a slow SIEM never holds up the request
+	logger := app.contextGetLogger(r)
+
+	app.background(func() {
+		js, err := json.Marshal(event)
+		if err != nil {
+			logger.PrintError(err)
+			return
+		}
+
+		err = app.siem.Forward(js)
+		if err != nil {
+			logger.PrintError(err, "security_event", event.Type)
+		}
+	})
+}
+
+// listSecurityEventsHandler returns a paginated list of security events, optionally filtered by type and user ID
+func (app *application) listSecurityEventsHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Type   string
+		UserID int
+		data.Filters
+	}
+
+	v := validator.New()
+
+	qs := r.URL.Query()
+
+	input.Type = app.readString(qs, "type", "")
+	input.UserID = app.readInt(qs, "user_id", 0, v)
+
+	input.Filters.Page = app.readInt(qs, "page", 1, v)
+	input.Filters.PageSize = app.readInt(qs, "page_size", 20, v)
+
+	// security events are sorted newest first unless the client asks otherwise
+	input.Filters.Sort = app.readString(qs, "sort", "-created_at")
+	input.Filters.SortSafeList = []string{"id", "created_at", "type", "-id", "-created_at", "-type"}
+
+	if input.Type != "" {
+		v.Check(validator.In(input.Type, data.SecurityEventTypes...), "type", "invalid security event type")
+	}
+	v.Check(input.UserID >= 0, "user_id", "must not be negative")
+
+	if data.ValidateFilters(v, input.Filters); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	events, metadata, err := app.models.SecurityEvents.GetAll(input.Type, int64(input.UserID), input.Filters)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrUnsafeSort):
+			app.invalidSortResponse(w, r, input.Filters)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"security_events": events, "metadata": metadata}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
@@ -0,0 +1,299 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/nytro04/greenlight/internal/data"
+	"github.com/nytro04/greenlight/internal/validator"
+)
+
+// embedSeries fills in the place of each of the movies in its series, with a single query whatever the number of
+// movies. The movies which aren't part of a series are left without one
+func (app *application) embedSeries(r *http.Request, movies ...*data.Movie) error {
+	if len(movies) == 0 {
+		return nil
+	}
+
+	ids := make([]int64, len(movies))
+	for i, movie := range movies {
+		ids[i] = movie.ID
+	}
+
+	entries, err := app.models.Series.GetEntries(r.Context(), ids...)
+	if err != nil {
+		return err
+	}
+
+	for _, movie := range movies {
+		movie.Series = entries[movie.ID]
+	}
+
+	return nil
+}
+
+func (app *application) createSeriesHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Name        string `json:"name"`
+		Description string `json:"description"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	series := &data.Series{
+		Name:        input.Name,
+		Description: input.Description,
+	}
+
+	v := validator.New()
+
+	if data.ValidateSeries(v, series); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = app.models.Series.Insert(r.Context(), series)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	app.recordAudit(r, data.AuditCategoryContent, "series_created", fmt.Sprintf("series:%d", series.ID))
+
+	headers := make(http.Header)
+	headers.Set("Location", fmt.Sprintf("/v1/series/%d", series.ID))
+
+	err = app.writeJSON(w, http.StatusCreated, envelope{"series": series}, headers)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// readSeries reads the series ID from the URL and fetches the series, sending a 404 if it doesn't exist
+func (app *application) readSeries(w http.ResponseWriter, r *http.Request) (*data.Series, bool) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return nil, false
+	}
+
+	series, err := app.models.Series.Get(r.Context(), id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return nil, false
+	}
+
+	return series, true
+}
+
+// showSeriesHandler returns a series with all of its movies, in order
+func (app *application) showSeriesHandler(w http.ResponseWriter, r *http.Request) {
+	series, ok := app.readSeries(w, r)
+	if !ok {
+		return
+	}
+
+	movies, err := app.models.Series.GetMovies(r.Context(), series.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	_, err = app.markFavorites(r, movies...)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"series": series, "movies": movies}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) listSeriesHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Name string
+		data.Filters
+	}
+
+	v := validator.New()
+
+	qs := r.URL.Query()
+
+	input.Name = app.readString(qs, "name", "")
+
+	input.Filters.Page = app.readInt(qs, "page", 1, v)
+	input.Filters.PageSize = app.readInt(qs, "page_size", 20, v)
+	input.Filters.Sort = app.readString(qs, "sort", "name")
+	input.Filters.SortSafeList = []string{"id", "name", "created_at", "-id", "-name", "-created_at"}
+
+	if data.ValidateFilters(v, input.Filters); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	series, metadata, err := app.models.Series.GetAll(r.Context(), input.Name, input.Filters)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrUnsafeSort):
+			app.invalidSortResponse(w, r, input.Filters)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"series": series, "metadata": metadata}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) updateSeriesHandler(w http.ResponseWriter, r *http.Request) {
+	series, ok := app.readSeries(w, r)
+	if !ok {
+		return
+	}
+
+	var input struct {
+		Name        *string `json:"name"`
+		Description *string `json:"description"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	if input.Name != nil {
+		series.Name = *input.Name
+	}
+	if input.Description != nil {
+		series.Description = *input.Description
+	}
+
+	v := validator.New()
+
+	if data.ValidateSeries(v, series); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = app.models.Series.Update(r.Context(), series)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrEditConflict):
+			app.editConflictResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	app.recordAudit(r, data.AuditCategoryContent, "series_updated", fmt.Sprintf("series:%d", series.ID))
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"series": series}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// deleteSeriesHandler deletes a series, leaving its movies in place
+func (app *application) deleteSeriesHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	err = app.models.Series.Delete(r.Context(), id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	app.recordAudit(r, data.AuditCategoryContent, "series_deleted", fmt.Sprintf("series:%d", id))
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"message": "series successfully deleted"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// setSeriesMoviesHandler replaces the movies of a series with the ones in the request, in the order they are given,
+// so the same request adds, removes and reorders movies. The response holds the series with its movies
+func (app *application) setSeriesMoviesHandler(w http.ResponseWriter, r *http.Request) {
+	series, ok := app.readSeries(w, r)
+	if !ok {
+		return
+	}
+
+	var input struct {
+		MovieIDs []int64 `json:"movie_ids"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+
+	v.Check(input.MovieIDs != nil, "movie_ids", "must be provided")
+	v.Check(len(input.MovieIDs) <= data.MaxSeriesMovies, "movie_ids", fmt.Sprintf("must not contain more than %d movies", data.MaxSeriesMovies))
+	v.Check(validator.Unique(input.MovieIDs), "movie_ids", "must not contain duplicate values")
+
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	movies, err := app.models.Series.SetMovies(r.Context(), series.ID, input.MovieIDs)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		case errors.Is(err, data.ErrSeriesMovieNotFound):
+			v.AddError("movie_ids", "must only contain existing movies")
+			app.failedValidationResponse(w, r, v.Errors)
+		case errors.Is(err, data.ErrMovieInOtherSeries):
+			v.AddError("movie_ids", "must not contain movies which are part of another series")
+			app.failedValidationResponse(w, r, v.Errors)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	app.recordAudit(r, data.AuditCategoryContent, "series_movies_set", fmt.Sprintf("series:%d", series.ID))
+
+	// read the series back from the primary, for its new movie count and version
+	series, err = app.models.Series.Get(r.Context(), series.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"series": series, "movies": movies}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
@@ -4,11 +4,16 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"log"
+	"log/slog"
+	"net"
 	"net/http"
 	"os"
 	"os/signal"
 	"syscall"
 	"time"
+
+	"github.com/nytro04/greenlight/internal/jsonlog"
 )
 
 func (app *application) serve() error {
@@ -16,11 +21,65 @@ func (app *application) serve() error {
 	srv := &http.Server{
 		Addr:         fmt.Sprintf(":%d", app.config.port),
 		Handler:      app.routes(),
+		ErrorLog:     app.serverErrorLog(),
 		IdleTimeout:  time.Minute,
 		ReadTimeout:  10 * time.Second,
 		WriteTimeout: 30 * time.Second,
 	}
 
+	// when TLS is enabled the API serves HTTPS on its port, and plain HTTP requests to the redirect address are sent on
+	// to it. In autocert mode the redirect listener also answers the ACME HTTP-01 challenges
+	tlsConfig, certManager := app.serverTLSConfig()
+	srv.TLSConfig = tlsConfig
+
+	// the operational endpoints get their own server on the internal admin address, when one is configured, and the
+	// redirect to HTTPS another. They are listening before the API starts, so a port which is already taken stops the
+	// application from starting
+	var secondary []*http.Server
+	if app.config.admin.addr != "" {
+		secondary = append(secondary, &http.Server{
+			Addr:        app.config.admin.addr,
+			Handler:     app.adminRoutes(),
+			ErrorLog:    app.serverErrorLog(),
+			IdleTimeout: time.Minute,
+			ReadTimeout: 10 * time.Second,
+			// long enough for a 60 second CPU profile or execution trace
+			WriteTimeout: 90 * time.Second,
+		})
+	}
+
+	if app.config.tls.redirectAddr != "" {
+		var handler http.Handler = http.HandlerFunc(app.redirectToHTTPSHandler)
+		if certManager != nil {
+			handler = certManager.HTTPHandler(handler)
+		}
+
+		secondary = append(secondary, &http.Server{
+			Addr:         app.config.tls.redirectAddr,
+			Handler:      handler,
+			ErrorLog:     app.serverErrorLog(),
+			IdleTimeout:  time.Minute,
+			ReadTimeout:  5 * time.Second,
+			WriteTimeout: 5 * time.Second,
+		})
+	}
+
+	for _, s := range secondary {
+		listener, err := net.Listen("tcp", s.Addr)
+		if err != nil {
+			return err
+		}
+
+		go func() {
+			err := s.Serve(listener)
+			if !errors.Is(err, http.ErrServerClosed) {
+				app.logger.PrintError(err, "addr", s.Addr)
+			}
+		}()
+
+		app.logger.PrintInfo("starting secondary server", "addr", s.Addr)
+	}
+
 	// Declare a shutdownError channel to receive any errors returned by the graceful shutdown process
 	shutdownError := make(chan error)
 
@@ -35,9 +94,7 @@ func (app *application) serve() error {
 		s := <-quit
 
 		// log a message to say that the signal has been caught, along with the signal type(name) as a string
-		app.logger.PrintInfo("shutting down server", map[string]string{
-			"signal": s.String(),
-		})
+		app.logger.PrintInfo("shutting down server", "signal", s.String())
 
 		// create a context with a 5-second timeout
 		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
@@ -49,8 +106,16 @@ func (app *application) serve() error {
 			shutdownError <- err
 		}
 
+		// the secondary servers are stopped after the API, so the metrics can be scraped while the requests drain
+		for _, s := range secondary {
+			err := s.Shutdown(ctx)
+			if err != nil {
+				app.logger.PrintError(err, "addr", s.Addr)
+			}
+		}
+
 		// log a message to say that the shutdown process has completed
-		app.logger.PrintInfo("completing background tasks", map[string]string{"addr": srv.Addr})
+		app.logger.PrintInfo("completing background tasks", "addr", srv.Addr)
 
 		// Call the Wait() method on the WaitGroup to block until all goroutines have finished.
 		// This is a safety measure to ensure that all background tasks have completed before the main() function exits.
@@ -58,20 +123,32 @@ func (app *application) serve() error {
 		// Then we return nil on the shutdownError channel to indicate that the shutdown process completed successfully.
 		// This is important because the main() function will block until it receives a value from the shutdownError channel.
 		// If we don't send a value, the main() function will block indefinitely, which will prevent the application from exiting.
+		// the scheduled jobs are stopped once the requests have drained, so the jobs triggered by them still run
+		app.jobs.Stop()
+
+		// the views counted since the last flush would be lost otherwise
+		err = app.flushMovieViews()
+		if err != nil {
+			app.logger.PrintError(err, "message", "Error flushing movie views")
+		}
+
 		app.wg.Wait()
 		shutdownError <- nil
 	}()
 
 	// log a starting server message
-	app.logger.PrintInfo("starting server", map[string]string{
-		"addr": srv.Addr,
-		"env":  app.config.env,
-	})
+	app.logger.PrintInfo("starting server", "addr", srv.Addr, "env", app.config.env, "tls", srv.TLSConfig != nil)
 
 	// calling shutdown() on our server will cause the Serve() method to immediately return an http.ErrServerClosed error.
 	// so if we see this error, it's actually a good thing and an indication that the graceful shutdown has started.
 	// so we check specifically for this, only returning the error if it's not http.ErrServerClosed
-	err := srv.ListenAndServe()
+	var err error
+	if srv.TLSConfig != nil {
+		// the certificate and key are empty in autocert mode, where the TLS config supplies the certificates
+		err = srv.ListenAndServeTLS(app.config.tls.certFile, app.config.tls.keyFile)
+	} else {
+		err = srv.ListenAndServe()
+	}
 	if !errors.Is(err, http.ErrServerClosed) {
 		return err
 	}
@@ -84,9 +161,13 @@ func (app *application) serve() error {
 	}
 
 	// at this point, we know that the graceful shutdown completed successfully, so we log a message to say so
-	app.logger.PrintInfo("stopped server", map[string]string{
-		"addr": srv.Addr,
-	})
+	app.logger.PrintInfo("stopped server", "addr", srv.Addr)
 
 	return nil
 }
+
+// serverErrorLog returns the logger the HTTP servers report connection problems such as failed TLS handshakes to. They
+// are written at the warning level, as they are caused by the clients and a stack trace of the server wouldn't help
+func (app *application) serverErrorLog() *log.Logger {
+	return slog.NewLogLogger(app.logger.Handler(), jsonlog.LevelWarn)
+}
@@ -0,0 +1,332 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/coreos/go-oidc/v3/oidc"
+	"github.com/julienschmidt/httprouter"
+	"github.com/nytro04/greenlight/internal/data"
+	"golang.org/x/oauth2"
+)
+
+// ssoStateTTL is how long a user has to complete the login at their identity provider
+const ssoStateTTL = 10 * time.Minute
+
+// ssoClaims holds the ID token claims we use to provision and link accounts. The role claim is read separately,
+// since the name of the claim is configured per organization
+type ssoClaims struct {
+	Email         string `json:"email"`
+	EmailVerified bool   `json:"email_verified"`
+	Name          string `json:"name"`
+}
+
+// readSSOOrganization fetches the organization named by the slug in the URL, along with its single sign-on settings.
+// A 404 is sent if the organization doesn't exist or hasn't configured SSO
+func (app *application) readSSOOrganization(w http.ResponseWriter, r *http.Request) (*data.Organization, *data.OrganizationSSO, bool) {
+	slug := httprouter.ParamsFromContext(r.Context()).ByName("slug")
+
+	org, err := app.models.Organizations.GetBySlug(slug)
+	if err == nil {
+		var sso *data.OrganizationSSO
+		sso, err = app.models.Organizations.GetSSO(org.ID)
+		if err == nil {
+			return org, sso, true
+		}
+	}
+
+	switch {
+	case errors.Is(err, data.ErrRecordNotFound):
+		app.notFoundResponse(w, r)
+	default:
+		app.serverErrorResponse(w, r, err)
+	}
+
+	return nil, nil, false
+}
+
+// ssoProvider runs the OIDC discovery for the organization's issuer and returns the provider together with the OAuth2
+// configuration used to redirect the user to it and exchange the authorization code
+func (app *application) ssoProvider(ctx context.Context, org *data.Organization, sso *data.OrganizationSSO) (*oidc.Provider, *oauth2.Config, error) {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	provider, err := oidc.NewProvider(ctx, sso.Issuer)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	config := &oauth2.Config{
+		ClientID:     sso.ClientID,
+		ClientSecret: sso.ClientSecret,
+		Endpoint:     provider.Endpoint(),
+		RedirectURL:  fmt.Sprintf("%s/v1/sso/%s/callback", app.config.sso.baseURL, org.Slug),
+		Scopes:       []string{oidc.ScopeOpenID, "email", "profile"},
+	}
+
+	return provider, config, nil
+}
+
+// ssoRole works out the organization role for a member from the role claim in their ID token. The claim can either be a
+// single string or a list of strings (e.g. groups), if several values are mapped the most privileged role wins
+func ssoRole(sso *data.OrganizationSSO, idToken *oidc.IDToken) string {
+	role := sso.DefaultRole
+
+	if sso.RoleClaim == "" {
+		return role
+	}
+
+	var claims map[string]interface{}
+	if idToken.Claims(&claims) != nil {
+		return role
+	}
+
+	var values []string
+	switch claim := claims[sso.RoleClaim].(type) {
+	case string:
+		values = append(values, claim)
+	case []interface{}:
+		for _, value := range claim {
+			if s, ok := value.(string); ok {
+				values = append(values, s)
+			}
+		}
+	}
+
+	for _, value := range values {
+		mapped, ok := sso.RoleMapping[value]
+		if ok && data.OrganizationRoleRank(mapped) > data.OrganizationRoleRank(role) {
+			role = mapped
+		}
+	}
+
+	return role
+}
+
+// ssoLoginHandler starts a single sign-on login by redirecting the user to their organization's identity provider
+func (app *application) ssoLoginHandler(w http.ResponseWriter, r *http.Request) {
+	org, sso, ok := app.readSSOOrganization(w, r)
+	if !ok {
+		return
+	}
+
+	_, config, err := app.ssoProvider(r.Context(), org, sso)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	state, nonce, err := app.models.Organizations.NewSSOState(org.ID, ssoStateTTL)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	http.Redirect(w, r, config.AuthCodeURL(state, oidc.Nonce(nonce)), http.StatusFound)
+}
+
+// ssoCallbackHandler completes a single sign-on login. The authorization code is exchanged for an ID token, which is verified
+// against the issuer and the nonce stored with the state. The user is then linked by their verified email address (or created
+// just in time if they don't have an account yet), given their mapped role in the organization and issued an authentication token
+func (app *application) ssoCallbackHandler(w http.ResponseWriter, r *http.Request) {
+	org, sso, ok := app.readSSOOrganization(w, r)
+	if !ok {
+		return
+	}
+
+	qs := r.URL.Query()
+
+	// the identity provider redirects back with an error parameter if the user cancelled or wasn't allowed to log in
+	if qs.Get("error") != "" {
+		app.badRequestResponse(w, r, fmt.Errorf("identity provider returned an error: %s", qs.Get("error")))
+		return
+	}
+
+	nonce, err := app.models.Organizations.ConsumeSSOState(org.ID, qs.Get("state"))
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.badRequestResponse(w, r, errors.New("invalid or expired state parameter"))
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	provider, config, err := app.ssoProvider(r.Context(), org, sso)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	token, err := config.Exchange(r.Context(), qs.Get("code"))
+	if err != nil {
+		app.recordSecurityEvent(r, data.SecurityEventLoginFailed, map[string]string{"organization": org.Slug, "reason": "sso code exchange failed"})
+		app.invalidCredentialsResponse(w, r)
+		return
+	}
+
+	rawIDToken, ok := token.Extra("id_token").(string)
+	if !ok {
+		app.invalidCredentialsResponse(w, r)
+		return
+	}
+
+	idToken, err := provider.Verifier(&oidc.Config{ClientID: sso.ClientID}).Verify(r.Context(), rawIDToken)
+	if err != nil || idToken.Nonce != nonce {
+		app.recordSecurityEvent(r, data.SecurityEventLoginFailed, map[string]string{"organization": org.Slug, "reason": "invalid sso id token"})
+		app.invalidCredentialsResponse(w, r)
+		return
+	}
+
+	var claims ssoClaims
+
+	err = idToken.Claims(&claims)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	// accounts are only linked by email address if the identity provider has verified it, otherwise anybody
+	// who can register an address at the identity provider could take over an existing account
+	if claims.Email == "" || !claims.EmailVerified {
+		app.recordSecurityEvent(r, data.SecurityEventLoginFailed, map[string]string{"organization": org.Slug, "reason": "sso email not verified"})
+		app.errorResponse(w, r, http.StatusForbidden, "your identity provider must supply a verified email address")
+		return
+	}
+
+	// the identity provider can vouch for any address, so it can only sign in existing members of the organization
+	// and accounts at the domains the organization has been verified to own
+	var current string
+
+	user, err := app.models.Users.GetByEmail(r.Context(), claims.Email)
+	switch {
+	case err == nil:
+		if user.Disabled() {
+			app.recordSecurityEvent(r, data.SecurityEventLoginFailed, map[string]string{"organization": org.Slug, "email": user.Email, "reason": "account disabled"})
+			app.accountDisabledResponse(w, r)
+			return
+		}
+
+		current, err = app.models.Organizations.GetMemberRole(org.ID, user.ID)
+		if err != nil && !errors.Is(err, data.ErrRecordNotFound) {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+
+		if current == "" && !sso.OwnsEmail(user.Email) {
+			app.ssoDomainNotVerifiedResponse(w, r, org, claims.Email)
+			return
+		}
+
+		// the identity provider's assertion doesn't stand in for the user's second factor, so the users who have turned
+		// on two-factor authentication sign in with their password and code, and their membership is left as it is
+		t, err := app.getEnabledTOTP(user.ID)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+
+		if t != nil {
+			app.recordSecurityEvent(r, data.SecurityEventLoginFailed, map[string]string{"organization": org.Slug, "email": user.Email, "reason": "sso login with two-factor enabled"})
+			app.errorResponse(w, r, http.StatusForbidden, "your account has two-factor authentication turned on, sign in with your password and code instead")
+			return
+		}
+
+		// the email address has been verified by the identity provider, so there is no need for the activation email
+		if !user.Activated {
+			user.Activated = true
+			err = app.models.Users.Update(r.Context(), user)
+			if err != nil {
+				app.serverErrorResponse(w, r, err)
+				return
+			}
+		}
+	case errors.Is(err, data.ErrRecordNotFound):
+		if !sso.OwnsEmail(claims.Email) {
+			app.ssoDomainNotVerifiedResponse(w, r, org, claims.Email)
+			return
+		}
+
+		user, err = app.provisionSSOUser(r.Context(), claims)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+	default:
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	// the organization owners are managed by the organization itself, so the identity provider never changes their role.
+	// The role only applies within the organization, it never grants permissions over the rest of the catalog
+	role := ssoRole(sso, idToken)
+
+	if current != data.OrganizationRoleOwner {
+		err = app.models.Organizations.SetMember(org.ID, user.ID, role)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+	}
+
+	authToken, err := app.newAuthenticationToken(r, user)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	app.recordAudit(r, data.AuditCategoryAuth, "login_sso", user.Email)
+
+	err = app.writeJSON(w, http.StatusCreated, envelope{"authentication_token": authToken}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// provisionSSOUser creates an activated account for a user logging in through single sign-on for the first time.
+// They authenticate through their identity provider, so the account gets a random password nobody knows
+func (app *application) provisionSSOUser(ctx context.Context, claims ssoClaims) (*data.User, error) {
+	user := &data.User{
+		Name:      claims.Name,
+		Email:     claims.Email,
+		Activated: true,
+	}
+
+	if user.Name == "" {
+		user.Name = claims.Email
+	}
+
+	password, err := app.randomPassword()
+	if err != nil {
+		return nil, err
+	}
+
+	err = user.Password.HashPassword(password)
+	if err != nil {
+		return nil, err
+	}
+
+	err = app.models.Users.Insert(ctx, user)
+	if err != nil {
+		return nil, err
+	}
+
+	err = app.models.Permissions.AddForUser(ctx, user.ID, "movies:read")
+	if err != nil {
+		return nil, err
+	}
+
+	return user, nil
+}
+
+// ssoDomainNotVerifiedResponse refuses a single sign-on login for an account which isn't a member of the organization and
+// isn't at one of its verified domains, recording the attempt as it may be an identity provider vouching for an address
+// it doesn't control
+func (app *application) ssoDomainNotVerifiedResponse(w http.ResponseWriter, r *http.Request, org *data.Organization, email string) {
+	app.recordSecurityEvent(r, data.SecurityEventLoginFailed, map[string]string{"organization": org.Slug, "email": email, "reason": "sso email domain not verified"})
+	app.errorResponse(w, r, http.StatusForbidden, "your email address isn't at a domain this organization has verified")
+}
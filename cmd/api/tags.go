@@ -0,0 +1,224 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"net/url"
+
+	"github.com/julienschmidt/httprouter"
+	"github.com/nytro04/greenlight/internal/data"
+	"github.com/nytro04/greenlight/internal/validator"
+)
+
+// readTags reads the comma separated tags filter of a movie listing from the query string, normalized in the same
+// way as the tags users add, so tags=Cult Classic matches the cult-classic tag
+func (app *application) readTags(qs url.Values, v *validator.Validator) []string {
+	tags := app.readCSV(qs, "tags", []string{})
+
+	for i, tag := range tags {
+		tags[i] = data.NormalizeTag(tag)
+		v.Check(data.TagRX.MatchString(tags[i]), "tags", "must only contain letters, digits, spaces and hyphens")
+	}
+
+	return tags
+}
+
+// embedTags fills in the names of the tags of the movies, with a single query whatever the number of movies
+func (app *application) embedTags(r *http.Request, movies ...*data.Movie) error {
+	if len(movies) == 0 {
+		return nil
+	}
+
+	ids := make([]int64, len(movies))
+	for i, movie := range movies {
+		ids[i] = movie.ID
+	}
+
+	tags, err := app.models.Tags.GetForMovies(r.Context(), ids...)
+	if err != nil {
+		return err
+	}
+
+	for _, movie := range movies {
+		movie.Tags = []string{}
+		for _, tag := range tags[movie.ID] {
+			movie.Tags = append(movie.Tags, tag.Name)
+		}
+	}
+
+	return nil
+}
+
+// listMovieTagsHandler returns the tags of a movie, with the users who added them
+func (app *application) listMovieTagsHandler(w http.ResponseWriter, r *http.Request) {
+	movieID, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	// check the movie exists, so an unknown movie gets a 404 rather than an empty list
+	_, err = app.models.Movies.Get(r.Context(), movieID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	tags, err := app.models.Tags.GetForMovies(r.Context(), movieID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	// make sure an empty list is sent rather than null when the movie has no tags
+	if tags[movieID] == nil {
+		tags[movieID] = []*data.MovieTag{}
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"tags": tags[movieID]}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// addMovieTagsHandler puts tags on a movie on behalf of the authenticated user. The tags are normalized first, and the
+// ones the movie already has are left as they are, so the request can be repeated. The response holds every tag of
+// the movie
+func (app *application) addMovieTagsHandler(w http.ResponseWriter, r *http.Request) {
+	movieID, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	var input struct {
+		Tags []string `json:"tags"`
+	}
+
+	err = app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	for i, tag := range input.Tags {
+		input.Tags[i] = data.NormalizeTag(tag)
+	}
+
+	v := validator.New()
+
+	if data.ValidateTags(v, input.Tags); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = app.models.Tags.AddToMovie(r.Context(), movieID, app.contextGetUser(r).ID, input.Tags)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	tags, err := app.models.Tags.GetForMovies(r.Context(), movieID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"tags": tags[movieID]}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// removeMovieTagHandler takes a tag off a movie. Users can remove the tags they added themselves, and the users with
+// the movies:write permission can remove any tag
+func (app *application) removeMovieTagHandler(w http.ResponseWriter, r *http.Request) {
+	movieID, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	name := data.NormalizeTag(httprouter.ParamsFromContext(r.Context()).ByName("tag"))
+
+	tag, err := app.models.Tags.GetForMovie(r.Context(), movieID, name)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	if tag.UserID != app.contextGetUser(r).ID {
+		permitted, err := app.hasPermission(r, "movies:write")
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+
+		if !permitted {
+			app.notPermittedResponse(w, r)
+			return
+		}
+	}
+
+	err = app.models.Tags.RemoveFromMovie(r.Context(), movieID, name)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"message": "tag removed from movie"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// listTagSuggestionsHandler suggests tags for the authenticated user as they type one, starting with the prefix they
+// have typed so far. The tags the user has used the most come first, followed by the most used tags overall
+func (app *application) listTagSuggestionsHandler(w http.ResponseWriter, r *http.Request) {
+	v := validator.New()
+
+	qs := r.URL.Query()
+
+	prefix := data.NormalizeTag(app.readString(qs, "prefix", ""))
+	limit := app.readInt(qs, "limit", 10, v)
+
+	v.Check(prefix == "" || data.TagRX.MatchString(prefix), "prefix", "must only contain letters, digits, spaces and hyphens")
+	v.Check(len(prefix) <= data.MaxTagLength, "prefix", "must not be longer than a tag")
+	v.Check(limit > 0 && limit <= 50, "limit", "must be between 1 and 50")
+
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	suggestions, err := app.models.Tags.Suggest(r.Context(), app.contextGetUser(r).ID, prefix, limit)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"suggestions": suggestions}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"crypto/tls"
+	"errors"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"golang.org/x/crypto/acme/autocert"
+)
+
+// serverTLSConfig returns the TLS settings of the API server, or nil when it serves plain HTTP. In autocert mode the
+// certificate manager is returned too, so the redirect listener can answer the ACME HTTP-01 challenges
+func (app *application) serverTLSConfig() (*tls.Config, *autocert.Manager) {
+	switch {
+	case len(app.config.tls.autocertDomains) > 0:
+		manager := &autocert.Manager{
+			Prompt:     autocert.AcceptTOS,
+			HostPolicy: autocert.HostWhitelist(app.config.tls.autocertDomains...),
+			Cache:      autocert.DirCache(app.config.tls.autocertCache),
+			Email:      app.config.tls.autocertEmail,
+		}
+
+		// the manager's config also answers the TLS-ALPN-01 challenges, so certificates can be issued without the
+		// redirect listener on port 80
+		tlsConfig := manager.TLSConfig()
+		tlsConfig.MinVersion = tls.VersionTLS12
+
+		return tlsConfig, manager
+	case app.config.tls.certFile != "":
+		return &tls.Config{MinVersion: tls.VersionTLS12}, nil
+	default:
+		return nil, nil
+	}
+}
+
+// redirectToHTTPSHandler permanently redirects a plain HTTP request to the same URL on the HTTPS port. A 308 is used
+// rather than a 301 so clients repeat POST and PUT requests with their body
+func (app *application) redirectToHTTPSHandler(w http.ResponseWriter, r *http.Request) {
+	host := r.Host
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	host = strings.Trim(host, "[]")
+
+	if host == "" {
+		app.badRequestResponse(w, r, errors.New("missing Host header"))
+		return
+	}
+
+	if app.config.port != 443 {
+		host = net.JoinHostPort(host, strconv.Itoa(app.config.port))
+	} else if strings.Contains(host, ":") {
+		// an IPv6 address without a port still needs its brackets
+		host = "[" + host + "]"
+	}
+
+	http.Redirect(w, r, "https://"+host+r.URL.RequestURI(), http.StatusPermanentRedirect)
+}
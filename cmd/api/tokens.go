@@ -1,20 +1,50 @@
 package main
 
 import (
+	"context"
 	"errors"
 	"net/http"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/nytro04/greenlight/internal/data"
 	"github.com/nytro04/greenlight/internal/validator"
+	"github.com/tomasen/realip"
 )
 
+// How long the authentication, activation and email change tokens issued to users are valid for
+const (
+	authenticationTokenTTL = 24 * time.Hour
+	activationTokenTTL     = 3 * 24 * time.Hour
+	emailChangeTokenTTL    = 24 * time.Hour
+)
+
+// newAuthenticationToken issues an authentication token for the user who has just logged in. With the default database
+// strategy it is a random token valid for 24 hours whose hash is stored in the tokens table, along with the address and
+// User-Agent of the client so it can be listed among the user's sessions. With the jwt strategy it is a signed JWT
+// holding the user's details, which is valid for the configured JWT TTL
+func (app *application) newAuthenticationToken(r *http.Request, user *data.User) (*data.Token, error) {
+	if app.jwt == nil {
+		return app.models.Tokens.NewSession(r.Context(), user.ID, authenticationTokenTTL, realip.FromRequest(r), r.UserAgent())
+	}
+
+	plaintext, expiry, err := app.jwt.Issue(user.ID, user.Email, user.Name, user.Activated, app.config.jwt.ttl)
+	if err != nil {
+		return nil, err
+	}
+
+	return &data.Token{Plaintext: plaintext, UserID: user.ID, Expiry: expiry, Scope: data.ScopeAuthentication}, nil
+}
+
 // this method is used to create a new authentication token for the user. the token will be used to authenticate the user
 // when they make requests to the API. the token will be stored in the database and the plaintext version will be sent to the user.
 func (app *application) createAuthenticationTokenHandler(w http.ResponseWriter, r *http.Request) {
 	var input struct {
-		Email    string `json:"email"`
-		Password string `json:"password"`
+		Email        string `json:"email"`
+		Password     string `json:"password"`
+		TOTPCode     string `json:"totp_code"`     // only needed when two-factor authentication is enabled
+		RecoveryCode string `json:"recovery_code"` // used instead of the code when the authenticator app is lost
 	}
 
 	err := app.readJSON(w, r, &input)
@@ -26,7 +56,7 @@ func (app *application) createAuthenticationTokenHandler(w http.ResponseWriter,
 	// validate the email and password fields in the input struct
 	v := validator.New()
 	data.ValidateEmail(v, input.Email)
-	data.ValidatePasswordPlaintext(v, input.Password)
+	data.ValidatePasswordLogin(v, input.Password)
 
 	if !v.Valid() {
 		app.failedValidationResponse(w, r, v.Errors)
@@ -34,10 +64,12 @@ func (app *application) createAuthenticationTokenHandler(w http.ResponseWriter,
 	}
 
 	// lookup the user based on the email address. if no user is found, return an error message to the client
-	user, err := app.models.Users.GetByEmail(input.Email)
+	user, err := app.models.Users.GetByEmail(r.Context(), input.Email)
 	if err != nil {
 		switch {
 		case errors.Is(err, data.ErrRecordNotFound):
+			app.recordAudit(r, data.AuditCategoryAuth, "login_failed", input.Email)
+			app.recordSecurityEvent(r, data.SecurityEventLoginFailed, map[string]string{"email": input.Email, "reason": "unknown email"})
 			app.invalidCredentialsResponse(w, r)
 		default:
 			app.serverErrorResponse(w, r, err)
@@ -53,17 +85,65 @@ func (app *application) createAuthenticationTokenHandler(w http.ResponseWriter,
 	}
 	// if the password doesn't match, return an error message to the client
 	if !match {
+		app.recordAudit(r, data.AuditCategoryAuth, "login_failed", input.Email)
+		app.recordSecurityEvent(r, data.SecurityEventLoginFailed, map[string]string{"email": input.Email, "reason": "wrong password"})
 		app.invalidCredentialsResponse(w, r)
 		return
 	}
 
-	// if the password is correct, create a new authentication token for the user with a 24-hour expiry time and the authentication scope
-	token, err := app.models.Tokens.New(user.ID, 24*time.Hour, data.ScopeAuthentication)
+	// a disabled user is only told so once they have given the right password, so it can't be used to probe accounts
+	if user.Disabled() {
+		app.recordSecurityEvent(r, data.SecurityEventLoginFailed, map[string]string{"email": input.Email, "reason": "account disabled"})
+		app.accountDisabledResponse(w, r)
+		return
+	}
+
+	// when the user has enabled two-factor authentication, the password alone isn't enough. the client is told a code
+	// is needed if it didn't send one, so it can ask the user for it and send the password again along with the code
+	t, err := app.getEnabledTOTP(user.ID)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 		return
 	}
 
+	if t != nil {
+		if input.TOTPCode == "" && input.RecoveryCode == "" {
+			app.totpRequiredResponse(w, r)
+			return
+		}
+
+		ok, err := app.verifySecondFactor(r, user, t, input.TOTPCode, input.RecoveryCode)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+
+		if !ok {
+			app.recordAudit(r, data.AuditCategoryAuth, "login_failed", input.Email)
+			app.recordSecurityEvent(r, data.SecurityEventLoginFailed, map[string]string{"email": input.Email, "reason": "wrong two-factor code"})
+			app.invalidCredentialsResponse(w, r)
+			return
+		}
+	}
+
+	// the password has been checked, so it can be hashed again if the hashing algorithm or its parameters have changed
+	// since the user last set it. A failure only means the old hash is kept until the next login, so it is just logged
+	if user.Password.NeedsRehash() {
+		err = app.rehashPassword(r.Context(), user, input.Password)
+		if err != nil {
+			app.logError(r, err)
+		}
+	}
+
+	// if the password is correct, create a new authentication token for the user
+	token, err := app.newAuthenticationToken(r, user)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	app.recordAudit(r, data.AuditCategoryAuth, "login", input.Email)
+
 	// send the token to the client in a JSON response
 	err = app.writeJSON(w, http.StatusCreated, envelope{"authentication_token": token}, nil)
 	if err != nil {
@@ -71,6 +151,16 @@ func (app *application) createAuthenticationTokenHandler(w http.ResponseWriter,
 	}
 }
 
+// rehashPassword replaces the user's password hash with one made with the configured hashing algorithm and parameters
+func (app *application) rehashPassword(ctx context.Context, user *data.User, plaintext string) error {
+	err := user.Password.HashPassword(plaintext)
+	if err != nil {
+		return err
+	}
+
+	return app.models.Users.UpdatePasswordHash(ctx, user)
+}
+
 // standalone handler for generating and sending an activation token to the user. this can
 // be used to resend the activation token if the user didn't receive it the first time or if it expired.
 func (app *application) createActivationTokenHandler(w http.ResponseWriter, r *http.Request) {
@@ -94,7 +184,7 @@ func (app *application) createActivationTokenHandler(w http.ResponseWriter, r *h
 
 	// try to retrieve the corresponding user record for the email address. if it cant
 	// be found, return am error message to the client
-	user, err := app.models.Users.GetByEmail(input.Email)
+	user, err := app.models.Users.GetByEmail(r.Context(), input.Email)
 	if err != nil {
 		switch {
 		case errors.Is(err, data.ErrRecordNotFound):
@@ -106,6 +196,12 @@ func (app *application) createActivationTokenHandler(w http.ResponseWriter, r *h
 		return
 	}
 
+	// a disabled user can't activate their account, so they aren't sent the token
+	if user.Disabled() {
+		app.accountDisabledResponse(w, r)
+		return
+	}
+
 	// return an error if the user account is already activated
 	if user.Activated {
 		v.AddError("email", "user account is already activated")
@@ -113,26 +209,41 @@ func (app *application) createActivationTokenHandler(w http.ResponseWriter, r *h
 		return
 	}
 
-	// create a new activation token for the user
-	token, err := app.models.Tokens.New(user.ID, 3*24*time.Hour, data.ScopeActivation)
+	// every activation email creates a token, so the tokens created in the last day tell how many emails were sent
+	now := app.clock.Now()
+
+	issued, err := app.models.Tokens.IssuedSince(r.Context(), data.ScopeActivation, user.ID, now.Add(-24*time.Hour))
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 		return
 	}
 
-	// we send the email to the user in the background to avoid blocking the request
-	app.background(func() {
-		data := map[string]interface{}{
-			"activationToken": token.Plaintext,
+	if wait := app.activationResendWait(issued, now); wait > 0 {
+		app.activationThrottledResponse(w, r, wait)
+		return
+	}
+
+	// create a new activation token for the user and queue the email containing it in one transaction, so the token
+	// is never left without an email to deliver it
+	err = app.models.WithTx(r.Context(), func(tx data.Models) error {
+		token, err := tx.Tokens.New(r.Context(), user.ID, activationTokenTTL, data.ScopeActivation)
+		if err != nil {
+			return err
 		}
 
 		// we send the email to email address of the user and not the one provided in the request
 		// this is to avoid leaking the email address of the user to the client in case of an error.
-		err = app.mailer.Send(user.Email, "token_activation.go.tmpl", data)
-		if err != nil {
-			app.logger.PrintError(err, nil)
-		}
+		return app.queueEmail(r.Context(), tx, user.Email, "token_activation.go.tmpl", map[string]interface{}{
+			"activationToken": token.Plaintext,
+		})
 	})
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	// we send the email to the user in the background to avoid blocking the request
+	app.sendQueuedEmails(r)
 
 	// send a 202 Accepted status code and a JSON response containing a success message
 	env := envelope{"message": "an email will be sent to you containing the activation instructions"}
@@ -142,3 +253,90 @@ func (app *application) createActivationTokenHandler(w http.ResponseWriter, r *h
 		app.serverErrorResponse(w, r, err)
 	}
 }
+
+// activationResendWait returns how long the user has to wait before another activation email can be sent, given when
+// the activation tokens of the last day were created, oldest first. Zero means an email can be sent now
+func (app *application) activationResendWait(issued []time.Time, now time.Time) time.Duration {
+	var wait time.Duration
+
+	if len(issued) > 0 {
+		wait = issued[len(issued)-1].Add(app.config.activation.resendCooldown).Sub(now)
+	}
+
+	// once the daily limit is reached, the next email can be sent when the oldest of the last limit emails is a day old
+	if limit := app.config.activation.resendDailyLimit; len(issued) >= limit {
+		wait = max(wait, issued[len(issued)-limit].Add(24*time.Hour).Sub(now))
+	}
+
+	return max(wait, 0)
+}
+
+// deleteExpiredTokens deletes the tokens which have expired, counting how many were deleted in each scope. This is run
+// periodically by the scheduler
+func (app *application) deleteExpiredTokens() error {
+	deleted, err := app.models.Tokens.DeleteExpired(app.clock.Now())
+	if err != nil {
+		return err
+	}
+
+	for scope, count := range deleted {
+		app.instruments.tokens.WithLabelValues(scope).Add(float64(count))
+		app.logger.PrintInfo("expired tokens deleted", "scope", scope, "deleted", count)
+	}
+
+	return nil
+}
+
+// deleteAuthenticationTokenHandler logs the user out by deleting the token the request was authenticated with, or every
+// one of their authentication tokens when all=true is given, which signs them out on all of their devices. A single signed
+// JWT can't be deleted, but all=true revokes every one issued to the user so far
+func (app *application) deleteAuthenticationTokenHandler(w http.ResponseWriter, r *http.Request) {
+	v := validator.New()
+
+	all := app.readBool(r.URL.Query(), "all", v)
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	user := app.contextGetUser(r)
+
+	token := bearerToken(r)
+
+	switch {
+	case all != nil && *all:
+		err := app.models.Tokens.DeleteAllForUser(r.Context(), data.ScopeAuthentication, user.ID)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+
+		err = app.models.Users.RevokeSignedTokens(r.Context(), user.ID)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+	case app.jwt != nil && strings.Count(token, ".") == 2:
+		app.badRequestResponse(w, r, errors.New("a signed authentication token can't be revoked, it expires on its own"))
+		return
+	default:
+		err := app.models.Tokens.Delete(r.Context(), data.ScopeAuthentication, user.ID, token)
+		if err != nil {
+			switch {
+			case errors.Is(err, data.ErrRecordNotFound):
+				app.invalidAuthenticationTokenResponse(w, r)
+			default:
+				app.serverErrorResponse(w, r, err)
+			}
+			return
+		}
+	}
+
+	app.recordAudit(r, data.AuditCategoryAuth, "logout", user.Email)
+	app.recordSecurityEvent(r, data.SecurityEventTokenRevoked, map[string]string{"all": strconv.FormatBool(all != nil && *all)})
+
+	err := app.writeJSON(w, http.StatusOK, envelope{"message": "you have been successfully logged out"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
@@ -0,0 +1,262 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/nytro04/greenlight/internal/data"
+	"github.com/nytro04/greenlight/internal/validator"
+)
+
+// getEnabledTOTP returns the user's TOTP enrollment if they have enabled two-factor authentication, or nil if they haven't
+func (app *application) getEnabledTOTP(userID int64) (*data.TOTP, error) {
+	t, err := app.models.TOTP.Get(userID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			return nil, nil
+		default:
+			return nil, err
+		}
+	}
+
+	if !t.Enabled {
+		return nil, nil
+	}
+
+	return t, nil
+}
+
+// verifySecondFactor checks the code from the user's authenticator app, or one of their recovery codes when that is
+// given instead. A recovery code can only be used once, and using one is recorded as a security event
+func (app *application) verifySecondFactor(r *http.Request, user *data.User, t *data.TOTP, code, recoveryCode string) (bool, error) {
+	if recoveryCode == "" {
+		return app.models.TOTP.Verify(t, code)
+	}
+
+	ok, err := app.models.TOTP.UseRecoveryCode(user.ID, recoveryCode)
+	if err != nil || !ok {
+		return false, err
+	}
+
+	app.recordSecurityEvent(r, data.SecurityEventRecoveryCodeUsed, map[string]string{"email": user.Email})
+
+	return true, nil
+}
+
+// enrollTOTPHandler generates a new TOTP secret for the user. Two-factor authentication isn't enabled until the user
+// has confirmed a code from their authenticator app with confirmTOTPHandler
+func (app *application) enrollTOTPHandler(w http.ResponseWriter, r *http.Request) {
+	user := app.contextGetUser(r)
+
+	enrollment, err := app.models.TOTP.Enroll(user.ID, app.config.totp.issuer, user.Email)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrTOTPEnabled):
+			app.totpAlreadyEnabledResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusCreated, envelope{"totp": enrollment}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// confirmTOTPHandler checks a code generated from the secret the user enrolled with, and enables two-factor
+// authentication if it is valid. The response holds the recovery codes, which are never shown again
+func (app *application) confirmTOTPHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Code string `json:"code"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+
+	if data.ValidateTOTPCode(v, "code", input.Code); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	user := app.contextGetUser(r)
+
+	t, err := app.models.TOTP.Get(user.ID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			v.AddError("code", "two-factor authentication has not been enrolled")
+			app.failedValidationResponse(w, r, v.Errors)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	if t.Enabled {
+		app.totpAlreadyEnabledResponse(w, r)
+		return
+	}
+
+	ok, err := app.models.TOTP.Verify(t, input.Code)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	if !ok {
+		v.AddError("code", "invalid or expired code")
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	codes, err := app.models.TOTP.Enable(user.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	app.recordAudit(r, data.AuditCategoryAuth, "totp_enabled", user.Email)
+	app.recordSecurityEvent(r, data.SecurityEventTOTPEnabled, nil)
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"recovery_codes": codes}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// regenerateRecoveryCodesHandler replaces the user's recovery codes with a new set, after checking a code from their
+// authenticator app. It is used when the old codes have been lost or mostly used up
+func (app *application) regenerateRecoveryCodesHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Code string `json:"code"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+
+	if data.ValidateTOTPCode(v, "code", input.Code); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	user := app.contextGetUser(r)
+
+	t, err := app.getEnabledTOTP(user.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	if t == nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	ok, err := app.models.TOTP.Verify(t, input.Code)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	if !ok {
+		v.AddError("code", "invalid or expired code")
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	codes, err := app.models.TOTP.NewRecoveryCodes(user.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"recovery_codes": codes}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// deleteTOTPHandler turns off two-factor authentication. Once it has been enabled, a code from the authenticator app
+// or a recovery code is needed to turn it off, so a stolen authentication token isn't enough
+func (app *application) deleteTOTPHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Code         string `json:"code"`
+		RecoveryCode string `json:"recovery_code"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	user := app.contextGetUser(r)
+
+	t, err := app.models.TOTP.Get(user.ID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	// an enrollment which was never confirmed can be removed without a code
+	if t.Enabled {
+		v := validator.New()
+
+		v.Check(input.Code != "" || input.RecoveryCode != "", "code", "must be provided")
+		if !v.Valid() {
+			app.failedValidationResponse(w, r, v.Errors)
+			return
+		}
+
+		ok, err := app.verifySecondFactor(r, user, t, input.Code, input.RecoveryCode)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+
+		if !ok {
+			v.AddError("code", "invalid or expired code")
+			app.failedValidationResponse(w, r, v.Errors)
+			return
+		}
+	}
+
+	err = app.models.TOTP.Delete(user.ID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	if t.Enabled {
+		app.recordAudit(r, data.AuditCategoryAuth, "totp_disabled", user.Email)
+		app.recordSecurityEvent(r, data.SecurityEventTOTPDisabled, nil)
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"message": "two-factor authentication successfully disabled"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
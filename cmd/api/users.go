@@ -3,7 +3,6 @@ package main
 import (
 	"errors"
 	"net/http"
-	"time"
 
 	"github.com/nytro04/greenlight/internal/data"
 	"github.com/nytro04/greenlight/internal/validator"
@@ -47,8 +46,32 @@ func (app *application) registerUserHandler(w http.ResponseWriter, r *http.Reque
 		return
 	}
 
-	// insert the user record into the database, handling any duplicate email errors
-	err = app.models.Users.Insert(user)
+	// insert the user record into the database along with their permissions, activation token and welcome email in one
+	// transaction, so a failure partway through can't leave behind a user who has no permissions or can never be
+	// activated. The activation token will be valid for 3 days and will have the scope activation
+	err = app.models.WithTx(r.Context(), func(tx data.Models) error {
+		err := tx.Users.Insert(r.Context(), user)
+		if err != nil {
+			return err
+		}
+
+		err = tx.Permissions.AddForUser(r.Context(), user.ID, "movies:read")
+		if err != nil {
+			return err
+		}
+
+		token, err := tx.Tokens.New(r.Context(), user.ID, activationTokenTTL, data.ScopeActivation)
+		if err != nil {
+			return err
+		}
+
+		// the welcome email is queued in the same transaction, so a crash after the user is committed can't lose
+		// their activation token
+		return app.queueEmail(r.Context(), tx, user.Email, "user_welcome.go.tmpl", map[string]interface{}{
+			"activationToken": token.Plaintext,
+			"userID":          user.ID,
+		})
+	})
 	if err != nil {
 		switch {
 		case errors.Is(err, data.ErrDuplicateEmail):
@@ -60,36 +83,8 @@ func (app *application) registerUserHandler(w http.ResponseWriter, r *http.Reque
 		return
 	}
 
-	err = app.models.Permissions.AddForUser(user.ID, "movies:read")
-	if err != nil {
-		app.serverErrorResponse(w, r, err)
-		return
-	}
-
-	// Generate a new activation token for the user after successfully inserting the user data into the database
-	// The token will be valid for 3 days and will have the scope activation
-	token, err := app.models.Tokens.New(user.ID, 3*24*time.Hour, data.ScopeActivation)
-	if err != nil {
-		app.serverErrorResponse(w, r, err)
-		return
-	}
-
-	// Use the background helper to execute an anonymous function that sends a welcome email to the user in the background
-	app.background(func() {
-
-		// create a map containing the plaintext activation token and the user ID
-		data := map[string]interface{}{
-			"activationToken": token.Plaintext,
-			"userID":          user.ID,
-		}
-
-		// send the welcome email, passing the map as dynamic data
-		err = app.mailer.Send(user.Email, "user_welcome.go.tmpl", data)
-		if err != nil {
-			app.logger.PrintError(err, nil)
-			return
-		}
-	})
+	// start sending the welcome email in the background, it is retried by the email_deliveries job if it fails
+	app.sendQueuedEmails(r)
 
 	// send a JSON response containing the user data
 	err = app.writeJSON(w, http.StatusAccepted, envelope{"user": user}, nil)
@@ -120,7 +115,7 @@ func (app *application) activateUserHandler(w http.ResponseWriter, r *http.Reque
 	}
 
 	// retrieve the details of the user associated with the activation token and send an error response if the token is not found
-	user, err := app.models.Users.GetTokenUser(data.ScopeActivation, input.TokenPlaintext)
+	user, err := app.models.Users.GetTokenUser(r.Context(), data.ScopeActivation, input.TokenPlaintext)
 	if err != nil {
 		switch {
 		case errors.Is(err, data.ErrRecordNotFound):
@@ -135,8 +130,16 @@ func (app *application) activateUserHandler(w http.ResponseWriter, r *http.Reque
 	// update the user's Activated status to true
 	user.Activated = true
 
-	// save the updated user(using the update method) record in the database, handling any edit conflict errors
-	err = app.models.Users.Update(user)
+	// save the updated user(using the update method) record in the database and delete all their activation tokens in
+	// one transaction, so the token can't be used again once the account is activated, handling any edit conflict errors
+	err = app.models.WithTx(r.Context(), func(tx data.Models) error {
+		err := tx.Users.Update(r.Context(), user)
+		if err != nil {
+			return err
+		}
+
+		return tx.Tokens.DeleteAllForUser(r.Context(), data.ScopeActivation, user.ID)
+	})
 	if err != nil {
 		switch {
 		case errors.Is(err, data.ErrEditConflict):
@@ -147,12 +150,7 @@ func (app *application) activateUserHandler(w http.ResponseWriter, r *http.Reque
 		return
 	}
 
-	// if everything was successful, delete all activation tokens for the user
-	err = app.models.Tokens.DeleteAllForUser(data.ScopeActivation, user.ID)
-	if err != nil {
-		app.serverErrorResponse(w, r, err)
-		return
-	}
+	app.recordAudit(r, data.AuditCategoryAuth, "account_activated", user.Email)
 
 	// send a JSON response containing the updated user details
 	err = app.writeJSON(w, http.StatusOK, envelope{"user": user}, nil)
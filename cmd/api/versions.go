@@ -0,0 +1,153 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/nytro04/greenlight/internal/data"
+)
+
+// apiVersion is a version of the API, served under its own path prefix such as /v2. The versions share the handlers,
+// which shape their responses for the version the request was made to
+type apiVersion string
+
+const (
+	apiV1 apiVersion = "v1"
+	apiV2 apiVersion = "v2"
+)
+
+// routeGroup registers the routes of one version of the API, with their paths relative to the version prefix. Every
+// handler is told the version of its request, and the responses of a deprecated version carry the deprecation headers
+type routeGroup struct {
+	app     *application
+	router  *routeRecorder
+	version apiVersion
+}
+
+// versionGroup returns the group of routes served under /<version>
+func (app *application) versionGroup(router *routeRecorder, version apiVersion) *routeGroup {
+	return &routeGroup{app: app, router: router, version: version}
+}
+
+func (g *routeGroup) path(path string) string {
+	return "/" + string(g.version) + path
+}
+
+func (g *routeGroup) HandlerFunc(method, path string, handler http.HandlerFunc) {
+	g.router.Handler(method, g.path(path), g.app.withAPIVersion(g.version, handler))
+}
+
+func (g *routeGroup) Handler(method, path string, handler http.Handler) {
+	g.router.Handler(method, g.path(path), g.app.withAPIVersion(g.version, handler))
+}
+
+func (g *routeGroup) StaticHandlerFunc(method, path string, handler http.HandlerFunc) {
+	g.router.StaticHandlerFunc(method, g.path(path), g.app.withAPIVersion(g.version, handler).ServeHTTP)
+}
+
+// withAPIVersion adds the version to the context of the request. The responses of v1 carry a Deprecation header once
+// a deprecation date has been configured, and a Sunset header with the date it will be removed, along with a link to
+// its successor, so the clients can find out they need to move to v2 from the responses themselves
+func (app *application) withAPIVersion(version apiVersion, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if version == apiV1 && !app.config.versions.v1Deprecation.IsZero() {
+			w.Header().Set("Deprecation", fmt.Sprintf("@%d", app.config.versions.v1Deprecation.Unix()))
+			w.Header().Add("Link", `</v2/movies>; rel="successor-version"`)
+
+			if !app.config.versions.v1Sunset.IsZero() {
+				w.Header().Set("Sunset", app.config.versions.v1Sunset.UTC().Format(http.TimeFormat))
+			}
+		}
+
+		next.ServeHTTP(w, app.contextSetAPIVersion(r, version))
+	})
+}
+
+// movieV2 is the representation of a movie in v2, in which every key is snake_case and the runtime is an ISO 8601
+// duration such as "PT107M"
+type movieV2 struct {
+	ID            int64             `json:"id"`
+	CreatedAt     time.Time         `json:"created_at"`
+	UpdatedAt     time.Time         `json:"updated_at"`
+	Title         string            `json:"title"`
+	Year          int32             `json:"year"`
+	Runtime       isoRuntime        `json:"runtime"`
+	Genres        []string          `json:"genres"`
+	Language      string            `json:"language"`
+	Country       string            `json:"country"`
+	Version       int32             `json:"version"`
+	AverageRating float64           `json:"average_rating"`
+	ReviewCount   int               `json:"review_count"`
+	Credits       []*data.Credit    `json:"credits,omitempty"`
+	Tags          []string          `json:"tags,omitempty"`
+	Series        *data.SeriesEntry `json:"series,omitempty"`
+	Headline      string            `json:"headline,omitempty"`
+	PosterURL     string            `json:"poster_url,omitempty"`
+	Favorited     *bool             `json:"favorited,omitempty"`
+}
+
+// isoRuntime is a runtime written as an ISO 8601 duration in minutes
+type isoRuntime data.Runtime
+
+func (r isoRuntime) MarshalJSON() ([]byte, error) {
+	return []byte(fmt.Sprintf(`"PT%dM"`, r)), nil
+}
+
+// movieV2FieldSafeList holds the movie fields a v2 client can ask for with the fields query string parameter
+var movieV2FieldSafeList = []string{"id", "created_at", "updated_at", "title", "year", "runtime", "genres", "version", "average_rating", "review_count", "credits", "headline", "poster_url", "favorited", "tags", "series", "language", "country"}
+
+func newMovieV2(movie *data.Movie) *movieV2 {
+	return &movieV2{
+		ID:            movie.ID,
+		CreatedAt:     movie.CreatedAt,
+		UpdatedAt:     movie.UpdatedAt,
+		Title:         movie.Title,
+		Year:          movie.Year,
+		Runtime:       isoRuntime(movie.Runtime),
+		Genres:        movie.Genres,
+		Language:      movie.Language,
+		Country:       movie.Country,
+		Version:       movie.Version,
+		AverageRating: movie.AverageRating,
+		ReviewCount:   movie.ReviewCount,
+		Credits:       movie.Credits,
+		Tags:          movie.Tags,
+		Series:        movie.Series,
+		Headline:      movie.Headline,
+		PosterURL:     movie.PosterURL,
+		Favorited:     movie.Favorited,
+	}
+}
+
+// movieFields returns the movie fields the version of the request lets a client ask for
+func (app *application) movieFields(r *http.Request) []string {
+	if app.contextGetAPIVersion(r) == apiV2 {
+		return movieV2FieldSafeList
+	}
+
+	return movieFieldSafeList
+}
+
+// presentMovie returns the representation of the movie in the version of the request
+func (app *application) presentMovie(r *http.Request, movie *data.Movie) interface{} {
+	if app.contextGetAPIVersion(r) == apiV2 {
+		return newMovieV2(movie)
+	}
+
+	return movie
+}
+
+// presentMovies returns the representations of the movies in the version of the request
+func (app *application) presentMovies(r *http.Request, movies []*data.Movie) interface{} {
+	if app.contextGetAPIVersion(r) != apiV2 {
+		return movies
+	}
+
+	presented := make([]*movieV2, len(movies))
+	for i, movie := range movies {
+		presented[i] = newMovieV2(movie)
+	}
+
+	return presented
+}
@@ -0,0 +1,169 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/nytro04/greenlight/internal/data"
+	"github.com/nytro04/greenlight/internal/validator"
+)
+
+// viewCounter buffers the views of the movie details in memory, so a GET doesn't cost a write. The counts are added to
+// the database by the movie_view_flush job, and once more in the graceful shutdown
+type viewCounter struct {
+	mu     sync.Mutex
+	counts map[int64]int64
+}
+
+func newViewCounter() *viewCounter {
+	return &viewCounter{counts: make(map[int64]int64)}
+}
+
+// add counts a view of the movie
+func (vc *viewCounter) add(movieID int64) {
+	vc.mu.Lock()
+	defer vc.mu.Unlock()
+
+	vc.counts[movieID]++
+}
+
+// take returns the counts buffered so far and starts a new buffer
+func (vc *viewCounter) take() map[int64]int64 {
+	vc.mu.Lock()
+	defer vc.mu.Unlock()
+
+	counts := vc.counts
+	vc.counts = make(map[int64]int64)
+
+	return counts
+}
+
+// restore puts counts which couldn't be written back in the buffer, so they are written with the next flush
+func (vc *viewCounter) restore(counts map[int64]int64) {
+	vc.mu.Lock()
+	defer vc.mu.Unlock()
+
+	for id, count := range counts {
+		vc.counts[id] += count
+	}
+}
+
+// recordMovieView counts a view of the movie's details. Only GET requests are counted, not the HEAD requests made to
+// check the movie's headers
+func (app *application) recordMovieView(r *http.Request, movieID int64) {
+	if app.views != nil && r.Method == http.MethodGet {
+		app.views.add(movieID)
+	}
+}
+
+// flushMovieViews adds the buffered view counts to the database. If they can't be written they are kept for the next
+// run. This is run periodically by the scheduler
+func (app *application) flushMovieViews() error {
+	if app.views == nil {
+		return nil
+	}
+
+	counts := app.views.take()
+
+	err := app.models.Views.Add(context.Background(), counts, app.clock.Now())
+	if err != nil {
+		app.views.restore(counts)
+		return err
+	}
+
+	return nil
+}
+
+// deleteOldMovieViews deletes the view counts older than the longest trending window. This is run periodically by the
+// scheduler
+func (app *application) deleteOldMovieViews() error {
+	deleted, err := app.models.Views.DeleteBefore(app.clock.Now().Add(-app.config.views.retention))
+	if err != nil {
+		return err
+	}
+
+	if deleted > 0 {
+		app.logger.PrintInfo("old movie views deleted", "deleted", deleted)
+	}
+
+	return nil
+}
+
+// parseViewWindow parses a trending window such as 7d or 12h, a whole number of days or hours
+func parseViewWindow(s string) (time.Duration, error) {
+	unit := time.Hour
+	number, ok := strings.CutSuffix(s, "h")
+	if !ok {
+		number, ok = strings.CutSuffix(s, "d")
+		unit = 24 * time.Hour
+	}
+
+	n, err := strconv.Atoi(number)
+	if !ok || err != nil || n < 1 {
+		return 0, fmt.Errorf("invalid window %q", s)
+	}
+
+	return time.Duration(n) * unit, nil
+}
+
+// listTrendingMoviesHandler lists the movies whose details were viewed the most in the window, 7 days by default. The
+// views of the last few seconds are still buffered, so they count once they have been flushed
+func (app *application) listTrendingMoviesHandler(w http.ResponseWriter, r *http.Request) {
+	v := validator.New()
+
+	qs := r.URL.Query()
+
+	windowParam := app.readString(qs, "window", "7d")
+
+	window, err := parseViewWindow(windowParam)
+	v.Check(err == nil, "window", "must be a number of hours or days, such as 24h or 7d")
+	v.Check(err != nil || window <= app.config.views.retention, "window", fmt.Sprintf("must not be longer than %s", formatViewWindow(app.config.views.retention)))
+
+	filters := data.Filters{
+		Page:         app.readInt(qs, "page", 1, v),
+		PageSize:     app.readInt(qs, "page_size", 20, v),
+		Sort:         "-views",
+		SortSafeList: []string{"-views"},
+	}
+
+	if data.ValidateFilters(v, filters); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	movies, metadata, err := app.models.Views.Trending(r.Context(), app.clock.Now().Add(-window), filters)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	flagged := make([]*data.Movie, len(movies))
+	for i, movie := range movies {
+		flagged[i] = movie.Movie
+	}
+
+	_, err = app.markFavorites(r, flagged...)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeResponseWithETag(w, r, http.StatusOK, envelope{"movies": movies, "metadata": metadata, "window": windowParam}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// formatViewWindow writes a retention period in the form of a trending window
+func formatViewWindow(d time.Duration) string {
+	if d%(24*time.Hour) == 0 {
+		return fmt.Sprintf("%dd", d/(24*time.Hour))
+	}
+
+	return fmt.Sprintf("%dh", d/time.Hour)
+}
@@ -0,0 +1,244 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/nytro04/greenlight/internal/data"
+	"github.com/nytro04/greenlight/internal/validator"
+	"github.com/nytro04/greenlight/internal/webhook"
+)
+
+// webhookClaimSize is the number of due deliveries the dispatcher claims at a time
+const webhookClaimSize = 20
+
+// webhookPayload is the JSON body POSTed to the webhooks, data holds the movie the event is about
+type webhookPayload struct {
+	Event      string    `json:"event"`
+	OccurredAt time.Time `json:"occurred_at"`
+	Data       any       `json:"data"`
+}
+
+// emitWebhookEvent is the movie hook which queues a delivery of the change for each of its movies to every webhook
+// subscribed to the event, then starts sending them in the background. The deliveries which can't be sent straight
+// away are retried by the webhook_deliveries job. Failing to queue the event is logged but doesn't fail the write
+func (app *application) emitWebhookEvent(ctx context.Context, change data.MovieChange) {
+	now := app.clock.Now()
+
+	payloads := make([][]byte, len(change.Movies))
+	for i, movie := range change.Movies {
+		payload, err := json.Marshal(webhookPayload{Event: change.Event, OccurredAt: now, Data: envelope{"movie": movie}})
+		if err != nil {
+			app.logger.PrintError(err, "message", "Error encoding webhook payload", "event", change.Event)
+			return
+		}
+		payloads[i] = payload
+	}
+
+	queued, err := app.models.Webhooks.Enqueue(change.Event, payloads...)
+	if err != nil {
+		app.logger.PrintError(err, "message", "Error queueing webhook deliveries", "event", change.Event)
+		return
+	}
+
+	if queued == 0 {
+		return
+	}
+
+	err = app.jobs.Trigger("webhook_deliveries")
+	if err != nil {
+		app.logger.PrintError(err, "message", "Error triggering webhook deliveries", "event", change.Event)
+	}
+}
+
+// deliverWebhooks sends every pending delivery which is due, until there are none left. Each failed attempt is
+// retried with an exponential backoff until the delivery runs out of attempts. This is run after each event and
+// periodically by the scheduler, which picks up the retries and anything left behind by a restart
+func (app *application) deliverWebhooks() error {
+	// a claimed delivery isn't picked up again until the lease runs out, which covers the time it takes to send it
+	lease := 2 * app.config.webhooks.timeout
+
+	for {
+		deliveries, err := app.models.Webhooks.ClaimDue(webhookClaimSize, lease)
+		if err != nil {
+			return err
+		}
+
+		if len(deliveries) == 0 {
+			return nil
+		}
+
+		for _, delivery := range deliveries {
+			err := app.sendWebhook(delivery)
+			if err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// sendWebhook makes a single attempt at a delivery and records the outcome in the delivery log. The returned error
+// is only for failing to record the outcome, a receiver which fails is recorded against the delivery
+func (app *application) sendWebhook(delivery *data.WebhookDelivery) error {
+	ctx, cancel := context.WithTimeout(context.Background(), app.config.webhooks.timeout)
+	defer cancel()
+
+	status, err := app.webhooks.Send(ctx, webhook.Delivery{
+		ID:      delivery.ID,
+		Event:   delivery.Event,
+		URL:     delivery.URL,
+		Secret:  delivery.Secret,
+		Payload: delivery.Payload,
+	}, app.clock.Now())
+	if err == nil {
+		return app.models.Webhooks.MarkDelivered(delivery.ID, status)
+	}
+
+	var responseStatus *int
+	if status != 0 {
+		responseStatus = &status
+	}
+
+	// wait 30s, 1m, 2m, 4m, ... between the attempts, and give up after the last one
+	var nextAttemptAt *time.Time
+	if delivery.Attempts < app.config.webhooks.maxAttempts {
+		next := app.clock.Now().Add(app.config.webhooks.retryBackoff << (delivery.Attempts - 1))
+		nextAttemptAt = &next
+	}
+
+	return app.models.Webhooks.MarkFailed(delivery.ID, responseStatus, err.Error(), nextAttemptAt)
+}
+
+// createWebhookHandler registers a URL to be sent the given events. The secret the payloads are signed with is only
+// included in this response
+func (app *application) createWebhookHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		URL    string   `json:"url"`
+		Events []string `json:"events"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	hook := &data.Webhook{
+		URL:    input.URL,
+		Events: input.Events,
+	}
+
+	v := validator.New()
+
+	if data.ValidateWebhook(v, hook); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = app.models.Webhooks.Insert(hook)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	app.recordAudit(r, data.AuditCategoryContent, "webhook_created", fmt.Sprintf("webhook:%d", hook.ID))
+
+	err = app.writeJSON(w, http.StatusCreated, envelope{"webhook": hook}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) listWebhooksHandler(w http.ResponseWriter, r *http.Request) {
+	hooks, err := app.models.Webhooks.GetAll()
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"webhooks": hooks}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// deleteWebhookHandler removes a webhook, its pending deliveries are dropped along with its delivery log
+func (app *application) deleteWebhookHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	err = app.models.Webhooks.Delete(id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	app.recordAudit(r, data.AuditCategoryContent, "webhook_deleted", fmt.Sprintf("webhook:%d", id))
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"message": "webhook successfully deleted"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// listWebhookDeliveriesHandler returns the delivery log of a webhook, newest first, optionally filtered by status
+func (app *application) listWebhookDeliveriesHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	var input struct {
+		Status string
+		data.Filters
+	}
+
+	v := validator.New()
+
+	qs := r.URL.Query()
+
+	input.Status = app.readString(qs, "status", "")
+
+	input.Filters.Page = app.readInt(qs, "page", 1, v)
+	input.Filters.PageSize = app.readInt(qs, "page_size", 20, v)
+	input.Filters.Sort = app.readString(qs, "sort", "-created_at")
+	input.Filters.SortSafeList = []string{"id", "created_at", "-id", "-created_at"}
+
+	if input.Status != "" {
+		v.Check(validator.In(input.Status, data.WebhookDeliveryPending, data.WebhookDeliveryDelivered, data.WebhookDeliveryFailed), "status", "must be pending, delivered or failed")
+	}
+
+	if data.ValidateFilters(v, input.Filters); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	deliveries, metadata, err := app.models.Webhooks.GetDeliveries(id, input.Status, input.Filters)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrUnsafeSort):
+			app.invalidSortResponse(w, r, input.Filters)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"deliveries": deliveries, "metadata": metadata}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
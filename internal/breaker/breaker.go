@@ -0,0 +1,207 @@
+package breaker
+
+import (
+	"context"
+	"database/sql/driver"
+	"errors"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/nytro04/greenlight/internal/clock"
+)
+
+// ErrOpen is returned instead of running a call while the breaker is open
+var ErrOpen = errors.New("circuit breaker is open")
+
+// State is the state of a breaker
+type State int
+
+const (
+	// Closed lets every call through, counting the consecutive failures
+	Closed State = iota
+	// HalfOpen lets a single probe through to find out whether the service has recovered
+	HalfOpen
+	// Open refuses every call until the cooldown has passed
+	Open
+)
+
+func (s State) String() string {
+	switch s {
+	case Closed:
+		return "closed"
+	case HalfOpen:
+		return "half_open"
+	default:
+		return "open"
+	}
+}
+
+// Breaker is a circuit breaker. After threshold consecutive failures it opens and refuses calls with ErrOpen, so they
+// fail straight away rather than each waiting for its own timeout. Once the cooldown has passed it lets a single probe
+// through, closing again if the probe succeeds and staying open for another cooldown if it fails. It is safe for
+// concurrent use
+type Breaker struct {
+	threshold int
+	cooldown  time.Duration
+	clock     clock.Clock
+
+	mu          sync.Mutex
+	state       State
+	failures    int       // the consecutive failures while closed
+	openedAt    time.Time // when the breaker last opened
+	probeActive bool      // whether a probe has been let through and not reported yet
+	probeStart  time.Time
+	trips       uint64
+	rejections  uint64
+}
+
+// New returns a closed breaker which opens after threshold consecutive failures and probes after the cooldown
+func New(threshold int, cooldown time.Duration, clk clock.Clock) *Breaker {
+	return &Breaker{threshold: threshold, cooldown: cooldown, clock: clk}
+}
+
+// Allow reports whether a call may go ahead, returning ErrOpen if it may not. A call which is allowed must be followed
+// by Success or Failure, except that a probe which is never reported is given up on after a cooldown
+func (b *Breaker) Allow() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	now := b.clock.Now()
+
+	switch b.state {
+	case Closed:
+		return nil
+	case Open:
+		if now.Sub(b.openedAt) < b.cooldown {
+			b.rejections++
+			return ErrOpen
+		}
+		b.state = HalfOpen
+	}
+
+	if b.probeActive && now.Sub(b.probeStart) < b.cooldown {
+		b.rejections++
+		return ErrOpen
+	}
+
+	b.probeActive = true
+	b.probeStart = now
+
+	return nil
+}
+
+// Success records a call which succeeded, closing the breaker if it was a probe. The calls let through before the
+// breaker opened are ignored if they finish after it has
+func (b *Breaker) Success() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.state == Open {
+		return
+	}
+
+	b.state = Closed
+	b.failures = 0
+	b.probeActive = false
+}
+
+// Failure records a call which failed, opening the breaker if it was a probe or the threshold has been reached
+func (b *Breaker) Failure() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.state == Open {
+		return
+	}
+
+	b.failures++
+
+	if b.state == HalfOpen || b.failures >= b.threshold {
+		if b.state == Closed {
+			b.trips++
+		}
+
+		b.state = Open
+		b.openedAt = b.clock.Now()
+		b.probeActive = false
+	}
+}
+
+// State returns the state of the breaker. An open breaker whose cooldown has passed is reported as open until the
+// next call probes the service
+func (b *Breaker) State() State {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	return b.state
+}
+
+// RetryAfter returns how long until the breaker will next let a probe through, at least a second, for the Retry-After
+// header of the responses to the calls it refused
+func (b *Breaker) RetryAfter() time.Duration {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	wait := b.cooldown - b.clock.Since(b.openedAt)
+	if b.state == HalfOpen {
+		wait = b.cooldown - b.clock.Since(b.probeStart)
+	}
+
+	return max(wait, time.Second)
+}
+
+// Trips returns the number of times the breaker has opened after being closed
+func (b *Breaker) Trips() uint64 {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	return b.trips
+}
+
+// Rejections returns the number of calls the breaker has refused
+func (b *Breaker) Rejections() uint64 {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	return b.rejections
+}
+
+// WrapConnector returns a connector guarded by the breaker. Every connection the pool hands out goes through Connect,
+// so while the breaker is open the queries fail straight away with ErrOpen. Failing to connect counts as a failure,
+// except when the context was cancelled by the caller going away, which says nothing about the database
+func WrapConnector(connector driver.Connector, b *Breaker) driver.Connector {
+	return &breakerConnector{Connector: connector, breaker: b}
+}
+
+type breakerConnector struct {
+	driver.Connector
+	breaker *Breaker
+}
+
+func (c *breakerConnector) Connect(ctx context.Context) (driver.Conn, error) {
+	err := c.breaker.Allow()
+	if err != nil {
+		return nil, err
+	}
+
+	conn, err := c.Connector.Connect(ctx)
+	switch {
+	case err == nil:
+		c.breaker.Success()
+	case errors.Is(err, context.Canceled):
+	default:
+		c.breaker.Failure()
+	}
+
+	return conn, err
+}
+
+// Close closes the wrapped connector if it holds anything open
+func (c *breakerConnector) Close() error {
+	if closer, ok := c.Connector.(io.Closer); ok {
+		return closer.Close()
+	}
+
+	return nil
+}
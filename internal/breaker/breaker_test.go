@@ -0,0 +1,65 @@
+package breaker
+
+import (
+	"testing"
+	"time"
+
+	"github.com/nytro04/greenlight/internal/clock"
+)
+
+// TestBreaker walks a breaker through opening after the threshold, refusing calls during the cooldown, failing a
+// probe, and closing after a probe succeeds
+func TestBreaker(t *testing.T) {
+	clk := clock.NewMock(time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC))
+	b := New(3, 10*time.Second, clk)
+
+	for i := 0; i < 3; i++ {
+		if err := b.Allow(); err != nil {
+			t.Fatalf("call %d refused while closed: %v", i, err)
+		}
+		b.Failure()
+	}
+
+	if b.State() != Open {
+		t.Fatalf("got state %s after 3 failures, want open", b.State())
+	}
+
+	if err := b.Allow(); err != ErrOpen {
+		t.Fatalf("got %v while open, want ErrOpen", err)
+	}
+
+	if got := b.RetryAfter(); got != 10*time.Second {
+		t.Errorf("got Retry-After %s, want 10s", got)
+	}
+
+	clk.Advance(10 * time.Second)
+
+	if err := b.Allow(); err != nil {
+		t.Fatalf("probe refused after the cooldown: %v", err)
+	}
+
+	if err := b.Allow(); err != ErrOpen {
+		t.Fatalf("got %v for a second call during the probe, want ErrOpen", err)
+	}
+
+	b.Failure()
+
+	if b.State() != Open {
+		t.Fatalf("got state %s after a failed probe, want open", b.State())
+	}
+
+	clk.Advance(10 * time.Second)
+
+	if err := b.Allow(); err != nil {
+		t.Fatalf("probe refused after the second cooldown: %v", err)
+	}
+	b.Success()
+
+	if b.State() != Closed {
+		t.Fatalf("got state %s after a successful probe, want closed", b.State())
+	}
+
+	if b.Trips() != 1 || b.Rejections() != 2 {
+		t.Errorf("got %d trips and %d rejections, want 1 and 2", b.Trips(), b.Rejections())
+	}
+}
@@ -0,0 +1,48 @@
+package cache
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/nytro04/greenlight/internal/clock"
+)
+
+// ErrMiss is returned by Get when there is no entry for the key, or it has expired
+var ErrMiss = errors.New("cache miss")
+
+// ErrUnknownBackend is returned by New when the requested backend is not supported
+var ErrUnknownBackend = errors.New("unknown cache backend")
+
+// Cache is implemented by the backends which can keep values for a while, so a hot record can be read without going
+// to the database every time. The values are opaque bytes, the callers encode and decode them
+type Cache interface {
+	Get(ctx context.Context, key string) ([]byte, error)
+	Set(ctx context.Context, key string, value []byte, ttl time.Duration) error
+	Delete(ctx context.Context, keys ...string) error
+}
+
+// Config holds the settings for all of the backends, only the ones for the selected backend are used
+type Config struct {
+	Backend string        // none, memory or redis
+	TTL     time.Duration // how long an entry is kept before it is read from the database again
+
+	MaxEntries int // the most entries the memory backend keeps, the least recently used are evicted first
+
+	RedisURL string // address of the Redis server, e.g. redis://localhost:6379/0
+}
+
+// New returns the Cache for the backend in the config, or nil when caching is turned off
+func New(cfg Config, clk clock.Clock) (Cache, error) {
+	switch cfg.Backend {
+	case "", "none":
+		return nil, nil
+	case "memory":
+		return NewMemory(cfg.MaxEntries, clk), nil
+	case "redis":
+		return NewRedis(cfg.RedisURL)
+	default:
+		return nil, fmt.Errorf("%w: %q", ErrUnknownBackend, cfg.Backend)
+	}
+}
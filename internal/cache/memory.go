@@ -0,0 +1,106 @@
+package cache
+
+import (
+	"container/list"
+	"context"
+	"sync"
+	"time"
+
+	"github.com/nytro04/greenlight/internal/clock"
+)
+
+// Memory is an in-process LRU cache. Each instance of the API has its own, so after a change on one instance the
+// others keep serving their copy until it expires; Redis is shared by every instance. It is safe for concurrent use
+type Memory struct {
+	maxEntries int
+	clock      clock.Clock
+
+	mu      sync.Mutex
+	entries map[string]*list.Element
+	lru     *list.List // the most recently used entry at the front
+}
+
+type memoryEntry struct {
+	key       string
+	value     []byte
+	expiresAt time.Time
+}
+
+// NewMemory returns an empty Memory cache which keeps at most maxEntries entries
+func NewMemory(maxEntries int, clk clock.Clock) *Memory {
+	return &Memory{
+		maxEntries: maxEntries,
+		clock:      clk,
+		entries:    make(map[string]*list.Element),
+		lru:        list.New(),
+	}
+}
+
+func (m *Memory) Get(ctx context.Context, key string) ([]byte, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	element, ok := m.entries[key]
+	if !ok {
+		return nil, ErrMiss
+	}
+
+	entry := element.Value.(*memoryEntry)
+
+	if !m.clock.Now().Before(entry.expiresAt) {
+		m.remove(element)
+		return nil, ErrMiss
+	}
+
+	m.lru.MoveToFront(element)
+
+	return entry.value, nil
+}
+
+func (m *Memory) Set(ctx context.Context, key string, value []byte, ttl time.Duration) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	expiresAt := m.clock.Now().Add(ttl)
+
+	if element, ok := m.entries[key]; ok {
+		entry := element.Value.(*memoryEntry)
+		entry.value, entry.expiresAt = value, expiresAt
+		m.lru.MoveToFront(element)
+		return nil
+	}
+
+	m.entries[key] = m.lru.PushFront(&memoryEntry{key: key, value: value, expiresAt: expiresAt})
+
+	for m.maxEntries > 0 && m.lru.Len() > m.maxEntries {
+		m.remove(m.lru.Back())
+	}
+
+	return nil
+}
+
+func (m *Memory) Delete(ctx context.Context, keys ...string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, key := range keys {
+		if element, ok := m.entries[key]; ok {
+			m.remove(element)
+		}
+	}
+
+	return nil
+}
+
+// Len returns the number of entries in the cache, including the expired ones which haven't been evicted yet
+func (m *Memory) Len() int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return m.lru.Len()
+}
+
+func (m *Memory) remove(element *list.Element) {
+	m.lru.Remove(element)
+	delete(m.entries, element.Value.(*memoryEntry).key)
+}
@@ -0,0 +1,48 @@
+package cache
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/nytro04/greenlight/internal/clock"
+)
+
+// TestMemoryExpiryAndEviction checks an entry is missed once its TTL has passed, and the least recently used entry is
+// evicted when the cache is full
+func TestMemoryExpiryAndEviction(t *testing.T) {
+	ctx := context.Background()
+	clk := clock.NewMock(time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC))
+	m := NewMemory(2, clk)
+
+	m.Set(ctx, "a", []byte("1"), time.Minute)
+	m.Set(ctx, "b", []byte("2"), time.Hour)
+
+	// reading a makes b the least recently used entry
+	if value, err := m.Get(ctx, "a"); err != nil || string(value) != "1" {
+		t.Fatalf("got %q, %v, want 1", value, err)
+	}
+
+	m.Set(ctx, "c", []byte("3"), time.Hour)
+
+	if _, err := m.Get(ctx, "b"); !errors.Is(err, ErrMiss) {
+		t.Errorf("got %v for the evicted entry, want ErrMiss", err)
+	}
+
+	clk.Advance(time.Minute)
+
+	if _, err := m.Get(ctx, "a"); !errors.Is(err, ErrMiss) {
+		t.Errorf("got %v for the expired entry, want ErrMiss", err)
+	}
+
+	if value, err := m.Get(ctx, "c"); err != nil || string(value) != "3" {
+		t.Errorf("got %q, %v, want 3", value, err)
+	}
+
+	m.Delete(ctx, "c")
+
+	if m.Len() != 0 {
+		t.Errorf("got %d entries, want 0", m.Len())
+	}
+}
@@ -0,0 +1,47 @@
+package cache
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// Redis keeps the entries in a Redis server, which is shared by every instance of the API so a change made through
+// one of them is seen by all of them
+type Redis struct {
+	client *redis.Client
+}
+
+// NewRedis returns a Redis cache for the server at the URL, e.g. redis://:password@localhost:6379/0. The connection
+// is made on the first command, so an unreachable server shows up as errors from the cache rather than at startup
+func NewRedis(url string) (*Redis, error) {
+	opts, err := redis.ParseURL(url)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Redis{client: redis.NewClient(opts)}, nil
+}
+
+func (c *Redis) Get(ctx context.Context, key string) ([]byte, error) {
+	value, err := c.client.Get(ctx, key).Bytes()
+	if errors.Is(err, redis.Nil) {
+		return nil, ErrMiss
+	}
+
+	return value, err
+}
+
+func (c *Redis) Set(ctx context.Context, key string, value []byte, ttl time.Duration) error {
+	return c.client.Set(ctx, key, value, ttl).Err()
+}
+
+func (c *Redis) Delete(ctx context.Context, keys ...string) error {
+	if len(keys) == 0 {
+		return nil
+	}
+
+	return c.client.Del(ctx, keys...).Err()
+}
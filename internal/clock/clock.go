@@ -0,0 +1,168 @@
+package clock
+
+import (
+	"sync"
+	"time"
+)
+
+// Clock is implemented by the types which can tell the time. Everything in the application which depends on the
+// current time (token expiry, rate limiting, scheduled jobs...) takes a Clock rather than calling the time package
+// directly, so that tests can swap in a Mock clock and move time forward deterministically instead of sleeping
+type Clock interface {
+	Now() time.Time
+	Since(t time.Time) time.Duration
+	After(d time.Duration) <-chan time.Time
+	NewTicker(d time.Duration) Ticker
+}
+
+// Ticker is the subset of time.Ticker used by the application, made an interface so the Mock clock can provide its own
+type Ticker interface {
+	C() <-chan time.Time
+	Stop()
+}
+
+// New returns a Clock backed by the real system time
+func New() Clock {
+	return realClock{}
+}
+
+type realClock struct{}
+
+func (realClock) Now() time.Time {
+	return time.Now()
+}
+
+func (realClock) Since(t time.Time) time.Duration {
+	return time.Since(t)
+}
+
+func (realClock) After(d time.Duration) <-chan time.Time {
+	return time.After(d)
+}
+
+func (realClock) NewTicker(d time.Duration) Ticker {
+	return realTicker{time.NewTicker(d)}
+}
+
+type realTicker struct {
+	ticker *time.Ticker
+}
+
+func (t realTicker) C() <-chan time.Time {
+	return t.ticker.C
+}
+
+func (t realTicker) Stop() {
+	t.ticker.Stop()
+}
+
+// Mock is a Clock which only moves when it is told to. Timers created with After and NewTicker fire when a call
+// to Advance or Set moves the clock past their deadline. It is safe for concurrent use
+type Mock struct {
+	mu      sync.Mutex
+	now     time.Time
+	waiters []*waiter
+}
+
+// waiter is a pending After channel or Ticker on the Mock clock. Tickers have a non-zero interval and are re-armed after they fire
+type waiter struct {
+	deadline time.Time
+	interval time.Duration
+	c        chan time.Time
+	stopped  bool
+}
+
+// NewMock returns a Mock clock set to the given time
+func NewMock(now time.Time) *Mock {
+	return &Mock{now: now}
+}
+
+func (m *Mock) Now() time.Time {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.now
+}
+
+func (m *Mock) Since(t time.Time) time.Duration {
+	return m.Now().Sub(t)
+}
+
+func (m *Mock) After(d time.Duration) <-chan time.Time {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	w := &waiter{deadline: m.now.Add(d), c: make(chan time.Time, 1)}
+	m.waiters = append(m.waiters, w)
+
+	return w.c
+}
+
+func (m *Mock) NewTicker(d time.Duration) Ticker {
+	if d <= 0 {
+		panic("non-positive interval for NewTicker")
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	w := &waiter{deadline: m.now.Add(d), interval: d, c: make(chan time.Time, 1)}
+	m.waiters = append(m.waiters, w)
+
+	return &mockTicker{clock: m, waiter: w}
+}
+
+// Advance moves the clock forward by d, firing any timers whose deadline has been reached
+func (m *Mock) Advance(d time.Duration) {
+	m.Set(m.Now().Add(d))
+}
+
+// Set moves the clock to the given time, firing any timers whose deadline has been reached. Like a real ticker, a mock
+// ticker which falls behind drops ticks rather than queueing them up
+func (m *Mock) Set(t time.Time) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.now = t
+
+	pending := m.waiters[:0]
+	for _, w := range m.waiters {
+		if w.stopped {
+			continue
+		}
+
+		if !w.deadline.After(t) {
+			// the channels are buffered, so a channel which hasn't been read yet simply misses this tick
+			select {
+			case w.c <- t:
+			default:
+			}
+
+			if w.interval == 0 {
+				continue
+			}
+
+			for !w.deadline.After(t) {
+				w.deadline = w.deadline.Add(w.interval)
+			}
+		}
+
+		pending = append(pending, w)
+	}
+
+	m.waiters = pending
+}
+
+type mockTicker struct {
+	clock  *Mock
+	waiter *waiter
+}
+
+func (t *mockTicker) C() <-chan time.Time {
+	return t.waiter.c
+}
+
+func (t *mockTicker) Stop() {
+	t.clock.mu.Lock()
+	defer t.clock.mu.Unlock()
+	t.waiter.stopped = true
+}
@@ -0,0 +1,256 @@
+package data
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base32"
+	"errors"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/nytro04/greenlight/internal/clock"
+	"github.com/nytro04/greenlight/internal/validator"
+)
+
+// APIKeyPrefix starts every API key, so leaked keys are easy to recognise (e.g. by secret scanners)
+const APIKeyPrefix = "gl_"
+
+// apiKeyLength is the length of the plaintext API keys, the prefix followed by 32 base32 characters (20 random bytes)
+const apiKeyLength = len(APIKeyPrefix) + 32
+
+// APIKey is a long-lived credential for scripts and server-to-server integrations. A key acts on behalf of the user
+// it belongs to, but only with the permissions it was minted with
+type APIKey struct {
+	ID          int64       `json:"id"`
+	CreatedAt   time.Time   `json:"created_at"`
+	UserID      int64       `json:"user_id"`
+	Name        string      `json:"name"`
+	Prefix      string      `json:"prefix"`        // the first characters of the key, to tell keys apart
+	Plaintext   string      `json:"key,omitempty"` // only set when the key is minted, it can't be retrieved afterwards
+	Hash        []byte      `json:"-"`
+	Permissions Permissions `json:"permissions"`
+	Tier        string      `json:"tier"`       // the quota tier, which sets how many requests the key can make a day and a month
+	ExpiresAt   *time.Time  `json:"expires_at"` // nil for keys which don't expire
+	LastUsedAt  *time.Time  `json:"last_used_at"`
+}
+
+func ValidateAPIKey(v *validator.Validator, key *APIKey, now time.Time) {
+	v.Check(key.Name != "", "name", "must be provided")
+	v.Check(len(key.Name) <= 100, "name", "must not be more than 100 bytes long")
+
+	v.Check(len(key.Permissions) > 0, "permissions", "must contain at least 1 permission")
+	v.Check(validator.Unique(key.Permissions), "permissions", "must not contain duplicate values")
+
+	v.Check(key.Tier != "", "tier", "must be provided")
+
+	if key.ExpiresAt != nil {
+		v.Check(key.ExpiresAt.After(now), "expires_at", "must be in the future")
+	}
+}
+
+// ValidateAPIKeyPlaintext checks the key looks like one we issued before it is looked up
+func ValidateAPIKeyPlaintext(v *validator.Validator, plaintext string) {
+	v.Check(strings.HasPrefix(plaintext, APIKeyPrefix), "key", "must be a valid API key")
+	v.Check(len(plaintext) == apiKeyLength, "key", "must be a valid API key")
+}
+
+type APIKeyModel struct {
+	DB     DBTX
+	Clock  clock.Clock
+	Random io.Reader
+}
+
+// Insert generates the random key for the API key and stores its hash. The plaintext key is set on the APIKey so it
+// can be handed to the client once
+func (m APIKeyModel) Insert(key *APIKey) error {
+	randomBytes := make([]byte, 20)
+
+	_, err := io.ReadFull(m.Random, randomBytes)
+	if err != nil {
+		return err
+	}
+
+	key.Plaintext = APIKeyPrefix + base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString(randomBytes)
+	key.Prefix = key.Plaintext[:len(APIKeyPrefix)+8]
+
+	hash := sha256.Sum256([]byte(key.Plaintext))
+	key.Hash = hash[:]
+
+	query := `
+		INSERT INTO api_keys (user_id, name, prefix, hash, permissions, tier, expires_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7)
+		RETURNING id, created_at`
+
+	args := []interface{}{key.UserID, key.Name, key.Prefix, key.Hash, key.Permissions, key.Tier, key.ExpiresAt}
+
+	ctx, cancel := withWriteTimeout(context.Background())
+	defer cancel()
+
+	err = m.DB.QueryRowContext(ctx, query, args...).Scan(&key.ID, &key.CreatedAt)
+	if err != nil {
+		switch {
+		case isForeignKeyViolation(err, "api_keys_user_id_fkey"):
+			return ErrRecordNotFound
+		case isForeignKeyViolation(err, "api_keys_tier_fkey"):
+			return ErrUnknownQuotaTier
+		default:
+			return err
+		}
+	}
+
+	return nil
+}
+
+// GetAll returns the API keys, newest first. When userID is not zero only the keys of that user are returned
+func (m APIKeyModel) GetAll(userID int64) ([]*APIKey, error) {
+	query := `
+		SELECT id, created_at, user_id, name, prefix, permissions, tier, expires_at, last_used_at
+		FROM api_keys
+		WHERE (user_id = $1 OR $1 = 0)
+		ORDER BY id DESC`
+
+	ctx, cancel := withReadTimeout(context.Background())
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	keys := []*APIKey{}
+
+	for rows.Next() {
+		var key APIKey
+
+		err := rows.Scan(
+			&key.ID,
+			&key.CreatedAt,
+			&key.UserID,
+			&key.Name,
+			&key.Prefix,
+			scanArray((*[]string)(&key.Permissions)),
+			&key.Tier,
+			&key.ExpiresAt,
+			&key.LastUsedAt,
+		)
+		if err != nil {
+			return nil, err
+		}
+
+		keys = append(keys, &key)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return keys, nil
+}
+
+// Delete revokes the API key, it stops working straight away
+func (m APIKeyModel) Delete(id int64) error {
+	if id < 1 {
+		return ErrRecordNotFound
+	}
+
+	query := `
+		DELETE FROM api_keys
+		WHERE id = $1`
+
+	ctx, cancel := withWriteTimeout(context.Background())
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, query, id)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+
+	return nil
+}
+
+// GetUserForKey looks up an unexpired API key by its plaintext and returns the key together with the user it belongs to.
+// The time the key was last used is recorded in the same statement, so the owner can spot keys which are no longer needed.
+// The keys of a disabled user are never matched
+func (m APIKeyModel) GetUserForKey(plaintext string) (*User, *APIKey, error) {
+	hash := sha256.Sum256([]byte(plaintext))
+
+	query := `
+		UPDATE api_keys
+		SET last_used_at = $2
+		FROM users
+		WHERE users.id = api_keys.user_id
+		AND api_keys.hash = $1
+		AND (api_keys.expires_at IS NULL OR api_keys.expires_at > $2)
+		AND users.disabled_at IS NULL
+		RETURNING users.id, users.created_at, users.name, users.email, users.password_hash, users.activated, users.version, users.avatar_key,
+		api_keys.id, api_keys.created_at, api_keys.name, api_keys.prefix, api_keys.permissions, api_keys.tier, api_keys.expires_at, api_keys.last_used_at`
+
+	var user User
+	var key APIKey
+
+	ctx, cancel := withWriteTimeout(context.Background())
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, hash[:], m.Clock.Now()).Scan(
+		&user.ID,
+		&user.CreatedAt,
+		&user.Name,
+		&user.Email,
+		&user.Password.hash,
+		&user.Activated,
+		&user.Version,
+		&user.AvatarKey,
+		&key.ID,
+		&key.CreatedAt,
+		&key.Name,
+		&key.Prefix,
+		scanArray((*[]string)(&key.Permissions)),
+		&key.Tier,
+		&key.ExpiresAt,
+		&key.LastUsedAt,
+	)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, nil, ErrRecordNotFound
+		default:
+			return nil, nil, err
+		}
+	}
+
+	key.UserID = user.ID
+	user.setAvatarURL()
+
+	return &user, &key, nil
+}
+
+// Mock data for testing
+type MockAPIKeyModel struct{}
+
+func (m MockAPIKeyModel) Insert(key *APIKey) error {
+	return nil
+}
+
+func (m MockAPIKeyModel) GetAll(userID int64) ([]*APIKey, error) {
+	return nil, nil
+}
+
+func (m MockAPIKeyModel) Delete(id int64) error {
+	return nil
+}
+
+func (m MockAPIKeyModel) GetUserForKey(plaintext string) (*User, *APIKey, error) {
+	return nil, nil, ErrRecordNotFound
+}
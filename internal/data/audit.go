@@ -0,0 +1,145 @@
+package data
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+)
+
+// Define constants for the audit event categories. Each category has its own retention policy, so
+// events which are only interesting for a short while (content edits) don't have to be kept as long
+// as the events which compliance needs to archive (authentication events)
+const (
+	AuditCategoryAuth    = "auth"
+	AuditCategoryContent = "content"
+)
+
+// DefaultAuditRetention holds the default retention period for each audit category. Events older than
+// the retention period for their category are removed by the scheduled retention job
+var DefaultAuditRetention = map[string]time.Duration{
+	AuditCategoryAuth:    365 * 24 * time.Hour,
+	AuditCategoryContent: 90 * 24 * time.Hour,
+}
+
+// AuditEvent holds the data for a single entry in the audit log. The UserID field is a pointer so that
+// events triggered by anonymous clients (e.g. a failed login) can be stored with a NULL user_id
+type AuditEvent struct {
+	ID         int64             `json:"id"`
+	CreatedAt  time.Time         `json:"created_at"`
+	Category   string            `json:"category"`
+	Action     string            `json:"action"`
+	UserID     *int64            `json:"user_id"`
+	Target     string            `json:"target,omitempty"`
+	Properties map[string]string `json:"properties,omitempty"`
+}
+
+// AuditModel wraps the connection pool and is used to read and write audit events
+type AuditModel struct {
+	DB DBTX
+}
+
+// Insert a new audit event into the audit_events table. The properties map is stored as a jsonb column
+func (m AuditModel) Insert(event *AuditEvent) error {
+	properties, err := json.Marshal(event.Properties)
+	if err != nil {
+		return err
+	}
+
+	// a nil map is marshalled to "null", store an empty object instead so the column stays consistent
+	if event.Properties == nil {
+		properties = []byte("{}")
+	}
+
+	query := `
+		INSERT INTO audit_events (category, action, user_id, target, properties)
+		VALUES ($1, $2, $3, $4, $5)
+		RETURNING id, created_at`
+
+	args := []interface{}{event.Category, event.Action, event.UserID, event.Target, properties}
+
+	ctx, cancel := withWriteTimeout(context.Background())
+	defer cancel()
+
+	return m.DB.QueryRowContext(ctx, query, args...).Scan(&event.ID, &event.CreatedAt)
+}
+
+// DeleteExpired deletes all audit events in the given category which were created before the provided time.
+// It returns the number of events which were removed
+func (m AuditModel) DeleteExpired(category string, before time.Time) (int64, error) {
+	query := `
+		DELETE FROM audit_events
+		WHERE category = $1 AND created_at < $2`
+
+	// deleting a year worth of events can take a while, so we use a more forgiving timeout here
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, query, category, before)
+	if err != nil {
+		return 0, err
+	}
+
+	return result.RowsAffected()
+}
+
+// Export iterates through all audit events created in the [from, to) range in chronological order, calling fn
+// for each one. The events are streamed from the database one row at a time rather than loaded into memory, so
+// the export can cover an arbitrarily large date range. If fn returns an error the iteration stops and the error is returned
+func (m AuditModel) Export(ctx context.Context, from, to time.Time, fn func(event *AuditEvent) error) error {
+	query := `
+		SELECT id, created_at, category, action, user_id, target, properties
+		FROM audit_events
+		WHERE created_at >= $1 AND created_at < $2
+		ORDER BY created_at ASC, id ASC`
+
+	rows, err := m.DB.QueryContext(ctx, query, from, to)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var event AuditEvent
+		var properties []byte
+
+		err := rows.Scan(
+			&event.ID,
+			&event.CreatedAt,
+			&event.Category,
+			&event.Action,
+			&event.UserID,
+			&event.Target,
+			&properties,
+		)
+		if err != nil {
+			return err
+		}
+
+		err = json.Unmarshal(properties, &event.Properties)
+		if err != nil {
+			return err
+		}
+
+		err = fn(&event)
+		if err != nil {
+			return err
+		}
+	}
+
+	return rows.Err()
+}
+
+// Mock data for testing
+type MockAuditModel struct{}
+
+func (m MockAuditModel) Insert(event *AuditEvent) error {
+	return nil
+}
+
+func (m MockAuditModel) DeleteExpired(category string, before time.Time) (int64, error) {
+	return 0, nil
+}
+
+func (m MockAuditModel) Export(ctx context.Context, from, to time.Time, fn func(event *AuditEvent) error) error {
+	return nil
+}
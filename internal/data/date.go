@@ -0,0 +1,55 @@
+package data
+
+import (
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+)
+
+var ErrInvalidDateFormat = errors.New("invalid date format")
+
+// Date is a calendar date without a time of day, such as a birth date. It is encoded in JSON as a "YYYY-MM-DD" string
+// and stored in PostgreSQL date columns
+type Date struct {
+	time.Time
+}
+
+// implement the MarshalJSON method on the Date type so that it satisfies the json.Marshaler interface
+func (d Date) MarshalJSON() ([]byte, error) {
+	return []byte(strconv.Quote(d.Format(time.DateOnly))), nil
+}
+
+func (d *Date) UnmarshalJSON(jsonValue []byte) error {
+	unquotedJSONValue, err := strconv.Unquote(string(jsonValue))
+	if err != nil {
+		return ErrInvalidDateFormat
+	}
+
+	t, err := time.Parse(time.DateOnly, unquotedJSONValue)
+	if err != nil {
+		return ErrInvalidDateFormat
+	}
+
+	d.Time = t
+
+	return nil
+}
+
+// Scan implements the sql.Scanner interface so a Date can be read straight from a date column
+func (d *Date) Scan(value interface{}) error {
+	t, ok := value.(time.Time)
+	if !ok {
+		return fmt.Errorf("cannot scan %T into Date", value)
+	}
+
+	d.Time = time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC)
+
+	return nil
+}
+
+// Value implements the driver.Valuer interface so a Date can be used as a query argument
+func (d Date) Value() (driver.Value, error) {
+	return d.Format(time.DateOnly), nil
+}
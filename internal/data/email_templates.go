@@ -0,0 +1,209 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/nytro04/greenlight/internal/validator"
+)
+
+// EmailTemplate is a version of an email template edited by an admin, overriding the template of the same name which
+// is embedded in the binary. Every change adds a new version, the latest version is the one the emails are rendered
+// with. The embedded templates are shown with version 0
+type EmailTemplate struct {
+	ID        int64     `json:"-"`
+	CreatedAt time.Time `json:"created_at"`
+	Name      string    `json:"name"`
+	Version   int32     `json:"version"`
+	Subject   string    `json:"subject"`
+	PlainBody string    `json:"plain_body"`
+	HTMLBody  string    `json:"html_body"`
+}
+
+func ValidateEmailTemplate(v *validator.Validator, tmpl *EmailTemplate) {
+	v.Check(tmpl.Subject != "", "subject", "must be provided")
+	v.Check(len(tmpl.Subject) <= 1000, "subject", "must not be more than 1000 bytes long")
+
+	v.Check(tmpl.PlainBody != "", "plain_body", "must be provided")
+	v.Check(len(tmpl.PlainBody) <= 100_000, "plain_body", "must not be more than 100000 bytes long")
+
+	v.Check(tmpl.HTMLBody != "", "html_body", "must be provided")
+	v.Check(len(tmpl.HTMLBody) <= 100_000, "html_body", "must not be more than 100000 bytes long")
+}
+
+type EmailTemplateModel struct {
+	DB DBTX
+}
+
+// Insert adds a new version of the template, numbered one after the latest version. Two admins saving the same
+// template at the same time would both get the same number, so the second one gets an ErrEditConflict
+func (m EmailTemplateModel) Insert(ctx context.Context, tmpl *EmailTemplate) error {
+	query := `
+		INSERT INTO email_templates (name, version, subject, plain_body, html_body)
+		SELECT $1, COALESCE(MAX(version), 0) + 1, $2, $3, $4
+		FROM email_templates
+		WHERE name = $1
+		RETURNING id, created_at, version`
+
+	args := []any{tmpl.Name, tmpl.Subject, tmpl.PlainBody, tmpl.HTMLBody}
+
+	ctx, cancel := withWriteTimeout(ctx)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, args...).Scan(&tmpl.ID, &tmpl.CreatedAt, &tmpl.Version)
+	if err != nil {
+		switch {
+		case isUniqueViolation(err, "email_templates_name_version_key"):
+			return ErrEditConflict
+		default:
+			return err
+		}
+	}
+
+	return nil
+}
+
+// GetLatest returns the latest version of the template, or ErrRecordNotFound when the embedded template hasn't been
+// overridden
+func (m EmailTemplateModel) GetLatest(ctx context.Context, name string) (*EmailTemplate, error) {
+	query := `
+		SELECT id, created_at, name, version, subject, plain_body, html_body
+		FROM email_templates
+		WHERE name = $1
+		ORDER BY version DESC
+		LIMIT 1`
+
+	ctx, cancel := withReadTimeout(ctx)
+	defer cancel()
+
+	var tmpl EmailTemplate
+
+	err := m.DB.QueryRowContext(ctx, query, name).Scan(
+		&tmpl.ID,
+		&tmpl.CreatedAt,
+		&tmpl.Name,
+		&tmpl.Version,
+		&tmpl.Subject,
+		&tmpl.PlainBody,
+		&tmpl.HTMLBody,
+	)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	return &tmpl, nil
+}
+
+// GetVersions returns every version of the template, newest first
+func (m EmailTemplateModel) GetVersions(ctx context.Context, name string) ([]*EmailTemplate, error) {
+	query := `
+		SELECT id, created_at, name, version, subject, plain_body, html_body
+		FROM email_templates
+		WHERE name = $1
+		ORDER BY version DESC`
+
+	return m.query(ctx, query, name)
+}
+
+// GetAllLatest returns the latest version of every template which has been overridden
+func (m EmailTemplateModel) GetAllLatest(ctx context.Context) ([]*EmailTemplate, error) {
+	query := `
+		SELECT DISTINCT ON (name) id, created_at, name, version, subject, plain_body, html_body
+		FROM email_templates
+		ORDER BY name, version DESC`
+
+	return m.query(ctx, query)
+}
+
+func (m EmailTemplateModel) query(ctx context.Context, query string, args ...any) ([]*EmailTemplate, error) {
+	ctx, cancel := withReadTimeout(ctx)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	templates := []*EmailTemplate{}
+
+	for rows.Next() {
+		var tmpl EmailTemplate
+
+		err := rows.Scan(
+			&tmpl.ID,
+			&tmpl.CreatedAt,
+			&tmpl.Name,
+			&tmpl.Version,
+			&tmpl.Subject,
+			&tmpl.PlainBody,
+			&tmpl.HTMLBody,
+		)
+		if err != nil {
+			return nil, err
+		}
+
+		templates = append(templates, &tmpl)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return templates, nil
+}
+
+// Delete removes every version of the template, so the emails are rendered with the embedded template again
+func (m EmailTemplateModel) Delete(ctx context.Context, name string) error {
+	query := `
+		DELETE FROM email_templates
+		WHERE name = $1`
+
+	ctx, cancel := withWriteTimeout(ctx)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, query, name)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+
+	return nil
+}
+
+type MockEmailTemplateModel struct{}
+
+func (m MockEmailTemplateModel) Insert(ctx context.Context, tmpl *EmailTemplate) error {
+	return nil
+}
+
+func (m MockEmailTemplateModel) GetLatest(ctx context.Context, name string) (*EmailTemplate, error) {
+	return nil, ErrRecordNotFound
+}
+
+func (m MockEmailTemplateModel) GetVersions(ctx context.Context, name string) ([]*EmailTemplate, error) {
+	return nil, nil
+}
+
+func (m MockEmailTemplateModel) GetAllLatest(ctx context.Context) ([]*EmailTemplate, error) {
+	return nil, nil
+}
+
+func (m MockEmailTemplateModel) Delete(ctx context.Context, name string) error {
+	return ErrRecordNotFound
+}
@@ -0,0 +1,185 @@
+package data
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	"github.com/nytro04/greenlight/internal/clock"
+)
+
+// The states of a queued email. An email is pending until it is sent or runs out of attempts
+const (
+	EmailPending = "pending"
+	EmailSent    = "sent"
+	EmailFailed  = "failed"
+)
+
+// Email is an email rendered from one of the mailer's templates and queued to be sent by the email worker. It is kept
+// rendered, so it can be sent after a restart without the data it was rendered with
+type Email struct {
+	ID            int64             `json:"id"`
+	CreatedAt     time.Time         `json:"created_at"`
+	Recipient     string            `json:"recipient"`
+	Template      string            `json:"template"`
+	Subject       string            `json:"subject"`
+	PlainBody     string            `json:"-"`
+	HTMLBody      string            `json:"-"`
+	Status        string            `json:"status"`
+	Attempts      int               `json:"attempts"`
+	NextAttemptAt time.Time         `json:"next_attempt_at"`
+	LastError     string            `json:"last_error"`
+	SentAt        *time.Time        `json:"sent_at"`
+	Attachments   []EmailAttachment `json:"-"`
+}
+
+// EmailAttachment is a file attached to a queued email, an inline one is an image shown in the HTML body
+type EmailAttachment struct {
+	Filename    string `json:"filename"`
+	ContentType string `json:"content_type"`
+	Data        []byte `json:"data"`
+	Inline      bool   `json:"inline"`
+}
+
+type EmailModel struct {
+	DB    DBTX
+	Clock clock.Clock
+}
+
+// Insert queues an email to be sent straight away. Inserted with the models of a transaction, the email is only sent
+// if the transaction commits
+func (m EmailModel) Insert(ctx context.Context, email *Email) error {
+	attachments, err := json.Marshal(email.Attachments)
+	if err != nil {
+		return err
+	}
+
+	// a nil slice is marshalled to "null", store an empty array instead so the column stays consistent
+	if email.Attachments == nil {
+		attachments = []byte("[]")
+	}
+
+	query := `
+		INSERT INTO emails (recipient, template, subject, plain_body, html_body, attachments, next_attempt_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7)
+		RETURNING id, created_at, status, attempts, next_attempt_at`
+
+	args := []any{email.Recipient, email.Template, email.Subject, email.PlainBody, email.HTMLBody, attachments, m.Clock.Now()}
+
+	ctx, cancel := withWriteTimeout(ctx)
+	defer cancel()
+
+	return m.DB.QueryRowContext(ctx, query, args...).Scan(&email.ID, &email.CreatedAt, &email.Status, &email.Attempts, &email.NextAttemptAt)
+}
+
+// ClaimDue picks up to limit pending emails which are due and pushes their next attempt back by the lease, so another
+// worker won't pick them up while they are being sent. The attempt is counted when it is claimed, so a worker which
+// dies part way through still uses up one of the attempts
+func (m EmailModel) ClaimDue(limit int, lease time.Duration) ([]*Email, error) {
+	now := m.Clock.Now()
+
+	query := `
+		UPDATE emails
+		SET attempts = attempts + 1, next_attempt_at = $2
+		WHERE id IN (
+			SELECT id FROM emails
+			WHERE status = 'pending' AND next_attempt_at <= $1
+			ORDER BY next_attempt_at
+			LIMIT $3
+			FOR UPDATE SKIP LOCKED
+		)
+		RETURNING id, created_at, recipient, template, subject, plain_body, html_body, attachments, status, attempts, next_attempt_at`
+
+	ctx, cancel := withWriteTimeout(context.Background())
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, now, now.Add(lease), limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	emails := []*Email{}
+
+	for rows.Next() {
+		var email Email
+		var attachments []byte
+
+		err := rows.Scan(
+			&email.ID,
+			&email.CreatedAt,
+			&email.Recipient,
+			&email.Template,
+			&email.Subject,
+			&email.PlainBody,
+			&email.HTMLBody,
+			&attachments,
+			&email.Status,
+			&email.Attempts,
+			&email.NextAttemptAt,
+		)
+		if err != nil {
+			return nil, err
+		}
+
+		err = json.Unmarshal(attachments, &email.Attachments)
+		if err != nil {
+			return nil, err
+		}
+
+		emails = append(emails, &email)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return emails, nil
+}
+
+// MarkSent records that the email was accepted by the mail provider
+func (m EmailModel) MarkSent(id int64) error {
+	query := `
+		UPDATE emails
+		SET status = 'sent', last_error = '', sent_at = $2
+		WHERE id = $1`
+
+	ctx, cancel := withWriteTimeout(context.Background())
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, query, id, m.Clock.Now())
+	return err
+}
+
+// MarkFailed records a failed attempt. The email is retried at nextAttemptAt, or given up on when it is nil
+func (m EmailModel) MarkFailed(id int64, lastError string, nextAttemptAt *time.Time) error {
+	query := `
+		UPDATE emails
+		SET status = CASE WHEN $3::timestamptz IS NULL THEN 'failed' ELSE 'pending' END,
+		last_error = $2, next_attempt_at = COALESCE($3, next_attempt_at)
+		WHERE id = $1`
+
+	ctx, cancel := withWriteTimeout(context.Background())
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, query, id, lastError, nextAttemptAt)
+	return err
+}
+
+type MockEmailModel struct{}
+
+func (m MockEmailModel) Insert(ctx context.Context, email *Email) error {
+	return nil
+}
+
+func (m MockEmailModel) ClaimDue(limit int, lease time.Duration) ([]*Email, error) {
+	return nil, nil
+}
+
+func (m MockEmailModel) MarkSent(id int64) error {
+	return nil
+}
+
+func (m MockEmailModel) MarkFailed(id int64, lastError string, nextAttemptAt *time.Time) error {
+	return nil
+}
@@ -0,0 +1,45 @@
+package data
+
+import (
+	"database/sql"
+	"errors"
+
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/jackc/pgx/v5/pgtype"
+)
+
+// The PostgreSQL error codes of the constraint violations the models map to their own errors
+const (
+	pgForeignKeyViolation = "23503"
+	pgUniqueViolation     = "23505"
+)
+
+// isConstraintViolation reports whether err is a PostgreSQL error with the given code raised by the named constraint.
+// Checking the code and constraint name rather than the error message keeps working whatever language the server
+// reports errors in, and whatever else the message says about the offending row
+func isConstraintViolation(err error, code, constraint string) bool {
+	var pgErr *pgconn.PgError
+	if !errors.As(err, &pgErr) {
+		return false
+	}
+
+	return pgErr.Code == code && pgErr.ConstraintName == constraint
+}
+
+// isUniqueViolation reports whether err is a violation of the named UNIQUE constraint
+func isUniqueViolation(err error, constraint string) bool {
+	return isConstraintViolation(err, pgUniqueViolation, constraint)
+}
+
+// isForeignKeyViolation reports whether err is a violation of the named FOREIGN KEY constraint, which happens when the
+// row being written refers to a row which doesn't exist
+func isForeignKeyViolation(err error, constraint string) bool {
+	return isConstraintViolation(err, pgForeignKeyViolation, constraint)
+}
+
+// scanArray returns a scanner which reads a PostgreSQL array column into dest, as database/sql can only scan into basic
+// types. Arrays are passed as query arguments as they are, pgx converts slices itself. A pgx type map isn't safe for
+// concurrent use, so every scan gets a new one, which is cheap as they share the built in types
+func scanArray[T any](dest *[]T) sql.Scanner {
+	return pgtype.NewMap().SQLScanner(dest)
+}
@@ -0,0 +1,59 @@
+package data
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+)
+
+// explainNode is a node of the plan EXPLAIN (FORMAT JSON) returns, with only the fields the estimates need
+type explainNode struct {
+	NodeType string        `json:"Node Type"`
+	PlanRows float64       `json:"Plan Rows"`
+	Plans    []explainNode `json:"Plans"`
+}
+
+// tableEstimate returns the number of rows in the table from the planner's statistics in pg_class, which costs the
+// same however big the table is. reltuples is -1 for a table which hasn't been vacuumed or analyzed yet, which is
+// returned as 0
+func tableEstimate(ctx context.Context, db DBTX, table string) (int, error) {
+	var reltuples float64
+
+	err := db.QueryRowContext(ctx, `SELECT reltuples FROM pg_class WHERE oid = $1::regclass`, table).Scan(&reltuples)
+	if err != nil {
+		return 0, err
+	}
+
+	return max(int(reltuples), 0), nil
+}
+
+// queryEstimate returns the number of rows the planner expects the query to return, from EXPLAIN, without running
+// it. The LIMIT of a paginated query is skipped over, so it is the estimate of all the matching rows rather than a page
+func queryEstimate(ctx context.Context, db DBTX, query string, args ...any) (int, error) {
+	var js []byte
+
+	err := db.QueryRowContext(ctx, "EXPLAIN (FORMAT JSON) "+query, args...).Scan(&js)
+	if err != nil {
+		return 0, err
+	}
+
+	var explained []struct {
+		Plan explainNode `json:"Plan"`
+	}
+
+	err = json.Unmarshal(js, &explained)
+	if err != nil {
+		return 0, err
+	}
+
+	if len(explained) == 0 {
+		return 0, errors.New("data: EXPLAIN returned no plan")
+	}
+
+	node := explained[0].Plan
+	for node.NodeType == "Limit" && len(node.Plans) > 0 {
+		node = node.Plans[0]
+	}
+
+	return int(node.PlanRows), nil
+}
@@ -0,0 +1,192 @@
+package data
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// FavoriteModel keeps the movies each user has marked as a favorite. Unlike the watchlist, which holds the movies a
+// user means to watch, the favorites are the ones they liked, and every movie response says whether the movie is one
+// of the requesting user's favorites
+type FavoriteModel struct {
+	DB DBTX
+}
+
+// Add marks a movie as one of the user's favorites. Adding a movie which is already a favorite does nothing, and
+// ErrRecordNotFound is returned if the movie doesn't exist
+func (m FavoriteModel) Add(ctx context.Context, userID, movieID int64) error {
+	query := `
+		WITH added AS (
+			INSERT INTO favorites (user_id, movie_id)
+			VALUES ($1, $2)
+			ON CONFLICT DO NOTHING
+			RETURNING user_id
+		)
+		UPDATE users
+		SET favorites_updated_at = NOW()
+		WHERE id IN (SELECT user_id FROM added)`
+
+	ctx, cancel := withWriteTimeout(ctx)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, query, userID, movieID)
+	if err != nil {
+		switch {
+		case isForeignKeyViolation(err, "favorites_movie_id_fkey"):
+			return ErrRecordNotFound
+		default:
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Remove unmarks a movie as one of the user's favorites, returning ErrRecordNotFound if it wasn't one
+func (m FavoriteModel) Remove(ctx context.Context, userID, movieID int64) error {
+	query := `
+		WITH removed AS (
+			DELETE FROM favorites
+			WHERE user_id = $1 AND movie_id = $2
+			RETURNING user_id
+		)
+		UPDATE users
+		SET favorites_updated_at = NOW()
+		WHERE id IN (SELECT user_id FROM removed)`
+
+	ctx, cancel := withWriteTimeout(ctx)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, query, userID, movieID)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+
+	return nil
+}
+
+// GetAll returns a page of the user's favorite movies. As well as the movie columns, the results can be sorted by
+// favorited_at, the time the movie was marked as a favorite
+func (m FavoriteModel) GetAll(ctx context.Context, userID int64, filters Filters) ([]*Movie, Metadata, error) {
+	sortColumn, err := filters.sortColumn()
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+
+	query := fmt.Sprintf(`
+		SELECT count(*) OVER(), movies.id, movies.created_at, movies.updated_at, title, year, runtime, genres, movies.version, poster_key,
+		average_rating, review_count, language, country
+		FROM movies
+		INNER JOIN favorites ON favorites.movie_id = movies.id
+		WHERE favorites.user_id = $1
+		ORDER BY %s %s, movies.id ASC
+		LIMIT $2 OFFSET $3`, sortColumn, filters.sortDirection())
+
+	ctx, cancel := withReadTimeout(ctx)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, userID, filters.limit(), filters.offset())
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+	defer rows.Close()
+
+	totalRecords := 0
+	movies := []*Movie{}
+
+	for rows.Next() {
+		var movie Movie
+
+		err := rows.Scan(
+			&totalRecords,
+			&movie.ID,
+			&movie.CreatedAt,
+			&movie.UpdatedAt,
+			&movie.Title,
+			&movie.Year,
+			&movie.Runtime,
+			scanArray(&movie.Genres),
+			&movie.Version,
+			&movie.PosterKey,
+			&movie.AverageRating,
+			&movie.ReviewCount,
+			&movie.Language,
+			&movie.Country,
+		)
+		if err != nil {
+			return nil, Metadata{}, err
+		}
+
+		movie.setPosterURL()
+
+		favorited := true
+		movie.Favorited = &favorited
+
+		movies = append(movies, &movie)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, Metadata{}, err
+	}
+
+	metadata := calculateMetadata(totalRecords, filters.Page, filters.PageSize)
+
+	return movies, metadata, nil
+}
+
+// GetFavorited returns which of the movies are among the user's favorites, along with the time the user last
+// favorited or unfavorited a movie
+func (m FavoriteModel) GetFavorited(ctx context.Context, userID int64, movieIDs []int64) (map[int64]bool, time.Time, error) {
+	query := `
+		SELECT favorites_updated_at,
+		ARRAY(SELECT movie_id FROM favorites WHERE user_id = users.id AND movie_id = ANY($2))
+		FROM users
+		WHERE id = $1`
+
+	ctx, cancel := withReadTimeout(ctx)
+	defer cancel()
+
+	var updatedAt time.Time
+	var ids []int64
+
+	err := m.DB.QueryRowContext(ctx, query, userID, movieIDs).Scan(&updatedAt, scanArray(&ids))
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+
+	favorited := make(map[int64]bool, len(ids))
+	for _, id := range ids {
+		favorited[id] = true
+	}
+
+	return favorited, updatedAt, nil
+}
+
+// Mock data for testing
+type MockFavoriteModel struct{}
+
+func (m MockFavoriteModel) Add(ctx context.Context, userID, movieID int64) error {
+	return nil
+}
+
+func (m MockFavoriteModel) Remove(ctx context.Context, userID, movieID int64) error {
+	return nil
+}
+
+func (m MockFavoriteModel) GetAll(ctx context.Context, userID int64, filters Filters) ([]*Movie, Metadata, error) {
+	return nil, Metadata{}, nil
+}
+
+func (m MockFavoriteModel) GetFavorited(ctx context.Context, userID int64, movieIDs []int64) (map[int64]bool, time.Time, error) {
+	return map[int64]bool{}, time.Time{}, nil
+}
@@ -1,25 +1,97 @@
 package data
 
 import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
 	"math"
 	"strings"
+	"time"
 
 	"github.com/nytro04/greenlight/internal/validator"
 )
 
+// The largest page number and page size the list endpoints accept
+const (
+	MaxPage     = 10_000_000
+	MaxPageSize = 100
+)
+
+// ErrUnsafeSort is returned when the sort value doesn't match any of the values in the SortSafeList
+var ErrUnsafeSort = errors.New("unsafe sort parameter")
+
+// ErrInvalidCursor is returned when a pagination cursor can't be decoded, or was issued for a different sort order
+var ErrInvalidCursor = errors.New("invalid cursor")
+
+// CursorSortSafeList holds the sort values which can be paginated with a cursor. Keyset pagination needs a unique key
+// to seek past, so the listings are ordered by id, or by created_at with the id breaking ties
+var CursorSortSafeList = []string{"id", "created_at", "-id", "-created_at"}
+
+// Filters holds the pagination and sorting parameters of a list request. When CursorMode is set the list is paginated
+// with a cursor instead of page numbers, PageSize is still the number of records returned and Cursor is the next_cursor
+// from the previous page, or empty for the first page. When EstimateCount is set the total isn't counted, it is
+// estimated by the planner instead, which the listings of the big tables support
 type Filters struct {
-	Page         int
-	PageSize     int
-	Sort         string
-	SortSafeList []string
+	Page          int
+	PageSize      int
+	Sort          string
+	SortSafeList  []string
+	CursorMode    bool
+	Cursor        string
+	EstimateCount bool
 }
 
+// Metadata describes the page of a list response. ApproximateTotal is set when TotalRecords, and so LastPage, is the
+// planner's estimate rather than an exact count
 type Metadata struct {
-	CurrentPage  int `json:"current_page,omitempty"`
-	PageSize     int `json:"page_size,omitempty"`
-	FirstPage    int `json:"first_page,omitempty"`
-	LastPage     int `json:"last_page,omitempty"`
-	TotalRecords int `json:"total_records,omitempty"`
+	CurrentPage      int    `json:"current_page,omitempty"`
+	PageSize         int    `json:"page_size,omitempty"`
+	FirstPage        int    `json:"first_page,omitempty"`
+	LastPage         int    `json:"last_page,omitempty"`
+	TotalRecords     int    `json:"total_records,omitempty"`
+	ApproximateTotal bool   `json:"approximate_total,omitempty"`
+	NextCursor       string `json:"next_cursor,omitempty"`
+}
+
+// cursor is the position of the last record on a page. It is sent to the client as opaque base64 JSON, and records the sort
+// it was issued for, so a cursor can't be replayed against a different order and silently skip or repeat records
+type cursor struct {
+	Sort      string    `json:"s"`
+	ID        int64     `json:"id"`
+	CreatedAt time.Time `json:"t,omitempty"`
+}
+
+// encodeCursor returns the next_cursor for a page ending at the given record
+func encodeCursor(sort string, id int64, createdAt time.Time) string {
+	c := cursor{Sort: sort, ID: id}
+	if strings.TrimPrefix(sort, "-") == "created_at" {
+		c.CreatedAt = createdAt
+	}
+
+	js, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(js)
+}
+
+// decodeCursor returns the position the client's cursor points at, or nil for the first page
+func (f Filters) decodeCursor() (*cursor, error) {
+	if f.Cursor == "" {
+		return nil, nil
+	}
+
+	js, err := base64.RawURLEncoding.DecodeString(f.Cursor)
+	if err != nil {
+		return nil, ErrInvalidCursor
+	}
+
+	var c cursor
+
+	err = json.Unmarshal(js, &c)
+	if err != nil || c.Sort != f.Sort || c.ID < 1 {
+		return nil, ErrInvalidCursor
+	}
+
+	return &c, nil
 }
 
 // calculateMetadata is a helper function that calculates the metadata for a response
@@ -51,14 +123,14 @@ func (f Filters) offset() int {
 
 // check that the client provided sort field matches one of the safe values in the SortSafeList
 // if it does, return the field name without the "-" prefix
-// if it doesn't, panic with a message indicating that the client provided an unsafe value
-func (f Filters) sortColumn() string {
+// if it doesn't, return ErrUnsafeSort, since the value is interpolated into the SQL query and must never reach it
+func (f Filters) sortColumn() (string, error) {
 	for _, safeValue := range f.SortSafeList {
 		if f.Sort == safeValue {
-			return strings.TrimPrefix(f.Sort, "-")
+			return strings.TrimPrefix(f.Sort, "-"), nil
 		}
 	}
-	panic("unsafe sort parameter: " + f.Sort)
+	return "", fmt.Errorf("%w: %q", ErrUnsafeSort, f.Sort)
 }
 
 // Return the sort direction (ASC or DESC) based on the prefix of the Sort field
@@ -72,10 +144,17 @@ func (f Filters) sortDirection() string {
 func ValidateFilters(v *validator.Validator, f Filters) {
 	// check that the page and page_size parameters contain sensible values
 	v.Check(f.Page > 0, "page", "must be greater than zero")
-	v.Check(f.Page <= 10_000_000, "page", "must be a maximum of 10 million")
+	v.Check(f.Page <= MaxPage, "page", "must be a maximum of 10 million")
 	v.Check(f.PageSize > 0, "page_size", "must be greater than zero")
-	v.Check(f.PageSize <= 100, "page_size", "must be a maximum of 100")
+	v.Check(f.PageSize <= MaxPageSize, "page_size", fmt.Sprintf("must be a maximum of %d", MaxPageSize))
+
+	// check that the sort parameter matches a value in the safe list, listing the allowed values so the client can fix the request
+	v.Check(validator.In(f.Sort, f.SortSafeList...), "sort", "must be one of: "+strings.Join(f.SortSafeList, ", "))
 
-	// check that the sort parameter matches a value in the safe list
-	v.Check(validator.In(f.Sort, f.SortSafeList...), "sort", "invalid sort value")
+	if f.CursorMode {
+		v.Check(validator.In(f.Sort, CursorSortSafeList...), "sort", "must be one of "+strings.Join(CursorSortSafeList, ", ")+" when paginating with a cursor")
+
+		_, err := f.decodeCursor()
+		v.Check(err == nil, "cursor", "must be a next_cursor value returned for the same sort")
+	}
 }
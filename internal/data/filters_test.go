@@ -0,0 +1,144 @@
+package data
+
+import (
+	"errors"
+	"math"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/nytro04/greenlight/internal/validator"
+	"pgregory.net/rapid"
+)
+
+var movieSortSafeList = []string{"id", "title", "year", "runtime", "-id", "-title", "-year", "-runtime"}
+
+// TestCalculateMetadataProperties checks that the pagination metadata always describes enough pages to hold every record,
+// without an extra empty page at the end
+func TestCalculateMetadataProperties(t *testing.T) {
+	rapid.Check(t, func(t *rapid.T) {
+		totalRecords := rapid.IntRange(0, 1_000_000_000).Draw(t, "totalRecords")
+		page := rapid.IntRange(1, 10_000_000).Draw(t, "page")
+		pageSize := rapid.IntRange(1, 100).Draw(t, "pageSize")
+
+		metadata := calculateMetadata(totalRecords, page, pageSize)
+
+		if totalRecords == 0 {
+			if metadata != (Metadata{}) {
+				t.Fatalf("got %+v for no records, want empty metadata", metadata)
+			}
+			return
+		}
+
+		if metadata.LastPage*metadata.PageSize < metadata.TotalRecords {
+			t.Fatalf("last page %d with page size %d can't hold %d records", metadata.LastPage, metadata.PageSize, metadata.TotalRecords)
+		}
+
+		if (metadata.LastPage-1)*metadata.PageSize >= metadata.TotalRecords {
+			t.Fatalf("last page %d with page size %d is empty for %d records", metadata.LastPage, metadata.PageSize, metadata.TotalRecords)
+		}
+
+		if metadata.FirstPage != 1 || metadata.LastPage < metadata.FirstPage {
+			t.Fatalf("got first page %d and last page %d", metadata.FirstPage, metadata.LastPage)
+		}
+
+		if metadata.CurrentPage != page || metadata.PageSize != pageSize || metadata.TotalRecords != totalRecords {
+			t.Fatalf("got %+v for page %d, page size %d and %d records", metadata, page, pageSize, totalRecords)
+		}
+	})
+}
+
+// TestFiltersProperties checks that any filters which pass validation give a positive limit, a non-negative offset and
+// a sort column from the safe list, and that any sort value outside the safe list is rejected with an error rather than a panic
+func TestFiltersProperties(t *testing.T) {
+	rapid.Check(t, func(t *rapid.T) {
+		filters := Filters{
+			Page:         rapid.IntRange(-100, 20_000_000).Draw(t, "page"),
+			PageSize:     rapid.IntRange(-100, 200).Draw(t, "pageSize"),
+			Sort:         rapid.OneOf(rapid.SampledFrom(movieSortSafeList), rapid.String()).Draw(t, "sort"),
+			SortSafeList: movieSortSafeList,
+		}
+
+		column, err := filters.sortColumn()
+		if validator.In(filters.Sort, movieSortSafeList...) {
+			if err != nil || !validator.In(column, movieSortSafeList...) {
+				t.Fatalf("safe sort %q gave column %q and error %v", filters.Sort, column, err)
+			}
+		} else if !errors.Is(err, ErrUnsafeSort) {
+			t.Fatalf("unsafe sort %q gave column %q and error %v, want ErrUnsafeSort", filters.Sort, column, err)
+		}
+
+		v := validator.New()
+		if ValidateFilters(v, filters); !v.Valid() {
+			return
+		}
+
+		if err != nil {
+			t.Fatalf("sort %q passed validation but sortColumn returned %v", filters.Sort, err)
+		}
+
+		if filters.limit() <= 0 || filters.offset() < 0 {
+			t.Fatalf("page %d, page size %d gave limit %d and offset %d", filters.Page, filters.PageSize, filters.limit(), filters.offset())
+		}
+	})
+}
+
+// FuzzFilters checks that any page, page size and sort value which passes ValidateFilters can be turned into the
+// ORDER BY, LIMIT and OFFSET clauses without panicking or producing a negative limit or offset
+func FuzzFilters(f *testing.F) {
+	f.Add(1, 20, "id")
+	f.Add(10_000_000, 100, "-runtime")
+	f.Add(0, 0, "")
+	f.Add(-1, 101, "title; DROP TABLE movies")
+	f.Add(1, 20, "--id")
+
+	f.Fuzz(func(t *testing.T, page, pageSize int, sort string) {
+		filters := Filters{Page: page, PageSize: pageSize, Sort: sort, SortSafeList: movieSortSafeList}
+
+		v := validator.New()
+		if ValidateFilters(v, filters); !v.Valid() {
+			return
+		}
+
+		column, err := filters.sortColumn()
+		if err != nil {
+			t.Fatalf("sort %q passed validation but sortColumn returned %v", sort, err)
+		}
+
+		if !validator.In(column, movieSortSafeList...) {
+			t.Fatalf("sort %q gave column %q which isn't in the safe list", sort, column)
+		}
+
+		if filters.limit() <= 0 || filters.offset() < 0 {
+			t.Fatalf("page %d, page size %d gave limit %d and offset %d", page, pageSize, filters.limit(), filters.offset())
+		}
+	})
+}
+
+// TestCursorProperties checks that a cursor always decodes back to the position it was issued for, and is rejected
+// rather than misread when it is sent with a different sort
+func TestCursorProperties(t *testing.T) {
+	rapid.Check(t, func(t *rapid.T) {
+		sort := rapid.SampledFrom(CursorSortSafeList).Draw(t, "sort")
+		id := rapid.Int64Range(1, math.MaxInt64).Draw(t, "id")
+		createdAt := time.Unix(rapid.Int64Range(0, 4_000_000_000).Draw(t, "createdAt"), 0).UTC()
+
+		filters := Filters{Sort: sort, SortSafeList: CursorSortSafeList, CursorMode: true, Cursor: encodeCursor(sort, id, createdAt)}
+
+		c, err := filters.decodeCursor()
+		if err != nil {
+			t.Fatalf("cursor for sort %q and id %d gave error %v", sort, id, err)
+		}
+
+		if c.ID != id || (strings.HasSuffix(sort, "created_at") && !c.CreatedAt.Equal(createdAt)) {
+			t.Fatalf("cursor for id %d at %v decoded to %+v", id, createdAt, c)
+		}
+
+		filters.Sort = rapid.SampledFrom(CursorSortSafeList).Filter(func(s string) bool { return s != sort }).Draw(t, "otherSort")
+
+		_, err = filters.decodeCursor()
+		if !errors.Is(err, ErrInvalidCursor) {
+			t.Fatalf("cursor for sort %q sent with sort %q gave error %v, want ErrInvalidCursor", sort, filters.Sort, err)
+		}
+	})
+}
@@ -0,0 +1,159 @@
+package data
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"time"
+
+	"github.com/nytro04/greenlight/internal/clock"
+)
+
+var (
+	// ErrIdempotencyKeyInUse is returned by Begin when the first request with the key is still being handled
+	ErrIdempotencyKeyInUse = errors.New("idempotency key in use")
+
+	// ErrIdempotencyKeyReused is returned by Begin when the key was first used for a different request
+	ErrIdempotencyKeyReused = errors.New("idempotency key reused")
+)
+
+// IdempotentResponse is the response to the first request made with an idempotency key, which is replayed for the
+// retries of the request
+type IdempotentResponse struct {
+	Status  int
+	Headers map[string]string
+	Body    []byte
+}
+
+// IdempotencyModel keeps the responses to the requests made with an Idempotency-Key header, keyed by the user who
+// made the request and the key
+type IdempotencyModel struct {
+	DB    DBTX
+	Clock clock.Clock
+}
+
+// Begin claims the key for a request, identified by the hash of the request. It returns nil when the request should be
+// handled, either because the key is new or because it has expired, and the stored response when the request is a
+// retry of one which has been handled
+func (m IdempotencyModel) Begin(ctx context.Context, userID int64, key string, requestHash []byte, ttl time.Duration) (*IdempotentResponse, error) {
+	now := m.Clock.Now()
+
+	// an expired key is claimed again as if it were new
+	query := `
+		INSERT INTO idempotency_keys (user_id, key, request_hash, created_at, expires_at)
+		VALUES ($1, $2, $3, $4, $5)
+		ON CONFLICT (user_id, key) DO UPDATE
+		SET request_hash = EXCLUDED.request_hash, created_at = EXCLUDED.created_at, expires_at = EXCLUDED.expires_at,
+		status = 0, headers = '{}', body = ''
+		WHERE idempotency_keys.expires_at <= $4
+		RETURNING true`
+
+	ctx, cancel := withWriteTimeout(ctx)
+	defer cancel()
+
+	var claimed bool
+
+	err := m.DB.QueryRowContext(ctx, query, userID, key, requestHash, now, now.Add(ttl)).Scan(&claimed)
+	switch {
+	case err == nil:
+		return nil, nil
+	case !errors.Is(err, sql.ErrNoRows):
+		return nil, err
+	}
+
+	query = `
+		SELECT request_hash, status, headers, body
+		FROM idempotency_keys
+		WHERE user_id = $1 AND key = $2`
+
+	var storedHash, headers []byte
+	response := IdempotentResponse{}
+
+	err = m.DB.QueryRowContext(ctx, query, userID, key).Scan(&storedHash, &response.Status, &headers, &response.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	switch {
+	case !bytes.Equal(storedHash, requestHash):
+		return nil, ErrIdempotencyKeyReused
+	case response.Status == 0:
+		return nil, ErrIdempotencyKeyInUse
+	}
+
+	err = json.Unmarshal(headers, &response.Headers)
+	if err != nil {
+		return nil, err
+	}
+
+	return &response, nil
+}
+
+// Complete stores the response to the request which claimed the key, to be replayed for its retries
+func (m IdempotencyModel) Complete(ctx context.Context, userID int64, key string, response *IdempotentResponse) error {
+	headers, err := json.Marshal(response.Headers)
+	if err != nil {
+		return err
+	}
+
+	query := `
+		UPDATE idempotency_keys
+		SET status = $3, headers = $4, body = $5
+		WHERE user_id = $1 AND key = $2`
+
+	ctx, cancel := withWriteTimeout(ctx)
+	defer cancel()
+
+	_, err = m.DB.ExecContext(ctx, query, userID, key, response.Status, headers, response.Body)
+	return err
+}
+
+// Release removes a key whose request failed, so a retry is handled again rather than replaying the failure
+func (m IdempotencyModel) Release(ctx context.Context, userID int64, key string) error {
+	query := `
+		DELETE FROM idempotency_keys
+		WHERE user_id = $1 AND key = $2`
+
+	ctx, cancel := withWriteTimeout(ctx)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, query, userID, key)
+	return err
+}
+
+// DeleteExpired removes the keys which expired before the given time, returning how many were removed
+func (m IdempotencyModel) DeleteExpired(before time.Time) (int64, error) {
+	query := `
+		DELETE FROM idempotency_keys
+		WHERE expires_at < $1`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, query, before)
+	if err != nil {
+		return 0, err
+	}
+
+	return result.RowsAffected()
+}
+
+type MockIdempotencyModel struct{}
+
+func (m MockIdempotencyModel) Begin(ctx context.Context, userID int64, key string, requestHash []byte, ttl time.Duration) (*IdempotentResponse, error) {
+	return nil, nil
+}
+
+func (m MockIdempotencyModel) Complete(ctx context.Context, userID int64, key string, response *IdempotentResponse) error {
+	return nil
+}
+
+func (m MockIdempotencyModel) Release(ctx context.Context, userID int64, key string) error {
+	return nil
+}
+
+func (m MockIdempotencyModel) DeleteExpired(before time.Time) (int64, error) {
+	return 0, nil
+}
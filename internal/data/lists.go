@@ -0,0 +1,430 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/nytro04/greenlight/internal/validator"
+)
+
+// ErrListOrderMismatch is returned when a list is reordered with movie IDs which aren't exactly the movies on the list
+var ErrListOrderMismatch = errors.New("list order mismatch")
+
+// MovieList is a named list of movies put together by a user, such as "Best heist movies", in the order they choose.
+// A public list can be shared with anyone by its URL, a private one is only ever shown to its owner
+type MovieList struct {
+	ID          int64     `json:"id"`
+	CreatedAt   time.Time `json:"created_at"`
+	UpdatedAt   time.Time `json:"updated_at"`
+	UserID      int64     `json:"user_id"`
+	Name        string    `json:"name"`
+	Description string    `json:"description"`
+	Public      bool      `json:"public"`
+	MovieCount  int       `json:"movie_count"`
+	Version     int32     `json:"version"`
+}
+
+func ValidateMovieList(v *validator.Validator, list *MovieList) {
+	v.Check(list.Name != "", "name", "must be provided")
+	v.Check(len(list.Name) <= 100, "name", "must not be more than 100 bytes long")
+	v.Check(len(list.Description) <= 1000, "description", "must not be more than 1000 bytes long")
+}
+
+type MovieListModel struct {
+	DB     DBTX
+	Reader DBTX // runs the read-only queries, on the read replica if one is configured
+}
+
+// Insert creates an empty list
+func (m MovieListModel) Insert(ctx context.Context, list *MovieList) error {
+	query := `
+		INSERT INTO lists (user_id, name, description, public)
+		VALUES ($1, $2, $3, $4)
+		RETURNING id, created_at, updated_at, version`
+
+	args := []interface{}{list.UserID, list.Name, list.Description, list.Public}
+
+	ctx, cancel := withWriteTimeout(ctx)
+	defer cancel()
+
+	return m.DB.QueryRowContext(ctx, query, args...).Scan(&list.ID, &list.CreatedAt, &list.UpdatedAt, &list.Version)
+}
+
+// Get returns the list with the given ID, public or not, or ErrRecordNotFound
+func (m MovieListModel) Get(ctx context.Context, id int64) (*MovieList, error) {
+	if id < 1 {
+		return nil, ErrRecordNotFound
+	}
+
+	query := `
+		SELECT id, created_at, updated_at, user_id, name, description, public,
+		(SELECT count(*) FROM list_movies WHERE list_id = lists.id), version
+		FROM lists
+		WHERE id = $1`
+
+	var list MovieList
+
+	ctx, cancel := withReadTimeout(ctx)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, id).Scan(
+		&list.ID,
+		&list.CreatedAt,
+		&list.UpdatedAt,
+		&list.UserID,
+		&list.Name,
+		&list.Description,
+		&list.Public,
+		&list.MovieCount,
+		&list.Version,
+	)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	return &list, nil
+}
+
+// GetAllForUser returns a page of the lists of a user, the private ones included
+func (m MovieListModel) GetAllForUser(ctx context.Context, userID int64, filters Filters) ([]*MovieList, Metadata, error) {
+	sortColumn, err := filters.sortColumn()
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+
+	query := fmt.Sprintf(`
+		SELECT count(*) OVER(), id, created_at, updated_at, user_id, name, description, public,
+		(SELECT count(*) FROM list_movies WHERE list_id = lists.id), version
+		FROM lists
+		WHERE user_id = $1
+		ORDER BY %s %s, id ASC
+		LIMIT $2 OFFSET $3`, sortColumn, filters.sortDirection())
+
+	ctx, cancel := withReadTimeout(ctx)
+	defer cancel()
+
+	rows, err := m.Reader.QueryContext(ctx, query, userID, filters.limit(), filters.offset())
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+	defer rows.Close()
+
+	totalRecords := 0
+	lists := []*MovieList{}
+
+	for rows.Next() {
+		var list MovieList
+
+		err := rows.Scan(
+			&totalRecords,
+			&list.ID,
+			&list.CreatedAt,
+			&list.UpdatedAt,
+			&list.UserID,
+			&list.Name,
+			&list.Description,
+			&list.Public,
+			&list.MovieCount,
+			&list.Version,
+		)
+		if err != nil {
+			return nil, Metadata{}, err
+		}
+
+		lists = append(lists, &list)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, Metadata{}, err
+	}
+
+	metadata := calculateMetadata(totalRecords, filters.Page, filters.PageSize)
+
+	return lists, metadata, nil
+}
+
+// Update saves the name, description and privacy of a list, using the version number to detect concurrent edits
+func (m MovieListModel) Update(ctx context.Context, list *MovieList) error {
+	query := `
+		UPDATE lists
+		SET name = $1, description = $2, public = $3, updated_at = NOW(), version = version + 1
+		WHERE id = $4 AND version = $5
+		RETURNING updated_at, version`
+
+	args := []interface{}{list.Name, list.Description, list.Public, list.ID, list.Version}
+
+	ctx, cancel := withWriteTimeout(ctx)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, args...).Scan(&list.UpdatedAt, &list.Version)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrEditConflict
+		default:
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Delete removes a list along with its movies
+func (m MovieListModel) Delete(ctx context.Context, id int64) error {
+	if id < 1 {
+		return ErrRecordNotFound
+	}
+
+	query := `
+		DELETE FROM lists
+		WHERE id = $1`
+
+	ctx, cancel := withWriteTimeout(ctx)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, query, id)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+
+	return nil
+}
+
+// AddMovie puts a movie at the end of a list. Adding a movie which is already on the list leaves it where it is, and
+// ErrRecordNotFound is returned if the movie doesn't exist
+func (m MovieListModel) AddMovie(ctx context.Context, listID, movieID int64) error {
+	// updating the list first locks it, so two movies added at the same time are given positions one after the other
+	query := `
+		WITH list AS (
+			UPDATE lists
+			SET updated_at = NOW()
+			WHERE id = $1
+			RETURNING id
+		)
+		INSERT INTO list_movies (list_id, movie_id, position)
+		SELECT id, $2, COALESCE((SELECT max(position) FROM list_movies WHERE list_id = $1), 0) + 1
+		FROM list
+		ON CONFLICT DO NOTHING`
+
+	ctx, cancel := withWriteTimeout(ctx)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, query, listID, movieID)
+	if err != nil {
+		switch {
+		case isForeignKeyViolation(err, "list_movies_movie_id_fkey"):
+			return ErrRecordNotFound
+		default:
+			return err
+		}
+	}
+
+	return nil
+}
+
+// RemoveMovie takes a movie off a list, returning ErrRecordNotFound if it wasn't on it
+func (m MovieListModel) RemoveMovie(ctx context.Context, listID, movieID int64) error {
+	query := `
+		WITH removed AS (
+			DELETE FROM list_movies
+			WHERE list_id = $1 AND movie_id = $2
+			RETURNING list_id
+		)
+		UPDATE lists
+		SET updated_at = NOW()
+		WHERE id IN (SELECT list_id FROM removed)`
+
+	ctx, cancel := withWriteTimeout(ctx)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, query, listID, movieID)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+
+	return nil
+}
+
+// Reorder puts the movies of a list in the order of the movie IDs, which must hold each movie on the list exactly
+// once. ErrListOrderMismatch is returned otherwise, and the list is left as it was
+func (m MovieListModel) Reorder(ctx context.Context, listID int64, movieIDs []int64) error {
+	ctx, cancel := withWriteTimeout(ctx)
+	defer cancel()
+
+	tx, err := beginTx(ctx, m.DB)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	// the list is locked before its movies are counted, so no movie can be added or removed in between
+	query := `
+		UPDATE lists
+		SET updated_at = NOW()
+		WHERE id = $1`
+
+	_, err = tx.ExecContext(ctx, query, listID)
+	if err != nil {
+		return err
+	}
+
+	query = `
+		UPDATE list_movies
+		SET position = reordered.position
+		FROM unnest($2::bigint[]) WITH ORDINALITY AS reordered (movie_id, position)
+		WHERE list_movies.list_id = $1 AND list_movies.movie_id = reordered.movie_id`
+
+	result, err := tx.ExecContext(ctx, query, listID, movieIDs)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	var count int
+
+	err = tx.QueryRowContext(ctx, `SELECT count(*) FROM list_movies WHERE list_id = $1`, listID).Scan(&count)
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected != int64(len(movieIDs)) || count != len(movieIDs) {
+		return ErrListOrderMismatch
+	}
+
+	return tx.Commit()
+}
+
+// GetMovies returns a page of the movies on a list. As well as the movie columns, the results can be sorted by
+// position, the order the owner has put the movies in, and added_at, the time each movie was added to the list
+func (m MovieListModel) GetMovies(ctx context.Context, listID int64, filters Filters) ([]*Movie, Metadata, error) {
+	sortColumn, err := filters.sortColumn()
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+
+	query := fmt.Sprintf(`
+		SELECT count(*) OVER(), movies.id, movies.created_at, movies.updated_at, title, year, runtime, genres, movies.version, poster_key,
+		average_rating, review_count, language, country
+		FROM movies
+		INNER JOIN list_movies ON list_movies.movie_id = movies.id
+		WHERE list_movies.list_id = $1
+		ORDER BY %s %s, movies.id ASC
+		LIMIT $2 OFFSET $3`, sortColumn, filters.sortDirection())
+
+	ctx, cancel := withReadTimeout(ctx)
+	defer cancel()
+
+	rows, err := m.Reader.QueryContext(ctx, query, listID, filters.limit(), filters.offset())
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+	defer rows.Close()
+
+	totalRecords := 0
+	movies := []*Movie{}
+
+	for rows.Next() {
+		var movie Movie
+
+		err := rows.Scan(
+			&totalRecords,
+			&movie.ID,
+			&movie.CreatedAt,
+			&movie.UpdatedAt,
+			&movie.Title,
+			&movie.Year,
+			&movie.Runtime,
+			scanArray(&movie.Genres),
+			&movie.Version,
+			&movie.PosterKey,
+			&movie.AverageRating,
+			&movie.ReviewCount,
+			&movie.Language,
+			&movie.Country,
+		)
+		if err != nil {
+			return nil, Metadata{}, err
+		}
+
+		movie.setPosterURL()
+
+		movies = append(movies, &movie)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, Metadata{}, err
+	}
+
+	metadata := calculateMetadata(totalRecords, filters.Page, filters.PageSize)
+
+	return movies, metadata, nil
+}
+
+// Mock data for testing
+type MockMovieListModel struct{}
+
+func (m MockMovieListModel) Insert(ctx context.Context, list *MovieList) error {
+	return nil
+}
+
+func (m MockMovieListModel) Get(ctx context.Context, id int64) (*MovieList, error) {
+	return nil, ErrRecordNotFound
+}
+
+func (m MockMovieListModel) GetAllForUser(ctx context.Context, userID int64, filters Filters) ([]*MovieList, Metadata, error) {
+	return nil, Metadata{}, nil
+}
+
+func (m MockMovieListModel) Update(ctx context.Context, list *MovieList) error {
+	return nil
+}
+
+func (m MockMovieListModel) Delete(ctx context.Context, id int64) error {
+	return nil
+}
+
+func (m MockMovieListModel) AddMovie(ctx context.Context, listID, movieID int64) error {
+	return nil
+}
+
+func (m MockMovieListModel) RemoveMovie(ctx context.Context, listID, movieID int64) error {
+	return nil
+}
+
+func (m MockMovieListModel) Reorder(ctx context.Context, listID int64, movieIDs []int64) error {
+	return nil
+}
+
+func (m MockMovieListModel) GetMovies(ctx context.Context, listID int64, filters Filters) ([]*Movie, Metadata, error) {
+	return nil, Metadata{}, nil
+}
@@ -1,9 +1,14 @@
 package data
 
 import (
+	"context"
 	"database/sql"
 	"errors"
+	"io"
 	"time"
+
+	"github.com/go-webauthn/webauthn/webauthn"
+	"github.com/nytro04/greenlight/internal/clock"
 )
 
 var (
@@ -11,52 +16,339 @@ var (
 	ErrEditConflict   = errors.New("edit conflict") // error returned when the version number of the record in the database doesn't match the version number in the request
 )
 
+// Models holds the models the handlers read and write the database through. The methods of the Movies, Users, Tokens
+// and Permissions models take the context of the request they are called for, so their queries are cancelled when the
+// client goes away and the request's trace and deadline carry through to the database. Each query still gets its own
+// read or write timeout on top of that context
 type Models struct {
 
 	// Set the movies field to an interface type containing the methods
 	// that both the real and mock movie models must implement(needs to support)
 	Movies interface {
-		GetAll(title string, genres []string, filters Filters) ([]*Movie, Metadata, error)
-		Insert(movie *Movie) error
-		Get(id int64) (*Movie, error)
-		Update(movie *Movie) error
-		Delete(id int64) error
+		GetAll(ctx context.Context, search MovieSearch, genres, tags []string, origin MovieOrigin, ranges MovieRanges, filters Filters) ([]*Movie, Metadata, error)
+		Export(ctx context.Context, search MovieSearch, genres, tags []string, origin MovieOrigin, ranges MovieRanges, fn func(movie *Movie) error) error
+		Insert(ctx context.Context, movie *Movie) error
+		InsertMany(ctx context.Context, movies []*Movie) error
+		Get(ctx context.Context, id int64) (*Movie, error)
+		GetForWrite(ctx context.Context, id int64) (*Movie, error)
+		Update(ctx context.Context, movie *Movie) error
+		UpdatePoster(ctx context.Context, movie *Movie) error
+		Delete(ctx context.Context, id int64) error
+		Stats(ctx context.Context) (*MovieStats, error)
+		GetRandom(ctx context.Context, search MovieSearch, genres, tags []string, origin MovieOrigin, ranges MovieRanges) (*Movie, error)
+	}
+
+	Views interface {
+		Add(ctx context.Context, counts map[int64]int64, at time.Time) error
+		Trending(ctx context.Context, since time.Time, filters Filters) ([]*TrendingMovie, Metadata, error)
+		DeleteBefore(before time.Time) (int64, error)
 	}
 
 	Users interface {
-		Insert(user *User) error
-		GetByEmail(email string) (*User, error)
-		Update(user *User) error
-		GetTokenUser(scope, tokenPlaintext string) (*User, error)
+		Insert(ctx context.Context, user *User) error
+		GetByEmail(ctx context.Context, email string) (*User, error)
+		GetByID(ctx context.Context, id int64) (*User, error)
+		GetByIDs(ctx context.Context, ids ...int64) (map[int64]*User, error)
+		Update(ctx context.Context, user *User) error
+		UpdatePassword(ctx context.Context, user *User) error
+		UpdatePasswordHash(ctx context.Context, user *User) error
+		Disable(ctx context.Context, user *User) error
+		Enable(ctx context.Context, user *User) error
+		SetPendingEmail(ctx context.Context, userID int64, email string) error
+		GetPendingEmail(ctx context.Context, userID int64) (string, error)
+		ConfirmEmail(ctx context.Context, user *User, email string) error
+		GetAll(ctx context.Context, email string, activated *bool, filters Filters) ([]*User, Metadata, error)
+		Delete(ctx context.Context, id int64) error
+		ScheduleDeletion(ctx context.Context, userID int64, dueAt time.Time) error
+		CancelDeletion(ctx context.Context, userID int64) error
+		DeleteDue(ctx context.Context) ([]string, int64, error)
+		UpdateAvatar(ctx context.Context, user *User) error
+		GetTokensValidAfter(ctx context.Context, userID int64) (time.Time, error)
+		RevokeSignedTokens(ctx context.Context, userID int64) error
+		GetTokenUser(ctx context.Context, scope, tokenPlaintext string) (*User, error)
 	}
 
 	Tokens interface {
-		New(userID int64, ttl time.Duration, scope string) (*Token, error)
-		Insert(token *Token) error
-		DeleteAllForUser(scope string, userID int64) error
+		New(ctx context.Context, userID int64, ttl time.Duration, scope string) (*Token, error)
+		Insert(ctx context.Context, token *Token) error
+		DeleteAllForUser(ctx context.Context, scope string, userID int64) error
+		Delete(ctx context.Context, scope string, userID int64, tokenPlaintext string) error
+		IssuedSince(ctx context.Context, scope string, userID int64, since time.Time) ([]time.Time, error)
+		DeleteExpired(before time.Time) (map[string]int64, error)
+		NewSession(ctx context.Context, userID int64, ttl time.Duration, ipAddress, userAgent string) (*Token, error)
+		GetSessions(ctx context.Context, userID int64, currentPlaintext string) ([]*Session, error)
+		DeleteSession(ctx context.Context, userID, id int64) error
+		Touch(ctx context.Context, tokenPlaintext string) error
 	}
 
 	Permissions interface {
-		GetAllForUser(UserID int64) (Permissions, error)
-		AddForUser(userID int64, codes ...string) error
+		GetAllForUser(ctx context.Context, userID int64) (Permissions, error)
+		AddForUser(ctx context.Context, userID int64, codes ...string) error
+		RemoveForUser(ctx context.Context, userID int64, codes ...string) error
+		GetAll(ctx context.Context) (Permissions, error)
+	}
+
+	Roles interface {
+		Insert(role *Role) error
+		GetAll() ([]*Role, error)
+		GetPermissions(names ...string) (Permissions, error)
+		Delete(id int64) error
+	}
+
+	Audit interface {
+		Insert(event *AuditEvent) error
+		DeleteExpired(category string, before time.Time) (int64, error)
+		Export(ctx context.Context, from, to time.Time, fn func(event *AuditEvent) error) error
+	}
+
+	SecurityEvents interface {
+		Insert(event *SecurityEvent) error
+		GetAll(eventType string, userID int64, filters Filters) ([]*SecurityEvent, Metadata, error)
+	}
+
+	Passkeys interface {
+		Insert(passkey *Passkey) error
+		GetAllForUser(userID int64) ([]*Passkey, error)
+		UpdateCredential(userID int64, credential *webauthn.Credential) error
+		Delete(id, userID int64) error
+		NewSession(userID int64, session *webauthn.SessionData, ttl time.Duration) (string, error)
+		ConsumeSession(userID int64, sessionPlaintext string) (*webauthn.SessionData, error)
+	}
+
+	TOTP interface {
+		Get(userID int64) (*TOTP, error)
+		Enroll(userID int64, issuer, accountName string) (*TOTPEnrollment, error)
+		Verify(t *TOTP, code string) (bool, error)
+		Enable(userID int64) ([]string, error)
+		NewRecoveryCodes(userID int64) ([]string, error)
+		UseRecoveryCode(userID int64, code string) (bool, error)
+		Delete(userID int64) error
+	}
+
+	OAuth interface {
+		NewState(provider string, ttl time.Duration) (string, string, error)
+		ConsumeState(provider, statePlaintext string) (string, error)
+		GetUser(provider, subject string) (*User, error)
+		Link(userID int64, provider, subject string) error
+	}
+
+	Organizations interface {
+		Insert(org *Organization, ownerID int64) error
+		GetBySlug(slug string) (*Organization, error)
+		Get(id int64) (*Organization, error)
+		GetMemberRole(orgID, userID int64) (string, error)
+		SetMember(orgID, userID int64, role string) error
+		GetSSO(orgID int64) (*OrganizationSSO, error)
+		SetSSO(sso *OrganizationSSO) error
+		NewSSOState(orgID int64, ttl time.Duration) (string, string, error)
+		ConsumeSSOState(orgID int64, statePlaintext string) (string, error)
+	}
+
+	Reviews interface {
+		Insert(review *Review) error
+		Get(movieID, id int64) (*Review, error)
+		GetAllForMovie(movieID int64, filters Filters) ([]*Review, Metadata, error)
+		GetAllForMovies(movieIDs []int64, filters Filters) (map[int64][]*Review, map[int64]Metadata, error)
+		Update(review *Review) error
+		Delete(id int64) error
+	}
+
+	Watchlist interface {
+		Add(userID, movieID int64) error
+		Remove(userID, movieID int64) error
+		GetAll(userID int64, filters Filters) ([]*Movie, Metadata, error)
+	}
+
+	Moderation interface {
+		Report(ctx context.Context, report *ReviewReport) error
+		GetReported(ctx context.Context, filters Filters) ([]*ReportedReview, Metadata, error)
+		GetReview(ctx context.Context, id int64) (*Review, []*ReviewReport, []*ModerationDecision, error)
+		Decide(ctx context.Context, decision *ModerationDecision) error
+	}
+
+	Tags interface {
+		AddToMovie(ctx context.Context, movieID, userID int64, names []string) error
+		RemoveFromMovie(ctx context.Context, movieID int64, name string) error
+		GetForMovie(ctx context.Context, movieID int64, name string) (*MovieTag, error)
+		GetForMovies(ctx context.Context, movieIDs ...int64) (map[int64][]*MovieTag, error)
+		Suggest(ctx context.Context, userID int64, prefix string, limit int) ([]*TagSuggestion, error)
+	}
+
+	Favorites interface {
+		Add(ctx context.Context, userID, movieID int64) error
+		Remove(ctx context.Context, userID, movieID int64) error
+		GetAll(ctx context.Context, userID int64, filters Filters) ([]*Movie, Metadata, error)
+		GetFavorited(ctx context.Context, userID int64, movieIDs []int64) (map[int64]bool, time.Time, error)
+	}
+
+	Lists interface {
+		Insert(ctx context.Context, list *MovieList) error
+		Get(ctx context.Context, id int64) (*MovieList, error)
+		GetAllForUser(ctx context.Context, userID int64, filters Filters) ([]*MovieList, Metadata, error)
+		Update(ctx context.Context, list *MovieList) error
+		Delete(ctx context.Context, id int64) error
+		AddMovie(ctx context.Context, listID, movieID int64) error
+		RemoveMovie(ctx context.Context, listID, movieID int64) error
+		Reorder(ctx context.Context, listID int64, movieIDs []int64) error
+		GetMovies(ctx context.Context, listID int64, filters Filters) ([]*Movie, Metadata, error)
+	}
+
+	Series interface {
+		Insert(ctx context.Context, series *Series) error
+		Get(ctx context.Context, id int64) (*Series, error)
+		GetAll(ctx context.Context, name string, filters Filters) ([]*Series, Metadata, error)
+		Update(ctx context.Context, series *Series) error
+		Delete(ctx context.Context, id int64) error
+		SetMovies(ctx context.Context, seriesID int64, movieIDs []int64) ([]*Movie, error)
+		GetMovies(ctx context.Context, seriesID int64) ([]*Movie, error)
+		GetEntries(ctx context.Context, movieIDs ...int64) (map[int64]*SeriesEntry, error)
+	}
+
+	People interface {
+		Insert(person *Person) error
+		Get(id int64) (*Person, error)
+		GetAll(name string, filters Filters) ([]*Person, Metadata, error)
+		Update(person *Person) error
+		Delete(id int64) error
+		AddCredit(credit *Credit) error
+		DeleteCredit(movieID, id int64) error
+		GetCreditsForMovies(movieIDs ...int64) (map[int64][]*Credit, error)
+		GetCreditsForPerson(personID int64) ([]*Credit, error)
 	}
+
+	APIKeys interface {
+		Insert(key *APIKey) error
+		GetAll(userID int64) ([]*APIKey, error)
+		Delete(id int64) error
+		GetUserForKey(plaintext string) (*User, *APIKey, error)
+	}
+
+	Quotas interface {
+		GetTiers(ctx context.Context) ([]*QuotaTier, error)
+		GetForUser(ctx context.Context, userID, keyID int64) ([]*APIKeyUsage, error)
+		Consume(ctx context.Context, key *APIKey) (*APIKeyUsage, error)
+	}
+
+	Webhooks interface {
+		Insert(webhook *Webhook) error
+		GetAll() ([]*Webhook, error)
+		Delete(id int64) error
+		Enqueue(event string, payloads ...[]byte) (int64, error)
+		ClaimDue(limit int, lease time.Duration) ([]*WebhookDelivery, error)
+		MarkDelivered(id int64, responseStatus int) error
+		MarkFailed(id int64, responseStatus *int, lastError string, nextAttemptAt *time.Time) error
+		GetDeliveries(webhookID int64, status string, filters Filters) ([]*WebhookDelivery, Metadata, error)
+	}
+
+	Emails interface {
+		Insert(ctx context.Context, email *Email) error
+		ClaimDue(limit int, lease time.Duration) ([]*Email, error)
+		MarkSent(id int64) error
+		MarkFailed(id int64, lastError string, nextAttemptAt *time.Time) error
+	}
+
+	EmailTemplates interface {
+		Insert(ctx context.Context, tmpl *EmailTemplate) error
+		GetLatest(ctx context.Context, name string) (*EmailTemplate, error)
+		GetVersions(ctx context.Context, name string) ([]*EmailTemplate, error)
+		GetAllLatest(ctx context.Context) ([]*EmailTemplate, error)
+		Delete(ctx context.Context, name string) error
+	}
+
+	Idempotency interface {
+		Begin(ctx context.Context, userID int64, key string, requestHash []byte, ttl time.Duration) (*IdempotentResponse, error)
+		Complete(ctx context.Context, userID int64, key string, response *IdempotentResponse) error
+		Release(ctx context.Context, userID int64, key string) error
+		DeleteExpired(before time.Time) (int64, error)
+	}
+
+	// the pool, clock and source of randomness are kept so WithTx can create the models again on a transaction
+	db     *sql.DB
+	clock  clock.Clock
+	random io.Reader
+
+	movieHooks *movieHooks // the hooks registered with OnMovieChanged
+}
+
+// NewModels creates the models on the connection pool. If a read replica is given the catalog models, the movies,
+// people and reviews, run their read-only queries on it, falling back to the primary when it can't be reached
+func NewModels(db *sql.DB, replica *sql.DB, clk clock.Clock, rnd io.Reader) Models {
+	var reader DBTX = db
+	if replica != nil {
+		reader = &replicaDB{primary: db, replica: replica, clock: clk}
+	}
+
+	models := newModels(db, reader, clk, rnd, &movieHooks{})
+	models.db = db
+
+	return models
 }
 
-func NewModels(db *sql.DB) Models {
+// newModels creates the models running their queries on db, which is the pool or a transaction, and the read-only
+// queries of the catalog models on reader. The movie model tells hooks about its writes
+func newModels(db, reader DBTX, clk clock.Clock, rnd io.Reader, hooks *movieHooks) Models {
 	return Models{
-		Movies:      MovieModel{DB: db},
-		Users:       UserModel{DB: db},
-		Tokens:      TokenModel{DB: db},
-		Permissions: PermissionModel{DB: db},
+		Movies:         MovieModel{DB: db, Reader: reader, Random: rnd, hooks: hooks},
+		Views:          ViewModel{DB: db, Reader: reader},
+		Users:          UserModel{DB: db, Clock: clk},
+		Tokens:         TokenModel{DB: db, Clock: clk, Random: rnd},
+		Permissions:    PermissionModel{DB: db},
+		Audit:          AuditModel{DB: db},
+		SecurityEvents: SecurityEventModel{DB: db},
+		Passkeys:       PasskeyModel{DB: db, Clock: clk, Random: rnd},
+		TOTP:           TOTPModel{DB: db, Clock: clk, Random: rnd},
+		OAuth:          OAuthModel{DB: db, Clock: clk, Random: rnd},
+		Organizations:  OrganizationModel{DB: db, Clock: clk, Random: rnd},
+		Reviews:        ReviewModel{DB: db, Reader: reader},
+		Moderation:     ModerationModel{DB: db, Reader: reader},
+		Watchlist:      WatchlistModel{DB: db},
+		Favorites:      FavoriteModel{DB: db},
+		Tags:           TagModel{DB: db, Reader: reader},
+		Lists:          MovieListModel{DB: db, Reader: reader},
+		Series:         SeriesModel{DB: db, Reader: reader},
+		People:         PersonModel{DB: db, Reader: reader},
+		APIKeys:        APIKeyModel{DB: db, Clock: clk, Random: rnd},
+		Roles:          RoleModel{DB: db},
+		Webhooks:       WebhookModel{DB: db, Clock: clk, Random: rnd},
+		Emails:         EmailModel{DB: db, Clock: clk},
+		EmailTemplates: EmailTemplateModel{DB: db},
+		Idempotency:    IdempotencyModel{DB: db, Clock: clk},
+		Quotas:         QuotaModel{DB: db, Clock: clk},
+		clock:          clk,
+		random:         rnd,
+		movieHooks:     hooks,
 	}
 }
 
 // helper function which returns models instance containing the modal models only for testing
 func NewMockModels() Models {
 	return Models{
-		Movies:      MockMovieModel{},
-		Users:       MockUserModel{},
-		Tokens:      MockTokenModel{},
-		Permissions: PermissionModel{},
+		Movies:         MockMovieModel{},
+		Views:          MockViewModel{},
+		Users:          MockUserModel{},
+		Tokens:         MockTokenModel{},
+		Permissions:    MockPermissionModel{},
+		Audit:          MockAuditModel{},
+		SecurityEvents: MockSecurityEventModel{},
+		Passkeys:       MockPasskeyModel{},
+		TOTP:           MockTOTPModel{},
+		OAuth:          MockOAuthModel{},
+		Organizations:  MockOrganizationModel{},
+		Reviews:        MockReviewModel{},
+		Moderation:     MockModerationModel{},
+		Watchlist:      MockWatchlistModel{},
+		Favorites:      MockFavoriteModel{},
+		Tags:           MockTagModel{},
+		Lists:          MockMovieListModel{},
+		Series:         MockSeriesModel{},
+		People:         MockPersonModel{},
+		APIKeys:        MockAPIKeyModel{},
+		Roles:          MockRoleModel{},
+		Webhooks:       MockWebhookModel{},
+		Emails:         MockEmailModel{},
+		EmailTemplates: MockEmailTemplateModel{},
+		Idempotency:    MockIdempotencyModel{},
+		Quotas:         MockQuotaModel{},
+		movieHooks:     &movieHooks{},
 	}
 }
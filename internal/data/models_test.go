@@ -0,0 +1,58 @@
+package data
+
+import (
+	"context"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// TestNewMockModels checks every model is set to its mock, so a handler tested with the mock models never reaches a
+// real model without a database connection
+func TestNewMockModels(t *testing.T) {
+	models := reflect.ValueOf(NewMockModels())
+
+	for i := 0; i < models.NumField(); i++ {
+		// the unexported fields hold the state of the models rather than models
+		if !models.Type().Field(i).IsExported() {
+			continue
+		}
+
+		name := models.Type().Field(i).Name
+		field := models.Field(i)
+
+		if field.IsNil() {
+			t.Errorf("%s is not set", name)
+			continue
+		}
+
+		if typeName := field.Elem().Type().Name(); !strings.HasPrefix(typeName, "Mock") {
+			t.Errorf("%s is set to %s, want a mock", name, typeName)
+		}
+	}
+}
+
+// TestMovieHooksTransaction checks the changes made in a transaction only reach the hooks once it has been committed,
+// in the order they were made
+func TestMovieHooksTransaction(t *testing.T) {
+	models := NewMockModels()
+
+	var events []string
+	models.OnMovieChanged(func(ctx context.Context, change MovieChange) {
+		events = append(events, change.Event)
+	})
+
+	tx := &movieHooks{parent: models.movieHooks}
+	tx.notify(context.Background(), MovieChange{Event: WebhookEventMovieCreated, Movies: []*Movie{{ID: 1}}})
+	tx.notify(context.Background(), MovieChange{Event: WebhookEventMovieDeleted, Movies: []*Movie{{ID: 1}}})
+
+	if len(events) != 0 {
+		t.Fatalf("got %v before the commit, want no events", events)
+	}
+
+	tx.commit(context.Background())
+
+	if !reflect.DeepEqual(events, []string{WebhookEventMovieCreated, WebhookEventMovieDeleted}) {
+		t.Errorf("got %v after the commit, want the created and deleted events", events)
+	}
+}
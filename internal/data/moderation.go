@@ -0,0 +1,361 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/nytro04/greenlight/internal/validator"
+)
+
+// ErrDuplicateReport is returned when a user reports a review they have already reported
+var ErrDuplicateReport = errors.New("duplicate report")
+
+// The actions of a moderation decision. hide takes the review out of the listings and the rating of its movie, restore
+// puts a hidden review back, and dismiss leaves the review as it is. Every decision resolves the open reports of the review
+const (
+	ModerationActionHide    = "hide"
+	ModerationActionRestore = "restore"
+	ModerationActionDismiss = "dismiss"
+)
+
+// ModerationActions holds the actions a moderator can decide on
+var ModerationActions = []string{ModerationActionHide, ModerationActionRestore, ModerationActionDismiss}
+
+// ReviewReport is a user flagging a review for the moderators, with the reason they gave. ResolvedAt is set once a
+// moderator has made a decision about the review. The reports of a user who has deleted their account are kept with a
+// UserID of zero
+type ReviewReport struct {
+	ID         int64      `json:"id"`
+	CreatedAt  time.Time  `json:"created_at"`
+	ReviewID   int64      `json:"review_id"`
+	UserID     int64      `json:"user_id,omitempty"`
+	Reason     string     `json:"reason"`
+	ResolvedAt *time.Time `json:"resolved_at,omitempty"`
+}
+
+func ValidateReviewReport(v *validator.Validator, report *ReviewReport) {
+	v.Check(report.Reason != "", "reason", "must be provided")
+	v.Check(len(report.Reason) <= 1000, "reason", "must not be more than 1000 bytes long")
+}
+
+// ModerationDecision is the record of a moderator's decision about a review
+type ModerationDecision struct {
+	ID          int64     `json:"id"`
+	CreatedAt   time.Time `json:"created_at"`
+	ReviewID    int64     `json:"review_id"`
+	ModeratorID int64     `json:"moderator_id,omitempty"`
+	Action      string    `json:"action"`
+	Note        string    `json:"note"`
+}
+
+func ValidateModerationDecision(v *validator.Validator, decision *ModerationDecision) {
+	v.Check(validator.In(decision.Action, ModerationActions...), "action", "must be one of hide, restore or dismiss")
+	v.Check(len(decision.Note) <= 1000, "note", "must not be more than 1000 bytes long")
+}
+
+// ReportedReview is a review in the moderation queue, with the number of open reports about it and their reasons
+type ReportedReview struct {
+	*Review
+	ReportCount    int       `json:"report_count"`
+	LastReportedAt time.Time `json:"last_reported_at"`
+	Reasons        []string  `json:"reasons"`
+}
+
+// ModerationModel keeps the reports users make about reviews and the decisions the moderators make about them
+type ModerationModel struct {
+	DB     DBTX
+	Reader DBTX // runs the read-only queries, on the read replica if one is configured
+}
+
+// Report records a user's report about a review. ErrRecordNotFound is returned if the review doesn't exist or has been
+// hidden, and ErrDuplicateReport if the user has already reported it
+func (m ModerationModel) Report(ctx context.Context, report *ReviewReport) error {
+	query := `
+		INSERT INTO review_reports (review_id, user_id, reason)
+		SELECT id, $2, $3
+		FROM reviews
+		WHERE id = $1 AND hidden_at IS NULL
+		RETURNING id, created_at`
+
+	ctx, cancel := withWriteTimeout(ctx)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, report.ReviewID, report.UserID, report.Reason).Scan(&report.ID, &report.CreatedAt)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrRecordNotFound
+		case isUniqueViolation(err, "review_reports_review_id_user_id_key"):
+			return ErrDuplicateReport
+		default:
+			return err
+		}
+	}
+
+	return nil
+}
+
+// GetReported returns a page of the reviews with open reports, the most reported first unless the filters ask for
+// another order. As well as the review columns, the results can be sorted by report_count and last_reported_at
+func (m ModerationModel) GetReported(ctx context.Context, filters Filters) ([]*ReportedReview, Metadata, error) {
+	sortColumn, err := filters.sortColumn()
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+
+	query := fmt.Sprintf(`
+		SELECT count(*) OVER(), reviews.id, reviews.created_at, movie_id, COALESCE(reviews.user_id, 0), rating, body, version,
+		hidden_at IS NOT NULL, reports.report_count, reports.last_reported_at, reports.reasons
+		FROM reviews
+		INNER JOIN (
+			SELECT review_id, count(*) AS report_count, max(created_at) AS last_reported_at,
+			array_agg(reason ORDER BY created_at) AS reasons
+			FROM review_reports
+			WHERE resolved_at IS NULL
+			GROUP BY review_id
+		) AS reports ON reports.review_id = reviews.id
+		ORDER BY %s %s, reviews.id ASC
+		LIMIT $1 OFFSET $2`, sortColumn, filters.sortDirection())
+
+	ctx, cancel := withReadTimeout(ctx)
+	defer cancel()
+
+	rows, err := m.Reader.QueryContext(ctx, query, filters.limit(), filters.offset())
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+	defer rows.Close()
+
+	totalRecords := 0
+	reported := []*ReportedReview{}
+
+	for rows.Next() {
+		entry := ReportedReview{Review: &Review{}}
+
+		err := rows.Scan(
+			&totalRecords,
+			&entry.ID,
+			&entry.CreatedAt,
+			&entry.MovieID,
+			&entry.UserID,
+			&entry.Rating,
+			&entry.Body,
+			&entry.Version,
+			&entry.Hidden,
+			&entry.ReportCount,
+			&entry.LastReportedAt,
+			scanArray(&entry.Reasons),
+		)
+		if err != nil {
+			return nil, Metadata{}, err
+		}
+
+		reported = append(reported, &entry)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, Metadata{}, err
+	}
+
+	metadata := calculateMetadata(totalRecords, filters.Page, filters.PageSize)
+
+	return reported, metadata, nil
+}
+
+// GetReview returns a review whether or not it has been hidden, along with every report made about it and every
+// decision made about it, oldest first
+func (m ModerationModel) GetReview(ctx context.Context, id int64) (*Review, []*ReviewReport, []*ModerationDecision, error) {
+	if id < 1 {
+		return nil, nil, nil, ErrRecordNotFound
+	}
+
+	ctx, cancel := withReadTimeout(ctx)
+	defer cancel()
+
+	query := `
+		SELECT id, created_at, movie_id, COALESCE(user_id, 0), rating, body, version, hidden_at IS NOT NULL
+		FROM reviews
+		WHERE id = $1`
+
+	var review Review
+
+	err := m.Reader.QueryRowContext(ctx, query, id).Scan(
+		&review.ID,
+		&review.CreatedAt,
+		&review.MovieID,
+		&review.UserID,
+		&review.Rating,
+		&review.Body,
+		&review.Version,
+		&review.Hidden,
+	)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, nil, nil, ErrRecordNotFound
+		default:
+			return nil, nil, nil, err
+		}
+	}
+
+	reports, err := m.reports(ctx, id)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	decisions, err := m.decisions(ctx, id)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	return &review, reports, decisions, nil
+}
+
+func (m ModerationModel) reports(ctx context.Context, reviewID int64) ([]*ReviewReport, error) {
+	query := `
+		SELECT id, created_at, review_id, COALESCE(user_id, 0), reason, resolved_at
+		FROM review_reports
+		WHERE review_id = $1
+		ORDER BY id`
+
+	rows, err := m.Reader.QueryContext(ctx, query, reviewID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	reports := []*ReviewReport{}
+
+	for rows.Next() {
+		var report ReviewReport
+
+		err := rows.Scan(&report.ID, &report.CreatedAt, &report.ReviewID, &report.UserID, &report.Reason, &report.ResolvedAt)
+		if err != nil {
+			return nil, err
+		}
+
+		reports = append(reports, &report)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return reports, nil
+}
+
+func (m ModerationModel) decisions(ctx context.Context, reviewID int64) ([]*ModerationDecision, error) {
+	query := `
+		SELECT id, created_at, review_id, COALESCE(moderator_id, 0), action, note
+		FROM review_moderation_decisions
+		WHERE review_id = $1
+		ORDER BY id`
+
+	rows, err := m.Reader.QueryContext(ctx, query, reviewID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	decisions := []*ModerationDecision{}
+
+	for rows.Next() {
+		var decision ModerationDecision
+
+		err := rows.Scan(&decision.ID, &decision.CreatedAt, &decision.ReviewID, &decision.ModeratorID, &decision.Action, &decision.Note)
+		if err != nil {
+			return nil, err
+		}
+
+		decisions = append(decisions, &decision)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return decisions, nil
+}
+
+// Decide records a moderator's decision about a review and carries it out in the same transaction: the review is
+// hidden or restored as the decision says, the rating of its movie is recomputed, and the open reports of the review
+// are resolved. ErrRecordNotFound is returned if the review doesn't exist
+func (m ModerationModel) Decide(ctx context.Context, decision *ModerationDecision) error {
+	ctx, cancel := withWriteTimeout(ctx)
+	defer cancel()
+
+	tx, err := beginTx(ctx, m.DB)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	// hiding a review which is already hidden keeps the time it was first hidden
+	query := `
+		UPDATE reviews
+		SET hidden_at = CASE $2
+			WHEN 'hide' THEN COALESCE(hidden_at, NOW())
+			WHEN 'restore' THEN NULL
+			ELSE hidden_at
+		END
+		WHERE id = $1
+		RETURNING movie_id`
+
+	var movieID int64
+
+	err = tx.QueryRowContext(ctx, query, decision.ReviewID, decision.Action).Scan(&movieID)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrRecordNotFound
+		default:
+			return err
+		}
+	}
+
+	query = `
+		INSERT INTO review_moderation_decisions (review_id, moderator_id, action, note)
+		VALUES ($1, $2, $3, $4)
+		RETURNING id, created_at`
+
+	err = tx.QueryRowContext(ctx, query, decision.ReviewID, decision.ModeratorID, decision.Action, decision.Note).Scan(&decision.ID, &decision.CreatedAt)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.ExecContext(ctx, `UPDATE review_reports SET resolved_at = NOW() WHERE review_id = $1 AND resolved_at IS NULL`, decision.ReviewID)
+	if err != nil {
+		return err
+	}
+
+	if decision.Action != ModerationActionDismiss {
+		err = updateMovieRating(ctx, tx, movieID)
+		if err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// Mock data for testing
+type MockModerationModel struct{}
+
+func (m MockModerationModel) Report(ctx context.Context, report *ReviewReport) error {
+	return nil
+}
+
+func (m MockModerationModel) GetReported(ctx context.Context, filters Filters) ([]*ReportedReview, Metadata, error) {
+	return []*ReportedReview{}, Metadata{}, nil
+}
+
+func (m MockModerationModel) GetReview(ctx context.Context, id int64) (*Review, []*ReviewReport, []*ModerationDecision, error) {
+	return nil, nil, nil, ErrRecordNotFound
+}
+
+func (m MockModerationModel) Decide(ctx context.Context, decision *ModerationDecision) error {
+	return nil
+}
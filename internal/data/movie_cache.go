@@ -0,0 +1,79 @@
+package data
+
+import (
+	"bytes"
+	"context"
+	"encoding/gob"
+	"strconv"
+	"time"
+
+	"github.com/nytro04/greenlight/internal/cache"
+)
+
+// movieCacheKey returns the key the movie with the ID is cached under
+func movieCacheKey(id int64) string {
+	return "movie:" + strconv.FormatInt(id, 10)
+}
+
+// getCached returns the movie from the cache, reading it from the database and caching it on a miss. The movies are
+// gob encoded rather than JSON, as the poster key isn't part of the JSON of a movie. The cache only saves queries, so
+// when it can't be reached or holds something which can't be decoded the movie is read from the database. A miss is
+// read from the primary rather than the replica, as a copy the replica hasn't caught up on would be cached for the
+// whole TTL after the write which removed the old one.
+//
+// The review aggregates of a cached movie are the ones it had when it was cached, so they can be behind by up to the
+// TTL of the cache
+func (m MovieModel) getCached(ctx context.Context, id int64) (*Movie, error) {
+	key := movieCacheKey(id)
+
+	value, err := m.Cache.Get(ctx, key)
+	if err == nil {
+		var movie Movie
+
+		if gob.NewDecoder(bytes.NewReader(value)).Decode(&movie) == nil {
+			return &movie, nil
+		}
+	}
+
+	movie, err := m.get(ctx, m.DB, id)
+	if err != nil {
+		return nil, err
+	}
+
+	var buf bytes.Buffer
+
+	if gob.NewEncoder(&buf).Encode(movie) == nil {
+		m.Cache.Set(ctx, key, buf.Bytes(), m.CacheTTL)
+	}
+
+	return movie, nil
+}
+
+// uncache removes the movie from the cache, so the next Get reads it from the database. A failure to remove it is not
+// returned, as the write itself has been made; the stale movie is served until it expires
+func (m MovieModel) uncache(ctx context.Context, id int64) {
+	if m.Cache != nil {
+		m.Cache.Delete(ctx, movieCacheKey(id))
+	}
+}
+
+// CacheMovies makes the movie model read the movies through the cache, keeping each for the TTL, and registers a hook
+// which removes the movies, and the catalog statistics, from the cache when they are written. It has no effect on the
+// mock models
+func (m *Models) CacheMovies(c cache.Cache, ttl time.Duration) {
+	movies, ok := m.Movies.(MovieModel)
+	if !ok {
+		return
+	}
+
+	movies.Cache, movies.CacheTTL = c, ttl
+	m.Movies = movies
+
+	m.OnMovieChanged(func(ctx context.Context, change MovieChange) {
+		for _, movie := range change.Movies {
+			movies.uncache(ctx, movie.ID)
+		}
+
+		c.Delete(ctx, movieStatsCacheKey)
+	})
+}
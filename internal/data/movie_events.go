@@ -0,0 +1,75 @@
+package data
+
+import (
+	"context"
+	"sync"
+)
+
+// MovieChange is a write made to the movies, passed to the hooks registered with OnMovieChanged once it has been made
+type MovieChange struct {
+	Event  string   // movie.created, movie.updated or movie.deleted, the same names as the webhook events
+	Movies []*Movie // the movies as they are after the write, or were before it for a deletion
+}
+
+// MovieHook is called with each change made to the movies, in the goroutine which made it
+type MovieHook func(ctx context.Context, change MovieChange)
+
+// movieHooks holds the hooks registered with OnMovieChanged. The models created by WithTx get hooks of their own, which
+// hold the changes back and pass them on once the transaction has been committed, so nothing reacts to a write which
+// is rolled back
+type movieHooks struct {
+	mu    sync.RWMutex
+	hooks []MovieHook
+
+	parent  *movieHooks // set on the hooks of a transaction
+	pending []MovieChange
+}
+
+func (h *movieHooks) add(hook MovieHook) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.hooks = append(h.hooks, hook)
+}
+
+// notify calls the hooks with the change, or holds it back until commit is called for the hooks of a transaction
+func (h *movieHooks) notify(ctx context.Context, change MovieChange) {
+	if h == nil || len(change.Movies) == 0 {
+		return
+	}
+
+	if h.parent != nil {
+		h.mu.Lock()
+		h.pending = append(h.pending, change)
+		h.mu.Unlock()
+		return
+	}
+
+	h.mu.RLock()
+	hooks := h.hooks
+	h.mu.RUnlock()
+
+	for _, hook := range hooks {
+		hook(ctx, change)
+	}
+}
+
+// commit passes the changes held back by the hooks of a transaction on to the hooks it was started from
+func (h *movieHooks) commit(ctx context.Context) {
+	h.mu.Lock()
+	pending := h.pending
+	h.pending = nil
+	h.mu.Unlock()
+
+	for _, change := range pending {
+		h.parent.notify(ctx, change)
+	}
+}
+
+// OnMovieChanged registers a hook which is called after every movie is created, updated or deleted through the
+// models, so the cache, the webhooks and anything else which follows the movies can react to the writes without each
+// handler having to tell them. The hooks are called in the order they were registered, after the write has been made,
+// and should be quick as the request which made the write waits for them. They are meant to be registered at startup
+func (m *Models) OnMovieChanged(hook MovieHook) {
+	m.movieHooks.add(hook)
+}
@@ -0,0 +1,175 @@
+package data
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/gob"
+	"errors"
+	"time"
+)
+
+// movieStatsCacheKey is the key the catalog statistics are cached under
+const movieStatsCacheKey = "movies:stats"
+
+// movieStatsTTL is how long the catalog statistics are cached for. It is short, as they are only removed from the cache
+// by the writes made through this instance, but it saves recomputing them for every dashboard polling them
+const movieStatsTTL = 30 * time.Second
+
+// MovieStats holds statistics about the whole catalog
+type MovieStats struct {
+	TotalMovies    int64         `json:"total_movies"`
+	TotalRuntime   Runtime       `json:"total_runtime"`
+	AverageRuntime float64       `json:"average_runtime"`
+	TotalReviews   int64         `json:"total_reviews"`
+	AverageRating  float64       `json:"average_rating"` // of all the reviews, zero if there are none
+	Genres         []GenreCount  `json:"genres"`         // the number of movies of each genre, most common first
+	Decades        []DecadeCount `json:"decades"`        // the number of movies released in each decade, oldest first
+	Newest         *MovieSummary `json:"newest"`         // the latest release, nil when the catalog is empty
+	Oldest         *MovieSummary `json:"oldest"`         // the earliest release, nil when the catalog is empty
+	ComputedAt     time.Time     `json:"computed_at"`
+}
+
+type GenreCount struct {
+	Genre  string `json:"genre"`
+	Movies int64  `json:"movies"`
+}
+
+type DecadeCount struct {
+	Decade int32 `json:"decade"` // the first year of the decade, e.g. 1990
+	Movies int64 `json:"movies"`
+}
+
+// MovieSummary identifies a movie in the statistics
+type MovieSummary struct {
+	ID    int64  `json:"id"`
+	Title string `json:"title"`
+	Year  int32  `json:"year"`
+}
+
+// Stats computes the catalog statistics with aggregate queries, or returns them from the cache when they have been
+// computed in the last movieStatsTTL
+func (m MovieModel) Stats(ctx context.Context) (*MovieStats, error) {
+	if m.Cache != nil {
+		value, err := m.Cache.Get(ctx, movieStatsCacheKey)
+		if err == nil {
+			var stats MovieStats
+
+			if gob.NewDecoder(bytes.NewReader(value)).Decode(&stats) == nil {
+				return &stats, nil
+			}
+		}
+	}
+
+	stats, err := m.computeStats(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	if m.Cache != nil {
+		var buf bytes.Buffer
+
+		if gob.NewEncoder(&buf).Encode(stats) == nil {
+			m.Cache.Set(ctx, movieStatsCacheKey, buf.Bytes(), movieStatsTTL)
+		}
+	}
+
+	return stats, nil
+}
+
+func (m MovieModel) computeStats(ctx context.Context) (*MovieStats, error) {
+	ctx, cancel := withReadTimeout(ctx)
+	defer cancel()
+
+	stats := MovieStats{Genres: []GenreCount{}, Decades: []DecadeCount{}, ComputedAt: time.Now().UTC()}
+
+	query := `
+		SELECT count(*), COALESCE(sum(runtime), 0), COALESCE(avg(runtime), 0),
+		(SELECT count(*) FROM reviews WHERE hidden_at IS NULL), COALESCE((SELECT avg(rating) FROM reviews WHERE hidden_at IS NULL), 0)
+		FROM movies`
+
+	err := m.Reader.QueryRowContext(ctx, query).Scan(&stats.TotalMovies, &stats.TotalRuntime, &stats.AverageRuntime, &stats.TotalReviews, &stats.AverageRating)
+	if err != nil {
+		return nil, err
+	}
+
+	query = `
+		SELECT genre, count(*)
+		FROM movies, unnest(genres) AS genre
+		GROUP BY genre
+		ORDER BY count(*) DESC, genre ASC`
+
+	err = m.scanCounts(ctx, query, func(rows *sql.Rows) error {
+		var count GenreCount
+		err := rows.Scan(&count.Genre, &count.Movies)
+		stats.Genres = append(stats.Genres, count)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	query = `
+		SELECT year / 10 * 10 AS decade, count(*)
+		FROM movies
+		GROUP BY decade
+		ORDER BY decade ASC`
+
+	err = m.scanCounts(ctx, query, func(rows *sql.Rows) error {
+		var count DecadeCount
+		err := rows.Scan(&count.Decade, &count.Movies)
+		stats.Decades = append(stats.Decades, count)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// the ties between movies released in the same year go to the one added first
+	stats.Newest, err = m.summary(ctx, `SELECT id, title, year FROM movies ORDER BY year DESC, id ASC LIMIT 1`)
+	if err != nil {
+		return nil, err
+	}
+
+	stats.Oldest, err = m.summary(ctx, `SELECT id, title, year FROM movies ORDER BY year ASC, id ASC LIMIT 1`)
+	if err != nil {
+		return nil, err
+	}
+
+	return &stats, nil
+}
+
+// scanCounts runs one of the grouping queries of the statistics, calling scan for each row
+func (m MovieModel) scanCounts(ctx context.Context, query string, scan func(rows *sql.Rows) error) error {
+	rows, err := m.Reader.QueryContext(ctx, query)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		err = scan(rows)
+		if err != nil {
+			return err
+		}
+	}
+
+	return rows.Err()
+}
+
+// summary returns the movie selected by the query, or nil when there are no movies
+func (m MovieModel) summary(ctx context.Context, query string) (*MovieSummary, error) {
+	var movie MovieSummary
+
+	err := m.Reader.QueryRowContext(ctx, query).Scan(&movie.ID, &movie.Title, &movie.Year)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, nil
+		default:
+			return nil, err
+		}
+	}
+
+	return &movie, nil
+}
@@ -2,25 +2,60 @@ package data
 
 import (
 	"context"
+	crand "crypto/rand"
 	"database/sql"
 	"errors"
 	"fmt"
+	"io"
+	"math/big"
+	"strings"
 	"time"
 
-	"github.com/lib/pq"
+	"github.com/nytro04/greenlight/internal/cache"
 	"github.com/nytro04/greenlight/internal/validator"
 )
 
 type Movie struct {
 	ID        int64     `json:"id"`        // Unique integer ID for the movie
 	CreatedAt time.Time `json:"createdAt"` // Timestamp for when the movie is added to our database
+	UpdatedAt time.Time `json:"updatedAt"` // Timestamp for when the movie was last changed, or one of its reviews was written
 	Title     string    `json:"title"`     // Movie title
 	Year      int32     `json:"year"`      // Movie release year
 	Runtime   Runtime   `json:"runtime"`   // Movie runtime (in minutes)
 	Genres    []string  `json:"genres"`    // Slice of genres for the movie (romance, comedy, etc.)
+	Language  string    `json:"language"`  // Original language of the movie as an ISO 639-1 code, e.g. "ja", empty if it isn't known
+	Country   string    `json:"country"`   // Country the movie was made in as an ISO 3166-1 alpha-2 code, e.g. "JP", empty if it isn't known
 	Version   int32     `json:"version"`   // The version number starts at 1 and will be incremented each // time the movie information is updated
+
+	AverageRating float64 `json:"average_rating"` // Average star rating of the movie's reviews, zero if it has none
+	ReviewCount   int     `json:"review_count"`   // Number of reviews of the movie
+
+	Credits []*Credit `json:"credits,omitempty"` // Cast and crew of the movie, only included when the client asks for them with include=credits
+
+	Tags []string `json:"tags,omitempty"` // Names of the tags users have put on the movie, only included when the client asks for them with include=tags
+
+	Series *SeriesEntry `json:"series,omitempty"` // Place of the movie in its series, only included when the client asks for it with include=series
+
+	Headline string `json:"headline,omitempty"` // Title with the words matching the search highlighted, only included when the client asks for it with include=headline
+
+	PosterKey string `json:"-"`                    // Storage key of the uploaded poster, empty if the movie doesn't have one
+	PosterURL string `json:"poster_url,omitempty"` // URL the poster can be fetched from
+
+	Favorited *bool `json:"favorited,omitempty"` // Whether the movie is one of the requesting user's favorites, only set in the responses to authenticated users
+}
+
+// setPosterURL fills in the poster URL from the poster key. The URL always points at the API, which serves the poster
+// itself or redirects to the storage backend, so it stays the same whichever backend the poster is kept in
+func (movie *Movie) setPosterURL() {
+	movie.PosterURL = ""
+	if movie.PosterKey != "" {
+		movie.PosterURL = fmt.Sprintf("/v1/movies/%d/poster", movie.ID)
+	}
 }
 
+// MaxMovieGenres is the largest number of genres a movie can have
+const MaxMovieGenres = 5
+
 func ValidateMovie(v *validator.Validator, movie *Movie) {
 	v.Check(movie.Title != "", "title", "must be provided")
 	v.Check(len(movie.Title) < 500, "title", "must not be more than 500 bytes long")
@@ -34,55 +69,260 @@ func ValidateMovie(v *validator.Validator, movie *Movie) {
 
 	v.Check(movie.Genres != nil, "genres", "must be provided")
 	v.Check(len(movie.Genres) >= 1, "genres", "must contain at least 1 genre")
-	v.Check(len(movie.Genres) <= 5, "genres", "must not contain more than 5 genres")
+	v.Check(len(movie.Genres) <= MaxMovieGenres, "genres", fmt.Sprintf("must not contain more than %d genres", MaxMovieGenres))
 	v.Check(validator.Unique(movie.Genres), "genres", "must not contain duplicate values")
+
+	v.Check(movie.Language == "" || validator.IsLanguageCode(movie.Language), "language", "must be a lowercase ISO 639-1 language code")
+	v.Check(movie.Country == "" || validator.IsCountryCode(movie.Country), "country", "must be an uppercase ISO 3166-1 alpha-2 country code")
+}
+
+// MovieSearch holds the title search of a movie listing. The title is matched with full text search, and when Headline
+// is set each movie in the results comes with its title, with the matching words highlighted, as a snippet. When Fuzzy
+// is set titles which are similar to the search are matched too, so a misspelling like "Intersteller" still finds "Interstellar"
+type MovieSearch struct {
+	Title    string
+	Headline bool
+	Fuzzy    bool
+}
+
+// titleCondition returns the WHERE condition matching the title search. The fuzzy match uses the pg_trgm similarity
+// operator, which the trigram index on the title column serves
+func (s MovieSearch) titleCondition() string {
+	if s.Fuzzy {
+		return "(to_tsvector('simple', title) @@ plainto_tsquery('simple', $1) OR title % $1 OR $1 = '')"
+	}
+	return "(to_tsvector('simple', title) @@ plainto_tsquery('simple', $1) OR $1 = '')"
+}
+
+// relevanceOrder returns the ORDER BY expression for sort=relevance. The full text matches come first, then the fuzzy
+// ones from the most to the least similar
+func (s MovieSearch) relevanceOrder() string {
+	if s.Fuzzy {
+		return "ts_rank(to_tsvector('simple', title), plainto_tsquery('simple', $1)) DESC, similarity(title, $1) DESC"
+	}
+	return "ts_rank(to_tsvector('simple', title), plainto_tsquery('simple', $1)) DESC"
+}
+
+// headlineColumn returns the expression for the headline column of the listing queries. ts_headline is only run when a
+// headline was asked for, since it is much slower than the search itself
+func (s MovieSearch) headlineColumn() string {
+	if !s.Headline || s.Title == "" {
+		return "''"
+	}
+	return "ts_headline('simple', title, plainto_tsquery('simple', $1), 'StartSel=<mark>, StopSel=</mark>')"
+}
+
+// tagsCondition returns the WHERE condition matching the movies which have every one of the tags in the array
+// parameter, or every movie when the array is empty
+func tagsCondition(param string) string {
+	return fmt.Sprintf(`(%[1]s = '{}' OR id IN (
+		SELECT movie_tags.movie_id
+		FROM movie_tags
+		INNER JOIN tags ON tags.id = movie_tags.tag_id
+		WHERE tags.name = ANY(%[1]s)
+		GROUP BY movie_tags.movie_id
+		HAVING count(*) = cardinality(%[1]s::text[])))`, param)
+}
+
+// MovieRanges holds the range filters of a movie listing, e.g. the movies from the 90s under 2 hours. The bounds are
+// inclusive, and a zero year or runtime (or a nil time) leaves that end of the range open
+type MovieRanges struct {
+	YearMin       int
+	YearMax       int
+	RuntimeMin    int
+	RuntimeMax    int
+	CreatedAfter  *time.Time
+	CreatedBefore *time.Time
+}
+
+// ValidateMovieRanges checks that the bounds are sensible values, and that no range is empty because its bounds are the wrong way round
+func ValidateMovieRanges(v *validator.Validator, ranges MovieRanges) {
+	v.Check(ranges.YearMin >= 0, "year_min", "must not be negative")
+	v.Check(ranges.YearMax >= 0, "year_max", "must not be negative")
+	v.Check(ranges.YearMax == 0 || ranges.YearMin <= ranges.YearMax, "year_max", "must not be less than year_min")
+
+	v.Check(ranges.RuntimeMin >= 0, "runtime_min", "must not be negative")
+	v.Check(ranges.RuntimeMax >= 0, "runtime_max", "must not be negative")
+	v.Check(ranges.RuntimeMax == 0 || ranges.RuntimeMin <= ranges.RuntimeMax, "runtime_max", "must not be less than runtime_min")
+
+	if ranges.CreatedAfter != nil && ranges.CreatedBefore != nil {
+		v.Check(!ranges.CreatedBefore.Before(*ranges.CreatedAfter), "created_before", "must not be before created_after")
+	}
+}
+
+// MovieOrigin holds the original language and country filters of a movie listing. A movie matches when its language
+// is any of the languages and its country any of the countries, and an empty slice leaves that filter off
+type MovieOrigin struct {
+	Languages []string
+	Countries []string
+}
+
+// ValidateMovieOrigin checks that the filters hold valid language and country codes
+func ValidateMovieOrigin(v *validator.Validator, origin MovieOrigin) {
+	for _, language := range origin.Languages {
+		v.Check(validator.IsLanguageCode(language), "language", "must only contain ISO 639-1 language codes")
+	}
+	for _, country := range origin.Countries {
+		v.Check(validator.IsCountryCode(country), "country", "must only contain ISO 3166-1 alpha-2 country codes")
+	}
 }
 
 type MovieModel struct {
-	DB *sql.DB
+	DB     DBTX
+	Reader DBTX // runs the read-only queries, on the read replica if one is configured
+
+	Cache    cache.Cache   // Get reads the movies through it when set, and the writes remove them from it
+	CacheTTL time.Duration // how long a movie is kept in the cache
+
+	Random io.Reader // picks the movie returned by GetRandom
+
+	hooks *movieHooks // told about every write which is made
 }
 
 // Insert method to create a new movie record
-func (m MovieModel) Insert(movie *Movie) error {
+func (m MovieModel) Insert(ctx context.Context, movie *Movie) error {
 	query := `
-		INSERT INTO movies (title, year, runtime, genres)
-		VALUES ($1, $2, $3, $4)
-		RETURNING id, created_at, version`
+		INSERT INTO movies (title, year, runtime, genres, language, country)
+		VALUES ($1, $2, $3, $4, $5, $6)
+		RETURNING id, created_at, updated_at, version`
 
 	// Create a slice containing the movie
-	args := []interface{}{movie.Title, movie.Year, movie.Runtime, pq.Array(movie.Genres)}
+	args := []interface{}{movie.Title, movie.Year, movie.Runtime, movie.Genres, movie.Language, movie.Country}
+
+	// create a new context with the write query timeout.
+	ctx, cancel := withWriteTimeout(ctx)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, args...).Scan(&movie.ID, &movie.CreatedAt, &movie.UpdatedAt, &movie.Version)
+	if err != nil {
+		return err
+	}
+
+	m.hooks.notify(ctx, MovieChange{Event: WebhookEventMovieCreated, Movies: []*Movie{movie}})
+
+	return nil
+}
 
-	// create a new context with a 3-second timeout
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+// movieInsertBatchSize is the number of movies InsertMany inserts with each statement, which keeps the number of
+// placeholders in a statement well below the PostgreSQL limit of 65535
+const movieInsertBatchSize = 500
+
+// InsertMany inserts the movies in batches inside a single transaction, so either all of them are inserted or none are.
+// The IDs, creation times and versions are filled in from the RETURNING clause, which returns the rows of a multi-row
+// INSERT in the order of its VALUES list
+func (m MovieModel) InsertMany(ctx context.Context, movies []*Movie) error {
+	ctx, cancel := context.WithTimeout(ctx, 30*time.Second)
 	defer cancel()
 
-	return m.DB.QueryRowContext(ctx, query, args...).Scan(&movie.ID, &movie.CreatedAt, &movie.Version)
+	tx, err := beginTx(ctx, m.DB)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	for start := 0; start < len(movies); start += movieInsertBatchSize {
+		batch := movies[start:min(start+movieInsertBatchSize, len(movies))]
+
+		values := make([]string, len(batch))
+		args := make([]interface{}, 0, len(batch)*6)
+
+		for i, movie := range batch {
+			values[i] = fmt.Sprintf("($%d, $%d, $%d, $%d, $%d, $%d)", i*6+1, i*6+2, i*6+3, i*6+4, i*6+5, i*6+6)
+			args = append(args, movie.Title, movie.Year, movie.Runtime, movie.Genres, movie.Language, movie.Country)
+		}
+
+		query := `
+		INSERT INTO movies (title, year, runtime, genres, language, country)
+		VALUES ` + strings.Join(values, ", ") + `
+		RETURNING id, created_at, updated_at, version`
+
+		err = insertBatch(ctx, tx, query, args, batch)
+		if err != nil {
+			return err
+		}
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return err
+	}
+
+	m.hooks.notify(ctx, MovieChange{Event: WebhookEventMovieCreated, Movies: movies})
+
+	return nil
+}
+
+// insertBatch runs one of the InsertMany statements and scans the returned rows into the batch of movies
+func insertBatch(ctx context.Context, tx DBTX, query string, args []interface{}, batch []*Movie) error {
+	rows, err := tx.QueryContext(ctx, query, args...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	i := 0
+	for rows.Next() {
+		err = rows.Scan(&batch[i].ID, &batch[i].CreatedAt, &batch[i].UpdatedAt, &batch[i].Version)
+		if err != nil {
+			return err
+		}
+		i++
+	}
+
+	return rows.Err()
 }
 
-func (m MovieModel) Get(id int64) (*Movie, error) {
+func (m MovieModel) Get(ctx context.Context, id int64) (*Movie, error) {
 	if id < 1 {
 		return nil, ErrRecordNotFound
 	}
 
+	if m.Cache != nil {
+		return m.getCached(ctx, id)
+	}
+
+	return m.get(ctx, m.Reader, id)
+}
+
+// GetForWrite returns the movie from the primary, skipping the cache and the replica, for the handlers which change a
+// movie based on what they read, so the change is never made on top of a stale copy
+func (m MovieModel) GetForWrite(ctx context.Context, id int64) (*Movie, error) {
+	if id < 1 {
+		return nil, ErrRecordNotFound
+	}
+
+	return m.get(ctx, m.DB, id)
+}
+
+func (m MovieModel) get(ctx context.Context, db DBTX, id int64) (*Movie, error) {
+
+	// the review aggregates are kept on the movie by the review model, in the same transaction as the review writes
 	query := `
-	SELECT id, created_at, title, year, runtime, genres, version
+	SELECT id, created_at, updated_at, title, year, runtime, genres, version, poster_key,
+		average_rating, review_count, language, country
 	FROM movies
 	WHERE id = $1`
 
 	var movie Movie
 
-	// Create a context with a 3-second timeout.
-	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	// Create a new context with the read query timeout.
+	ctx, cancel := withReadTimeout(ctx)
 	defer cancel()
 	// Use the QueryRow() method to execute the query and scan the returned row into the movie struct.
-	err := m.DB.QueryRowContext(ctx, query, id).Scan(
+	err := db.QueryRowContext(ctx, query, id).Scan(
 		&movie.ID,
 		&movie.CreatedAt,
+		&movie.UpdatedAt,
 		&movie.Title,
 		&movie.Year,
 		&movie.Runtime,
-		pq.Array(&movie.Genres),
+		scanArray(&movie.Genres),
 		&movie.Version,
+		&movie.PosterKey,
+		&movie.AverageRating,
+		&movie.ReviewCount,
+		&movie.Language,
+		&movie.Country,
 	)
 
 	if err != nil {
@@ -94,11 +334,13 @@ func (m MovieModel) Get(id int64) (*Movie, error) {
 		}
 	}
 
+	movie.setPosterURL()
+
 	return &movie, nil
 
 }
 
-func (m MovieModel) GetAll(title string, genres []string, filters Filters) ([]*Movie, Metadata, error) {
+func (m MovieModel) GetAll(ctx context.Context, search MovieSearch, genres, tags []string, origin MovieOrigin, ranges MovieRanges, filters Filters) ([]*Movie, Metadata, error) {
 	// The query to retrieve all movies records. The query uses a WHERE clause to filter the results based on the title and genres.
 	// title will be matched using a case-insensitive search or empty string, and genres will be matched using the @> operator to check if the genres column contains all of the genres in the slice or pass an empty array.
 	// full text search is used to search the title column. to_tsvector('simple', title), splits the title into lexemes eg. "the matrix" -> 'the' 'matrix', we use 'simple' configuration to turn it into lowercase and remove punctuation.
@@ -106,23 +348,59 @@ func (m MovieModel) GetAll(title string, genres []string, filters Filters) ([]*M
 	// sort the results based on the sort column and direction provided in the filters struct(interpolation is used to insert the column and direction into the query).
 	// add a secondary sort on the movie ID to ensure that the results are returned in a consistent order.
 	// add a window function(count(*) OVER()) to count the total number of records that match the query, and return this as a column in the result set.
+	// counting every match is expensive on a big table, so when the filters ask for an estimate the window function is left out and the total is estimated afterwards.
+	// the range filters are skipped when the bound is zero (or NULL for the timestamps), in the same way as the title and genres.
+	// the tags filter matches the movies which have every one of the tags, through the movie_tags join table.
+	// the language and country filters match the movies whose original language (or country) is any of the given ones.
+	// sorting by relevance puts the best matches for the title first, ties (and every movie when there is no title) are in ID order.
+	// sorting by rating orders by the average rating, the movies without reviews counting as zero.
+	if filters.CursorMode {
+		return m.getAllByCursor(ctx, search, genres, tags, origin, ranges, filters)
+	}
+
+	sortColumn, err := filters.sortColumn()
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+
+	orderBy := sortColumn + " " + filters.sortDirection()
+	switch sortColumn {
+	case "relevance":
+		orderBy = search.relevanceOrder()
+	case "rating":
+		orderBy = "average_rating " + filters.sortDirection()
+	}
+
+	countColumn := "count(*) OVER()"
+	if filters.EstimateCount {
+		countColumn = "0"
+	}
+
 	query := fmt.Sprintf(
-		`SELECT count(*) OVER(), id, created_at, title, year, runtime, genres, version
+		`SELECT %s, id, created_at, updated_at, title, year, runtime, genres, version, poster_key,
+	   average_rating, review_count, language, country, %s
 	   FROM movies
-	   WHERE (to_tsvector('simple', title) @@ plainto_tsquery('simple', $1) OR $1 = '')
+	   WHERE %s
 	   AND (genres @> $2 OR $2 = '{}')
-	   ORDER BY %s %s, id ASC
-	   LIMIT $3 OFFSET $4`, filters.sortColumn(), filters.sortDirection())
-
-	// Create a new context with a 3-second timeout.
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	   AND (year >= $5 OR $5 = 0) AND (year <= $6 OR $6 = 0)
+	   AND (runtime >= $7 OR $7 = 0) AND (runtime <= $8 OR $8 = 0)
+	   AND (created_at >= $9 OR $9 IS NULL) AND (created_at <= $10 OR $10 IS NULL)
+	   AND %s
+	   AND (language = ANY($12) OR $12 = '{}') AND (country = ANY($13) OR $13 = '{}')
+	   ORDER BY %s, id ASC
+	   LIMIT $3 OFFSET $4`, countColumn, search.headlineColumn(), search.titleCondition(), tagsCondition("$11"), orderBy)
+
+	// Create a new context with the read query timeout.
+	ctx, cancel := withReadTimeout(ctx)
 	defer cancel()
 
 	// values of sql placeholders parameters in a slice
-	args := []interface{}{title, pq.Array(genres), filters.limit(), filters.offset()}
+	args := []interface{}{search.Title, genres, filters.limit(), filters.offset(),
+		ranges.YearMin, ranges.YearMax, ranges.RuntimeMin, ranges.RuntimeMax, ranges.CreatedAfter, ranges.CreatedBefore, tags,
+		origin.Languages, origin.Countries}
 
 	// Execute the query passing in the title and genres as the placeholders. If an error is returned, return it to the calling function.
-	rows, err := m.DB.QueryContext(ctx, query, args...)
+	rows, err := m.Reader.QueryContext(ctx, query, args...)
 	if err != nil {
 		return nil, Metadata{}, err
 	}
@@ -145,15 +423,23 @@ func (m MovieModel) GetAll(title string, genres []string, filters Filters) ([]*M
 			&totalRecords,
 			&movie.ID,
 			&movie.CreatedAt,
+			&movie.UpdatedAt,
 			&movie.Title,
 			&movie.Year,
 			&movie.Runtime,
-			pq.Array(&movie.Genres),
+			scanArray(&movie.Genres),
 			&movie.Version,
+			&movie.PosterKey,
+			&movie.AverageRating,
+			&movie.ReviewCount,
+			&movie.Language,
+			&movie.Country,
+			&movie.Headline,
 		)
 		if err != nil {
 			return nil, Metadata{}, err
 		}
+		movie.setPosterURL()
 		// Append the Movie struct to the slice.
 		movies = append(movies, &movie)
 	}
@@ -162,80 +448,399 @@ func (m MovieModel) GetAll(title string, genres []string, filters Filters) ([]*M
 	if err = rows.Err(); err != nil {
 		return nil, Metadata{}, err
 	}
+
+	if filters.EstimateCount {
+		return m.withEstimatedTotal(ctx, movies, search, genres, tags, origin, ranges, filters, query, args)
+	}
+
 	// generate the metadata struct, passing in the total number of records, the current page, and the page size.
 	metadata := calculateMetadata(totalRecords, filters.Page, filters.PageSize)
 
 	return movies, metadata, nil
 }
 
+// withEstimatedTotal returns the page of movies with metadata whose total is estimated rather than counted. Without
+// any filters it is the size of the table from pg_class, otherwise the planner's estimate of the rows the listing
+// query matches. The estimate is never less than the records up to the end of this page, which are known to exist
+func (m MovieModel) withEstimatedTotal(ctx context.Context, movies []*Movie, search MovieSearch, genres, tags []string, origin MovieOrigin, ranges MovieRanges, filters Filters, query string, args []any) ([]*Movie, Metadata, error) {
+	var estimate int
+	var err error
+
+	if search.Title == "" && len(genres) == 0 && len(tags) == 0 && len(origin.Languages) == 0 && len(origin.Countries) == 0 &&
+		ranges == (MovieRanges{}) {
+		estimate, err = tableEstimate(ctx, m.Reader, "movies")
+	} else {
+		estimate, err = queryEstimate(ctx, m.Reader, query, args...)
+	}
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+
+	metadata := calculateMetadata(max(estimate, filters.offset()+len(movies)), filters.Page, filters.PageSize)
+	metadata.ApproximateTotal = metadata.TotalRecords > 0
+
+	return movies, metadata, nil
+}
+
+// getAllByCursor is the keyset paginated version of GetAll. Instead of counting and skipping the earlier pages, it seeks
+// past the last record of the previous page using the (id) or (created_at, id) key, so every page costs the same and
+// records inserted or deleted between requests never shift a record onto the wrong page. The total isn't counted,
+// since doing so would cost as much as the OFFSET it replaces
+func (m MovieModel) getAllByCursor(ctx context.Context, search MovieSearch, genres, tags []string, origin MovieOrigin, ranges MovieRanges, filters Filters) ([]*Movie, Metadata, error) {
+	sortColumn, err := filters.sortColumn()
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+	if sortColumn != "id" && sortColumn != "created_at" {
+		return nil, Metadata{}, fmt.Errorf("%w: %q can't be paginated with a cursor", ErrUnsafeSort, filters.Sort)
+	}
+
+	after, err := filters.decodeCursor()
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+
+	// fetch one more record than the page size, so we know whether there is a next page
+	args := []interface{}{search.Title, genres, filters.limit() + 1,
+		ranges.YearMin, ranges.YearMax, ranges.RuntimeMin, ranges.RuntimeMax, ranges.CreatedAfter, ranges.CreatedBefore, tags,
+		origin.Languages, origin.Countries}
+
+	operator := ">"
+	if filters.sortDirection() == "DESC" {
+		operator = "<"
+	}
+
+	seek := ""
+	switch {
+	case after == nil:
+	case sortColumn == "id":
+		seek = fmt.Sprintf("AND id %s $13", operator)
+		args = append(args, after.ID)
+	default:
+		seek = fmt.Sprintf("AND (created_at, id) %s ($13, $14)", operator)
+		args = append(args, after.CreatedAt, after.ID)
+	}
+
+	query := fmt.Sprintf(
+		`SELECT id, created_at, updated_at, title, year, runtime, genres, version, poster_key,
+	   average_rating, review_count, language, country, %s
+	   FROM movies
+	   WHERE %s
+	   AND (genres @> $2 OR $2 = '{}')
+	   AND (year >= $4 OR $4 = 0) AND (year <= $5 OR $5 = 0)
+	   AND (runtime >= $6 OR $6 = 0) AND (runtime <= $7 OR $7 = 0)
+	   AND (created_at >= $8 OR $8 IS NULL) AND (created_at <= $9 OR $9 IS NULL)
+	   AND %s
+	   AND (language = ANY($11) OR $11 = '{}') AND (country = ANY($12) OR $12 = '{}')
+	   %s
+	   ORDER BY %s %s, id %[6]s
+	   LIMIT $3`, search.headlineColumn(), search.titleCondition(), tagsCondition("$10"), seek, sortColumn, filters.sortDirection())
+
+	ctx, cancel := withReadTimeout(ctx)
+	defer cancel()
+
+	rows, err := m.Reader.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+	defer rows.Close()
+
+	movies := []*Movie{}
+
+	for rows.Next() {
+		var movie Movie
+
+		err := rows.Scan(
+			&movie.ID,
+			&movie.CreatedAt,
+			&movie.UpdatedAt,
+			&movie.Title,
+			&movie.Year,
+			&movie.Runtime,
+			scanArray(&movie.Genres),
+			&movie.Version,
+			&movie.PosterKey,
+			&movie.AverageRating,
+			&movie.ReviewCount,
+			&movie.Language,
+			&movie.Country,
+			&movie.Headline,
+		)
+		if err != nil {
+			return nil, Metadata{}, err
+		}
+		movie.setPosterURL()
+		movies = append(movies, &movie)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, Metadata{}, err
+	}
+
+	// the next page starts after the last record on this one, if the extra record shows there is a next page
+	metadata := Metadata{PageSize: filters.PageSize}
+	if len(movies) > filters.PageSize {
+		movies = movies[:filters.PageSize]
+		last := movies[len(movies)-1]
+		metadata.NextCursor = encodeCursor(filters.Sort, last.ID, last.CreatedAt)
+	}
+
+	return movies, metadata, nil
+}
+
+// Export iterates through every movie matching the same filters as GetAll in ID order, calling fn for each one. The movies
+// are streamed from the database one row at a time rather than loaded into memory, so the whole catalog can be exported.
+// If fn returns an error the iteration stops and the error is returned
+func (m MovieModel) Export(ctx context.Context, search MovieSearch, genres, tags []string, origin MovieOrigin, ranges MovieRanges, fn func(movie *Movie) error) error {
+	query := fmt.Sprintf(`
+		SELECT id, created_at, title, year, runtime, genres, language, country, version
+		FROM movies
+		WHERE %s
+		AND (genres @> $2 OR $2 = '{}')
+		AND (year >= $3 OR $3 = 0) AND (year <= $4 OR $4 = 0)
+		AND (runtime >= $5 OR $5 = 0) AND (runtime <= $6 OR $6 = 0)
+		AND (created_at >= $7 OR $7 IS NULL) AND (created_at <= $8 OR $8 IS NULL)
+		AND (language = ANY($9) OR $9 = '{}') AND (country = ANY($10) OR $10 = '{}')
+		AND %s
+		ORDER BY id ASC`, search.titleCondition(), tagsCondition("$11"))
+
+	args := []interface{}{search.Title, genres,
+		ranges.YearMin, ranges.YearMax, ranges.RuntimeMin, ranges.RuntimeMax, ranges.CreatedAfter, ranges.CreatedBefore,
+		origin.Languages, origin.Countries, tags}
+
+	rows, err := m.Reader.QueryContext(ctx, query, args...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var movie Movie
+
+		err := rows.Scan(
+			&movie.ID,
+			&movie.CreatedAt,
+			&movie.Title,
+			&movie.Year,
+			&movie.Runtime,
+			scanArray(&movie.Genres),
+			&movie.Language,
+			&movie.Country,
+			&movie.Version,
+		)
+		if err != nil {
+			return err
+		}
+
+		err = fn(&movie)
+		if err != nil {
+			return err
+		}
+	}
+
+	return rows.Err()
+}
+
+// GetRandom returns a movie picked at random from the ones matching the same filters as GetAll, or ErrRecordNotFound if
+// none match. The matches are counted and one is read at a random offset, which only reads the rows up to that offset
+// rather than sorting every match by random(). A movie deleted between the two queries can leave the offset past the
+// end, so it is picked again a few times before giving up
+func (m MovieModel) GetRandom(ctx context.Context, search MovieSearch, genres, tags []string, origin MovieOrigin, ranges MovieRanges) (*Movie, error) {
+	where := fmt.Sprintf(`
+		WHERE %s
+		AND (genres @> $2 OR $2 = '{}')
+		AND (year >= $3 OR $3 = 0) AND (year <= $4 OR $4 = 0)
+		AND (runtime >= $5 OR $5 = 0) AND (runtime <= $6 OR $6 = 0)
+		AND (created_at >= $7 OR $7 IS NULL) AND (created_at <= $8 OR $8 IS NULL)
+		AND (language = ANY($9) OR $9 = '{}') AND (country = ANY($10) OR $10 = '{}')
+		AND %s`, search.titleCondition(), tagsCondition("$11"))
+
+	args := []interface{}{search.Title, genres,
+		ranges.YearMin, ranges.YearMax, ranges.RuntimeMin, ranges.RuntimeMax, ranges.CreatedAfter, ranges.CreatedBefore,
+		origin.Languages, origin.Countries, tags}
+
+	ctx, cancel := withReadTimeout(ctx)
+	defer cancel()
+
+	for attempt := 0; attempt < 3; attempt++ {
+		var count int64
+
+		err := m.Reader.QueryRowContext(ctx, `SELECT count(*) FROM movies`+where, args...).Scan(&count)
+		if err != nil {
+			return nil, err
+		}
+
+		if count == 0 {
+			return nil, ErrRecordNotFound
+		}
+
+		offset, err := crand.Int(m.Random, big.NewInt(count))
+		if err != nil {
+			return nil, err
+		}
+
+		query := `
+		SELECT id, created_at, updated_at, title, year, runtime, genres, version, poster_key,
+			average_rating, review_count, language, country
+		FROM movies` + where + `
+		ORDER BY id
+		LIMIT 1 OFFSET $12`
+
+		var movie Movie
+
+		err = m.Reader.QueryRowContext(ctx, query, append(args, offset.Int64())...).Scan(
+			&movie.ID,
+			&movie.CreatedAt,
+			&movie.UpdatedAt,
+			&movie.Title,
+			&movie.Year,
+			&movie.Runtime,
+			scanArray(&movie.Genres),
+			&movie.Version,
+			&movie.PosterKey,
+			&movie.AverageRating,
+			&movie.ReviewCount,
+			&movie.Language,
+			&movie.Country,
+		)
+		if err != nil {
+			if errors.Is(err, sql.ErrNoRows) {
+				continue
+			}
+			return nil, err
+		}
+
+		movie.setPosterURL()
+
+		return &movie, nil
+	}
+
+	return nil, ErrRecordNotFound
+}
+
 // Update method to update the movie record
-func (m MovieModel) Update(movie *Movie) error {
+func (m MovieModel) Update(ctx context.Context, movie *Movie) error {
 	// query for updating the movie record
 	query := `
 	UPDATE movies
-	SET title = $1, year = $2, runtime = $3, genres = $4, version = version + 1
-	WHERE ID = $5 AND version = $6
-	RETURNING version`
+	SET title = $1, year = $2, runtime = $3, genres = $4, language = $5, country = $6, version = version + 1, updated_at = NOW()
+	WHERE ID = $7 AND version = $8
+	RETURNING version, updated_at`
 
 	// Create a slice containing the movie genres
 	args := []interface{}{
 		movie.Title,
 		movie.Year,
 		movie.Runtime,
-		pq.Array(movie.Genres),
+		movie.Genres,
+		movie.Language,
+		movie.Country,
 		movie.ID,
 		movie.Version,
 	}
 
-	// Create a new context with a 3-second timeout.
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	// Create a new context with the write query timeout.
+	ctx, cancel := withWriteTimeout(ctx)
 	defer cancel()
 
 	// Execute the query. If no matching row is found, we know that the movie version has changed
 	// or the movie has been deleted, so we return ErrEditConflict.
-	err := m.DB.QueryRowContext(ctx, query, args...).Scan(&movie.Version)
+	err := m.DB.QueryRowContext(ctx, query, args...).Scan(&movie.Version, &movie.UpdatedAt)
 	if err != nil {
 		switch {
 		case errors.Is(err, sql.ErrNoRows):
+			// the cached movie may be the stale copy the client's version came from
+			m.uncache(ctx, movie.ID)
 			return ErrEditConflict
 		default:
 			return err
 		}
 	}
+
+	m.hooks.notify(ctx, MovieChange{Event: WebhookEventMovieUpdated, Movies: []*Movie{movie}})
+
 	return nil
 }
 
-// Delete method to delete the movie record
-func (m MovieModel) Delete(id int64) error {
+// UpdatePoster sets the poster key of the movie. Like Update, it fails with ErrEditConflict if the movie has been changed
+// since it was read, so two concurrent uploads can't both think their poster was the one kept
+func (m MovieModel) UpdatePoster(ctx context.Context, movie *Movie) error {
+	query := `
+	UPDATE movies
+	SET poster_key = $1, version = version + 1, updated_at = NOW()
+	WHERE id = $2 AND version = $3
+	RETURNING version, updated_at`
+
+	ctx, cancel := withWriteTimeout(ctx)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, movie.PosterKey, movie.ID, movie.Version).Scan(&movie.Version, &movie.UpdatedAt)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			m.uncache(ctx, movie.ID)
+			return ErrEditConflict
+		default:
+			return err
+		}
+	}
+
+	movie.setPosterURL()
+
+	m.hooks.notify(ctx, MovieChange{Event: WebhookEventMovieUpdated, Movies: []*Movie{movie}})
+
+	return nil
+}
+
+// Delete method to delete the movie record. The deleted movie is returned by the query, so the hooks can be told
+// what it was
+func (m MovieModel) Delete(ctx context.Context, id int64) error {
 	if id < 1 {
 		return ErrRecordNotFound
 	}
 
-	// delete query
+	// delete query, the reviews are deleted with the movie
 	query := `
 	DELETE FROM movies
-	WHERE id = $1`
+	WHERE id = $1
+	RETURNING id, created_at, updated_at, title, year, runtime, genres, version, poster_key,
+		average_rating, review_count, language, country`
 
-	// Create a new context with a 3-second timeout.
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	var movie Movie
+
+	// Create a new context with the write query timeout.
+	ctx, cancel := withWriteTimeout(ctx)
 	defer cancel()
 
-	// Execute the query, passing the id as the value for the placeholder parameter.
-	result, err := m.DB.ExecContext(ctx, query, id)
+	// Execute the query, passing the id as the value for the placeholder parameter. If no row is returned, we know
+	// that the movie with that ID doesn't exist in the database.
+	err := m.DB.QueryRowContext(ctx, query, id).Scan(
+		&movie.ID,
+		&movie.CreatedAt,
+		&movie.UpdatedAt,
+		&movie.Title,
+		&movie.Year,
+		&movie.Runtime,
+		scanArray(&movie.Genres),
+		&movie.Version,
+		&movie.PosterKey,
+		&movie.AverageRating,
+		&movie.ReviewCount,
+		&movie.Language,
+		&movie.Country,
+	)
 	if err != nil {
-		return err
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrRecordNotFound
+		default:
+			return err
+		}
 	}
 
-	// Check how many rows were affected by the query.
-	rowsAffected, err := result.RowsAffected()
-	if err != nil {
-		return err
-	}
+	movie.setPosterURL()
 
-	// If no rows were affected, we know that the movie with that ID doesn't exist in the database.
-	if rowsAffected == 0 {
-		return ErrRecordNotFound
-	}
+	m.hooks.notify(ctx, MovieChange{Event: WebhookEventMovieDeleted, Movies: []*Movie{&movie}})
 
 	return nil
 }
@@ -243,22 +848,46 @@ func (m MovieModel) Delete(id int64) error {
 // Mock data for testing
 type MockMovieModel struct{}
 
-func (m MockMovieModel) Insert(movie *Movie) error {
+func (m MockMovieModel) Insert(ctx context.Context, movie *Movie) error {
+	return nil
+}
+
+func (m MockMovieModel) InsertMany(ctx context.Context, movies []*Movie) error {
 	return nil
 }
 
-func (m MockMovieModel) Get(id int64) (*Movie, error) {
+func (m MockMovieModel) Get(ctx context.Context, id int64) (*Movie, error) {
 	return nil, nil
 }
 
-func (m MockMovieModel) GetAll(title string, genres []string, filters Filters) ([]*Movie, Metadata, error) {
+func (m MockMovieModel) GetForWrite(ctx context.Context, id int64) (*Movie, error) {
+	return nil, nil
+}
+
+func (m MockMovieModel) GetAll(ctx context.Context, search MovieSearch, genres, tags []string, origin MovieOrigin, ranges MovieRanges, filters Filters) ([]*Movie, Metadata, error) {
 	return nil, Metadata{}, nil
 }
 
-func (m MockMovieModel) Update(movie *Movie) error {
+func (m MockMovieModel) Export(ctx context.Context, search MovieSearch, genres, tags []string, origin MovieOrigin, ranges MovieRanges, fn func(movie *Movie) error) error {
+	return nil
+}
+
+func (m MockMovieModel) Update(ctx context.Context, movie *Movie) error {
+	return nil
+}
+
+func (m MockMovieModel) UpdatePoster(ctx context.Context, movie *Movie) error {
 	return nil
 }
 
-func (m MockMovieModel) Delete(id int64) error {
+func (m MockMovieModel) Delete(ctx context.Context, id int64) error {
 	return nil
 }
+
+func (m MockMovieModel) Stats(ctx context.Context) (*MovieStats, error) {
+	return &MovieStats{Genres: []GenreCount{}, Decades: []DecadeCount{}}, nil
+}
+
+func (m MockMovieModel) GetRandom(ctx context.Context, search MovieSearch, genres, tags []string, origin MovieOrigin, ranges MovieRanges) (*Movie, error) {
+	return nil, ErrRecordNotFound
+}
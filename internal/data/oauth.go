@@ -0,0 +1,156 @@
+package data
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base64"
+	"errors"
+	"io"
+	"time"
+
+	"github.com/nytro04/greenlight/internal/clock"
+)
+
+// OAuthModel wraps the connection pool and is used to read and write the accounts users have linked at the social
+// login providers, and the state of the logins which have been started
+type OAuthModel struct {
+	DB     DBTX
+	Clock  clock.Clock
+	Random io.Reader
+}
+
+// NewState stores the state of a login which has been started with the provider. It returns the plaintext state, which
+// is passed through the provider and back to the callback, and the PKCE code verifier the code has to be exchanged with
+func (m OAuthModel) NewState(provider string, ttl time.Duration) (string, string, error) {
+	state, err := generateToken(m.Clock, m.Random, 0, ttl, "oauth")
+	if err != nil {
+		return "", "", err
+	}
+
+	// the verifier must be between 43 and 128 characters long, 32 random bytes encode to 43
+	b := make([]byte, 32)
+
+	_, err = io.ReadFull(m.Random, b)
+	if err != nil {
+		return "", "", err
+	}
+
+	verifier := base64.RawURLEncoding.EncodeToString(b)
+
+	query := `
+		INSERT INTO oauth_states (hash, provider, verifier, expiry)
+		VALUES ($1, $2, $3, $4)`
+
+	ctx, cancel := withWriteTimeout(context.Background())
+	defer cancel()
+
+	_, err = m.DB.ExecContext(ctx, query, state.Hash, provider, verifier, state.Expiry)
+	if err != nil {
+		return "", "", err
+	}
+
+	return state.Plaintext, verifier, nil
+}
+
+// ConsumeState deletes the state of a login and returns its code verifier, so each state can only be used once.
+// ErrRecordNotFound is returned if the state doesn't exist, has expired or was started with another provider
+func (m OAuthModel) ConsumeState(provider, statePlaintext string) (string, error) {
+	hash := sha256.Sum256([]byte(statePlaintext))
+
+	query := `
+		DELETE FROM oauth_states
+		WHERE hash = $1 AND provider = $2 AND expiry > $3
+		RETURNING verifier`
+
+	ctx, cancel := withWriteTimeout(context.Background())
+	defer cancel()
+
+	var verifier string
+
+	err := m.DB.QueryRowContext(ctx, query, hash[:], provider, m.Clock.Now()).Scan(&verifier)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return "", ErrRecordNotFound
+		default:
+			return "", err
+		}
+	}
+
+	return verifier, nil
+}
+
+// GetUser returns the user who has linked the account with the given subject at the provider, or ErrRecordNotFound
+// if nobody has
+func (m OAuthModel) GetUser(provider, subject string) (*User, error) {
+	query := `
+		SELECT users.id, users.created_at, users.name, users.email, users.password_hash, users.activated, users.version, users.avatar_key,
+		users.disabled_at
+		FROM users
+		INNER JOIN oauth_identities ON users.id = oauth_identities.user_id
+		WHERE oauth_identities.provider = $1 AND oauth_identities.subject = $2`
+
+	ctx, cancel := withReadTimeout(context.Background())
+	defer cancel()
+
+	var user User
+
+	err := m.DB.QueryRowContext(ctx, query, provider, subject).Scan(
+		&user.ID,
+		&user.CreatedAt,
+		&user.Name,
+		&user.Email,
+		&user.Password.hash,
+		&user.Activated,
+		&user.Version,
+		&user.AvatarKey,
+		&user.DisabledAt,
+	)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	user.setAvatarURL()
+
+	return &user, nil
+}
+
+// Link records that the account with the given subject at the provider belongs to the user. Linking an account which
+// is already linked does nothing
+func (m OAuthModel) Link(userID int64, provider, subject string) error {
+	query := `
+		INSERT INTO oauth_identities (provider, subject, user_id)
+		VALUES ($1, $2, $3)
+		ON CONFLICT DO NOTHING`
+
+	ctx, cancel := withWriteTimeout(context.Background())
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, query, provider, subject, userID)
+	return err
+}
+
+// Mock data for testing
+type MockOAuthModel struct{}
+
+func (m MockOAuthModel) NewState(provider string, ttl time.Duration) (string, string, error) {
+	return "", "", nil
+}
+
+func (m MockOAuthModel) ConsumeState(provider, statePlaintext string) (string, error) {
+	return "", nil
+}
+
+func (m MockOAuthModel) GetUser(provider, subject string) (*User, error) {
+	return nil, nil
+}
+
+func (m MockOAuthModel) Link(userID int64, provider, subject string) error {
+	return nil
+}
@@ -0,0 +1,439 @@
+package data
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/url"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/nytro04/greenlight/internal/clock"
+	"github.com/nytro04/greenlight/internal/validator"
+)
+
+// ErrDuplicateSlug is returned when an organization is created with a slug which is already taken
+var ErrDuplicateSlug = errors.New("duplicate slug")
+
+// ErrDuplicateDomain is returned when the single sign-on settings claim a domain which belongs to another organization
+var ErrDuplicateDomain = errors.New("duplicate domain")
+
+// Define constants for the roles a user can have within an organization
+const (
+	OrganizationRoleOwner  = "owner"
+	OrganizationRoleEditor = "editor"
+	OrganizationRoleMember = "member"
+)
+
+// OrganizationRoles holds the valid organization roles, ordered from the least to the most privileged
+var OrganizationRoles = []string{OrganizationRoleMember, OrganizationRoleEditor, OrganizationRoleOwner}
+
+// OrganizationRoleRank returns the position of the role in OrganizationRoles, so two roles can be compared. Unknown roles rank lowest
+func OrganizationRoleRank(role string) int {
+	for i, r := range OrganizationRoles {
+		if r == role {
+			return i
+		}
+	}
+	return -1
+}
+
+type Organization struct {
+	ID        int64     `json:"id"`
+	CreatedAt time.Time `json:"created_at"`
+	Name      string    `json:"name"`
+	Slug      string    `json:"slug"`
+	Version   int32     `json:"version"`
+}
+
+// OrganizationSSO holds the OIDC single sign-on settings for an organization. RoleClaim names the ID token claim
+// (e.g. "groups") whose values are looked up in RoleMapping to decide which organization role a member gets,
+// DefaultRole is used when none of the values are mapped. Domains lists the email domains an administrator has verified the
+// organization owns, only accounts with an address at one of them can be linked or created by the identity provider
+type OrganizationSSO struct {
+	OrganizationID int64             `json:"organization_id"`
+	Issuer         string            `json:"issuer"`
+	ClientID       string            `json:"client_id"`
+	ClientSecret   string            `json:"-"`
+	RoleClaim      string            `json:"role_claim"`
+	RoleMapping    map[string]string `json:"role_mapping"`
+	DefaultRole    string            `json:"default_role"`
+	Domains        []string          `json:"domains"`
+}
+
+// OwnsEmail reports whether the email address is at one of the organization's verified domains
+func (sso *OrganizationSSO) OwnsEmail(email string) bool {
+	at := strings.LastIndexByte(email, '@')
+	if at == -1 {
+		return false
+	}
+
+	return slices.Contains(sso.Domains, strings.ToLower(email[at+1:]))
+}
+
+func ValidateOrganization(v *validator.Validator, org *Organization) {
+	v.Check(org.Name != "", "name", "must be provided")
+	v.Check(len(org.Name) <= 500, "name", "must not be more than 500 bytes long")
+
+	v.Check(org.Slug != "", "slug", "must be provided")
+	v.Check(len(org.Slug) <= 100, "slug", "must not be more than 100 bytes long")
+	v.Check(validator.Matches(org.Slug, validator.SlugRX), "slug", "must only contain lowercase letters, numbers and hyphens")
+}
+
+func ValidateOrganizationSSO(v *validator.Validator, sso *OrganizationSSO) {
+	issuer, err := url.Parse(sso.Issuer)
+	v.Check(sso.Issuer != "", "issuer", "must be provided")
+	v.Check(err == nil && issuer.Scheme == "https" && issuer.Host != "", "issuer", "must be a valid https URL")
+
+	v.Check(sso.ClientID != "", "client_id", "must be provided")
+	v.Check(sso.ClientSecret != "", "client_secret", "must be provided")
+
+	v.Check(validator.In(sso.DefaultRole, OrganizationRoles...), "default_role", "must be a valid organization role")
+
+	// the owner role can't be handed out by the identity provider, owners are managed by the organization itself
+	v.Check(sso.DefaultRole != OrganizationRoleOwner, "default_role", "must not be owner")
+	for _, role := range sso.RoleMapping {
+		v.Check(validator.In(role, OrganizationRoleMember, OrganizationRoleEditor), "role_mapping", "must only map to the member or editor roles")
+	}
+	v.Check(len(sso.RoleMapping) == 0 || sso.RoleClaim != "", "role_claim", "must be provided when a role mapping is set")
+
+	v.Check(len(sso.Domains) <= 50, "domains", "must not contain more than 50 entries")
+	v.Check(validator.Unique(sso.Domains), "domains", "must not contain duplicate values")
+	for _, domain := range sso.Domains {
+		v.Check(validator.Matches(domain, validator.DomainRX), "domains", "must only contain lowercase domain names")
+	}
+}
+
+type OrganizationModel struct {
+	DB     DBTX
+	Clock  clock.Clock
+	Random io.Reader
+}
+
+// Insert creates a new organization and makes the user with the given ID its owner. Both statements run in a
+// transaction, so an organization is never left without an owner
+func (m OrganizationModel) Insert(org *Organization, ownerID int64) error {
+	ctx, cancel := withWriteTimeout(context.Background())
+	defer cancel()
+
+	tx, err := beginTx(ctx, m.DB)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	query := `
+		INSERT INTO organizations (name, slug)
+		VALUES ($1, $2)
+		RETURNING id, created_at, version`
+
+	err = tx.QueryRowContext(ctx, query, org.Name, org.Slug).Scan(&org.ID, &org.CreatedAt, &org.Version)
+	if err != nil {
+		switch {
+		case isUniqueViolation(err, "organizations_slug_key"):
+			return ErrDuplicateSlug
+		default:
+			return err
+		}
+	}
+
+	query = `
+		INSERT INTO organization_members (organization_id, user_id, role)
+		VALUES ($1, $2, $3)`
+
+	_, err = tx.ExecContext(ctx, query, org.ID, ownerID, OrganizationRoleOwner)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// GetBySlug returns the organization with the given slug, or ErrRecordNotFound
+func (m OrganizationModel) GetBySlug(slug string) (*Organization, error) {
+	query := `
+		SELECT id, created_at, name, slug, version
+		FROM organizations
+		WHERE slug = $1`
+
+	return m.get(query, slug)
+}
+
+// Get returns the organization with the given ID, or ErrRecordNotFound
+func (m OrganizationModel) Get(id int64) (*Organization, error) {
+	if id < 1 {
+		return nil, ErrRecordNotFound
+	}
+
+	query := `
+		SELECT id, created_at, name, slug, version
+		FROM organizations
+		WHERE id = $1`
+
+	return m.get(query, id)
+}
+
+func (m OrganizationModel) get(query string, arg interface{}) (*Organization, error) {
+	var org Organization
+
+	ctx, cancel := withWriteTimeout(context.Background())
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, arg).Scan(&org.ID, &org.CreatedAt, &org.Name, &org.Slug, &org.Version)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	return &org, nil
+}
+
+// GetMemberRole returns the role of the user in the organization, or ErrRecordNotFound if they are not a member
+func (m OrganizationModel) GetMemberRole(orgID, userID int64) (string, error) {
+	query := `
+		SELECT role
+		FROM organization_members
+		WHERE organization_id = $1 AND user_id = $2`
+
+	ctx, cancel := withReadTimeout(context.Background())
+	defer cancel()
+
+	var role string
+
+	err := m.DB.QueryRowContext(ctx, query, orgID, userID).Scan(&role)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return "", ErrRecordNotFound
+		default:
+			return "", err
+		}
+	}
+
+	return role, nil
+}
+
+// SetMember adds the user to the organization with the given role, or updates their role if they are already a member
+func (m OrganizationModel) SetMember(orgID, userID int64, role string) error {
+	query := `
+		INSERT INTO organization_members (organization_id, user_id, role)
+		VALUES ($1, $2, $3)
+		ON CONFLICT (organization_id, user_id) DO UPDATE SET role = EXCLUDED.role`
+
+	ctx, cancel := withWriteTimeout(context.Background())
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, query, orgID, userID, role)
+	return err
+}
+
+// GetSSO returns the single sign-on settings for the organization, or ErrRecordNotFound if SSO hasn't been configured
+func (m OrganizationModel) GetSSO(orgID int64) (*OrganizationSSO, error) {
+	query := `
+		SELECT organization_id, issuer, client_id, client_secret, role_claim, role_mapping, default_role,
+			ARRAY(SELECT domain::text FROM organization_domains WHERE organization_id = $1 ORDER BY domain)
+		FROM organization_sso
+		WHERE organization_id = $1`
+
+	ctx, cancel := withReadTimeout(context.Background())
+	defer cancel()
+
+	var sso OrganizationSSO
+	var mapping []byte
+
+	err := m.DB.QueryRowContext(ctx, query, orgID).Scan(
+		&sso.OrganizationID,
+		&sso.Issuer,
+		&sso.ClientID,
+		&sso.ClientSecret,
+		&sso.RoleClaim,
+		&mapping,
+		&sso.DefaultRole,
+		scanArray(&sso.Domains),
+	)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	err = json.Unmarshal(mapping, &sso.RoleMapping)
+	if err != nil {
+		return nil, err
+	}
+
+	return &sso, nil
+}
+
+// SetSSO creates or replaces the single sign-on settings for an organization along with its verified domains, returning
+// ErrDuplicateDomain if one of the domains belongs to another organization
+func (m OrganizationModel) SetSSO(sso *OrganizationSSO) error {
+	mapping := []byte("{}")
+	if sso.RoleMapping != nil {
+		var err error
+		mapping, err = json.Marshal(sso.RoleMapping)
+		if err != nil {
+			return err
+		}
+	}
+
+	domains := sso.Domains
+	if domains == nil {
+		domains = []string{}
+	}
+
+	ctx, cancel := withWriteTimeout(context.Background())
+	defer cancel()
+
+	tx, err := beginTx(ctx, m.DB)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	query := `
+		INSERT INTO organization_sso (organization_id, issuer, client_id, client_secret, role_claim, role_mapping, default_role)
+		VALUES ($1, $2, $3, $4, $5, $6, $7)
+		ON CONFLICT (organization_id) DO UPDATE
+		SET issuer = EXCLUDED.issuer, client_id = EXCLUDED.client_id, client_secret = EXCLUDED.client_secret,
+			role_claim = EXCLUDED.role_claim, role_mapping = EXCLUDED.role_mapping, default_role = EXCLUDED.default_role`
+
+	args := []interface{}{sso.OrganizationID, sso.Issuer, sso.ClientID, sso.ClientSecret, sso.RoleClaim, mapping, sso.DefaultRole}
+
+	_, err = tx.ExecContext(ctx, query, args...)
+	if err != nil {
+		return err
+	}
+
+	query = `
+		DELETE FROM organization_domains
+		WHERE organization_id = $1`
+
+	_, err = tx.ExecContext(ctx, query, sso.OrganizationID)
+	if err != nil {
+		return err
+	}
+
+	query = `
+		INSERT INTO organization_domains (domain, organization_id)
+		SELECT unnest($2::text[]), $1`
+
+	_, err = tx.ExecContext(ctx, query, sso.OrganizationID, domains)
+	if err != nil {
+		switch {
+		case isUniqueViolation(err, "organization_domains_pkey"):
+			return ErrDuplicateDomain
+		default:
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// NewSSOState stores the state of a single sign-on login which has been started for the organization. It returns the
+// plaintext state, which is passed through the identity provider and back to the callback, and the nonce which the
+// returned ID token must contain
+func (m OrganizationModel) NewSSOState(orgID int64, ttl time.Duration) (string, string, error) {
+	state, err := generateToken(m.Clock, m.Random, 0, ttl, "sso")
+	if err != nil {
+		return "", "", err
+	}
+
+	nonce, err := generateToken(m.Clock, m.Random, 0, ttl, "sso")
+	if err != nil {
+		return "", "", err
+	}
+
+	query := `
+		INSERT INTO sso_states (hash, organization_id, nonce, expiry)
+		VALUES ($1, $2, $3, $4)`
+
+	ctx, cancel := withWriteTimeout(context.Background())
+	defer cancel()
+
+	_, err = m.DB.ExecContext(ctx, query, state.Hash, orgID, nonce.Plaintext, state.Expiry)
+	if err != nil {
+		return "", "", err
+	}
+
+	return state.Plaintext, nonce.Plaintext, nil
+}
+
+// ConsumeSSOState deletes the state of a single sign-on login and returns its nonce, so each state can only be used
+// once. ErrRecordNotFound is returned if the state doesn't exist, has expired or belongs to another organization
+func (m OrganizationModel) ConsumeSSOState(orgID int64, statePlaintext string) (string, error) {
+	hash := sha256.Sum256([]byte(statePlaintext))
+
+	query := `
+		DELETE FROM sso_states
+		WHERE hash = $1 AND organization_id = $2 AND expiry > $3
+		RETURNING nonce`
+
+	ctx, cancel := withWriteTimeout(context.Background())
+	defer cancel()
+
+	var nonce string
+
+	err := m.DB.QueryRowContext(ctx, query, hash[:], orgID, m.Clock.Now()).Scan(&nonce)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return "", ErrRecordNotFound
+		default:
+			return "", err
+		}
+	}
+
+	return nonce, nil
+}
+
+// Mock data for testing
+type MockOrganizationModel struct{}
+
+func (m MockOrganizationModel) Insert(org *Organization, ownerID int64) error {
+	return nil
+}
+
+func (m MockOrganizationModel) GetBySlug(slug string) (*Organization, error) {
+	return nil, nil
+}
+
+func (m MockOrganizationModel) Get(id int64) (*Organization, error) {
+	return nil, nil
+}
+
+func (m MockOrganizationModel) GetMemberRole(orgID, userID int64) (string, error) {
+	return "", nil
+}
+
+func (m MockOrganizationModel) SetMember(orgID, userID int64, role string) error {
+	return nil
+}
+
+func (m MockOrganizationModel) GetSSO(orgID int64) (*OrganizationSSO, error) {
+	return nil, nil
+}
+
+func (m MockOrganizationModel) SetSSO(sso *OrganizationSSO) error {
+	return nil
+}
+
+func (m MockOrganizationModel) NewSSOState(orgID int64, ttl time.Duration) (string, string, error) {
+	return "", "", nil
+}
+
+func (m MockOrganizationModel) ConsumeSSOState(orgID int64, statePlaintext string) (string, error) {
+	return "", nil
+}
@@ -0,0 +1,312 @@
+package data
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"io"
+	"time"
+
+	"github.com/go-webauthn/webauthn/webauthn"
+	"github.com/nytro04/greenlight/internal/clock"
+	"github.com/nytro04/greenlight/internal/validator"
+)
+
+// ErrDuplicatePasskey is returned when the authenticator tries to register a credential which is already stored
+var ErrDuplicatePasskey = errors.New("duplicate passkey")
+
+// Passkey holds a single WebAuthn credential registered by a user. The credential itself (public key, sign count,
+// transports etc.) is stored as JSON and never sent to the client
+type Passkey struct {
+	ID           int64               `json:"id"`
+	CreatedAt    time.Time           `json:"created_at"`
+	UserID       int64               `json:"-"`
+	Name         string              `json:"name"`
+	CredentialID []byte              `json:"-"`
+	Credential   webauthn.Credential `json:"-"`
+	LastUsedAt   *time.Time          `json:"last_used_at"`
+}
+
+// ValidatePasskeyName checks that the user provided name for a passkey is not too long. The name is optional
+func ValidatePasskeyName(v *validator.Validator, name string) {
+	v.Check(len(name) <= 100, "name", "must not be more than 100 bytes long")
+}
+
+// WebAuthnUser wraps a User together with their registered credentials so that it satisfies the webauthn.User interface
+type WebAuthnUser struct {
+	*User
+	Credentials []webauthn.Credential
+}
+
+// WebAuthnID returns the user handle stored by the authenticator. We use the 8-byte big endian encoding of the user ID
+// so that the user can be looked up again from the handle returned during a discoverable (passkey) login
+func (u WebAuthnUser) WebAuthnID() []byte {
+	return WebAuthnUserHandle(u.ID)
+}
+
+func (u WebAuthnUser) WebAuthnName() string {
+	return u.Email
+}
+
+func (u WebAuthnUser) WebAuthnDisplayName() string {
+	return u.Name
+}
+
+func (u WebAuthnUser) WebAuthnCredentials() []webauthn.Credential {
+	return u.Credentials
+}
+
+// WebAuthnUserHandle encodes a user ID as a WebAuthn user handle
+func WebAuthnUserHandle(userID int64) []byte {
+	handle := make([]byte, 8)
+	binary.BigEndian.PutUint64(handle, uint64(userID))
+	return handle
+}
+
+// UserIDFromWebAuthnHandle decodes the user ID from a WebAuthn user handle, returning ErrRecordNotFound if the handle is malformed
+func UserIDFromWebAuthnHandle(handle []byte) (int64, error) {
+	if len(handle) != 8 {
+		return 0, ErrRecordNotFound
+	}
+	return int64(binary.BigEndian.Uint64(handle)), nil
+}
+
+// PasskeyModel wraps the connection pool and is used to read and write passkeys and the WebAuthn ceremony sessions
+type PasskeyModel struct {
+	DB     DBTX
+	Clock  clock.Clock
+	Random io.Reader
+}
+
+// Insert a new passkey for a user, returning ErrDuplicatePasskey if the credential has been registered before
+func (m PasskeyModel) Insert(passkey *Passkey) error {
+	credential, err := json.Marshal(passkey.Credential)
+	if err != nil {
+		return err
+	}
+
+	query := `
+		INSERT INTO passkeys (user_id, name, credential_id, credential)
+		VALUES ($1, $2, $3, $4)
+		RETURNING id, created_at`
+
+	args := []interface{}{passkey.UserID, passkey.Name, passkey.Credential.ID, credential}
+
+	ctx, cancel := withWriteTimeout(context.Background())
+	defer cancel()
+
+	err = m.DB.QueryRowContext(ctx, query, args...).Scan(&passkey.ID, &passkey.CreatedAt)
+	if err != nil {
+		switch {
+		case isUniqueViolation(err, "passkeys_credential_id_key"):
+			return ErrDuplicatePasskey
+		default:
+			return err
+		}
+	}
+
+	passkey.CredentialID = passkey.Credential.ID
+
+	return nil
+}
+
+// GetAllForUser returns all the passkeys registered by a user, oldest first
+func (m PasskeyModel) GetAllForUser(userID int64) ([]*Passkey, error) {
+	query := `
+		SELECT id, created_at, user_id, name, credential_id, credential, last_used_at
+		FROM passkeys
+		WHERE user_id = $1
+		ORDER BY id ASC`
+
+	ctx, cancel := withReadTimeout(context.Background())
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	passkeys := []*Passkey{}
+
+	for rows.Next() {
+		var passkey Passkey
+		var credential []byte
+
+		err := rows.Scan(
+			&passkey.ID,
+			&passkey.CreatedAt,
+			&passkey.UserID,
+			&passkey.Name,
+			&passkey.CredentialID,
+			&credential,
+			&passkey.LastUsedAt,
+		)
+		if err != nil {
+			return nil, err
+		}
+
+		err = json.Unmarshal(credential, &passkey.Credential)
+		if err != nil {
+			return nil, err
+		}
+
+		passkeys = append(passkeys, &passkey)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return passkeys, nil
+}
+
+// UpdateCredential stores the updated credential (the authenticator sign count changes on every login) and records when it was last used
+func (m PasskeyModel) UpdateCredential(userID int64, credential *webauthn.Credential) error {
+	js, err := json.Marshal(credential)
+	if err != nil {
+		return err
+	}
+
+	query := `
+		UPDATE passkeys
+		SET credential = $1, last_used_at = NOW()
+		WHERE credential_id = $2 AND user_id = $3`
+
+	ctx, cancel := withWriteTimeout(context.Background())
+	defer cancel()
+
+	_, err = m.DB.ExecContext(ctx, query, js, credential.ID, userID)
+	return err
+}
+
+// Delete a passkey belonging to a user. ErrRecordNotFound is returned if the passkey doesn't exist or belongs to someone else
+func (m PasskeyModel) Delete(id, userID int64) error {
+	if id < 1 {
+		return ErrRecordNotFound
+	}
+
+	query := `
+		DELETE FROM passkeys
+		WHERE id = $1 AND user_id = $2`
+
+	ctx, cancel := withWriteTimeout(context.Background())
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, query, id, userID)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+
+	return nil
+}
+
+// NewSession stores the session data for a registration or login ceremony and returns the plaintext session token the
+// client must send back when finishing the ceremony. Only the SHA-256 hash of the token is stored, the same as for the
+// other tokens. The userID is zero for a discoverable login, where the user isn't known until the ceremony finishes
+func (m PasskeyModel) NewSession(userID int64, session *webauthn.SessionData, ttl time.Duration) (string, error) {
+	token, err := generateToken(m.Clock, m.Random, userID, ttl, "webauthn")
+	if err != nil {
+		return "", err
+	}
+
+	js, err := json.Marshal(session)
+	if err != nil {
+		return "", err
+	}
+
+	// store a NULL user_id for discoverable logins
+	var user *int64
+	if userID != 0 {
+		user = &userID
+	}
+
+	query := `
+		INSERT INTO webauthn_sessions (hash, user_id, data, expiry)
+		VALUES ($1, $2, $3, $4)`
+
+	ctx, cancel := withWriteTimeout(context.Background())
+	defer cancel()
+
+	_, err = m.DB.ExecContext(ctx, query, token.Hash, user, js, token.Expiry)
+	if err != nil {
+		return "", err
+	}
+
+	return token.Plaintext, nil
+}
+
+// ConsumeSession retrieves and deletes the session data for a ceremony in one step, so a session can only ever be used once.
+// The userID must match the user the session was created for (zero for discoverable logins). ErrRecordNotFound is returned
+// if there is no matching, unexpired session
+func (m PasskeyModel) ConsumeSession(userID int64, sessionPlaintext string) (*webauthn.SessionData, error) {
+	hash := sha256.Sum256([]byte(sessionPlaintext))
+
+	query := `
+		DELETE FROM webauthn_sessions
+		WHERE hash = $1 AND COALESCE(user_id, 0) = $2 AND expiry > $3
+		RETURNING data`
+
+	ctx, cancel := withWriteTimeout(context.Background())
+	defer cancel()
+
+	var js []byte
+
+	err := m.DB.QueryRowContext(ctx, query, hash[:], userID, m.Clock.Now()).Scan(&js)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	var session webauthn.SessionData
+
+	err = json.Unmarshal(js, &session)
+	if err != nil {
+		return nil, err
+	}
+
+	return &session, nil
+}
+
+// Mock data for testing
+type MockPasskeyModel struct{}
+
+func (m MockPasskeyModel) Insert(passkey *Passkey) error {
+	return nil
+}
+
+func (m MockPasskeyModel) GetAllForUser(userID int64) ([]*Passkey, error) {
+	return nil, nil
+}
+
+func (m MockPasskeyModel) UpdateCredential(userID int64, credential *webauthn.Credential) error {
+	return nil
+}
+
+func (m MockPasskeyModel) Delete(id, userID int64) error {
+	return nil
+}
+
+func (m MockPasskeyModel) NewSession(userID int64, session *webauthn.SessionData, ttl time.Duration) (string, error) {
+	return "", nil
+}
+
+func (m MockPasskeyModel) ConsumeSession(userID int64, sessionPlaintext string) (*webauthn.SessionData, error) {
+	return nil, nil
+}
@@ -0,0 +1,411 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/nytro04/greenlight/internal/validator"
+)
+
+// ErrDuplicateCredit is returned when a person is credited twice on a movie for the same role and character
+var ErrDuplicateCredit = errors.New("duplicate credit")
+
+// The roles people can be credited for on a movie
+var CreditRoles = []string{"cast", "director", "writer", "producer", "composer", "cinematographer", "editor"}
+
+// Person is a member of the cast or crew of one or more movies
+type Person struct {
+	ID        int64     `json:"id"`
+	CreatedAt time.Time `json:"created_at"`
+	Name      string    `json:"name"`
+	Biography string    `json:"biography"`
+	BirthDate *Date     `json:"birth_date,omitempty"`
+	Version   int32     `json:"version"`
+}
+
+// Credit links a person to a movie with the role they had on it. The movie title is set when listing the movies of a
+// person, and the person name when listing the credits of a movie
+type Credit struct {
+	ID           int64  `json:"id"`
+	MovieID      int64  `json:"movie_id"`
+	MovieTitle   string `json:"movie_title,omitempty"`
+	MovieYear    int32  `json:"movie_year,omitempty"`
+	PersonID     int64  `json:"person_id"`
+	PersonName   string `json:"person_name,omitempty"`
+	Role         string `json:"role"`
+	Character    string `json:"character,omitempty"`
+	BillingOrder int32  `json:"billing_order"`
+}
+
+func ValidatePerson(v *validator.Validator, person *Person) {
+	v.Check(person.Name != "", "name", "must be provided")
+	v.Check(len(person.Name) <= 500, "name", "must not be more than 500 bytes long")
+	v.Check(len(person.Biography) <= 10_000, "biography", "must not be more than 10000 bytes long")
+
+	if person.BirthDate != nil {
+		v.Check(!person.BirthDate.After(time.Now()), "birth_date", "must not be in the future")
+	}
+}
+
+func ValidateCredit(v *validator.Validator, credit *Credit) {
+	v.Check(credit.PersonID > 0, "person_id", "must be provided")
+	v.Check(validator.In(credit.Role, CreditRoles...), "role", "must be one of the supported roles")
+	v.Check(len(credit.Character) <= 500, "character", "must not be more than 500 bytes long")
+	v.Check(credit.Character == "" || credit.Role == "cast", "character", "must only be provided for cast credits")
+	v.Check(credit.BillingOrder >= 0, "billing_order", "must not be negative")
+}
+
+type PersonModel struct {
+	DB     DBTX
+	Reader DBTX // runs the read-only queries, on the read replica if one is configured
+}
+
+// Insert method to create a new person record
+func (m PersonModel) Insert(person *Person) error {
+	query := `
+		INSERT INTO people (name, biography, birth_date)
+		VALUES ($1, $2, $3)
+		RETURNING id, created_at, version`
+
+	args := []interface{}{person.Name, person.Biography, person.BirthDate}
+
+	ctx, cancel := withWriteTimeout(context.Background())
+	defer cancel()
+
+	return m.DB.QueryRowContext(ctx, query, args...).Scan(&person.ID, &person.CreatedAt, &person.Version)
+}
+
+func (m PersonModel) Get(id int64) (*Person, error) {
+	if id < 1 {
+		return nil, ErrRecordNotFound
+	}
+
+	query := `
+		SELECT id, created_at, name, biography, birth_date, version
+		FROM people
+		WHERE id = $1`
+
+	var person Person
+
+	ctx, cancel := withReadTimeout(context.Background())
+	defer cancel()
+
+	err := m.Reader.QueryRowContext(ctx, query, id).Scan(
+		&person.ID,
+		&person.CreatedAt,
+		&person.Name,
+		&person.Biography,
+		&person.BirthDate,
+		&person.Version,
+	)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	return &person, nil
+}
+
+// GetAll returns a page of people, optionally filtered by a full text search on their name in the same way as the movie titles
+func (m PersonModel) GetAll(name string, filters Filters) ([]*Person, Metadata, error) {
+	sortColumn, err := filters.sortColumn()
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+
+	query := fmt.Sprintf(`
+		SELECT count(*) OVER(), id, created_at, name, biography, birth_date, version
+		FROM people
+		WHERE (to_tsvector('simple', name) @@ plainto_tsquery('simple', $1) OR $1 = '')
+		ORDER BY %s %s, id ASC
+		LIMIT $2 OFFSET $3`, sortColumn, filters.sortDirection())
+
+	ctx, cancel := withReadTimeout(context.Background())
+	defer cancel()
+
+	rows, err := m.Reader.QueryContext(ctx, query, name, filters.limit(), filters.offset())
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+	defer rows.Close()
+
+	totalRecords := 0
+	people := []*Person{}
+
+	for rows.Next() {
+		var person Person
+
+		err := rows.Scan(
+			&totalRecords,
+			&person.ID,
+			&person.CreatedAt,
+			&person.Name,
+			&person.Biography,
+			&person.BirthDate,
+			&person.Version,
+		)
+		if err != nil {
+			return nil, Metadata{}, err
+		}
+
+		people = append(people, &person)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, Metadata{}, err
+	}
+
+	metadata := calculateMetadata(totalRecords, filters.Page, filters.PageSize)
+
+	return people, metadata, nil
+}
+
+// Update method to update the person record, using the version number to detect concurrent edits
+func (m PersonModel) Update(person *Person) error {
+	query := `
+		UPDATE people
+		SET name = $1, biography = $2, birth_date = $3, version = version + 1
+		WHERE id = $4 AND version = $5
+		RETURNING version`
+
+	args := []interface{}{person.Name, person.Biography, person.BirthDate, person.ID, person.Version}
+
+	ctx, cancel := withWriteTimeout(context.Background())
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, args...).Scan(&person.Version)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrEditConflict
+		default:
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Delete method to delete the person record, their credits are deleted with them
+func (m PersonModel) Delete(id int64) error {
+	if id < 1 {
+		return ErrRecordNotFound
+	}
+
+	query := `
+		DELETE FROM people
+		WHERE id = $1`
+
+	ctx, cancel := withWriteTimeout(context.Background())
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, query, id)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+
+	return nil
+}
+
+// AddCredit credits a person on a movie. ErrRecordNotFound is returned if the movie or the person doesn't exist
+func (m PersonModel) AddCredit(credit *Credit) error {
+	query := `
+		INSERT INTO movie_credits (movie_id, person_id, role, character, billing_order)
+		VALUES ($1, $2, $3, $4, $5)
+		RETURNING id`
+
+	args := []interface{}{credit.MovieID, credit.PersonID, credit.Role, credit.Character, credit.BillingOrder}
+
+	ctx, cancel := withWriteTimeout(context.Background())
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, args...).Scan(&credit.ID)
+	if err != nil {
+		switch {
+		case isUniqueViolation(err, "movie_credits_movie_id_person_id_role_character_key"):
+			return ErrDuplicateCredit
+		case isForeignKeyViolation(err, "movie_credits_movie_id_fkey"),
+			isForeignKeyViolation(err, "movie_credits_person_id_fkey"):
+			return ErrRecordNotFound
+		default:
+			return err
+		}
+	}
+
+	return nil
+}
+
+// DeleteCredit removes a credit from a movie
+func (m PersonModel) DeleteCredit(movieID, id int64) error {
+	query := `
+		DELETE FROM movie_credits
+		WHERE id = $1 AND movie_id = $2`
+
+	ctx, cancel := withWriteTimeout(context.Background())
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, query, id, movieID)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+
+	return nil
+}
+
+// GetCreditsForMovies returns the credits of each of the movies, keyed by movie ID and in billing order. The credits of
+// a whole page of movies are fetched with a single query, rather than one query per movie
+func (m PersonModel) GetCreditsForMovies(movieIDs ...int64) (map[int64][]*Credit, error) {
+	query := `
+		SELECT movie_credits.id, movie_id, person_id, people.name, role, character, billing_order
+		FROM movie_credits
+		INNER JOIN people ON people.id = movie_credits.person_id
+		WHERE movie_id = ANY($1)
+		ORDER BY movie_id, billing_order, movie_credits.id`
+
+	ctx, cancel := withReadTimeout(context.Background())
+	defer cancel()
+
+	rows, err := m.Reader.QueryContext(ctx, query, movieIDs)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	credits := make(map[int64][]*Credit)
+
+	for rows.Next() {
+		var credit Credit
+
+		err := rows.Scan(
+			&credit.ID,
+			&credit.MovieID,
+			&credit.PersonID,
+			&credit.PersonName,
+			&credit.Role,
+			&credit.Character,
+			&credit.BillingOrder,
+		)
+		if err != nil {
+			return nil, err
+		}
+
+		credits[credit.MovieID] = append(credits[credit.MovieID], &credit)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return credits, nil
+}
+
+// GetCreditsForPerson returns the movies a person has been credited on, newest first
+func (m PersonModel) GetCreditsForPerson(personID int64) ([]*Credit, error) {
+	query := `
+		SELECT movie_credits.id, movie_id, movies.title, movies.year, person_id, role, character, billing_order
+		FROM movie_credits
+		INNER JOIN movies ON movies.id = movie_credits.movie_id
+		WHERE person_id = $1
+		ORDER BY movies.year DESC, movies.id, movie_credits.id`
+
+	ctx, cancel := withReadTimeout(context.Background())
+	defer cancel()
+
+	rows, err := m.Reader.QueryContext(ctx, query, personID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	credits := []*Credit{}
+
+	for rows.Next() {
+		var credit Credit
+
+		err := rows.Scan(
+			&credit.ID,
+			&credit.MovieID,
+			&credit.MovieTitle,
+			&credit.MovieYear,
+			&credit.PersonID,
+			&credit.Role,
+			&credit.Character,
+			&credit.BillingOrder,
+		)
+		if err != nil {
+			return nil, err
+		}
+
+		credits = append(credits, &credit)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return credits, nil
+}
+
+// Mock data for testing
+type MockPersonModel struct{}
+
+func (m MockPersonModel) Insert(person *Person) error {
+	return nil
+}
+
+func (m MockPersonModel) Get(id int64) (*Person, error) {
+	return nil, nil
+}
+
+func (m MockPersonModel) GetAll(name string, filters Filters) ([]*Person, Metadata, error) {
+	return nil, Metadata{}, nil
+}
+
+func (m MockPersonModel) Update(person *Person) error {
+	return nil
+}
+
+func (m MockPersonModel) Delete(id int64) error {
+	return nil
+}
+
+func (m MockPersonModel) AddCredit(credit *Credit) error {
+	return nil
+}
+
+func (m MockPersonModel) DeleteCredit(movieID, id int64) error {
+	return nil
+}
+
+func (m MockPersonModel) GetCreditsForMovies(movieIDs ...int64) (map[int64][]*Credit, error) {
+	return nil, nil
+}
+
+func (m MockPersonModel) GetCreditsForPerson(personID int64) ([]*Credit, error) {
+	return nil, nil
+}
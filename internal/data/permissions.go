@@ -2,10 +2,6 @@ package data
 
 import (
 	"context"
-	"database/sql"
-	"time"
-
-	"github.com/lib/pq"
 )
 
 // Permission slice to hold the permissions for a user eg. "movies:read"
@@ -23,11 +19,11 @@ func (p Permissions) Include(code string) bool {
 
 // PermissionModel defines the structure for the permission model
 type PermissionModel struct {
-	DB *sql.DB
+	DB DBTX
 }
 
 // GetAllForUser returns all permissions for a specific user
-func (m PermissionModel) GetAllForUser(userId int64) (Permissions, error) {
+func (m PermissionModel) GetAllForUser(ctx context.Context, userId int64) (Permissions, error) {
 	query := `
 		SELECT permissions.code
 		FROM permissions
@@ -36,7 +32,7 @@ func (m PermissionModel) GetAllForUser(userId int64) (Permissions, error) {
 		WHERE users.id = $1
 		`
 
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	ctx, cancel := withReadTimeout(ctx)
 	defer cancel()
 
 	rows, err := m.DB.QueryContext(ctx, query, userId)
@@ -63,21 +59,84 @@ func (m PermissionModel) GetAllForUser(userId int64) (Permissions, error) {
 	return permissions, nil
 }
 
-func (m PermissionModel) AddForUser(userID int64, codes ...string) error {
+func (m PermissionModel) AddForUser(ctx context.Context, userID int64, codes ...string) error {
 	query := `
 		INSERT INTO users_permissions
-		SELECT $1, permissions.id FROM permissions WHERE permissions.code = ANY($2)`
+		SELECT $1, permissions.id FROM permissions WHERE permissions.code = ANY($2)
+		ON CONFLICT DO NOTHING`
 
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	ctx, cancel := withWriteTimeout(ctx)
 	defer cancel()
 
-	_, err := m.DB.ExecContext(ctx, query, userID, pq.Array(codes))
+	_, err := m.DB.ExecContext(ctx, query, userID, codes)
 	return err
 }
 
+// RemoveForUser revokes the permissions with the given codes from the user, codes they don't have are ignored
+func (m PermissionModel) RemoveForUser(ctx context.Context, userID int64, codes ...string) error {
+	query := `
+		DELETE FROM users_permissions
+		USING permissions
+		WHERE users_permissions.permission_id = permissions.id
+		AND users_permissions.user_id = $1
+		AND permissions.code = ANY($2)`
+
+	ctx, cancel := withWriteTimeout(ctx)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, query, userID, codes)
+	return err
+}
+
+// GetAll returns the codes of every permission which can be granted
+func (m PermissionModel) GetAll(ctx context.Context) (Permissions, error) {
+	query := `
+		SELECT code
+		FROM permissions
+		ORDER BY code`
+
+	ctx, cancel := withReadTimeout(ctx)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	permissions := Permissions{}
+
+	for rows.Next() {
+		var permission string
+
+		err := rows.Scan(&permission)
+		if err != nil {
+			return nil, err
+		}
+		permissions = append(permissions, permission)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return permissions, nil
+}
+
 // Mock data for testing
 type MockPermissionModel struct{}
 
-func (m MockPermissionModel) GetAllForUser(userId int64) (Permissions, error) {
+func (m MockPermissionModel) GetAllForUser(ctx context.Context, userId int64) (Permissions, error) {
+	return Permissions{"movies:read", "movies:write"}, nil
+}
+
+func (m MockPermissionModel) AddForUser(ctx context.Context, userID int64, codes ...string) error {
+	return nil
+}
+
+func (m MockPermissionModel) RemoveForUser(ctx context.Context, userID int64, codes ...string) error {
+	return nil
+}
+
+func (m MockPermissionModel) GetAll(ctx context.Context) (Permissions, error) {
 	return Permissions{"movies:read", "movies:write"}, nil
 }
@@ -0,0 +1,255 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/nytro04/greenlight/internal/clock"
+)
+
+var (
+	// ErrQuotaExceeded is returned by Consume when the API key has used up its requests for the day or the month
+	ErrQuotaExceeded = errors.New("quota exceeded")
+
+	// ErrUnknownQuotaTier is returned when an API key is given a tier which doesn't exist
+	ErrUnknownQuotaTier = errors.New("unknown quota tier")
+)
+
+// DefaultQuotaTier is the tier of the API keys minted without one
+const DefaultQuotaTier = "free"
+
+// QuotaTier is a tier of API keys and the number of requests they can make each UTC day and month
+type QuotaTier struct {
+	Name         string `json:"name"`
+	DailyLimit   *int64 `json:"daily_limit"`   // nil when there is no daily limit
+	MonthlyLimit *int64 `json:"monthly_limit"` // nil when there is no monthly limit
+}
+
+// QuotaUsage is the number of requests an API key has made in the current day or month, against the limit of its tier
+type QuotaUsage struct {
+	Used     int64     `json:"used"`
+	Limit    *int64    `json:"limit"` // nil when the tier has no limit for the period
+	ResetsAt time.Time `json:"resets_at"`
+}
+
+// Exceeded reports whether the limit has been reached, so no more requests are allowed in the period
+func (u QuotaUsage) Exceeded() bool {
+	return u.Limit != nil && u.Used >= *u.Limit
+}
+
+// APIKeyUsage is the consumption of an API key's quota. The days and months are UTC ones
+type APIKeyUsage struct {
+	APIKeyID int64      `json:"api_key_id"`
+	Name     string     `json:"name"`
+	Prefix   string     `json:"prefix"`
+	Tier     string     `json:"tier"`
+	Daily    QuotaUsage `json:"daily"`
+	Monthly  QuotaUsage `json:"monthly"`
+}
+
+// QuotaModel counts the requests made with each API key, a row per key and day, and checks them against the daily
+// and monthly limits of the key's tier
+type QuotaModel struct {
+	DB    DBTX
+	Clock clock.Clock
+}
+
+// GetTiers returns every quota tier, the ones with the lowest daily limit first and the unlimited ones last
+func (m QuotaModel) GetTiers(ctx context.Context) ([]*QuotaTier, error) {
+	query := `
+		SELECT name, daily_limit, monthly_limit
+		FROM api_quota_tiers
+		ORDER BY daily_limit ASC NULLS LAST, monthly_limit ASC NULLS LAST, name ASC`
+
+	ctx, cancel := withReadTimeout(ctx)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	tiers := []*QuotaTier{}
+
+	for rows.Next() {
+		var tier QuotaTier
+
+		err := rows.Scan(&tier.Name, &tier.DailyLimit, &tier.MonthlyLimit)
+		if err != nil {
+			return nil, err
+		}
+
+		tiers = append(tiers, &tier)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return tiers, nil
+}
+
+// GetForUser returns the usage of the user's API keys, newest key first. When keyID is not zero only the usage of that
+// key is returned
+func (m QuotaModel) GetForUser(ctx context.Context, userID, keyID int64) ([]*APIKeyUsage, error) {
+	day, month := quotaPeriods(m.Clock.Now())
+
+	query := `
+		SELECT api_keys.id, api_keys.name, api_keys.prefix, api_keys.tier, api_quota_tiers.daily_limit, api_quota_tiers.monthly_limit,
+		COALESCE(SUM(api_key_usage.requests) FILTER (WHERE api_key_usage.day = $3), 0),
+		COALESCE(SUM(api_key_usage.requests), 0)
+		FROM api_keys
+		INNER JOIN api_quota_tiers ON api_quota_tiers.name = api_keys.tier
+		LEFT JOIN api_key_usage ON api_key_usage.api_key_id = api_keys.id AND api_key_usage.day >= $4
+		WHERE api_keys.user_id = $1 AND (api_keys.id = $2 OR $2 = 0)
+		GROUP BY api_keys.id, api_quota_tiers.name
+		ORDER BY api_keys.id DESC`
+
+	ctx, cancel := withReadTimeout(ctx)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, userID, keyID, day, month)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	usages := []*APIKeyUsage{}
+
+	for rows.Next() {
+		usage := APIKeyUsage{
+			Daily:   QuotaUsage{ResetsAt: day.AddDate(0, 0, 1)},
+			Monthly: QuotaUsage{ResetsAt: month.AddDate(0, 1, 0)},
+		}
+
+		err := rows.Scan(
+			&usage.APIKeyID,
+			&usage.Name,
+			&usage.Prefix,
+			&usage.Tier,
+			&usage.Daily.Limit,
+			&usage.Monthly.Limit,
+			&usage.Daily.Used,
+			&usage.Monthly.Used,
+		)
+		if err != nil {
+			return nil, err
+		}
+
+		usages = append(usages, &usage)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return usages, nil
+}
+
+// Consume counts a request made with the API key, unless the key has used up its requests for the day or the month,
+// in which case the request isn't counted and ErrQuotaExceeded is returned along with the usage. The check and the
+// count are a single statement: the limits are checked against the key's row for the day once it is locked, so a
+// burst of concurrent requests can't go over them. Only the refused requests read the usage again, for the response
+func (m QuotaModel) Consume(ctx context.Context, key *APIKey) (*APIKeyUsage, error) {
+	day, month := quotaPeriods(m.Clock.Now())
+
+	// earlier is the requests made in the month before today. Only today's row is written to, so it is the same when
+	// the row is locked, and today's requests are read from the locked row in the conflict's WHERE
+	query := `
+		WITH quota AS (
+			SELECT api_keys.id, api_quota_tiers.daily_limit, api_quota_tiers.monthly_limit,
+			COALESCE((
+				SELECT SUM(api_key_usage.requests)
+				FROM api_key_usage
+				WHERE api_key_usage.api_key_id = api_keys.id AND api_key_usage.day >= $3 AND api_key_usage.day < $2
+			), 0) AS earlier
+			FROM api_keys
+			INNER JOIN api_quota_tiers ON api_quota_tiers.name = api_keys.tier
+			WHERE api_keys.id = $1
+		)
+		INSERT INTO api_key_usage (api_key_id, day, requests)
+		SELECT id, $2, 1
+		FROM quota
+		WHERE (daily_limit IS NULL OR daily_limit > 0) AND (monthly_limit IS NULL OR earlier < monthly_limit)
+		ON CONFLICT (api_key_id, day) DO UPDATE
+		SET requests = api_key_usage.requests + 1
+		WHERE ((SELECT daily_limit FROM quota) IS NULL OR api_key_usage.requests < (SELECT daily_limit FROM quota))
+		AND ((SELECT monthly_limit FROM quota) IS NULL
+			OR (SELECT earlier FROM quota) + api_key_usage.requests < (SELECT monthly_limit FROM quota))
+		RETURNING api_key_usage.requests, (SELECT earlier FROM quota),
+			(SELECT daily_limit FROM quota), (SELECT monthly_limit FROM quota)`
+
+	usage := &APIKeyUsage{
+		APIKeyID: key.ID,
+		Name:     key.Name,
+		Prefix:   key.Prefix,
+		Tier:     key.Tier,
+		Daily:    QuotaUsage{ResetsAt: day.AddDate(0, 0, 1)},
+		Monthly:  QuotaUsage{ResetsAt: month.AddDate(0, 1, 0)},
+	}
+
+	var earlier int64
+
+	writeCtx, cancel := withWriteTimeout(ctx)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(writeCtx, query, key.ID, day, month).Scan(&usage.Daily.Used, &earlier, &usage.Daily.Limit, &usage.Monthly.Limit)
+	if err != nil {
+		switch {
+		// nothing was counted, either because the quota is used up or because the key doesn't exist any more
+		case errors.Is(err, sql.ErrNoRows):
+			return m.refused(ctx, key)
+		// the key was revoked since it was looked up
+		case isForeignKeyViolation(err, "api_key_usage_api_key_id_fkey"):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	usage.Monthly.Used = earlier + usage.Daily.Used
+
+	return usage, nil
+}
+
+// refused returns the usage of the API key whose request Consume didn't count, with ErrQuotaExceeded, or
+// ErrRecordNotFound if the key has been revoked
+func (m QuotaModel) refused(ctx context.Context, key *APIKey) (*APIKeyUsage, error) {
+	usages, err := m.GetForUser(ctx, key.UserID, key.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(usages) == 0 {
+		return nil, ErrRecordNotFound
+	}
+
+	return usages[0], ErrQuotaExceeded
+}
+
+// quotaPeriods returns the start of the UTC day and month the time is in
+func quotaPeriods(now time.Time) (day, month time.Time) {
+	now = now.UTC()
+
+	day = time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.UTC)
+	month = time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, time.UTC)
+
+	return day, month
+}
+
+type MockQuotaModel struct{}
+
+func (m MockQuotaModel) GetTiers(ctx context.Context) ([]*QuotaTier, error) {
+	return []*QuotaTier{}, nil
+}
+
+func (m MockQuotaModel) GetForUser(ctx context.Context, userID, keyID int64) ([]*APIKeyUsage, error) {
+	return []*APIKeyUsage{}, nil
+}
+
+func (m MockQuotaModel) Consume(ctx context.Context, key *APIKey) (*APIKeyUsage, error) {
+	return &APIKeyUsage{APIKeyID: key.ID, Name: key.Name, Prefix: key.Prefix, Tier: key.Tier}, nil
+}
@@ -0,0 +1,81 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"net"
+	"sync/atomic"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/nytro04/greenlight/internal/clock"
+)
+
+// replicaRetryAfter is how long the replica is skipped after it couldn't be reached, so every read doesn't wait on a
+// replica which is down before falling back to the primary
+const replicaRetryAfter = 30 * time.Second
+
+// replicaDB runs the read-only queries of the catalog models on the read replica, falling back to the primary when
+// the replica can't be reached. Only connection failures fall back, an error from the query itself is returned as it
+// is. The replica may lag behind the primary, so a record read from it just after a write may be out of date, which
+// the version checks of the updates turn into an edit conflict rather than a lost update
+type replicaDB struct {
+	primary   *sql.DB
+	replica   *sql.DB
+	clock     clock.Clock
+	downUntil atomic.Int64 // the Unix time in nanoseconds until which the replica is skipped
+}
+
+func (r *replicaDB) ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error) {
+	// writes always go to the primary
+	return r.primary.ExecContext(ctx, query, args...)
+}
+
+func (r *replicaDB) QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error) {
+	if r.available() {
+		rows, err := r.replica.QueryContext(ctx, query, args...)
+		if !r.unreachable(err) {
+			return rows, err
+		}
+	}
+
+	return r.primary.QueryContext(ctx, query, args...)
+}
+
+func (r *replicaDB) QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row {
+	if r.available() {
+		row := r.replica.QueryRowContext(ctx, query, args...)
+		if !r.unreachable(row.Err()) {
+			return row
+		}
+	}
+
+	return r.primary.QueryRowContext(ctx, query, args...)
+}
+
+// available reports whether the replica should be tried, which it isn't for a while after it couldn't be reached
+func (r *replicaDB) available() bool {
+	return r.clock.Now().UnixNano() >= r.downUntil.Load()
+}
+
+// unreachable reports whether err means the replica couldn't be reached, in which case it is skipped for a while
+func (r *replicaDB) unreachable(err error) bool {
+	if err == nil || !isConnectionError(err) {
+		return false
+	}
+
+	r.downUntil.Store(r.clock.Now().Add(replicaRetryAfter).UnixNano())
+
+	return true
+}
+
+// isConnectionError reports whether err is a failure to connect to the server or a broken connection, rather than an
+// error the server returned for the query
+func isConnectionError(err error) bool {
+	var connectErr *pgconn.ConnectError
+	var netErr net.Error
+
+	return errors.Is(err, driver.ErrBadConn) || errors.As(err, &connectErr) || errors.As(err, &netErr)
+}
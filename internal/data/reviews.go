@@ -0,0 +1,358 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/nytro04/greenlight/internal/validator"
+)
+
+// ErrDuplicateReview is returned when a user tries to review a movie they have already reviewed
+var ErrDuplicateReview = errors.New("duplicate review")
+
+// Review is a star rating from 1 to 5 given to a movie by a user, with an optional text review. The reviews of a user
+// who has deleted their account are kept with a UserID of zero, which is left out of the JSON. A review hidden by a
+// moderator is only ever returned to the moderators, so Hidden is left out of the JSON of the others
+type Review struct {
+	ID        int64     `json:"id"`
+	CreatedAt time.Time `json:"created_at"`
+	MovieID   int64     `json:"movie_id"`
+	UserID    int64     `json:"user_id,omitempty"`
+	Rating    int8      `json:"rating"`
+	Body      string    `json:"body"`
+	Version   int32     `json:"version"`
+	Hidden    bool      `json:"hidden,omitempty"`
+}
+
+func ValidateReview(v *validator.Validator, review *Review) {
+	v.Check(review.Rating >= 1 && review.Rating <= 5, "rating", "must be between 1 and 5")
+	v.Check(len(review.Body) <= 10_000, "body", "must not be more than 10000 bytes long")
+}
+
+type ReviewModel struct {
+	DB     DBTX
+	Reader DBTX // runs the read-only queries, on the read replica if one is configured
+}
+
+// Insert adds a review to a movie. ErrDuplicateReview is returned if the user has already reviewed the movie, and
+// ErrRecordNotFound if the movie doesn't exist. Every write to the reviews updates the review aggregates of the movie
+// in the same transaction, and moves its updated_at time on
+func (m ReviewModel) Insert(review *Review) error {
+	query := `
+		INSERT INTO reviews (movie_id, user_id, rating, body)
+		VALUES ($1, $2, $3, $4)
+		RETURNING id, created_at, version`
+
+	args := []interface{}{review.MovieID, review.UserID, review.Rating, review.Body}
+
+	ctx, cancel := withWriteTimeout(context.Background())
+	defer cancel()
+
+	tx, err := beginTx(ctx, m.DB)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	err = tx.QueryRowContext(ctx, query, args...).Scan(&review.ID, &review.CreatedAt, &review.Version)
+	if err != nil {
+		switch {
+		case isUniqueViolation(err, "reviews_movie_id_user_id_key"):
+			return ErrDuplicateReview
+		case isForeignKeyViolation(err, "reviews_movie_id_fkey"):
+			return ErrRecordNotFound
+		default:
+			return err
+		}
+	}
+
+	err = updateMovieRating(ctx, tx, review.MovieID)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// Get returns the review with the given ID on the given movie. Like the listings, it doesn't return hidden reviews
+func (m ReviewModel) Get(movieID, id int64) (*Review, error) {
+	if id < 1 {
+		return nil, ErrRecordNotFound
+	}
+
+	query := `
+		SELECT id, created_at, movie_id, COALESCE(user_id, 0), rating, body, version
+		FROM reviews
+		WHERE id = $1 AND movie_id = $2 AND hidden_at IS NULL`
+
+	var review Review
+
+	ctx, cancel := withReadTimeout(context.Background())
+	defer cancel()
+
+	err := m.Reader.QueryRowContext(ctx, query, id, movieID).Scan(
+		&review.ID,
+		&review.CreatedAt,
+		&review.MovieID,
+		&review.UserID,
+		&review.Rating,
+		&review.Body,
+		&review.Version,
+	)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	return &review, nil
+}
+
+// GetAllForMovie returns a page of the reviews of a movie
+func (m ReviewModel) GetAllForMovie(movieID int64, filters Filters) ([]*Review, Metadata, error) {
+	sortColumn, err := filters.sortColumn()
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+
+	query := fmt.Sprintf(`
+		SELECT count(*) OVER(), id, created_at, movie_id, COALESCE(user_id, 0), rating, body, version
+		FROM reviews
+		WHERE movie_id = $1 AND hidden_at IS NULL
+		ORDER BY %s %s, id DESC
+		LIMIT $2 OFFSET $3`, sortColumn, filters.sortDirection())
+
+	ctx, cancel := withReadTimeout(context.Background())
+	defer cancel()
+
+	rows, err := m.Reader.QueryContext(ctx, query, movieID, filters.limit(), filters.offset())
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+	defer rows.Close()
+
+	totalRecords := 0
+	reviews := []*Review{}
+
+	for rows.Next() {
+		var review Review
+
+		err := rows.Scan(
+			&totalRecords,
+			&review.ID,
+			&review.CreatedAt,
+			&review.MovieID,
+			&review.UserID,
+			&review.Rating,
+			&review.Body,
+			&review.Version,
+		)
+		if err != nil {
+			return nil, Metadata{}, err
+		}
+
+		reviews = append(reviews, &review)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, Metadata{}, err
+	}
+
+	metadata := calculateMetadata(totalRecords, filters.Page, filters.PageSize)
+
+	return reviews, metadata, nil
+}
+
+// GetAllForMovies returns a page of the reviews of each of the movies with a single query, along with the pagination
+// metadata of each movie's reviews. The movies without any reviews are left out of both maps
+func (m ReviewModel) GetAllForMovies(movieIDs []int64, filters Filters) (map[int64][]*Review, map[int64]Metadata, error) {
+	sortColumn, err := filters.sortColumn()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// the reviews are numbered within each movie, so the same page of every movie can be picked out at once
+	query := fmt.Sprintf(`
+		SELECT total, id, created_at, movie_id, user_id, rating, body, version
+		FROM (
+			SELECT count(*) OVER (PARTITION BY movie_id) AS total,
+			row_number() OVER (PARTITION BY movie_id ORDER BY %s %s, id DESC) AS row,
+			id, created_at, movie_id, COALESCE(user_id, 0) AS user_id, rating, body, version
+			FROM reviews
+			WHERE movie_id = ANY($1) AND hidden_at IS NULL
+		) AS numbered
+		WHERE row > $2 AND row <= $2 + $3
+		ORDER BY movie_id, row`, sortColumn, filters.sortDirection())
+
+	ctx, cancel := withReadTimeout(context.Background())
+	defer cancel()
+
+	rows, err := m.Reader.QueryContext(ctx, query, movieIDs, filters.offset(), filters.limit())
+	if err != nil {
+		return nil, nil, err
+	}
+	defer rows.Close()
+
+	reviews := make(map[int64][]*Review)
+	metadata := make(map[int64]Metadata)
+
+	for rows.Next() {
+		var review Review
+		var totalRecords int
+
+		err := rows.Scan(
+			&totalRecords,
+			&review.ID,
+			&review.CreatedAt,
+			&review.MovieID,
+			&review.UserID,
+			&review.Rating,
+			&review.Body,
+			&review.Version,
+		)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		reviews[review.MovieID] = append(reviews[review.MovieID], &review)
+		metadata[review.MovieID] = calculateMetadata(totalRecords, filters.Page, filters.PageSize)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, nil, err
+	}
+
+	return reviews, metadata, nil
+}
+
+// Update saves the rating and body of a review, using the version number to detect concurrent edits
+func (m ReviewModel) Update(review *Review) error {
+	query := `
+		UPDATE reviews
+		SET rating = $1, body = $2, version = version + 1
+		WHERE id = $3 AND version = $4
+		RETURNING version, movie_id`
+
+	args := []interface{}{review.Rating, review.Body, review.ID, review.Version}
+
+	ctx, cancel := withWriteTimeout(context.Background())
+	defer cancel()
+
+	tx, err := beginTx(ctx, m.DB)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	err = tx.QueryRowContext(ctx, query, args...).Scan(&review.Version, &review.MovieID)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrEditConflict
+		default:
+			return err
+		}
+	}
+
+	err = updateMovieRating(ctx, tx, review.MovieID)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// Delete removes a review
+func (m ReviewModel) Delete(id int64) error {
+	if id < 1 {
+		return ErrRecordNotFound
+	}
+
+	query := `
+		DELETE FROM reviews
+		WHERE id = $1
+		RETURNING movie_id`
+
+	ctx, cancel := withWriteTimeout(context.Background())
+	defer cancel()
+
+	tx, err := beginTx(ctx, m.DB)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var movieID int64
+
+	err = tx.QueryRowContext(ctx, query, id).Scan(&movieID)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrRecordNotFound
+		default:
+			return err
+		}
+	}
+
+	err = updateMovieRating(ctx, tx, movieID)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// updateMovieRating recomputes the average rating and review count of the movie from its reviews, leaving out the
+// hidden ones, and moves its updated_at time on. The movie is locked by a statement of its own first, so the aggregates are computed from a
+// snapshot taken once any other transaction writing a review of the movie has committed, and two reviews written at
+// the same time can't leave one of them out. The lock doesn't block the inserts of reviews, which only take a key
+// share lock on the movie
+func updateMovieRating(ctx context.Context, tx DBTX, movieID int64) error {
+	_, err := tx.ExecContext(ctx, `SELECT id FROM movies WHERE id = $1 FOR NO KEY UPDATE`, movieID)
+	if err != nil {
+		return err
+	}
+
+	query := `
+		UPDATE movies
+		SET average_rating = COALESCE((SELECT avg(rating) FROM reviews WHERE movie_id = $1 AND hidden_at IS NULL), 0),
+		review_count = (SELECT count(*) FROM reviews WHERE movie_id = $1 AND hidden_at IS NULL),
+		updated_at = NOW()
+		WHERE id = $1`
+
+	_, err = tx.ExecContext(ctx, query, movieID)
+	return err
+}
+
+// Mock data for testing
+type MockReviewModel struct{}
+
+func (m MockReviewModel) Insert(review *Review) error {
+	return nil
+}
+
+func (m MockReviewModel) Get(movieID, id int64) (*Review, error) {
+	return nil, nil
+}
+
+func (m MockReviewModel) GetAllForMovie(movieID int64, filters Filters) ([]*Review, Metadata, error) {
+	return nil, Metadata{}, nil
+}
+
+func (m MockReviewModel) GetAllForMovies(movieIDs []int64, filters Filters) (map[int64][]*Review, map[int64]Metadata, error) {
+	return nil, nil, nil
+}
+
+func (m MockReviewModel) Update(review *Review) error {
+	return nil
+}
+
+func (m MockReviewModel) Delete(id int64) error {
+	return nil
+}
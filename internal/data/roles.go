@@ -0,0 +1,219 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/nytro04/greenlight/internal/validator"
+)
+
+// ErrDuplicateRole is returned when a role is created with a name which is already taken
+var ErrDuplicateRole = errors.New("duplicate role")
+
+// Role is a named bundle of permissions. Granting a role to a user grants them each of its permissions, the user
+// keeps those permissions if the role is changed or deleted later
+type Role struct {
+	ID          int64       `json:"id"`
+	CreatedAt   time.Time   `json:"created_at"`
+	Name        string      `json:"name"`
+	Description string      `json:"description"`
+	Permissions Permissions `json:"permissions"`
+}
+
+func ValidateRole(v *validator.Validator, role *Role) {
+	v.Check(role.Name != "", "name", "must be provided")
+	v.Check(len(role.Name) <= 100, "name", "must not be more than 100 bytes long")
+	v.Check(len(role.Description) <= 1000, "description", "must not be more than 1000 bytes long")
+
+	v.Check(len(role.Permissions) > 0, "permissions", "must contain at least 1 permission")
+	v.Check(validator.Unique(role.Permissions), "permissions", "must not contain duplicate values")
+}
+
+type RoleModel struct {
+	DB DBTX
+}
+
+// Insert creates the role together with its permissions in a transaction. The permission codes must exist, unknown
+// codes are ignored, so they should be checked against PermissionModel.GetAll first
+func (m RoleModel) Insert(role *Role) error {
+	ctx, cancel := withWriteTimeout(context.Background())
+	defer cancel()
+
+	tx, err := beginTx(ctx, m.DB)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	query := `
+		INSERT INTO roles (name, description)
+		VALUES ($1, $2)
+		RETURNING id, created_at`
+
+	err = tx.QueryRowContext(ctx, query, role.Name, role.Description).Scan(&role.ID, &role.CreatedAt)
+	if err != nil {
+		switch {
+		case isUniqueViolation(err, "roles_name_key"):
+			return ErrDuplicateRole
+		default:
+			return err
+		}
+	}
+
+	query = `
+		INSERT INTO roles_permissions
+		SELECT $1, permissions.id FROM permissions WHERE permissions.code = ANY($2)`
+
+	_, err = tx.ExecContext(ctx, query, role.ID, role.Permissions)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// GetAll returns every role with its permissions, ordered by name
+func (m RoleModel) GetAll() ([]*Role, error) {
+	query := `
+		SELECT roles.id, roles.created_at, roles.name, roles.description,
+		COALESCE(array_agg(permissions.code ORDER BY permissions.code) FILTER (WHERE permissions.code IS NOT NULL), '{}')
+		FROM roles
+		LEFT JOIN roles_permissions ON roles_permissions.role_id = roles.id
+		LEFT JOIN permissions ON permissions.id = roles_permissions.permission_id
+		GROUP BY roles.id
+		ORDER BY roles.name`
+
+	ctx, cancel := withReadTimeout(context.Background())
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	roles := []*Role{}
+
+	for rows.Next() {
+		var role Role
+
+		err := rows.Scan(
+			&role.ID,
+			&role.CreatedAt,
+			&role.Name,
+			&role.Description,
+			scanArray((*[]string)(&role.Permissions)),
+		)
+		if err != nil {
+			return nil, err
+		}
+
+		roles = append(roles, &role)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return roles, nil
+}
+
+// GetPermissions returns the permissions bundled by the roles with the given names. ErrRecordNotFound is returned if
+// any of the roles doesn't exist
+func (m RoleModel) GetPermissions(names ...string) (Permissions, error) {
+	query := `
+		SELECT roles.name, permissions.code
+		FROM roles
+		LEFT JOIN roles_permissions ON roles_permissions.role_id = roles.id
+		LEFT JOIN permissions ON permissions.id = roles_permissions.permission_id
+		WHERE roles.name = ANY($1)`
+
+	ctx, cancel := withReadTimeout(context.Background())
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, names)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	found := map[string]bool{}
+	permissions := Permissions{}
+
+	for rows.Next() {
+		var name string
+		var code sql.NullString
+
+		err := rows.Scan(&name, &code)
+		if err != nil {
+			return nil, err
+		}
+
+		found[name] = true
+		if code.Valid && !permissions.Include(code.String) {
+			permissions = append(permissions, code.String)
+		}
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	for _, name := range names {
+		if !found[name] {
+			return nil, ErrRecordNotFound
+		}
+	}
+
+	return permissions, nil
+}
+
+func (m RoleModel) Delete(id int64) error {
+	if id < 1 {
+		return ErrRecordNotFound
+	}
+
+	query := `
+		DELETE FROM roles
+		WHERE id = $1`
+
+	ctx, cancel := withWriteTimeout(context.Background())
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, query, id)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+
+	return nil
+}
+
+// Mock data for testing
+type MockRoleModel struct{}
+
+func (m MockRoleModel) Insert(role *Role) error {
+	return nil
+}
+
+func (m MockRoleModel) GetAll() ([]*Role, error) {
+	return nil, nil
+}
+
+func (m MockRoleModel) GetPermissions(names ...string) (Permissions, error) {
+	return nil, nil
+}
+
+func (m MockRoleModel) Delete(id int64) error {
+	return nil
+}
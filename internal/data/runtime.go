@@ -26,6 +26,23 @@ func (r *Runtime) UnmarshalJSON(jsonValue []byte) error {
 		return ErrInvalidRuntimeFormat
 	}
 
+	// the ISO 8601 duration used by v2 of the API, such as "PT107M"
+	if minutes, ok := strings.CutPrefix(unquotedJSONValue, "PT"); ok {
+		minutes, ok = strings.CutSuffix(minutes, "M")
+		if !ok {
+			return ErrInvalidRuntimeFormat
+		}
+
+		i, err := strconv.ParseInt(minutes, 10, 32)
+		if err != nil {
+			return ErrInvalidRuntimeFormat
+		}
+
+		*r = Runtime(i)
+
+		return nil
+	}
+
 	parts := strings.Split(unquotedJSONValue, " ")
 
 	if len(parts) != 2 || parts[1] != "mins" {
@@ -0,0 +1,49 @@
+package data
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+// FuzzRuntimeUnmarshalJSON checks that decoding a runtime never panics, and that any value which decodes successfully
+// survives a round trip through MarshalJSON unchanged
+func FuzzRuntimeUnmarshalJSON(f *testing.F) {
+	f.Add(`"102 mins"`)
+	f.Add(`"PT102M"`)
+	f.Add(`"0 mins"`)
+	f.Add(`"-5 mins"`)
+	f.Add(`"2147483648 mins"`)
+	f.Add(`"102  mins"`)
+	f.Add(`"102 mins`)
+	f.Add(`102`)
+	f.Add(`"1 mins"`)
+	f.Add(``)
+
+	f.Fuzz(func(t *testing.T, input string) {
+		var r Runtime
+
+		err := r.UnmarshalJSON([]byte(input))
+		if err != nil {
+			if err != ErrInvalidRuntimeFormat {
+				t.Fatalf("unexpected error for %q: %v", input, err)
+			}
+			return
+		}
+
+		js, err := json.Marshal(r)
+		if err != nil {
+			t.Fatalf("marshalling %d: %v", r, err)
+		}
+
+		var decoded Runtime
+
+		err = json.Unmarshal(js, &decoded)
+		if err != nil {
+			t.Fatalf("decoding %s: %v", js, err)
+		}
+
+		if decoded != r {
+			t.Fatalf("round trip of %q gave %d, want %d", input, decoded, r)
+		}
+	})
+}
@@ -0,0 +1,152 @@
+package data
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/fs"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Schema check statuses
+const (
+	SchemaStatusOK       = "ok"
+	SchemaStatusMismatch = "mismatch"
+)
+
+// SchemaCheck is the result of comparing the migrations embedded in the binary with the migrations applied to the database
+type SchemaCheck struct {
+	Status          string   `json:"status"`
+	DatabaseVersion int64    `json:"database_version"`
+	EmbeddedVersion int64    `json:"embedded_version"`
+	Dirty           bool     `json:"dirty"`
+	Problems        []string `json:"problems,omitempty"`
+}
+
+// MigrationChecksums returns the SHA-256 hash of every up migration in the directory of the file system, keyed by the
+// migration version. The file names follow the golang-migrate convention of <version>_<name>.up.sql
+func MigrationChecksums(fsys fs.FS, dir string) (map[int64]string, error) {
+	entries, err := fs.ReadDir(fsys, dir)
+	if err != nil {
+		return nil, err
+	}
+
+	checksums := make(map[int64]string)
+
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.IsDir() || !strings.HasSuffix(name, ".up.sql") {
+			continue
+		}
+
+		prefix, _, found := strings.Cut(name, "_")
+		if !found {
+			return nil, fmt.Errorf("migration %s has no version prefix", name)
+		}
+
+		version, err := strconv.ParseInt(prefix, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("migration %s has an invalid version prefix", name)
+		}
+
+		content, err := fs.ReadFile(fsys, path.Join(dir, name))
+		if err != nil {
+			return nil, err
+		}
+
+		hash := sha256.Sum256(content)
+		checksums[version] = hex.EncodeToString(hash[:])
+	}
+
+	return checksums, nil
+}
+
+// CheckSchema compares the embedded migration checksums with the schema in the database. It reports a mismatch when the
+// database has been migrated past the newest embedded migration (an old binary running against a new schema), when it is
+// behind the embedded migrations, when the last migration failed part way through, or when a migration has been changed
+// since it was applied. The checksums of the applied migrations are recorded the first time they are seen, so they can
+// be compared on every later startup
+func CheckSchema(db *sql.DB, checksums map[int64]string) (*SchemaCheck, error) {
+	check := &SchemaCheck{Status: SchemaStatusOK}
+
+	versions := make([]int64, 0, len(checksums))
+	for version := range checksums {
+		versions = append(versions, version)
+	}
+	sort.Slice(versions, func(i, j int) bool { return versions[i] < versions[j] })
+
+	if len(versions) > 0 {
+		check.EmbeddedVersion = versions[len(versions)-1]
+	}
+
+	ctx, cancel := withReadTimeout(context.Background())
+	defer cancel()
+
+	// schema_migrations is maintained by golang-migrate and holds a single row with the current version
+	err := db.QueryRowContext(ctx, `SELECT version, dirty FROM schema_migrations LIMIT 1`).Scan(&check.DatabaseVersion, &check.Dirty)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return nil, err
+	}
+
+	if check.Dirty {
+		check.Problems = append(check.Problems, fmt.Sprintf("migration %d failed part way through and the database is dirty", check.DatabaseVersion))
+	}
+
+	switch {
+	case check.DatabaseVersion > check.EmbeddedVersion:
+		check.Problems = append(check.Problems, fmt.Sprintf("database schema version %d is newer than the latest embedded migration %d", check.DatabaseVersion, check.EmbeddedVersion))
+	case check.DatabaseVersion < check.EmbeddedVersion:
+		check.Problems = append(check.Problems, fmt.Sprintf("database schema version %d is behind the latest embedded migration %d", check.DatabaseVersion, check.EmbeddedVersion))
+	}
+
+	// the checksums table is created by a migration itself, so there is nothing more to compare until it has been applied
+	var exists bool
+
+	err = db.QueryRowContext(ctx, `SELECT to_regclass('schema_checksums') IS NOT NULL`).Scan(&exists)
+	if err != nil {
+		return nil, err
+	}
+
+	if exists {
+		for _, version := range versions {
+			if version > check.DatabaseVersion {
+				break
+			}
+
+			query := `
+				WITH recorded AS (
+					INSERT INTO schema_checksums (version, checksum)
+					VALUES ($1, $2)
+					ON CONFLICT (version) DO NOTHING
+					RETURNING checksum
+				)
+				SELECT checksum FROM recorded
+				UNION ALL
+				SELECT checksum FROM schema_checksums WHERE version = $1
+				LIMIT 1`
+
+			var recorded string
+
+			err = db.QueryRowContext(ctx, query, version, checksums[version]).Scan(&recorded)
+			if err != nil {
+				return nil, err
+			}
+
+			if recorded != checksums[version] {
+				check.Problems = append(check.Problems, fmt.Sprintf("migration %d has been changed since it was applied to the database", version))
+			}
+		}
+	}
+
+	if len(check.Problems) > 0 {
+		check.Status = SchemaStatusMismatch
+	}
+
+	return check, nil
+}
@@ -0,0 +1,430 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/nytro04/greenlight/internal/validator"
+)
+
+// MaxSeriesMovies is the largest number of movies a series can hold
+const MaxSeriesMovies = 100
+
+// ErrMovieInOtherSeries is returned when the movies of a series are set to include a movie which is already part of
+// another series, and ErrSeriesMovieNotFound when they include a movie which doesn't exist
+var (
+	ErrMovieInOtherSeries  = errors.New("movie in other series")
+	ErrSeriesMovieNotFound = errors.New("series movie not found")
+)
+
+// Series is a franchise or series of movies, such as "The Lord of the Rings", whose movies are kept in order. A movie
+// is part of one series at most
+type Series struct {
+	ID          int64     `json:"id"`
+	CreatedAt   time.Time `json:"created_at"`
+	Name        string    `json:"name"`
+	Description string    `json:"description"`
+	MovieCount  int       `json:"movie_count"`
+	Version     int32     `json:"version"`
+}
+
+// SeriesEntry is the place of a movie in its series, as embedded in the movie with include=series, which is enough for
+// a client to show "part 2 of 3" with links to the movies before and after it. The previous and next movie IDs are
+// left out at either end of the series
+type SeriesEntry struct {
+	ID              int64  `json:"id"`
+	Name            string `json:"name"`
+	Position        int    `json:"position"`
+	Total           int    `json:"total"`
+	PreviousMovieID int64  `json:"previous_movie_id,omitempty"`
+	NextMovieID     int64  `json:"next_movie_id,omitempty"`
+}
+
+func ValidateSeries(v *validator.Validator, series *Series) {
+	v.Check(series.Name != "", "name", "must be provided")
+	v.Check(len(series.Name) <= 500, "name", "must not be more than 500 bytes long")
+	v.Check(len(series.Description) <= 2000, "description", "must not be more than 2000 bytes long")
+}
+
+type SeriesModel struct {
+	DB     DBTX
+	Reader DBTX // runs the read-only queries, on the read replica if one is configured
+}
+
+// Insert creates a series without any movies
+func (m SeriesModel) Insert(ctx context.Context, series *Series) error {
+	query := `
+		INSERT INTO series (name, description)
+		VALUES ($1, $2)
+		RETURNING id, created_at, version`
+
+	ctx, cancel := withWriteTimeout(ctx)
+	defer cancel()
+
+	return m.DB.QueryRowContext(ctx, query, series.Name, series.Description).Scan(&series.ID, &series.CreatedAt, &series.Version)
+}
+
+// Get returns the series with the given ID, or ErrRecordNotFound
+func (m SeriesModel) Get(ctx context.Context, id int64) (*Series, error) {
+	if id < 1 {
+		return nil, ErrRecordNotFound
+	}
+
+	query := `
+		SELECT id, created_at, name, description, (SELECT count(*) FROM series_movies WHERE series_id = series.id), version
+		FROM series
+		WHERE id = $1`
+
+	var series Series
+
+	ctx, cancel := withReadTimeout(ctx)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, id).Scan(
+		&series.ID,
+		&series.CreatedAt,
+		&series.Name,
+		&series.Description,
+		&series.MovieCount,
+		&series.Version,
+	)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	return &series, nil
+}
+
+// GetAll returns a page of the series, filtered by name if one is given
+func (m SeriesModel) GetAll(ctx context.Context, name string, filters Filters) ([]*Series, Metadata, error) {
+	sortColumn, err := filters.sortColumn()
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+
+	query := fmt.Sprintf(`
+		SELECT count(*) OVER(), id, created_at, name, description,
+		(SELECT count(*) FROM series_movies WHERE series_id = series.id), version
+		FROM series
+		WHERE (to_tsvector('simple', name) @@ plainto_tsquery('simple', $1) OR $1 = '')
+		ORDER BY %s %s, id ASC
+		LIMIT $2 OFFSET $3`, sortColumn, filters.sortDirection())
+
+	ctx, cancel := withReadTimeout(ctx)
+	defer cancel()
+
+	rows, err := m.Reader.QueryContext(ctx, query, name, filters.limit(), filters.offset())
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+	defer rows.Close()
+
+	totalRecords := 0
+	allSeries := []*Series{}
+
+	for rows.Next() {
+		var series Series
+
+		err := rows.Scan(
+			&totalRecords,
+			&series.ID,
+			&series.CreatedAt,
+			&series.Name,
+			&series.Description,
+			&series.MovieCount,
+			&series.Version,
+		)
+		if err != nil {
+			return nil, Metadata{}, err
+		}
+
+		allSeries = append(allSeries, &series)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, Metadata{}, err
+	}
+
+	metadata := calculateMetadata(totalRecords, filters.Page, filters.PageSize)
+
+	return allSeries, metadata, nil
+}
+
+// Update saves the name and description of a series, using the version number to detect concurrent edits
+func (m SeriesModel) Update(ctx context.Context, series *Series) error {
+	query := `
+		UPDATE series
+		SET name = $1, description = $2, version = version + 1
+		WHERE id = $3 AND version = $4
+		RETURNING version`
+
+	args := []interface{}{series.Name, series.Description, series.ID, series.Version}
+
+	ctx, cancel := withWriteTimeout(ctx)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, args...).Scan(&series.Version)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrEditConflict
+		default:
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Delete removes a series. Its movies are kept, they just stop being part of it
+func (m SeriesModel) Delete(ctx context.Context, id int64) error {
+	if id < 1 {
+		return ErrRecordNotFound
+	}
+
+	query := `
+		DELETE FROM series
+		WHERE id = $1`
+
+	ctx, cancel := withWriteTimeout(ctx)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, query, id)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+
+	return nil
+}
+
+// SetMovies replaces the movies of a series with the given ones, in the given order, and returns the movies as they now
+// are. They are read back in the same transaction, so the response to the write never comes from a lagging replica.
+// ErrMovieInOtherSeries is returned if one of the movies is already part of another series, and
+// ErrSeriesMovieNotFound if one doesn't exist, in which case the series is left as it was
+func (m SeriesModel) SetMovies(ctx context.Context, seriesID int64, movieIDs []int64) ([]*Movie, error) {
+	ctx, cancel := withWriteTimeout(ctx)
+	defer cancel()
+
+	tx, err := beginTx(ctx, m.DB)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	// the version is bumped so a client holding the series notices its movies have changed
+	query := `
+		UPDATE series
+		SET version = version + 1
+		WHERE id = $1`
+
+	result, err := tx.ExecContext(ctx, query, seriesID)
+	if err != nil {
+		return nil, err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return nil, err
+	}
+
+	if rowsAffected == 0 {
+		return nil, ErrRecordNotFound
+	}
+
+	_, err = tx.ExecContext(ctx, `DELETE FROM series_movies WHERE series_id = $1`, seriesID)
+	if err != nil {
+		return nil, err
+	}
+
+	query = `
+		INSERT INTO series_movies (series_id, movie_id, position)
+		SELECT $1, movie_id, position
+		FROM unnest($2::bigint[]) WITH ORDINALITY AS entries (movie_id, position)`
+
+	_, err = tx.ExecContext(ctx, query, seriesID, movieIDs)
+	if err != nil {
+		switch {
+		case isUniqueViolation(err, "series_movies_pkey"):
+			return nil, ErrMovieInOtherSeries
+		case isForeignKeyViolation(err, "series_movies_movie_id_fkey"):
+			return nil, ErrSeriesMovieNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	movies, err := getSeriesMovies(ctx, tx, seriesID)
+	if err != nil {
+		return nil, err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return nil, err
+	}
+
+	return movies, nil
+}
+
+// GetMovies returns the movies of a series in order
+func (m SeriesModel) GetMovies(ctx context.Context, seriesID int64) ([]*Movie, error) {
+	ctx, cancel := withReadTimeout(ctx)
+	defer cancel()
+
+	return getSeriesMovies(ctx, m.Reader, seriesID)
+}
+
+// getSeriesMovies reads the movies of a series in order with the given connection, which is the transaction of the
+// write when they are read back after one
+func getSeriesMovies(ctx context.Context, db DBTX, seriesID int64) ([]*Movie, error) {
+	query := `
+		SELECT movies.id, movies.created_at, movies.updated_at, title, year, runtime, genres, movies.version, poster_key,
+		average_rating, review_count, language, country
+		FROM movies
+		INNER JOIN series_movies ON series_movies.movie_id = movies.id
+		WHERE series_movies.series_id = $1
+		ORDER BY series_movies.position`
+
+	rows, err := db.QueryContext(ctx, query, seriesID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	movies := []*Movie{}
+
+	for rows.Next() {
+		var movie Movie
+
+		err := rows.Scan(
+			&movie.ID,
+			&movie.CreatedAt,
+			&movie.UpdatedAt,
+			&movie.Title,
+			&movie.Year,
+			&movie.Runtime,
+			scanArray(&movie.Genres),
+			&movie.Version,
+			&movie.PosterKey,
+			&movie.AverageRating,
+			&movie.ReviewCount,
+			&movie.Language,
+			&movie.Country,
+		)
+		if err != nil {
+			return nil, err
+		}
+
+		movie.setPosterURL()
+
+		movies = append(movies, &movie)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return movies, nil
+}
+
+// GetEntries returns the place of each of the movies in its series with a single query. The movies which aren't part
+// of a series are left out of the map
+func (m SeriesModel) GetEntries(ctx context.Context, movieIDs ...int64) (map[int64]*SeriesEntry, error) {
+	// the whole of each series has to be numbered before the movies asked for are picked out, so the positions and
+	// neighbours take the other movies of the series into account
+	query := `
+		SELECT movie_id, series_id, name, position, total, previous_movie_id, next_movie_id
+		FROM (
+			SELECT series_movies.movie_id, series_movies.series_id, series.name,
+			row_number() OVER entries AS position,
+			count(*) OVER (PARTITION BY series_movies.series_id) AS total,
+			COALESCE(lag(series_movies.movie_id) OVER entries, 0) AS previous_movie_id,
+			COALESCE(lead(series_movies.movie_id) OVER entries, 0) AS next_movie_id
+			FROM series_movies
+			INNER JOIN series ON series.id = series_movies.series_id
+			WHERE series_movies.series_id IN (SELECT series_id FROM series_movies WHERE movie_id = ANY($1))
+			WINDOW entries AS (PARTITION BY series_movies.series_id ORDER BY series_movies.position)
+		) AS numbered
+		WHERE movie_id = ANY($1)`
+
+	ctx, cancel := withReadTimeout(ctx)
+	defer cancel()
+
+	rows, err := m.Reader.QueryContext(ctx, query, movieIDs)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	entries := make(map[int64]*SeriesEntry)
+
+	for rows.Next() {
+		var movieID int64
+		var entry SeriesEntry
+
+		err := rows.Scan(&movieID, &entry.ID, &entry.Name, &entry.Position, &entry.Total, &entry.PreviousMovieID, &entry.NextMovieID)
+		if err != nil {
+			return nil, err
+		}
+
+		entries[movieID] = &entry
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return entries, nil
+}
+
+// Mock data for testing
+type MockSeriesModel struct{}
+
+func (m MockSeriesModel) Insert(ctx context.Context, series *Series) error {
+	return nil
+}
+
+func (m MockSeriesModel) Get(ctx context.Context, id int64) (*Series, error) {
+	return nil, ErrRecordNotFound
+}
+
+func (m MockSeriesModel) GetAll(ctx context.Context, name string, filters Filters) ([]*Series, Metadata, error) {
+	return nil, Metadata{}, nil
+}
+
+func (m MockSeriesModel) Update(ctx context.Context, series *Series) error {
+	return nil
+}
+
+func (m MockSeriesModel) Delete(ctx context.Context, id int64) error {
+	return nil
+}
+
+func (m MockSeriesModel) SetMovies(ctx context.Context, seriesID int64, movieIDs []int64) ([]*Movie, error) {
+	return []*Movie{}, nil
+}
+
+func (m MockSeriesModel) GetMovies(ctx context.Context, seriesID int64) ([]*Movie, error) {
+	return []*Movie{}, nil
+}
+
+func (m MockSeriesModel) GetEntries(ctx context.Context, movieIDs ...int64) (map[int64]*SeriesEntry, error) {
+	return map[int64]*SeriesEntry{}, nil
+}
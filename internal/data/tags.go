@@ -0,0 +1,256 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/nytro04/greenlight/internal/validator"
+)
+
+// The largest number of tags which can be added to a movie in one request, and the longest a tag can be
+const (
+	MaxTagsPerRequest = 10
+	MaxTagLength      = 50
+)
+
+// TagRX matches a normalized tag, lowercase letters and digits in words joined by single hyphens
+var TagRX = regexp.MustCompile(`^[a-z0-9]+(-[a-z0-9]+)*$`)
+
+// MovieTag is a free-form label a user has put on a movie, such as oscar-winner or cult-classic. Unlike the genres,
+// which are curated, any user can tag a movie. The tags of a user who has deleted their account are kept with a
+// UserID of zero
+type MovieTag struct {
+	MovieID int64     `json:"-"`
+	Name    string    `json:"name"`
+	UserID  int64     `json:"user_id,omitempty"`
+	AddedAt time.Time `json:"added_at"`
+}
+
+// TagSuggestion is a tag offered to a user as they type one, with the number of times they have used it themselves
+// and the number of movies it is on overall
+type TagSuggestion struct {
+	Name    string `json:"name"`
+	OwnUses int    `json:"own_uses"`
+	Uses    int    `json:"uses"`
+}
+
+// NormalizeTag turns a label as a user typed it into the form tags are stored in: lowercase, with runs of spaces,
+// underscores and hyphens replaced by a single hyphen, so "Cult Classic" and "cult_classic" are the same tag
+func NormalizeTag(tag string) string {
+	words := strings.FieldsFunc(strings.ToLower(tag), func(r rune) bool {
+		return r == ' ' || r == '_' || r == '-' || r == '\t'
+	})
+
+	return strings.Join(words, "-")
+}
+
+// ValidateTags checks the normalized tags of a request
+func ValidateTags(v *validator.Validator, tags []string) {
+	v.Check(len(tags) >= 1, "tags", "must contain at least 1 tag")
+	v.Check(len(tags) <= MaxTagsPerRequest, "tags", fmt.Sprintf("must not contain more than %d tags", MaxTagsPerRequest))
+	v.Check(validator.Unique(tags), "tags", "must not contain duplicate values")
+
+	for _, tag := range tags {
+		v.Check(len(tag) <= MaxTagLength, "tags", fmt.Sprintf("must not contain tags more than %d bytes long", MaxTagLength))
+		v.Check(TagRX.MatchString(tag), "tags", "must only contain letters, digits, spaces and hyphens")
+	}
+}
+
+type TagModel struct {
+	DB     DBTX
+	Reader DBTX // runs the read-only queries, on the read replica if one is configured
+}
+
+// AddToMovie tags a movie on behalf of the user, creating the tags which don't exist yet. The tags the movie already
+// has are left as they are, along with the user who added them, and ErrRecordNotFound is returned if the movie doesn't
+// exist
+func (m TagModel) AddToMovie(ctx context.Context, movieID, userID int64, names []string) error {
+	// the no-op update makes the insert return the ID of the tags which already exist as well as the new ones
+	query := `
+		WITH tag AS (
+			INSERT INTO tags (name)
+			SELECT unnest($3::text[])
+			ON CONFLICT (name) DO UPDATE SET name = EXCLUDED.name
+			RETURNING id
+		)
+		INSERT INTO movie_tags (movie_id, tag_id, user_id)
+		SELECT $1, id, $2 FROM tag
+		ON CONFLICT DO NOTHING`
+
+	ctx, cancel := withWriteTimeout(ctx)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, query, movieID, userID, names)
+	if err != nil {
+		switch {
+		case isForeignKeyViolation(err, "movie_tags_movie_id_fkey"):
+			return ErrRecordNotFound
+		default:
+			return err
+		}
+	}
+
+	return nil
+}
+
+// RemoveFromMovie takes a tag off a movie, returning ErrRecordNotFound if the movie doesn't have it
+func (m TagModel) RemoveFromMovie(ctx context.Context, movieID int64, name string) error {
+	query := `
+		DELETE FROM movie_tags
+		USING tags
+		WHERE movie_tags.tag_id = tags.id AND movie_tags.movie_id = $1 AND tags.name = $2`
+
+	ctx, cancel := withWriteTimeout(ctx)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, query, movieID, name)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+
+	return nil
+}
+
+// GetForMovie returns the tag with the given name on a movie, or ErrRecordNotFound if the movie doesn't have it
+func (m TagModel) GetForMovie(ctx context.Context, movieID int64, name string) (*MovieTag, error) {
+	query := `
+		SELECT movie_tags.movie_id, tags.name, COALESCE(movie_tags.user_id, 0), movie_tags.added_at
+		FROM movie_tags
+		INNER JOIN tags ON tags.id = movie_tags.tag_id
+		WHERE movie_tags.movie_id = $1 AND tags.name = $2`
+
+	ctx, cancel := withReadTimeout(ctx)
+	defer cancel()
+
+	var tag MovieTag
+
+	err := m.DB.QueryRowContext(ctx, query, movieID, name).Scan(&tag.MovieID, &tag.Name, &tag.UserID, &tag.AddedAt)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	return &tag, nil
+}
+
+// GetForMovies returns the tags of each of the movies, in alphabetical order. The movies without any tags are left out
+// of the map
+func (m TagModel) GetForMovies(ctx context.Context, movieIDs ...int64) (map[int64][]*MovieTag, error) {
+	query := `
+		SELECT movie_tags.movie_id, tags.name, COALESCE(movie_tags.user_id, 0), movie_tags.added_at
+		FROM movie_tags
+		INNER JOIN tags ON tags.id = movie_tags.tag_id
+		WHERE movie_tags.movie_id = ANY($1)
+		ORDER BY movie_tags.movie_id, tags.name`
+
+	ctx, cancel := withReadTimeout(ctx)
+	defer cancel()
+
+	rows, err := m.Reader.QueryContext(ctx, query, movieIDs)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	tags := make(map[int64][]*MovieTag)
+
+	for rows.Next() {
+		var tag MovieTag
+
+		err := rows.Scan(&tag.MovieID, &tag.Name, &tag.UserID, &tag.AddedAt)
+		if err != nil {
+			return nil, err
+		}
+
+		tags[tag.MovieID] = append(tags[tag.MovieID], &tag)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return tags, nil
+}
+
+// Suggest returns up to limit tags starting with the prefix for the user to pick from, the tags they have used the
+// most first, then the tags on the most movies. Only the tags which are on at least one movie are suggested
+func (m TagModel) Suggest(ctx context.Context, userID int64, prefix string, limit int) ([]*TagSuggestion, error) {
+	// a normalized prefix only holds letters, digits and hyphens, so it can't contain a LIKE wildcard
+	query := `
+		SELECT tags.name, count(*) FILTER (WHERE movie_tags.user_id = $1), count(*)
+		FROM tags
+		INNER JOIN movie_tags ON movie_tags.tag_id = tags.id
+		WHERE tags.name LIKE $2 || '%'
+		GROUP BY tags.id
+		ORDER BY 2 DESC, 3 DESC, tags.name ASC
+		LIMIT $3`
+
+	ctx, cancel := withReadTimeout(ctx)
+	defer cancel()
+
+	rows, err := m.Reader.QueryContext(ctx, query, userID, prefix, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	suggestions := []*TagSuggestion{}
+
+	for rows.Next() {
+		var suggestion TagSuggestion
+
+		err := rows.Scan(&suggestion.Name, &suggestion.OwnUses, &suggestion.Uses)
+		if err != nil {
+			return nil, err
+		}
+
+		suggestions = append(suggestions, &suggestion)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return suggestions, nil
+}
+
+// Mock data for testing
+type MockTagModel struct{}
+
+func (m MockTagModel) AddToMovie(ctx context.Context, movieID, userID int64, names []string) error {
+	return nil
+}
+
+func (m MockTagModel) RemoveFromMovie(ctx context.Context, movieID int64, name string) error {
+	return nil
+}
+
+func (m MockTagModel) GetForMovie(ctx context.Context, movieID int64, name string) (*MovieTag, error) {
+	return nil, ErrRecordNotFound
+}
+
+func (m MockTagModel) GetForMovies(ctx context.Context, movieIDs ...int64) (map[int64][]*MovieTag, error) {
+	return map[int64][]*MovieTag{}, nil
+}
+
+func (m MockTagModel) Suggest(ctx context.Context, userID int64, prefix string, limit int) ([]*TagSuggestion, error) {
+	return []*TagSuggestion{}, nil
+}
@@ -0,0 +1,25 @@
+package data
+
+import (
+	"context"
+	"time"
+)
+
+// ReadTimeout and WriteTimeout bound how long a single read or write of the models may take, on top of any deadline
+// of the context it was called with. They are set from the -db-read-timeout and -db-write-timeout flags when the
+// application starts. The bulk operations, such as InsertMany, keep their own longer timeouts
+var (
+	ReadTimeout  = 3 * time.Second
+	WriteTimeout = 3 * time.Second
+)
+
+// withReadTimeout returns a context which is cancelled after the read timeout
+func withReadTimeout(ctx context.Context) (context.Context, context.CancelFunc) {
+	return context.WithTimeout(ctx, ReadTimeout)
+}
+
+// withWriteTimeout returns a context which is cancelled after the write timeout. Methods which both read and write,
+// or run a transaction, use the write timeout
+func withWriteTimeout(ctx context.Context) (context.Context, context.CancelFunc) {
+	return context.WithTimeout(ctx, WriteTimeout)
+}
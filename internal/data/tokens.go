@@ -2,12 +2,12 @@ package data
 
 import (
 	"context"
-	"crypto/rand"
 	"crypto/sha256"
-	"database/sql"
 	"encoding/base32"
+	"io"
 	"time"
 
+	"github.com/nytro04/greenlight/internal/clock"
 	"github.com/nytro04/greenlight/internal/validator"
 )
 
@@ -15,6 +15,7 @@ import (
 const (
 	ScopeActivation     = "activation"
 	ScopeAuthentication = "authentication"
+	ScopeEmailChange    = "email_change"
 )
 
 // Define a Token struct to hold the data for a single token. This will be used to read and write token data to and from the database
@@ -26,27 +27,48 @@ type Token struct {
 	UserID    int64     `json:"-"`      // the ID of the user the token belongs to
 	Expiry    time.Time `json:"expiry"` // the expiry time of the token
 	Scope     string    `json:"-"`      // the scope of the token
+	IPAddress string    `json:"-"`      // the address of the client the token was issued to, only kept for authentication tokens
+	UserAgent string    `json:"-"`      // the User-Agent of the client the token was issued to
 }
 
+// Session describes an authentication token a user is signed in with, so they can recognise their devices and sign out
+// of the ones they don't. The token itself is never returned
+type Session struct {
+	ID         int64      `json:"id"`
+	CreatedAt  time.Time  `json:"created_at"`
+	LastUsedAt *time.Time `json:"last_used_at"`
+	Expiry     time.Time  `json:"expiry"`
+	IPAddress  string     `json:"ip_address"`
+	UserAgent  string     `json:"user_agent"`
+	Current    bool       `json:"current"` // the session the request was made with
+}
+
+// MaxUserAgentLength is the longest User-Agent stored with a session, longer ones are truncated
+const MaxUserAgentLength = 512
+
+// sessionTouchInterval is how often the last use of a session is written, so a busy client doesn't cause a write on every request
+const sessionTouchInterval = time.Minute
+
 // generateToken generates a new token for the user with the provided user ID, expiry time, and scope.
 // The method generates a random 16-byte plaintext token, encodes it to a base32-encoded string, and stores it in the Plaintext field of the token.
 // It then generates the SHA-256 hash of the plaintext token and stores it in the Hash field of the token.
 // The method returns the token and a nil error if the token is generated successfully.
 // If an error occurs when generating the random bytes, the method returns nil and the error.
-func generateToken(userID int64, ttl time.Duration, scope string) (*Token, error) {
+func generateToken(clk clock.Clock, rnd io.Reader, userID int64, ttl time.Duration, scope string) (*Token, error) {
 	// Generate a new token with the provided user ID, expiry time, and scope.
-	// Notice we add the provided ttl(time to live) to the current time to get the expiry time of the token.
+	// Notice we add the provided ttl(time to live) to the current time on the clock to get the expiry time of the token.
 	token := &Token{
 		UserID: userID,
-		Expiry: time.Now().Add(ttl),
+		Expiry: clk.Now().Add(ttl),
 		Scope:  scope,
 	}
 
 	// Initialize a zero-valued byte slice with a length of 16 bytes.
 	randomBytes := make([]byte, 16)
 
-	// Use the Read method from the crypto/rand package to fill the byte slice with random bytes.
-	_, err := rand.Read(randomBytes)
+	// Fill the byte slice with bytes from the random source. This is crypto/rand in production, but can be a seeded
+	// deterministic source in test environments.
+	_, err := io.ReadFull(rnd, randomBytes)
 	if err != nil {
 		return nil, err
 	}
@@ -71,30 +93,51 @@ func ValidateTokenPlaintext(v *validator.Validator, tokenPlaintext string) {
 
 // Define the TokenModel type
 type TokenModel struct {
-	DB *sql.DB
+	DB     DBTX
+	Clock  clock.Clock
+	Random io.Reader // the source of the random bytes in the generated tokens
 }
 
 // The New method is a shortcut for generating a new token struct and inserting it into the tokens table.
-func (m TokenModel) New(userID int64, ttl time.Duration, scope string) (*Token, error) {
-	token, err := generateToken(userID, ttl, scope)
+func (m TokenModel) New(ctx context.Context, userID int64, ttl time.Duration, scope string) (*Token, error) {
+	token, err := generateToken(m.Clock, m.Random, userID, ttl, scope)
 	if err != nil {
 		return nil, err
 	}
 
-	err = m.Insert(token)
+	err = m.Insert(ctx, token)
+	return token, err
+}
+
+// NewSession generates an authentication token for the user and inserts it along with the client it was issued to, so
+// it can be listed among the user's sessions
+func (m TokenModel) NewSession(ctx context.Context, userID int64, ttl time.Duration, ipAddress, userAgent string) (*Token, error) {
+	token, err := generateToken(m.Clock, m.Random, userID, ttl, ScopeAuthentication)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(userAgent) > MaxUserAgentLength {
+		userAgent = userAgent[:MaxUserAgentLength]
+	}
+
+	token.IPAddress = ipAddress
+	token.UserAgent = userAgent
+
+	err = m.Insert(ctx, token)
 	return token, err
 }
 
 // Insert method to create a new token record in the tokens table
-func (m TokenModel) Insert(token *Token) error {
+func (m TokenModel) Insert(ctx context.Context, token *Token) error {
 	query := `
-	INSERT INTO tokens (hash, user_id, expiry, scope)
-	VALUES ($1, $2, $3, $4)
+	INSERT INTO tokens (hash, user_id, expiry, scope, ip_address, user_agent)
+	VALUES ($1, $2, $3, $4, $5, $6)
 	`
 	// Create a slice containing the token struct fields to be inserted into the database.
-	args := []interface{}{token.Hash, token.UserID, token.Expiry, token.Scope}
+	args := []interface{}{token.Hash, token.UserID, token.Expiry, token.Scope, token.IPAddress, token.UserAgent}
 
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	ctx, cancel := withWriteTimeout(ctx)
 	defer cancel()
 
 	_, err := m.DB.ExecContext(ctx, query, args...)
@@ -102,30 +145,265 @@ func (m TokenModel) Insert(token *Token) error {
 }
 
 // DeleteAllForUser method to delete all tokens for a specific user and scope
-func (m TokenModel) DeleteAllForUser(scope string, userID int64) error {
+func (m TokenModel) DeleteAllForUser(ctx context.Context, scope string, userID int64) error {
 	query := `
 	DELETE FROM tokens
 	WHERE scope = $1 AND user_id = $2
 	`
 
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	ctx, cancel := withWriteTimeout(ctx)
 	defer cancel()
 
 	_, err := m.DB.ExecContext(ctx, query, scope, userID)
 	return err
 }
 
+// IssuedSince returns when each of the user's tokens in the scope created after since was created, oldest first. It is
+// used to limit how often a token which is sent by email can be requested
+func (m TokenModel) IssuedSince(ctx context.Context, scope string, userID int64, since time.Time) ([]time.Time, error) {
+	query := `
+	SELECT created_at
+	FROM tokens
+	WHERE scope = $1 AND user_id = $2 AND created_at > $3
+	ORDER BY created_at
+	`
+
+	ctx, cancel := withReadTimeout(ctx)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, scope, userID, since)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	issued := []time.Time{}
+
+	for rows.Next() {
+		var createdAt time.Time
+
+		err := rows.Scan(&createdAt)
+		if err != nil {
+			return nil, err
+		}
+
+		issued = append(issued, createdAt)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return issued, nil
+}
+
+// DeleteExpired removes every token which expired before the given time, returning the number removed in each scope
+func (m TokenModel) DeleteExpired(before time.Time) (map[string]int64, error) {
+	query := `
+	WITH deleted AS (
+		DELETE FROM tokens
+		WHERE expiry < $1
+		RETURNING scope
+	)
+	SELECT scope, COUNT(*) FROM deleted GROUP BY scope
+	`
+
+	// the first run can have a backlog of expired tokens to get through, so we use a more forgiving timeout here
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, before)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	deleted := map[string]int64{}
+
+	for rows.Next() {
+		var scope string
+		var count int64
+
+		err := rows.Scan(&scope, &count)
+		if err != nil {
+			return nil, err
+		}
+
+		deleted[scope] = count
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return deleted, nil
+}
+
+// Delete removes a single token of the user, so it can't be used any more. ErrRecordNotFound is returned if the user
+// has no such token in the scope
+func (m TokenModel) Delete(ctx context.Context, scope string, userID int64, tokenPlaintext string) error {
+	hash := sha256.Sum256([]byte(tokenPlaintext))
+
+	query := `
+	DELETE FROM tokens
+	WHERE hash = $1 AND scope = $2 AND user_id = $3
+	`
+
+	ctx, cancel := withWriteTimeout(ctx)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, query, hash[:], scope, userID)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+
+	return nil
+}
+
+// GetSessions returns the user's authentication tokens which haven't expired, most recently created first. The session
+// of the current token is marked as current
+func (m TokenModel) GetSessions(ctx context.Context, userID int64, currentPlaintext string) ([]*Session, error) {
+	hash := sha256.Sum256([]byte(currentPlaintext))
+
+	query := `
+	SELECT id, created_at, last_used_at, expiry, ip_address, user_agent, hash = $1
+	FROM tokens
+	WHERE user_id = $2 AND scope = $3 AND expiry > $4
+	ORDER BY created_at DESC, id DESC
+	`
+
+	ctx, cancel := withReadTimeout(ctx)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, hash[:], userID, ScopeAuthentication, m.Clock.Now())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	sessions := []*Session{}
+
+	for rows.Next() {
+		var session Session
+
+		err := rows.Scan(
+			&session.ID,
+			&session.CreatedAt,
+			&session.LastUsedAt,
+			&session.Expiry,
+			&session.IPAddress,
+			&session.UserAgent,
+			&session.Current,
+		)
+		if err != nil {
+			return nil, err
+		}
+
+		sessions = append(sessions, &session)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return sessions, nil
+}
+
+// DeleteSession removes one of the user's authentication tokens by its ID. ErrRecordNotFound is returned if the user
+// has no session with the ID
+func (m TokenModel) DeleteSession(ctx context.Context, userID, id int64) error {
+	query := `
+	DELETE FROM tokens
+	WHERE id = $1 AND user_id = $2 AND scope = $3
+	`
+
+	ctx, cancel := withWriteTimeout(ctx)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, query, id, userID, ScopeAuthentication)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+
+	return nil
+}
+
+// Touch records that an authentication token has just been used. The time is only written when the last one recorded
+// is more than a minute old
+func (m TokenModel) Touch(ctx context.Context, tokenPlaintext string) error {
+	hash := sha256.Sum256([]byte(tokenPlaintext))
+	now := m.Clock.Now()
+
+	query := `
+	UPDATE tokens
+	SET last_used_at = $1
+	WHERE hash = $2 AND (last_used_at IS NULL OR last_used_at < $3)
+	`
+
+	ctx, cancel := withWriteTimeout(ctx)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, query, now, hash[:], now.Add(-sessionTouchInterval))
+	return err
+}
+
 // MockTokenModel type to help with testing
 type MockTokenModel struct{}
 
-func (m MockTokenModel) New(userID int64, ttl time.Duration, scope string) (*Token, error) {
+func (m MockTokenModel) New(ctx context.Context, userID int64, ttl time.Duration, scope string) (*Token, error) {
+	return &Token{UserID: userID, Scope: scope}, nil
+}
+
+func (m MockTokenModel) Insert(ctx context.Context, token *Token) error {
+	return nil
+}
+
+func (m MockTokenModel) DeleteAllForUser(ctx context.Context, scope string, userID int64) error {
+	return nil
+}
+
+func (m MockTokenModel) IssuedSince(ctx context.Context, scope string, userID int64, since time.Time) ([]time.Time, error) {
+	return nil, nil
+}
+
+func (m MockTokenModel) DeleteExpired(before time.Time) (map[string]int64, error) {
+	return map[string]int64{}, nil
+}
+
+func (m MockTokenModel) Delete(ctx context.Context, scope string, userID int64, tokenPlaintext string) error {
+	return nil
+}
+
+func (m MockTokenModel) NewSession(ctx context.Context, userID int64, ttl time.Duration, ipAddress, userAgent string) (*Token, error) {
+	return nil, nil
+}
+
+func (m MockTokenModel) GetSessions(ctx context.Context, userID int64, currentPlaintext string) ([]*Session, error) {
 	return nil, nil
 }
 
-func (m MockTokenModel) Insert(token *Token) error {
+func (m MockTokenModel) DeleteSession(ctx context.Context, userID, id int64) error {
 	return nil
 }
 
-func (m MockTokenModel) DeleteAllForUser(scope string, userID int64) error {
+func (m MockTokenModel) Touch(ctx context.Context, tokenPlaintext string) error {
 	return nil
 }
@@ -0,0 +1,380 @@
+package data
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"crypto/subtle"
+	"database/sql"
+	"encoding/base32"
+	"encoding/base64"
+	"errors"
+	"image/png"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/nytro04/greenlight/internal/clock"
+	"github.com/nytro04/greenlight/internal/validator"
+	"github.com/pquerna/otp"
+	"github.com/pquerna/otp/totp"
+)
+
+// ErrTOTPEnabled is returned when a user who has already enabled two-factor authentication tries to enroll again
+var ErrTOTPEnabled = errors.New("two-factor authentication already enabled")
+
+// the TOTP codes are the ones every authenticator app supports: six digits from an HMAC-SHA1 of the 30 second time step.
+// A code from the step before or after the current one is accepted as well, to allow for clock drift
+const (
+	totpPeriod = 30
+	totpSkew   = 1
+
+	// RecoveryCodeCount is the number of recovery codes generated each time
+	RecoveryCodeCount = 10
+)
+
+var totpOpts = totp.ValidateOpts{
+	Period:    totpPeriod,
+	Digits:    otp.DigitsSix,
+	Algorithm: otp.AlgorithmSHA1,
+}
+
+// TOTP holds the two-factor authentication enrollment of a user. The secret is only sent to the client once, when
+// the user enrolls
+type TOTP struct {
+	UserID       int64     `json:"-"`
+	CreatedAt    time.Time `json:"created_at"`
+	Secret       string    `json:"-"`
+	Enabled      bool      `json:"enabled"`
+	LastUsedStep int64     `json:"-"`
+}
+
+// TOTPEnrollment is returned when a user starts enrolling. The provisioning URI is the payload of the QR code the
+// authenticator apps scan, the secret is for entering by hand
+type TOTPEnrollment struct {
+	Secret          string `json:"secret"`
+	ProvisioningURI string `json:"provisioning_uri"`
+	QRCode          string `json:"qr_code"` // PNG image of the provisioning URI, as a data URI
+}
+
+// ValidateTOTPCode checks that a code entered by the user looks like a TOTP code
+func ValidateTOTPCode(v *validator.Validator, key, code string) {
+	v.Check(code != "", key, "must be provided")
+	v.Check(len(code) == 6 && strings.Trim(code, "0123456789") == "", key, "must be a 6 digit code")
+}
+
+// normalizeRecoveryCode lowercases a recovery code and removes the dash and any spaces, so the code can be typed the
+// way it was shown or not
+func normalizeRecoveryCode(code string) string {
+	return strings.ToLower(strings.NewReplacer("-", "", " ", "").Replace(code))
+}
+
+// qrCodeDataURI renders the provisioning URI of the key as a QR code, returned as a PNG data URI the client can use as
+// the src of an img element
+func qrCodeDataURI(key *otp.Key) (string, error) {
+	img, err := key.Image(256, 256)
+	if err != nil {
+		return "", err
+	}
+
+	var buf bytes.Buffer
+
+	err = png.Encode(&buf, img)
+	if err != nil {
+		return "", err
+	}
+
+	return "data:image/png;base64," + base64.StdEncoding.EncodeToString(buf.Bytes()), nil
+}
+
+// TOTPModel wraps the connection pool and is used to read and write the TOTP enrollments and recovery codes
+type TOTPModel struct {
+	DB     DBTX
+	Clock  clock.Clock
+	Random io.Reader
+}
+
+// Get returns the enrollment of a user, or ErrRecordNotFound if they have never enrolled
+func (m TOTPModel) Get(userID int64) (*TOTP, error) {
+	query := `
+		SELECT user_id, created_at, secret, enabled, last_used_step
+		FROM user_totp
+		WHERE user_id = $1`
+
+	ctx, cancel := withReadTimeout(context.Background())
+	defer cancel()
+
+	var t TOTP
+
+	err := m.DB.QueryRowContext(ctx, query, userID).Scan(&t.UserID, &t.CreatedAt, &t.Secret, &t.Enabled, &t.LastUsedStep)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	return &t, nil
+}
+
+// Enroll generates a new secret for the user and stores it, replacing any enrollment which hasn't been confirmed yet.
+// Two-factor authentication isn't enabled until a code generated from the secret is confirmed with Enable.
+// ErrTOTPEnabled is returned if the user has already enabled it
+func (m TOTPModel) Enroll(userID int64, issuer, accountName string) (*TOTPEnrollment, error) {
+	key, err := totp.Generate(totp.GenerateOpts{
+		Issuer:      issuer,
+		AccountName: accountName,
+		Period:      totpPeriod,
+		Digits:      otp.DigitsSix,
+		Algorithm:   otp.AlgorithmSHA1,
+		Rand:        m.Random,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// the enrollment is only replaced while it hasn't been enabled, so no row is returned for an enabled one
+	query := `
+		INSERT INTO user_totp (user_id, secret)
+		VALUES ($1, $2)
+		ON CONFLICT (user_id) DO UPDATE
+		SET secret = EXCLUDED.secret, created_at = NOW(), last_used_step = 0
+		WHERE NOT user_totp.enabled
+		RETURNING user_id`
+
+	ctx, cancel := withWriteTimeout(context.Background())
+	defer cancel()
+
+	err = m.DB.QueryRowContext(ctx, query, userID, key.Secret()).Scan(&userID)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrTOTPEnabled
+		default:
+			return nil, err
+		}
+	}
+
+	qrCode, err := qrCodeDataURI(key)
+	if err != nil {
+		return nil, err
+	}
+
+	return &TOTPEnrollment{Secret: key.Secret(), ProvisioningURI: key.URL(), QRCode: qrCode}, nil
+}
+
+// Verify reports whether the code is valid for the user's secret at the current time. A code is only accepted once,
+// and never after a code from a later time step has been accepted, so an intercepted code can't be replayed
+func (m TOTPModel) Verify(t *TOTP, code string) (bool, error) {
+	now := m.Clock.Now()
+	step := now.Unix() / totpPeriod
+
+	for i := int64(-totpSkew); i <= totpSkew; i++ {
+		if step+i <= t.LastUsedStep {
+			continue
+		}
+
+		expected, err := totp.GenerateCodeCustom(t.Secret, time.Unix((step+i)*totpPeriod, 0), totpOpts)
+		if err != nil {
+			return false, err
+		}
+
+		if subtle.ConstantTimeCompare([]byte(expected), []byte(code)) != 1 {
+			continue
+		}
+
+		// record the step, unless a concurrent request got there first with the same code
+		query := `
+			UPDATE user_totp
+			SET last_used_step = $1
+			WHERE user_id = $2 AND last_used_step < $1`
+
+		ctx, cancel := withWriteTimeout(context.Background())
+		defer cancel()
+
+		result, err := m.DB.ExecContext(ctx, query, step+i, t.UserID)
+		if err != nil {
+			return false, err
+		}
+
+		rowsAffected, err := result.RowsAffected()
+		if err != nil {
+			return false, err
+		}
+
+		t.LastUsedStep = step + i
+		return rowsAffected == 1, nil
+	}
+
+	return false, nil
+}
+
+// Enable turns on two-factor authentication for the user, and replaces their recovery codes with a new set. The
+// plaintext recovery codes are returned so they can be shown to the user, only their hashes are stored
+func (m TOTPModel) Enable(userID int64) ([]string, error) {
+	ctx, cancel := withWriteTimeout(context.Background())
+	defer cancel()
+
+	tx, err := beginTx(ctx, m.DB)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.ExecContext(ctx, `UPDATE user_totp SET enabled = true WHERE user_id = $1`, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	codes, err := m.replaceRecoveryCodes(ctx, tx, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return nil, err
+	}
+
+	return codes, nil
+}
+
+// NewRecoveryCodes replaces the user's recovery codes with a new set, invalidating the old ones
+func (m TOTPModel) NewRecoveryCodes(userID int64) ([]string, error) {
+	ctx, cancel := withWriteTimeout(context.Background())
+	defer cancel()
+
+	tx, err := beginTx(ctx, m.DB)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	codes, err := m.replaceRecoveryCodes(ctx, tx, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return nil, err
+	}
+
+	return codes, nil
+}
+
+// replaceRecoveryCodes deletes the user's recovery codes and stores the hashes of a new set in the transaction. The
+// codes are 8 random base32 characters shown as two groups of four, such as 4xkq-m2pa
+func (m TOTPModel) replaceRecoveryCodes(ctx context.Context, tx DBTX, userID int64) ([]string, error) {
+	_, err := tx.ExecContext(ctx, `DELETE FROM totp_recovery_codes WHERE user_id = $1`, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	codes := make([]string, RecoveryCodeCount)
+
+	for i := range codes {
+		randomBytes := make([]byte, 5)
+
+		_, err := io.ReadFull(m.Random, randomBytes)
+		if err != nil {
+			return nil, err
+		}
+
+		code := strings.ToLower(base32.StdEncoding.EncodeToString(randomBytes))
+		codes[i] = code[:4] + "-" + code[4:]
+
+		hash := sha256.Sum256([]byte(normalizeRecoveryCode(code)))
+
+		_, err = tx.ExecContext(ctx, `INSERT INTO totp_recovery_codes (user_id, hash) VALUES ($1, $2)`, userID, hash[:])
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return codes, nil
+}
+
+// UseRecoveryCode marks one of the user's unused recovery codes as used, reporting whether the code matched one
+func (m TOTPModel) UseRecoveryCode(userID int64, code string) (bool, error) {
+	hash := sha256.Sum256([]byte(normalizeRecoveryCode(code)))
+
+	query := `
+		UPDATE totp_recovery_codes
+		SET used_at = $1
+		WHERE user_id = $2 AND hash = $3 AND used_at IS NULL`
+
+	ctx, cancel := withWriteTimeout(context.Background())
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, query, m.Clock.Now(), userID, hash[:])
+	if err != nil {
+		return false, err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+
+	return rowsAffected == 1, nil
+}
+
+// Delete turns off two-factor authentication for the user, removing their secret and recovery codes
+func (m TOTPModel) Delete(userID int64) error {
+	query := `
+		DELETE FROM user_totp
+		WHERE user_id = $1`
+
+	ctx, cancel := withWriteTimeout(context.Background())
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, query, userID)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+
+	return nil
+}
+
+// Mock data for testing
+type MockTOTPModel struct{}
+
+func (m MockTOTPModel) Get(userID int64) (*TOTP, error) {
+	return nil, ErrRecordNotFound
+}
+
+func (m MockTOTPModel) Enroll(userID int64, issuer, accountName string) (*TOTPEnrollment, error) {
+	return &TOTPEnrollment{}, nil
+}
+
+func (m MockTOTPModel) Verify(t *TOTP, code string) (bool, error) {
+	return false, nil
+}
+
+func (m MockTOTPModel) Enable(userID int64) ([]string, error) {
+	return nil, nil
+}
+
+func (m MockTOTPModel) NewRecoveryCodes(userID int64) ([]string, error) {
+	return nil, nil
+}
+
+func (m MockTOTPModel) UseRecoveryCode(userID int64, code string) (bool, error) {
+	return false, nil
+}
+
+func (m MockTOTPModel) Delete(userID int64) error {
+	return nil
+}
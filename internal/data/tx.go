@@ -0,0 +1,112 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"sync/atomic"
+)
+
+// DBTX is the part of the connection pool the models run their queries with, which a transaction has as well, so the
+// same models can run on either
+type DBTX interface {
+	ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error)
+	QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error)
+	QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row
+}
+
+// Tx is a transaction started by beginTx
+type Tx interface {
+	DBTX
+	Commit() error
+	Rollback() error
+}
+
+// savepointSeq numbers the savepoints so nested ones don't share a name
+var savepointSeq atomic.Int64
+
+// beginTx starts a transaction on the pool, or a savepoint if the model is already running inside a transaction
+// started by Models.WithTx. Either way the steps of the model's method are committed or rolled back together, and in
+// the second case the outer transaction decides whether they are kept
+func beginTx(ctx context.Context, db DBTX) (Tx, error) {
+	switch db := db.(type) {
+	case *sql.DB:
+		tx, err := db.BeginTx(ctx, nil)
+		if err != nil {
+			return nil, err
+		}
+		return tx, nil
+	case *sql.Tx:
+		name := fmt.Sprintf("sp_%d", savepointSeq.Add(1))
+
+		_, err := db.ExecContext(ctx, "SAVEPOINT "+name)
+		if err != nil {
+			return nil, err
+		}
+		return &savepoint{Tx: db, ctx: ctx, name: name}, nil
+	default:
+		return nil, fmt.Errorf("data: can't begin a transaction on %T", db)
+	}
+}
+
+// savepoint is a transaction nested inside another with a savepoint. Like *sql.Tx, rolling it back after it has been
+// committed does nothing, so it can be rolled back with defer
+type savepoint struct {
+	*sql.Tx
+	ctx  context.Context
+	name string
+	done bool
+}
+
+func (s *savepoint) Commit() error {
+	if s.done {
+		return sql.ErrTxDone
+	}
+	s.done = true
+
+	_, err := s.Tx.ExecContext(s.ctx, "RELEASE SAVEPOINT "+s.name)
+	return err
+}
+
+func (s *savepoint) Rollback() error {
+	if s.done {
+		return sql.ErrTxDone
+	}
+	s.done = true
+
+	_, err := s.Tx.ExecContext(s.ctx, "ROLLBACK TO SAVEPOINT "+s.name)
+	return err
+}
+
+// WithTx runs fn with models which all run their queries in a single transaction. The transaction is committed if fn
+// returns nil and rolled back if it returns an error (which WithTx returns) or panics, so the steps of a flow such as
+// registration either all happen or none do. The reads inside the transaction run on it as well, never on the read
+// replica. The movie hooks are only called once the transaction has been committed. The mock models have no database,
+// so fn is just called with them
+func (m Models) WithTx(ctx context.Context, fn func(tx Models) error) error {
+	if m.db == nil {
+		return fn(m)
+	}
+
+	tx, err := m.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	hooks := &movieHooks{parent: m.movieHooks}
+
+	err = fn(newModels(tx, tx, m.clock, m.random, hooks))
+	if err != nil {
+		return err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return err
+	}
+
+	hooks.commit(ctx)
+
+	return nil
+}
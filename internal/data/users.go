@@ -5,32 +5,53 @@ import (
 	"crypto/sha256"
 	"database/sql"
 	"errors"
+	"fmt"
 	"time"
 
+	"github.com/nbutton23/zxcvbn-go"
+	"github.com/nytro04/greenlight/internal/clock"
+	pwhash "github.com/nytro04/greenlight/internal/password"
 	"github.com/nytro04/greenlight/internal/validator"
-	"golang.org/x/crypto/bcrypt"
 )
 
 // Define a custom ErrDuplicateEmail error. This will be used to indicate that a user with the specified email address already exists in the database
-var (
-	EmailDuplicateKeyConstraint = `pq: duplicate key value violates unique constraint "users_email_key"`
-	ErrDuplicateEmail           = errors.New("duplicate email")
-)
+var ErrDuplicateEmail = errors.New("duplicate email")
+
+// MinPasswordScore is the lowest zxcvbn strength score, from 0 (too guessable) to 4 (very unguessable), a new password
+// must have. It is set from the -password-min-score flag when the application starts
+var MinPasswordScore = 3
+
+// PasswordHasher hashes the passwords of the users and checks them at login. It is set from the password hashing flags
+// when the application starts
+var PasswordHasher pwhash.Hasher = pwhash.Bcrypt{Cost: pwhash.DefaultBcryptCost}
 
 // Define a UserModel struct type which wraps the connection pool .This struct will be used to read and write user data to and from the database
 type UserModel struct {
-	DB *sql.DB
+	DB    DBTX
+	Clock clock.Clock
 }
 
 // Define a User struct to hold the data for a single user. This will be used to read and write user data to and from the database
 type User struct {
-	ID        int64     `json:"id"`
-	CreatedAt time.Time `json:"created_at"`
-	Name      string    `json:"name"`
-	Email     string    `json:"email"`
-	Password  password  `json:"-"` // use the "-" to tell the json package to ignore this field
-	Activated bool      `json:"activated"`
-	Version   int       `json:"-"` // use the "-" to tell the json package to ignore this field
+	ID         int64      `json:"id"`
+	CreatedAt  time.Time  `json:"created_at"`
+	Name       string     `json:"name"`
+	Email      string     `json:"email"`
+	Password   password   `json:"-"` // use the "-" to tell the json package to ignore this field
+	Activated  bool       `json:"activated"`
+	DisabledAt *time.Time `json:"disabled_at,omitempty"` // when the user was deprovisioned, nil while they are enabled
+	Version    int        `json:"-"`                     // use the "-" to tell the json package to ignore this field
+	AvatarKey  string     `json:"-"`                     // Storage key of the uploaded avatar, empty if the user doesn't have one
+	AvatarURL  string     `json:"avatar_url,omitempty"`  // URL the avatar can be fetched from
+}
+
+// setAvatarURL fills in the avatar URL from the avatar key. Like the poster URL of a movie, it always points at the API
+// whichever storage backend the avatar is kept in
+func (u *User) setAvatarURL() {
+	u.AvatarURL = ""
+	if u.AvatarKey != "" {
+		u.AvatarURL = fmt.Sprintf("/v1/users/%d/avatar", u.ID)
+	}
 }
 
 // create a custom type to represent a password. This will be used to store the plaintext password and the hashed version of the password
@@ -45,14 +66,19 @@ type password struct {
 // this represent an inactivated user with no email, password or id
 var AnonymousUser = &User{}
 
+// Disabled reports whether the user has been deprovisioned, which locks them out until they are enabled again
+func (u *User) Disabled() bool {
+	return u.DisabledAt != nil
+}
+
 // Check if the user instance is the AnonymousUser
 func (u *User) IsAnonymous() bool {
 	return u == AnonymousUser
 }
 
-// generate the bcrypt hash of a plaintext password and store both the plaintext and hashed versions of the password in the password struct
+// generate the hash of a plaintext password with the configured PasswordHasher and store both the plaintext and hashed versions of the password in the password struct
 func (p *password) HashPassword(plaintextPassword string) error {
-	hash, err := bcrypt.GenerateFromPassword([]byte(plaintextPassword), 12) // use a cost of 12 to generate the bcrypt hash
+	hash, err := PasswordHasher.Hash(plaintextPassword)
 	if err != nil {
 		return err
 	}
@@ -62,19 +88,16 @@ func (p *password) HashPassword(plaintextPassword string) error {
 	return nil
 }
 
-// check if a plaintext password matches the hashed password. It returns true if the passwords match, or false if they do not
+// check if a plaintext password matches the hashed password. It returns true if the passwords match, or false if they do not.
+// The hash can have been made with any of the algorithms PasswordHasher supports, not only the configured one
 func (p *password) Matches(plaintextPassword string) (bool, error) {
-	err := bcrypt.CompareHashAndPassword(p.hash, []byte(plaintextPassword))
-	if err != nil {
-		switch {
-		case errors.Is(err, bcrypt.ErrMismatchedHashAndPassword):
-			return false, nil
-		default:
-			return false, err
-		}
-	}
+	return PasswordHasher.Matches(plaintextPassword, p.hash)
+}
 
-	return true, nil
+// check if the hashed password was made with another algorithm or parameters than the configured PasswordHasher uses, in which
+// case it should be hashed again the next time the user gives their password
+func (p *password) NeedsRehash() bool {
+	return PasswordHasher.NeedsRehash(p.hash)
 }
 
 // validate the email address using the validator package. The email address must be provided and must be a valid email address
@@ -83,13 +106,28 @@ func ValidateEmail(v *validator.Validator, email string) {
 	v.Check(validator.Matches(email, validator.EmailRX), "email", "must be a valid email address")
 }
 
-// validate the plaintext password using the validator package. The password must be at least 8 bytes long and no more than 72 bytes long
-func ValidatePasswordPlaintext(v *validator.Validator, password string) {
+// validate the plaintext password using the validator package. The password must be at least 8 bytes long and no more than 72 bytes long,
+// and its estimated strength must reach MinPasswordScore. The user inputs, such as the user's name and email address, are treated as
+// easy to guess, so a password made up of them is rejected too
+func ValidatePasswordPlaintext(v *validator.Validator, password string, userInputs ...string) {
 	v.Check(password != "", "password", "must be provided")
 	v.Check(len(password) >= 8, "password", "must be at least 8 bytes long")
 	v.Check(len(password) <= 72, "password", "must not be more than 72 bytes long")
 
-	// TODO: Add additional checks for password strength (e.g. requiring a mix of uppercase and lowercase letters, numbers, and symbols)
+	// only estimate the strength of a password which passed the other checks, so there is a single error for the field
+	if _, exists := v.Errors["password"]; exists {
+		return
+	}
+
+	strength := zxcvbn.PasswordStrength(password, userInputs)
+	v.Check(strength.Score >= MinPasswordScore, "password", fmt.Sprintf("is too easy to guess (it could be cracked in %s), try a longer passphrase of unrelated words", strength.CrackTimeDisplay))
+}
+
+// validate a password given to log in. Only the length is checked, the strength of a password which was accepted under an older
+// MinPasswordScore mustn't stop its user from logging in
+func ValidatePasswordLogin(v *validator.Validator, password string) {
+	v.Check(password != "", "password", "must be provided")
+	v.Check(len(password) <= 72, "password", "must not be more than 72 bytes long")
 }
 
 // validate the user data using the validator package. This function will validate the name field is not empty and not more than 500 bytes long, and then
@@ -104,7 +142,7 @@ func ValidateUser(v *validator.Validator, user *User) {
 
 	// if the plaintext password is not nil, validate it using the ValidatePasswordPlaintext helper
 	if user.Password.plaintext != nil {
-		ValidatePasswordPlaintext(v, *user.Password.plaintext)
+		ValidatePasswordPlaintext(v, *user.Password.plaintext, user.Name, user.Email)
 	}
 
 	// if the password is ever nil, this will be due to a logic error in our codebase(probably we forgot to set a password for the user)
@@ -121,7 +159,7 @@ func ValidateUser(v *validator.Validator, user *User) {
 
 // Insert a new user record in the database for the user. Note that the id, created_at, and version fields are all automatically generated by the database.
 // so we use the RETURNING clause to read them back into the user struct after the insert, and update the fields accordingly
-func (m UserModel) Insert(user *User) error {
+func (m UserModel) Insert(ctx context.Context, user *User) error {
 	query := `
 		INSERT INTO users (name, email, password_hash, activated)
 		VALUES($1, $2, $3, $4)
@@ -130,7 +168,7 @@ func (m UserModel) Insert(user *User) error {
 
 	args := []interface{}{user.Name, user.Email, user.Password.hash, user.Activated}
 
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	ctx, cancel := withWriteTimeout(ctx)
 	defer cancel()
 
 	// use QueryRowContext to execute the query and scan the returned id, created_at, and version values into the user struct
@@ -138,8 +176,8 @@ func (m UserModel) Insert(user *User) error {
 	if err != nil {
 		switch {
 		// if the table already contains a record with this email address, then when we try to perform the insert, there will a violation of the UNIQUE "users_email_key"
-		// constraint, we can check for this specific error message and return our custom ErrDuplicateEmail error
-		case err.Error() == EmailDuplicateKeyConstraint:
+		// constraint, we can check for a unique violation of this constraint and return our custom ErrDuplicateEmail error
+		case isUniqueViolation(err, "users_email_key"):
 			return ErrDuplicateEmail
 		default:
 			return err
@@ -152,16 +190,16 @@ func (m UserModel) Insert(user *User) error {
 // Retrieve the User details from the database based on the user's email address.
 // Because we have a UNIQUE constraint on the email column, , this SQL query will only return
 // one record (or none at all, in which case we return ErrRecordNotFound)
-func (m UserModel) GetByEmail(email string) (*User, error) {
+func (m UserModel) GetByEmail(ctx context.Context, email string) (*User, error) {
 	query := `
-		SELECT id, created_at, name, email, password_hash, activated, version
+		SELECT id, created_at, name, email, password_hash, activated, version, avatar_key, disabled_at
 		FROM users
 		WHERE email = $1
 	`
 
 	var user User
 
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	ctx, cancel := withReadTimeout(ctx)
 	defer cancel()
 
 	err := m.DB.QueryRowContext(ctx, query, email).Scan(
@@ -172,6 +210,8 @@ func (m UserModel) GetByEmail(email string) (*User, error) {
 		&user.Password.hash,
 		&user.Activated,
 		&user.Version,
+		&user.AvatarKey,
+		&user.DisabledAt,
 	)
 	if err != nil {
 		switch {
@@ -182,12 +222,104 @@ func (m UserModel) GetByEmail(email string) (*User, error) {
 		}
 	}
 
+	user.setAvatarURL()
+
 	return &user, nil
 }
 
+// Retrieve the User details from the database based on the user's ID, returning ErrRecordNotFound if there is no such user
+func (m UserModel) GetByID(ctx context.Context, id int64) (*User, error) {
+	if id < 1 {
+		return nil, ErrRecordNotFound
+	}
+
+	query := `
+		SELECT id, created_at, name, email, password_hash, activated, version, avatar_key, disabled_at
+		FROM users
+		WHERE id = $1
+	`
+
+	var user User
+
+	ctx, cancel := withReadTimeout(ctx)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, id).Scan(
+		&user.ID,
+		&user.CreatedAt,
+		&user.Name,
+		&user.Email,
+		&user.Password.hash,
+		&user.Activated,
+		&user.Version,
+		&user.AvatarKey,
+		&user.DisabledAt,
+	)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	user.setAvatarURL()
+
+	return &user, nil
+}
+
+// GetByIDs fetches the users with the given IDs with a single query, keyed by their ID. IDs which don't belong to a
+// user are left out of the map
+func (m UserModel) GetByIDs(ctx context.Context, ids ...int64) (map[int64]*User, error) {
+	query := `
+		SELECT id, created_at, name, email, password_hash, activated, version, avatar_key, disabled_at
+		FROM users
+		WHERE id = ANY($1)`
+
+	ctx, cancel := withReadTimeout(ctx)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, ids)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	users := make(map[int64]*User, len(ids))
+
+	for rows.Next() {
+		var user User
+
+		err := rows.Scan(
+			&user.ID,
+			&user.CreatedAt,
+			&user.Name,
+			&user.Email,
+			&user.Password.hash,
+			&user.Activated,
+			&user.Version,
+			&user.AvatarKey,
+			&user.DisabledAt,
+		)
+		if err != nil {
+			return nil, err
+		}
+
+		user.setAvatarURL()
+		users[user.ID] = &user
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return users, nil
+}
+
 // Update the details for a specific user. Notice that we check against the version field to help prevent any race conditions during the request cycle.
 // we also check for a violation of the UNIQUE "users_email_key" constraint and return our custom ErrDuplicateEmail error if this occurs
-func (m UserModel) Update(user *User) error {
+func (m UserModel) Update(ctx context.Context, user *User) error {
 	query := `
 		UPDATE users
 		SET name = $1, email = $2, password_hash = $3, activated = $4, version = version + 1
@@ -204,15 +336,15 @@ func (m UserModel) Update(user *User) error {
 		user.Version,
 	}
 
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	ctx, cancel := withWriteTimeout(ctx)
 	defer cancel()
 
 	err := m.DB.QueryRowContext(ctx, query, args...).Scan(&user.Version)
 	if err != nil {
 		switch {
 		// if the table already contains a record with this email address, then when we try to perform the insert, there will a violation of the UNIQUE "users_email_key"
-		// constraint, we can check for this specific error message and return our custom ErrDuplicateEmail error
-		case err.Error() == EmailDuplicateKeyConstraint:
+		// constraint, we can check for a unique violation of this constraint and return our custom ErrDuplicateEmail error
+		case isUniqueViolation(err, "users_email_key"):
 			return ErrDuplicateEmail
 		case errors.Is(err, sql.ErrNoRows):
 			return ErrEditConflict
@@ -224,29 +356,543 @@ func (m UserModel) Update(user *User) error {
 	return nil
 }
 
+// UpdatePassword saves the user's new password hash and deletes all of their authentication tokens in the same
+// transaction, so a session opened with the old password can't outlive the change. The signed tokens can't be deleted,
+// so the ones issued until now are revoked through tokens_valid_after instead. ErrEditConflict is returned if the user
+// has been updated since they were fetched
+func (m UserModel) UpdatePassword(ctx context.Context, user *User) error {
+	ctx, cancel := withWriteTimeout(ctx)
+	defer cancel()
+
+	tx, err := beginTx(ctx, m.DB)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	query := `
+		UPDATE users
+		SET password_hash = $1, tokens_valid_after = $4, version = version + 1
+		WHERE id = $2 AND version = $3
+		RETURNING version`
+
+	err = tx.QueryRowContext(ctx, query, user.Password.hash, user.ID, user.Version, m.tokensValidAfter()).Scan(&user.Version)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrEditConflict
+		default:
+			return err
+		}
+	}
+
+	_, err = tx.ExecContext(ctx, `DELETE FROM tokens WHERE user_id = $1 AND scope = $2`, user.ID, ScopeAuthentication)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// UpdatePasswordHash replaces the user's password hash with a new hash of the same password, made with the current
+// hashing algorithm and parameters. Unlike UpdatePassword the user's sessions are left alone, since their password hasn't
+// changed. ErrEditConflict is returned if the user has been updated since they were fetched
+func (m UserModel) UpdatePasswordHash(ctx context.Context, user *User) error {
+	query := `
+		UPDATE users
+		SET password_hash = $1, version = version + 1
+		WHERE id = $2 AND version = $3
+		RETURNING version`
+
+	ctx, cancel := withWriteTimeout(ctx)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, user.Password.hash, user.ID, user.Version).Scan(&user.Version)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrEditConflict
+		default:
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Disable deprovisions the user, locking them out until they are enabled again. All of their tokens are deleted and their
+// signed tokens revoked in the same transaction, so they are signed out everywhere, and they can't sign in or activate
+// their account while they are disabled. ErrRecordNotFound is returned if the user doesn't exist
+func (m UserModel) Disable(ctx context.Context, user *User) error {
+	ctx, cancel := withWriteTimeout(ctx)
+	defer cancel()
+
+	tx, err := beginTx(ctx, m.DB)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	// a user who is already disabled keeps the time they were first disabled at
+	query := `
+		UPDATE users
+		SET disabled_at = COALESCE(disabled_at, $2), tokens_valid_after = $2, version = version + 1
+		WHERE id = $1
+		RETURNING disabled_at, version`
+
+	err = tx.QueryRowContext(ctx, query, user.ID, m.tokensValidAfter()).Scan(&user.DisabledAt, &user.Version)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrRecordNotFound
+		default:
+			return err
+		}
+	}
+
+	_, err = tx.ExecContext(ctx, `DELETE FROM tokens WHERE user_id = $1`, user.ID)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// Enable lets a disabled user sign in again. ErrRecordNotFound is returned if the user doesn't exist
+func (m UserModel) Enable(ctx context.Context, user *User) error {
+	query := `
+		UPDATE users
+		SET disabled_at = NULL, version = version + 1
+		WHERE id = $1
+		RETURNING version`
+
+	ctx, cancel := withWriteTimeout(ctx)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, user.ID).Scan(&user.Version)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrRecordNotFound
+		default:
+			return err
+		}
+	}
+
+	user.DisabledAt = nil
+
+	return nil
+}
+
+// SetPendingEmail records the address the user wants to change their email to, replacing any change they had already
+// started. The address only becomes the user's email once ConfirmEmail is called
+func (m UserModel) SetPendingEmail(ctx context.Context, userID int64, email string) error {
+	query := `
+		INSERT INTO email_changes (user_id, email, created_at)
+		VALUES ($1, $2, $3)
+		ON CONFLICT (user_id) DO UPDATE SET email = EXCLUDED.email, created_at = EXCLUDED.created_at`
+
+	ctx, cancel := withWriteTimeout(ctx)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, query, userID, email, m.Clock.Now())
+	return err
+}
+
+// GetPendingEmail returns the address the user has asked to change their email to, or ErrRecordNotFound if they
+// haven't started a change
+func (m UserModel) GetPendingEmail(ctx context.Context, userID int64) (string, error) {
+	query := `
+		SELECT email
+		FROM email_changes
+		WHERE user_id = $1`
+
+	ctx, cancel := withReadTimeout(ctx)
+	defer cancel()
+
+	var email string
+
+	err := m.DB.QueryRowContext(ctx, query, userID).Scan(&email)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return "", ErrRecordNotFound
+		default:
+			return "", err
+		}
+	}
+
+	return email, nil
+}
+
+// ConfirmEmail replaces the user's email with their pending address, and removes the pending change and its tokens in
+// the same transaction. ErrDuplicateEmail is returned if somebody else has taken the address in the meantime, and
+// ErrEditConflict if the user has been updated since they were fetched
+func (m UserModel) ConfirmEmail(ctx context.Context, user *User, email string) error {
+	ctx, cancel := withWriteTimeout(ctx)
+	defer cancel()
+
+	tx, err := beginTx(ctx, m.DB)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	query := `
+		UPDATE users
+		SET email = $1, version = version + 1
+		WHERE id = $2 AND version = $3
+		RETURNING version`
+
+	err = tx.QueryRowContext(ctx, query, email, user.ID, user.Version).Scan(&user.Version)
+	if err != nil {
+		switch {
+		case isUniqueViolation(err, "users_email_key"):
+			return ErrDuplicateEmail
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrEditConflict
+		default:
+			return err
+		}
+	}
+
+	_, err = tx.ExecContext(ctx, `DELETE FROM email_changes WHERE user_id = $1`, user.ID)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.ExecContext(ctx, `DELETE FROM tokens WHERE user_id = $1 AND scope = $2`, user.ID, ScopeEmailChange)
+	if err != nil {
+		return err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return err
+	}
+
+	user.Email = email
+
+	return nil
+}
+
+// GetAll returns a page of the users for the admin endpoints. The email filter matches any part of the address, ignoring
+// case, and the activated filter is skipped when it is nil
+func (m UserModel) GetAll(ctx context.Context, email string, activated *bool, filters Filters) ([]*User, Metadata, error) {
+	sortColumn, err := filters.sortColumn()
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+
+	// strpos is used rather than LIKE, so characters such as % and _ in the filter are matched literally
+	query := fmt.Sprintf(`
+		SELECT count(*) OVER(), id, created_at, name, email, password_hash, activated, version, avatar_key, disabled_at
+		FROM users
+		WHERE (strpos(lower(email), lower($1)) > 0 OR $1 = '')
+		AND (activated = $2 OR $2 IS NULL)
+		ORDER BY %s %s, id ASC
+		LIMIT $3 OFFSET $4`, sortColumn, filters.sortDirection())
+
+	args := []interface{}{email, activated, filters.limit(), filters.offset()}
+
+	ctx, cancel := withReadTimeout(ctx)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+	defer rows.Close()
+
+	totalRecords := 0
+	users := []*User{}
+
+	for rows.Next() {
+		var user User
+
+		err := rows.Scan(
+			&totalRecords,
+			&user.ID,
+			&user.CreatedAt,
+			&user.Name,
+			&user.Email,
+			&user.Password.hash,
+			&user.Activated,
+			&user.Version,
+			&user.AvatarKey,
+			&user.DisabledAt,
+		)
+		if err != nil {
+			return nil, Metadata{}, err
+		}
+
+		user.setAvatarURL()
+		users = append(users, &user)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, Metadata{}, err
+	}
+
+	metadata := calculateMetadata(totalRecords, filters.Page, filters.PageSize)
+
+	return users, metadata, nil
+}
+
+// Delete removes the user. Their tokens, permissions and everything else which belongs to them are removed with them by
+// the ON DELETE CASCADE foreign keys, apart from their reviews which are kept without the user
+func (m UserModel) Delete(ctx context.Context, id int64) error {
+	if id < 1 {
+		return ErrRecordNotFound
+	}
+
+	query := `
+		DELETE FROM users
+		WHERE id = $1`
+
+	ctx, cancel := withWriteTimeout(ctx)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, query, id)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+
+	return nil
+}
+
+// ScheduleDeletion marks the user's account to be deleted at the given time and deletes all of their tokens in the same
+// transaction, revoking their signed tokens too, so they are signed out everywhere. ErrRecordNotFound is returned if
+// the user doesn't exist
+func (m UserModel) ScheduleDeletion(ctx context.Context, userID int64, dueAt time.Time) error {
+	ctx, cancel := withWriteTimeout(ctx)
+	defer cancel()
+
+	tx, err := beginTx(ctx, m.DB)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	query := `
+		UPDATE users
+		SET deletion_due_at = $1, tokens_valid_after = $3, version = version + 1
+		WHERE id = $2`
+
+	result, err := tx.ExecContext(ctx, query, dueAt, userID, m.tokensValidAfter())
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+
+	_, err = tx.ExecContext(ctx, `DELETE FROM tokens WHERE user_id = $1`, userID)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// CancelDeletion clears the scheduled deletion of the user's account. ErrRecordNotFound is returned if the account
+// isn't due to be deleted
+func (m UserModel) CancelDeletion(ctx context.Context, userID int64) error {
+	query := `
+		UPDATE users
+		SET deletion_due_at = NULL, version = version + 1
+		WHERE id = $1 AND deletion_due_at IS NOT NULL`
+
+	ctx, cancel := withWriteTimeout(ctx)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, query, userID)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+
+	return nil
+}
+
+// DeleteDue permanently deletes the accounts whose scheduled deletion time has passed, in the same way as Delete. It
+// returns the avatar keys of the deleted accounts which had one, so the avatars can be removed from storage, along with
+// how many accounts were deleted
+func (m UserModel) DeleteDue(ctx context.Context) ([]string, int64, error) {
+	query := `
+		DELETE FROM users
+		WHERE deletion_due_at <= $1
+		RETURNING avatar_key`
+
+	ctx, cancel := withWriteTimeout(ctx)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, m.Clock.Now())
+	if err != nil {
+		return nil, 0, err
+	}
+	defer rows.Close()
+
+	var avatarKeys []string
+	var deleted int64
+
+	for rows.Next() {
+		var key string
+
+		err := rows.Scan(&key)
+		if err != nil {
+			return nil, 0, err
+		}
+
+		deleted++
+		if key != "" {
+			avatarKeys = append(avatarKeys, key)
+		}
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, 0, err
+	}
+
+	return avatarKeys, deleted, nil
+}
+
+// UpdateAvatar sets the avatar key of the user. Like Update, it fails with ErrEditConflict if the user has been changed
+// since they were read, so two concurrent uploads can't both think their avatar was the one kept
+func (m UserModel) UpdateAvatar(ctx context.Context, user *User) error {
+	query := `
+		UPDATE users
+		SET avatar_key = $1, version = version + 1
+		WHERE id = $2 AND version = $3
+		RETURNING version`
+
+	ctx, cancel := withWriteTimeout(ctx)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, user.AvatarKey, user.ID, user.Version).Scan(&user.Version)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrEditConflict
+		default:
+			return err
+		}
+	}
+
+	user.setAvatarURL()
+
+	return nil
+}
+
+// tokensValidAfter returns the time the signed tokens issued until now are revoked from. It is truncated to the
+// second, as the issued at time of a token is, so a token issued just after the revocation isn't refused with them
+func (m UserModel) tokensValidAfter() time.Time {
+	return m.Clock.Now().Truncate(time.Second)
+}
+
+// GetTokensValidAfter returns the time the user's signed authentication tokens must have been issued at or after to be
+// accepted, which is the zero time if they have never been revoked. ErrRecordNotFound is returned if the user doesn't
+// exist, such as when their account has been deleted since the token was issued, or has been disabled
+func (m UserModel) GetTokensValidAfter(ctx context.Context, userID int64) (time.Time, error) {
+	query := `
+		SELECT tokens_valid_after
+		FROM users
+		WHERE id = $1 AND disabled_at IS NULL`
+
+	var validAfter sql.NullTime
+
+	ctx, cancel := withReadTimeout(ctx)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, userID).Scan(&validAfter)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return time.Time{}, ErrRecordNotFound
+		default:
+			return time.Time{}, err
+		}
+	}
+
+	return validAfter.Time, nil
+}
+
+// RevokeSignedTokens revokes every signed authentication token issued to the user until now, for when they sign out on
+// all of their devices. ErrRecordNotFound is returned if the user doesn't exist
+func (m UserModel) RevokeSignedTokens(ctx context.Context, userID int64) error {
+	query := `
+		UPDATE users
+		SET tokens_valid_after = $2
+		WHERE id = $1`
+
+	ctx, cancel := withWriteTimeout(ctx)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, query, userID, m.tokensValidAfter())
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+
+	return nil
+}
+
 // This method will retrieve the user details based on the token hash, scope,
-// It will return the user details if a matching record is found, or an error if no matching record is found
-func (m UserModel) GetTokenUser(tokenScope, tokenPlaintext string) (*User, error) {
+// It will return the user details if a matching record is found, or an error if no matching record is found.
+// The tokens of a disabled user are never matched, whatever their scope
+func (m UserModel) GetTokenUser(ctx context.Context, tokenScope, tokenPlaintext string) (*User, error) {
 	// hash the plaintext token using the SHA-256 algorithm, returning a 32-byte array
 	tokenHash := sha256.Sum256([]byte(tokenPlaintext))
 
 	// query to retrieve the user details based on the token hash, scope and expiry time
 	query := `
-		SELECT users.id, users.created_at, users.name, users.email, users.password_hash, users.activated, users.version
+		SELECT users.id, users.created_at, users.name, users.email, users.password_hash, users.activated, users.version, users.avatar_key
 		FROM users
 		INNER JOIN tokens
 		ON users.id = tokens.user_id
 		WHERE tokens.hash = $1
 		AND tokens.scope = $2
-		AND tokens.expiry > $3`
+		AND tokens.expiry > $3
+		AND users.disabled_at IS NULL`
 
 	// create a slice containing the query arguments. The token hash is converted to a byte slice using the [:] operator
-	// because the pq driver expects a byte slice. we pass the current time against the token expiry time to check if the token is still valid
-	args := []interface{}{tokenHash[:], tokenScope, time.Now()}
+	// because the driver expects a byte slice. we pass the current time against the token expiry time to check if the token is still valid
+	args := []interface{}{tokenHash[:], tokenScope, m.Clock.Now()}
 
 	var user User
 
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	ctx, cancel := withReadTimeout(ctx)
 	defer cancel()
 
 	// execute the query and scan the returned values into the user struct, returning ErrRecordNotFound if no matching record is found
@@ -258,6 +904,7 @@ func (m UserModel) GetTokenUser(tokenScope, tokenPlaintext string) (*User, error
 		&user.Password.hash,
 		&user.Activated,
 		&user.Version,
+		&user.AvatarKey,
 	)
 
 	if err != nil {
@@ -270,24 +917,94 @@ func (m UserModel) GetTokenUser(tokenScope, tokenPlaintext string) (*User, error
 	}
 
 	// return matching user
+	user.setAvatarURL()
+
 	return &user, nil
 }
 
 // Mock data for testing
 type MockUserModel struct{}
 
-func (m MockUserModel) Insert(user *User) error {
+func (m MockUserModel) Insert(ctx context.Context, user *User) error {
 	return nil
 }
 
-func (m MockUserModel) GetByEmail(email string) (*User, error) {
+func (m MockUserModel) GetByEmail(ctx context.Context, email string) (*User, error) {
+	return nil, nil
+}
+
+func (m MockUserModel) GetByID(ctx context.Context, id int64) (*User, error) {
 	return nil, nil
 }
 
-func (m MockUserModel) Update(user *User) error {
+func (m MockUserModel) GetByIDs(ctx context.Context, ids ...int64) (map[int64]*User, error) {
+	return map[int64]*User{}, nil
+}
+
+func (m MockUserModel) Update(ctx context.Context, user *User) error {
+	return nil
+}
+
+func (m MockUserModel) UpdatePassword(ctx context.Context, user *User) error {
+	return nil
+}
+
+func (m MockUserModel) UpdatePasswordHash(ctx context.Context, user *User) error {
+	return nil
+}
+
+func (m MockUserModel) SetPendingEmail(ctx context.Context, userID int64, email string) error {
+	return nil
+}
+
+func (m MockUserModel) GetPendingEmail(ctx context.Context, userID int64) (string, error) {
+	return "", nil
+}
+
+func (m MockUserModel) ConfirmEmail(ctx context.Context, user *User, email string) error {
+	return nil
+}
+
+func (m MockUserModel) GetAll(ctx context.Context, email string, activated *bool, filters Filters) ([]*User, Metadata, error) {
+	return nil, Metadata{}, nil
+}
+
+func (m MockUserModel) Delete(ctx context.Context, id int64) error {
+	return nil
+}
+
+func (m MockUserModel) ScheduleDeletion(ctx context.Context, userID int64, dueAt time.Time) error {
+	return nil
+}
+
+func (m MockUserModel) CancelDeletion(ctx context.Context, userID int64) error {
+	return nil
+}
+
+func (m MockUserModel) DeleteDue(ctx context.Context) ([]string, int64, error) {
+	return nil, 0, nil
+}
+
+func (m MockUserModel) UpdateAvatar(ctx context.Context, user *User) error {
+	return nil
+}
+
+func (m MockUserModel) Disable(ctx context.Context, user *User) error {
+	return nil
+}
+
+func (m MockUserModel) Enable(ctx context.Context, user *User) error {
+	return nil
+}
+
+func (m MockUserModel) GetTokensValidAfter(ctx context.Context, userID int64) (time.Time, error) {
+	return time.Time{}, nil
+}
+
+func (m MockUserModel) RevokeSignedTokens(ctx context.Context, userID int64) error {
 	return nil
 }
 
-func (m MockUserModel) GetTokenUser(tokenScope, tokenPlaintext string) (*User, error) {
+func (m MockUserModel) GetTokenUser(ctx context.Context, tokenScope, tokenPlaintext string) (*User, error) {
 	return nil, nil
 }
@@ -0,0 +1,146 @@
+package data
+
+import (
+	"context"
+	"time"
+)
+
+// TrendingMovie is a movie along with the number of times its details were viewed in the trending window
+type TrendingMovie struct {
+	*Movie
+	Views int64 `json:"views"`
+}
+
+// ViewModel counts the views of the movie details in hourly buckets, a row per movie and hour, so the trending movies
+// can be ranked over any window of whole hours
+type ViewModel struct {
+	DB     DBTX
+	Reader DBTX // runs the read-only queries, on the read replica if one is configured
+}
+
+// Add adds the view counts, keyed by movie ID, to the bucket of the hour the time is in. The counts of the movies which
+// have been deleted since they were viewed are dropped
+func (m ViewModel) Add(ctx context.Context, counts map[int64]int64, at time.Time) error {
+	if len(counts) == 0 {
+		return nil
+	}
+
+	ids := make([]int64, 0, len(counts))
+	views := make([]int64, 0, len(counts))
+
+	for id, count := range counts {
+		ids = append(ids, id)
+		views = append(views, count)
+	}
+
+	query := `
+		INSERT INTO movie_views (movie_id, hour, views)
+		SELECT counts.movie_id, $3, counts.views
+		FROM unnest($1::bigint[], $2::bigint[]) AS counts(movie_id, views)
+		INNER JOIN movies ON movies.id = counts.movie_id
+		ON CONFLICT (movie_id, hour) DO UPDATE
+		SET views = movie_views.views + EXCLUDED.views`
+
+	ctx, cancel := withWriteTimeout(ctx)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, query, ids, views, at.UTC().Truncate(time.Hour))
+	return err
+}
+
+// Trending returns a page of the movies with the most views since the given time, most viewed first
+func (m ViewModel) Trending(ctx context.Context, since time.Time, filters Filters) ([]*TrendingMovie, Metadata, error) {
+	query := `
+		SELECT count(*) OVER(), movies.id, movies.created_at, movies.updated_at, title, year, runtime, genres, movies.version, poster_key,
+		average_rating, review_count, language, country,
+		counts.views
+		FROM (
+			SELECT movie_id, sum(views) AS views
+			FROM movie_views
+			WHERE hour >= $1
+			GROUP BY movie_id
+		) AS counts
+		INNER JOIN movies ON movies.id = counts.movie_id
+		ORDER BY counts.views DESC, movies.id ASC
+		LIMIT $2 OFFSET $3`
+
+	ctx, cancel := withReadTimeout(ctx)
+	defer cancel()
+
+	rows, err := m.Reader.QueryContext(ctx, query, since.UTC().Truncate(time.Hour), filters.limit(), filters.offset())
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+	defer rows.Close()
+
+	totalRecords := 0
+	movies := []*TrendingMovie{}
+
+	for rows.Next() {
+		movie := TrendingMovie{Movie: &Movie{}}
+
+		err := rows.Scan(
+			&totalRecords,
+			&movie.ID,
+			&movie.CreatedAt,
+			&movie.UpdatedAt,
+			&movie.Title,
+			&movie.Year,
+			&movie.Runtime,
+			scanArray(&movie.Genres),
+			&movie.Version,
+			&movie.PosterKey,
+			&movie.AverageRating,
+			&movie.ReviewCount,
+			&movie.Language,
+			&movie.Country,
+			&movie.Views,
+		)
+		if err != nil {
+			return nil, Metadata{}, err
+		}
+
+		movie.setPosterURL()
+		movies = append(movies, &movie)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, Metadata{}, err
+	}
+
+	metadata := calculateMetadata(totalRecords, filters.Page, filters.PageSize)
+
+	return movies, metadata, nil
+}
+
+// DeleteBefore deletes the view counts of the hours before the time, returning how many buckets were deleted. It is
+// run by a job without a request, so it has its own timeout, long enough for a big backlog
+func (m ViewModel) DeleteBefore(before time.Time) (int64, error) {
+	query := `
+		DELETE FROM movie_views
+		WHERE hour < $1`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, query, before)
+	if err != nil {
+		return 0, err
+	}
+
+	return result.RowsAffected()
+}
+
+type MockViewModel struct{}
+
+func (m MockViewModel) Add(ctx context.Context, counts map[int64]int64, at time.Time) error {
+	return nil
+}
+
+func (m MockViewModel) Trending(ctx context.Context, since time.Time, filters Filters) ([]*TrendingMovie, Metadata, error) {
+	return []*TrendingMovie{}, Metadata{}, nil
+}
+
+func (m MockViewModel) DeleteBefore(before time.Time) (int64, error) {
+	return 0, nil
+}
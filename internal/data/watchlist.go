@@ -0,0 +1,141 @@
+package data
+
+import (
+	"context"
+	"fmt"
+)
+
+type WatchlistModel struct {
+	DB DBTX
+}
+
+// Add puts a movie on the user's watchlist. Adding a movie which is already on the watchlist does nothing, and
+// ErrRecordNotFound is returned if the movie doesn't exist
+func (m WatchlistModel) Add(userID, movieID int64) error {
+	query := `
+		INSERT INTO watchlist (user_id, movie_id)
+		VALUES ($1, $2)
+		ON CONFLICT DO NOTHING`
+
+	ctx, cancel := withWriteTimeout(context.Background())
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, query, userID, movieID)
+	if err != nil {
+		switch {
+		case isForeignKeyViolation(err, "watchlist_movie_id_fkey"):
+			return ErrRecordNotFound
+		default:
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Remove takes a movie off the user's watchlist, returning ErrRecordNotFound if it wasn't on it
+func (m WatchlistModel) Remove(userID, movieID int64) error {
+	query := `
+		DELETE FROM watchlist
+		WHERE user_id = $1 AND movie_id = $2`
+
+	ctx, cancel := withWriteTimeout(context.Background())
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, query, userID, movieID)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+
+	return nil
+}
+
+// GetAll returns a page of the movies on the user's watchlist. As well as the movie columns, the results can be sorted
+// by added_at, the time the movie was put on the watchlist
+func (m WatchlistModel) GetAll(userID int64, filters Filters) ([]*Movie, Metadata, error) {
+	sortColumn, err := filters.sortColumn()
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+
+	query := fmt.Sprintf(`
+		SELECT count(*) OVER(), movies.id, movies.created_at, movies.updated_at, title, year, runtime, genres, movies.version, poster_key,
+		average_rating, review_count, language, country
+		FROM movies
+		INNER JOIN watchlist ON watchlist.movie_id = movies.id
+		WHERE watchlist.user_id = $1
+		ORDER BY %s %s, movies.id ASC
+		LIMIT $2 OFFSET $3`, sortColumn, filters.sortDirection())
+
+	ctx, cancel := withReadTimeout(context.Background())
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, userID, filters.limit(), filters.offset())
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+	defer rows.Close()
+
+	totalRecords := 0
+	movies := []*Movie{}
+
+	for rows.Next() {
+		var movie Movie
+
+		err := rows.Scan(
+			&totalRecords,
+			&movie.ID,
+			&movie.CreatedAt,
+			&movie.UpdatedAt,
+			&movie.Title,
+			&movie.Year,
+			&movie.Runtime,
+			scanArray(&movie.Genres),
+			&movie.Version,
+			&movie.PosterKey,
+			&movie.AverageRating,
+			&movie.ReviewCount,
+			&movie.Language,
+			&movie.Country,
+		)
+		if err != nil {
+			return nil, Metadata{}, err
+		}
+
+		movie.setPosterURL()
+
+		movies = append(movies, &movie)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, Metadata{}, err
+	}
+
+	metadata := calculateMetadata(totalRecords, filters.Page, filters.PageSize)
+
+	return movies, metadata, nil
+}
+
+// Mock data for testing
+type MockWatchlistModel struct{}
+
+func (m MockWatchlistModel) Add(userID, movieID int64) error {
+	return nil
+}
+
+func (m MockWatchlistModel) Remove(userID, movieID int64) error {
+	return nil
+}
+
+func (m MockWatchlistModel) GetAll(userID int64, filters Filters) ([]*Movie, Metadata, error) {
+	return nil, Metadata{}, nil
+}
@@ -0,0 +1,388 @@
+package data
+
+import (
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/url"
+	"time"
+
+	"github.com/nytro04/greenlight/internal/clock"
+	"github.com/nytro04/greenlight/internal/validator"
+)
+
+// The events a webhook can subscribe to
+const (
+	WebhookEventMovieCreated = "movie.created"
+	WebhookEventMovieUpdated = "movie.updated"
+	WebhookEventMovieDeleted = "movie.deleted"
+)
+
+var WebhookEvents = []string{WebhookEventMovieCreated, WebhookEventMovieUpdated, WebhookEventMovieDeleted}
+
+// The states of a webhook delivery. A delivery is pending until it succeeds or runs out of attempts
+const (
+	WebhookDeliveryPending   = "pending"
+	WebhookDeliveryDelivered = "delivered"
+	WebhookDeliveryFailed    = "failed"
+)
+
+// WebhookSecretPrefix starts every webhook signing secret, so leaked secrets are easy to recognise
+const WebhookSecretPrefix = "whsec_"
+
+// Webhook is a URL registered by an admin to be sent the events it subscribes to
+type Webhook struct {
+	ID        int64     `json:"id"`
+	CreatedAt time.Time `json:"created_at"`
+	URL       string    `json:"url"`
+	Events    []string  `json:"events"`
+	Secret    string    `json:"secret,omitempty"` // only included when the webhook is registered
+}
+
+// WebhookDelivery is a single event sent, or to be sent, to a webhook. The URL and secret of the webhook are only
+// loaded when the delivery is claimed by the dispatcher
+type WebhookDelivery struct {
+	ID             int64           `json:"id"`
+	CreatedAt      time.Time       `json:"created_at"`
+	WebhookID      int64           `json:"webhook_id"`
+	Event          string          `json:"event"`
+	Payload        json.RawMessage `json:"payload"`
+	Status         string          `json:"status"`
+	Attempts       int             `json:"attempts"`
+	NextAttemptAt  time.Time       `json:"next_attempt_at"`
+	ResponseStatus *int            `json:"response_status"`
+	LastError      string          `json:"last_error"`
+	DeliveredAt    *time.Time      `json:"delivered_at"`
+	URL            string          `json:"-"`
+	Secret         string          `json:"-"`
+}
+
+func ValidateWebhook(v *validator.Validator, webhook *Webhook) {
+	v.Check(webhook.URL != "", "url", "must be provided")
+	v.Check(len(webhook.URL) <= 2000, "url", "must not be more than 2000 bytes long")
+
+	u, err := url.Parse(webhook.URL)
+	v.Check(err == nil && u.Host != "" && validator.In(u.Scheme, "http", "https"), "url", "must be an absolute http or https URL")
+
+	v.Check(len(webhook.Events) > 0, "events", "must contain at least 1 event")
+	v.Check(validator.Unique(webhook.Events), "events", "must not contain duplicate values")
+
+	for _, event := range webhook.Events {
+		v.Check(validator.In(event, WebhookEvents...), "events", fmt.Sprintf("must only contain %s, %s or %s", WebhookEvents[0], WebhookEvents[1], WebhookEvents[2]))
+	}
+}
+
+type WebhookModel struct {
+	DB     DBTX
+	Clock  clock.Clock
+	Random io.Reader
+}
+
+// Insert generates the signing secret for the webhook and stores it. The secret is kept in plaintext, because it is
+// needed to sign every payload, but it is only handed out in the response to the registration
+func (m WebhookModel) Insert(webhook *Webhook) error {
+	randomBytes := make([]byte, 24)
+
+	_, err := io.ReadFull(m.Random, randomBytes)
+	if err != nil {
+		return err
+	}
+
+	webhook.Secret = WebhookSecretPrefix + hex.EncodeToString(randomBytes)
+
+	query := `
+		INSERT INTO webhooks (url, events, secret)
+		VALUES ($1, $2, $3)
+		RETURNING id, created_at`
+
+	ctx, cancel := withWriteTimeout(context.Background())
+	defer cancel()
+
+	return m.DB.QueryRowContext(ctx, query, webhook.URL, webhook.Events, webhook.Secret).Scan(&webhook.ID, &webhook.CreatedAt)
+}
+
+// GetAll returns the registered webhooks, newest first, without their secrets
+func (m WebhookModel) GetAll() ([]*Webhook, error) {
+	query := `
+		SELECT id, created_at, url, events
+		FROM webhooks
+		ORDER BY id DESC`
+
+	ctx, cancel := withReadTimeout(context.Background())
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	webhooks := []*Webhook{}
+
+	for rows.Next() {
+		var webhook Webhook
+
+		err := rows.Scan(&webhook.ID, &webhook.CreatedAt, &webhook.URL, scanArray(&webhook.Events))
+		if err != nil {
+			return nil, err
+		}
+
+		webhooks = append(webhooks, &webhook)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return webhooks, nil
+}
+
+// Delete removes the webhook, along with its delivery log
+func (m WebhookModel) Delete(id int64) error {
+	if id < 1 {
+		return ErrRecordNotFound
+	}
+
+	query := `
+		DELETE FROM webhooks
+		WHERE id = $1`
+
+	ctx, cancel := withWriteTimeout(context.Background())
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, query, id)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+
+	return nil
+}
+
+// Enqueue adds a pending delivery of each payload to every webhook subscribed to the event, in a single statement,
+// and returns the number of deliveries added. The payloads must be JSON documents
+func (m WebhookModel) Enqueue(event string, payloads ...[]byte) (int64, error) {
+	if len(payloads) == 0 {
+		return 0, nil
+	}
+
+	// the payloads are passed as a text array and cast to jsonb, so they are sent as JSON text rather than bytea
+	documents := make([]string, len(payloads))
+	for i, payload := range payloads {
+		documents[i] = string(payload)
+	}
+
+	query := `
+		INSERT INTO webhook_deliveries (webhook_id, event, payload)
+		SELECT webhooks.id, $1, payload
+		FROM webhooks, unnest($2::jsonb[]) AS payload
+		WHERE $1 = ANY(webhooks.events)`
+
+	ctx, cancel := withWriteTimeout(context.Background())
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, query, event, documents)
+	if err != nil {
+		return 0, err
+	}
+
+	return result.RowsAffected()
+}
+
+// ClaimDue picks up to limit pending deliveries which are due and pushes their next attempt back by the lease, so
+// another dispatcher won't pick them up while they are being sent. The attempt is counted when it is claimed, so a
+// dispatcher which dies part way through still uses up one of the attempts
+func (m WebhookModel) ClaimDue(limit int, lease time.Duration) ([]*WebhookDelivery, error) {
+	now := m.Clock.Now()
+
+	query := `
+		UPDATE webhook_deliveries
+		SET attempts = webhook_deliveries.attempts + 1, next_attempt_at = $2
+		FROM webhooks
+		WHERE webhooks.id = webhook_deliveries.webhook_id
+		AND webhook_deliveries.id IN (
+			SELECT id FROM webhook_deliveries
+			WHERE status = 'pending' AND next_attempt_at <= $1
+			ORDER BY next_attempt_at
+			LIMIT $3
+			FOR UPDATE SKIP LOCKED
+		)
+		RETURNING webhook_deliveries.id, webhook_deliveries.created_at, webhook_deliveries.webhook_id, webhook_deliveries.event,
+		webhook_deliveries.payload, webhook_deliveries.status, webhook_deliveries.attempts, webhook_deliveries.next_attempt_at,
+		webhooks.url, webhooks.secret`
+
+	ctx, cancel := withWriteTimeout(context.Background())
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, now, now.Add(lease), limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	deliveries := []*WebhookDelivery{}
+
+	for rows.Next() {
+		var delivery WebhookDelivery
+
+		err := rows.Scan(
+			&delivery.ID,
+			&delivery.CreatedAt,
+			&delivery.WebhookID,
+			&delivery.Event,
+			&delivery.Payload,
+			&delivery.Status,
+			&delivery.Attempts,
+			&delivery.NextAttemptAt,
+			&delivery.URL,
+			&delivery.Secret,
+		)
+		if err != nil {
+			return nil, err
+		}
+
+		deliveries = append(deliveries, &delivery)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return deliveries, nil
+}
+
+// MarkDelivered records that the receiver accepted the delivery
+func (m WebhookModel) MarkDelivered(id int64, responseStatus int) error {
+	query := `
+		UPDATE webhook_deliveries
+		SET status = 'delivered', response_status = $2, last_error = '', delivered_at = $3
+		WHERE id = $1`
+
+	ctx, cancel := withWriteTimeout(context.Background())
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, query, id, responseStatus, m.Clock.Now())
+	return err
+}
+
+// MarkFailed records a failed attempt. The delivery is retried at nextAttemptAt, or given up on when it is nil.
+// responseStatus is nil when the receiver couldn't be reached at all
+func (m WebhookModel) MarkFailed(id int64, responseStatus *int, lastError string, nextAttemptAt *time.Time) error {
+	query := `
+		UPDATE webhook_deliveries
+		SET status = CASE WHEN $4::timestamptz IS NULL THEN 'failed' ELSE 'pending' END,
+		response_status = $2, last_error = $3, next_attempt_at = COALESCE($4, next_attempt_at)
+		WHERE id = $1`
+
+	ctx, cancel := withWriteTimeout(context.Background())
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, query, id, responseStatus, lastError, nextAttemptAt)
+	return err
+}
+
+// GetDeliveries returns the delivery log of a webhook, optionally only the deliveries with the given status
+func (m WebhookModel) GetDeliveries(webhookID int64, status string, filters Filters) ([]*WebhookDelivery, Metadata, error) {
+	sortColumn, err := filters.sortColumn()
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+
+	query := fmt.Sprintf(`
+		SELECT count(*) OVER(), id, created_at, webhook_id, event, payload, status, attempts, next_attempt_at,
+		response_status, last_error, delivered_at
+		FROM webhook_deliveries
+		WHERE webhook_id = $1
+		AND (status = $2 OR $2 = '')
+		ORDER BY %s %s, id DESC
+		LIMIT $3 OFFSET $4`, sortColumn, filters.sortDirection())
+
+	ctx, cancel := withReadTimeout(context.Background())
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, webhookID, status, filters.limit(), filters.offset())
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+	defer rows.Close()
+
+	totalRecords := 0
+	deliveries := []*WebhookDelivery{}
+
+	for rows.Next() {
+		var delivery WebhookDelivery
+
+		err := rows.Scan(
+			&totalRecords,
+			&delivery.ID,
+			&delivery.CreatedAt,
+			&delivery.WebhookID,
+			&delivery.Event,
+			&delivery.Payload,
+			&delivery.Status,
+			&delivery.Attempts,
+			&delivery.NextAttemptAt,
+			&delivery.ResponseStatus,
+			&delivery.LastError,
+			&delivery.DeliveredAt,
+		)
+		if err != nil {
+			return nil, Metadata{}, err
+		}
+
+		deliveries = append(deliveries, &delivery)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, Metadata{}, err
+	}
+
+	metadata := calculateMetadata(totalRecords, filters.Page, filters.PageSize)
+
+	return deliveries, metadata, nil
+}
+
+// Mock data for testing
+type MockWebhookModel struct{}
+
+func (m MockWebhookModel) Insert(webhook *Webhook) error {
+	return nil
+}
+
+func (m MockWebhookModel) GetAll() ([]*Webhook, error) {
+	return nil, nil
+}
+
+func (m MockWebhookModel) Delete(id int64) error {
+	return nil
+}
+
+func (m MockWebhookModel) Enqueue(event string, payloads ...[]byte) (int64, error) {
+	return 0, nil
+}
+
+func (m MockWebhookModel) ClaimDue(limit int, lease time.Duration) ([]*WebhookDelivery, error) {
+	return nil, nil
+}
+
+func (m MockWebhookModel) MarkDelivered(id int64, responseStatus int) error {
+	return nil
+}
+
+func (m MockWebhookModel) MarkFailed(id int64, responseStatus *int, lastError string, nextAttemptAt *time.Time) error {
+	return nil
+}
+
+func (m MockWebhookModel) GetDeliveries(webhookID int64, status string, filters Filters) ([]*WebhookDelivery, Metadata, error) {
+	return nil, Metadata{}, nil
+}
@@ -0,0 +1,100 @@
+package errtrack
+
+import (
+	"strconv"
+	"time"
+
+	"github.com/getsentry/sentry-go"
+)
+
+// Event is an unexpected error, along with the request it happened in so it can be found in the logs and traces
+type Event struct {
+	Err       error
+	Panic     bool   // the error was recovered from a panic
+	RequestID string // the X-Request-ID of the request
+	UserID    int64  // the authenticated user, zero for an anonymous request
+	Route     string // the route pattern the request matched, such as /v1/movies/:id
+	Method    string
+	URL       string
+}
+
+// Config holds the settings of the error tracker. The DSN is the one given by Sentry, or any tracker which accepts
+// the Sentry protocol such as GlitchTip
+type Config struct {
+	DSN         string
+	Environment string
+	Release     string
+}
+
+// Reporter is implemented by the types which send unexpected errors to an error tracker. The events are sent in the
+// background, Flush waits for the ones which haven't been sent yet and is called before the application exits
+type Reporter interface {
+	Report(event Event)
+	Flush(timeout time.Duration) bool
+}
+
+// New returns the Reporter for the configuration. Errors are only reported when a DSN is set
+func New(cfg Config) (Reporter, error) {
+	if cfg.DSN == "" {
+		return NoopReporter{}, nil
+	}
+
+	client, err := sentry.NewClient(sentry.ClientOptions{
+		Dsn:         cfg.DSN,
+		Environment: cfg.Environment,
+		Release:     cfg.Release,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &SentryReporter{client: client}, nil
+}
+
+// NoopReporter discards all events, it is used when no error tracker is configured
+type NoopReporter struct{}
+
+func (r NoopReporter) Report(event Event) {}
+
+func (r NoopReporter) Flush(timeout time.Duration) bool {
+	return true
+}
+
+// SentryReporter sends the events to a Sentry-compatible error tracker. The stack trace is captured where Report is
+// called, which for a panic is still inside the deferred function that recovered it
+type SentryReporter struct {
+	client *sentry.Client
+}
+
+func (r *SentryReporter) Report(event Event) {
+	scope := sentry.NewScope()
+
+	scope.SetLevel(sentry.LevelError)
+	if event.Panic {
+		scope.SetLevel(sentry.LevelFatal)
+	}
+
+	if event.RequestID != "" {
+		scope.SetTag("request_id", event.RequestID)
+	}
+	if event.Route != "" {
+		scope.SetTag("route", event.Route)
+	}
+	if event.UserID != 0 {
+		scope.SetUser(sentry.User{ID: strconv.FormatInt(event.UserID, 10)})
+	}
+
+	// only the method and URL of the request are sent, its headers and body may hold credentials
+	if event.Method != "" {
+		scope.AddEventProcessor(func(e *sentry.Event, hint *sentry.EventHint) *sentry.Event {
+			e.Request = &sentry.Request{Method: event.Method, URL: event.URL}
+			return e
+		})
+	}
+
+	r.client.CaptureException(event.Err, nil, scope)
+}
+
+func (r *SentryReporter) Flush(timeout time.Duration) bool {
+	return r.client.Flush(timeout)
+}
@@ -0,0 +1,652 @@
+// Package graphql executes GraphQL queries against a schema of resolvers. It implements the executable part of the
+// language: operations, variables, aliases, fragments and the @skip and @include directives. There are no interfaces,
+// unions or introspection, and the types of the arguments are checked by the resolvers rather than by the schema.
+//
+// The fields are resolved breadth first. Each resolver is called once for all the objects at its level of the
+// response, so the field of a list of movies is fetched with one query for the whole list rather than one per movie.
+package graphql
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+)
+
+// maxDepth is the deepest a selection can be nested, and maxFields the most fields a query can select once its
+// fragments have been expanded. They stop a small document from asking for an enormous response
+const (
+	maxDepth  = 12
+	maxFields = 1000
+)
+
+// Object is an object type of the schema
+type Object struct {
+	Name   string
+	Fields map[string]*Field
+}
+
+// Field is a field of an object type. Type is the object type the field resolves to, or nil for a scalar field,
+// whose value is encoded as JSON as it is
+type Field struct {
+	Type    *Object
+	List    bool     // whether the field resolves to a list of Type, in which case the resolver returns a []any for each source
+	Args    []string // the names of the arguments the field accepts
+	Resolve Resolver
+}
+
+// Resolver resolves a field for all the source objects at once, returning one value for each source in the same
+// order. A nil value is returned as null. The message of a returned error is sent to the client
+type Resolver func(ctx context.Context, sources []any, args Args) ([]any, error)
+
+// Property returns the resolver for a field which is read straight off each source, such as a column of a record
+func Property[S any](get func(source S) any) Resolver {
+	return func(ctx context.Context, sources []any, args Args) ([]any, error) {
+		values := make([]any, len(sources))
+		for i, source := range sources {
+			values[i] = get(source.(S))
+		}
+		return values, nil
+	}
+}
+
+// Root returns the resolver for a field of a root type, which has a single source, from a function which resolves
+// its value
+func Root(resolve func(ctx context.Context, args Args) (any, error)) Resolver {
+	return func(ctx context.Context, sources []any, args Args) ([]any, error) {
+		value, err := resolve(ctx, args)
+		if err != nil {
+			return nil, err
+		}
+
+		values := make([]any, len(sources))
+		for i := range values {
+			values[i] = value
+		}
+		return values, nil
+	}
+}
+
+// Schema holds the root object types. Mutation is nil when the schema doesn't support mutations
+type Schema struct {
+	Query    *Object
+	Mutation *Object
+}
+
+// Request is a GraphQL request, as it is sent in the body of a POST request
+type Request struct {
+	Query         string         `json:"query"`
+	OperationName string         `json:"operationName"`
+	Variables     map[string]any `json:"variables"`
+}
+
+// Response is the result of executing a request. Data is left out when the request couldn't be executed at all
+type Response struct {
+	Data   any     `json:"data,omitempty"`
+	Errors []Error `json:"errors,omitempty"`
+}
+
+// Error is a problem with a request or one of its fields. The path is the response keys leading to the field. It
+// doesn't include the indexes of lists, as a field is resolved for the whole list at once
+type Error struct {
+	Message string   `json:"message"`
+	Path    []string `json:"path,omitempty"`
+}
+
+// Execute parses, validates and executes the request. Errors in resolving a field are returned alongside the data,
+// with the field set to null
+func (s *Schema) Execute(ctx context.Context, req Request) *Response {
+	doc, err := parse(req.Query)
+	if err != nil {
+		return &Response{Errors: []Error{{Message: err.Error()}}}
+	}
+
+	e := &executor{doc: doc}
+
+	op, root, err := e.operation(s, req.OperationName)
+	if err != nil {
+		return &Response{Errors: []Error{{Message: err.Error()}}}
+	}
+	e.op = op
+
+	e.variables, err = coerceVariables(op, req.Variables)
+	if err != nil {
+		return &Response{Errors: []Error{{Message: err.Error()}}}
+	}
+
+	err = e.validate(root, op.selections, 1, map[string]bool{})
+	if err != nil {
+		return &Response{Errors: []Error{{Message: err.Error()}}}
+	}
+
+	// the root object is resolved once, with a single nil source
+	data := e.execute(ctx, root, []any{nil}, op.selections, nil)
+
+	return &Response{Data: data[0], Errors: e.errors}
+}
+
+type executor struct {
+	doc       *document
+	op        *operation
+	variables map[string]any
+	errors    []Error
+	fields    int
+}
+
+// operation picks the operation to run from the document, along with the root type it runs against
+func (e *executor) operation(s *Schema, name string) (*operation, *Object, error) {
+	var op *operation
+
+	switch {
+	case name == "" && len(e.doc.operations) > 1:
+		return nil, nil, errors.New("operationName must be provided when the document contains more than one operation")
+	case name == "":
+		op = e.doc.operations[0]
+	default:
+		for _, candidate := range e.doc.operations {
+			if candidate.name == name {
+				op = candidate
+				break
+			}
+		}
+		if op == nil {
+			return nil, nil, fmt.Errorf("unknown operation %q", name)
+		}
+	}
+
+	if op.kind == "mutation" {
+		if s.Mutation == nil {
+			return nil, nil, errors.New("the schema does not support mutations")
+		}
+		return op, s.Mutation, nil
+	}
+
+	return op, s.Query, nil
+}
+
+// coerceVariables works out the value of each variable the operation defines, from the request or the default
+func coerceVariables(op *operation, provided map[string]any) (map[string]any, error) {
+	variables := make(map[string]any)
+
+	for _, definition := range op.variables {
+		value, ok := provided[definition.name]
+
+		switch {
+		case !ok && definition.defaultValue != nil:
+			variables[definition.name] = definition.defaultValue
+		case (!ok || value == nil) && definition.required:
+			return nil, fmt.Errorf("variable \"$%s\" of a non-null type must be provided", definition.name)
+		case ok:
+			variables[definition.name] = value
+		}
+	}
+
+	return variables, nil
+}
+
+// validate checks the selections against the type before anything is resolved, so a mistake in part of a query
+// doesn't leave the rest of it half executed
+func (e *executor) validate(obj *Object, selections []selection, depth int, spreading map[string]bool) error {
+	if depth > maxDepth {
+		return fmt.Errorf("the query must not be nested more than %d levels deep", maxDepth)
+	}
+
+	for _, s := range selections {
+		switch s := s.(type) {
+		case *field:
+			e.fields++
+			if e.fields > maxFields {
+				return fmt.Errorf("the query must not select more than %d fields", maxFields)
+			}
+
+			if err := e.validateDirectives(s.directives); err != nil {
+				return err
+			}
+
+			if s.name == "__typename" {
+				if len(s.arguments) > 0 || len(s.selections) > 0 {
+					return errors.New(`field "__typename" does not take arguments or subfields`)
+				}
+				continue
+			}
+
+			definition, ok := obj.Fields[s.name]
+			if !ok {
+				return fmt.Errorf("cannot query field %q on type %q", s.name, obj.Name)
+			}
+
+			for name, value := range s.arguments {
+				if !contains(definition.Args, name) {
+					return fmt.Errorf("unknown argument %q on field \"%s.%s\"", name, obj.Name, s.name)
+				}
+				if err := e.validateValue(value); err != nil {
+					return err
+				}
+			}
+
+			switch {
+			case definition.Type == nil && len(s.selections) > 0:
+				return fmt.Errorf("field %q must not have a selection since it is a scalar", s.name)
+			case definition.Type != nil && len(s.selections) == 0:
+				return fmt.Errorf("field %q of type %q must have a selection of subfields", s.name, definition.Type.Name)
+			case definition.Type != nil:
+				if err := e.validate(definition.Type, s.selections, depth+1, spreading); err != nil {
+					return err
+				}
+			}
+		case *fragmentSpread:
+			if err := e.validateDirectives(s.directives); err != nil {
+				return err
+			}
+
+			frag, ok := e.doc.fragments[s.name]
+			if !ok {
+				return fmt.Errorf("unknown fragment %q", s.name)
+			}
+			if frag.typeCondition != obj.Name {
+				return fmt.Errorf("fragment %q cannot be spread here as type %q is not %q", s.name, obj.Name, frag.typeCondition)
+			}
+			if spreading[s.name] {
+				return fmt.Errorf("cannot spread fragment %q within itself", s.name)
+			}
+
+			spreading[s.name] = true
+			if err := e.validate(obj, frag.selections, depth, spreading); err != nil {
+				return err
+			}
+			delete(spreading, s.name)
+		case *inlineFragment:
+			if err := e.validateDirectives(s.directives); err != nil {
+				return err
+			}
+
+			if s.typeCondition != "" && s.typeCondition != obj.Name {
+				return fmt.Errorf("inline fragment cannot be spread here as type %q is not %q", obj.Name, s.typeCondition)
+			}
+			if err := e.validate(obj, s.selections, depth, spreading); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+func (e *executor) validateDirectives(directives []*directive) error {
+	for _, d := range directives {
+		if d.name != "skip" && d.name != "include" {
+			return fmt.Errorf("unknown directive \"@%s\"", d.name)
+		}
+
+		condition, ok := d.arguments["if"]
+		if !ok || len(d.arguments) != 1 {
+			return fmt.Errorf("directive \"@%s\" takes a single argument \"if\"", d.name)
+		}
+
+		if _, ok := e.resolveValue(condition).(bool); !ok {
+			return fmt.Errorf("argument \"if\" of directive \"@%s\" must be a boolean", d.name)
+		}
+	}
+
+	return nil
+}
+
+// validateValue checks every variable used in the value has been defined by the operation
+func (e *executor) validateValue(value any) error {
+	switch value := value.(type) {
+	case variable:
+		if !e.defined(string(value)) {
+			return fmt.Errorf("variable \"$%s\" is not defined", value)
+		}
+	case []any:
+		for _, item := range value {
+			if err := e.validateValue(item); err != nil {
+				return err
+			}
+		}
+	case map[string]any:
+		for _, item := range value {
+			if err := e.validateValue(item); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// defined reports whether the operation defines the variable. The value of a defined variable which the request
+// left out is absent from the variables, rather than it being an error to use it
+func (e *executor) defined(name string) bool {
+	for _, definition := range e.op.variables {
+		if definition.name == name {
+			return true
+		}
+	}
+	return false
+}
+
+// fieldGroup holds the fields of a selection which share a response key, they are merged into a single result
+type fieldGroup struct {
+	key    string
+	fields []*field
+}
+
+// collectFields flattens the fragments of the selections into the fields to resolve, in the order they are selected
+func (e *executor) collectFields(obj *Object, selections []selection, groups []*fieldGroup, visited map[string]bool) []*fieldGroup {
+	for _, s := range selections {
+		switch s := s.(type) {
+		case *field:
+			if !e.included(s.directives) {
+				continue
+			}
+
+			key := s.responseKey()
+
+			var group *fieldGroup
+			for _, candidate := range groups {
+				if candidate.key == key {
+					group = candidate
+					break
+				}
+			}
+
+			if group == nil {
+				group = &fieldGroup{key: key}
+				groups = append(groups, group)
+			}
+
+			group.fields = append(group.fields, s)
+		case *fragmentSpread:
+			if !e.included(s.directives) || visited[s.name] {
+				continue
+			}
+
+			visited[s.name] = true
+			groups = e.collectFields(obj, e.doc.fragments[s.name].selections, groups, visited)
+		case *inlineFragment:
+			if !e.included(s.directives) {
+				continue
+			}
+
+			groups = e.collectFields(obj, s.selections, groups, visited)
+		}
+	}
+
+	return groups
+}
+
+// included applies the @skip and @include directives
+func (e *executor) included(directives []*directive) bool {
+	for _, d := range directives {
+		condition := e.resolveValue(d.arguments["if"]).(bool)
+
+		if (d.name == "skip" && condition) || (d.name == "include" && !condition) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// execute resolves the selections for every source, returning the result object of each one
+func (e *executor) execute(ctx context.Context, obj *Object, sources []any, selections []selection, path []string) []*orderedMap {
+	results := make([]*orderedMap, len(sources))
+	for i := range results {
+		results[i] = &orderedMap{}
+	}
+
+	if len(sources) == 0 {
+		return results
+	}
+
+	for _, group := range e.collectFields(obj, selections, nil, map[string]bool{}) {
+		f := group.fields[0]
+		fieldPath := append(path[:len(path):len(path)], group.key)
+
+		if f.name == "__typename" {
+			for _, result := range results {
+				result.set(group.key, obj.Name)
+			}
+			continue
+		}
+
+		definition := obj.Fields[f.name]
+
+		values, err := definition.Resolve(ctx, sources, e.arguments(f))
+		if err == nil && len(values) != len(sources) {
+			err = fmt.Errorf("field %q resolved %d values for %d objects", f.name, len(values), len(sources))
+		}
+		if err != nil {
+			e.errors = append(e.errors, Error{Message: err.Error(), Path: fieldPath})
+			for _, result := range results {
+				result.set(group.key, nil)
+			}
+			continue
+		}
+
+		if definition.Type == nil {
+			for i, result := range results {
+				result.set(group.key, values[i])
+			}
+			continue
+		}
+
+		// the subfields of the objects resolved for every source are resolved together
+		var subselections []selection
+		for _, f := range group.fields {
+			subselections = append(subselections, f.selections...)
+		}
+
+		var children []any
+		for _, value := range values {
+			switch {
+			case value == nil:
+			case definition.List:
+				children = append(children, value.([]any)...)
+			default:
+				children = append(children, value)
+			}
+		}
+
+		childResults := e.execute(ctx, definition.Type, children, subselections, fieldPath)
+
+		next := 0
+		for i, value := range values {
+			switch {
+			case value == nil:
+				results[i].set(group.key, nil)
+			case definition.List:
+				items := value.([]any)
+				list := make([]any, len(items))
+				for j := range items {
+					list[j] = childResults[next]
+					next++
+				}
+				results[i].set(group.key, list)
+			default:
+				results[i].set(group.key, childResults[next])
+				next++
+			}
+		}
+	}
+
+	return results
+}
+
+// arguments resolves the variables in the arguments of the field
+func (e *executor) arguments(f *field) Args {
+	args := make(Args, len(f.arguments))
+
+	for name, value := range f.arguments {
+		// a variable which wasn't provided leaves the argument out, so the resolver uses its default
+		if v, ok := value.(variable); ok {
+			if _, provided := e.variables[string(v)]; !provided {
+				continue
+			}
+		}
+		args[name] = e.resolveValue(value)
+	}
+
+	return args
+}
+
+func (e *executor) resolveValue(value any) any {
+	switch value := value.(type) {
+	case variable:
+		return e.variables[string(value)]
+	case []any:
+		list := make([]any, len(value))
+		for i, item := range value {
+			list[i] = e.resolveValue(item)
+		}
+		return list
+	case map[string]any:
+		object := make(map[string]any, len(value))
+		for name, item := range value {
+			object[name] = e.resolveValue(item)
+		}
+		return object
+	default:
+		return value
+	}
+}
+
+// Args holds the arguments a field was given, after the variables have been filled in. The helpers return the
+// fallback for an argument which wasn't given or is null
+type Args map[string]any
+
+// Int returns an integer argument. Integers in the variables are decoded from JSON as floats, so the whole floats are
+// accepted too
+func (a Args) Int(name string, fallback int) (int, error) {
+	switch value := a[name].(type) {
+	case nil:
+		return fallback, nil
+	case int64:
+		if value >= math.MinInt32 && value <= math.MaxInt32 {
+			return int(value), nil
+		}
+	case float64:
+		if value == math.Trunc(value) && value >= math.MinInt32 && value <= math.MaxInt32 {
+			return int(value), nil
+		}
+	}
+
+	return 0, fmt.Errorf("argument %q must be an integer", name)
+}
+
+// ID returns an ID argument, which can be given as a number or a string holding a number
+func (a Args) ID(name string) (int64, error) {
+	switch value := a[name].(type) {
+	case int64:
+		return value, nil
+	case float64:
+		if value == math.Trunc(value) {
+			return int64(value), nil
+		}
+	case string:
+		id, err := strconv.ParseInt(value, 10, 64)
+		if err == nil {
+			return id, nil
+		}
+	case nil:
+		return 0, fmt.Errorf("argument %q must be provided", name)
+	}
+
+	return 0, fmt.Errorf("argument %q must be an ID", name)
+}
+
+// String returns a string argument. Enum values such as ASC are returned as strings
+func (a Args) String(name, fallback string) (string, error) {
+	switch value := a[name].(type) {
+	case nil:
+		return fallback, nil
+	case string:
+		return value, nil
+	case enumValue:
+		return string(value), nil
+	}
+
+	return "", fmt.Errorf("argument %q must be a string", name)
+}
+
+// Strings returns a list of strings argument. A single string is taken as a list of one, in the way GraphQL
+// coerces the input values
+func (a Args) Strings(name string) ([]string, error) {
+	switch value := a[name].(type) {
+	case nil:
+		return nil, nil
+	case string:
+		return []string{value}, nil
+	case []any:
+		list := make([]string, len(value))
+		for i, item := range value {
+			s, ok := item.(string)
+			if !ok {
+				return nil, fmt.Errorf("argument %q must be a list of strings", name)
+			}
+			list[i] = s
+		}
+		return list, nil
+	}
+
+	return nil, fmt.Errorf("argument %q must be a list of strings", name)
+}
+
+// orderedMap is a result object. It is encoded with its keys in the order they were selected, as GraphQL requires
+type orderedMap struct {
+	keys   []string
+	values map[string]any
+}
+
+func (m *orderedMap) set(key string, value any) {
+	if m.values == nil {
+		m.values = make(map[string]any)
+	}
+	if _, exists := m.values[key]; !exists {
+		m.keys = append(m.keys, key)
+	}
+	m.values[key] = value
+}
+
+func (m *orderedMap) MarshalJSON() ([]byte, error) {
+	var buf bytes.Buffer
+
+	buf.WriteByte('{')
+
+	for i, key := range m.keys {
+		if i > 0 {
+			buf.WriteByte(',')
+		}
+
+		k, err := json.Marshal(key)
+		if err != nil {
+			return nil, err
+		}
+
+		v, err := json.Marshal(m.values[key])
+		if err != nil {
+			return nil, err
+		}
+
+		buf.Write(k)
+		buf.WriteByte(':')
+		buf.Write(v)
+	}
+
+	buf.WriteByte('}')
+
+	return buf.Bytes(), nil
+}
+
+func contains(list []string, value string) bool {
+	for _, item := range list {
+		if item == value {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,198 @@
+package graphql
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+)
+
+type testMovie struct {
+	ID    int64
+	Title string
+}
+
+// testSchema returns a schema of movies whose sequel field is the movie itself, so a query can be nested as deep as
+// it likes. The number of times the sequel resolver is called is counted in calls
+func testSchema(calls *int) *Schema {
+	movie := &Object{Name: "Movie"}
+	movie.Fields = map[string]*Field{
+		"id":    {Resolve: Property(func(m *testMovie) any { return m.ID })},
+		"title": {Resolve: Property(func(m *testMovie) any { return m.Title })},
+		"sequel": {Type: movie, Resolve: func(ctx context.Context, sources []any, args Args) ([]any, error) {
+			*calls++
+			return sources, nil
+		}},
+	}
+
+	query := &Object{Name: "Query", Fields: map[string]*Field{
+		"movie": {Type: movie, Resolve: Root(func(ctx context.Context, args Args) (any, error) {
+			return &testMovie{ID: 1, Title: "Moana"}, nil
+		})},
+		"movies": {Type: movie, List: true, Args: []string{"limit"}, Resolve: Root(func(ctx context.Context, args Args) (any, error) {
+			limit, err := args.Int("limit", 2)
+			if err != nil {
+				return nil, err
+			}
+			if limit < 0 || limit > 100 {
+				return nil, errors.New("argument \"limit\" must be between 0 and 100")
+			}
+
+			movies := make([]any, limit)
+			for i := range movies {
+				movies[i] = &testMovie{ID: int64(i + 1), Title: "Moana"}
+			}
+			return movies, nil
+		})},
+	}}
+
+	return &Schema{Query: query}
+}
+
+// FuzzParse checks that parsing and executing an untrusted query never panics, and that the response can always be
+// encoded
+func FuzzParse(f *testing.F) {
+	f.Add(`{ movie { id title } }`)
+	f.Add(`query Movies($limit: Int = 2) { movies(limit: $limit) { ...M sequel { __typename } } } fragment M on Movie { id }`)
+	f.Add(`{ movie { ... on Movie { title @skip(if: true) } } }`)
+	f.Add(`fragment A on Movie { ...B } fragment B on Movie { ...A } { movie { ...A } }`)
+	f.Add(`mutation { movie { id } }`)
+	f.Add(`{ movies(limit: [1, {a: "é"}, -1.5e3, $x, ENUM]) { id } }`)
+	f.Add(`{ movie { id `)
+	f.Add(`"""block string""" { }`)
+
+	f.Fuzz(func(t *testing.T, query string) {
+		var calls int
+
+		resp := testSchema(&calls).Execute(context.Background(), Request{Query: query})
+
+		_, err := json.Marshal(resp)
+		if err != nil {
+			t.Fatalf("couldn't encode the response to %q: %v", query, err)
+		}
+	})
+}
+
+// TestExecute checks the results of the queries and the limits which stop a small document from asking for an
+// enormous response
+func TestExecute(t *testing.T) {
+	nested := func(depth int) string {
+		return "{ movie " + strings.Repeat("{ sequel ", depth-2) + "{ id }" + strings.Repeat(" }", depth-1)
+	}
+
+	fields := func(n int) string {
+		return "{ movie { " + strings.Repeat("id ", n-1) + "} }"
+	}
+
+	tests := []struct {
+		name      string
+		query     string
+		variables map[string]any
+		want      string
+	}{
+		{
+			name:  "aliases",
+			query: `{ movie { id name: title } }`,
+			want:  `{"data":{"movie":{"id":1,"name":"Moana"}}}`,
+		},
+		{
+			name:  "deepest nesting",
+			query: nested(maxDepth),
+			want:  `{"data":{"movie":{"sequel":{"sequel":{"sequel":{"sequel":{"sequel":{"sequel":{"sequel":{"sequel":{"sequel":{"sequel":{"id":1}}}}}}}}}}}}}`,
+		},
+		{
+			name:  "nested too deep",
+			query: nested(maxDepth + 1),
+			want:  `{"errors":[{"message":"the document must not be nested more than 12 levels deep"}]}`,
+		},
+		{
+			name:  "most fields",
+			query: fields(maxFields),
+			want:  `{"data":{"movie":{"id":1}}}`,
+		},
+		{
+			name:  "too many fields",
+			query: fields(maxFields + 1),
+			want:  `{"errors":[{"message":"the query must not select more than 1000 fields"}]}`,
+		},
+		{
+			name:  "fragments count towards the field limit",
+			query: `{ movie { ` + strings.Repeat("...M ", 100) + `} } fragment M on Movie { ` + strings.Repeat("id ", 10) + `}`,
+			want:  `{"errors":[{"message":"the query must not select more than 1000 fields"}]}`,
+		},
+		{
+			name:  "fragments",
+			query: `{ movie { ...M ... on Movie { title } } } fragment M on Movie { id title }`,
+			want:  `{"data":{"movie":{"id":1,"title":"Moana"}}}`,
+		},
+		{
+			name:  "fragment cycle",
+			query: `{ movie { ...A } } fragment A on Movie { ...B } fragment B on Movie { id ...A }`,
+			want:  `{"errors":[{"message":"cannot spread fragment \"A\" within itself"}]}`,
+		},
+		{
+			name:  "fragment on the wrong type",
+			query: `{ movie { ...Q } } fragment Q on Query { movie { id } }`,
+			want:  `{"errors":[{"message":"fragment \"Q\" cannot be spread here as type \"Movie\" is not \"Query\""}]}`,
+		},
+		{
+			name:  "unknown fragment",
+			query: `{ movie { ...M } }`,
+			want:  `{"errors":[{"message":"unknown fragment \"M\""}]}`,
+		},
+		{
+			name:      "skip and include",
+			query:     `query ($hide: Boolean!) { movie { id @skip(if: $hide) title @include(if: $hide) ...M @skip(if: true) } } fragment M on Movie { __typename }`,
+			variables: map[string]any{"hide": true},
+			want:      `{"data":{"movie":{"title":"Moana"}}}`,
+		},
+		{
+			name:  "unknown field",
+			query: `{ movie { rating } }`,
+			want:  `{"errors":[{"message":"cannot query field \"rating\" on type \"Movie\""}]}`,
+		},
+		{
+			name:  "undefined variable",
+			query: `{ movies(limit: $limit) { id } }`,
+			want:  `{"errors":[{"message":"variable \"$limit\" is not defined"}]}`,
+		},
+		{
+			name:  "resolver error",
+			query: `{ movies(limit: "two") { id } movie { id } }`,
+			want:  `{"data":{"movies":null,"movie":{"id":1}},"errors":[{"message":"argument \"limit\" must be an integer","path":["movies"]}]}`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var calls int
+
+			resp := testSchema(&calls).Execute(context.Background(), Request{Query: tt.query, Variables: tt.variables})
+
+			got, err := json.Marshal(resp)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if string(got) != tt.want {
+				t.Errorf("got %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+// TestExecuteBatches checks a field is resolved once for all the objects at its level of the response, rather than
+// once for each object
+func TestExecuteBatches(t *testing.T) {
+	var calls int
+
+	resp := testSchema(&calls).Execute(context.Background(), Request{Query: `{ movies(limit: 5) { sequel { sequel { id } } } }`})
+	if len(resp.Errors) > 0 {
+		t.Fatalf("got errors %v", resp.Errors)
+	}
+
+	if calls != 2 {
+		t.Errorf("got %d calls to the sequel resolver, want 2", calls)
+	}
+}
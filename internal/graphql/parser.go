@@ -0,0 +1,739 @@
+package graphql
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// document is a parsed GraphQL query document
+type document struct {
+	operations []*operation
+	fragments  map[string]*fragment
+}
+
+type operation struct {
+	kind       string // query or mutation
+	name       string
+	variables  []*variableDefinition
+	selections []selection
+}
+
+type variableDefinition struct {
+	name         string
+	required     bool
+	defaultValue any
+}
+
+type fragment struct {
+	name          string
+	typeCondition string
+	selections    []selection
+}
+
+// selection is one of *field, *fragmentSpread and *inlineFragment
+type selection interface{}
+
+type field struct {
+	alias      string
+	name       string
+	arguments  map[string]any
+	directives []*directive
+	selections []selection
+}
+
+// responseKey is the key the field is returned under, its alias if it has one
+func (f *field) responseKey() string {
+	if f.alias != "" {
+		return f.alias
+	}
+	return f.name
+}
+
+type fragmentSpread struct {
+	name       string
+	directives []*directive
+}
+
+type inlineFragment struct {
+	typeCondition string
+	directives    []*directive
+	selections    []selection
+}
+
+type directive struct {
+	name      string
+	arguments map[string]any
+}
+
+// variable is a reference to a variable in a value, it is replaced by the variable's value at execution time
+type variable string
+
+// enumValue is an enum literal such as ASC, which is passed to the resolvers as a string
+type enumValue string
+
+type tokenKind int
+
+const (
+	tokenEOF tokenKind = iota
+	tokenPunctuator
+	tokenName
+	tokenInt
+	tokenFloat
+	tokenString
+)
+
+type token struct {
+	kind  tokenKind
+	value string
+	pos   int
+}
+
+// lexer splits a query document into tokens, skipping the whitespace, commas and comments between them
+type lexer struct {
+	src string
+	pos int
+}
+
+func (l *lexer) next() (token, error) {
+	for l.pos < len(l.src) {
+		c := l.src[l.pos]
+
+		switch {
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r' || c == ',':
+			l.pos++
+		case c == '#':
+			for l.pos < len(l.src) && l.src[l.pos] != '\n' && l.src[l.pos] != '\r' {
+				l.pos++
+			}
+		default:
+			return l.token()
+		}
+	}
+
+	return token{kind: tokenEOF, pos: l.pos}, nil
+}
+
+func (l *lexer) token() (token, error) {
+	start := l.pos
+	c := l.src[l.pos]
+
+	switch {
+	case strings.HasPrefix(l.src[l.pos:], "..."):
+		l.pos += 3
+		return token{kind: tokenPunctuator, value: "...", pos: start}, nil
+	case strings.ContainsRune("!$()&:=@[]{}|", rune(c)):
+		l.pos++
+		return token{kind: tokenPunctuator, value: string(c), pos: start}, nil
+	case c == '_' || isLetter(c):
+		for l.pos < len(l.src) && (l.src[l.pos] == '_' || isLetter(l.src[l.pos]) || isDigit(l.src[l.pos])) {
+			l.pos++
+		}
+		return token{kind: tokenName, value: l.src[start:l.pos], pos: start}, nil
+	case c == '-' || isDigit(c):
+		return l.number()
+	case c == '"':
+		return l.string()
+	default:
+		return token{}, fmt.Errorf("syntax error at offset %d: unexpected character %q", start, c)
+	}
+}
+
+func (l *lexer) number() (token, error) {
+	start := l.pos
+	kind := tokenInt
+
+	if l.src[l.pos] == '-' {
+		l.pos++
+	}
+
+	digits := func() int {
+		n := 0
+		for l.pos < len(l.src) && isDigit(l.src[l.pos]) {
+			l.pos++
+			n++
+		}
+		return n
+	}
+
+	if digits() == 0 {
+		return token{}, fmt.Errorf("syntax error at offset %d: invalid number", start)
+	}
+
+	if l.pos < len(l.src) && l.src[l.pos] == '.' {
+		kind = tokenFloat
+		l.pos++
+		if digits() == 0 {
+			return token{}, fmt.Errorf("syntax error at offset %d: invalid number", start)
+		}
+	}
+
+	if l.pos < len(l.src) && (l.src[l.pos] == 'e' || l.src[l.pos] == 'E') {
+		kind = tokenFloat
+		l.pos++
+		if l.pos < len(l.src) && (l.src[l.pos] == '+' || l.src[l.pos] == '-') {
+			l.pos++
+		}
+		if digits() == 0 {
+			return token{}, fmt.Errorf("syntax error at offset %d: invalid number", start)
+		}
+	}
+
+	return token{kind: kind, value: l.src[start:l.pos], pos: start}, nil
+}
+
+func (l *lexer) string() (token, error) {
+	start := l.pos
+
+	// block strings are taken as they are, apart from the escaped triple quotes
+	if strings.HasPrefix(l.src[l.pos:], `"""`) {
+		for i := l.pos + 3; i+3 <= len(l.src); i++ {
+			switch {
+			case strings.HasPrefix(l.src[i:], `\"""`):
+				i += 3
+			case strings.HasPrefix(l.src[i:], `"""`):
+				value := strings.ReplaceAll(l.src[l.pos+3:i], `\"""`, `"""`)
+				l.pos = i + 3
+				return token{kind: tokenString, value: value, pos: start}, nil
+			}
+		}
+
+		return token{}, fmt.Errorf("syntax error at offset %d: unterminated string", start)
+	}
+
+	l.pos++
+
+	var b strings.Builder
+
+	for l.pos < len(l.src) {
+		c := l.src[l.pos]
+
+		switch {
+		case c == '"':
+			l.pos++
+			return token{kind: tokenString, value: b.String(), pos: start}, nil
+		case c == '\n' || c == '\r':
+			return token{}, fmt.Errorf("syntax error at offset %d: unterminated string", start)
+		case c == '\\':
+			if l.pos+1 >= len(l.src) {
+				return token{}, fmt.Errorf("syntax error at offset %d: unterminated string", start)
+			}
+
+			escape := l.src[l.pos+1]
+			l.pos += 2
+
+			switch escape {
+			case '"', '\\', '/':
+				b.WriteByte(escape)
+			case 'b':
+				b.WriteByte('\b')
+			case 'f':
+				b.WriteByte('\f')
+			case 'n':
+				b.WriteByte('\n')
+			case 'r':
+				b.WriteByte('\r')
+			case 't':
+				b.WriteByte('\t')
+			case 'u':
+				if l.pos+4 > len(l.src) {
+					return token{}, fmt.Errorf("syntax error at offset %d: invalid unicode escape", l.pos)
+				}
+				r, err := strconv.ParseUint(l.src[l.pos:l.pos+4], 16, 32)
+				if err != nil {
+					return token{}, fmt.Errorf("syntax error at offset %d: invalid unicode escape", l.pos)
+				}
+				b.WriteRune(rune(r))
+				l.pos += 4
+			default:
+				return token{}, fmt.Errorf("syntax error at offset %d: invalid escape sequence", l.pos-2)
+			}
+		default:
+			r, size := utf8.DecodeRuneInString(l.src[l.pos:])
+			b.WriteRune(r)
+			l.pos += size
+		}
+	}
+
+	return token{}, fmt.Errorf("syntax error at offset %d: unterminated string", start)
+}
+
+func isLetter(c byte) bool {
+	return (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z')
+}
+
+func isDigit(c byte) bool {
+	return c >= '0' && c <= '9'
+}
+
+// parser is a recursive descent parser for the executable parts of the GraphQL language: operations and fragments
+type parser struct {
+	lexer *lexer
+	tok   token
+	depth int
+}
+
+func parse(src string) (*document, error) {
+	p := &parser{lexer: &lexer{src: strings.TrimPrefix(src, "\ufeff")}}
+
+	err := p.advance()
+	if err != nil {
+		return nil, err
+	}
+
+	doc := &document{fragments: make(map[string]*fragment)}
+
+	for p.tok.kind != tokenEOF {
+		switch {
+		case p.peek(tokenPunctuator, "{"):
+			selections, err := p.selectionSet()
+			if err != nil {
+				return nil, err
+			}
+			doc.operations = append(doc.operations, &operation{kind: "query", selections: selections})
+		case p.peek(tokenName, "query"), p.peek(tokenName, "mutation"):
+			op, err := p.operation()
+			if err != nil {
+				return nil, err
+			}
+			doc.operations = append(doc.operations, op)
+		case p.peek(tokenName, "fragment"):
+			frag, err := p.fragment()
+			if err != nil {
+				return nil, err
+			}
+			if _, exists := doc.fragments[frag.name]; exists {
+				return nil, fmt.Errorf("there can only be one fragment named %q", frag.name)
+			}
+			doc.fragments[frag.name] = frag
+		default:
+			return nil, p.unexpected()
+		}
+	}
+
+	if len(doc.operations) == 0 {
+		return nil, fmt.Errorf("the document must contain an operation")
+	}
+
+	return doc, nil
+}
+
+func (p *parser) advance() error {
+	tok, err := p.lexer.next()
+	if err != nil {
+		return err
+	}
+	p.tok = tok
+	return nil
+}
+
+// peek reports whether the current token is of the kind, and has the value if one is given
+func (p *parser) peek(kind tokenKind, value string) bool {
+	return p.tok.kind == kind && (value == "" || p.tok.value == value)
+}
+
+// expect consumes the current token if it matches, returning its value
+func (p *parser) expect(kind tokenKind, value string) (string, error) {
+	if !p.peek(kind, value) {
+		return "", p.unexpected()
+	}
+
+	v := p.tok.value
+	return v, p.advance()
+}
+
+func (p *parser) unexpected() error {
+	if p.tok.kind == tokenEOF {
+		return fmt.Errorf("syntax error: unexpected end of document")
+	}
+	return fmt.Errorf("syntax error at offset %d: unexpected %q", p.tok.pos, p.tok.value)
+}
+
+func (p *parser) operation() (*operation, error) {
+	op := &operation{kind: p.tok.value}
+
+	err := p.advance()
+	if err != nil {
+		return nil, err
+	}
+
+	if p.peek(tokenName, "") {
+		op.name = p.tok.value
+		if err := p.advance(); err != nil {
+			return nil, err
+		}
+	}
+
+	if p.peek(tokenPunctuator, "(") {
+		op.variables, err = p.variableDefinitions()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	// directives on the operation are parsed but have no effect
+	if _, err := p.directives(); err != nil {
+		return nil, err
+	}
+
+	op.selections, err = p.selectionSet()
+	if err != nil {
+		return nil, err
+	}
+
+	return op, nil
+}
+
+func (p *parser) variableDefinitions() ([]*variableDefinition, error) {
+	if _, err := p.expect(tokenPunctuator, "("); err != nil {
+		return nil, err
+	}
+
+	var definitions []*variableDefinition
+
+	for !p.peek(tokenPunctuator, ")") {
+		if _, err := p.expect(tokenPunctuator, "$"); err != nil {
+			return nil, err
+		}
+
+		name, err := p.expect(tokenName, "")
+		if err != nil {
+			return nil, err
+		}
+
+		if _, err := p.expect(tokenPunctuator, ":"); err != nil {
+			return nil, err
+		}
+
+		// the type is only used to find out whether the variable is required, the resolvers check the values
+		required, err := p.typeReference()
+		if err != nil {
+			return nil, err
+		}
+
+		definition := &variableDefinition{name: name, required: required}
+
+		if p.peek(tokenPunctuator, "=") {
+			if err := p.advance(); err != nil {
+				return nil, err
+			}
+			definition.defaultValue, err = p.value(true)
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		definitions = append(definitions, definition)
+	}
+
+	return definitions, p.advance()
+}
+
+// typeReference parses a type such as [String!]! and reports whether it is non-null
+func (p *parser) typeReference() (bool, error) {
+	if p.peek(tokenPunctuator, "[") {
+		if err := p.advance(); err != nil {
+			return false, err
+		}
+		if _, err := p.typeReference(); err != nil {
+			return false, err
+		}
+		if _, err := p.expect(tokenPunctuator, "]"); err != nil {
+			return false, err
+		}
+	} else if _, err := p.expect(tokenName, ""); err != nil {
+		return false, err
+	}
+
+	if p.peek(tokenPunctuator, "!") {
+		return true, p.advance()
+	}
+
+	return false, nil
+}
+
+func (p *parser) fragment() (*fragment, error) {
+	if err := p.advance(); err != nil {
+		return nil, err
+	}
+
+	name, err := p.expect(tokenName, "")
+	if err != nil {
+		return nil, err
+	}
+	if name == "on" {
+		return nil, fmt.Errorf("syntax error: a fragment can't be named \"on\"")
+	}
+
+	if _, err := p.expect(tokenName, "on"); err != nil {
+		return nil, err
+	}
+
+	typeCondition, err := p.expect(tokenName, "")
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := p.directives(); err != nil {
+		return nil, err
+	}
+
+	selections, err := p.selectionSet()
+	if err != nil {
+		return nil, err
+	}
+
+	return &fragment{name: name, typeCondition: typeCondition, selections: selections}, nil
+}
+
+func (p *parser) selectionSet() ([]selection, error) {
+	// nesting is limited while parsing, so a deeply nested document can't exhaust the stack
+	p.depth++
+	defer func() { p.depth-- }()
+
+	if p.depth > maxDepth {
+		return nil, fmt.Errorf("the document must not be nested more than %d levels deep", maxDepth)
+	}
+
+	if _, err := p.expect(tokenPunctuator, "{"); err != nil {
+		return nil, err
+	}
+
+	var selections []selection
+
+	for !p.peek(tokenPunctuator, "}") {
+		s, err := p.selection()
+		if err != nil {
+			return nil, err
+		}
+		selections = append(selections, s)
+	}
+
+	if len(selections) == 0 {
+		return nil, fmt.Errorf("syntax error at offset %d: a selection set must not be empty", p.tok.pos)
+	}
+
+	return selections, p.advance()
+}
+
+func (p *parser) selection() (selection, error) {
+	if p.peek(tokenPunctuator, "...") {
+		if err := p.advance(); err != nil {
+			return nil, err
+		}
+
+		if p.peek(tokenName, "") && p.tok.value != "on" {
+			spread := &fragmentSpread{name: p.tok.value}
+			if err := p.advance(); err != nil {
+				return nil, err
+			}
+
+			var err error
+			spread.directives, err = p.directives()
+			return spread, err
+		}
+
+		inline := &inlineFragment{}
+
+		if p.peek(tokenName, "on") {
+			if err := p.advance(); err != nil {
+				return nil, err
+			}
+
+			var err error
+			inline.typeCondition, err = p.expect(tokenName, "")
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		var err error
+		inline.directives, err = p.directives()
+		if err != nil {
+			return nil, err
+		}
+
+		inline.selections, err = p.selectionSet()
+		return inline, err
+	}
+
+	name, err := p.expect(tokenName, "")
+	if err != nil {
+		return nil, err
+	}
+
+	f := &field{name: name}
+
+	if p.peek(tokenPunctuator, ":") {
+		if err := p.advance(); err != nil {
+			return nil, err
+		}
+
+		f.alias = name
+		f.name, err = p.expect(tokenName, "")
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if p.peek(tokenPunctuator, "(") {
+		f.arguments, err = p.arguments()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	f.directives, err = p.directives()
+	if err != nil {
+		return nil, err
+	}
+
+	if p.peek(tokenPunctuator, "{") {
+		f.selections, err = p.selectionSet()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return f, nil
+}
+
+func (p *parser) arguments() (map[string]any, error) {
+	if _, err := p.expect(tokenPunctuator, "("); err != nil {
+		return nil, err
+	}
+
+	arguments := make(map[string]any)
+
+	for !p.peek(tokenPunctuator, ")") {
+		name, err := p.expect(tokenName, "")
+		if err != nil {
+			return nil, err
+		}
+
+		if _, exists := arguments[name]; exists {
+			return nil, fmt.Errorf("there can only be one argument named %q", name)
+		}
+
+		if _, err := p.expect(tokenPunctuator, ":"); err != nil {
+			return nil, err
+		}
+
+		arguments[name], err = p.value(false)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return arguments, p.advance()
+}
+
+func (p *parser) directives() ([]*directive, error) {
+	var directives []*directive
+
+	for p.peek(tokenPunctuator, "@") {
+		if err := p.advance(); err != nil {
+			return nil, err
+		}
+
+		name, err := p.expect(tokenName, "")
+		if err != nil {
+			return nil, err
+		}
+
+		d := &directive{name: name}
+
+		if p.peek(tokenPunctuator, "(") {
+			d.arguments, err = p.arguments()
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		directives = append(directives, d)
+	}
+
+	return directives, nil
+}
+
+// value parses an input value. Variables aren't allowed in constant values, such as the defaults of the variables
+func (p *parser) value(constant bool) (any, error) {
+	tok := p.tok
+
+	switch {
+	case p.peek(tokenPunctuator, "$"):
+		if constant {
+			return nil, p.unexpected()
+		}
+		if err := p.advance(); err != nil {
+			return nil, err
+		}
+		name, err := p.expect(tokenName, "")
+		return variable(name), err
+	case tok.kind == tokenInt:
+		i, err := strconv.ParseInt(tok.value, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("syntax error at offset %d: integer out of range", tok.pos)
+		}
+		return i, p.advance()
+	case tok.kind == tokenFloat:
+		f, err := strconv.ParseFloat(tok.value, 64)
+		if err != nil {
+			return nil, fmt.Errorf("syntax error at offset %d: invalid number", tok.pos)
+		}
+		return f, p.advance()
+	case tok.kind == tokenString:
+		return tok.value, p.advance()
+	case tok.kind == tokenName:
+		if err := p.advance(); err != nil {
+			return nil, err
+		}
+		switch tok.value {
+		case "true":
+			return true, nil
+		case "false":
+			return false, nil
+		case "null":
+			return nil, nil
+		default:
+			return enumValue(tok.value), nil
+		}
+	case p.peek(tokenPunctuator, "["):
+		if err := p.advance(); err != nil {
+			return nil, err
+		}
+
+		list := []any{}
+		for !p.peek(tokenPunctuator, "]") {
+			item, err := p.value(constant)
+			if err != nil {
+				return nil, err
+			}
+			list = append(list, item)
+		}
+		return list, p.advance()
+	case p.peek(tokenPunctuator, "{"):
+		if err := p.advance(); err != nil {
+			return nil, err
+		}
+
+		object := make(map[string]any)
+		for !p.peek(tokenPunctuator, "}") {
+			name, err := p.expect(tokenName, "")
+			if err != nil {
+				return nil, err
+			}
+			if _, err := p.expect(tokenPunctuator, ":"); err != nil {
+				return nil, err
+			}
+			object[name], err = p.value(constant)
+			if err != nil {
+				return nil, err
+			}
+		}
+		return object, p.advance()
+	default:
+		return nil, p.unexpected()
+	}
+}
@@ -0,0 +1,242 @@
+package jobs
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/nytro04/greenlight/internal/clock"
+	"github.com/nytro04/greenlight/internal/jsonlog"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// ErrUnknownJob is returned by Trigger when no job has been registered with the name
+var ErrUnknownJob = errors.New("unknown job")
+
+// Scheduler runs named jobs periodically in the background. A job never runs twice at the same time: a tick which
+// comes while the job is still running is skipped, and a Trigger while it is running makes it run once more when it
+// finishes. A panic in a job is recovered and logged, and the job carries on running on its next tick. Stop waits for
+// the running jobs to finish, so it is called in the graceful shutdown
+type Scheduler struct {
+	clock   clock.Clock
+	logger  *jsonlog.Logger
+	metrics *metrics
+
+	mu      sync.Mutex
+	jobs    map[string]*job
+	started bool
+	stopped bool
+
+	stop chan struct{}
+	wg   sync.WaitGroup
+}
+
+// job is a registered job and whether it is running
+type job struct {
+	name     string
+	interval time.Duration
+	fn       func() error
+
+	running bool // guarded by the scheduler's mutex
+	again   bool // triggered while running, so it runs once more when it finishes
+}
+
+// metrics are the metrics recorded for every job, labelled with the name of the job
+type metrics struct {
+	runs        *prometheus.CounterVec
+	durations   *prometheus.HistogramVec
+	lastSuccess *prometheus.GaugeVec
+}
+
+// New returns a Scheduler which times the jobs with the clock and logs their errors. The metrics of the jobs are
+// registered with reg when it isn't nil
+func New(clk clock.Clock, logger *jsonlog.Logger, reg prometheus.Registerer) *Scheduler {
+	m := &metrics{
+		runs: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "job_runs_total",
+			Help: "Number of runs of the scheduled jobs, by job and outcome (success, error, panic or skipped).",
+		}, []string{"job", "outcome"}),
+		durations: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "job_duration_seconds",
+			Help:    "Time taken by the runs of the scheduled jobs, by job.",
+			Buckets: []float64{.01, .05, .1, .5, 1, 5, 10, 30, 60, 300},
+		}, []string{"job"}),
+		lastSuccess: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "job_last_success_timestamp_seconds",
+			Help: "Unix time the scheduled jobs last ran without an error, by job.",
+		}, []string{"job"}),
+	}
+
+	if reg != nil {
+		reg.MustRegister(m.runs, m.durations, m.lastSuccess)
+	}
+
+	return &Scheduler{
+		clock:   clk,
+		logger:  logger,
+		metrics: m,
+		jobs:    make(map[string]*job),
+		stop:    make(chan struct{}),
+	}
+}
+
+// Register adds a job which runs fn once every interval, starting one interval after the scheduler is started. It
+// panics if the name is already taken or the scheduler has been started, as both are mistakes in the code setting it up
+func (s *Scheduler) Register(name string, interval time.Duration, fn func() error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.started {
+		panic(fmt.Sprintf("jobs: %s registered after the scheduler started", name))
+	}
+
+	if _, ok := s.jobs[name]; ok {
+		panic(fmt.Sprintf("jobs: %s registered twice", name))
+	}
+
+	if interval <= 0 {
+		panic(fmt.Sprintf("jobs: %s has a non-positive interval", name))
+	}
+
+	s.jobs[name] = &job{name: name, interval: interval, fn: fn}
+}
+
+// Start starts a goroutine for each job which runs it on its schedule until the scheduler is stopped
+func (s *Scheduler) Start() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.started {
+		return
+	}
+	s.started = true
+
+	for _, j := range s.jobs {
+		s.wg.Add(1)
+
+		go func() {
+			defer s.wg.Done()
+
+			ticker := s.clock.NewTicker(j.interval)
+			defer ticker.Stop()
+
+			for {
+				select {
+				case <-s.stop:
+					return
+				case <-ticker.C():
+					s.run(j)
+				}
+			}
+		}()
+	}
+}
+
+// Trigger runs a job straight away in the background, rather than waiting for its next tick. It is used when there is
+// new work for a job, such as an email which has just been queued. If the job is running it runs again once it has
+// finished, so the new work isn't missed
+func (s *Scheduler) Trigger(name string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	j, ok := s.jobs[name]
+	if !ok {
+		return fmt.Errorf("%w: %s", ErrUnknownJob, name)
+	}
+
+	// the work is picked up by the first run after a restart
+	if s.stopped {
+		return nil
+	}
+
+	// the run in progress may have already looked for the new work, so it runs once more when it finishes
+	if j.running {
+		j.again = true
+		return nil
+	}
+	j.running = true
+
+	// added while holding the mutex, so it can't race with the Wait in Stop
+	s.wg.Add(1)
+
+	go func() {
+		defer s.wg.Done()
+		s.loop(j)
+	}()
+
+	return nil
+}
+
+// Stop stops the jobs from being run on their schedules and waits for the runs in progress to finish
+func (s *Scheduler) Stop() {
+	s.mu.Lock()
+	if !s.stopped {
+		s.stopped = true
+		close(s.stop)
+	}
+	s.mu.Unlock()
+
+	s.wg.Wait()
+}
+
+// run runs the job on a tick, unless it is already running, in which case the tick is skipped as the run in progress
+// is doing the work
+func (s *Scheduler) run(j *job) {
+	s.mu.Lock()
+	if j.running {
+		s.mu.Unlock()
+		s.metrics.runs.WithLabelValues(j.name, "skipped").Inc()
+		return
+	}
+	j.running = true
+	s.mu.Unlock()
+
+	s.loop(j)
+}
+
+// loop runs the job, which has been marked as running, again for as long as it is triggered while running
+func (s *Scheduler) loop(j *job) {
+	for {
+		s.runOnce(j)
+
+		s.mu.Lock()
+		again := j.again && !s.stopped
+		j.again = false
+		if !again {
+			j.running = false
+		}
+		s.mu.Unlock()
+
+		if !again {
+			return
+		}
+	}
+}
+
+// runOnce runs a single iteration of the job, recovering from any panic so that a misbehaving job can't stop the
+// scheduler, and records the outcome
+func (s *Scheduler) runOnce(j *job) {
+	start := s.clock.Now()
+	outcome := "success"
+
+	defer func() {
+		if err := recover(); err != nil {
+			outcome = "panic"
+			s.logger.PrintError(fmt.Errorf("%s", err), "job", j.name)
+		}
+
+		s.metrics.runs.WithLabelValues(j.name, outcome).Inc()
+		s.metrics.durations.WithLabelValues(j.name).Observe(s.clock.Since(start).Seconds())
+
+		if outcome == "success" {
+			s.metrics.lastSuccess.WithLabelValues(j.name).Set(float64(s.clock.Now().Unix()))
+		}
+	}()
+
+	err := j.fn()
+	if err != nil {
+		outcome = "error"
+		s.logger.PrintError(err, "job", j.name)
+	}
+}
@@ -0,0 +1,88 @@
+package jobs
+
+import (
+	"errors"
+	"io"
+	"log/slog"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/nytro04/greenlight/internal/clock"
+	"github.com/nytro04/greenlight/internal/jsonlog"
+)
+
+// TestTriggerWhileRunning checks a job triggered while it is running isn't run at the same time, but runs once more
+// after the run in progress, however many times it was triggered
+func TestTriggerWhileRunning(t *testing.T) {
+	s := New(clock.NewMock(time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)), jsonlog.New(io.Discard, slog.LevelError), nil)
+
+	started := make(chan struct{})
+	release := make(chan struct{})
+
+	var runs, concurrent, maxConcurrent atomic.Int32
+
+	s.Register("test", time.Hour, func() error {
+		n := concurrent.Add(1)
+		defer concurrent.Add(-1)
+
+		if n > maxConcurrent.Load() {
+			maxConcurrent.Store(n)
+		}
+
+		if runs.Add(1) == 1 {
+			close(started)
+			<-release
+		}
+		return nil
+	})
+
+	if err := s.Trigger("test"); err != nil {
+		t.Fatal(err)
+	}
+	<-started
+
+	for i := 0; i < 3; i++ {
+		if err := s.Trigger("test"); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	close(release)
+	s.wg.Wait()
+
+	if got := runs.Load(); got != 2 {
+		t.Errorf("got %d runs, want 2", got)
+	}
+
+	if got := maxConcurrent.Load(); got != 1 {
+		t.Errorf("got %d concurrent runs, want 1", got)
+	}
+}
+
+// TestPanicRecovered checks a job which panics can be run again
+func TestPanicRecovered(t *testing.T) {
+	s := New(clock.NewMock(time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)), jsonlog.New(io.Discard, slog.LevelError), nil)
+
+	var runs atomic.Int32
+
+	s.Register("test", time.Hour, func() error {
+		runs.Add(1)
+		panic("boom")
+	})
+
+	for i := 0; i < 2; i++ {
+		if err := s.Trigger("test"); err != nil {
+			t.Fatal(err)
+		}
+		s.wg.Wait()
+	}
+
+	if got := runs.Load(); got != 2 {
+		t.Errorf("got %d runs, want 2", got)
+	}
+
+	if err := s.Trigger("missing"); !errors.Is(err, ErrUnknownJob) {
+		t.Errorf("got %v triggering an unknown job, want ErrUnknownJob", err)
+	}
+}
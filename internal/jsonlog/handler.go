@@ -0,0 +1,206 @@
+package jsonlog
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"runtime/debug"
+	"sync"
+	"time"
+)
+
+// Handler is a slog.Handler which writes each record as a single line of JSON, holding the level, time, message and
+// the record's attributes as properties. Entries at the error level and above include the stack trace
+type Handler struct {
+	out      io.Writer
+	minLevel slog.Leveler
+	mu       *sync.Mutex
+
+	// the attributes added with WithAttrs, each with the groups which were open when it was added
+	attrs  []groupedAttr
+	groups []string
+}
+
+type groupedAttr struct {
+	groups []string
+	attr   slog.Attr
+}
+
+// NewHandler creates a handler which writes the records at or above the minimum level to the output destination
+func NewHandler(out io.Writer, minLevel slog.Leveler) *Handler {
+	if minLevel == nil {
+		minLevel = LevelInfo
+	}
+
+	return &Handler{
+		out:      out,
+		minLevel: minLevel,
+		mu:       &sync.Mutex{},
+	}
+}
+
+// Enabled reports whether records at the level are written
+func (h *Handler) Enabled(_ context.Context, level slog.Level) bool {
+	return level >= h.minLevel.Level()
+}
+
+// WithAttrs returns a handler which adds the attributes to every record, inside the groups which are currently open
+func (h *Handler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	if len(attrs) == 0 {
+		return h
+	}
+
+	h2 := h.clone()
+	for _, attr := range attrs {
+		h2.attrs = append(h2.attrs, groupedAttr{groups: h.groups, attr: attr})
+	}
+	return h2
+}
+
+// WithGroup returns a handler which nests the attributes added after it, and those of its records, under the group name
+func (h *Handler) WithGroup(name string) slog.Handler {
+	if name == "" {
+		return h
+	}
+
+	h2 := h.clone()
+	h2.groups = append(h2.groups[:len(h2.groups):len(h2.groups)], name)
+	return h2
+}
+
+// clone copies the handler, sharing its output destination and mutex so the entries of the two are never intermingled
+func (h *Handler) clone() *Handler {
+	return &Handler{
+		out:      h.out,
+		minLevel: h.minLevel,
+		mu:       h.mu,
+		attrs:    h.attrs[:len(h.attrs):len(h.attrs)],
+		groups:   h.groups,
+	}
+}
+
+// Handle writes the record as a line of JSON
+func (h *Handler) Handle(_ context.Context, record slog.Record) error {
+	properties := make(map[string]any)
+
+	for _, ga := range h.attrs {
+		addAttr(group(properties, ga.groups), ga.attr)
+	}
+
+	if record.NumAttrs() > 0 {
+		m := group(properties, h.groups)
+		record.Attrs(func(attr slog.Attr) bool {
+			addAttr(m, attr)
+			return true
+		})
+	}
+
+	// create an anonymous struct to hold the log entry properties
+	aux := struct {
+		Level      string         `json:"level"`
+		Time       string         `json:"time,omitempty"`
+		Message    string         `json:"message"`
+		Properties map[string]any `json:"properties,omitempty"`
+		Trace      string         `json:"trace,omitempty"`
+	}{
+		Level:      levelName(record.Level),
+		Message:    record.Message,
+		Properties: properties,
+	}
+
+	// the time is left out of a record without one, as slog expects
+	if !record.Time.IsZero() {
+		aux.Time = record.Time.UTC().Format(time.RFC3339)
+	}
+
+	// include the stack trace for logs at the error and fatal levels
+	if record.Level >= LevelError {
+		aux.Trace = string(debug.Stack())
+	}
+
+	// marshal the anonymous struct to a JSON. if there was a problem creating the JSON, set the contents of the log
+	// entry to be that plain text error message
+	line, err := json.Marshal(aux)
+	if err != nil {
+		line = []byte(levelName(LevelError) + ": unable to marshal log message: " + err.Error())
+	}
+
+	// lock the mutex so that no two writes to the output destination can happen at the same time
+	// if we dont do this, it's possible that the text for two or more log entries could be intermingled in the output
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	_, err = h.out.Write(append(line, '\n'))
+	return err
+}
+
+// levelName returns the name of the level written in the entries
+func levelName(level slog.Level) string {
+	if level >= LevelFatal {
+		return "FATAL"
+	}
+	return level.String()
+}
+
+// group returns the map the attributes inside the groups are added to, creating the nested maps along the way
+func group(properties map[string]any, groups []string) map[string]any {
+	for _, name := range groups {
+		m, ok := properties[name].(map[string]any)
+		if !ok {
+			m = make(map[string]any)
+			properties[name] = m
+		}
+		properties = m
+	}
+	return properties
+}
+
+// addAttr adds the attribute to the map. empty attributes and groups are left out, and the attributes of a group
+// without a key are added to the map directly
+func addAttr(m map[string]any, attr slog.Attr) {
+	attr.Value = attr.Value.Resolve()
+	if attr.Equal(slog.Attr{}) {
+		return
+	}
+
+	if attr.Value.Kind() == slog.KindGroup {
+		attrs := attr.Value.Group()
+		if len(attrs) == 0 {
+			return
+		}
+
+		if attr.Key != "" {
+			m = group(m, []string{attr.Key})
+		}
+		for _, a := range attrs {
+			addAttr(m, a)
+		}
+		return
+	}
+
+	m[attr.Key] = value(attr.Value)
+}
+
+// value converts an attribute value to the value written in the JSON. times and durations are written in their text
+// form, errors as their message, and values which can't be marshaled as their fmt representation
+func value(v slog.Value) any {
+	switch v.Kind() {
+	case slog.KindTime:
+		return v.Time().UTC().Format(time.RFC3339Nano)
+	case slog.KindDuration:
+		return v.Duration().String()
+	case slog.KindAny:
+		a := v.Any()
+		if err, ok := a.(error); ok {
+			return err.Error()
+		}
+		if _, err := json.Marshal(a); err != nil {
+			return fmt.Sprintf("%+v", a)
+		}
+		return a
+	default:
+		return v.Any()
+	}
+}
@@ -1,70 +1,67 @@
 package jsonlog
 
 import (
-	"encoding/json"
+	"context"
+	"fmt"
 	"io"
+	"log/slog"
 	"os"
-	"runtime/debug"
-	"sync"
-	"time"
+	"strings"
 )
 
-// Level is a type that represents the severity of the log.
-type Level int8
-
-// initialize a constant which represent a specific severity level
-// we use iota as a shortcut to assign incremental values to the constants
+// the severity levels of the log entries. they are the slog levels, with a fatal level above error for the entries
+// written just before the application exits, and an off level which silences the logger
 const (
-	LevelInfo  Level = iota // has a value of 0
-	LevelError              // has a value of 1
-	LevelFatal              // has a value of 2
-	LevelOff                // has a value of 3
+	LevelDebug = slog.LevelDebug
+	LevelInfo  = slog.LevelInfo
+	LevelWarn  = slog.LevelWarn
+	LevelError = slog.LevelError
+	LevelFatal = slog.Level(12)
+	LevelOff   = slog.Level(16)
 )
 
-// String method to convert the Level type to a string
-func (l Level) String() string {
-	switch l {
-	case LevelInfo:
-		return "INFO"
-	case LevelError:
-		return "ERROR"
-	case LevelFatal:
-		return "FATAL"
-	default:
-		return ""
-	}
-}
-
-// Logger type to represent the logger. this holds the output destination that the log will be written to,
-// the minimum level of severity that logs will be written for, and a mutex to make the logger safe for concurrent use(coordinating the writes)
+// Logger is the application's logger. it writes entries through a Handler, and takes the properties of an entry as
+// alternating keys and values, or slog.Attr values, in the same way as slog.Logger, so they keep their types in the output
 type Logger struct {
-	out      io.Writer
-	minLevel Level
-	mu       sync.Mutex
+	logger *slog.Logger
 }
 
 // New function to create a new Logger instance, which will write logs at or above the specified minimum level to the given output destination
-func New(out io.Writer, minLevel Level) *Logger {
-	return &Logger{
-		out:      out,
-		minLevel: minLevel,
-	}
+func New(out io.Writer, minLevel slog.Leveler) *Logger {
+	return &Logger{logger: slog.New(NewHandler(out, minLevel))}
+}
+
+// With returns a child logger which adds the given properties to every entry it writes, as well as any the parent adds.
+// the child shares its parent's output destination and mutex, so entries from the two are never intermingled
+func (l *Logger) With(args ...any) *Logger {
+	return &Logger{logger: l.logger.With(args...)}
+}
+
+// Slog returns a slog.Logger writing through the same handler, for libraries which log with slog. Making it the
+// default logger with slog.SetDefault also sends the output of the standard library's log package through it
+func (l *Logger) Slog() *slog.Logger {
+	return l.logger
+}
+
+// Handler returns the handler the logger writes its entries through
+func (l *Logger) Handler() slog.Handler {
+	return l.logger.Handler()
 }
 
 // PrintInfo method to write an info log entry to the output destination. the log entry will include the log level, specified message and properties
-func (l *Logger) PrintInfo(message string, properties map[string]string) {
-	l.print(LevelInfo, message, properties)
+func (l *Logger) PrintInfo(message string, args ...any) {
+	l.logger.Log(context.Background(), LevelInfo, message, args...)
 }
 
 // PrintError method to write an error log entry to the output destination. the log entry will include the specified message and properties
-func (l *Logger) PrintError(err error, properties map[string]string) {
-	l.print(LevelError, err.Error(), properties)
+func (l *Logger) PrintError(err error, args ...any) {
+	l.logger.Log(context.Background(), LevelError, err.Error(), args...)
 }
 
 // PrintFatal method to write a fatal log entry to the output destination. the log entry will include the specified message and properties
 // after writing the log entry, the application will be terminated by calling os.Exit(1)
-func (l *Logger) PrintFatal(err error, properties map[string]string) {
-	l.print(LevelFatal, err.Error(), properties)
+func (l *Logger) PrintFatal(err error, args ...any) {
+	l.logger.Log(context.Background(), LevelFatal, err.Error(), args...)
 	os.Exit(1) // for entries at the fatal level, we call os.Exit(1) to terminate the application
 }
 
@@ -72,50 +69,27 @@ func (l *Logger) PrintFatal(err error, properties map[string]string) {
 // this means that we can use a Logger instance as the output destination for the log package's standard library loggers
 // this is useful because it allows us to redirect the standard library loggers to our custom logger
 func (l *Logger) Write(message []byte) (n int, err error) {
-	return l.print(LevelError, string(message), nil)
+	l.logger.Log(context.Background(), LevelError, strings.TrimSuffix(string(message), "\n"))
+	return len(message), nil
 }
 
-// Print method to write a log entry to the output destination. the log entry will include the specified level, message, and properties
-func (l *Logger) print(level Level, message string, properties map[string]string) (int, error) {
-	// if the log level is below the minimum level, return without writing anything
-	if level < l.minLevel {
-		return 0, nil
-	}
-
-	// create an anonymous struct to hold the log entry properties
-	aux := struct {
-		Level      string            `josn:"level"`
-		Time       string            `json:"time"`
-		Message    string            `json:"message"`
-		Properties map[string]string `json:"properties,omitempty"`
-		Trace      string            `json:"json,omitempty"`
-	}{
-		Level:      level.String(),
-		Time:       time.Now().UTC().Format(time.RFC3339),
-		Message:    message,
-		Properties: properties,
-	}
-
-	// include the stack trace for logs at the error and fatal levels
-	if level >= LevelError {
-		aux.Trace = string(debug.Stack())
-	}
-
-	// declare a line variable for holding the log entry
-	var line []byte
-
-	// marshal the anonymous struct to a JSON and store it in the line variable. if there was a problem
-	// creating the JSON, set the contents of the log entry to be that plain text error message
-	line, err := json.Marshal(aux)
-	if err != nil {
-		line = []byte(LevelError.String() + ": unable to marshal log message" + err.Error())
+// ParseLevel returns the level with the given name, which is one of debug, info, warn or error in any case
+func ParseLevel(name string) (slog.Level, error) {
+	switch strings.ToLower(name) {
+	case "debug":
+		return LevelDebug, nil
+	case "info":
+		return LevelInfo, nil
+	case "warn":
+		return LevelWarn, nil
+	case "error":
+		return LevelError, nil
+	default:
+		return 0, fmt.Errorf("unknown log level %q", name)
 	}
+}
 
-	// lock the logger's mutex to make it safe for concurrent use
-	// lock the mutex so that no two writes to the output destination can happen at the same time
-	// if we dont do this, it's possible that the text for two or more log entries could be intermingled in the output
-	l.mu.Lock()
-	defer l.mu.Unlock()
-
-	return l.out.Write(append(line, '\n'))
+// LevelName returns the name of the level accepted by ParseLevel
+func LevelName(level slog.Level) string {
+	return strings.ToLower(levelName(level))
 }
@@ -0,0 +1,163 @@
+package jwtauth
+
+import (
+	"crypto/ed25519"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/nytro04/greenlight/internal/clock"
+)
+
+// ErrInvalidToken is returned by Verify for a token which is malformed, expired, or wasn't signed with our key
+var ErrInvalidToken = errors.New("invalid token")
+
+// The signing algorithms which can be configured
+const (
+	AlgorithmHS256 = "HS256"
+	AlgorithmEdDSA = "EdDSA"
+)
+
+// MinSecretLength is the shortest HS256 secret accepted, shorter secrets can be brute forced from a single token
+const MinSecretLength = 32
+
+// Config holds the signing settings. Secret is used with HS256, PrivateKeyFile is the path of a PEM encoded PKCS #8
+// Ed25519 private key used with EdDSA
+type Config struct {
+	Algorithm      string
+	Secret         string
+	PrivateKeyFile string
+	Issuer         string
+}
+
+// Claims are the claims of the tokens we issue. The user's details are included so the request can be authenticated
+// without looking the user up in the database
+type Claims struct {
+	Email     string `json:"email"`
+	Name      string `json:"name"`
+	Activated bool   `json:"activated"`
+	jwt.RegisteredClaims
+}
+
+// UserID returns the ID of the user the token was issued to, which is held in the subject claim
+func (c *Claims) UserID() (int64, error) {
+	return strconv.ParseInt(c.Subject, 10, 64)
+}
+
+// Signer issues and verifies the signed authentication tokens
+type Signer struct {
+	method     jwt.SigningMethod
+	signingKey any
+	verifyKey  any
+	issuer     string
+	clock      clock.Clock
+}
+
+// New returns a signer for the configured algorithm, reading the private key from disk for EdDSA
+func New(cfg Config, clk clock.Clock) (*Signer, error) {
+	s := &Signer{issuer: cfg.Issuer, clock: clk}
+
+	switch cfg.Algorithm {
+	case AlgorithmHS256:
+		if len(cfg.Secret) < MinSecretLength {
+			return nil, fmt.Errorf("jwt: the HS256 secret must be at least %d bytes long", MinSecretLength)
+		}
+
+		s.method = jwt.SigningMethodHS256
+		s.signingKey = []byte(cfg.Secret)
+		s.verifyKey = []byte(cfg.Secret)
+	case AlgorithmEdDSA:
+		key, err := readEd25519Key(cfg.PrivateKeyFile)
+		if err != nil {
+			return nil, err
+		}
+
+		s.method = jwt.SigningMethodEdDSA
+		s.signingKey = key
+		s.verifyKey = key.Public()
+	default:
+		return nil, fmt.Errorf("jwt: unsupported algorithm %q", cfg.Algorithm)
+	}
+
+	return s, nil
+}
+
+// readEd25519Key reads a PEM encoded PKCS #8 Ed25519 private key, the format `openssl genpkey -algorithm ed25519` writes
+func readEd25519Key(path string) (ed25519.PrivateKey, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("jwt: %w", err)
+	}
+
+	block, _ := pem.Decode(b)
+	if block == nil {
+		return nil, errors.New("jwt: the private key file is not PEM encoded")
+	}
+
+	key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("jwt: %w", err)
+	}
+
+	ed, ok := key.(ed25519.PrivateKey)
+	if !ok {
+		return nil, errors.New("jwt: the private key is not an Ed25519 key")
+	}
+
+	return ed, nil
+}
+
+// Issue signs a token for the user which expires after the ttl, returning it with its expiry time
+func (s *Signer) Issue(userID int64, email, name string, activated bool, ttl time.Duration) (string, time.Time, error) {
+	now := s.clock.Now()
+	expiry := now.Add(ttl)
+
+	claims := Claims{
+		Email:     email,
+		Name:      name,
+		Activated: activated,
+		RegisteredClaims: jwt.RegisteredClaims{
+			Issuer:    s.issuer,
+			Subject:   strconv.FormatInt(userID, 10),
+			IssuedAt:  jwt.NewNumericDate(now),
+			NotBefore: jwt.NewNumericDate(now),
+			ExpiresAt: jwt.NewNumericDate(expiry),
+		},
+	}
+
+	token, err := jwt.NewWithClaims(s.method, claims).SignedString(s.signingKey)
+	if err != nil {
+		return "", time.Time{}, err
+	}
+
+	return token, expiry, nil
+}
+
+// Verify checks the signature, issuer and expiry of a token and returns its claims. Only the configured algorithm is
+// accepted, so a token can't be forged by switching it to another algorithm (or none)
+func (s *Signer) Verify(token string) (*Claims, error) {
+	var claims Claims
+
+	_, err := jwt.ParseWithClaims(token, &claims, func(*jwt.Token) (any, error) {
+		return s.verifyKey, nil
+	},
+		jwt.WithValidMethods([]string{s.method.Alg()}),
+		jwt.WithIssuer(s.issuer),
+		jwt.WithExpirationRequired(),
+		jwt.WithTimeFunc(s.clock.Now),
+	)
+	if err != nil {
+		return nil, ErrInvalidToken
+	}
+
+	if _, err := claims.UserID(); err != nil {
+		return nil, ErrInvalidToken
+	}
+
+	return &claims, nil
+}
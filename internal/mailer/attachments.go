@@ -0,0 +1,101 @@
+package mailer
+
+import (
+	"errors"
+	"fmt"
+	"mime"
+	"net/http"
+	"strings"
+)
+
+// MaxAttachmentsSize is the largest total size of the attachments of an email. The attachments are base64 encoded in
+// the message, so this keeps an email well under the size limits of the providers and of most mailboxes
+const MaxAttachmentsSize = 10 << 20
+
+// attachmentTypes are the content types an attachment may have, only images may be inline
+var attachmentTypes = []string{
+	"application/json",
+	"application/pdf",
+	"application/zip",
+	"image/gif",
+	"image/jpeg",
+	"image/png",
+	"text/csv",
+	"text/plain",
+}
+
+// ErrInvalidAttachment is returned when an attachment is too large, has a content type which isn't allowed, or its
+// content doesn't match its content type
+var ErrInvalidAttachment = errors.New("invalid attachment")
+
+// Attachment is a file sent with an email. An inline attachment is an image shown in the HTML body, which refers to it
+// by its file name as the content ID, such as <img src="cid:logo.png">, rather than a file offered for download
+type Attachment struct {
+	Filename    string
+	ContentType string
+	Data        []byte
+	Inline      bool
+}
+
+// disposition returns the Content-Disposition of the attachment
+func (a Attachment) disposition() string {
+	if a.Inline {
+		return "inline"
+	}
+	return "attachment"
+}
+
+// ValidateAttachments checks the attachments of an email can be sent. The content is sniffed, so a file can't be sent
+// as an image or a PDF when it is something else
+func ValidateAttachments(attachments []Attachment) error {
+	total := 0
+	filenames := make(map[string]bool, len(attachments))
+
+	for _, a := range attachments {
+		// the file name ends up in the headers of the message and is the content ID of an inline image
+		if a.Filename == "" || strings.ContainsAny(a.Filename, "/\\\"\r\n") {
+			return fmt.Errorf("%w: %q is not a valid file name", ErrInvalidAttachment, a.Filename)
+		}
+
+		if filenames[a.Filename] {
+			return fmt.Errorf("%w: %q is attached more than once", ErrInvalidAttachment, a.Filename)
+		}
+		filenames[a.Filename] = true
+
+		mediaType, _, err := mime.ParseMediaType(a.ContentType)
+		if err != nil || !contains(attachmentTypes, mediaType) {
+			return fmt.Errorf("%w: %s can't be attached as %q", ErrInvalidAttachment, a.Filename, a.ContentType)
+		}
+
+		if a.Inline && !strings.HasPrefix(mediaType, "image/") {
+			return fmt.Errorf("%w: %s is inline but not an image", ErrInvalidAttachment, a.Filename)
+		}
+
+		// the text types can't be told apart by sniffing, they only need to be text
+		sniffed, _, _ := mime.ParseMediaType(http.DetectContentType(a.Data))
+		if strings.HasPrefix(mediaType, "text/") || mediaType == "application/json" {
+			if !strings.HasPrefix(sniffed, "text/") {
+				return fmt.Errorf("%w: %s is not text", ErrInvalidAttachment, a.Filename)
+			}
+		} else if sniffed != mediaType {
+			return fmt.Errorf("%w: %s is %s rather than %s", ErrInvalidAttachment, a.Filename, sniffed, mediaType)
+		}
+
+		total += len(a.Data)
+	}
+
+	if total > MaxAttachmentsSize {
+		return fmt.Errorf("%w: the attachments must not be more than %d bytes in total", ErrInvalidAttachment, MaxAttachmentsSize)
+	}
+
+	return nil
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
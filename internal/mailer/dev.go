@@ -0,0 +1,145 @@
+package mailer
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// DefaultDevDir is the directory the dev provider writes the emails to when the config doesn't set one
+const DefaultDevDir = "./tmp/mail"
+
+// ErrNoOutbox is returned by Mailer.Outbox when the emails are sent rather than captured
+var ErrNoOutbox = errors.New("the mail provider has no outbox")
+
+// CapturedEmail is an email captured by the dev provider instead of being sent. The attachments are listed without
+// their content
+type CapturedEmail struct {
+	ID          string               `json:"id"`
+	CapturedAt  time.Time            `json:"captured_at"`
+	From        string               `json:"from"`
+	To          string               `json:"to"`
+	Subject     string               `json:"subject"`
+	PlainBody   string               `json:"plain_body"`
+	HTMLBody    string               `json:"html_body"`
+	Attachments []CapturedAttachment `json:"attachments"`
+}
+
+type CapturedAttachment struct {
+	Filename    string `json:"filename"`
+	ContentType string `json:"content_type"`
+	Size        int    `json:"size"`
+	Inline      bool   `json:"inline"`
+}
+
+// Dev captures the emails rather than sending them, for local development without a mail provider. Each email is
+// written as a JSON file to a directory and logged, so the tokens in the activation and password reset emails can be
+// read straight from the log
+type Dev struct {
+	dir string
+}
+
+// NewDev returns a Dev provider which writes the emails to dir, creating it if it doesn't exist
+func NewDev(dir string) (*Dev, error) {
+	if dir == "" {
+		dir = DefaultDevDir
+	}
+
+	err := os.MkdirAll(dir, 0o755)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Dev{dir: dir}, nil
+}
+
+func (d *Dev) Send(ctx context.Context, from, to string, message *Message) error {
+	now := time.Now().UTC()
+
+	email := CapturedEmail{
+		// the ID sorts in the order the emails were captured and is the name of the file
+		ID:          fmt.Sprintf("%d", now.UnixNano()),
+		CapturedAt:  now,
+		From:        from,
+		To:          to,
+		Subject:     message.Subject,
+		PlainBody:   message.PlainBody,
+		HTMLBody:    message.HTMLBody,
+		Attachments: []CapturedAttachment{},
+	}
+
+	for _, a := range message.Attachments {
+		email.Attachments = append(email.Attachments, CapturedAttachment{Filename: a.Filename, ContentType: a.ContentType, Size: len(a.Data), Inline: a.Inline})
+	}
+
+	js, err := json.MarshalIndent(email, "", "\t")
+	if err != nil {
+		return err
+	}
+
+	path := filepath.Join(d.dir, email.ID+".json")
+
+	err = os.WriteFile(path, js, 0o644)
+	if err != nil {
+		return err
+	}
+
+	slog.InfoContext(ctx, "email captured", "to", to, "subject", message.Subject, "path", path, "plain_body", message.PlainBody)
+
+	return nil
+}
+
+// Ping checks the directory the emails are written to still exists
+func (d *Dev) Ping(ctx context.Context) error {
+	_, err := os.Stat(d.dir)
+	return err
+}
+
+// List returns up to limit of the captured emails, newest first
+func (d *Dev) List(limit int) ([]*CapturedEmail, error) {
+	entries, err := os.ReadDir(d.dir)
+	if err != nil {
+		return nil, err
+	}
+
+	names := []string{}
+	for _, entry := range entries {
+		if !entry.IsDir() && strings.HasSuffix(entry.Name(), ".json") {
+			names = append(names, entry.Name())
+		}
+	}
+
+	// the IDs have the same number of digits, so sorting the names sorts the emails by when they were captured
+	sort.Sort(sort.Reverse(sort.StringSlice(names)))
+
+	if len(names) > limit {
+		names = names[:limit]
+	}
+
+	emails := make([]*CapturedEmail, 0, len(names))
+
+	for _, name := range names {
+		js, err := os.ReadFile(filepath.Join(d.dir, name))
+		if err != nil {
+			return nil, err
+		}
+
+		var email CapturedEmail
+
+		err = json.Unmarshal(js, &email)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", name, err)
+		}
+
+		emails = append(emails, &email)
+	}
+
+	return emails, nil
+}
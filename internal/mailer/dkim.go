@@ -0,0 +1,81 @@
+package mailer
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/emersion/go-msgauth/dkim"
+)
+
+// dkimHeaders are the header fields covered by the DKIM signature. Relays may add their own header fields, so only the
+// ones the mailer writes are signed rather than all of them
+var dkimHeaders = []string{"From", "To", "Subject", "Date", "Message-ID", "MIME-Version", "Content-Type"}
+
+// newDKIMOptions returns the options messages are signed with for the domain and selector, with the private key read
+// from a PEM file. The key may be an RSA key in PKCS #1 or PKCS #8 form, or an Ed25519 key in PKCS #8 form
+func newDKIMOptions(domain, selector, keyFile string) (*dkim.SignOptions, error) {
+	b, err := os.ReadFile(keyFile)
+	if err != nil {
+		return nil, fmt.Errorf("dkim: %w", err)
+	}
+
+	block, _ := pem.Decode(b)
+	if block == nil {
+		return nil, errors.New("dkim: the private key file is not PEM encoded")
+	}
+
+	var key any
+
+	key, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		key, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+		if err != nil {
+			return nil, errors.New("dkim: the private key is not a PKCS #1 or PKCS #8 key")
+		}
+	}
+
+	signer, ok := key.(crypto.Signer)
+	if !ok {
+		return nil, errors.New("dkim: the private key can't be used for signing")
+	}
+
+	if _, ok := key.(*rsa.PrivateKey); ok && signer.Public().(*rsa.PublicKey).N.BitLen() < 1024 {
+		return nil, errors.New("dkim: the RSA private key must be at least 1024 bits")
+	}
+
+	return &dkim.SignOptions{
+		Domain:                 domain,
+		Selector:               selector,
+		Signer:                 signer,
+		HeaderKeys:             dkimHeaders,
+		HeaderCanonicalization: dkim.CanonicalizationRelaxed,
+		BodyCanonicalization:   dkim.CanonicalizationRelaxed,
+	}, nil
+}
+
+// signMessage writes out the message and returns it with a DKIM-Signature header added, ready to be handed to the
+// SMTP connection
+func signMessage(msg io.WriterTo, options *dkim.SignOptions) (*bytes.Buffer, error) {
+	var raw bytes.Buffer
+
+	_, err := msg.WriteTo(&raw)
+	if err != nil {
+		return nil, err
+	}
+
+	var signed bytes.Buffer
+
+	err = dkim.Sign(&signed, &raw, options)
+	if err != nil {
+		return nil, fmt.Errorf("dkim: %w", err)
+	}
+
+	return &signed, nil
+}
@@ -0,0 +1,26 @@
+package mailer
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+)
+
+// do sends a request to a provider's HTTP API, turning a non-2xx response into an error which includes the start of
+// the response body, where the providers explain what was wrong
+func do(client *http.Client, provider string, req *http.Request) error {
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		message, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return fmt.Errorf("%s %s %s: unexpected status %d: %s", provider, req.Method, req.URL.Path, resp.StatusCode, message)
+	}
+
+	// drain the body so the connection can be reused
+	_, err = io.Copy(io.Discard, resp.Body)
+	return err
+}
@@ -2,11 +2,21 @@ package mailer
 
 import (
 	"bytes"
+	"context"
 	"embed"
+	"errors"
+	"fmt"
+	"io/fs"
+	"strings"
 	"text/template"
 	"time"
 
-	"github.com/go-mail/mail/v2"
+	"github.com/emersion/go-msgauth/dkim"
+	"github.com/nytro04/greenlight/internal/clock"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
 )
 
 // Below we declare a new variable with the type embed.FS(embed file system) to hold
@@ -18,45 +28,217 @@ import (
 //go:embed templates
 var templateFS embed.FS
 
-// Define a Mailer struct which contains a mail.Dialer instance(used to connect to an SMTP server),
-// and the sender information for your emails (the name and address you want the emails to be from
-// such as "Alice Smith <alice@example.com>").
+// The providers emails can be sent through
+const (
+	ProviderSMTP     = "smtp"
+	ProviderSES      = "ses"
+	ProviderSendGrid = "sendgrid"
+	ProviderMailgun  = "mailgun"
+	ProviderDev      = "dev"
+)
+
+// defaultTimeout bounds an attempt at sending an email when the config doesn't set a timeout
+const defaultTimeout = 10 * time.Second
+
+// ErrUnknownProvider is returned by New when the requested provider is not supported
+var ErrUnknownProvider = errors.New("unknown mail provider")
+
+// Sender is implemented by the providers which can send the rendered emails. Send makes a single attempt, retrying is
+// left to the caller. Ping checks the provider can be reached and the credentials are accepted, without sending
+// anything
+type Sender interface {
+	Send(ctx context.Context, from, to string, message *Message) error
+	Ping(ctx context.Context) error
+}
+
+// Config holds the settings for all of the providers, only the ones for the selected provider are used
+type Config struct {
+	Provider string        // smtp, ses, sendgrid, mailgun or dev
+	Sender   string        // the name and address the emails are from, such as "Alice Smith <alice@example.com>"
+	Timeout  time.Duration // bounds each attempt at sending an email, 10 seconds when it isn't set
+
+	SMTPHosts            []string // in priority order, each may include a port
+	SMTPPort             int      // the port of the hosts which don't include one
+	SMTPUsername         string
+	SMTPPassword         string
+	SMTPFailureThreshold int           // consecutive failures to connect after which a host is skipped
+	SMTPHostCooldown     time.Duration // how long a failing host is skipped for
+
+	// the emails sent over SMTP are DKIM signed when the domain is set, the selector names the DNS record holding the
+	// public key of the PEM encoded private key in the file
+	DKIMDomain   string
+	DKIMSelector string
+	DKIMKeyFile  string
+
+	SESRegion    string
+	SESAccessKey string
+	SESSecretKey string
+
+	SendGridAPIKey string
+
+	MailgunBaseURL string // https://api.mailgun.net, or https://api.eu.mailgun.net for domains in the EU region
+	MailgunDomain  string
+	MailgunAPIKey  string
+
+	DevDir string // where the dev provider writes the emails, ./tmp/mail when it isn't set
+}
+
+// Define a Mailer struct which contains the Sender for the configured provider, and the sender
+// information for your emails (the name and address you want the emails to be from such as
+// "Alice Smith <alice@example.com>").
 type Mailer struct {
-	dialer *mail.Dialer
-	sender string
+	provider string
+	sender   Sender
+	from     string
+}
+
+// New returns a Mailer which sends the emails through the provider in the config. The clock times how long a failing
+// SMTP host is skipped for
+func New(cfg Config, clk clock.Clock) (Mailer, error) {
+	var sender Sender
+	var err error
+
+	if cfg.Timeout <= 0 {
+		cfg.Timeout = defaultTimeout
+	}
+
+	switch cfg.Provider {
+	case "", ProviderSMTP:
+		cfg.Provider = ProviderSMTP
+
+		var dkimOptions *dkim.SignOptions
+		if cfg.DKIMDomain != "" {
+			dkimOptions, err = newDKIMOptions(cfg.DKIMDomain, cfg.DKIMSelector, cfg.DKIMKeyFile)
+			if err != nil {
+				return Mailer{}, err
+			}
+		}
+
+		sender, err = NewSMTP(cfg.SMTPHosts, cfg.SMTPPort, cfg.SMTPUsername, cfg.SMTPPassword, cfg.Timeout, cfg.SMTPFailureThreshold, cfg.SMTPHostCooldown, dkimOptions, clk)
+		if err != nil {
+			return Mailer{}, err
+		}
+	case ProviderSES:
+		sender = NewSES(cfg.SESRegion, cfg.SESAccessKey, cfg.SESSecretKey, cfg.Timeout)
+	case ProviderSendGrid:
+		sender = NewSendGrid(cfg.SendGridAPIKey, cfg.Timeout)
+	case ProviderMailgun:
+		sender = NewMailgun(cfg.MailgunBaseURL, cfg.MailgunDomain, cfg.MailgunAPIKey, cfg.Timeout)
+	case ProviderDev:
+		sender, err = NewDev(cfg.DevDir)
+		if err != nil {
+			return Mailer{}, err
+		}
+	default:
+		return Mailer{}, fmt.Errorf("%w: %q", ErrUnknownProvider, cfg.Provider)
+	}
+
+	return Mailer{provider: cfg.Provider, sender: sender, from: cfg.Sender}, nil
+}
+
+// tracerName is the instrumentation scope of the spans recorded for the emails sent
+const tracerName = "github.com/nytro04/greenlight/internal/mailer"
+
+// Message is an email rendered from one of the templates, ready to be sent, with the files attached to it
+type Message struct {
+	Subject     string
+	PlainBody   string
+	HTMLBody    string
+	Attachments []Attachment
+}
+
+// templateExt is the extension of the embedded template files, the name of a template is its file name without it
+const templateExt = ".go.tmpl"
+
+// Template holds the subject, plain text and HTML body templates of an email, written in the text/template language
+// with the same dynamic data as the embedded template they replace. It is used for the templates edited by an admin
+type Template struct {
+	Subject   string
+	PlainBody string
+	HTMLBody  string
+}
+
+// TemplateNames returns the names of the embedded templates, such as "user_welcome", sorted by name
+func TemplateNames() []string {
+	entries, _ := fs.ReadDir(templateFS, "templates")
+
+	names := make([]string, 0, len(entries))
+	for _, entry := range entries {
+		names = append(names, strings.TrimSuffix(entry.Name(), templateExt))
+	}
+
+	return names
 }
 
-// Define a New function which initializes a new Mailer instance and returns a pointer to it.
-func New(host string, port int, username, password, sender string) Mailer {
+// TemplateName returns the name of the template in the template file
+func TemplateName(templateFile string) string {
+	return strings.TrimSuffix(templateFile, templateExt)
+}
 
-	// initialize a new mail.Dialer instance with the provided SMTP serve settings. we
-	// also configure the dialer to use a 5-second timeout when connecting to the SMTP server.
-	// This will prevent the application from hanging indefinitely if the SMTP server is not
-	// available or is slow to respond.
-	dialer := mail.NewDialer(host, port, username, password)
-	dialer.Timeout = 5 * time.Second
+// TemplateFile returns the file name of the template with the given name
+func TemplateFile(name string) string {
+	return name + templateExt
+}
 
-	// return a new Mailer instance with the dialer and sender information
-	return Mailer{
-		dialer: dialer,
-		sender: sender,
+// Embedded returns the source of the subject, plainBody and htmlBody templates of the embedded template with the
+// given name, for an admin to start editing from
+func Embedded(name string) (*Template, error) {
+	tmpl, err := template.New("email").ParseFS(templateFS, "templates/"+name+templateExt)
+	if err != nil {
+		return nil, err
 	}
+
+	source := func(part string) string {
+		t := tmpl.Lookup(part)
+		if t == nil || t.Tree == nil {
+			return ""
+		}
+		return strings.TrimSpace(t.Tree.Root.String())
+	}
+
+	return &Template{Subject: source("subject"), PlainBody: source("plainBody"), HTMLBody: source("htmlBody")}, nil
 }
 
-func (m Mailer) Send(recipient, templateFile string, data interface{}) error {
+// CheckSyntax parses a part of a template, returning the error if it isn't a valid template
+func CheckSyntax(text string) error {
+	_, err := template.New("check").Parse(text)
+	return err
+}
+
+// Render executes the subject, plainBody and htmlBody templates of the template file with the dynamic data
+func (m Mailer) Render(templateFile string, data interface{}) (*Message, error) {
 	//use the ParseFS method to parse the email template file from the embedded file system
 	// and return a new template.Template instance that we can use to render the email template.
 	tmpl, err := template.New("email").ParseFS(templateFS, "templates/"+templateFile)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
+	return execute(tmpl, data)
+}
+
+// RenderTemplate executes a template edited by an admin with the dynamic data, in the same way as Render
+func (m Mailer) RenderTemplate(t Template, data interface{}) (*Message, error) {
+	tmpl := template.New("email")
+
+	for name, text := range map[string]string{"subject": t.Subject, "plainBody": t.PlainBody, "htmlBody": t.HTMLBody} {
+		_, err := tmpl.New(name).Parse(text)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return execute(tmpl, data)
+}
+
+// execute renders the subject, plainBody and htmlBody templates of an email
+func execute(tmpl *template.Template, data interface{}) (*Message, error) {
 	// Execute the named template "subject", passing in the dynamic data and storing the
 	// result in a bytes.Buffer variable
 	subject := new(bytes.Buffer)
-	err = tmpl.ExecuteTemplate(subject, "subject", data)
+	err := tmpl.ExecuteTemplate(subject, "subject", data)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
 	// Execute the named template "plainBody", passing in the dynamic data and storing the
@@ -64,44 +246,56 @@ func (m Mailer) Send(recipient, templateFile string, data interface{}) error {
 	plainBody := new(bytes.Buffer)
 	err = tmpl.ExecuteTemplate(plainBody, "plainBody", data)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
 	// same as above but for the "htmlBody" template
 	htmlBody := new(bytes.Buffer)
 	err = tmpl.ExecuteTemplate(htmlBody, "htmlBody", data)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
-	// create a new mail.Message instance and set the recipient, sender, subject, and body of the email
-	// using the values we generated from the email template above. We2q			 use the SetBody method to set the
-	// plain text body of the email, and the AddAlternative method to add an HTML alternative body. This
-	// allows email clients that support HTML to display the HTML version of the email, while clients that
-	// do not support HTML will display the plain text version. It's important to note that AddAlternative
-	// must be called after SetBody to ensure that the HTML version is correctly associated with the plain text version.
-	msg := mail.NewMessage()
-	msg.SetHeader("To", recipient)
-	msg.SetHeader("From", m.sender)
-	msg.SetHeader("subject", subject.String())
-	msg.SetBody("text/plain", plainBody.String())
-	msg.AddAlternative("text/html", htmlBody.String())
-
-	// we will try to send the email up to 3 times if it fails. This is to handle temporary network issues or
-	// SMTP server problems. If the email is sent successfully, we return nil. If it fails after 3 attempts, we
-	// return the error. We also sleep for 500 milliseconds between each attempt to give the SMTP server a chance to recover.
-	for i := 1; i <= 3; i++ {
-		// use the dialer to connect to the SMTP server and send the email message then closes the connection. If
-		// there is a timeout, it will return a "dial tcp: i/o timeout" error. or the associated error if there is one.
-		err = m.dialer.DialAndSend(msg)
-		// if the email was sent successfully, return nil
-		if nil == err {
-			return nil
+	return &Message{Subject: subject.String(), PlainBody: plainBody.String(), HTMLBody: htmlBody.String()}, nil
+}
+
+// Deliver makes a single attempt at sending a rendered email, recording a span as a child of the span in the context.
+// Retrying a failed email is left to the caller, the email queue retries with a backoff. The attachments are checked
+// with ValidateAttachments first, an email with an invalid attachment is never handed to the provider
+func (m Mailer) Deliver(ctx context.Context, recipient string, message *Message) (err error) {
+	ctx, span := otel.Tracer(tracerName).Start(ctx, "mailer.Deliver",
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(attribute.String("mailer.provider", m.provider)),
+	)
+	defer func() {
+		if err != nil {
+			span.RecordError(err)
+			span.SetStatus(codes.Error, err.Error())
 		}
+		span.End()
+	}()
 
-		// if it didnt work, sleep for 500 milliseconds and try again
-		time.Sleep(500 * time.Millisecond)
+	err = ValidateAttachments(message.Attachments)
+	if err != nil {
+		return err
 	}
 
-	return err
+	return m.sender.Send(ctx, m.from, recipient, message)
+}
+
+// Ping checks the provider can be reached and accepts the credentials, without sending anything. It is used by the
+// readiness check to find out whether emails can be sent
+func (m Mailer) Ping(ctx context.Context) error {
+	return m.sender.Ping(ctx)
+}
+
+// Outbox returns up to limit of the emails captured by the dev provider, newest first. It returns ErrNoOutbox for the
+// other providers, which send the emails
+func (m Mailer) Outbox(limit int) ([]*CapturedEmail, error) {
+	dev, ok := m.sender.(*Dev)
+	if !ok {
+		return nil, ErrNoOutbox
+	}
+
+	return dev.List(limit)
 }
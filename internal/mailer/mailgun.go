@@ -0,0 +1,112 @@
+package mailer
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"net/textproto"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// DefaultMailgunBaseURL is the address of the Mailgun API for domains in the US region
+const DefaultMailgunBaseURL = "https://api.mailgun.net"
+
+// Mailgun sends the emails with the Mailgun messages API for a sending domain, authenticated with an API key
+type Mailgun struct {
+	baseURL string
+	domain  string
+	apiKey  string
+	client  *http.Client
+}
+
+func NewMailgun(baseURL, domain, apiKey string, timeout time.Duration) *Mailgun {
+	if baseURL == "" {
+		baseURL = DefaultMailgunBaseURL
+	}
+
+	return &Mailgun{
+		baseURL: strings.TrimSuffix(baseURL, "/"),
+		domain:  domain,
+		apiKey:  apiKey,
+		client:  &http.Client{Timeout: timeout},
+	}
+}
+
+// Send posts the email as a multipart form, with each attachment as a file. Mailgun gives an inline image its file
+// name as the content ID
+func (m *Mailgun) Send(ctx context.Context, from, to string, message *Message) error {
+	var body bytes.Buffer
+
+	form := multipart.NewWriter(&body)
+
+	fields := [][2]string{
+		{"from", from},
+		{"to", to},
+		{"subject", message.Subject},
+		{"text", message.PlainBody},
+		{"html", message.HTMLBody},
+	}
+
+	for _, field := range fields {
+		err := form.WriteField(field[0], field[1])
+		if err != nil {
+			return err
+		}
+	}
+
+	for _, a := range message.Attachments {
+		header := textproto.MIMEHeader{}
+		header.Set("Content-Disposition", mime.FormatMediaType("form-data", map[string]string{"name": a.disposition(), "filename": a.Filename}))
+		header.Set("Content-Type", a.ContentType)
+
+		part, err := form.CreatePart(header)
+		if err != nil {
+			return err
+		}
+
+		_, err = part.Write(a.Data)
+		if err != nil {
+			return err
+		}
+	}
+
+	err := form.Close()
+	if err != nil {
+		return err
+	}
+
+	req, err := m.newRequest(ctx, http.MethodPost, "/v3/"+url.PathEscape(m.domain)+"/messages", &body)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", form.FormDataContentType())
+
+	return do(m.client, "Mailgun", req)
+}
+
+// Ping fetches the sending domain, which fails if the key has been revoked or the domain doesn't exist
+func (m *Mailgun) Ping(ctx context.Context) error {
+	req, err := m.newRequest(ctx, http.MethodGet, "/v3/domains/"+url.PathEscape(m.domain), nil)
+	if err != nil {
+		return err
+	}
+
+	return do(m.client, "Mailgun", req)
+}
+
+func (m *Mailgun) newRequest(ctx context.Context, method, path string, body io.Reader) (*http.Request, error) {
+	req, err := http.NewRequestWithContext(ctx, method, m.baseURL+path, body)
+	if err != nil {
+		return nil, err
+	}
+
+	// Mailgun uses basic authentication with the user name "api" and the API key as the password
+	req.SetBasicAuth("api", m.apiKey)
+
+	return req, nil
+}
@@ -0,0 +1,113 @@
+package mailer
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/mail"
+	"time"
+)
+
+// sendGridBaseURL is the address of the SendGrid v3 API
+const sendGridBaseURL = "https://api.sendgrid.com"
+
+// SendGrid sends the emails with the SendGrid v3 mail send API, authenticated with an API key
+type SendGrid struct {
+	apiKey string
+	client *http.Client
+}
+
+func NewSendGrid(apiKey string, timeout time.Duration) *SendGrid {
+	return &SendGrid{apiKey: apiKey, client: &http.Client{Timeout: timeout}}
+}
+
+type sendGridAddress struct {
+	Email string `json:"email"`
+	Name  string `json:"name,omitempty"`
+}
+
+type sendGridContent struct {
+	Type  string `json:"type"`
+	Value string `json:"value"`
+}
+
+type sendGridPersonalization struct {
+	To []sendGridAddress `json:"to"`
+}
+
+type sendGridAttachment struct {
+	Content     []byte `json:"content"` // encoded as base64
+	Type        string `json:"type"`
+	Filename    string `json:"filename"`
+	Disposition string `json:"disposition"`
+	ContentID   string `json:"content_id,omitempty"`
+}
+
+type sendGridMessage struct {
+	Personalizations []sendGridPersonalization `json:"personalizations"`
+	From             sendGridAddress           `json:"from"`
+	Subject          string                    `json:"subject"`
+	Content          []sendGridContent         `json:"content"`
+	Attachments      []sendGridAttachment      `json:"attachments,omitempty"`
+}
+
+func (s *SendGrid) Send(ctx context.Context, from, to string, message *Message) error {
+	// SendGrid takes the name and address separately, rather than as a single "Name <address>"
+	sender, err := mail.ParseAddress(from)
+	if err != nil {
+		return err
+	}
+
+	body := sendGridMessage{
+		Personalizations: []sendGridPersonalization{{To: []sendGridAddress{{Email: to}}}},
+		From:             sendGridAddress{Email: sender.Address, Name: sender.Name},
+		Subject:          message.Subject,
+		// the plain text must come before the HTML
+		Content: []sendGridContent{{Type: "text/plain", Value: message.PlainBody}, {Type: "text/html", Value: message.HTMLBody}},
+	}
+
+	for _, a := range message.Attachments {
+		attachment := sendGridAttachment{Content: a.Data, Type: a.ContentType, Filename: a.Filename, Disposition: a.disposition()}
+		if a.Inline {
+			attachment.ContentID = a.Filename
+		}
+
+		body.Attachments = append(body.Attachments, attachment)
+	}
+
+	js, err := json.Marshal(body)
+	if err != nil {
+		return err
+	}
+
+	req, err := s.newRequest(ctx, http.MethodPost, "/v3/mail/send", bytes.NewReader(js))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	return do(s.client, "SendGrid", req)
+}
+
+// Ping lists the scopes of the API key, which fails if the key has been revoked
+func (s *SendGrid) Ping(ctx context.Context) error {
+	req, err := s.newRequest(ctx, http.MethodGet, "/v3/scopes", nil)
+	if err != nil {
+		return err
+	}
+
+	return do(s.client, "SendGrid", req)
+}
+
+func (s *SendGrid) newRequest(ctx context.Context, method, path string, body io.Reader) (*http.Request, error) {
+	req, err := http.NewRequestWithContext(ctx, method, sendGridBaseURL+path, body)
+	if err != nil {
+		return nil, err
+	}
+
+	req.Header.Set("Authorization", "Bearer "+s.apiKey)
+
+	return req, nil
+}
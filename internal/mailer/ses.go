@@ -0,0 +1,160 @@
+package mailer
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// SES sends the emails with the Amazon SES v2 API of a region. Requests are signed with AWS Signature Version 4
+type SES struct {
+	region    string
+	accessKey string
+	secretKey string
+	client    *http.Client
+}
+
+func NewSES(region, accessKey, secretKey string, timeout time.Duration) *SES {
+	if region == "" {
+		region = "us-east-1"
+	}
+
+	return &SES{region: region, accessKey: accessKey, secretKey: secretKey, client: &http.Client{Timeout: timeout}}
+}
+
+type sesContent struct {
+	Data    string `json:"Data"`
+	Charset string `json:"Charset"`
+}
+
+type sesSimple struct {
+	Subject sesContent `json:"Subject"`
+	Body    struct {
+		Text sesContent `json:"Text"`
+		Html sesContent `json:"Html"`
+	} `json:"Body"`
+}
+
+type sesRaw struct {
+	Data []byte `json:"Data"` // encoded as base64
+}
+
+type sesMessage struct {
+	FromEmailAddress string `json:"FromEmailAddress"`
+	Destination      struct {
+		ToAddresses []string `json:"ToAddresses"`
+	} `json:"Destination"`
+	Content struct {
+		Simple *sesSimple `json:"Simple,omitempty"`
+		Raw    *sesRaw    `json:"Raw,omitempty"`
+	} `json:"Content"`
+}
+
+func (s *SES) Send(ctx context.Context, from, to string, message *Message) error {
+	var body sesMessage
+	body.FromEmailAddress = from
+	body.Destination.ToAddresses = []string{to}
+
+	// an email with attachments is sent as the raw MIME message, which SES sends as it is
+	if len(message.Attachments) > 0 {
+		var raw bytes.Buffer
+
+		_, err := newMIMEMessage(from, to, message).WriteTo(&raw)
+		if err != nil {
+			return err
+		}
+
+		body.Content.Raw = &sesRaw{Data: raw.Bytes()}
+	} else {
+		simple := &sesSimple{Subject: sesContent{Data: message.Subject, Charset: "UTF-8"}}
+		simple.Body.Text = sesContent{Data: message.PlainBody, Charset: "UTF-8"}
+		simple.Body.Html = sesContent{Data: message.HTMLBody, Charset: "UTF-8"}
+
+		body.Content.Simple = simple
+	}
+
+	js, err := json.Marshal(body)
+	if err != nil {
+		return err
+	}
+
+	req, err := s.newRequest(ctx, http.MethodPost, "/v2/email/outbound-emails", js)
+	if err != nil {
+		return err
+	}
+
+	return do(s.client, "SES", req)
+}
+
+// Ping fetches the sending account, which fails if the credentials aren't accepted
+func (s *SES) Ping(ctx context.Context) error {
+	req, err := s.newRequest(ctx, http.MethodGet, "/v2/email/account", nil)
+	if err != nil {
+		return err
+	}
+
+	return do(s.client, "SES", req)
+}
+
+// newRequest builds a request to the API signed with the Authorization header. The body is hashed for the signature,
+// the emails are small enough to hold in memory
+func (s *SES) newRequest(ctx context.Context, method, path string, body []byte) (*http.Request, error) {
+	host := "email." + s.region + ".amazonaws.com"
+
+	req, err := http.NewRequestWithContext(ctx, method, "https://"+host+path, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now().UTC()
+	amzDate := now.Format("20060102T150405Z")
+	scope := now.Format("20060102") + "/" + s.region + "/ses/aws4_request"
+	payloadHash := sha256.Sum256(body)
+
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Amz-Date", amzDate)
+
+	signedHeaders := "content-type;host;x-amz-date"
+
+	canonicalRequest := strings.Join([]string{
+		method,
+		path,
+		"",
+		"content-type:application/json\n" + "host:" + host + "\n" + "x-amz-date:" + amzDate + "\n",
+		signedHeaders,
+		hex.EncodeToString(payloadHash[:]),
+	}, "\n")
+
+	signature := s.sign(now, amzDate, scope, canonicalRequest)
+
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s", s.accessKey, scope, signedHeaders, signature))
+
+	return req, nil
+}
+
+// sign returns the Signature Version 4 signature of the canonical request
+func (s *SES) sign(now time.Time, amzDate, scope, canonicalRequest string) string {
+	hash := sha256.Sum256([]byte(canonicalRequest))
+
+	stringToSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + hex.EncodeToString(hash[:])
+
+	key := hmacSHA256([]byte("AWS4"+s.secretKey), now.Format("20060102"))
+	key = hmacSHA256(key, s.region)
+	key = hmacSHA256(key, "ses")
+	key = hmacSHA256(key, "aws4_request")
+
+	return hex.EncodeToString(hmacSHA256(key, stringToSign))
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
@@ -0,0 +1,174 @@
+package mailer
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	netmail "net/mail"
+	"strconv"
+	"time"
+
+	"github.com/emersion/go-msgauth/dkim"
+	"github.com/go-mail/mail/v2"
+	"github.com/nytro04/greenlight/internal/breaker"
+	"github.com/nytro04/greenlight/internal/clock"
+)
+
+// SMTP sends the emails through a prioritized list of SMTP servers, failing over to the next server when one can't be
+// reached. Each server has a circuit breaker, so after it has failed threshold times in a row it is skipped for the
+// cooldown rather than every email waiting on its dial timeout, then tried again with a single email. The context
+// isn't used to cancel a send, the dialer's timeout bounds it instead
+type SMTP struct {
+	hosts []*smtpHost
+	dkim  *dkim.SignOptions
+}
+
+// smtpHost is one of the SMTP servers with the breaker tracking its health
+type smtpHost struct {
+	addr    string
+	dialer  *mail.Dialer
+	breaker *breaker.Breaker
+}
+
+// NewSMTP returns an SMTP sender for the hosts in priority order. A host may include a port, otherwise the port is
+// used. All of the servers are logged in to with the same credentials. The timeout bounds connecting to a server and
+// each read or write after that. When dkimOptions isn't nil every email is signed with them
+func NewSMTP(hosts []string, port int, username, password string, timeout time.Duration, threshold int, cooldown time.Duration, dkimOptions *dkim.SignOptions, clk clock.Clock) (*SMTP, error) {
+	s := &SMTP{dkim: dkimOptions}
+
+	for _, host := range hosts {
+		hostPort := port
+
+		if h, p, err := net.SplitHostPort(host); err == nil {
+			hostPort, err = strconv.Atoi(p)
+			if err != nil {
+				return nil, fmt.Errorf("invalid SMTP port in %q", host)
+			}
+			host = h
+		}
+
+		// initialize a new mail.Dialer instance with the provided SMTP serve settings. we
+		// also configure the dialer with the timeout when connecting to the SMTP server.
+		// This will prevent the application from hanging indefinitely if the SMTP server is not
+		// available or is slow to respond.
+		dialer := mail.NewDialer(host, hostPort, username, password)
+		dialer.Timeout = timeout
+
+		s.hosts = append(s.hosts, &smtpHost{
+			addr:    net.JoinHostPort(host, strconv.Itoa(hostPort)),
+			dialer:  dialer,
+			breaker: breaker.New(threshold, cooldown, clk),
+		})
+	}
+
+	return s, nil
+}
+
+// Send sends the email through the first server which can be reached. Only failing to connect or log in moves on to
+// the next server, an email the server refuses would be refused by the others as well, so its error is returned
+func (s *SMTP) Send(ctx context.Context, from, to string, message *Message) error {
+	msg := newMIMEMessage(from, to, message)
+
+	if s.dkim == nil {
+		conn, err := s.dial()
+		if err != nil {
+			return err
+		}
+		defer conn.Close()
+
+		return mail.Send(conn, msg)
+	}
+
+	// the signature covers the message as it is written out, so the signed bytes are sent as they are, with the
+	// envelope sender taken from the From header like mail.Send does
+	signed, err := signMessage(msg, s.dkim)
+	if err != nil {
+		return err
+	}
+
+	envelopeFrom, err := netmail.ParseAddress(from)
+	if err != nil {
+		return fmt.Errorf("invalid sender %q: %w", from, err)
+	}
+
+	conn, err := s.dial()
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	return conn.Send(envelopeFrom.Address, []string{to}, signed)
+}
+
+// Ping connects and logs in to the first server which can be reached, then closes the connection without sending
+// anything
+func (s *SMTP) Ping(ctx context.Context) error {
+	conn, err := s.dial()
+	if err != nil {
+		return err
+	}
+
+	return conn.Close()
+}
+
+// dial connects and logs in to the servers in priority order, skipping the ones whose breaker is open, and returns
+// the first connection made. The returned error holds the reason each server was passed over
+func (s *SMTP) dial() (mail.SendCloser, error) {
+	if len(s.hosts) == 0 {
+		return nil, errors.New("no SMTP host configured")
+	}
+
+	var errs []error
+
+	for _, host := range s.hosts {
+		err := host.breaker.Allow()
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", host.addr, err))
+			continue
+		}
+
+		// If there is a timeout, it will return a "dial tcp: i/o timeout" error. or the associated error if there is one.
+		conn, err := host.dialer.Dial()
+		if err != nil {
+			host.breaker.Failure()
+			errs = append(errs, fmt.Errorf("%s: %w", host.addr, err))
+			continue
+		}
+
+		host.breaker.Success()
+		return conn, nil
+	}
+
+	return nil, errors.Join(errs...)
+}
+
+// newMIMEMessage builds the MIME message of an email, for the providers which are handed the whole message
+func newMIMEMessage(from, to string, message *Message) *mail.Message {
+	// create a new mail.Message instance and set the recipient, sender, subject, and body of the email
+	// using the values we generated from the email template. We use the SetBody method to set the
+	// plain text body of the email, and the AddAlternative method to add an HTML alternative body. This
+	// allows email clients that support HTML to display the HTML version of the email, while clients that
+	// do not support HTML will display the plain text version. It's important to note that AddAlternative
+	// must be called after SetBody to ensure that the HTML version is correctly associated with the plain text version.
+	msg := mail.NewMessage()
+	msg.SetHeader("To", to)
+	msg.SetHeader("From", from)
+	msg.SetHeader("subject", message.Subject)
+	msg.SetBody("text/plain", message.PlainBody)
+	msg.AddAlternative("text/html", message.HTMLBody)
+
+	// an embedded file gets its name as its content ID, which is how the HTML body refers to it
+	for _, a := range message.Attachments {
+		header := mail.SetHeader(map[string][]string{"Content-Type": {a.ContentType}})
+
+		if a.Inline {
+			msg.EmbedReader(a.Filename, bytes.NewReader(a.Data), header)
+		} else {
+			msg.AttachReader(a.Filename, bytes.NewReader(a.Data), header)
+		}
+	}
+
+	return msg
+}
@@ -0,0 +1,120 @@
+package moviemeta
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ErrNotFound is returned by Lookup when the external service has no movie with the given ID
+var ErrNotFound = errors.New("movie not found")
+
+// DefaultTMDBBaseURL is the address of the TMDB API
+const DefaultTMDBBaseURL = "https://api.themoviedb.org"
+
+// Movie holds the metadata we import from an external service, in the form the movies table stores it
+type Movie struct {
+	Title    string
+	Year     int32
+	Runtime  int32
+	Genres   []string
+	Language string
+	Country  string
+}
+
+// TMDB is a client for The Movie Database API (version 3), authenticated with an API key
+type TMDB struct {
+	baseURL string
+	apiKey  string
+	client  *http.Client
+}
+
+// NewTMDB returns a TMDB client for the API at baseURL, which is DefaultTMDBBaseURL unless the requests go through a proxy
+func NewTMDB(baseURL, apiKey string) *TMDB {
+	return &TMDB{
+		baseURL: strings.TrimSuffix(baseURL, "/"),
+		apiKey:  apiKey,
+		client:  &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+// tmdbMovie is the part of the TMDB movie details response we use
+type tmdbMovie struct {
+	Title       string `json:"title"`
+	ReleaseDate string `json:"release_date"`
+	Runtime     int32  `json:"runtime"`
+	Genres      []struct {
+		Name string `json:"name"`
+	} `json:"genres"`
+	OriginalLanguage string   `json:"original_language"`
+	OriginCountry    []string `json:"origin_country"`
+}
+
+// Lookup fetches the metadata of the movie with the given TMDB ID. The genres are lower-cased to match the genres
+// the API stores, and the year is taken from the release date, which is empty for movies which haven't been released.
+// TMDB lists every country of origin of a co-production, the first one is taken as the movie's country
+func (t *TMDB) Lookup(ctx context.Context, id int64) (*Movie, error) {
+	u := fmt.Sprintf("%s/3/movie/%d?api_key=%s", t.baseURL, id, url.QueryEscape(t.apiKey))
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", "application/json")
+
+	// the request URL holds the API key, so it is left out of the error to keep the key out of the logs
+	resp, err := t.client.Do(req)
+	if err != nil {
+		var urlError *url.Error
+		if errors.As(err, &urlError) {
+			return nil, fmt.Errorf("TMDB: %s: %w", urlError.Op, urlError.Err)
+		}
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	switch {
+	case resp.StatusCode == http.StatusNotFound:
+		return nil, ErrNotFound
+	case resp.StatusCode < 200 || resp.StatusCode > 299:
+		return nil, fmt.Errorf("TMDB: unexpected status %d", resp.StatusCode)
+	}
+
+	var details tmdbMovie
+
+	err = json.NewDecoder(resp.Body).Decode(&details)
+	if err != nil {
+		return nil, fmt.Errorf("TMDB: decoding movie details: %w", err)
+	}
+
+	movie := &Movie{
+		Title:    details.Title,
+		Runtime:  details.Runtime,
+		Genres:   []string{},
+		Language: details.OriginalLanguage,
+	}
+
+	if len(details.OriginCountry) > 0 {
+		movie.Country = details.OriginCountry[0]
+	}
+
+	// release dates are in the YYYY-MM-DD format
+	if len(details.ReleaseDate) >= 4 {
+		year, err := strconv.ParseInt(details.ReleaseDate[:4], 10, 32)
+		if err == nil {
+			movie.Year = int32(year)
+		}
+	}
+
+	for _, genre := range details.Genres {
+		movie.Genres = append(movie.Genres, strings.ToLower(genre.Name))
+	}
+
+	return movie, nil
+}
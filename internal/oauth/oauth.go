@@ -0,0 +1,176 @@
+package oauth
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/oauth2"
+	"golang.org/x/oauth2/endpoints"
+)
+
+// ErrUnknownProvider is returned by New when the requested provider is not supported
+var ErrUnknownProvider = errors.New("unknown oauth provider")
+
+// The providers users can log in with
+const (
+	Google = "google"
+	GitHub = "github"
+)
+
+// Profile is the account of the user at the provider. Subject is the provider's ID of the account, which unlike the
+// email address never changes
+type Profile struct {
+	Subject       string
+	Email         string
+	EmailVerified bool
+	Name          string
+}
+
+// Provider is an OAuth2 provider users can log in with. It sends the user to the provider's consent page and exchanges
+// the code the provider redirects back with for the user's profile
+type Provider struct {
+	name    string
+	config  *oauth2.Config
+	profile func(ctx context.Context, client *http.Client) (*Profile, error)
+}
+
+// New returns the provider with the given name. The redirect URL must be registered with the provider as the callback
+// of the OAuth application the client ID belongs to
+func New(name, clientID, clientSecret, redirectURL string) (*Provider, error) {
+	p := &Provider{
+		name: name,
+		config: &oauth2.Config{
+			ClientID:     clientID,
+			ClientSecret: clientSecret,
+			RedirectURL:  redirectURL,
+		},
+	}
+
+	switch name {
+	case Google:
+		p.config.Endpoint = endpoints.Google
+		p.config.Scopes = []string{"openid", "email", "profile"}
+		p.profile = googleProfile
+	case GitHub:
+		p.config.Endpoint = endpoints.GitHub
+		p.config.Scopes = []string{"read:user", "user:email"}
+		p.profile = githubProfile
+	default:
+		return nil, ErrUnknownProvider
+	}
+
+	return p, nil
+}
+
+// Name returns the name of the provider, such as google
+func (p *Provider) Name() string {
+	return p.name
+}
+
+// AuthCodeURL returns the URL of the provider's consent page. The state is passed back to the callback, and the
+// verifier is the PKCE code verifier which has to be given to Exchange
+func (p *Provider) AuthCodeURL(state, verifier string) string {
+	return p.config.AuthCodeURL(state, oauth2.S256ChallengeOption(verifier))
+}
+
+// Exchange swaps the code the provider redirected back with for an access token, and uses it to fetch the user's profile
+func (p *Provider) Exchange(ctx context.Context, code, verifier string) (*Profile, error) {
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	token, err := p.config.Exchange(ctx, code, oauth2.VerifierOption(verifier))
+	if err != nil {
+		return nil, fmt.Errorf("%s: exchanging code: %w", p.name, err)
+	}
+
+	profile, err := p.profile(ctx, p.config.Client(ctx, token))
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", p.name, err)
+	}
+
+	return profile, nil
+}
+
+// getJSON fetches a JSON document with the authorized client and decodes it into dst
+func getJSON(ctx context.Context, client *http.Client, url string, dst any) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "application/json")
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("unexpected status %d from %s", resp.StatusCode, url)
+	}
+
+	return json.NewDecoder(resp.Body).Decode(dst)
+}
+
+// googleProfile reads the profile from Google's OpenID Connect userinfo endpoint
+func googleProfile(ctx context.Context, client *http.Client) (*Profile, error) {
+	var info struct {
+		Sub           string `json:"sub"`
+		Email         string `json:"email"`
+		EmailVerified bool   `json:"email_verified"`
+		Name          string `json:"name"`
+	}
+
+	err := getJSON(ctx, client, "https://openidconnect.googleapis.com/v1/userinfo", &info)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Profile{Subject: info.Sub, Email: info.Email, EmailVerified: info.EmailVerified, Name: info.Name}, nil
+}
+
+// githubProfile reads the profile from the GitHub API. The email address on the user isn't necessarily verified, or
+// even set when the user keeps it private, so the primary address is taken from the list of the user's addresses
+func githubProfile(ctx context.Context, client *http.Client) (*Profile, error) {
+	var user struct {
+		ID    int64  `json:"id"`
+		Login string `json:"login"`
+		Name  string `json:"name"`
+	}
+
+	err := getJSON(ctx, client, "https://api.github.com/user", &user)
+	if err != nil {
+		return nil, err
+	}
+
+	var emails []struct {
+		Email    string `json:"email"`
+		Primary  bool   `json:"primary"`
+		Verified bool   `json:"verified"`
+	}
+
+	err = getJSON(ctx, client, "https://api.github.com/user/emails", &emails)
+	if err != nil {
+		return nil, err
+	}
+
+	profile := &Profile{Subject: strconv.FormatInt(user.ID, 10), Name: strings.TrimSpace(user.Name)}
+	if profile.Name == "" {
+		profile.Name = user.Login
+	}
+
+	for _, email := range emails {
+		if email.Primary {
+			profile.Email = email.Email
+			profile.EmailVerified = email.Verified
+		}
+	}
+
+	return profile, nil
+}
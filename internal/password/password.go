@@ -0,0 +1,171 @@
+package password
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// ErrUnknownHash is returned by Matches for a hash which wasn't made by any of the supported algorithms
+var ErrUnknownHash = errors.New("password: unknown hash format")
+
+// The hashing algorithms which can be configured
+const (
+	AlgorithmBcrypt   = "bcrypt"
+	AlgorithmArgon2id = "argon2id"
+)
+
+// DefaultBcryptCost is the bcrypt cost the hashes were made with before it could be configured
+const DefaultBcryptCost = 12
+
+// The default argon2id parameters, the second recommended option of RFC 9106 with two lanes
+const (
+	DefaultArgon2Time    = 3
+	DefaultArgon2Memory  = 64 * 1024
+	DefaultArgon2Threads = 2
+)
+
+const (
+	argon2SaltLength = 16
+	argon2KeyLength  = 32
+)
+
+// Hasher hashes passwords and checks them against their hash. Any hasher can check a hash made by any of the supported
+// algorithms, so the algorithm or its parameters can be changed without locking out the existing users. NeedsRehash
+// reports whether a hash was made with a different algorithm or parameters than the hasher uses, in which case it should
+// be replaced with a new hash of the password the next time the user gives it
+type Hasher interface {
+	Hash(plaintext string) ([]byte, error)
+	Matches(plaintext string, hash []byte) (bool, error)
+	NeedsRehash(hash []byte) bool
+}
+
+// Bcrypt hashes passwords with bcrypt at the given cost
+type Bcrypt struct {
+	Cost int
+}
+
+// Hash returns the bcrypt hash of the password
+func (b Bcrypt) Hash(plaintext string) ([]byte, error) {
+	return bcrypt.GenerateFromPassword([]byte(plaintext), b.Cost)
+}
+
+// Matches checks the password against a hash made by any of the supported algorithms
+func (b Bcrypt) Matches(plaintext string, hash []byte) (bool, error) {
+	return matches(plaintext, hash)
+}
+
+// NeedsRehash reports whether the hash isn't a bcrypt hash of the configured cost
+func (b Bcrypt) NeedsRehash(hash []byte) bool {
+	cost, err := bcrypt.Cost(hash)
+	return err != nil || cost != b.Cost
+}
+
+// Argon2id hashes passwords with argon2id. Time is the number of passes over the memory, Memory is in KiB, and Threads
+// is the degree of parallelism
+type Argon2id struct {
+	Time    uint32
+	Memory  uint32
+	Threads uint8
+}
+
+// Hash returns the argon2id hash of the password with a random salt, encoded in the PHC string format used by the
+// reference implementation, such as $argon2id$v=19$m=65536,t=3,p=2$<salt>$<key>
+func (a Argon2id) Hash(plaintext string) ([]byte, error) {
+	salt := make([]byte, argon2SaltLength)
+
+	_, err := rand.Read(salt)
+	if err != nil {
+		return nil, err
+	}
+
+	key := argon2.IDKey([]byte(plaintext), salt, a.Time, a.Memory, a.Threads, argon2KeyLength)
+
+	return encodeArgon2id(a, salt, key), nil
+}
+
+// Matches checks the password against a hash made by any of the supported algorithms
+func (a Argon2id) Matches(plaintext string, hash []byte) (bool, error) {
+	return matches(plaintext, hash)
+}
+
+// NeedsRehash reports whether the hash isn't an argon2id hash made with the configured parameters
+func (a Argon2id) NeedsRehash(hash []byte) bool {
+	params, _, key, err := decodeArgon2id(hash)
+	return err != nil || params != a || len(key) != argon2KeyLength
+}
+
+// matches checks the password against the hash with the algorithm the hash was made with, which is told apart by its prefix
+func matches(plaintext string, hash []byte) (bool, error) {
+	switch {
+	case bytes.HasPrefix(hash, []byte("$argon2id$")):
+		params, salt, key, err := decodeArgon2id(hash)
+		if err != nil {
+			return false, err
+		}
+
+		other := argon2.IDKey([]byte(plaintext), salt, params.Time, params.Memory, params.Threads, uint32(len(key)))
+
+		return subtle.ConstantTimeCompare(key, other) == 1, nil
+	case bytes.HasPrefix(hash, []byte("$2")):
+		err := bcrypt.CompareHashAndPassword(hash, []byte(plaintext))
+		if err != nil {
+			switch {
+			case errors.Is(err, bcrypt.ErrMismatchedHashAndPassword):
+				return false, nil
+			default:
+				return false, err
+			}
+		}
+
+		return true, nil
+	default:
+		return false, ErrUnknownHash
+	}
+}
+
+// encodeArgon2id encodes the parameters, salt and key of an argon2id hash in the PHC string format
+func encodeArgon2id(params Argon2id, salt, key []byte) []byte {
+	return []byte(fmt.Sprintf("$argon2id$v=%d$m=%d,t=%d,p=%d$%s$%s", argon2.Version, params.Memory, params.Time, params.Threads,
+		base64.RawStdEncoding.EncodeToString(salt), base64.RawStdEncoding.EncodeToString(key)))
+}
+
+// decodeArgon2id decodes a hash made by encodeArgon2id, returning ErrUnknownHash if it isn't one
+func decodeArgon2id(hash []byte) (Argon2id, []byte, []byte, error) {
+	var version int
+	var params Argon2id
+
+	// the hash is split on the $ separators, since Sscanf would read the key into the salt
+	parts := bytes.Split(hash, []byte("$"))
+	if len(parts) != 6 {
+		return Argon2id{}, nil, nil, ErrUnknownHash
+	}
+
+	_, err := fmt.Sscanf(string(parts[2]), "v=%d", &version)
+	if err != nil || version != argon2.Version {
+		return Argon2id{}, nil, nil, ErrUnknownHash
+	}
+
+	_, err = fmt.Sscanf(string(parts[3]), "m=%d,t=%d,p=%d", &params.Memory, &params.Time, &params.Threads)
+	if err != nil {
+		return Argon2id{}, nil, nil, ErrUnknownHash
+	}
+
+	salt, err := base64.RawStdEncoding.DecodeString(string(parts[4]))
+	if err != nil {
+		return Argon2id{}, nil, nil, ErrUnknownHash
+	}
+
+	key, err := base64.RawStdEncoding.DecodeString(string(parts[5]))
+	if err != nil || len(key) == 0 {
+		return Argon2id{}, nil, nil, ErrUnknownHash
+	}
+
+	return params, salt, key, nil
+}
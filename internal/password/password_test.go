@@ -0,0 +1,58 @@
+package password
+
+import "testing"
+
+// TestHashers checks that each hasher accepts its own hashes and the other's, and asks for a rehash of any hash made with
+// another algorithm or other parameters
+func TestHashers(t *testing.T) {
+	// cheap parameters keep the test fast
+	bcryptHasher := Bcrypt{Cost: 4}
+	argon2Hasher := Argon2id{Time: 1, Memory: 64, Threads: 1}
+
+	hashers := map[string]Hasher{"bcrypt": bcryptHasher, "argon2id": argon2Hasher}
+
+	for name, hasher := range hashers {
+		hash, err := hasher.Hash("correct horse battery staple")
+		if err != nil {
+			t.Fatalf("%s: hashing: %v", name, err)
+		}
+
+		if hasher.NeedsRehash(hash) {
+			t.Errorf("%s: a fresh hash needs a rehash", name)
+		}
+
+		for otherName, other := range hashers {
+			match, err := other.Matches("correct horse battery staple", hash)
+			if err != nil || !match {
+				t.Errorf("%s hash checked by %s: got %v, %v, want a match", name, otherName, match, err)
+			}
+
+			match, err = other.Matches("wrong horse battery staple", hash)
+			if err != nil || match {
+				t.Errorf("%s hash checked by %s with the wrong password: got %v, %v, want no match", name, otherName, match, err)
+			}
+
+			if otherName != name && !other.NeedsRehash(hash) {
+				t.Errorf("%s hash doesn't need a rehash by %s", name, otherName)
+			}
+		}
+	}
+
+	hash, err := argon2Hasher.Hash("correct horse battery staple")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !(Argon2id{Time: 2, Memory: 64, Threads: 1}).NeedsRehash(hash) {
+		t.Error("argon2id hash with outdated parameters doesn't need a rehash")
+	}
+
+	if !(Bcrypt{Cost: 5}).NeedsRehash([]byte("$2a$04$abcdefghijklmnopqrstuuWnF3NTrOn1z1tUBQ0MY/WjGqkUzrdVC")) {
+		t.Error("bcrypt hash with an outdated cost doesn't need a rehash")
+	}
+
+	_, err = bcryptHasher.Matches("password", []byte("plaintext"))
+	if err != ErrUnknownHash {
+		t.Errorf("got %v for an unknown hash, want ErrUnknownHash", err)
+	}
+}
@@ -0,0 +1,32 @@
+package random
+
+import (
+	crand "crypto/rand"
+	"io"
+	mrand "math/rand"
+	"sync"
+)
+
+// New returns the source of random bytes used in production, which is the cryptographically secure crypto/rand reader
+func New() io.Reader {
+	return crand.Reader
+}
+
+// NewSeeded returns a deterministic source of random bytes. The same seed always produces the same sequence of tokens,
+// which makes golden-file tests and reproducible bug reports possible. It must never be used in production, since
+// anybody who knows the seed can predict every token the application generates
+func NewSeeded(seed int64) io.Reader {
+	return &seededReader{rnd: mrand.New(mrand.NewSource(seed))}
+}
+
+// seededReader wraps a math/rand generator with a mutex, since unlike crypto/rand it isn't safe for concurrent use
+type seededReader struct {
+	mu  sync.Mutex
+	rnd *mrand.Rand
+}
+
+func (r *seededReader) Read(p []byte) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.rnd.Read(p)
+}
@@ -0,0 +1,63 @@
+package siem
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// ErrUnknownForwarder is returned by New when the requested forwarder kind is not supported
+var ErrUnknownForwarder = errors.New("unknown SIEM forwarder")
+
+// Forwarder is implemented by the types which can ship a security event to an external SIEM. The event is passed in
+// already encoded as JSON, so the forwarders don't need to know anything about the shape of the events
+type Forwarder interface {
+	Forward(event []byte) error
+}
+
+// New returns the Forwarder for the given kind. The supported kinds are "none" (events are only stored in the database),
+// "syslog" (address is a host:port of a syslog server, reached over UDP) and "http" (address is a URL the events are POSTed to)
+func New(kind, address string) (Forwarder, error) {
+	switch kind {
+	case "", "none":
+		return NoopForwarder{}, nil
+	case "syslog":
+		return newSyslogForwarder(address)
+	case "http":
+		return HTTPForwarder{
+			url:    address,
+			client: &http.Client{Timeout: 5 * time.Second},
+		}, nil
+	default:
+		return nil, fmt.Errorf("%w: %q", ErrUnknownForwarder, kind)
+	}
+}
+
+// NoopForwarder discards all events, it is used when no SIEM is configured
+type NoopForwarder struct{}
+
+func (f NoopForwarder) Forward(event []byte) error {
+	return nil
+}
+
+// HTTPForwarder POSTs each event as a JSON body to the configured URL. Any non-2xx response is treated as an error
+type HTTPForwarder struct {
+	url    string
+	client *http.Client
+}
+
+func (f HTTPForwarder) Forward(event []byte) error {
+	resp, err := f.client.Post(f.url, "application/json", bytes.NewReader(event))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("SIEM forwarder: unexpected status %d", resp.StatusCode)
+	}
+
+	return nil
+}
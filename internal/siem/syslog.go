@@ -0,0 +1,22 @@
+//go:build !windows && !plan9
+
+package siem
+
+import "log/syslog"
+
+// SyslogForwarder writes each event as a single syslog message with the auth facility
+type SyslogForwarder struct {
+	writer *syslog.Writer
+}
+
+func newSyslogForwarder(address string) (Forwarder, error) {
+	writer, err := syslog.Dial("udp", address, syslog.LOG_AUTH|syslog.LOG_WARNING, "greenlight")
+	if err != nil {
+		return nil, err
+	}
+	return SyslogForwarder{writer: writer}, nil
+}
+
+func (f SyslogForwarder) Forward(event []byte) error {
+	return f.writer.Warning(string(event))
+}
@@ -0,0 +1,10 @@
+//go:build windows || plan9
+
+package siem
+
+import "errors"
+
+// newSyslogForwarder always fails on the platforms where the log/syslog package isn't available
+func newSyslogForwarder(address string) (Forwarder, error) {
+	return nil, errors.New("the syslog SIEM forwarder is not supported on this platform")
+}
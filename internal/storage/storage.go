@@ -0,0 +1,54 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+)
+
+// ErrNotFound is returned when the object with the given key doesn't exist
+var ErrNotFound = errors.New("object not found")
+
+// ErrUnknownBackend is returned by New when the requested backend is not supported
+var ErrUnknownBackend = errors.New("unknown storage backend")
+
+// Storage is implemented by the backends which can store uploaded files such as movie posters. Objects are addressed
+// by a key, which is a slash separated path like "posters/1-5f2b.jpg"
+type Storage interface {
+	Put(ctx context.Context, key string, body io.Reader, size int64, contentType string) error
+	Open(ctx context.Context, key string) (io.ReadCloser, error)
+	Delete(ctx context.Context, key string) error
+}
+
+// URLSigner is implemented by the backends which can hand out a temporary URL clients can download an object from directly,
+// so the API can redirect to it rather than streaming the object itself
+type URLSigner interface {
+	SignedURL(key string, ttl time.Duration) (string, error)
+}
+
+// Config holds the settings for all of the backends, only the ones for the selected backend are used
+type Config struct {
+	Backend string // local or s3
+
+	LocalDir string // directory the local backend writes the objects to
+
+	S3Endpoint  string // base URL of the S3-compatible service, e.g. https://s3.eu-west-1.amazonaws.com
+	S3Region    string
+	S3Bucket    string
+	S3AccessKey string
+	S3SecretKey string
+}
+
+// New returns the Storage for the backend in the config
+func New(cfg Config) (Storage, error) {
+	switch cfg.Backend {
+	case "", "local":
+		return NewLocal(cfg.LocalDir)
+	case "s3":
+		return NewS3(cfg.S3Endpoint, cfg.S3Region, cfg.S3Bucket, cfg.S3AccessKey, cfg.S3SecretKey)
+	default:
+		return nil, fmt.Errorf("%w: %q", ErrUnknownBackend, cfg.Backend)
+	}
+}
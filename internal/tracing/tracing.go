@@ -0,0 +1,49 @@
+// Package tracing sets up the OpenTelemetry SDK to export the spans recorded by the application to a collector over
+// OTLP/HTTP, and instruments the database driver
+package tracing
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+)
+
+// Config holds the settings of the tracer provider
+type Config struct {
+	Endpoint       string            // base URL of the OTLP/HTTP collector, such as http://localhost:4318
+	Headers        map[string]string // sent with every export, usually to authenticate with the collector
+	ServiceName    string
+	ServiceVersion string
+	SampleRatio    float64       // the fraction of new traces which are recorded, traces started upstream keep their decision
+	Timeout        time.Duration // how long the collector has to accept a batch
+	BatchInterval  time.Duration // how often the recorded spans are exported
+}
+
+// New returns a tracer provider which exports the spans in batches in the background. Shutdown must be called on it
+// before the application exits, or the spans recorded since the last export are lost
+func New(cfg Config) (*sdktrace.TracerProvider, error) {
+	exporter, err := otlptracehttp.New(context.Background(),
+		otlptracehttp.WithEndpointURL(strings.TrimSuffix(cfg.Endpoint, "/")+"/v1/traces"),
+		otlptracehttp.WithHeaders(cfg.Headers),
+		otlptracehttp.WithTimeout(cfg.Timeout),
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	res := resource.NewWithAttributes(semconv.SchemaURL,
+		semconv.ServiceName(cfg.ServiceName),
+		semconv.ServiceVersion(cfg.ServiceVersion),
+	)
+
+	return sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter, sdktrace.WithBatchTimeout(cfg.BatchInterval)),
+		sdktrace.WithResource(res),
+		sdktrace.WithSampler(sdktrace.ParentBased(sdktrace.TraceIDRatioBased(cfg.SampleRatio))),
+	), nil
+}
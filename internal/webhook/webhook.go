@@ -0,0 +1,88 @@
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// The headers sent with every delivery. The signature header has the form t=<unix timestamp>,v1=<hex signature>, so a
+// receiver can reject old deliveries being replayed as well as checking the payload hasn't been tampered with
+const (
+	EventHeader     = "X-Greenlight-Event"
+	DeliveryHeader  = "X-Greenlight-Delivery"
+	SignatureHeader = "X-Greenlight-Signature"
+)
+
+// Delivery is a single payload to be POSTed to a webhook URL
+type Delivery struct {
+	ID      int64
+	Event   string
+	URL     string
+	Secret  string
+	Payload []byte
+}
+
+// Sign returns the value of the signature header for the payload sent at the given time. The signature is the
+// HMAC-SHA256, keyed by the webhook secret, of the unix timestamp and the payload joined by a dot
+func Sign(secret string, timestamp time.Time, payload []byte) string {
+	t := strconv.FormatInt(timestamp.Unix(), 10)
+
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(t))
+	mac.Write([]byte("."))
+	mac.Write(payload)
+
+	return "t=" + t + ",v1=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// Sender POSTs the signed deliveries to the webhook URLs
+type Sender struct {
+	client *http.Client
+}
+
+// NewSender returns a Sender which gives up on a receiver which hasn't responded within the timeout
+func NewSender(timeout time.Duration) *Sender {
+	return &Sender{
+		client: &http.Client{
+			Timeout: timeout,
+			// a receiver is expected to respond to the URL it registered, redirects are treated as failures
+			CheckRedirect: func(req *http.Request, via []*http.Request) error {
+				return http.ErrUseLastResponse
+			},
+		},
+	}
+}
+
+// Send delivers the payload, signed at the given time. The status code of the response is returned whenever there is
+// one, and any non-2xx response is treated as an error so the delivery is retried
+func (s *Sender) Send(ctx context.Context, d Delivery, now time.Time) (int, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, d.URL, bytes.NewReader(d.Payload))
+	if err != nil {
+		return 0, err
+	}
+
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "Greenlight-Webhooks")
+	req.Header.Set(EventHeader, d.Event)
+	req.Header.Set(DeliveryHeader, strconv.FormatInt(d.ID, 10))
+	req.Header.Set(SignatureHeader, Sign(d.Secret, now, d.Payload))
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return resp.StatusCode, fmt.Errorf("webhook: unexpected status %d", resp.StatusCode)
+	}
+
+	return resp.StatusCode, nil
+}